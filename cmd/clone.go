@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+var (
+	cloneTo        string
+	cloneOverwrite bool
+	cloneVerify    bool
+)
+
+// cloneCmd 复制已有的远程备份到另一个远程路径
+var cloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "将远程备份复制到另一个远程路径",
+	Long: `将--remote-path下的整个备份（压缩包、校验和、索引、blob、历史快照和元数据）复制到--clone-to，
+不需要chunk目录也不重新打包。使用rclone后端且源和目标位于同一个支持服务端复制的远程时由服务端完成复制。
+复制完成后比对目标每个压缩包的大小和校验和文件；指定--verify时再下载目标的压缩包完整校验SHA256。`,
+	Example: `  # 复制到同一存储桶的另一个路径（服务端复制）
+  backuper clone --remote-path s3:bucket/pve-backups --clone-to s3:bucket/pve-backups-copy
+
+  # 复制到另一个远程并完整校验
+  backuper clone --remote-path s3:bucket/pve-backups --clone-to b2:offsite/pve-backups --verify`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := buildConfig("clone")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+		if cloneTo == "" {
+			return fmt.Errorf("配置无效: clone-to是必需的")
+		}
+
+		return runClone(config)
+	},
+}
+
+func init() {
+	cloneCmd.Flags().StringVar(&cloneTo, "clone-to", "", "目标远程路径")
+	cloneCmd.Flags().BoolVar(&cloneOverwrite, "overwrite", false, "目标已有备份时仍然复制并覆盖")
+	cloneCmd.Flags().BoolVar(&cloneVerify, "verify", false, "复制后下载目标的压缩包，完整校验SHA256")
+
+	rootCmd.AddCommand(cloneCmd)
+}
+
+// runClone 执行复制
+func runClone(config *models.Config) error {
+	// 复制期间源备份不应被同一主机上的备份修改
+	cloneLock, err := acquireLock(config)
+	if err != nil {
+		return err
+	}
+	defer cloneLock.Release()
+
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+
+	fmt.Printf("开始复制...\n")
+	fmt.Printf("源路径: %s\n", config.RemotePath)
+	fmt.Printf("目标路径: %s\n", cloneTo)
+
+	result, err := manager.RunClone(ctx, cloneTo, cloneOverwrite)
+	if err != nil {
+		logger.Error(fmt.Sprintf("复制失败: %v", err))
+		return fmt.Errorf("复制失败: %w", err)
+	}
+
+	fmt.Printf("\n=== 复制完成 ===\n")
+	fmt.Printf("耗时: %v\n", result.Duration)
+	fmt.Printf("复制对象数: %d\n", len(result.CopiedObjects))
+	fmt.Printf("校验通过压缩包数: %d\n", result.VerifiedArchives)
+	fmt.Printf("校验失败压缩包数: %d\n", len(result.Mismatches))
+	for _, mismatch := range result.Mismatches {
+		if mismatch.Error != "" {
+			fmt.Printf("  - %s: %s\n", mismatch.Archive, mismatch.Error)
+		} else {
+			fmt.Printf("  - %s: 预期 %s，实际 %s\n", mismatch.Archive, mismatch.Expected, mismatch.Actual)
+		}
+	}
+	if len(result.Mismatches) > 0 {
+		return fmt.Errorf("%d个压缩包复制后校验失败: %w", len(result.Mismatches), backup.ErrChecksumMismatch)
+	}
+
+	if cloneVerify {
+		dstConfig := *config
+		dstConfig.RemotePath = cloneTo
+		dstConfig.MetadataRemote = ""
+		verifyResult, err := backup.NewBackupManager(&dstConfig, store).RunVerify(ctx, backup.DefaultVerifyConcurrency, backup.MismatchReport)
+		if err != nil {
+			return fmt.Errorf("校验目标失败: %w", err)
+		}
+		fmt.Printf("完整校验压缩包数: %d，失败: %d\n", verifyResult.VerifiedArchives, len(verifyResult.Mismatches))
+		for _, mismatch := range verifyResult.Mismatches {
+			fmt.Printf("  - %s: %s%s\n", mismatch.Archive, mismatch.Actual, mismatch.Error)
+		}
+		if len(verifyResult.Mismatches) > 0 {
+			return fmt.Errorf("%d个压缩包完整校验失败: %w", len(verifyResult.Mismatches), backup.ErrChecksumMismatch)
+		}
+	}
+
+	fmt.Printf("\n复制的备份校验通过！\n")
+	return nil
+}
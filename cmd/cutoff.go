@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cutoffLayouts --newer-than支持的时间格式（不带时区的按本地时间解析）
+var cutoffLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseCutoff 解析截止时间，支持相对时长（如"24h"、"90m"，表示now之前）和时间戳
+func parseCutoff(value string, now time.Time) (time.Time, error) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return time.Time{}, fmt.Errorf("时间不能为空")
+	}
+
+	if duration, err := time.ParseDuration(trimmed); err == nil {
+		if duration <= 0 {
+			return time.Time{}, fmt.Errorf("时长必须大于0: %s", value)
+		}
+		return now.Add(-duration), nil
+	}
+
+	for _, layout := range cutoffLayouts {
+		if cutoff, err := time.ParseInLocation(layout, trimmed, time.Local); err == nil {
+			return cutoff, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("无效的时长或时间戳: %s", value)
+}
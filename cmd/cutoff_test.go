@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseCutoff 测试截止时间解析
+func TestParseCutoff(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+
+	testCases := []struct {
+		input    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"24h", now.Add(-24 * time.Hour), false},
+		{"90m", now.Add(-90 * time.Minute), false},
+		{"2024-03-14", time.Date(2024, 3, 14, 0, 0, 0, 0, time.Local), false},
+		{"2024-03-14 08:30:00", time.Date(2024, 3, 14, 8, 30, 0, 0, time.Local), false},
+		{"2024-03-14T08:30:00Z", time.Date(2024, 3, 14, 8, 30, 0, 0, time.UTC), false},
+		{"", time.Time{}, true},
+		{"-1h", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseCutoff(tc.input, now)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseCutoff(%q) 错误不符合预期: %v", tc.input, err)
+			continue
+		}
+		if !got.Equal(tc.expected) {
+			t.Errorf("parseCutoff(%q) = %v, 期望 %v", tc.input, got, tc.expected)
+		}
+	}
+}
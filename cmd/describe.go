@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+var (
+	describePath   string
+	describeFormat string
+)
+
+// describeCmd 输出远程备份摘要的命令
+var describeCmd = &cobra.Command{
+	Use:   "describe",
+	Short: "输出远程备份的摘要，或检查路径是否在备份中",
+	Long: `流式读取远程备份元数据（以及拆分保存的文件树），输出备份时间、压缩包数、目录数、文件数和总大小。
+不在内存中构建完整的文件树，同一时刻只保留一个chunk目录的节点，在很大的datastore上也很快且占用内存很少。
+指定--path时同时检查该路径（相对chunk目录的文件或目录）是否在备份中，不存在时以非零状态退出。
+只读取远程存储，不需要--chunk-path。`,
+	Example: `  # 查看最新备份的摘要
+  backuper describe --remote-path remote:backup
+
+  # 检查某个chunk是否已备份
+  backuper describe --remote-path remote:backup --path 0100/0100abcd... --format json`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if describeFormat != "table" && describeFormat != "json" {
+			return fmt.Errorf("配置无效: 输出格式必须是table或json，得到%s", describeFormat)
+		}
+		config, err := buildConfig("describe")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+		return runDescribe(config)
+	},
+}
+
+func init() {
+	describeCmd.Flags().StringVar(&describePath, "path", "", "检查该路径（相对chunk目录）是否在备份中")
+	describeCmd.Flags().StringVar(&describeFormat, "format", "table", "输出格式（table/json）")
+
+	rootCmd.AddCommand(describeCmd)
+}
+
+// runDescribe 读取并输出备份摘要
+func runDescribe(config *models.Config) error {
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	if err := useLatestRun(ctx, manager, config); err != nil {
+		return err
+	}
+
+	desc, err := manager.DescribeBackup(ctx, describePath)
+	if err != nil {
+		logger.Error(fmt.Sprintf("读取备份摘要失败: %v", err))
+		return fmt.Errorf("读取备份摘要失败: %w", err)
+	}
+
+	if describeFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(desc); err != nil {
+			return err
+		}
+	} else {
+		printDescription(config, desc)
+	}
+
+	if desc.Path != "" && !desc.PathFound {
+		return fmt.Errorf("路径 %s 不在备份中", desc.Path)
+	}
+	return nil
+}
+
+// printDescription 以文本形式输出备份摘要
+func printDescription(config *models.Config, desc *models.BackupDescription) {
+	fmt.Printf("远程路径: %s\n", config.RemotePath)
+	fmt.Printf("备份时间: %s\n", desc.BackupTime.Local().Format("2006-01-02 15:04:05"))
+	if desc.Namespace != "" {
+		fmt.Printf("命名空间: %s\n", desc.Namespace)
+	}
+	if desc.DatastoreID != "" {
+		fmt.Printf("datastore标识: %s\n", desc.DatastoreID)
+	}
+	fmt.Printf("压缩包数: %d\n", desc.Archives)
+	fmt.Printf("目录数: %d\n", desc.Directories)
+	fmt.Printf("文件数: %d\n", desc.Files)
+	fmt.Printf("总大小: %s\n", formatSize(desc.TotalSize))
+	if desc.Path != "" {
+		if desc.PathFound {
+			fmt.Printf("路径 %s: 存在\n", desc.Path)
+		} else {
+			fmt.Printf("路径 %s: 不存在\n", desc.Path)
+		}
+	}
+	fmt.Printf("耗时: %v\n", desc.Duration)
+}
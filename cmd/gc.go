@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+var (
+	gcRetainDaily   int
+	gcRetainWeekly  int
+	gcRetainMonthly int
+	gcDryRun        bool
+	gcConfirm       bool
+	gcRepair        bool
+	gcMinAge        time.Duration
+)
+
+// gcCmd 全面核对远程存储，清理孤立对象并修复缺失的压缩包和校验和文件
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "全面核对远程存储，清理孤立对象并修复缺失的文件",
+	Long: `列出远程chunk、sha256、index、blob和filetree目录中的全部对象，与当前元数据和历史快照的引用比对：
+没有被引用的对象（如中断的上传、已替换的压缩包留下的文件）删除；
+当前元数据引用但远程不存在的压缩包，指定--repair时用--chunk-path中的当前数据重新生成并上传；
+校验和文件缺失或与元数据不一致时按元数据和--checksum-style重新上传，汇总校验和文件（--checksum-files bundle）同样核对。
+同时指定--retain-daily、--retain-weekly或--retain-monthly时先按保留策略清理历史快照（同prune），再核对剩余的引用。
+只被历史快照引用且远程不存在的压缩包无法从当前数据恢复，只报告。
+默认只报告将执行的操作，指定--confirm后才实际修改远程存储。`,
+	Example: `  # 预览
+  backuper gc --remote-path remote:backup
+
+  # 删除孤立对象、修复缺失的压缩包，24小时内修改过的孤立对象不删除
+  backuper gc --remote-path remote:backup --chunk-path /path/to/.chunk --repair --min-age 24h --confirm
+
+  # 同时按保留策略清理历史快照
+  backuper gc --remote-path remote:backup --retain-daily 7 --retain-weekly 4 --confirm`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := buildConfig("gc")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+
+		if gcDryRun && gcConfirm {
+			return fmt.Errorf("配置无效: --dry-run与--confirm不能同时使用")
+		}
+		if gcMinAge < 0 {
+			return fmt.Errorf("配置无效: --min-age不能为负数")
+		}
+		if gcRepair && config.ChunkPath == "" {
+			return fmt.Errorf("配置无效: --repair需要--chunk-path")
+		}
+
+		opts := backup.GCOptions{MinAge: gcMinAge, Repair: gcRepair, DryRun: !gcConfirm}
+		if gcRetainDaily != 0 || gcRetainWeekly != 0 || gcRetainMonthly != 0 {
+			if gcRetainDaily < 0 || gcRetainWeekly < 0 || gcRetainMonthly < 0 {
+				return fmt.Errorf("配置无效: 保留数量不能为负数")
+			}
+			opts.Retention = &backup.RetentionPolicy{Daily: gcRetainDaily, Weekly: gcRetainWeekly, Monthly: gcRetainMonthly, MinAge: gcMinAge}
+		}
+
+		return runGC(config, opts)
+	},
+}
+
+func init() {
+	gcCmd.Flags().IntVar(&gcRetainDaily, "retain-daily", 0, "先清理历史快照，保留最近N天每天最新的快照")
+	gcCmd.Flags().IntVar(&gcRetainWeekly, "retain-weekly", 0, "先清理历史快照，保留最近N周每周最新的快照")
+	gcCmd.Flags().IntVar(&gcRetainMonthly, "retain-monthly", 0, "先清理历史快照，保留最近N月每月最新的快照")
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "只报告将执行的操作，不修改远程存储（未指定--confirm时的默认行为）")
+	gcCmd.Flags().BoolVar(&gcConfirm, "confirm", false, "实际删除、上传和改写文件，未指定时只预览")
+	gcCmd.Flags().BoolVar(&gcRepair, "repair", false, "用--chunk-path中的当前数据重新生成远程缺失的压缩包")
+	gcCmd.Flags().DurationVar(&gcMinAge, "min-age", 0, "删除保护期（如24h），保护期内修改过的孤立对象本次不删除")
+
+	rootCmd.AddCommand(gcCmd)
+}
+
+// runGC 执行远程存储整理
+func runGC(config *models.Config, opts backup.GCOptions) error {
+	// 整理会删除和上传远程文件，与备份共用本地锁
+	gcLock, err := acquireLock(config)
+	if err != nil {
+		return err
+	}
+	defer gcLock.Release()
+
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	if err := useLatestRun(ctx, manager, config); err != nil {
+		return err
+	}
+
+	fmt.Printf("开始整理...\n")
+	fmt.Printf("远程路径: %s\n", config.RemotePath)
+	if opts.Retention != nil {
+		fmt.Printf("保留策略: %d天 / %d周 / %d月\n", opts.Retention.Daily, opts.Retention.Weekly, opts.Retention.Monthly)
+	}
+	if opts.MinAge > 0 {
+		fmt.Printf("保护期: %v\n", opts.MinAge)
+	}
+
+	result, err := manager.RunGC(ctx, opts)
+	if err != nil {
+		logger.Error(fmt.Sprintf("整理失败: %v", err))
+		return fmt.Errorf("整理失败: %w", err)
+	}
+
+	if result.DryRun {
+		fmt.Printf("\n=== 整理预览（未修改远程存储，使用--confirm实际执行） ===\n")
+	} else {
+		fmt.Printf("\n=== 整理完成 ===\n")
+	}
+	fmt.Printf("耗时: %v\n", result.Duration)
+	if result.Prune != nil {
+		fmt.Printf("删除快照数: %d\n", len(result.Prune.PrunedSnapshots))
+		fmt.Printf("随快照删除的压缩包数: %d\n", len(result.Prune.DeletedArchives))
+	}
+	fmt.Printf("保留快照数: %d\n", result.Snapshots)
+	fmt.Printf("核对对象数: %d\n", result.ScannedObjects)
+	fmt.Printf("删除孤立对象数: %d\n", len(result.DeletedOrphans))
+	for _, name := range result.DeletedOrphans {
+		fmt.Printf("  - %s\n", name)
+	}
+	if len(result.ProtectedOrphans) > 0 {
+		fmt.Printf("保护期内未删除的孤立对象数: %d\n", len(result.ProtectedOrphans))
+	}
+	fmt.Printf("改写校验和文件数: %d\n", len(result.RewrittenChecksums))
+	for _, name := range result.RewrittenChecksums {
+		fmt.Printf("  - %s\n", name)
+	}
+
+	if len(result.MissingHistory) > 0 {
+		fmt.Printf("历史快照引用的缺失压缩包（无法修复）: %d\n", len(result.MissingHistory))
+		for _, name := range result.MissingHistory {
+			fmt.Printf("  - %s/%s\n", backup.ChunkDirName, name)
+		}
+	}
+	if len(result.MissingArchives) == 0 {
+		return nil
+	}
+	fmt.Printf("缺失的压缩包: %d（已修复 %d）\n", len(result.MissingArchives), len(result.RepairedArchives))
+	repaired := make(map[string]bool, len(result.RepairedArchives))
+	for _, name := range result.RepairedArchives {
+		repaired[name] = true
+	}
+	unrepaired := 0
+	for _, name := range result.MissingArchives {
+		if repaired[name] {
+			fmt.Printf("  - %s（已修复）\n", name)
+			continue
+		}
+		fmt.Printf("  - %s\n", name)
+		unrepaired++
+	}
+	if unrepaired > 0 && !result.DryRun {
+		return fmt.Errorf("%d个压缩包在远程缺失且未修复", unrepaired)
+	}
+	return nil
+}
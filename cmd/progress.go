@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// progressBarWidth 终端进度条的宽度（字符数）
+	progressBarWidth = 30
+	// progressInterval 刷新进度条的最小间隔，避免大量目录时频繁写终端
+	progressInterval = 100 * time.Millisecond
+)
+
+// scanProgress 返回在标准错误上显示chunk目录扫描进度的回调。
+// 指定--quiet或标准错误不是终端（重定向到文件、cron）时返回nil，不输出进度
+func scanProgress() func(done, total int) {
+	if quiet || !isTerminal(os.Stderr) {
+		return nil
+	}
+	return newProgressBar(os.Stderr, "扫描目录")
+}
+
+// isTerminal 判断文件是否是终端（字符设备）
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgressBar 返回把进度以单行进度条写入w的回调，每次刷新用\r覆盖上一次的输出，完成时换行
+func newProgressBar(w io.Writer, label string) func(done, total int) {
+	var last time.Time
+	return func(done, total int) {
+		if total <= 0 {
+			return
+		}
+		finished := done >= total
+		if !finished && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+
+		filled := done * progressBarWidth / total
+		bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+		fmt.Fprintf(w, "\r%s [%s] %d/%d (%d%%)", label, bar, done, total, done*100/total)
+		if finished {
+			fmt.Fprintln(w)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+var (
+	retainDaily   int
+	retainWeekly  int
+	retainMonthly int
+	pruneDryRun   bool
+	pruneConfirm  bool
+	pruneMinAge   time.Duration
+)
+
+// pruneCmd 按保留策略清理元数据历史快照
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "按保留策略清理元数据历史快照",
+	Long: `按祖父-父-子策略清理使用--keep-history保存的元数据历史快照：
+保留最近N天、N周、N月中每个周期内最新的一份快照，其余快照删除。
+只被删除的快照引用的压缩包和blob一并删除，当前元数据和保留的快照引用的不会删除。
+注意压缩包按名称原地更新，历史快照只记录当时的压缩包名称和校验和，不保存压缩包的旧内容。
+默认只列出将删除的文件，指定--confirm后才实际删除。`,
+	Example: `  # 保留7天、4周、6个月的快照，先预览
+  backuper prune --remote-path remote:backup --retain-daily 7 --retain-weekly 4 --retain-monthly 6
+
+  # 确认删除，7天内修改过的快照和对象不删除
+  backuper prune --remote-path remote:backup --retain-daily 7 --min-age 168h --confirm`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := buildConfig("prune")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+
+		if pruneDryRun && pruneConfirm {
+			return fmt.Errorf("配置无效: --dry-run与--confirm不能同时使用")
+		}
+		if pruneMinAge < 0 {
+			return fmt.Errorf("配置无效: --min-age不能为负数")
+		}
+
+		policy := backup.RetentionPolicy{Daily: retainDaily, Weekly: retainWeekly, Monthly: retainMonthly, MinAge: pruneMinAge}
+		if policy.Daily < 0 || policy.Weekly < 0 || policy.Monthly < 0 {
+			return fmt.Errorf("配置无效: 保留数量不能为负数")
+		}
+		if policy.Daily+policy.Weekly+policy.Monthly == 0 {
+			return fmt.Errorf("配置无效: 至少需要指定--retain-daily、--retain-weekly或--retain-monthly之一")
+		}
+
+		return runPrune(config, policy)
+	},
+}
+
+func init() {
+	pruneCmd.Flags().IntVar(&retainDaily, "retain-daily", 0, "保留最近N天每天最新的快照")
+	pruneCmd.Flags().IntVar(&retainWeekly, "retain-weekly", 0, "保留最近N周每周最新的快照")
+	pruneCmd.Flags().IntVar(&retainMonthly, "retain-monthly", 0, "保留最近N月每月最新的快照")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "只列出将删除的文件，不实际删除（未指定--confirm时的默认行为）")
+	pruneCmd.Flags().BoolVar(&pruneConfirm, "confirm", false, "实际删除文件，未指定时只预览")
+	pruneCmd.Flags().DurationVar(&pruneMinAge, "min-age", 0, "删除保护期（如168h），快照或其将删除的压缩包、blob在保护期内修改过时本次不清理")
+
+	rootCmd.AddCommand(pruneCmd)
+}
+
+// runPrune 执行清理
+func runPrune(config *models.Config, policy backup.RetentionPolicy) error {
+	// 清理会删除远程文件，与备份共用本地锁
+	pruneLock, err := acquireLock(config)
+	if err != nil {
+		return err
+	}
+	defer pruneLock.Release()
+
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	fmt.Printf("开始清理...\n")
+	fmt.Printf("远程路径: %s\n", config.RemotePath)
+	fmt.Printf("保留策略: %d天 / %d周 / %d月\n", policy.Daily, policy.Weekly, policy.Monthly)
+	if policy.MinAge > 0 {
+		fmt.Printf("保护期: %v\n", policy.MinAge)
+	}
+
+	result, err := manager.RunPrune(ctx, policy, !pruneConfirm)
+	if err != nil {
+		logger.Error(fmt.Sprintf("清理失败: %v", err))
+		return fmt.Errorf("清理失败: %w", err)
+	}
+
+	if result.DryRun {
+		fmt.Printf("\n=== 清理预览（未删除任何文件，使用--confirm实际删除） ===\n")
+	} else {
+		fmt.Printf("\n=== 清理完成 ===\n")
+	}
+	fmt.Printf("耗时: %v\n", result.Duration)
+	fmt.Printf("保留快照数: %d\n", len(result.RetainedSnapshots))
+	fmt.Printf("删除快照数: %d\n", len(result.PrunedSnapshots))
+	if len(result.ProtectedSnapshots) > 0 {
+		fmt.Printf("保护期内推迟清理的快照数: %d\n", len(result.ProtectedSnapshots))
+	}
+	fmt.Printf("删除压缩包数: %d\n", len(result.DeletedArchives))
+	fmt.Printf("删除blob数: %d\n", len(result.DeletedBlobs))
+
+	for _, name := range result.PrunedSnapshots {
+		fmt.Printf("  - %s\n", name)
+	}
+	for _, name := range result.DeletedArchives {
+		fmt.Printf("  - %s/%s\n", backup.ChunkDirName, name)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// regroupCmd 按新的前缀位数重新分组已有的备份
+var regroupCmd = &cobra.Command{
+	Use:   "regroup",
+	Short: "按新的前缀位数重新分组已有的备份",
+	Long: `将已有备份的压缩包按--prefix-digits重新拆分或合并，不需要chunk目录，也不重新读取源数据。
+目录集合不变的压缩包保持不动，只是名称改变的压缩包在远程复制改名，其余压缩包从旧压缩包下载解压后重新打包。
+新的元数据上传成功后才删除旧压缩包，中途失败时原备份保持可用。
+备份缺少压缩包时需要先执行incremental --repair。`,
+	Example: `  # 将2位前缀（256个压缩包）拆分为3位前缀（4096个压缩包）
+  backuper regroup --remote-path remote:backup --prefix-digits 3`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := buildConfig("regroup")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+
+		return runRegroup(config)
+	},
+}
+
+func init() {
+	regroupCmd.Flags().IntVar(&prefixDigits, "prefix-digits", 0, "新的分组前缀位数（1到--hex-digits）")
+	regroupCmd.MarkFlagRequired("prefix-digits")
+
+	rootCmd.AddCommand(regroupCmd)
+}
+
+// runRegroup 执行重新分组
+func runRegroup(config *models.Config) error {
+	// 重新分组会替换远程压缩包，与备份共用本地锁
+	regroupLock, err := acquireLock(config)
+	if err != nil {
+		return err
+	}
+	defer regroupLock.Release()
+
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+
+	fmt.Printf("开始重新分组...\n")
+	fmt.Printf("远程路径: %s\n", config.RemotePath)
+
+	result, err := manager.RunRegroup(ctx, config.PrefixDigits)
+	if err != nil {
+		logger.Error(fmt.Sprintf("重新分组失败: %v", err))
+		return fmt.Errorf("重新分组失败: %w", err)
+	}
+
+	fmt.Printf("\n=== 重新分组完成 ===\n")
+	fmt.Printf("前缀位数: %d -> %d\n", result.OldPrefixDigits, result.NewPrefixDigits)
+	fmt.Printf("耗时: %v\n", result.Duration)
+	fmt.Printf("未变化压缩包数: %d\n", len(result.Unchanged))
+	fmt.Printf("远程复制压缩包数: %d\n", len(result.Copied))
+	fmt.Printf("重新打包压缩包数: %d\n", len(result.Rebuilt))
+	fmt.Printf("删除旧压缩包数: %d\n", len(result.Deleted))
+
+	return nil
+}
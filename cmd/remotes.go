@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/storage"
+)
+
+// listRemotesTimeout 列出远程和目录的超时时间
+const listRemotesTimeout = time.Minute
+
+// listRemotesCmd 列出rclone配置的远程，帮助确定--remote-path
+var listRemotesCmd = &cobra.Command{
+	Use:   "list-remotes [远程]",
+	Short: "列出rclone配置的远程及其顶层目录",
+	Long: `执行rclone listremotes列出配置文件中的远程；指定远程（如s3:或s3:bucket）时列出该路径下的目录。
+用于确定--remote-path，不需要记住rclone的命令。只适用于rclone后端，其他后端直接跳过。`,
+	Example: `  # 列出所有远程
+  backuper list-remotes
+
+  # 列出远程s3:下的目录
+  backuper list-remotes s3:`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if backend != "rclone" {
+			fmt.Printf("list-remotes只适用于rclone后端，当前后端为%s，跳过\n", backend)
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), listRemotesTimeout)
+		defer cancel()
+
+		rclone := storage.NewRcloneStorage(rcloneBinary, rcloneConfig, rcloneArgs, false, false)
+		if len(args) == 1 {
+			return printRemoteDirs(ctx, rclone, args[0])
+		}
+		return printRemotes(ctx, rclone)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listRemotesCmd)
+}
+
+// printRemotes 打印配置的远程
+func printRemotes(ctx context.Context, rclone *storage.RcloneStorage) error {
+	remotes, err := rclone.ListRemotes(ctx)
+	if err != nil {
+		return fmt.Errorf("列出远程失败: %w", err)
+	}
+	if len(remotes) == 0 {
+		fmt.Printf("rclone没有配置远程，请先执行 rclone config\n")
+		return nil
+	}
+
+	fmt.Printf("可用的远程:\n")
+	for _, remote := range remotes {
+		fmt.Printf("  %s\n", remote)
+	}
+	fmt.Printf("\n执行 list-remotes <远程> 查看远程下的目录\n")
+	return nil
+}
+
+// printRemoteDirs 打印远程路径下的目录，以及可以直接使用的--remote-path
+func printRemoteDirs(ctx context.Context, rclone *storage.RcloneStorage, remote string) error {
+	dirs, err := rclone.ListDirs(ctx, remote)
+	if err != nil {
+		return fmt.Errorf("列出目录失败: %w", err)
+	}
+	if len(dirs) == 0 {
+		fmt.Printf("%s 下没有目录\n", remote)
+		return nil
+	}
+
+	fmt.Printf("%s 下的目录:\n", remote)
+	for _, dir := range dirs {
+		fmt.Printf("  --remote-path %s\n", joinRemotePath(remote, dir))
+	}
+	return nil
+}
+
+// joinRemotePath 拼接rclone远程路径，远程名称的冒号之后不加分隔符（s3: + dir = s3:dir）
+func joinRemotePath(remote, dir string) string {
+	if strings.HasSuffix(remote, ":") || strings.HasSuffix(remote, "/") {
+		return remote + dir
+	}
+	return remote + "/" + dir
+}
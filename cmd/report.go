@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pbs-backuper/internal/models"
+)
+
+// newBackupReport 根据备份结果和错误生成运行报告，部分失败时同时包含结果和错误信息
+func newBackupReport(config *models.Config, startTime time.Time, result *models.BackupResult, err error) *models.BackupReport {
+	host, _ := os.Hostname()
+	report := &models.BackupReport{
+		RunID:     config.RunID,
+		Host:      host,
+		Mode:      config.Mode,
+		Success:   err == nil,
+		StartTime: startTime,
+		EndTime:   time.Now(),
+		Config:    config,
+		Result:    result,
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// writeReport 写入运行报告。路径以.jsonl结尾时作为历史记录追加一行；
+// 否则先写入同目录的临时文件再重命名，监控程序不会读到写了一半的报告
+func writeReport(path string, report *models.BackupReport) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建报告目录失败: %w", err)
+	}
+
+	if strings.HasSuffix(path, ".jsonl") {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("序列化报告失败: %w", err)
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		// 一次写入整行，多个进程同时追加时行不会交错
+		if _, err := file.Write(append(data, '\n')); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化报告失败: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
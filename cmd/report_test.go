@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/models"
+)
+
+// TestWriteReport 测试报告原子写入，以及.jsonl路径追加历史记录
+func TestWriteReport(t *testing.T) {
+	dir := t.TempDir()
+	config := &models.Config{RunID: "run-1", Mode: "incremental", SFTPPassword: "secret"}
+	result := &models.BackupResult{RunID: "run-1", ErrorArchives: []string{"0000-00ff.tar.gz"}}
+	partial := &backup.PartialFailureError{Failed: result.ErrorArchives}
+
+	path := filepath.Join(dir, "reports", "report.json")
+	for i := 0; i < 2; i++ {
+		if err := writeReport(path, newBackupReport(config, time.Now(), result, partial)); err != nil {
+			t.Fatalf("写入报告失败: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取报告失败: %v", err)
+	}
+	var report models.BackupReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("解析报告失败: %v", err)
+	}
+	if report.Success || report.Error == "" || report.Result == nil || len(report.Result.ErrorArchives) != 1 || report.Config.RunID != "run-1" {
+		t.Errorf("部分失败的报告不正确: %+v", report)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("报告不应包含密码")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("不应残留临时文件: %d 个文件", len(entries))
+	}
+
+	history := filepath.Join(dir, "history.jsonl")
+	for _, err := range []error{nil, errors.New("备份失败")} {
+		if err := writeReport(history, newBackupReport(config, time.Now(), nil, err)); err != nil {
+			t.Fatalf("追加报告失败: %v", err)
+		}
+	}
+	data, err = os.ReadFile(history)
+	if err != nil {
+		t.Fatalf("读取历史失败: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("应追加2行，实际 %d 行", len(lines))
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &report); err != nil || report.Success || report.Error != "备份失败" {
+		t.Errorf("第2行报告不正确: %+v, %v", report, err)
+	}
+}
+
+// TestSummarizeReports 测试汇总报告：跳过无法解析的行，按时间和主机筛选
+func TestSummarizeReports(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	report := func(host string, day int, success bool, uploaded int64) *models.BackupReport {
+		start := base.AddDate(0, 0, day)
+		r := &models.BackupReport{
+			RunID:     fmt.Sprintf("%s-%d", host, day),
+			Host:      host,
+			Success:   success,
+			StartTime: start,
+			EndTime:   start.Add(10 * time.Minute),
+			Result:    &models.BackupResult{UploadedBytes: uploaded},
+		}
+		if !success {
+			r.Error = "部分压缩包失败"
+			r.Result.ErrorArchives = []string{"0000-00ff.tar.gz"}
+		}
+		return r
+	}
+
+	history := filepath.Join(dir, "host1.jsonl")
+	for _, r := range []*models.BackupReport{report("host1", 0, true, 100), report("host1", 1, false, 50), report("host1", 2, true, 200)} {
+		if err := writeReport(history, r); err != nil {
+			t.Fatalf("写入报告失败: %v", err)
+		}
+	}
+	// 模拟写了一半的最后一行
+	file, err := os.OpenFile(history, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"run_id":"trunc`)
+	file.Close()
+	single := filepath.Join(dir, "host2.json")
+	if err := writeReport(single, report("host2", 1, true, 1000)); err != nil {
+		t.Fatalf("写入报告失败: %v", err)
+	}
+
+	var reports []*models.BackupReport
+	invalid := 0
+	for _, path := range []string{history, single} {
+		fileReports, skipped, err := readReportFile(path)
+		if err != nil {
+			t.Fatalf("读取报告失败: %v", err)
+		}
+		reports = append(reports, fileReports...)
+		invalid += skipped
+	}
+	if len(reports) != 4 || invalid != 1 {
+		t.Fatalf("应读取4个报告并跳过1行，实际 %d 个，跳过 %d 行", len(reports), invalid)
+	}
+
+	summary := summarizeReports(reports, reportFilter{})
+	if summary.Runs != 4 || summary.Succeeded != 3 || summary.UploadedBytes != 1350 || summary.AverageDuration != 10*time.Minute {
+		t.Errorf("汇总不正确: %+v", summary)
+	}
+	if len(summary.Hosts) != 2 || summary.Hosts[0].Host != "host1" || summary.Hosts[0].Runs != 3 || !summary.Hosts[0].LastSuccess.Equal(base.AddDate(0, 0, 2)) {
+		t.Errorf("主机统计不正确: %+v", summary.Hosts)
+	}
+	if len(summary.Failures) != 1 || summary.Failures[0].RunID != "host1-1" || len(summary.Failures[0].FailedArchives) != 1 {
+		t.Errorf("失败记录不正确: %+v", summary.Failures)
+	}
+
+	filter := reportFilter{since: base.AddDate(0, 0, 1), until: base.AddDate(0, 0, 2), hosts: []string{"host1"}}
+	summary = summarizeReports(reports, filter)
+	if summary.Runs != 1 || summary.Succeeded != 0 || summary.SuccessRate != 0 {
+		t.Errorf("筛选后应只剩host1第2天的失败运行: %+v", summary)
+	}
+}
@@ -2,32 +2,163 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"pbs-backuper/internal/archiver"
 	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/lock"
 	"pbs-backuper/internal/logger"
 	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
 	"pbs-backuper/internal/storage"
 )
 
 var (
-	chunkPath    string
-	remotePath   string
-	tempPath     string
-	rcloneBinary string
-	rcloneConfig string
-	rcloneArgs   []string
-	prefixDigits int
-	verbose      bool
-	timeout      time.Duration
-	logPath      string
+	chunkPath     string
+	remotePath    string
+	metadataPath  string
+	tempPaths     []string
+	backend       string
+	rcloneBinary  string
+	rcloneConfig  string
+	rcloneArgs    []string
+	prefixDigits  int
+	dirBatchSize  int
+	verbose       bool
+	quiet         bool
+	verboseRclone bool
+	timeout       time.Duration
+	maxRuntime    time.Duration
+	logPath       string
+	tarIndex      bool
+	restoreFile   string
+	preserveEmpty bool
+	smartCompress bool
+	parallelGzip  bool
+	preallocate   bool
+	fsyncMetadata bool
+	compression   string
+	checksumStyle string
+	checksumFiles string
+	paranoid      bool
+	signKey       string
+	gpgBinary     string
+	minThroughput string
+	maxDirSize    string
+	maxObjectSize string
+	minArchive    string
+	smartFull     bool
+	metaPairs     []string
+	runSubdir     bool
+	usePrevMeta   bool
+	readBuffer    string
+	hashBuffer    string
+	throttleAfter int
+	throttleBytes string
+	throttleSleep time.Duration
+	readRateLimit string
+	catMaxSize    string
+	transfers     int
+	checkers      int
+	includePrefix []string
+	excludeFiles  []string
+	excludeFrom   string
+	remoteExclude bool
+	continueScan  bool
+	maxScanDepth  int
+	allowEmpty    bool
+	compareMode   string
+	treeHash      string
+	trackInodes   bool
+	growthReport  int
+	decisionLimit int
+	autoFull      bool
+	fullThreshold float64
+	datastoreID   string
+	namespace     string
+	namespaceDir  bool
+	force         bool
+	repair        bool
+	verifyRemote  bool
+	listChanged   bool
+	newerThan     string
+	excludeOlder  string
+	dedupe        bool
+	keepHistory   bool
+	archiveSuffix bool
+	emitManifest  bool
+	splitTree     bool
+	logRunContext bool
+	hexDigits     int
+	looseHex      bool
+	reportFile    string
+	statusFile    bool
+	statusRemote  bool
+	snapshotHook  string
+	snapshotClean string
+	pbsVerify     bool
+	pbsSample     float64
+	pbsAbort      bool
+
+	sftpHost                  string
+	sftpPort                  int
+	sftpUser                  string
+	sftpKeyFile               string
+	sftpPassword              string
+	sftpKnownHosts            string
+	sftpInsecureIgnoreHostKey bool
+)
+
+// localCommands 只读取本地数据、报告文件或rclone配置的顶层命令（包括cobra自带的help和completion），
+// 不要求--remote-path
+var localCommands = map[string]bool{
+	"scan":         true,
+	"summary":      true,
+	"version":      true,
+	"list-remotes": true,
+	"help":         true,
+	"completion":   true,
+}
+
+// lockFileName 本地锁文件名，位于临时目录下
+const lockFileName = "backuper.lock"
+
+// defaultGrowthReport 启用--verbose且未指定--growth-report时报告的目录数
+const defaultGrowthReport = 10
+
+// defaultTransfers、defaultCheckers 传输类rclone命令默认的并发传输数和检查数，
+// 高于rclone自身的默认值（4和8），对对象存储等高延迟后端能明显提高吞吐
+const (
+	defaultTransfers = 8
+	defaultCheckers  = 16
 )
 
+// defaultCatMaxSize 默认的远程小文件读取上限，远大于任何校验和文件
+const defaultCatMaxSize = "16MB"
+
+// maxHashBufferSize --hash-buffer-size的上限，每个并行计算校验和的压缩包各分配一个缓冲区
+const maxHashBufferSize = 256 << 20
+
+// minReadRateLimit --source-read-rate-limit的下限，更低的速率下单个chunk（最大4MB）就要读取一分钟以上
+const minReadRateLimit = 64 << 10
+
+// sftpPasswordEnv 未指定--sftp-password时读取的环境变量，避免密码出现在进程列表中
+const sftpPasswordEnv = "PBS_BACKUPER_SFTP_PASSWORD"
+
 // rootCmd 根命令
 var rootCmd = &cobra.Command{
 	Use:   "backuper",
@@ -75,69 +206,510 @@ var incrementalCmd = &cobra.Command{
 	Short: "执行增量备份",
 	Long: `基于之前的备份元数据执行增量备份。
 仅为变化的目录创建和上传压缩包。
-要求远程存储中存在之前的备份元数据。`,
+要求远程存储中存在之前的备份元数据，使用--auto-full时缺失元数据会自动执行全量备份。
+使用--verify-remote时不读取上次的文件树，打包全部分组后只上传SHA256与远程校验和文件不同的压缩包；
+与--list-changed一起使用时只检查未变化的压缩包在远程是否存在、校验和文件是否与元数据一致。`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		config, err := buildConfig("incremental")
 		if err != nil {
 			return fmt.Errorf("配置无效: %w", err)
 		}
 
+		if listChanged {
+			return runListChanged(config)
+		}
+		return runBackup(config)
+	},
+}
+
+// autoCmd 自动选择备份类型的命令
+var autoCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "根据变化量自动选择全量或增量备份",
+	Long: `加载远程备份元数据并扫描当前文件树，计算变化目录占全部目录的比例。
+远程没有元数据或变化比例超过--full-threshold时执行全量备份，否则执行增量备份。
+已有备份时沿用元数据中的前缀位数，--prefix-digits只用于首次全量备份。`,
+	Example: `  # 变化超过一半的目录时执行全量备份
+  backuper backup --chunk-path /path/to/.chunk --remote-path remote:backup --full-threshold 0.5`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := buildConfig("auto")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+
 		return runBackup(config)
 	},
 }
 
+// restoreCmd 恢复命令
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "从远程存储恢复chunk数据",
+	Long: `从远程存储下载压缩包并解压到--chunk-path指定的目录。
+默认恢复全部压缩包；使用--file只恢复单个文件或目录，
+如果备份时启用了--tar-index，将通过索引直接定位条目而无需扫描整个压缩包。`,
+	Example: `  # 恢复全部chunk数据
+  backuper restore --chunk-path /path/to/.chunk --remote-path remote:backup
+
+  # 恢复单个chunk文件
+  backuper restore --chunk-path /path/to/.chunk --remote-path remote:backup --file 0012/0012abcd...`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := buildConfig("restore")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+
+		return runRestore(config)
+	},
+}
+
 func init() {
 	// 添加全局标志
 	rootCmd.PersistentFlags().StringVar(&chunkPath, "chunk-path", "", ".chunk目录路径（必需）")
 	rootCmd.PersistentFlags().StringVar(&remotePath, "remote-path", "", "远程存储路径（必需）")
-	rootCmd.PersistentFlags().StringVar(&tempPath, "temp-path", "/tmp/backuper", "临时文件路径")
+	rootCmd.PersistentFlags().BoolVar(&runSubdir, "remote-subdir-per-run", false, "每次备份写入远程路径下以开始时间命名的子目录（如2024-01-01T12-00），并更新顶层的latest指针；增量备份、恢复和校验读取latest指向的子目录")
+	rootCmd.PersistentFlags().BoolVar(&usePrevMeta, "use-prev-metadata", false, "主元数据损坏（校验和不一致或无法解析）时回退到上次覆盖前保存的backup-metadata.json.prev")
+	rootCmd.PersistentFlags().StringVar(&metadataPath, "metadata-remote-path", "", "备份元数据（backup-metadata.json及其校验和文件）的远程目录，未设置时与压缩包一起保存在--remote-path")
+	rootCmd.PersistentFlags().StringSliceVar(&tempPaths, "temp-path", []string{"/tmp/backuper"}, "临时文件路径；逗号分隔多个目录时压缩包轮流存放在各目录中，锁文件和元数据使用第一个目录")
+	rootCmd.PersistentFlags().StringVar(&backend, "backend", "rclone", fmt.Sprintf("存储后端（可选: %s）", strings.Join(storage.Backends(), ", ")))
 	rootCmd.PersistentFlags().StringVar(&rcloneBinary, "rclone-binary", "rclone", "rclone二进制文件路径")
 	rootCmd.PersistentFlags().StringVar(&rcloneConfig, "rclone-config", "", "rclone配置文件路径")
 	rootCmd.PersistentFlags().StringSliceVar(&rcloneArgs, "rclone-args", []string{}, "额外的rclone参数（逗号分隔）")
+	rootCmd.PersistentFlags().IntVar(&transfers, "transfers", defaultTransfers, "rclone传输类命令（copy/copyto/moveto等）的并发传输数，0表示使用rclone默认值；--rclone-args中指定了--transfers时以其为准")
+	rootCmd.PersistentFlags().IntVar(&checkers, "checkers", defaultCheckers, "rclone传输类命令的并发检查数，0表示使用rclone默认值；--rclone-args中指定了--checkers时以其为准")
+	rootCmd.PersistentFlags().StringVar(&catMaxSize, "cat-max-size", defaultCatMaxSize, "直接读入内存的远程小文件（校验和文件）的大小上限，超过时报错而不是整个读入；0表示不限制")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "启用详细输出")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "不显示终端进度条（扫描chunk目录的进度）；标准错误不是终端时自动不显示")
+	rootCmd.PersistentFlags().StringArrayVar(&metaPairs, "meta", nil, "记录到备份元数据中的自定义字段（key=value，可重复指定），如工单号、环境、操作人，不影响备份逻辑")
+	rootCmd.PersistentFlags().BoolVar(&logRunContext, "log-run-context", false, "每行日志附加主机名和本次运行标识（run_id），便于关联多台主机或多个定时任务的日志与远程元数据")
+	rootCmd.PersistentFlags().BoolVar(&verboseRclone, "verbose-rclone", false, "启用rclone自身的详细输出（-v及实时输出）")
 	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 30*time.Minute, "操作超时时间")
+	rootCmd.PersistentFlags().DurationVar(&maxRuntime, "max-runtime", 0, "备份的最长运行时间（如4h），用完后不再开始新的压缩包组，完成正在处理的分组并上传元数据后结束，剩余分组由下次增量备份继续；必须小于--timeout，0表示不限制")
+	rootCmd.PersistentFlags().StringVar(&maxDirSize, "max-dir-size", "", "排除超过该大小的chunk目录（如50GB），被排除的目录会在结果中列出")
+	rootCmd.PersistentFlags().StringVar(&maxObjectSize, "max-object-size", "", "单个远程对象的大小上限（如5GB），覆盖按rclone远程类型识别的上限；全量备份预计压缩包超过上限时自动按目录数切分；off或0表示不检查")
+	rootCmd.PersistentFlags().StringSliceVar(&includePrefix, "include-prefix", []string{}, "只备份以这些十六进制前缀开头的chunk目录（逗号分隔）")
+	rootCmd.PersistentFlags().StringSliceVar(&excludeFiles, "exclude-file-pattern", scanner.DefaultExcludePatterns, "不备份文件名匹配这些模式的文件（逗号分隔，如*.tmp），默认排除PBS的临时文件和损坏的chunk；传入空字符串不排除任何文件")
+	rootCmd.PersistentFlags().StringVar(&excludeFrom, "exclude-from", "", "从文件读取排除模式（每行一个，#开头为注释，以/结尾的模式排除匹配的子目录），与--exclude-file-pattern合并")
+	rootCmd.PersistentFlags().BoolVar(&allowEmpty, "allow-empty", false, "chunk路径下没有任何chunk目录时只警告并继续备份（默认报错，这通常说明--chunk-path指错了目录）")
+	rootCmd.PersistentFlags().IntVar(&maxScanDepth, "max-scan-depth", scanner.DefaultMaxDepth, "chunk目录下允许的最大子目录嵌套深度，超过时中止扫描（防止误挂载的深层目录或挂载循环）")
+	rootCmd.PersistentFlags().BoolVar(&continueScan, "continue-on-scan-error", false, "扫描chunk目录遇到网络挂载的暂时性错误（如EIO、ESTALE、超时）时重试，多次失败后跳过该目录并在结果中列出，而不是中止备份；路径不存在等错误仍然中止")
+	rootCmd.PersistentFlags().BoolVar(&remoteExclude, "remote-excludes", false, "备份开始时读取--remote-path下的ignore-patterns.txt（格式同--exclude-from），与本地排除模式合并；远程不存在时忽略，读取失败时使用上次保存在临时目录中的副本")
+	rootCmd.PersistentFlags().StringVar(&newerThan, "newer-than", "", "只备份树内修改时间晚于该时间的chunk目录（时长如24h，或时间戳如2024-03-14 08:00:00）")
+	rootCmd.PersistentFlags().StringVar(&excludeOlder, "exclude-mtime-older-than", "", "分阶段全量备份：只打包树内修改时间晚于该时间的chunk目录（格式同--newer-than），其余目录记录在元数据中，由之后的增量备份补齐；仅用于full")
+	rootCmd.PersistentFlags().BoolVar(&dedupe, "dedupe-across-groups", false, "内容相同的文件只在远程blob目录中保存一份，压缩包中省略（扫描时需计算所有文件的SHA256）")
+	rootCmd.PersistentFlags().BoolVar(&keepHistory, "keep-history", false, "每次备份在远程history目录保存一份元数据快照，供prune按保留策略清理")
+	rootCmd.PersistentFlags().BoolVar(&archiveSuffix, "archive-timestamp-suffix", false, "压缩包以带时间戳后缀的新对象名上传（如0000-00ff.20240101T020000Z.tar.gz），从不覆盖远程已有的压缩包，适用于不可变存储；需要与--keep-history一起使用")
+	rootCmd.PersistentFlags().BoolVar(&emitManifest, "emit-restore-manifest", false, "每次备份上传restore-manifest.json，列出不依赖本工具手动恢复所需的压缩包、校验和与命令")
+	rootCmd.PersistentFlags().BoolVar(&splitTree, "split-file-tree", false, "文件树与校验和分开保存到filetree目录，verify、prune和恢复不再下载完整文件树")
+	rootCmd.PersistentFlags().IntVar(&hexDigits, "hex-digits", scanner.DefaultHexDigits, "chunk目录名的十六进制位数（1-8），--prefix-digits不能超过该值")
+	rootCmd.PersistentFlags().BoolVar(&looseHex, "loose-hex", false, "目录名只要求以--hex-digits位十六进制开头，允许带后缀（默认要求完全匹配）")
+	rootCmd.PersistentFlags().StringVar(&snapshotHook, "snapshot-hook", "", "备份前执行的创建快照命令，标准输出最后一行为快照挂载路径，用于代替chunk-path")
+	rootCmd.PersistentFlags().StringVar(&snapshotClean, "snapshot-cleanup", "", "备份结束后（包括失败时）执行的清理快照命令，挂载路径通过PBS_SNAPSHOT_PATH环境变量传入")
+	rootCmd.PersistentFlags().StringVar(&datastoreID, "datastore-id", "", "datastore标识，记录在元数据中；为空时使用chunk路径指纹。移动datastore后指定标识即可继续增量备份：上次记录的是路径指纹时首次指定会被接受并改为记录该标识")
+	rootCmd.PersistentFlags().StringVar(&namespace, "namespace", "", "PBS命名空间标签（如prod或prod/db），记录在元数据中，增量备份和恢复时检查；chunk在datastore内共享，不按命名空间筛选")
+	rootCmd.PersistentFlags().BoolVar(&namespaceDir, "namespace-dir", false, "远程路径（和--metadata-remote-path）加上命名空间对应的子目录（ns/<名称>，多级时为ns/a/ns/b），每个命名空间的备份分开保存")
+	rootCmd.PersistentFlags().StringVar(&compareMode, "compare-mode", string(scanner.CompareMtimeSize), "增量备份的变化检测模式（mtime-size、size-only或hash）")
+	rootCmd.PersistentFlags().BoolVar(&trackInodes, "track-inodes", false, "文件树记录inode号，增量备份把只是移动过的文件视为未变化，恢复时从原压缩包移动到当前路径（仅类Unix系统）")
+	rootCmd.PersistentFlags().IntVar(&growthReport, "growth-report", 0, fmt.Sprintf("增量备份后列出大小变化最大的前N个目录（0表示关闭，--verbose时默认%d）", defaultGrowthReport))
+	rootCmd.PersistentFlags().IntVar(&decisionLimit, "decision-concurrency", backup.DefaultDecisionConcurrency, "增量备份判断压缩包是否需要上传时同时读取的远程校验和文件数，与打包和上传分开")
+	rootCmd.PersistentFlags().StringVar(&minThroughput, "min-throughput", "", "最低上传吞吐量（如10MB，表示每秒），设置后每个压缩包的上传截止时间按其大小计算，上传不再受--timeout限制")
 	rootCmd.PersistentFlags().StringVar(&logPath, "log-path", "", "日志文件路径（可选，默认仅输出到控制台）")
+	rootCmd.PersistentFlags().StringVar(&reportFile, "report-file", "", "备份结束后（包括失败时）将JSON运行报告写入该文件；以.jsonl结尾时追加一行")
+	rootCmd.PersistentFlags().BoolVar(&statusFile, "status-file", false, "备份期间在临时目录中定期更新status.json（阶段、已完成/总分组数、已上传字节数和预计剩余时间），供外部监控读取")
+	rootCmd.PersistentFlags().BoolVar(&statusRemote, "status-remote", false, "同时把status.json上传到远程路径（最多每分钟一次，结束时总是上传），需要与--status-file一起使用")
+	rootCmd.PersistentFlags().BoolVar(&tarIndex, "tar-index", false, "为每个压缩包生成tar索引，支持单文件快速恢复")
+	rootCmd.PersistentFlags().StringVar(&readBuffer, "read-buffer-bytes", "", "创建压缩包时并行预读文件内容的内存上限（如64MB），未设置时顺序读取")
+	rootCmd.PersistentFlags().StringVar(&treeHash, "tree-hash", scanner.HashSHA256, "扫描时文件内容哈希的算法（sha256或xxh3）；xxh3只用于--compare-mode hash等变化检测，速度快得多，不能与--dedupe-across-groups同时使用。压缩包校验和始终使用SHA256")
+	rootCmd.PersistentFlags().StringVar(&hashBuffer, "hash-buffer-size", "1MB", "计算SHA256（压缩包校验和、--compare-mode hash等）时的读取缓冲区大小")
+	rootCmd.PersistentFlags().IntVar(&throttleAfter, "throttle-after", 0, "每打包N个chunk目录后暂停--throttle-sleep，把源磁盘I/O让给PBS（0表示不按目录数暂停）")
+	rootCmd.PersistentFlags().StringVar(&throttleBytes, "throttle-after-bytes", "", "每从源目录读取该数据量（如512MB）后暂停--throttle-sleep，未设置时不按数据量暂停")
+	rootCmd.PersistentFlags().DurationVar(&throttleSleep, "throttle-sleep", time.Second, "--throttle-after/--throttle-after-bytes每次暂停的时长")
+	rootCmd.PersistentFlags().StringVar(&readRateLimit, "source-read-rate-limit", "", "从源目录读取文件内容的速率上限（如50MB/s），按未压缩的数据计算，未设置时不限制")
+	rootCmd.PersistentFlags().BoolVar(&smartCompress, "smart-compression", false, "创建压缩包前采样文件的压缩率，压缩效果差（如chunk已压缩或加密）时不压缩以节省CPU")
+	rootCmd.PersistentFlags().BoolVar(&parallelGzip, "parallel-gzip", false, "使用多核并行gzip（pgzip）压缩，输出仍是标准gzip；启用--tar-index时不生效")
+	rootCmd.PersistentFlags().BoolVar(&preallocate, "preallocate-temp", false, "写入压缩包前按估算大小预留临时目录的磁盘空间（Linux fallocate），空间不足时立即失败")
+	rootCmd.PersistentFlags().BoolVar(&fsyncMetadata, "fsync-metadata", false, "本地元数据副本和临时压缩包写完后调用fsync落盘，崩溃后保留的文件不会残缺；会降低写入速度")
+	rootCmd.PersistentFlags().StringVar(&checksumStyle, "checksum-style", archiver.ChecksumStyleText, "校验和文件格式（text、binary或tag），分别与sha256sum默认、-b和--tag的输出相同；读取时接受所有格式")
+	rootCmd.PersistentFlags().StringVar(&checksumFiles, "checksum-files", backup.ChecksumFilesSidecar, "校验和文件的保存方式（sidecar、bundle或both）；bundle把所有压缩包的校验和写入一个checksums.txt，不再为每个压缩包上传单独的.sha256文件，both两种都上传")
+	rootCmd.PersistentFlags().StringVar(&compression, "compression", archiver.CompressionGzip, "压缩包格式（gzip、none或auto）；none写入不压缩的.tar，适用于已压缩的datastore或带宽充足的目标；auto按分组采样选择gzip、zstd或不压缩。增量备份沿用全量备份的格式")
+	rootCmd.PersistentFlags().BoolVar(&paranoid, "paranoid", false, "上传前读回每个压缩包，确认条目和文件内容与源目录完全一致（额外读取一遍源数据和压缩包）")
+	rootCmd.PersistentFlags().StringVar(&signKey, "sign-key", "", "用该GPG密钥（--local-user）为每个压缩包生成分离签名，与校验和文件一起上传，verify时校验签名")
+	rootCmd.PersistentFlags().StringVar(&gpgBinary, "gpg-binary", "gpg", "gpg二进制文件路径，用于--sign-key签名和verify校验签名")
+	rootCmd.PersistentFlags().BoolVar(&pbsVerify, "pbs-verify", false, "打包前抽样校验源chunk的内容是否与文件名中的摘要一致，发现PBS数据存储中已损坏的chunk")
+	rootCmd.PersistentFlags().Float64Var(&pbsSample, "pbs-verify-sample", backup.DefaultPBSVerifySample, "--pbs-verify抽样校验的chunk比例（0-1]")
+	rootCmd.PersistentFlags().BoolVar(&pbsAbort, "pbs-verify-abort", false, "--pbs-verify发现损坏的chunk时中止备份，不上传任何文件")
+
+	// SFTP后端标志（--backend sftp时使用）
+	rootCmd.PersistentFlags().StringVar(&sftpHost, "sftp-host", "", "SFTP服务器地址")
+	rootCmd.PersistentFlags().IntVar(&sftpPort, "sftp-port", 22, "SFTP服务器端口")
+	rootCmd.PersistentFlags().StringVar(&sftpUser, "sftp-user", "", "SFTP用户名")
+	rootCmd.PersistentFlags().StringVar(&sftpKeyFile, "sftp-key-file", "", "SSH私钥文件路径")
+	rootCmd.PersistentFlags().StringVar(&sftpPassword, "sftp-password", "", "SFTP密码，私钥加密时作为私钥口令（也可通过环境变量"+sftpPasswordEnv+"设置）")
+	rootCmd.PersistentFlags().StringVar(&sftpKnownHosts, "sftp-known-hosts", "", "known_hosts文件路径（默认~/.ssh/known_hosts）")
+	rootCmd.PersistentFlags().BoolVar(&sftpInsecureIgnoreHostKey, "sftp-insecure-ignore-host-key", false, "跳过SFTP主机密钥校验（不安全，仅用于测试）")
 
 	// 全量备份特有标志
-	fullCmd.Flags().IntVar(&prefixDigits, "prefix-digits", 2, "分组前缀位数（1-4）")
+	fullCmd.Flags().IntVar(&prefixDigits, "prefix-digits", 2, "分组前缀位数（1到--hex-digits）")
+	fullCmd.Flags().IntVar(&dirBatchSize, "dir-batch-size", 0, "每个压缩包最多包含的目录数，超过时按目录编号对齐切分（0表示只按前缀分组）")
+	fullCmd.Flags().BoolVar(&smartFull, "smart-full", false, "按源内容校验和（文件路径、大小和SHA256）与上次备份比较，未变化的压缩包不重新打包和上传（扫描时需计算所有文件的SHA256）")
+	fullCmd.Flags().StringVar(&minArchive, "min-archive-size", "", "合并相邻的小分组直到每个压缩包达到该大小（如1GB），合并后的压缩包按实际范围命名；增量备份沿用合并结果")
+
+	// 增量备份特有标志
+	incrementalCmd.Flags().BoolVar(&autoFull, "auto-full", false, "远程没有备份元数据时自动执行全量备份，而不是报错")
+	incrementalCmd.Flags().BoolVar(&force, "force", false, "datastore标识或命名空间与上次备份不一致时仍然执行增量备份")
+	incrementalCmd.Flags().BoolVar(&repair, "repair", false, "重新生成上次备份缺少校验和的压缩包；没有目录变化时也照常处理分组并上传元数据")
+	incrementalCmd.Flags().BoolVar(&verifyRemote, "verify-remote", false, "不使用上次备份的文件树：打包全部分组，只上传SHA256与远程校验和文件不同的压缩包（读取全部数据）；与--list-changed一起使用时检查远程压缩包和校验和文件")
+	incrementalCmd.Flags().BoolVar(&listChanged, "list-changed", false, "只以JSON输出将要更新的压缩包和变化的目录，不执行备份")
+	incrementalCmd.Flags().IntVar(&prefixDigits, "prefix-digits", 2, "自动全量备份时使用的分组前缀位数（1到--hex-digits），仅与--auto-full一起使用；--verify-remote无法读取远程元数据时也使用该值")
+	incrementalCmd.Flags().IntVar(&dirBatchSize, "dir-batch-size", 0, "自动全量备份时每个压缩包最多包含的目录数，仅与--auto-full一起使用；--verify-remote无法读取远程元数据时也使用该值")
+	incrementalCmd.Flags().StringVar(&minArchive, "min-archive-size", "", "自动全量备份时合并相邻小分组的目标大小，仅与--auto-full一起使用；--verify-remote无法读取远程元数据时也使用该值")
+
+	// 自动备份特有标志
+	autoCmd.Flags().Float64Var(&fullThreshold, "full-threshold", backup.DefaultFullThreshold, "变化目录比例超过该值（0-1）时执行全量备份")
+	autoCmd.Flags().IntVar(&prefixDigits, "prefix-digits", 2, "首次全量备份的分组前缀位数（1到--hex-digits）")
+	autoCmd.Flags().IntVar(&dirBatchSize, "dir-batch-size", 0, "首次全量备份时每个压缩包最多包含的目录数（0表示只按前缀分组）")
+	autoCmd.Flags().StringVar(&minArchive, "min-archive-size", "", "全量备份时合并相邻的小分组直到每个压缩包达到该大小（如1GB）")
+	autoCmd.Flags().BoolVar(&force, "force", false, "datastore标识或命名空间与上次备份不一致时仍然执行备份")
 
-	// 标记必需参数
-	rootCmd.MarkPersistentFlagRequired("chunk-path")
+	// 恢复特有标志
+	restoreCmd.Flags().StringVar(&restoreFile, "file", "", "只恢复指定的文件或目录（相对于chunk目录，如0012/abcd）")
+	restoreCmd.Flags().BoolVar(&preserveEmpty, "preserve-empty-dirs", false, "解压后按文件树重新创建所有目录（包括空的chunk目录）并还原目录修改时间，需要下载文件树")
+
+	// 标记必需参数（localCommands中的命令由skipRemotePathRequired取消）
 	rootCmd.MarkPersistentFlagRequired("remote-path")
+	rootCmd.PersistentPreRun = skipRemotePathRequired
 
 	// 添加子命令
 	rootCmd.AddCommand(fullCmd)
 	rootCmd.AddCommand(incrementalCmd)
+	rootCmd.AddCommand(autoCmd)
+	rootCmd.AddCommand(restoreCmd)
 }
 
 // Execute 执行命令
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// skipRemotePathRequired 执行不访问备份远程路径的命令时取消--remote-path的必需标记，
+// cobra在PersistentPreRun之后才检查必需参数
+func skipRemotePathRequired(cmd *cobra.Command, args []string) {
+	top := cmd
+	for top.HasParent() && top.Parent() != rootCmd {
+		top = top.Parent()
+	}
+	if localCommands[top.Name()] {
+		cmd.Flags().SetAnnotation("remote-path", cobra.BashCompOneRequiredFlag, []string{"false"})
 	}
 }
 
 // buildConfig 构建配置对象
 func buildConfig(mode string) (*models.Config, error) {
-	// 验证必需参数
-	if chunkPath == "" {
+	// 验证必需参数（校验、清理、整理、复制、重新分组和摘要只操作远程存储，不需要chunk目录）
+	remoteOnly := mode == "verify" || mode == "prune" || mode == "gc" || mode == "clone" || mode == "regroup" || mode == "describe"
+	if chunkPath == "" && !remoteOnly {
 		return nil, fmt.Errorf("chunk-path是必需的")
 	}
 	if remotePath == "" {
 		return nil, fmt.Errorf("remote-path是必需的")
 	}
+	metadataRemote := strings.TrimSpace(metadataPath)
+	if metadataRemote == remotePath {
+		metadataRemote = ""
+	}
+	if runSubdir && metadataRemote != "" {
+		return nil, fmt.Errorf("--remote-subdir-per-run不能与--metadata-remote-path同时使用，元数据保存在每次运行的子目录中")
+	}
 
-	// 验证chunk路径
-	if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("chunk目录不存在: %s", chunkPath)
+	// 按命名空间划分远程路径时，所有命令（包括增量备份读取的元数据和恢复）都使用命名空间的子目录
+	ns, err := backup.ParseNamespace(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("namespace无效: %w", err)
+	}
+	remoteBase := remotePath
+	if namespaceDir {
+		if ns == "" {
+			return nil, fmt.Errorf("--namespace-dir需要与--namespace一起使用")
+		}
+		remoteBase = backup.NamespaceRemotePath(remotePath, ns)
+		if metadataRemote != "" {
+			metadataRemote = backup.NamespaceRemotePath(metadataRemote, ns)
+		}
+	}
+
+	// 验证chunk路径（恢复时目标目录可以不存在）
+	if mode != "restore" && !remoteOnly {
+		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
+			return nil, fmt.Errorf("chunk目录不存在: %s", chunkPath)
+		}
+	}
+
+	// 解析临时目录，第一个目录用于锁文件、元数据等，所有目录轮流存放压缩包
+	var temps []string
+	for _, path := range tempPaths {
+		if trimmed := strings.TrimSpace(path); trimmed != "" {
+			temps = append(temps, trimmed)
+		}
+	}
+	if len(temps) == 0 {
+		return nil, fmt.Errorf("temp-path不能为空")
+	}
+
+	// 验证chunk目录名的十六进制位数
+	if hexDigits < 1 || hexDigits > scanner.MaxHexDigits {
+		return nil, fmt.Errorf("hex-digits必须在1到%d之间，得到%d", scanner.MaxHexDigits, hexDigits)
+	}
+
+	// 验证前缀位数（全量备份、重新分组，或增量备份可能自动转为全量时），不能超过目录名的十六进制位数
+	if mode == "full" || mode == "auto" || mode == "regroup" || mode == "incremental" && (autoFull || verifyRemote) {
+		if err := archiver.ValidatePrefixDigits(prefixDigits, hexDigits); err != nil {
+			return nil, fmt.Errorf("前缀位数无效（--prefix-digits不能超过--hex-digits）: %w", err)
+		}
+		if dirBatchSize < 0 {
+			return nil, fmt.Errorf("dir-batch-size不能为负数，得到%d", dirBatchSize)
+		}
+	}
+
+	if snapshotClean != "" && snapshotHook == "" {
+		return nil, fmt.Errorf("snapshot-cleanup需要与snapshot-hook一起使用")
+	}
+
+	if pbsVerify && (pbsSample <= 0 || pbsSample > 1) {
+		return nil, fmt.Errorf("pbs-verify-sample必须在0到1之间（不含0），得到%g", pbsSample)
+	}
+	if pbsAbort && !pbsVerify {
+		return nil, fmt.Errorf("pbs-verify-abort需要与pbs-verify一起使用")
+	}
+	if statusRemote && !statusFile {
+		return nil, fmt.Errorf("status-remote需要与status-file一起使用")
+	}
+	// 旧的压缩包只由prune在引用它们的历史快照清理后删除
+	if archiveSuffix && !keepHistory {
+		return nil, fmt.Errorf("archive-timestamp-suffix需要与keep-history一起使用")
+	}
+
+	if mode == "auto" && (fullThreshold < 0 || fullThreshold > 1) {
+		return nil, fmt.Errorf("full-threshold必须在0到1之间，得到%g", fullThreshold)
+	}
+
+	// 解析最低上传吞吐量
+	var minThroughputBytes int64
+	if minThroughput != "" {
+		parsed, err := parseSize(minThroughput)
+		if err != nil {
+			return nil, fmt.Errorf("min-throughput无效: %w", err)
+		}
+		if parsed <= 0 {
+			return nil, fmt.Errorf("min-throughput必须大于0")
+		}
+		minThroughputBytes = parsed
+	}
+
+	// 最长运行时间需要在全局超时之前用完，否则备份会先被--timeout中止
+	if maxRuntime < 0 {
+		return nil, fmt.Errorf("max-runtime不能为负数")
+	}
+	if maxRuntime > 0 && maxRuntime >= timeout {
+		return nil, fmt.Errorf("--max-runtime（%v）必须小于--timeout（%v）", maxRuntime, timeout)
+	}
+
+	// 解析目录大小限制
+	var maxDirSizeBytes int64
+	if maxDirSize != "" {
+		parsed, err := parseSize(maxDirSize)
+		if err != nil {
+			return nil, fmt.Errorf("max-dir-size无效: %w", err)
+		}
+		maxDirSizeBytes = parsed
 	}
 
-	// 验证前缀位数（仅全量备份）
-	if mode == "full" {
-		if prefixDigits < 1 || prefixDigits > 4 {
-			return nil, fmt.Errorf("前缀位数必须在1到4之间，得到%d", prefixDigits)
+	// 解析远程对象大小上限，空表示使用后端识别的上限
+	var maxObjectBytes int64
+	switch strings.ToLower(strings.TrimSpace(maxObjectSize)) {
+	case "":
+	case "off", "0":
+		maxObjectBytes = -1
+	default:
+		parsed, err := parseSize(maxObjectSize)
+		if err != nil {
+			return nil, fmt.Errorf("max-object-size无效: %w", err)
+		}
+		if parsed <= 0 {
+			return nil, fmt.Errorf("max-object-size必须大于0，得到%s", maxObjectSize)
 		}
+		maxObjectBytes = parsed
+	}
+
+	annotations, err := parseAnnotations(metaPairs)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析合并小分组的目标大小
+	var minArchiveBytes int64
+	if minArchive != "" {
+		parsed, err := parseSize(minArchive)
+		if err != nil {
+			return nil, fmt.Errorf("min-archive-size无效: %w", err)
+		}
+		minArchiveBytes = parsed
+	}
+
+	// 解析预读内存上限
+	var readBufferBytes int64
+	if readBuffer != "" {
+		parsed, err := parseSize(readBuffer)
+		if err != nil {
+			return nil, fmt.Errorf("read-buffer-bytes无效: %w", err)
+		}
+		if parsed <= 0 {
+			return nil, fmt.Errorf("read-buffer-bytes必须大于0")
+		}
+		readBufferBytes = parsed
+	}
+
+	// 解析校验和读取缓冲区大小
+	hashBufferSize, err := parseSize(hashBuffer)
+	if err != nil {
+		return nil, fmt.Errorf("hash-buffer-size无效: %w", err)
+	}
+	if hashBufferSize <= 0 || hashBufferSize > maxHashBufferSize {
+		return nil, fmt.Errorf("hash-buffer-size必须在1B到%s之间", formatSize(maxHashBufferSize))
+	}
+
+	// 解析源磁盘节流条件
+	if throttleAfter < 0 {
+		return nil, fmt.Errorf("throttle-after不能为负数，得到%d", throttleAfter)
+	}
+	var throttleAfterBytes int64
+	if throttleBytes != "" {
+		parsed, err := parseSize(throttleBytes)
+		if err != nil {
+			return nil, fmt.Errorf("throttle-after-bytes无效: %w", err)
+		}
+		if parsed <= 0 {
+			return nil, fmt.Errorf("throttle-after-bytes必须大于0")
+		}
+		throttleAfterBytes = parsed
+	}
+	if (throttleAfter > 0 || throttleAfterBytes > 0) && throttleSleep <= 0 {
+		return nil, fmt.Errorf("throttle-sleep必须大于0")
+	}
+	var readRateBytes int64
+	if readRateLimit != "" {
+		parsed, err := parseRate(readRateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("source-read-rate-limit无效: %w", err)
+		}
+		if parsed < minReadRateLimit {
+			return nil, fmt.Errorf("source-read-rate-limit不能低于%s/s，得到%s", formatSize(minReadRateLimit), readRateLimit)
+		}
+		readRateBytes = parsed
+	}
+
+	if transfers < 0 {
+		return nil, fmt.Errorf("transfers不能为负数，得到%d", transfers)
+	}
+	if checkers < 0 {
+		return nil, fmt.Errorf("checkers不能为负数，得到%d", checkers)
+	}
+
+	// 解析远程小文件读取上限
+	catMaxSizeBytes, err := parseSize(catMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("cat-max-size无效: %w", err)
+	}
+
+	// 验证包含前缀
+	hexPrefix := regexp.MustCompile(fmt.Sprintf(`^[0-9a-fA-F]{1,%d}$`, hexDigits))
+	excludePatterns, err := parseExcludePatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prefix := range includePrefix {
+		if !hexPrefix.MatchString(prefix) {
+			return nil, fmt.Errorf("include-prefix必须是1到%d位十六进制，得到%s", hexDigits, prefix)
+		}
+	}
+
+	// 解析修改时间截止点
+	var newerThanCutoff time.Time
+	if newerThan != "" {
+		parsed, err := parseCutoff(newerThan, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("newer-than无效: %w", err)
+		}
+		newerThanCutoff = parsed
+	}
+	var stagedCutoff time.Time
+	if excludeOlder != "" {
+		if mode != "full" {
+			return nil, fmt.Errorf("exclude-mtime-older-than只适用于全量备份，之后用增量备份补齐其余目录")
+		}
+		parsed, err := parseCutoff(excludeOlder, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("exclude-mtime-older-than无效: %w", err)
+		}
+		stagedCutoff = parsed
+	}
+
+	// 验证压缩包格式
+	archiveCompression, err := archiver.ParseCompression(compression)
+	if err != nil {
+		return nil, fmt.Errorf("compression无效: %w", err)
+	}
+	if archiveCompression == archiver.CompressionNone && smartCompress {
+		return nil, fmt.Errorf("--compression none不能与--smart-compression同时使用")
+	}
+	if archiveCompression == archiver.CompressionAuto && smartCompress {
+		return nil, fmt.Errorf("--compression auto已按分组采样选择格式，不能与--smart-compression同时使用")
+	}
+
+	// 验证校验和文件格式
+	style, err := archiver.ParseChecksumStyle(checksumStyle)
+	if err != nil {
+		return nil, fmt.Errorf("checksum-style无效: %w", err)
+	}
+	sumFiles, err := backup.ParseChecksumFiles(checksumFiles)
+	if err != nil {
+		return nil, fmt.Errorf("checksum-files无效: %w", err)
+	}
+
+	// 验证变化检测模式
+	if _, err := scanner.ParseCompareMode(compareMode); err != nil {
+		return nil, fmt.Errorf("compare-mode无效: %w", err)
+	}
+	treeHashAlgorithm, err := scanner.ParseHashAlgorithm(treeHash)
+	if err != nil {
+		return nil, fmt.Errorf("tree-hash无效: %w", err)
+	}
+	if treeHashAlgorithm == scanner.HashXXH3 && dedupe {
+		return nil, fmt.Errorf("--tree-hash xxh3不能与--dedupe-across-groups同时使用，去重的blob以SHA256命名")
+	}
+
+	// 验证增长报告数量，详细模式下默认开启
+	if maxScanDepth < 1 {
+		return nil, fmt.Errorf("max-scan-depth必须大于0，得到%d", maxScanDepth)
+	}
+	if decisionLimit < 1 {
+		return nil, fmt.Errorf("decision-concurrency必须大于0，得到%d", decisionLimit)
+	}
+	if growthReport < 0 {
+		return nil, fmt.Errorf("growth-report不能为负数，得到%d", growthReport)
+	}
+	growthReportSize := growthReport
+	if verbose && !rootCmd.PersistentFlags().Changed("growth-report") {
+		growthReportSize = defaultGrowthReport
+	}
+
+	// SFTP密码优先使用命令行参数，其次使用环境变量
+	password := sftpPassword
+	if password == "" {
+		password = os.Getenv(sftpPasswordEnv)
 	}
 
 	// 处理rclone参数
@@ -159,38 +731,206 @@ func buildConfig(mode string) (*models.Config, error) {
 	}
 
 	return &models.Config{
-		ChunkPath:    chunkPath,
-		RemotePath:   remotePath,
-		TempPath:     tempPath,
-		RcloneBinary: rcloneBinary,
-		RcloneConfig: rcloneConfig,
-		RcloneArgs:   processedArgs,
-		PrefixDigits: prefixDigits,
-		Mode:         mode,
-		Verbose:      verbose,
+		ChunkPath:       chunkPath,
+		RemotePath:      remoteBase,
+		MetadataRemote:  metadataRemote,
+		TempPath:        temps[0],
+		TempPaths:       temps,
+		Backend:         backend,
+		RcloneBinary:    rcloneBinary,
+		RcloneConfig:    rcloneConfig,
+		RcloneArgs:      processedArgs,
+		CatMaxSize:      catMaxSizeBytes,
+		Transfers:       transfers,
+		Checkers:        checkers,
+		PrefixDigits:    prefixDigits,
+		DirBatchSize:    dirBatchSize,
+		MinArchiveSize:  minArchiveBytes,
+		HexDigits:       hexDigits,
+		LooseHex:        looseHex,
+		Mode:            mode,
+		AutoFull:        autoFull,
+		FullThreshold:   fullThreshold,
+		DatastoreID:     datastoreID,
+		Namespace:       ns,
+		NamespaceDir:    namespaceDir,
+		Force:           force,
+		AllowEmpty:      allowEmpty,
+		Repair:          repair,
+		VerifyRemote:    verifyRemote,
+		RunID:           backup.NewRunID(),
+		LogRunContext:   logRunContext,
+		ReportFile:      reportFile,
+		StatusFile:      statusFile,
+		StatusRemote:    statusRemote,
+		Verbose:         verbose,
+		VerboseRclone:   verboseRclone,
+		TarIndex:        tarIndex,
+		SmartCompress:   smartCompress,
+		ParallelGzip:    parallelGzip,
+		PreallocateTemp: preallocate,
+		FsyncMetadata:   fsyncMetadata,
+		SmartFull:       smartFull,
+		RunSubdir:       runSubdir,
+		UsePrevMetadata: usePrevMeta,
+		Annotations:     annotations,
+		MaxRuntime:      maxRuntime,
+		Compression:     archiveCompression,
+		ChecksumStyle:   style,
+		ChecksumFiles:   sumFiles,
+		Paranoid:        paranoid,
+		SignKey:         signKey,
+		GPGBinary:       gpgBinary,
+		KeepHistory:     keepHistory,
+		ArchiveSuffix:   archiveSuffix,
+		RestoreManifest: emitManifest,
+		SplitFileTree:   splitTree,
+		KeepEmptyDirs:   preserveEmpty,
+		SnapshotHook:    snapshotHook,
+		SnapshotCleanup: snapshotClean,
+		ReadBufferBytes: readBufferBytes,
+		HashBufferSize:  int(hashBufferSize),
+		MinThroughput:   minThroughputBytes,
+		MaxDirSize:      maxDirSizeBytes,
+		MaxObjectSize:   maxObjectBytes,
+		IncludePrefixes: includePrefix,
+		ExcludePatterns: excludePatterns,
+		RemoteExcludes:  remoteExclude,
+		SkipScanErrors:  continueScan,
+		MaxScanDepth:    maxScanDepth,
+		NewerThan:       newerThanCutoff,
+		StagedCutoff:    stagedCutoff,
+		CompareMode:     compareMode,
+		TrackInodes:     trackInodes,
+		TreeHash:        treeHashAlgorithm,
+		GrowthReport:    growthReportSize,
+		DecisionWorkers: decisionLimit,
+		Dedupe:          dedupe,
+		PBSVerify:       pbsVerify,
+		PBSVerifySample: pbsSample,
+		PBSVerifyAbort:  pbsAbort,
+
+		ThrottleDirs:  throttleAfter,
+		ThrottleBytes: throttleAfterBytes,
+		ThrottleSleep: throttleSleep,
+
+		ReadRateLimit: readRateBytes,
+
+		SFTPHost:                  sftpHost,
+		SFTPPort:                  sftpPort,
+		SFTPUser:                  sftpUser,
+		SFTPKeyFile:               sftpKeyFile,
+		SFTPPassword:              password,
+		SFTPKnownHosts:            sftpKnownHosts,
+		SFTPInsecureIgnoreHostKey: sftpInsecureIgnoreHostKey,
 	}, nil
 }
 
-// runBackup 执行备份
+// runBackup 执行备份，指定--report-file时在结束后（包括失败时）写入运行报告。
+// 部分压缩包失败或达到--max-runtime时备份结果仍然有效，以零状态退出
 func runBackup(config *models.Config) error {
+	startTime := time.Now()
+	result, err := executeBackup(config)
+
+	if config.ReportFile != "" {
+		report := newBackupReport(config, startTime, result, err)
+		if reportErr := writeReport(config.ReportFile, report); reportErr != nil {
+			logger.Error(fmt.Sprintf("写入运行报告失败: %v", reportErr))
+			if err == nil {
+				return fmt.Errorf("写入运行报告失败: %w", reportErr)
+			}
+		}
+	}
+
+	if errors.Is(err, backup.ErrPartialFailure) {
+		return nil
+	}
+	return err
+}
+
+// executeBackup 执行备份并输出结果，部分压缩包失败时同时返回结果和错误
+func executeBackup(config *models.Config) (*models.BackupResult, error) {
+	// 获取本地锁，防止同一台主机上同时运行多个备份
+	backupLock, err := acquireLock(config)
+	if err != nil {
+		return nil, err
+	}
+	defer backupLock.Release()
+
 	// 初始化日志系统
-	if err := logger.InitLogger(config.Verbose, logPath); err != nil {
-		return fmt.Errorf("初始化日志失败: %w", err)
+	if err := initLogger(config); err != nil {
+		return nil, fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	// 创建上下文
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	// 创建快照并改为备份快照挂载路径，备份结束后（包括失败时）执行清理命令
+	if config.SnapshotHook != "" {
+		// 快照挂载路径可能每次不同，datastore标识沿用原始chunk目录的指纹
+		if config.DatastoreID == "" {
+			id, err := backup.PathDatastoreID(config.ChunkPath)
+			if err != nil {
+				return nil, err
+			}
+			config.DatastoreID = id
+		}
+
+		mountPath, err := runSnapshotHook(ctx, config.SnapshotHook, config.ChunkPath)
+		defer func() { runSnapshotCleanup(config.SnapshotCleanup, mountPath) }()
+		if err != nil {
+			return nil, err
+		}
+		logger.Info(fmt.Sprintf("使用快照 %s 代替 %s", mountPath, config.ChunkPath))
+		config.ChunkPath = mountPath
 	}
 
 	// 创建存储实例
-	store := storage.NewRcloneStorage(config.RcloneBinary, config.RcloneConfig, config.RcloneArgs, config.Verbose)
+	store, err := newStorage(config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStorage(store)
+
+	// 合并远程集中管理的排除模式，必须在创建备份管理器之前完成
+	if config.RemoteExcludes {
+		patterns, err := backup.FetchRemoteExcludes(ctx, store, config)
+		if err != nil {
+			return nil, fmt.Errorf("读取远程排除模式失败: %w", err)
+		}
+		config.ExcludePatterns = append(config.ExcludePatterns, patterns...)
+	}
 
 	// 创建备份管理器
 	manager := backup.NewBackupManager(config, store)
+	manager.SetScanProgress(scanProgress())
 
-	// 创建上下文
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	// 确保临时目录存在且可写
+	if err := prepareTempPaths(config.TempPaths); err != nil {
+		return nil, err
+	}
 
-	// 确保临时目录存在
-	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
-		return fmt.Errorf("创建临时目录失败: %w", err)
+	// 未指定--max-object-size时识别远程的对象大小上限，失败时不检查
+	if detector, ok := store.(storage.LimitDetector); ok && config.MaxObjectSize == 0 {
+		if err := detector.DetectLimits(ctx); err != nil {
+			logger.Warn(fmt.Sprintf("识别远程对象大小上限失败，本次不检查: %v", err))
+		}
+	}
+
+	// 确保远程目录存在，部分后端不会在上传时自动创建路径
+	if err := store.MkdirRemote(ctx, config.RemotePath); err != nil {
+		return nil, fmt.Errorf("创建远程目录失败: %w", err)
+	}
+	if config.MetadataRemote != "" {
+		if err := store.MkdirRemote(ctx, config.MetadataRemote); err != nil {
+			return nil, fmt.Errorf("创建元数据远程目录失败: %w", err)
+		}
+	}
+	if config.RunSubdir {
+		if err := manager.PrepareRunSubdir(ctx); err != nil {
+			return nil, fmt.Errorf("准备本次运行的远程子目录失败: %w", err)
+		}
 	}
 
 	// 记录备份开始
@@ -199,22 +939,47 @@ func runBackup(config *models.Config) error {
 	fmt.Printf("开始%s备份...\n", config.Mode)
 	fmt.Printf("Chunk路径: %s\n", config.ChunkPath)
 	fmt.Printf("远程路径: %s\n", config.RemotePath)
-	fmt.Printf("临时路径: %s\n", config.TempPath)
+	if config.MetadataRemote != "" {
+		fmt.Printf("元数据路径: %s\n", config.MetadataRemote)
+	}
+	if config.Namespace != "" {
+		fmt.Printf("命名空间: %s\n", config.Namespace)
+	}
+	fmt.Printf("临时路径: %s\n", strings.Join(config.TempPaths, ", "))
+	printAnnotations(config.Annotations)
 
 	// 执行备份
 	var result *models.BackupResult
-	var err error
 
-	if config.Mode == "full" {
+	switch config.Mode {
+	case "full":
 		fmt.Printf("前缀位数: %d\n", config.PrefixDigits)
+		if config.DirBatchSize > 0 {
+			fmt.Printf("每个压缩包最多目录数: %d\n", config.DirBatchSize)
+		}
+		if config.SmartFull {
+			fmt.Println("智能全量备份: 跳过源内容未变化的压缩包")
+		}
+		if config.MinArchiveSize > 0 {
+			fmt.Printf("合并小分组的目标大小: %s\n", formatSize(config.MinArchiveSize))
+		}
 		result, err = manager.RunFullBackup(ctx)
-	} else {
+	case "auto":
+		fmt.Printf("全量备份阈值: %.0f%%\n", config.FullThreshold*100)
+		result, err = manager.RunAutoBackup(ctx)
+	default:
 		result, err = manager.RunIncrementalBackup(ctx)
 	}
 
-	if err != nil {
+	// 部分压缩包失败或达到--max-runtime时备份结果仍然有效，照常输出
+	if err != nil && !errors.Is(err, backup.ErrPartialFailure) {
 		logger.Error(fmt.Sprintf("备份失败: %v", err))
-		return fmt.Errorf("备份失败: %w", err)
+		return nil, fmt.Errorf("备份失败: %w", err)
+	}
+	// 元数据已上传（包括部分压缩包失败时），latest指向本次运行
+	if latestErr := manager.UpdateLatest(ctx); latestErr != nil {
+		logger.Error(fmt.Sprintf("更新latest指针失败: %v", latestErr))
+		return nil, fmt.Errorf("备份失败: %w", latestErr)
 	}
 
 	// 记录备份完成
@@ -224,18 +989,264 @@ func runBackup(config *models.Config) error {
 	// 输出结果
 	printBackupResult(result, config.Verbose)
 
+	return result, err
+}
+
+// runListChanged 输出增量备份将要更新的压缩包和变化的目录，不执行备份
+func runListChanged(config *models.Config) error {
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+	// 标准输出只包含JSON结果，便于其他工具处理
+	logger.SetConsoleOutput(os.Stderr)
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+	manager.SetScanProgress(scanProgress())
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	if err := useLatestRun(ctx, manager, config); err != nil {
+		return err
+	}
+	changes, err := manager.ListChanged(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("计算变化失败: %v", err))
+		return fmt.Errorf("计算变化失败: %w", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(changes)
+}
+
+// runRestore 执行恢复
+func runRestore(config *models.Config) error {
+	// 初始化日志系统
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	if err := useLatestRun(ctx, manager, config); err != nil {
+		return err
+	}
+
+	fmt.Printf("开始恢复...\n")
+	fmt.Printf("远程路径: %s\n", config.RemotePath)
+	fmt.Printf("恢复到: %s\n", config.ChunkPath)
+	if config.Namespace != "" {
+		fmt.Printf("命名空间: %s\n", config.Namespace)
+	}
+	if restoreFile != "" {
+		fmt.Printf("恢复文件: %s\n", restoreFile)
+	}
+
+	result, err := manager.RunRestore(ctx, restoreFile)
+	if err != nil {
+		logger.Error(fmt.Sprintf("恢复失败: %v", err))
+		return fmt.Errorf("恢复失败: %w", err)
+	}
+
+	fmt.Printf("\n=== 恢复完成 ===\n")
+	fmt.Printf("耗时: %v\n", result.Duration)
+	fmt.Printf("恢复压缩包数: %d\n", result.RestoredArchives)
+	fmt.Printf("恢复条目数: %d\n", result.RestoredEntries)
+	if config.KeepEmptyDirs {
+		fmt.Printf("新建目录数: %d\n", result.CreatedDirs)
+	}
+	if result.MovedFiles > 0 {
+		fmt.Printf("移动到当前路径的文件数: %d\n", result.MovedFiles)
+	}
+	printAnnotations(result.Annotations)
+
+	return nil
+}
+
+// useLatestRun 启用--remote-subdir-per-run时把远程路径改为latest指向的最新一次运行的子目录
+func useLatestRun(ctx context.Context, manager *backup.BackupManager, config *models.Config) error {
+	if !config.RunSubdir {
+		return nil
+	}
+	if err := manager.UseLatestRun(ctx); err != nil {
+		return fmt.Errorf("读取latest指针失败: %w", err)
+	}
+	return nil
+}
+
+// initLogger 初始化日志系统，启用--log-run-context时每行日志附加主机名和运行标识
+func initLogger(config *models.Config) error {
+	var fields logrus.Fields
+	if config.LogRunContext {
+		host, _ := os.Hostname()
+		fields = logrus.Fields{"host": host, "run_id": config.RunID}
+	}
+	return logger.InitLoggerWithFields(config.Verbose, logPath, fields)
+}
+
+// acquireLock 获取临时目录下的本地锁，已被其他进程持有时返回错误
+func acquireLock(config *models.Config) (*lock.Lock, error) {
+	lockPath := filepath.Join(config.TempPath, lockFileName)
+	backupLock, err := lock.Acquire(lockPath)
+	if err != nil {
+		if errors.Is(err, lock.ErrLocked) {
+			return nil, fmt.Errorf("已有备份进程在运行（锁文件: %s）", lockPath)
+		}
+		return nil, fmt.Errorf("获取本地锁失败: %w", err)
+	}
+	return backupLock, nil
+}
+
+// newStorage 根据配置创建存储后端
+func newStorage(config *models.Config) (storage.Storage, error) {
+	store, err := storage.New(config.Backend, storage.Options{
+		RcloneBinary:  config.RcloneBinary,
+		RcloneConfig:  config.RcloneConfig,
+		RcloneArgs:    config.RcloneArgs,
+		Verbose:       config.Verbose,
+		VerboseRclone: config.VerboseRclone,
+		CatMaxSize:    config.CatMaxSize,
+		Transfers:     config.Transfers,
+		Checkers:      config.Checkers,
+		RemotePath:    config.RemotePath,
+
+		SFTPHost:                  config.SFTPHost,
+		SFTPPort:                  config.SFTPPort,
+		SFTPUser:                  config.SFTPUser,
+		SFTPKeyFile:               config.SFTPKeyFile,
+		SFTPPassword:              config.SFTPPassword,
+		SFTPKnownHosts:            config.SFTPKnownHosts,
+		SFTPInsecureIgnoreHostKey: config.SFTPInsecureIgnoreHostKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建存储后端失败: %w", err)
+	}
+	return store, nil
+}
+
+// closeStorage 关闭持有连接的存储后端（如SFTP）
+func closeStorage(store storage.Storage) {
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Warn(fmt.Sprintf("关闭存储后端失败: %v", err))
+		}
+	}
+}
+
+// backupContext 创建备份使用的上下文，收到SIGINT/SIGTERM时取消，以便清理临时文件后退出，
+// 到达--timeout时超时。设置了--min-throughput时上传不受--timeout约束，
+// 由备份管理器按压缩包大小计算每次上传的截止时间，避免大压缩包在上传途中被全局超时中断。
+func backupContext(config *models.Config) (context.Context, context.CancelFunc) {
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithTimeout(signalCtx, timeout)
+	if config.MinThroughput > 0 {
+		logger.Info(fmt.Sprintf("已设置最低吞吐量%s/s，上传截止时间按压缩包大小计算，--timeout只限制扫描、元数据和校验等其他操作", formatSize(config.MinThroughput)))
+		// 上传从只响应信号的上下文派生，全局超时之后仍能被SIGINT/SIGTERM取消
+		ctx = backup.WithUploadParent(ctx, signalCtx)
+	}
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// prepareTempPaths 创建临时目录并确认可以写入，避免备份到一半才因某个目录不可用而失败
+func prepareTempPaths(paths []string) error {
+	for _, path := range paths {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("创建临时目录失败: %w", err)
+		}
+		probe, err := os.CreateTemp(path, ".write-test-*")
+		if err != nil {
+			return fmt.Errorf("临时目录不可写: %w", err)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
 	return nil
 }
 
 // printBackupResult 输出备份结果
 func printBackupResult(result *models.BackupResult, verbose bool) {
 	fmt.Printf("\n=== 备份完成 ===\n")
+	fmt.Printf("运行ID: %s\n", result.RunID)
+	if result.Mode != "" {
+		fmt.Printf("备份类型: %s（变化目录比例 %.1f%%）\n", result.Mode, result.Drift*100)
+	}
 	fmt.Printf("耗时: %v\n", result.Duration)
 	fmt.Printf("总压缩包数: %d\n", result.TotalArchives)
 	fmt.Printf("更新压缩包数: %d\n", result.UpdatedArchives)
 	fmt.Printf("跳过压缩包数: %d\n", result.SkippedArchives)
 	fmt.Printf("错误压缩包数: %d\n", len(result.ErrorArchives))
 	fmt.Printf("上传文件数: %d\n", len(result.UploadedFiles))
+	fmt.Printf("上传大小: %s\n", formatSize(result.UploadedBytes))
+
+	if len(result.ExcludedDirs) > 0 {
+		fmt.Printf("\n因超过大小限制被排除的目录（可使用--include-prefix单独备份）:\n")
+		for _, dir := range result.ExcludedDirs {
+			fmt.Printf("  - %s\n", dir)
+		}
+	}
+
+	if len(result.UnreadableDirs) > 0 {
+		fmt.Printf("\n多次扫描失败而跳过的目录（增量备份沿用上次的记录，请检查挂载）:\n")
+		for _, dir := range result.UnreadableDirs {
+			fmt.Printf("  - %s\n", dir)
+		}
+	}
+
+	if len(result.CorruptChunks) > 0 {
+		fmt.Printf("\n抽样校验发现损坏的源chunk（已照常备份，请在PBS中执行校验）:\n")
+		for _, chunk := range result.CorruptChunks {
+			fmt.Printf("  - %s\n", chunk)
+		}
+	}
+
+	if len(result.GrowthReport) > 0 {
+		fmt.Printf("\n大小变化最大的目录:\n")
+		for _, change := range result.GrowthReport {
+			sign := "+"
+			delta := change.Delta
+			if delta < 0 {
+				sign = "-"
+				delta = -delta
+			}
+			fmt.Printf("  - %s: %s%s (%s -> %s)\n", change.Directory, sign, formatSize(delta),
+				formatSize(change.OldSize), formatSize(change.NewSize))
+		}
+	}
+
+	if len(result.DeferredArchives) > 0 {
+		fmt.Printf("\n已达到--max-runtime，推迟到下次运行的压缩包（下次增量备份继续处理）:\n")
+		for _, archive := range result.DeferredArchives {
+			fmt.Printf("  - %s\n", archive)
+		}
+	}
+
+	if result.PendingDirs > 0 {
+		fmt.Printf("\n分阶段备份尚未备份的目录: %d（之后的增量备份继续处理）\n", result.PendingDirs)
+	}
+
+	if result.MovedFiles > 0 {
+		fmt.Printf("\n移动过、仍保存在原压缩包中的文件: %d（恢复时移动到当前路径）\n", result.MovedFiles)
+	}
 
 	if len(result.ErrorArchives) > 0 {
 		fmt.Printf("\n错误:\n")
@@ -264,3 +1275,56 @@ func printBackupResult(result *models.BackupResult, verbose bool) {
 		fmt.Printf("\n备份成功完成！\n")
 	}
 }
+
+// parseAnnotations 解析--meta指定的key=value自定义字段，key不能为空或重复
+func parseAnnotations(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	annotations := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("meta格式应为key=value，得到%q", pair)
+		}
+		if _, exists := annotations[key]; exists {
+			return nil, fmt.Errorf("meta字段%q重复", key)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+// printAnnotations 按key排序输出自定义字段
+func printAnnotations(annotations map[string]string) {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("自定义字段: %s=%s\n", key, annotations[key])
+	}
+}
+
+// parseExcludePatterns 解析--exclude-file-pattern（忽略空白项），并合并--exclude-from文件中的模式
+func parseExcludePatterns() ([]string, error) {
+	var patterns []string
+	for _, pattern := range excludeFiles {
+		if trimmed := strings.TrimSpace(pattern); trimmed != "" {
+			patterns = append(patterns, trimmed)
+		}
+	}
+	if err := scanner.ValidateExcludePatterns(patterns); err != nil {
+		return nil, fmt.Errorf("exclude-file-pattern无效: %w", err)
+	}
+	if excludeFrom != "" {
+		filePatterns, err := scanner.ReadExcludeFile(excludeFrom)
+		if err != nil {
+			return nil, fmt.Errorf("读取exclude-from文件失败: %w", err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+var scanFormat string
+
+// scanCmd 扫描报告命令
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "扫描chunk目录并输出统计报告",
+	Long: `扫描chunk目录，输出目录数、文件数、总大小以及按前缀分组的大小分布。
+不执行任何备份操作，也不需要远程存储，可用于在首次备份前选择合适的前缀位数。`,
+	Example: `  # 以表格形式查看按2位前缀分组的统计
+  backuper scan --chunk-path /path/to/.chunk --prefix-digits 2
+
+  # 输出JSON供其他工具处理
+  backuper scan --chunk-path /path/to/.chunk --format json`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if scanFormat != "table" && scanFormat != "json" {
+			return fmt.Errorf("配置无效: 输出格式必须是table或json，得到%s", scanFormat)
+		}
+
+		if chunkPath == "" {
+			return fmt.Errorf("配置无效: chunk-path是必需的")
+		}
+		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
+			return fmt.Errorf("配置无效: chunk目录不存在: %s", chunkPath)
+		}
+
+		if hexDigits < 1 || hexDigits > scanner.MaxHexDigits {
+			return fmt.Errorf("配置无效: hex-digits必须在1到%d之间，得到%d", scanner.MaxHexDigits, hexDigits)
+		}
+		if err := archiver.ValidatePrefixDigits(prefixDigits, hexDigits); err != nil {
+			return fmt.Errorf("配置无效: 前缀位数不能超过hex-digits: %w", err)
+		}
+
+		if maxScanDepth < 1 {
+			return fmt.Errorf("配置无效: max-scan-depth必须大于0，得到%d", maxScanDepth)
+		}
+
+		excludePatterns, err := parseExcludePatterns()
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+
+		s := scanner.NewChunkScannerWithOptions(chunkPath, scanner.Options{HexDigits: hexDigits, LooseHex: looseHex, ExcludePatterns: excludePatterns, MaxDepth: maxScanDepth})
+		s.SetProgress(scanProgress())
+		fileTree, err := s.ScanFileTree()
+		if err != nil {
+			return fmt.Errorf("扫描失败: %w", err)
+		}
+
+		report, err := scanner.BuildScanReport(fileTree, prefixDigits)
+		if err != nil {
+			return fmt.Errorf("生成报告失败: %w", err)
+		}
+		report.ChunkPath = chunkPath
+
+		if scanFormat == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		}
+
+		printScanReport(report)
+		return nil
+	},
+}
+
+func init() {
+	scanCmd.Flags().IntVar(&prefixDigits, "prefix-digits", 2, "分组统计使用的前缀位数（1到--hex-digits）")
+	scanCmd.Flags().StringVar(&scanFormat, "format", "table", "输出格式（table/json）")
+
+	rootCmd.AddCommand(scanCmd)
+}
+
+// printScanReport 以表格形式输出扫描报告
+func printScanReport(report *models.ScanReport) {
+	fmt.Printf("Chunk路径: %s\n", report.ChunkPath)
+	fmt.Printf("目录数: %d\n", report.DirectoryCount)
+	fmt.Printf("文件数: %d\n", report.FileCount)
+	fmt.Printf("总大小: %s\n", formatSize(report.TotalSize))
+	fmt.Printf("前缀位数: %d（%d个分组）\n\n", report.PrefixDigits, len(report.Groups))
+
+	// 表头使用ASCII，避免宽字符导致tabwriter对齐错乱
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tDIRS\tFILES\tSIZE")
+	for _, group := range report.Groups {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", group.Prefix, group.Directories, group.Files, formatSize(group.Size))
+	}
+	w.Flush()
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits 支持的大小单位，K/M/G/T按1024进制计算
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+	"T":   1 << 40,
+	"TB":  1 << 40,
+	"TIB": 1 << 40,
+}
+
+// parseSize 解析大小字符串，如"1048576"、"512K"、"10MB"、"1.5GiB"
+func parseSize(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return 0, fmt.Errorf("大小不能为空")
+	}
+
+	// 分离数字部分和单位部分
+	i := 0
+	for i < len(trimmed) && (trimmed[i] >= '0' && trimmed[i] <= '9' || trimmed[i] == '.') {
+		i++
+	}
+
+	number, err := strconv.ParseFloat(trimmed[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("无效的大小: %s", value)
+	}
+
+	unit, exists := sizeUnits[strings.ToUpper(strings.TrimSpace(trimmed[i:]))]
+	if !exists {
+		return 0, fmt.Errorf("无效的大小单位: %s", value)
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+// parseRate 解析每秒字节数，如"50MB/s"、"50M"、"1.5GiB/s"，"/s"后缀可以省略
+func parseRate(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	if lower := strings.ToLower(trimmed); strings.HasSuffix(lower, "/s") {
+		trimmed = strings.TrimSpace(trimmed[:len(trimmed)-2])
+	}
+	rate, err := parseSize(trimmed)
+	if err != nil {
+		return 0, fmt.Errorf("无效的速率: %s", value)
+	}
+	return rate, nil
+}
+
+// formatSize 将字节数格式化为易读的大小
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import "testing"
+
+// TestParseSize 测试大小字符串解析
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"1048576", 1048576, false},
+		{"512K", 512 << 10, false},
+		{"10MB", 10 << 20, false},
+		{"1.5GiB", 3 << 29, false},
+		{"2 t", 2 << 40, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"10XB", 0, true},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseSize(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseSize(%q) 错误不符合预期: %v", tc.input, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("parseSize(%q) = %d, 期望 %d", tc.input, got, tc.expected)
+		}
+	}
+}
+
+// TestParseRate 测试速率解析，"/s"后缀可以省略
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"50MB/s", 50 << 20, false},
+		{"50M", 50 << 20, false},
+		{"1.5GiB/S", 3 << 29, false},
+		{"512 K/s", 512 << 10, false},
+		{"/s", 0, true},
+		{"10MB/m", 0, true},
+		{"fast", 0, true},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseRate(tc.input)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseRate(%q) 错误不符合预期: %v", tc.input, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("parseRate(%q) = %d, 期望 %d", tc.input, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"pbs-backuper/internal/logger"
+)
+
+const (
+	// snapshotChunkPathEnv 传给快照命令的原始chunk目录
+	snapshotChunkPathEnv = "PBS_CHUNK_PATH"
+	// snapshotPathEnv 传给清理命令的快照挂载路径
+	snapshotPathEnv = "PBS_SNAPSHOT_PATH"
+)
+
+// shellCommand 返回通过系统shell执行命令的exec.Cmd
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runSnapshotHook 执行创建快照的命令，标准输出最后一个非空行作为快照挂载路径。
+// 命令的标准错误直接输出到终端，挂载路径必须是已存在的目录
+func runSnapshotHook(ctx context.Context, command, chunkPath string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), snapshotChunkPathEnv+"="+chunkPath)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("快照命令执行失败: %w", err)
+	}
+
+	var mountPath string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			mountPath = trimmed
+		}
+	}
+	if mountPath == "" {
+		return "", fmt.Errorf("快照命令没有输出挂载路径")
+	}
+
+	info, err := os.Stat(mountPath)
+	if err != nil {
+		return "", fmt.Errorf("快照挂载路径无效: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("快照挂载路径不是目录: %s", mountPath)
+	}
+	return mountPath, nil
+}
+
+// runSnapshotCleanup 执行清理快照的命令。备份失败或被取消时也会执行，
+// 因此不使用备份的上下文；清理失败只记录错误，不覆盖备份本身的结果
+func runSnapshotCleanup(command, mountPath string) {
+	if command == "" {
+		return
+	}
+
+	cmd := shellCommand(context.Background(), command)
+	cmd.Env = append(os.Environ(), snapshotPathEnv+"="+mountPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		logger.Error(fmt.Sprintf("快照清理命令执行失败: %v", err))
+		return
+	}
+	logger.Info(fmt.Sprintf("已清理快照: %s", mountPath))
+}
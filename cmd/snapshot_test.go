@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestRunSnapshotHook 测试快照命令输出挂载路径和清理命令的环境变量
+func TestRunSnapshotHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("测试命令使用sh语法")
+	}
+
+	mountDir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "cleanup")
+
+	// 最后一个非空行是挂载路径，原始chunk目录通过环境变量传入
+	hook := `echo "creating snapshot of $` + snapshotChunkPathEnv + `"; echo '` + mountDir + `'; echo`
+	got, err := runSnapshotHook(context.Background(), hook, "/var/lib/chunks")
+	if err != nil {
+		t.Fatalf("runSnapshotHook失败: %v", err)
+	}
+	if got != mountDir {
+		t.Errorf("挂载路径 = %q，预期 %q", got, mountDir)
+	}
+
+	for _, hook := range []string{"exit 1", "true", "echo " + filepath.Join(mountDir, "missing")} {
+		if _, err := runSnapshotHook(context.Background(), hook, ""); err == nil {
+			t.Errorf("命令 %q 应该返回错误", hook)
+		}
+	}
+
+	runSnapshotCleanup(`printf '%s' "$`+snapshotPathEnv+`" > '`+marker+`'`, mountDir)
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("清理命令没有执行: %v", err)
+	}
+	if string(data) != mountDir {
+		t.Errorf("清理命令收到的挂载路径 = %q，预期 %q", data, mountDir)
+	}
+}
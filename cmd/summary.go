@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/models"
+)
+
+var (
+	summaryHosts  []string
+	summarySince  string
+	summaryUntil  string
+	summaryFormat string
+)
+
+// summaryCmd 汇总多个--report-file写出的运行报告
+var summaryCmd = &cobra.Command{
+	Use:   "summary <报告文件>...",
+	Short: "汇总多个运行报告的成功率、上传量和失败记录",
+	Long: `读取--report-file写出的运行报告（.jsonl历史文件每行一个报告，其他文件为单个报告），
+按主机统计运行次数、成功率、上传的压缩包字节数和平均耗时，并列出失败或部分失败的运行。
+只读取报告文件，不访问远程存储。无法解析的行（如写了一半的最后一行）跳过并计数。`,
+	Example: `  # 汇总多台主机最近7天的运行
+  backuper summary /var/log/backuper/*.jsonl --since 168h
+
+  # 只看一台主机某段时间的运行，输出JSON
+  backuper summary host1.jsonl host2.jsonl --host host1 --since 2024-05-01 --until 2024-06-01 --format json`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if summaryFormat != "table" && summaryFormat != "json" {
+			return fmt.Errorf("配置无效: 输出格式必须是table或json，得到%s", summaryFormat)
+		}
+
+		var filter reportFilter
+		now := time.Now()
+		if summarySince != "" {
+			since, err := parseCutoff(summarySince, now)
+			if err != nil {
+				return fmt.Errorf("配置无效: --since: %w", err)
+			}
+			filter.since = since
+		}
+		if summaryUntil != "" {
+			until, err := parseCutoff(summaryUntil, now)
+			if err != nil {
+				return fmt.Errorf("配置无效: --until: %w", err)
+			}
+			filter.until = until
+		}
+		if !filter.since.IsZero() && !filter.until.IsZero() && !filter.until.After(filter.since) {
+			return fmt.Errorf("配置无效: --until必须晚于--since")
+		}
+		filter.hosts = summaryHosts
+
+		var reports []*models.BackupReport
+		invalid := 0
+		for _, path := range args {
+			fileReports, skipped, err := readReportFile(path)
+			if err != nil {
+				return err
+			}
+			reports = append(reports, fileReports...)
+			invalid += skipped
+		}
+
+		summary := summarizeReports(reports, filter)
+		summary.InvalidLines = invalid
+
+		if summaryFormat == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(summary)
+		}
+		printReportSummary(summary)
+		return nil
+	},
+}
+
+func init() {
+	summaryCmd.Flags().StringSliceVar(&summaryHosts, "host", nil, "只统计指定主机的运行（可重复或用逗号分隔）")
+	summaryCmd.Flags().StringVar(&summarySince, "since", "", "只统计此时间之后开始的运行（时长如168h，或时间戳如2024-05-01）")
+	summaryCmd.Flags().StringVar(&summaryUntil, "until", "", "只统计此时间之前开始的运行（格式同--since）")
+	summaryCmd.Flags().StringVar(&summaryFormat, "format", "table", "输出格式（table/json）")
+
+	rootCmd.AddCommand(summaryCmd)
+}
+
+// reportFilter 按开始时间和主机筛选报告，零值表示不限制
+type reportFilter struct {
+	since time.Time
+	until time.Time
+	hosts []string
+}
+
+// match 报告是否满足筛选条件
+func (f reportFilter) match(report *models.BackupReport) bool {
+	if !f.since.IsZero() && report.StartTime.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !report.StartTime.Before(f.until) {
+		return false
+	}
+	return len(f.hosts) == 0 || slices.Contains(f.hosts, report.Host)
+}
+
+// readReportFile 读取报告文件，返回报告和无法解析而跳过的行数。
+// .jsonl文件逐行解析，其他文件作为单个报告解析
+func readReportFile(path string) ([]*models.BackupReport, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("打开报告文件失败: %w", err)
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(path, ".jsonl") {
+		var report models.BackupReport
+		if err := json.NewDecoder(file).Decode(&report); err != nil {
+			return nil, 0, fmt.Errorf("解析报告文件%s失败: %w", path, err)
+		}
+		return []*models.BackupReport{&report}, 0, nil
+	}
+
+	var reports []*models.BackupReport
+	invalid := 0
+	// 报告包含上传文件列表，单行可能很长，不使用有行长度限制的bufio.Scanner
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var report models.BackupReport
+			if jsonErr := json.Unmarshal(line, &report); jsonErr != nil {
+				invalid++
+			} else {
+				reports = append(reports, &report)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("读取报告文件%s失败: %w", path, err)
+		}
+	}
+	return reports, invalid, nil
+}
+
+// summarizeReports 汇总满足筛选条件的报告
+func summarizeReports(reports []*models.BackupReport, filter reportFilter) *models.ReportSummary {
+	summary := &models.ReportSummary{Hosts: []models.HostSummary{}, Failures: []models.ReportFailure{}}
+	hosts := make(map[string]*models.HostSummary)
+	durations := make(map[string]time.Duration)
+	var total time.Duration
+
+	for _, report := range reports {
+		if !filter.match(report) {
+			continue
+		}
+		host, exists := hosts[report.Host]
+		if !exists {
+			host = &models.HostSummary{Host: report.Host}
+			hosts[report.Host] = host
+		}
+
+		duration := report.EndTime.Sub(report.StartTime)
+		var uploaded int64
+		if report.Result != nil {
+			uploaded = report.Result.UploadedBytes
+		}
+
+		summary.Runs++
+		summary.UploadedBytes += uploaded
+		total += duration
+		host.Runs++
+		host.UploadedBytes += uploaded
+		durations[report.Host] += duration
+		if report.StartTime.After(host.LastRun) {
+			host.LastRun = report.StartTime
+		}
+
+		if report.Success {
+			summary.Succeeded++
+			host.Succeeded++
+			if report.StartTime.After(host.LastSuccess) {
+				host.LastSuccess = report.StartTime
+			}
+			continue
+		}
+		failure := models.ReportFailure{
+			Host:      report.Host,
+			RunID:     report.RunID,
+			StartTime: report.StartTime,
+			Error:     report.Error,
+		}
+		if report.Result != nil {
+			failure.FailedArchives = report.Result.ErrorArchives
+		}
+		summary.Failures = append(summary.Failures, failure)
+	}
+
+	if summary.Runs > 0 {
+		summary.SuccessRate = float64(summary.Succeeded) / float64(summary.Runs)
+		summary.AverageDuration = total / time.Duration(summary.Runs)
+	}
+	for name, host := range hosts {
+		host.SuccessRate = float64(host.Succeeded) / float64(host.Runs)
+		host.AverageDuration = durations[name] / time.Duration(host.Runs)
+		summary.Hosts = append(summary.Hosts, *host)
+	}
+	sort.Slice(summary.Hosts, func(i, j int) bool {
+		return summary.Hosts[i].Host < summary.Hosts[j].Host
+	})
+	sort.SliceStable(summary.Failures, func(i, j int) bool {
+		return summary.Failures[i].StartTime.Before(summary.Failures[j].StartTime)
+	})
+	return summary
+}
+
+// printReportSummary 以表格形式输出汇总结果
+func printReportSummary(summary *models.ReportSummary) {
+	if summary.InvalidLines > 0 {
+		fmt.Printf("跳过了%d行无法解析的报告\n", summary.InvalidLines)
+	}
+	if summary.Runs == 0 {
+		fmt.Printf("没有符合条件的运行报告\n")
+		return
+	}
+
+	fmt.Printf("运行次数: %d\n", summary.Runs)
+	fmt.Printf("成功率: %.1f%%（%d/%d）\n", summary.SuccessRate*100, summary.Succeeded, summary.Runs)
+	fmt.Printf("上传大小: %s\n", formatSize(summary.UploadedBytes))
+	fmt.Printf("平均耗时: %v\n\n", summary.AverageDuration.Round(time.Second))
+
+	// 表头使用ASCII，避免宽字符导致tabwriter对齐错乱
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tRUNS\tSUCCESS\tUPLOADED\tAVG DURATION\tLAST SUCCESS")
+	for _, host := range summary.Hosts {
+		lastSuccess := "-"
+		if !host.LastSuccess.IsZero() {
+			lastSuccess = host.LastSuccess.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%s\t%v\t%s\n", host.Host, host.Runs, host.SuccessRate*100,
+			formatSize(host.UploadedBytes), host.AverageDuration.Round(time.Second), lastSuccess)
+	}
+	w.Flush()
+
+	if len(summary.Failures) == 0 {
+		return
+	}
+	fmt.Printf("\n失败的运行:\n")
+	for _, failure := range summary.Failures {
+		fmt.Printf("  - %s %s（%s）: %s\n", failure.StartTime.Local().Format("2006-01-02 15:04"), failure.Host, failure.RunID, failure.Error)
+		for _, archive := range failure.FailedArchives {
+			fmt.Printf("      %s\n", archive)
+		}
+	}
+}
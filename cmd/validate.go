@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// validate检查失败时的退出码，CI据此区分失败的原因；其他错误的退出码为1
+const (
+	exitInvalidFlags = 2 // 选项无效
+	exitLocalCheck   = 3 // chunk目录或临时目录不可用
+	exitRemoteCheck  = 4 // 远程存储不可达，或缺少命令需要的备份元数据
+)
+
+// exitError 带退出码的错误，Execute按code退出
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// exitCode 返回错误对应的进程退出码
+func exitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return 1
+}
+
+// validateModes 可以检查的命令，值表示该命令是否需要远程已有备份元数据
+var validateModes = map[string]bool{
+	"full":        false,
+	"incremental": true,
+	"auto":        false,
+	"verify":      true,
+	"restore":     true,
+	"prune":       true,
+	"gc":          true,
+	"clone":       true,
+	"regroup":     true,
+	"describe":    true,
+}
+
+// validateCmd 按指定命令的选项检查配置，不执行该命令
+var validateCmd = &cobra.Command{
+	Use:   "validate <命令> [选项...]",
+	Short: "检查命令的选项、本地路径和远程存储，不执行备份",
+	Long: `按指定命令的选项执行与该命令相同的全部配置校验，再检查chunk目录（目录名与--hex-digits一致）、
+临时目录可写、远程存储可达，以及命令需要时远程已有备份元数据。逐项输出结果后退出，不扫描、不打包，也不修改远程存储，
+用于在CI或部署cron任务前发现配置错误。
+退出码：0全部通过，2选项无效，3本地路径检查失败，4远程存储检查失败。`,
+	Example: `  # 检查增量备份的配置
+  backuper validate incremental --chunk-path /path/to/.chunk --remote-path remote:backup
+
+  # 检查全量备份的分组选项
+  backuper validate full --chunk-path /path/to/.chunk --remote-path remote:backup --prefix-digits 3 --hex-digits 4`,
+	// 选项属于被检查的命令，由该命令解析；检查结果已逐项输出，失败时不再打印用法
+	DisableFlagParsing: true,
+	SilenceUsage:       true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+			return cmd.Help()
+		}
+		target, flags, err := rootCmd.Find(args)
+		if err != nil {
+			return &exitError{code: exitInvalidFlags, err: fmt.Errorf("配置无效: %w", err)}
+		}
+		needsMetadata, ok := validateModes[target.Name()]
+		if !ok || target == rootCmd {
+			return &exitError{code: exitInvalidFlags, err: fmt.Errorf("配置无效: 不支持检查命令%s", target.Name())}
+		}
+		if err := target.ParseFlags(flags); err != nil {
+			return &exitError{code: exitInvalidFlags, err: fmt.Errorf("配置无效: %w", err)}
+		}
+		if target.Name() == "incremental" && autoFull {
+			needsMetadata = false
+		}
+		return runValidate(target.Name(), needsMetadata)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
+
+// runValidate 逐项检查并输出结果。选项无效时无法继续，其余检查全部执行后按第一类失败返回退出码
+func runValidate(mode string, needsMetadata bool) error {
+	config, err := buildConfig(mode)
+	if !reportCheck("选项", mode+"命令的选项有效", err) {
+		return &exitError{code: exitInvalidFlags, err: fmt.Errorf("配置无效: %w", err)}
+	}
+
+	localOK := true
+	if mode == "full" || mode == "incremental" || mode == "auto" {
+		detail, err := checkChunkDirectories(config)
+		localOK = reportCheck("chunk目录", detail, err) && localOK
+	}
+	err = prepareTempPaths(config.TempPaths)
+	localOK = reportCheck("临时目录", strings.Join(config.TempPaths, ", ")+" 可写", err) && localOK
+
+	remoteOK := checkRemote(config, needsMetadata)
+
+	switch {
+	case !localOK:
+		return &exitError{code: exitLocalCheck, err: fmt.Errorf("本地路径检查失败")}
+	case !remoteOK:
+		return &exitError{code: exitRemoteCheck, err: fmt.Errorf("远程存储检查失败")}
+	}
+	fmt.Println("配置检查全部通过")
+	return nil
+}
+
+// reportCheck 输出一项检查的结果，通过时返回true
+func reportCheck(name, detail string, err error) bool {
+	if err != nil {
+		fmt.Printf("[失败] %s: %v\n", name, err)
+		return false
+	}
+	fmt.Printf("[通过] %s: %s\n", name, detail)
+	return true
+}
+
+// checkChunkDirectories 确认chunk路径下有按--hex-digits命名、且匹配--include-prefix的目录。
+// 没有时统计其他位数的目录，目录名位数与--hex-digits不一致时给出提示
+func checkChunkDirectories(config *models.Config) (string, error) {
+	entries, err := os.ReadDir(config.ChunkPath)
+	if err != nil {
+		return "", fmt.Errorf("读取chunk目录失败: %w", err)
+	}
+	digits := config.HexDigits
+	if digits == 0 {
+		digits = scanner.DefaultHexDigits
+	}
+
+	pattern := scanner.ChunkDirPattern(digits, config.LooseHex)
+	counts := make(map[int]int)
+	included := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if pattern.MatchString(entry.Name()) {
+			counts[digits]++
+			if matchesPrefix(entry.Name(), config.IncludePrefixes) {
+				included++
+			}
+			continue
+		}
+		for n := 1; n <= scanner.MaxHexDigits; n++ {
+			if scanner.ChunkDirPattern(n, false).MatchString(entry.Name()) {
+				counts[n]++
+				break
+			}
+		}
+	}
+
+	switch {
+	case included > 0:
+		return fmt.Sprintf("%s 下有%d个%d位十六进制的chunk目录", config.ChunkPath, included, digits), nil
+	case counts[digits] > 0:
+		return "", fmt.Errorf("%d个chunk目录都不匹配--include-prefix %s", counts[digits], strings.Join(config.IncludePrefixes, ","))
+	}
+	for n := 1; n <= scanner.MaxHexDigits; n++ {
+		if counts[n] > 0 {
+			return "", fmt.Errorf("没有%d位十六进制的chunk目录，但有%d个%d位的目录，请检查--hex-digits", digits, counts[n], n)
+		}
+	}
+	if config.AllowEmpty {
+		return fmt.Sprintf("%s 下没有chunk目录（已使用--allow-empty）", config.ChunkPath), nil
+	}
+	return "", fmt.Errorf("%s 下没有chunk目录，请检查--chunk-path，或使用--allow-empty", config.ChunkPath)
+}
+
+// matchesPrefix 目录名是否以任一前缀开头，没有前缀时总是匹配
+func matchesPrefix(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRemote 创建存储后端并检查远程是否可达；needsMetadata为true时远程必须已有备份元数据。只读取，不修改远程存储
+func checkRemote(config *models.Config, needsMetadata bool) bool {
+	store, err := newStorage(config)
+	if !reportCheck("存储后端", config.Backend, err) {
+		return false
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	if needsMetadata {
+		if err := useLatestRun(ctx, manager, config); err != nil {
+			return reportCheck("远程存储", "", err)
+		}
+	}
+	exists, err := manager.MetadataExists(ctx)
+	if !reportCheck("远程存储", config.RemotePath+" 可以访问", err) {
+		return false
+	}
+
+	switch {
+	case exists:
+		return reportCheck("备份元数据", "已存在", nil)
+	case needsMetadata:
+		return reportCheck("备份元数据", "", fmt.Errorf("远程没有备份元数据，请先执行全量备份或检查--remote-path"))
+	}
+	return reportCheck("备份元数据", "不存在，将创建新的备份", nil)
+}
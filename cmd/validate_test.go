@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pbs-backuper/internal/models"
+)
+
+// TestCheckChunkDirectories 测试chunk目录检查识别--hex-digits和--include-prefix与实际目录不一致
+func TestCheckChunkDirectories(t *testing.T) {
+	chunkDir := t.TempDir()
+	for _, name := range []string{"0000", "00ff", "abcd", "other"} {
+		if err := os.Mkdir(filepath.Join(chunkDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		config  models.Config
+		wantErr string
+	}{
+		{"默认4位", models.Config{}, ""},
+		{"匹配前缀", models.Config{IncludePrefixes: []string{"AB"}}, ""},
+		{"位数不一致", models.Config{HexDigits: 3}, "--hex-digits"},
+		{"前缀不匹配", models.Config{IncludePrefixes: []string{"ff"}}, "--include-prefix"},
+	}
+	for _, tc := range cases {
+		tc.config.ChunkPath = chunkDir
+		_, err := checkChunkDirectories(&tc.config)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: 不应失败: %v", tc.name, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: 应提示%s，实际 %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	empty := models.Config{ChunkPath: t.TempDir()}
+	if _, err := checkChunkDirectories(&empty); err == nil {
+		t.Error("没有chunk目录时应失败")
+	}
+	empty.AllowEmpty = true
+	if _, err := checkChunkDirectories(&empty); err != nil {
+		t.Errorf("使用--allow-empty时不应失败: %v", err)
+	}
+}
+
+// TestExitCode 测试带退出码的错误经过包装后仍按原退出码退出
+func TestExitCode(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &exitError{code: exitRemoteCheck, err: errors.New("unreachable")})
+	if got := exitCode(err); got != exitRemoteCheck {
+		t.Errorf("退出码 = %d，预期 %d", got, exitRemoteCheck)
+	}
+	if got := exitCode(errors.New("other")); got != 1 {
+		t.Errorf("其他错误的退出码 = %d，预期 1", got)
+	}
+}
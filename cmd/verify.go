@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+var (
+	verifyConcurrency int
+	onMismatch        string
+	adaptiveConc      bool
+	minConcurrency    int
+	maxConcurrency    int
+	providerHash      bool
+)
+
+// verifyCmd 校验命令
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "校验远程压缩包的完整性",
+	Long: `下载远程存储中的每个压缩包，计算SHA256并与备份元数据比对。
+使用--concurrency同时校验多个压缩包，结果按压缩包名称排序输出；
+--adaptive-concurrency从--min-concurrency开始，按观测到的下载吞吐量在--max-concurrency以内自动增加并发。
+任一压缩包校验失败时命令以非零状态退出。
+--on-checksum-mismatch repair会用--chunk-path中的当前数据重新生成并上传失败的压缩包，全部修复后正常退出；
+fail在发现第一个失败时立即停止。
+--provider-hash比较备份时记录的CRC32C/MD5与云存储服务端记录的哈希（rclone lsjson --hash），不下载压缩包；
+没有记录哈希的旧备份、服务端没有可比较哈希的对象以及不支持的后端仍下载校验SHA256。该方式不校验签名等附加文件。`,
+	Example: `  # 同时校验8个压缩包
+  backuper verify --remote-path remote:backup --concurrency 8
+
+  # 自动调整并发数（1到16）
+  backuper verify --remote-path remote:backup --adaptive-concurrency --max-concurrency 16
+
+  # 只比较服务端记录的哈希，不下载压缩包
+  backuper verify --remote-path gcs:bucket/backup --provider-hash
+
+  # 校验并用当前chunk目录修复损坏的压缩包
+  backuper verify --remote-path remote:backup --chunk-path /path/to/.chunk --on-checksum-mismatch repair`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := buildConfig("verify")
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+		if verifyConcurrency < 1 {
+			return fmt.Errorf("配置无效: concurrency必须大于0，得到%d", verifyConcurrency)
+		}
+		if adaptiveConc && (minConcurrency < 1 || maxConcurrency < minConcurrency) {
+			return fmt.Errorf("配置无效: 自适应并发范围无效（%d-%d），需要1 <= min-concurrency <= max-concurrency", minConcurrency, maxConcurrency)
+		}
+		policy, err := backup.ParseMismatchPolicy(onMismatch)
+		if err != nil {
+			return fmt.Errorf("配置无效: %w", err)
+		}
+		if policy == backup.MismatchRepair && config.ChunkPath == "" {
+			return fmt.Errorf("配置无效: --on-checksum-mismatch repair需要--chunk-path")
+		}
+		config.ProviderHash = providerHash
+
+		return runVerify(config, policy)
+	},
+}
+
+func init() {
+	verifyCmd.Flags().IntVar(&verifyConcurrency, "concurrency", backup.DefaultVerifyConcurrency, "同时校验的压缩包数")
+	verifyCmd.Flags().BoolVar(&adaptiveConc, "adaptive-concurrency", false, "按观测到的下载吞吐量自动调整并发数，忽略--concurrency")
+	verifyCmd.Flags().IntVar(&minConcurrency, "min-concurrency", 1, "自适应并发的起始值和下限")
+	verifyCmd.Flags().IntVar(&maxConcurrency, "max-concurrency", backup.DefaultMaxAdaptiveConcurrency, "自适应并发的上限")
+	verifyCmd.Flags().BoolVar(&providerHash, "provider-hash", false, "比较服务端记录的CRC32C/MD5，不下载压缩包；不支持时下载校验SHA256")
+	verifyCmd.Flags().StringVar(&onMismatch, "on-checksum-mismatch", string(backup.MismatchReport), "校验失败时的处理方式（report/repair/fail）")
+
+	rootCmd.AddCommand(verifyCmd)
+}
+
+// runVerify 执行校验
+func runVerify(config *models.Config, policy backup.MismatchPolicy) error {
+	// 修复会重新上传压缩包和元数据，与备份互斥
+	if policy == backup.MismatchRepair {
+		verifyLock, err := acquireLock(config)
+		if err != nil {
+			return err
+		}
+		defer verifyLock.Release()
+	}
+
+	// 初始化日志系统
+	if err := initLogger(config); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	store, err := newStorage(config)
+	if err != nil {
+		return err
+	}
+	defer closeStorage(store)
+	manager := backup.NewBackupManager(config, store)
+
+	ctx, cancel := backupContext(config)
+	defer cancel()
+
+	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	if err := useLatestRun(ctx, manager, config); err != nil {
+		return err
+	}
+
+	fmt.Printf("开始校验...\n")
+	fmt.Printf("远程路径: %s\n", config.RemotePath)
+	if adaptiveConc {
+		fmt.Printf("并发数: 自适应（%d-%d）\n", minConcurrency, maxConcurrency)
+	} else {
+		fmt.Printf("并发数: %d\n", verifyConcurrency)
+	}
+	fmt.Printf("校验失败处理: %s\n", policy)
+	if config.ProviderHash {
+		fmt.Printf("校验方式: 服务端哈希（不支持时下载校验）\n")
+	}
+
+	var result *models.VerifyResult
+	if adaptiveConc {
+		result, err = manager.RunVerifyAdaptive(ctx, minConcurrency, maxConcurrency, policy)
+	} else {
+		result, err = manager.RunVerify(ctx, verifyConcurrency, policy)
+	}
+	if err != nil && result == nil {
+		logger.Error(fmt.Sprintf("校验失败: %v", err))
+		return fmt.Errorf("校验失败: %w", err)
+	}
+
+	fmt.Printf("\n=== 校验完成 ===\n")
+	fmt.Printf("耗时: %v\n", result.Duration)
+	fmt.Printf("校验压缩包数: %d\n", result.VerifiedArchives)
+	if config.ProviderHash {
+		fmt.Printf("通过服务端哈希校验: %d\n", result.HashVerified)
+	}
+	fmt.Printf("失败压缩包数: %d\n", len(result.Mismatches))
+
+	if len(result.Mismatches) > 0 {
+		unrepaired := 0
+		fmt.Printf("\n失败:\n")
+		for _, mismatch := range result.Mismatches {
+			status := ""
+			if mismatch.Repaired {
+				status = "（已修复）"
+			} else {
+				unrepaired++
+			}
+			if mismatch.Error != "" {
+				fmt.Printf("  - %s: %s%s\n", mismatch.Archive, mismatch.Error, status)
+			} else {
+				fmt.Printf("  - %s: 预期 %s，实际 %s%s\n", mismatch.Archive, mismatch.Expected, mismatch.Actual, status)
+			}
+		}
+
+		// fail策略在第一个失败处停止，其余压缩包没有校验
+		if err != nil {
+			logger.Error(fmt.Sprintf("校验失败: %v", err))
+			return fmt.Errorf("校验在第一个失败处停止: %w", err)
+		}
+		if unrepaired > 0 {
+			return fmt.Errorf("%d个压缩包校验失败: %w", unrepaired, backup.ErrChecksumMismatch)
+		}
+		fmt.Printf("\n所有校验失败的压缩包均已修复\n")
+		return nil
+	}
+
+	fmt.Printf("\n所有压缩包校验通过！\n")
+	return nil
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pbs-backuper/internal/backup"
+	"pbs-backuper/internal/storage"
+)
+
+// version 工具版本，构建时通过 -ldflags "-X pbs-backuper/cmd.version=v1.2.3" 注入
+var version = "dev"
+
+// rcloneVersionTimeout 查询rclone版本的超时时间
+const rcloneVersionTimeout = 10 * time.Second
+
+// versionCmd 版本命令
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "显示版本信息",
+	Long: `显示工具版本、Go版本、写入的备份元数据格式版本，以及检测到的rclone版本。
+提交问题时请附上该命令的输出。`,
+	Example: `  backuper version
+  backuper version --rclone-binary /usr/local/bin/rclone`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("pbs-backuper: %s\n", version)
+		fmt.Printf("Go版本: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		fmt.Printf("元数据版本: %d\n", backup.MetadataVersion)
+
+		ctx, cancel := context.WithTimeout(context.Background(), rcloneVersionTimeout)
+		defer cancel()
+
+		rclone := storage.NewRcloneStorage(rcloneBinary, rcloneConfig, nil, false, false)
+		rcloneVersion, err := rclone.Version(ctx)
+		if err != nil {
+			fmt.Printf("rclone: 未检测到 (%s)\n", rcloneBinary)
+			return
+		}
+		fmt.Printf("rclone: %s\n", rcloneVersion)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+}
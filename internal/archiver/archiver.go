@@ -3,43 +3,161 @@ package archiver
 import (
 	"archive/tar"
 	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
 
 	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
 )
 
+// Options 压缩器可选配置
+type Options struct {
+	TarIndex         bool  // 生成tar索引，每个条目写入独立的gzip成员以支持随机访问
+	SmartCompression bool  // 采样判断压缩率，压缩效果差时以gzip级别0存储
+	ReadBufferBytes  int64 // 预读文件内容的内存上限，大于0时并行读取文件，0表示顺序读取
+	ParallelGzip     bool  // 使用pgzip多核压缩，输出仍是标准gzip流；启用TarIndex时不生效
+	HexDigits        int   // chunk目录名的十六进制位数，0表示默认的4位；目录名更长时只按前HexDigits位分组
+	PreallocateTemp  bool  // 写入前按估算大小预留压缩包的磁盘空间，空间不足时立即失败；不支持的文件系统忽略
+	HashBufferSize   int   // 计算校验和时的读取缓冲区大小（字节），0表示scanner.DefaultHashBufferSize
+	Fsync            bool  // 压缩包写完后调用fsync落盘再返回，进程或系统崩溃后保留的临时压缩包不会残缺
+
+	// ExcludePatterns 文件名匹配这些模式（filepath.Match）的文件不写入压缩包，以/结尾的模式排除匹配的子目录，
+	// 与扫描器的排除模式相同
+	ExcludePatterns []string
+
+	// Compression 压缩包格式：空或gzip表示.tar.gz，none表示不压缩的.tar（SmartCompression和ParallelGzip不生效）
+	Compression string
+
+	// ChecksumStyle 校验和文件格式：空或text、binary、tag，分别对应sha256sum默认、-b和--tag的输出
+	ChecksumStyle string
+
+	// 读取源目录的节流：每读完ThrottleDirs个目录或ThrottleBytes字节后暂停ThrottleSleep，
+	// 两个条件为0时不按该条件暂停，ThrottleSleep为0时不节流
+	ThrottleDirs  int
+	ThrottleBytes int64
+	ThrottleSleep time.Duration
+
+	// ReadRateLimit 从源目录读取文件内容的速率上限（字节/秒），按读取的原始数据计算，与压缩率无关；0表示不限制
+	ReadRateLimit int64
+}
+
 // Archiver 负责创建和管理压缩包
 type Archiver struct {
 	chunkPath string
 	tempPath  string
+	options   Options
+	throttle  *throttle
+	limiter   *rateLimiter
 }
 
 // NewArchiver 创建新的压缩器
 func NewArchiver(chunkPath, tempPath string) *Archiver {
+	return NewArchiverWithOptions(chunkPath, tempPath, Options{})
+}
+
+// NewArchiverWithOptions 使用可选配置创建压缩器
+func NewArchiverWithOptions(chunkPath, tempPath string, options Options) *Archiver {
 	return &Archiver{
 		chunkPath: chunkPath,
 		tempPath:  tempPath,
+		options:   options,
+		throttle:  newThrottle(options),
+		limiter:   newRateLimiter(options.ReadRateLimit),
 	}
 }
 
+// WithCompression 返回使用指定压缩包格式、其余配置相同的压缩器，
+// 用于按元数据记录的格式生成与上次备份一致的压缩包名
+func (a *Archiver) WithCompression(compression string) *Archiver {
+	copied := *a
+	copied.options.Compression = compression
+	return &copied
+}
+
+// WithChunkPath 返回从指定目录读取chunk目录、其余配置相同的压缩器，
+// 用于从解压的旧压缩包重新打包
+func (a *Archiver) WithChunkPath(chunkPath string) *Archiver {
+	copied := *a
+	copied.chunkPath = chunkPath
+	return &copied
+}
+
+// excluded 判断Walk遍历到的条目是否因排除模式不写入压缩包：文件按文件名匹配文件排除模式，
+// 子目录按目录名匹配目录排除模式，遍历的起点root本身不排除
+func (a *Archiver) excluded(root, file string, info os.FileInfo) bool {
+	if info.IsDir() {
+		return file != root && scanner.MatchExcludeDir(a.options.ExcludePatterns, info.Name())
+	}
+	return scanner.MatchExclude(a.options.ExcludePatterns, info.Name())
+}
+
+// skipExcluded 返回Walk回调跳过排除的条目时的返回值，排除的目录跳过整个子树
+func skipExcluded(info os.FileInfo) error {
+	if info.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// uncompressed 判断是否写入不压缩的tar包
+func (a *Archiver) uncompressed() bool {
+	return a.options.Compression == CompressionNone
+}
+
+// ValidatePrefixDigits 检查分组前缀位数是否在1到chunk目录名的十六进制位数之间，hexDigits为0时使用默认的4位。
+// 前缀不能比目录名更长，否则无法从目录名取出前缀
+func ValidatePrefixDigits(prefixDigits, hexDigits int) error {
+	if hexDigits == 0 {
+		hexDigits = scanner.DefaultHexDigits
+	}
+	if prefixDigits < 1 {
+		return fmt.Errorf("prefix digits must be between 1 and %d, got %d", hexDigits, prefixDigits)
+	}
+	if prefixDigits > hexDigits {
+		return fmt.Errorf("prefix digits %d exceed the %d hex digits of chunk directory names, must be between 1 and %d", prefixDigits, hexDigits, hexDigits)
+	}
+	return nil
+}
+
+// hexDigits 返回chunk目录名的十六进制位数
+func (a *Archiver) hexDigits() int {
+	if a.options.HexDigits == 0 {
+		return scanner.DefaultHexDigits
+	}
+	return a.options.HexDigits
+}
+
 // GenerateArchiveGroups 根据前缀位数生成压缩包分组
 func (a *Archiver) GenerateArchiveGroups(directories []string, prefixDigits int) ([]*models.ArchiveGroup, error) {
-	if prefixDigits < 1 || prefixDigits > 4 {
-		return nil, fmt.Errorf("prefix digits must be between 1 and 4, got %d", prefixDigits)
+	return a.GenerateBatchedArchiveGroups(directories, prefixDigits, 0)
+}
+
+// GenerateBatchedArchiveGroups 根据前缀位数生成压缩包分组，batchSize大于0时再将每个分组
+// 按目录编号对齐切分为最多batchSize个目录的子分组（如0000-003f、0040-007f），
+// 子分组按实际范围命名。按编号对齐而不是按目录个数切分，新增目录不会改变其他子分组的范围
+func (a *Archiver) GenerateBatchedArchiveGroups(directories []string, prefixDigits, batchSize int) ([]*models.ArchiveGroup, error) {
+	if err := ValidatePrefixDigits(prefixDigits, a.options.HexDigits); err != nil {
+		return nil, err
+	}
+	digits := a.hexDigits()
+	if batchSize < 0 {
+		return nil, fmt.Errorf("directory batch size must not be negative, got %d", batchSize)
 	}
 
 	// 将目录按前缀分组
 	groupMap := make(map[string][]string)
 
 	for _, dir := range directories {
-		if len(dir) != 4 {
+		if len(dir) < digits {
 			continue // 跳过不符合格式的目录
 		}
 
@@ -51,11 +169,11 @@ func (a *Archiver) GenerateArchiveGroups(directories []string, prefixDigits int)
 
 	// 为每个前缀创建压缩包分组
 	for prefix, dirs := range groupMap {
-		sort.Strings(dirs) // 确保目录顺序一致
+		scanner.SortHex(dirs) // 按十六进制数值排序，确保目录顺序和tar条目顺序一致
 
 		// 计算范围
 		startRange, endRange := a.calculateRange(prefix, prefixDigits)
-		archiveName := fmt.Sprintf("%s-%s.tar.gz", startRange, endRange)
+		archiveName := startRange + "-" + endRange + ArchiveExtension(a.options.Compression)
 
 		group := &models.ArchiveGroup{
 			Prefix:      prefix,
@@ -66,34 +184,205 @@ func (a *Archiver) GenerateArchiveGroups(directories []string, prefixDigits int)
 			NeedsUpdate: false,
 		}
 
-		groups = append(groups, group)
+		if batchSize > 0 {
+			groups = append(groups, splitGroup(group, batchSize, digits, ArchiveExtension(a.options.Compression))...)
+		} else {
+			groups = append(groups, group)
+		}
 	}
 
-	// 按前缀排序
+	// 按前缀的十六进制数值排序，同一前缀的子分组按范围排序
 	sort.Slice(groups, func(i, j int) bool {
-		return groups[i].Prefix < groups[j].Prefix
+		if groups[i].Prefix != groups[j].Prefix {
+			return scanner.HexLess(groups[i].Prefix, groups[j].Prefix)
+		}
+		return scanner.HexLess(groups[i].StartRange, groups[j].StartRange)
 	})
 
+	// 在任何上传之前检查压缩包名冲突，避免远程文件被互相覆盖
+	if err := validateArchiveNames(groups); err != nil {
+		return nil, err
+	}
+
 	return groups, nil
 }
 
+// validateArchiveNames 检查是否有两个分组使用相同的压缩包名。
+// 比较时忽略大小写，因为部分远程存储（如Windows文件系统）不区分大小写，
+// 例如目录ab00与AB00会生成ab00-abff.tar.gz与AB00-ABff.tar.gz并在远程互相覆盖。
+func validateArchiveNames(groups []*models.ArchiveGroup) error {
+	seen := make(map[string]*models.ArchiveGroup)
+	for _, group := range groups {
+		key := strings.ToLower(group.ArchiveName)
+		if existing, exists := seen[key]; exists {
+			return fmt.Errorf("duplicate archive name %s: prefixes %q and %q resolve to the same archive", group.ArchiveName, existing.Prefix, group.Prefix)
+		}
+		seen[key] = group
+	}
+	return nil
+}
+
+// splitGroup 将分组按目录编号切分为每batchSize个编号一段的子分组，只保留有目录的子分组。
+// 目录编号取目录名的前digits位，不是十六进制时无法确定编号，整个分组保持不变；子分组使用ext作为扩展名
+func splitGroup(group *models.ArchiveGroup, batchSize, digits int, ext string) []*models.ArchiveGroup {
+	start, err := strconv.ParseUint(group.StartRange, 16, 64)
+	if err != nil {
+		return []*models.ArchiveGroup{group}
+	}
+	end, err := strconv.ParseUint(group.EndRange, 16, 64)
+	if err != nil {
+		return []*models.ArchiveGroup{group}
+	}
+
+	slots := make(map[uint64][]string)
+	for _, dir := range group.Directories {
+		value, err := strconv.ParseUint(dir[:digits], 16, 64)
+		if err != nil {
+			return []*models.ArchiveGroup{group}
+		}
+		slot := value / uint64(batchSize)
+		slots[slot] = append(slots[slot], dir)
+	}
+
+	var groups []*models.ArchiveGroup
+	for slot, dirs := range slots {
+		// 子分组范围按批次对齐，并限制在原分组范围内
+		slotStart := max(slot*uint64(batchSize), start)
+		slotEnd := min(slot*uint64(batchSize)+uint64(batchSize)-1, end)
+		startRange := fmt.Sprintf("%0*x", digits, slotStart)
+		endRange := fmt.Sprintf("%0*x", digits, slotEnd)
+
+		groups = append(groups, &models.ArchiveGroup{
+			Prefix:      group.Prefix,
+			StartRange:  startRange,
+			EndRange:    endRange,
+			ArchiveName: startRange + "-" + endRange + ext,
+			Directories: dirs,
+		})
+	}
+	return groups
+}
+
+// PlanMergedRanges 按目录大小规划相邻小分组的合并：从前往后累计连续的、小于minSize的分组，
+// 累计大小达到minSize时结束一段，本身达到minSize的分组不参与合并。
+// 返回包含两个及以上分组的合并范围（如"0000-02ff"），由MergeArchiveGroups按范围合并。sizes的key为chunk目录名
+func PlanMergedRanges(groups []*models.ArchiveGroup, sizes map[string]int64, minSize int64) []string {
+	var ranges []string
+	var run []*models.ArchiveGroup
+	var runSize int64
+	flush := func() {
+		if len(run) > 1 {
+			ranges = append(ranges, run[0].StartRange+"-"+run[len(run)-1].EndRange)
+		}
+		run = nil
+		runSize = 0
+	}
+
+	for _, group := range groups {
+		var size int64
+		for _, dir := range group.Directories {
+			size += sizes[dir]
+		}
+		if size >= minSize {
+			flush()
+			continue
+		}
+		run = append(run, group)
+		runSize += size
+		if runSize >= minSize {
+			flush()
+		}
+	}
+	flush()
+	return ranges
+}
+
+// MergeArchiveGroups 将落在同一合并范围内的分组合并为一个分组，压缩包按合并范围命名（如0000-02ff.tar.gz）。
+// 合并范围记录在元数据中，增量备份、恢复和校验按同样的范围合并，新增目录落入范围时加入合并后的压缩包；
+// 只有部分分组落在范围内时（如恢复单个目录）仍按范围命名。groups需按范围排序
+func (a *Archiver) MergeArchiveGroups(groups []*models.ArchiveGroup, ranges []string) ([]*models.ArchiveGroup, error) {
+	if len(ranges) == 0 {
+		return groups, nil
+	}
+
+	bounds := make([][2]string, len(ranges))
+	for i, r := range ranges {
+		start, end, ok := strings.Cut(r, "-")
+		if !ok || start == "" || end == "" || scanner.HexLess(end, start) {
+			return nil, fmt.Errorf("invalid merged range %q", r)
+		}
+		bounds[i] = [2]string{start, end}
+	}
+
+	merged := make(map[int]*models.ArchiveGroup)
+	var result []*models.ArchiveGroup
+	for _, group := range groups {
+		index := -1
+		for i, b := range bounds {
+			if !scanner.HexLess(group.StartRange, b[0]) && !scanner.HexLess(b[1], group.EndRange) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			result = append(result, group)
+			continue
+		}
+		if existing, ok := merged[index]; ok {
+			existing.Directories = append(existing.Directories, group.Directories...)
+			existing.NeedsUpdate = existing.NeedsUpdate || group.NeedsUpdate
+			continue
+		}
+		mergedGroup := &models.ArchiveGroup{
+			Prefix:      group.Prefix,
+			StartRange:  bounds[index][0],
+			EndRange:    bounds[index][1],
+			ArchiveName: bounds[index][0] + "-" + bounds[index][1] + ArchiveExtension(a.options.Compression),
+			Directories: append([]string(nil), group.Directories...),
+			NeedsUpdate: group.NeedsUpdate,
+		}
+		merged[index] = mergedGroup
+		result = append(result, mergedGroup)
+	}
+
+	if err := validateArchiveNames(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // calculateRange 根据前缀和位数计算范围
 func (a *Archiver) calculateRange(prefix string, prefixDigits int) (string, string) {
 	// 计算开始和结束范围
-	startRange := prefix + strings.Repeat("0", 4-prefixDigits)
-	endRange := prefix + strings.Repeat("f", 4-prefixDigits)
+	digits := a.hexDigits()
+	startRange := prefix + strings.Repeat("0", digits-prefixDigits)
+	endRange := prefix + strings.Repeat("f", digits-prefixDigits)
 
 	return startRange, endRange
 }
 
-// CreateArchive 创建压缩包
+// CreateArchive 创建压缩包，启用TarIndex时同时在IndexPath(archivePath)生成索引文件。
+// group.Deduped中的文件不写入压缩包。启用SmartCompression时按采样结果选择压缩方式，
+// 选择结果记录在group.Compression中。
 func (a *Archiver) CreateArchive(group *models.ArchiveGroup) (string, error) {
+	return a.CreateArchiveIn(group, a.tempPath)
+}
+
+// CreateArchiveIn 与CreateArchive相同，但压缩包创建在指定的临时目录中，
+// 用于把多个压缩包分散到不同磁盘
+func (a *Archiver) CreateArchiveIn(group *models.ArchiveGroup, tempDir string) (archivePath string, err error) {
 	// 确保临时目录存在
-	if err := os.MkdirAll(a.tempPath, 0755); err != nil {
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
 
-	archivePath := filepath.Join(a.tempPath, group.ArchiveName)
+	archivePath = filepath.Join(tempDir, group.ArchiveName)
+
+	// 去重的文件内容存放在blob目录中，不写入压缩包
+	skip := make(map[string]bool, len(group.Deduped))
+	for _, entry := range group.Deduped {
+		skip[entry.Path] = true
+	}
 
 	// 创建tar.gz文件
 	file, err := os.Create(archivePath)
@@ -102,40 +391,141 @@ func (a *Archiver) CreateArchive(group *models.ArchiveGroup) (string, error) {
 	}
 	defer file.Close()
 
-	// 创建gzip写入器
-	gzipWriter := gzip.NewWriter(file)
+	if a.options.Fsync {
+		// 在tar和gzip写入器关闭、预留空间释放之后，文件关闭之前执行
+		defer func() {
+			if err != nil {
+				return
+			}
+			if syncErr := file.Sync(); syncErr != nil {
+				archivePath, err = "", fmt.Errorf("failed to sync archive file: %w", syncErr)
+			}
+		}()
+	}
+
+	if a.options.PreallocateTemp {
+		if err := preallocate(file, a.estimateArchiveSize(group, skip)); err != nil {
+			return "", err
+		}
+		// 在tar和gzip写入器关闭之后、文件关闭之前执行
+		defer releasePreallocated(file)
+	}
+
+	group.Compression = ""
+	level := gzip.DefaultCompression
+	format := a.options.Compression
+	if format == CompressionAuto {
+		format = a.chooseFormat(group, skip)
+		group.Compression = format
+	} else if a.uncompressed() {
+		group.Compression = CompressionNone
+	} else if a.options.SmartCompression {
+		group.Compression = a.chooseCompression(group, skip)
+		if group.Compression == CompressionStore {
+			level = gzip.NoCompression
+		}
+	}
+
+	// 创建gzip写入器，启用索引时每个条目使用独立的gzip成员；不压缩时直接写入文件
+	var gzipWriter io.WriteCloser
+	var index *indexBuilder
+	if a.options.TarIndex {
+		var members memberWriter
+		if format == CompressionNone {
+			members = &plainMemberWriter{out: &countingWriter{w: file}}
+		} else {
+			members = &gzipMemberWriter{out: &countingWriter{w: file}, level: level}
+		}
+		gzipWriter = members
+		index = &indexBuilder{
+			members: members,
+			index:   models.TarIndex{Archive: group.ArchiveName},
+		}
+	} else if format == CompressionNone {
+		gzipWriter = nopWriteCloser{file}
+	} else if format == CompressionZstd {
+		gzipWriter, err = zstd.NewWriter(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+	} else if a.options.ParallelGzip {
+		// pgzip按块并行压缩，每块独立压缩后顺序写出，结果仍是单个标准gzip成员
+		gzipWriter, err = pgzip.NewWriterLevel(file, level)
+		if err != nil {
+			return "", fmt.Errorf("failed to create parallel gzip writer: %w", err)
+		}
+	} else {
+		gzipWriter, err = gzip.NewWriterLevel(file, level)
+		if err != nil {
+			return "", fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+	}
 	defer gzipWriter.Close()
 
-	// 创建tar写入器
-	tarWriter := tar.NewWriter(gzipWriter)
+	// 创建tar写入器，经过计数写入器统计读取的字节数用于节流
+	tarStream := &countingWriter{w: gzipWriter}
+	tarWriter := tar.NewWriter(tarStream)
 	defer tarWriter.Close()
+	if index != nil {
+		index.tarWriter = tarWriter
+	}
+
+	// 启用预读时整个压缩包共用一个内存预算
+	var readAhead *byteBudget
+	if a.options.ReadBufferBytes > 0 {
+		readAhead = newByteBudget(a.options.ReadBufferBytes)
+	}
 
 	// 添加每个目录到压缩包
 	for _, dir := range group.Directories {
 		dirPath := filepath.Join(a.chunkPath, dir)
 
-		// 检查目录是否存在
-		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-			continue // 跳过不存在的目录
+		// 检查目录是否存在，扫描后被删除或替换为文件的目录跳过
+		if info, err := os.Stat(dirPath); os.IsNotExist(err) || err == nil && !info.IsDir() {
+			continue
 		}
 
 		// 将目录添加到tar包
-		err := a.addDirectoryToTar(tarWriter, dirPath, dir)
+		written := tarStream.n
+		err := a.addDirectoryToTar(tarWriter, dirPath, dir, skip, index, readAhead)
 		if err != nil {
 			return "", fmt.Errorf("failed to add directory %s to archive: %w", dir, err)
 		}
+		a.throttle.dirDone(tarStream.n - written)
+	}
+
+	if index != nil {
+		// 结束tar流，使结尾块写入最后一个成员后再计算最后一个条目的长度
+		if err := tarWriter.Close(); err != nil {
+			return "", fmt.Errorf("failed to finalize archive: %w", err)
+		}
+		end, err := index.members.cut()
+		if err != nil {
+			return "", fmt.Errorf("failed to finalize archive: %w", err)
+		}
+		index.closeLastEntry(end)
+
+		if err := writeIndexFile(IndexPath(archivePath), &index.index); err != nil {
+			return "", err
+		}
 	}
 
 	return archivePath, nil
 }
 
-// addDirectoryToTar 递归将目录添加到tar包
-func (a *Archiver) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, basePath string) error {
-	return filepath.Walk(sourcePath, func(file string, info os.FileInfo, err error) error {
+// addDirectoryToTar 递归将目录添加到tar包，跳过skip中的文件，index不为nil时同时记录条目索引。
+// readAhead不为nil时先收集条目，再在内存预算内并行读取文件内容后按顺序写入
+func (a *Archiver) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, basePath string, skip map[string]bool, index *indexBuilder, readAhead *byteBudget) error {
+	var entries []tarEntry
+	err := filepath.Walk(sourcePath, func(file string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
+		if a.excluded(sourcePath, file, info) {
+			return skipExcluded(info)
+		}
+
 		// 计算在tar包中的路径
 		relPath, err := filepath.Rel(filepath.Dir(sourcePath), file)
 		if err != nil {
@@ -151,6 +541,27 @@ func (a *Archiver) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, basePath
 		// 设置名称，使用正斜杠作为分隔符（tar标准）
 		header.Name = filepath.ToSlash(relPath)
 
+		if skip[header.Name] {
+			return nil
+		}
+
+		// 使用PAX格式保留亚秒级修改时间，恢复后的文件树才能与元数据一致；
+		// 访问时间和变更时间会随读取变化，清空以保证压缩包内容可重复
+		header.Format = tar.FormatPAX
+		header.AccessTime = time.Time{}
+		header.ChangeTime = time.Time{}
+
+		if readAhead != nil {
+			entries = append(entries, tarEntry{header: header, path: file})
+			return nil
+		}
+
+		if index != nil {
+			if err := index.beginEntry(header); err != nil {
+				return err
+			}
+		}
+
 		// 写入头
 		if err := tarWriter.WriteHeader(header); err != nil {
 			return err
@@ -164,7 +575,7 @@ func (a *Archiver) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, basePath
 			}
 			defer fileData.Close()
 
-			_, err = io.Copy(tarWriter, fileData)
+			_, err = io.Copy(tarWriter, a.limiter.reader(fileData))
 			if err != nil {
 				return err
 			}
@@ -172,6 +583,11 @@ func (a *Archiver) addDirectoryToTar(tarWriter *tar.Writer, sourcePath, basePath
 
 		return nil
 	})
+	if err != nil || readAhead == nil {
+		return err
+	}
+
+	return writeEntriesReadAhead(tarWriter, entries, index, readAhead, a.limiter)
 }
 
 // CalculateChecksum 计算文件的SHA256校验和
@@ -182,12 +598,12 @@ func (a *Archiver) CalculateChecksum(filePath string) (string, error) {
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
+	checksum, err := scanner.HashReader(file, a.options.HashBufferSize)
+	if err != nil {
 		return "", fmt.Errorf("failed to calculate checksum: %w", err)
 	}
 
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return checksum, nil
 }
 
 // CreateChecksumFile 创建校验和文件
@@ -200,9 +616,8 @@ func (a *Archiver) CreateChecksumFile(archivePath, checksum string) (string, err
 	}
 	defer file.Close()
 
-	// 写入校验和（格式：<checksum>  <filename>）
-	archiveName := filepath.Base(archivePath)
-	content := fmt.Sprintf("%s  %s\n", checksum, archiveName)
+	// 写入校验和，默认格式为<checksum>  <filename>
+	content := FormatChecksumLine(a.options.ChecksumStyle, checksum, filepath.Base(archivePath))
 
 	if _, err := file.WriteString(content); err != nil {
 		return "", fmt.Errorf("failed to write checksum: %w", err)
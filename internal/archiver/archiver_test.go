@@ -1,9 +1,21 @@
 package archiver
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"pbs-backuper/internal/models"
 )
@@ -242,3 +254,1182 @@ func TestMarkGroupsForUpdate(t *testing.T) {
 
 	t.Log("分组更新标记测试通过")
 }
+
+// TestTarIndex 测试tar索引生成与按索引解压
+func TestTarIndex(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	tempDir := filepath.Join(testDir, "temp")
+
+	files := map[string]string{
+		"0000/a.chunk":       "content of a",
+		"0000/b.chunk":       "content of b, a bit longer",
+		"0001/sub/c.chunk":   "nested content",
+		"0001/d.chunk":       "",
+		"0002/large.chunk":   string(make([]byte, 5000)),
+		"0002/zz/last.chunk": "last entry",
+	}
+	for name, content := range files {
+		path := filepath.Join(chunkDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+
+	archiver := NewArchiverWithOptions(chunkDir, tempDir, Options{TarIndex: true})
+	groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0001", "0002"}, 2)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+
+	archivePath, err := archiver.CreateArchive(groups[0])
+	if err != nil {
+		t.Fatalf("创建压缩包失败: %v", err)
+	}
+
+	index, err := LoadIndex(IndexPath(archivePath))
+	if err != nil {
+		t.Fatalf("读取索引失败: %v", err)
+	}
+
+	// 多成员gzip仍然是标准gzip流，可以被完整顺序解压
+	fullDir := filepath.Join(testDir, "full")
+	count, err := ExtractArchive(archivePath, fullDir, nil)
+	if err != nil {
+		t.Fatalf("顺序解压失败: %v", err)
+	}
+	if count != len(index.Entries) {
+		t.Errorf("顺序解压条目数 %d 与索引条目数 %d 不一致", count, len(index.Entries))
+	}
+
+	// 按索引逐个解压文件
+	for name, content := range files {
+		entry, found := FindEntry(index, name)
+		if !found {
+			t.Errorf("索引中缺少条目 %s", name)
+			continue
+		}
+		if entry.Length <= 0 {
+			t.Errorf("条目 %s 的压缩长度无效: %d", name, entry.Length)
+		}
+
+		entryDir := filepath.Join(testDir, "entry")
+		if err := ExtractEntry(archivePath, entry, entryDir); err != nil {
+			t.Fatalf("按索引解压 %s 失败: %v", name, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(entryDir, name))
+		if err != nil {
+			t.Fatalf("读取解压文件失败: %v", err)
+		}
+		if string(data) != content {
+			t.Errorf("条目 %s 内容不匹配", name)
+		}
+	}
+}
+
+// TestSmartCompression 测试按采样压缩率选择压缩方式，以及未压缩tar包的解压
+func TestSmartCompression(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	tempDir := filepath.Join(testDir, "temp")
+
+	random := make([]byte, 32*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("生成随机数据失败: %v", err)
+	}
+	files := map[string][]byte{
+		"0000/random.chunk": random,
+		"0100/zeros.chunk":  make([]byte, 32*1024),
+	}
+	for name, content := range files {
+		path := filepath.Join(chunkDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+
+	archiver := NewArchiverWithOptions(chunkDir, tempDir, Options{SmartCompression: true})
+	groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0100"}, 2)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+
+	expected := []string{CompressionStore, CompressionGzip}
+	for i, group := range groups {
+		archivePath, err := archiver.CreateArchive(group)
+		if err != nil {
+			t.Fatalf("创建压缩包失败: %v", err)
+		}
+		if group.Compression != expected[i] {
+			t.Errorf("压缩包 %s 的压缩方式为 %s，期望 %s", group.ArchiveName, group.Compression, expected[i])
+		}
+
+		info, err := os.Stat(archivePath)
+		if err != nil {
+			t.Fatalf("读取压缩包信息失败: %v", err)
+		}
+		if group.Compression == CompressionStore && info.Size() < int64(len(random)) {
+			t.Errorf("存储方式的压缩包大小 %d 小于原始数据 %d", info.Size(), len(random))
+		}
+
+		destDir := filepath.Join(testDir, "restore")
+		if _, err := ExtractArchive(archivePath, destDir, nil); err != nil {
+			t.Fatalf("解压 %s 失败: %v", group.ArchiveName, err)
+		}
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(testDir, "restore", name))
+		if err != nil {
+			t.Fatalf("读取解压文件失败: %v", err)
+		}
+		if string(data) != string(content) {
+			t.Errorf("文件 %s 内容不匹配", name)
+		}
+	}
+
+	// 不带gzip封装的tar包也可以解压
+	tarPath := filepath.Join(testDir, "plain.tar")
+	tarFile, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatalf("创建tar文件失败: %v", err)
+	}
+	tarWriter := tar.NewWriter(tarFile)
+	content := []byte("plain tar content")
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "0200/plain.chunk", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("写入tar头失败: %v", err)
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		t.Fatalf("写入tar内容失败: %v", err)
+	}
+	tarWriter.Close()
+	tarFile.Close()
+
+	plainDir := filepath.Join(testDir, "plain")
+	if count, err := ExtractArchive(tarPath, plainDir, nil); err != nil || count != 1 {
+		t.Fatalf("解压未压缩tar失败: count=%d, err=%v", count, err)
+	}
+	data, err := os.ReadFile(filepath.Join(plainDir, "0200", "plain.chunk"))
+	if err != nil || string(data) != string(content) {
+		t.Errorf("未压缩tar解压内容不匹配: %q, %v", data, err)
+	}
+}
+
+// TestReadAheadBudget 测试并行预读时已读入内存的数据不超过预算，且压缩包内容与顺序写入一致
+func TestReadAheadBudget(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	tempDir := filepath.Join(testDir, "temp")
+
+	const limit = 10000
+	files := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		files[filepath.ToSlash(filepath.Join("0000", string(rune('a'+i))+".chunk"))] = []byte(strings.Repeat(string(rune('a'+i)), 3000))
+	}
+	files["0000/sub/large.chunk"] = []byte(strings.Repeat("L", 3*limit)) // 超过预算，直接流式写入
+	files["0000/sub/empty.chunk"] = nil
+	for name, content := range files {
+		path := filepath.Join(chunkDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+
+	// 直接调用以检查预算的峰值占用
+	archiver := NewArchiverWithOptions(chunkDir, tempDir, Options{ReadBufferBytes: limit})
+	budget := newByteBudget(limit)
+	tarWriter := tar.NewWriter(io.Discard)
+	if err := archiver.addDirectoryToTar(tarWriter, filepath.Join(chunkDir, "0000"), "0000", nil, nil, budget); err != nil {
+		t.Fatalf("写入tar失败: %v", err)
+	}
+	if budget.peak > limit {
+		t.Errorf("预读占用峰值 %d 超过预算 %d", budget.peak, limit)
+	}
+	if budget.peak == 0 {
+		t.Error("没有文件经过预读")
+	}
+	if budget.used != 0 {
+		t.Errorf("写入完成后仍占用 %d 字节额度", budget.used)
+	}
+
+	// 完整创建压缩包（同时生成索引）并校验内容
+	archiver = NewArchiverWithOptions(chunkDir, tempDir, Options{ReadBufferBytes: limit, TarIndex: true})
+	groups, err := archiver.GenerateArchiveGroups([]string{"0000"}, 2)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	archivePath, err := archiver.CreateArchive(groups[0])
+	if err != nil {
+		t.Fatalf("创建压缩包失败: %v", err)
+	}
+
+	destDir := filepath.Join(testDir, "restore")
+	if _, err := ExtractArchive(archivePath, destDir, nil); err != nil {
+		t.Fatalf("解压失败: %v", err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(destDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("读取解压文件失败: %v", err)
+		}
+		if string(data) != string(content) {
+			t.Errorf("文件 %s 内容不匹配", name)
+		}
+	}
+
+	index, err := LoadIndex(IndexPath(archivePath))
+	if err != nil {
+		t.Fatalf("读取索引失败: %v", err)
+	}
+	entry, found := FindEntry(index, "0000/sub/large.chunk")
+	if !found {
+		t.Fatal("索引中缺少大文件条目")
+	}
+	if err := ExtractEntry(archivePath, entry, filepath.Join(testDir, "entry")); err != nil {
+		t.Fatalf("按索引解压失败: %v", err)
+	}
+}
+
+// TestBatchedArchiveGroups 测试按目录批次切分分组
+func TestBatchedArchiveGroups(t *testing.T) {
+	archiver := NewArchiver("/tmp", "/tmp")
+
+	var directories []string
+	for i := 0; i < 0x100; i++ {
+		directories = append(directories, fmt.Sprintf("%04x", i))
+	}
+	directories = append(directories, "0100", "0105", "0250")
+
+	groups, err := archiver.GenerateBatchedArchiveGroups(directories, 2, 64)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+
+	expected := []struct {
+		name  string
+		count int
+	}{
+		{"0000-003f.tar.gz", 64},
+		{"0040-007f.tar.gz", 64},
+		{"0080-00bf.tar.gz", 64},
+		{"00c0-00ff.tar.gz", 64},
+		{"0100-013f.tar.gz", 2},
+		{"0240-027f.tar.gz", 1},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("预期 %d 个分组，实际 %d", len(expected), len(groups))
+	}
+	for i, want := range expected {
+		if groups[i].ArchiveName != want.name || len(groups[i].Directories) != want.count {
+			t.Errorf("分组 %d: 预期 %s（%d个目录），实际 %s（%d个目录）", i, want.name, want.count, groups[i].ArchiveName, len(groups[i].Directories))
+		}
+	}
+
+	// 批次大于前缀范围时与不切分的结果相同
+	groups, err = archiver.GenerateBatchedArchiveGroups([]string{"0000", "00ff"}, 2, 1024)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	if len(groups) != 1 || groups[0].ArchiveName != "0000-00ff.tar.gz" {
+		t.Errorf("批次超过前缀范围时不应切分: %+v", groups)
+	}
+
+	// 单个目录定位到的压缩包与整体分组一致，供单文件恢复使用
+	groups, err = archiver.GenerateBatchedArchiveGroups([]string{"0105"}, 2, 64)
+	if err != nil || len(groups) != 1 || groups[0].ArchiveName != "0100-013f.tar.gz" {
+		t.Errorf("单个目录应定位到0100-013f.tar.gz: %+v, %v", groups, err)
+	}
+}
+
+// TestMergeArchiveGroups 测试按大小规划并合并相邻的小分组
+func TestMergeArchiveGroups(t *testing.T) {
+	archiver := NewArchiver("/tmp", "/tmp")
+	groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0100", "0200", "0300", "0400"}, 2)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+
+	// 0000与0100累计达到20后结束一段，0200本身足够大不参与合并，末尾的0300与0400不足20也合并
+	sizes := map[string]int64{"0000": 10, "0100": 10, "0200": 100, "0300": 10, "0400": 5}
+	ranges := PlanMergedRanges(groups, sizes, 20)
+	if strings.Join(ranges, ",") != "0000-01ff,0300-04ff" {
+		t.Fatalf("合并范围不正确: %v", ranges)
+	}
+
+	merged, err := archiver.MergeArchiveGroups(groups, ranges)
+	if err != nil {
+		t.Fatalf("合并分组失败: %v", err)
+	}
+	var names []string
+	for _, group := range merged {
+		names = append(names, fmt.Sprintf("%s%v", group.ArchiveName, group.Directories))
+	}
+	if strings.Join(names, ",") != "0000-01ff.tar.gz[0000 0100],0200-02ff.tar.gz[0200],0300-04ff.tar.gz[0300 0400]" {
+		t.Errorf("合并结果不正确: %v", names)
+	}
+
+	// 单个目录按记录的范围定位到合并后的压缩包，供增量备份中的新目录和单文件恢复使用
+	groups, _ = archiver.GenerateArchiveGroups([]string{"0150"}, 2)
+	merged, err = archiver.MergeArchiveGroups(groups, ranges)
+	if err != nil || len(merged) != 1 || merged[0].ArchiveName != "0000-01ff.tar.gz" {
+		t.Errorf("0150应定位到0000-01ff.tar.gz: %+v, %v", merged, err)
+	}
+
+	if _, err := archiver.MergeArchiveGroups(groups, []string{"01ff"}); err == nil {
+		t.Error("格式错误的合并范围应该返回错误")
+	}
+}
+
+// TestHexDigitsGrouping 测试非4位目录名的datastore分组与命名
+func TestHexDigitsGrouping(t *testing.T) {
+	// 2位目录名
+	archiver := NewArchiverWithOptions("/tmp", "/tmp", Options{HexDigits: 2})
+	groups, err := archiver.GenerateArchiveGroups([]string{"00", "0a", "f3"}, 1)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	if len(groups) != 2 || groups[0].ArchiveName != "00-0f.tar.gz" || len(groups[0].Directories) != 2 || groups[1].ArchiveName != "f0-ff.tar.gz" {
+		t.Errorf("2位目录分组不正确: %+v", groups)
+	}
+	if _, err := archiver.GenerateArchiveGroups([]string{"00"}, 3); err == nil {
+		t.Error("前缀位数超过目录名位数时应返回错误")
+	}
+
+	// 8位目录名，按批次切分时范围同样使用8位
+	archiver = NewArchiverWithOptions("/tmp", "/tmp", Options{HexDigits: 8})
+	groups, err = archiver.GenerateBatchedArchiveGroups([]string{"0000abcd", "0000ab01", "0000cd00", "ffffffff"}, 4, 0x100)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	var names []string
+	for _, group := range groups {
+		names = append(names, group.ArchiveName)
+	}
+	expected := "0000ab00-0000abff.tar.gz,0000cd00-0000cdff.tar.gz,ffffff00-ffffffff.tar.gz"
+	if got := strings.Join(names, ","); got != expected {
+		t.Errorf("8位目录分组不正确: %s", got)
+	}
+
+	// 带后缀的目录按前4位分组和切分
+	archiver = NewArchiver("/tmp", "/tmp")
+	groups, err = archiver.GenerateBatchedArchiveGroups([]string{"0012", "0012.old", "0080-new"}, 2, 64)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	if len(groups) != 2 || groups[0].ArchiveName != "0000-003f.tar.gz" || len(groups[0].Directories) != 2 || groups[1].ArchiveName != "0080-00bf.tar.gz" {
+		t.Errorf("带后缀的目录分组不正确: %+v", groups)
+	}
+}
+
+// TestValidatePrefixDigits 测试前缀位数的有效范围为1到目录名的十六进制位数
+func TestValidatePrefixDigits(t *testing.T) {
+	tests := []struct {
+		prefixDigits, hexDigits int
+		valid                   bool
+	}{
+		{1, 0, true},
+		{4, 0, true},
+		{5, 0, false},
+		{0, 4, false},
+		{2, 2, true},
+		{3, 2, false},
+		{8, 8, true},
+		{6, 1, false},
+	}
+	for _, test := range tests {
+		err := ValidatePrefixDigits(test.prefixDigits, test.hexDigits)
+		if (err == nil) != test.valid {
+			t.Errorf("ValidatePrefixDigits(%d, %d) = %v, 期望有效: %v", test.prefixDigits, test.hexDigits, err, test.valid)
+		}
+	}
+
+	// 前缀位数等于目录名位数时每个目录单独成组
+	archiver := NewArchiverWithOptions("/tmp", "/tmp", Options{HexDigits: 2})
+	groups, err := archiver.GenerateArchiveGroups([]string{"0a", "f3"}, 2)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	if len(groups) != 2 || groups[0].ArchiveName != "0a-0a.tar.gz" || groups[1].ArchiveName != "f3-f3.tar.gz" {
+		t.Errorf("前缀位数等于目录名位数时分组不正确: %+v", groups)
+	}
+}
+
+// TestArchiveOrdering 测试分组、目录和tar条目按十六进制数值排序，与输入顺序和大小写无关
+func TestArchiveOrdering(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	dirs := []string{"00FF", "0010", "00a0", "01Fe", "0100", "0200"}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(chunkDir, dir), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(chunkDir, dir, "chunk"), []byte(dir), 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+
+	archiver := NewArchiver(chunkDir, filepath.Join(testDir, "temp"))
+	describe := func(groups []*models.ArchiveGroup) string {
+		var parts []string
+		for _, group := range groups {
+			parts = append(parts, group.ArchiveName+":"+strings.Join(group.Directories, ","))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	expected := "0000-003f.tar.gz:0010 0080-00bf.tar.gz:00a0 00c0-00ff.tar.gz:00FF 0100-013f.tar.gz:0100 01c0-01ff.tar.gz:01Fe 0200-023f.tar.gz:0200"
+	for _, input := range [][]string{dirs, {"0200", "01Fe", "0100", "00FF", "00a0", "0010"}} {
+		groups, err := archiver.GenerateBatchedArchiveGroups(input, 2, 64)
+		if err != nil {
+			t.Fatalf("生成分组失败: %v", err)
+		}
+		if got := describe(groups); got != expected {
+			t.Errorf("分组顺序不正确:\n期望: %s\n实际: %s", expected, got)
+		}
+	}
+
+	groups, err := archiver.GenerateArchiveGroups(dirs, 2)
+	if err != nil {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	if got := strings.Join(groups[0].Directories, ","); got != "0010,00a0,00FF" {
+		t.Fatalf("分组内目录顺序不正确: %s", got)
+	}
+
+	// tar条目按分组内的目录顺序写入
+	archivePath, err := archiver.CreateArchive(groups[0])
+	if err != nil {
+		t.Fatalf("创建压缩包失败: %v", err)
+	}
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("打开压缩包失败: %v", err)
+	}
+	defer file.Close()
+	stream, err := openArchiveStream(file)
+	if err != nil {
+		t.Fatalf("读取压缩包失败: %v", err)
+	}
+	defer stream.Close()
+
+	var order []string
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("读取tar条目失败: %v", err)
+		}
+		if dir, _, _ := strings.Cut(header.Name, "/"); len(order) == 0 || order[len(order)-1] != dir {
+			order = append(order, dir)
+		}
+	}
+	if got := strings.Join(order, ","); got != "0010,00a0,00FF" {
+		t.Errorf("tar条目顺序不正确: %v", order)
+	}
+}
+
+// TestSafeJoin 测试解压时拒绝跳出目标目录的路径
+func TestSafeJoin(t *testing.T) {
+	destDir := t.TempDir()
+	for _, name := range []string{"../evil", "0000/../../evil"} {
+		if _, err := safeJoin(destDir, name); err == nil {
+			t.Errorf("路径 %s 应该被拒绝", name)
+		}
+	}
+	if _, err := safeJoin(destDir, "0000/file"); err != nil {
+		t.Errorf("合法路径被拒绝: %v", err)
+	}
+}
+
+// TestDuplicateArchiveNames 测试分组压缩包名冲突检测
+func TestDuplicateArchiveNames(t *testing.T) {
+	tempDir := t.TempDir()
+	archiver := NewArchiver(tempDir, tempDir)
+
+	// 大小写不同的目录在不区分大小写的远程上会生成同名压缩包
+	if _, err := archiver.GenerateArchiveGroups([]string{"ab00", "AB00"}, 2); err == nil {
+		t.Error("大小写冲突的分组应该返回错误")
+	}
+
+	groups := []*models.ArchiveGroup{
+		{Prefix: "00", ArchiveName: "0000-00ff.tar.gz"},
+		{Prefix: "0x", ArchiveName: "0000-00ff.tar.gz"},
+	}
+	if err := validateArchiveNames(groups); err == nil {
+		t.Error("同名压缩包应该返回错误")
+	}
+
+	if _, err := archiver.GenerateArchiveGroups([]string{"0000", "0100", "ab00"}, 2); err != nil {
+		t.Errorf("无冲突的分组不应该返回错误: %v", err)
+	}
+}
+
+// writeCompressibleChunks 在chunkDir下创建count个大小为size的半可压缩文件（随机数据与重复文本交替）
+func writeCompressibleChunks(tb testing.TB, chunkDir string, count, size int) map[string][]byte {
+	tb.Helper()
+	files := make(map[string][]byte, count)
+	for i := 0; i < count; i++ {
+		content := make([]byte, 0, size)
+		random := make([]byte, 256)
+		for len(content) < size {
+			if _, err := rand.Read(random); err != nil {
+				tb.Fatalf("生成随机数据失败: %v", err)
+			}
+			content = append(content, random...)
+			content = append(content, strings.Repeat("pbs chunk ", 76)...)
+		}
+		content = content[:size]
+
+		name := fmt.Sprintf("%04x/chunk%d", i%2, i)
+		path := filepath.Join(chunkDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tb.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			tb.Fatalf("创建文件失败: %v", err)
+		}
+		files[name] = content
+	}
+	return files
+}
+
+// TestParallelGzip 测试并行gzip生成的压缩包可以用标准gzip解压
+func TestParallelGzip(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	files := writeCompressibleChunks(t, chunkDir, 4, 3<<20)
+
+	archiver := NewArchiverWithOptions(chunkDir, filepath.Join(testDir, "temp"), Options{ParallelGzip: true})
+	groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0001"}, 2)
+	if err != nil || len(groups) != 1 {
+		t.Fatalf("生成分组失败: %v", err)
+	}
+	archivePath, err := archiver.CreateArchive(groups[0])
+	if err != nil {
+		t.Fatalf("创建压缩包失败: %v", err)
+	}
+
+	// ExtractArchive使用标准库compress/gzip读取
+	destDir := filepath.Join(testDir, "restore")
+	if _, err := ExtractArchive(archivePath, destDir, nil); err != nil {
+		t.Fatalf("标准gzip解压失败: %v", err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatalf("读取解压文件失败: %v", err)
+		}
+		if string(data) != string(content) {
+			t.Errorf("文件 %s 内容不匹配", name)
+		}
+	}
+}
+
+// TestPreallocateTemp 测试预留空间不改变压缩包内容，估算大小不小于未压缩的tar
+func TestPreallocateTemp(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	writeCompressibleChunks(t, chunkDir, 4, 100<<10)
+
+	var archives [][]byte
+	for i, preallocate := range []bool{false, true} {
+		archiver := NewArchiverWithOptions(chunkDir, testDir, Options{Compression: CompressionNone, PreallocateTemp: preallocate})
+		groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0001"}, 2)
+		if err != nil || len(groups) != 1 {
+			t.Fatalf("生成分组失败: %v", err)
+		}
+		archivePath, err := archiver.CreateArchiveIn(groups[0], filepath.Join(testDir, fmt.Sprintf("temp%d", i)))
+		if err != nil {
+			t.Fatalf("创建压缩包失败: %v", err)
+		}
+		data, err := os.ReadFile(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, data)
+
+		if estimate := archiver.estimateArchiveSize(groups[0], nil); estimate < int64(len(data)) {
+			t.Errorf("估算大小 %d 小于实际的tar大小 %d", estimate, len(data))
+		}
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Error("预留空间后生成的压缩包与未预留时不同")
+	}
+}
+
+// TestFsyncArchive 测试写完后落盘不改变压缩包内容，与预留空间同时启用时同样如此
+func TestFsyncArchive(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	writeCompressibleChunks(t, chunkDir, 4, 100<<10)
+
+	var archives [][]byte
+	for i, fsync := range []bool{false, true} {
+		archiver := NewArchiverWithOptions(chunkDir, testDir, Options{Fsync: fsync, PreallocateTemp: true})
+		groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0001"}, 2)
+		if err != nil || len(groups) != 1 {
+			t.Fatalf("生成分组失败: %v", err)
+		}
+		archivePath, err := archiver.CreateArchiveIn(groups[0], filepath.Join(testDir, fmt.Sprintf("temp%d", i)))
+		if err != nil {
+			t.Fatalf("创建压缩包失败: %v", err)
+		}
+		data, err := os.ReadFile(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, data)
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Error("落盘后的压缩包与未落盘时不同")
+	}
+}
+
+// TestChecksumFileStyles 测试校验和文件与sha256sum各种输出格式的互通
+func TestChecksumFileStyles(t *testing.T) {
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	other := strings.Repeat("ab", 32)
+	name := "0000-00ff.tar.gz"
+	cases := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"两个空格", digest + "  " + name + "\n", false},
+		{"一个空格", digest + " " + name + "\n", false},
+		{"制表符", digest + "\t" + name + "\n", false},
+		{"二进制标记", digest + " *" + name + "\n", false},
+		{"tag格式", "SHA256 (" + name + ") = " + digest + "\n", false},
+		{"转义的文件名", "\\" + digest + "  a\\\\b\\n.tar.gz\n", false},
+		{"转义的tag格式", "\\SHA256 (a\\\\b) = " + strings.ToUpper(digest) + "\n", false},
+		{"多条记录按文件名选择", other + "  0100-01ff.tar.gz\n" + digest + "  " + name + "\n", false},
+		{"多条记录带目录", other + "  chunk/0100-01ff.tar.gz\n" + digest + " *chunk/" + name + "\n", false},
+		{"多条记录没有匹配", other + "  a.tar.gz\n" + other + "  b.tar.gz\n", true},
+		{"tag格式的其他算法", "MD5 (" + name + ") = 098f6bcd4621d373cade4e832627b4f6\n", true},
+		{"有一行格式错误", digest + "  " + name + "\nnot a checksum\n", true},
+	}
+	for _, tc := range cases {
+		got, err := ParseChecksumFile([]byte(tc.content), name)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: 应返回错误，实际 %q", tc.name, got)
+			}
+			continue
+		}
+		if err != nil || got != digest {
+			t.Errorf("%s: 期望 %s，实际 %q (%v)", tc.name, digest, got, err)
+		}
+	}
+
+	for _, style := range []string{ChecksumStyleText, ChecksumStyleBinary, ChecksumStyleTag} {
+		for _, file := range []string{name, "a\\b.tar.gz"} {
+			line := FormatChecksumLine(style, digest, file)
+			if got, err := ParseChecksumFile([]byte(line), file); err != nil || got != digest {
+				t.Errorf("%s格式 %q 无法读回: %q (%v)", style, line, got, err)
+			}
+		}
+	}
+	if _, err := ParseChecksumStyle("md5"); err == nil {
+		t.Error("未知的校验和文件格式应返回错误")
+	}
+
+	// 多条记录的汇总文件按文件名读取全部记录
+	bundle := "\ufeff" + FormatChecksumLine(ChecksumStyleText, digest, "chunk/"+name) +
+		FormatChecksumLine(ChecksumStyleTag, strings.ToUpper(other), "chunk/0100-01ff.tar.gz") + "\r\n"
+	sums, err := ParseChecksumEntries([]byte(bundle))
+	if err != nil || len(sums) != 2 || sums["chunk/"+name] != digest || sums["chunk/0100-01ff.tar.gz"] != other {
+		t.Errorf("汇总文件解析结果不正确: %v (%v)", sums, err)
+	}
+	if sums, err := ParseChecksumEntries(nil); err != nil || len(sums) != 0 {
+		t.Errorf("空的汇总文件应返回空映射: %v (%v)", sums, err)
+	}
+	if _, err := ParseChecksumEntries([]byte("not a checksum\n")); err == nil {
+		t.Error("格式错误的汇总文件应返回错误")
+	}
+
+	// 与系统的sha256sum互相校验
+	sha256sum, err := exec.LookPath("sha256sum")
+	if err != nil {
+		t.Skip("没有sha256sum，跳过互通测试")
+	}
+	testDir := t.TempDir()
+	archivePath := filepath.Join(testDir, name)
+	if err := os.WriteFile(archivePath, []byte("archive content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("archive content"))
+	checksum := hex.EncodeToString(sum[:])
+
+	for _, style := range []string{ChecksumStyleText, ChecksumStyleBinary, ChecksumStyleTag} {
+		archiver := NewArchiverWithOptions(testDir, testDir, Options{ChecksumStyle: style})
+		checksumPath, err := archiver.CreateChecksumFile(archivePath, checksum)
+		if err != nil {
+			t.Fatalf("创建校验和文件失败: %v", err)
+		}
+		cmd := exec.Command(sha256sum, "-c", checksumPath)
+		cmd.Dir = testDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("sha256sum -c不接受%s格式: %v: %s", style, err, output)
+		}
+	}
+
+	for _, args := range [][]string{{}, {"-b"}, {"--tag"}} {
+		cmd := exec.Command(sha256sum, append(args, name)...)
+		cmd.Dir = testDir
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("执行sha256sum %v失败: %v", args, err)
+		}
+		if got, err := ParseChecksumFile(output, name); err != nil || got != checksum {
+			t.Errorf("无法读取sha256sum %v的输出 %q: %q (%v)", args, output, got, err)
+		}
+	}
+}
+
+// TestThrottle 测试按目录数或读取的字节数暂停，计数在同一压缩器的压缩包之间累计
+func TestThrottle(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	writeCompressibleChunks(t, chunkDir, 4, 100<<10)
+
+	testCases := []struct {
+		name    string
+		options Options
+		pauses  int
+	}{
+		{"关闭", Options{}, 0},
+		{"未设置暂停时长", Options{ThrottleDirs: 1}, 0},
+		{"每个目录", Options{ThrottleDirs: 1, ThrottleSleep: time.Second}, 4},
+		{"每三个目录", Options{ThrottleDirs: 3, ThrottleSleep: time.Second}, 1},
+		{"按字节数", Options{ThrottleBytes: 300 << 10, ThrottleSleep: time.Second}, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			archiver := NewArchiverWithOptions(chunkDir, testDir, tc.options)
+			var pauses []time.Duration
+			if archiver.throttle != nil {
+				archiver.throttle.sleep = func(d time.Duration) { pauses = append(pauses, d) }
+			}
+
+			// 同一组目录打包两次，共读取4个目录、约800KB
+			for i := 0; i < 2; i++ {
+				groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0001"}, 2)
+				if err != nil || len(groups) != 1 {
+					t.Fatalf("生成分组失败: %v", err)
+				}
+				if _, err := archiver.WithCompression("").CreateArchiveIn(groups[0], filepath.Join(testDir, tc.name)); err != nil {
+					t.Fatalf("创建压缩包失败: %v", err)
+				}
+			}
+
+			if len(pauses) != tc.pauses {
+				t.Errorf("暂停次数为%d，期望%d", len(pauses), tc.pauses)
+			}
+			for _, d := range pauses {
+				if d != tc.options.ThrottleSleep {
+					t.Errorf("暂停时长为%v，期望%v", d, tc.options.ThrottleSleep)
+				}
+			}
+		})
+	}
+}
+
+// TestReadRateLimit 测试源读取限速：空闲时可以先读取一秒的额度，之后按读取的字节数等待；
+// 顺序读取和预读都经过限速，压缩包内容不受影响
+func TestReadRateLimit(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		now   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		slept time.Duration
+	)
+	fakeClock := func(limiter *rateLimiter) {
+		limiter.last = now
+		limiter.now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+		limiter.sleep = func(d time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			slept += d
+			now = now.Add(d)
+		}
+	}
+
+	limiter := newRateLimiter(1000)
+	fakeClock(limiter)
+	limiter.wait(500)
+	limiter.wait(1500)
+	if slept != time.Second {
+		t.Errorf("超出额度1000字节应等待1秒，实际 %v", slept)
+	}
+	now = now.Add(time.Minute)
+	limiter.wait(1000)
+	if slept != time.Second {
+		t.Errorf("空闲后最多积累一秒的额度，不应等待，实际共等待 %v", slept)
+	}
+	if newRateLimiter(0) != nil {
+		t.Error("速率为0时不应限速")
+	}
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	files := writeCompressibleChunks(t, chunkDir, 4, 100<<10)
+
+	for _, readBuffer := range []int64{0, 1 << 20} {
+		slept = 0
+		archiver := NewArchiverWithOptions(chunkDir, testDir, Options{ReadRateLimit: 100 << 10, ReadBufferBytes: readBuffer})
+		fakeClock(archiver.limiter)
+		groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0001"}, 2)
+		if err != nil || len(groups) != 1 {
+			t.Fatalf("生成分组失败: %v", err)
+		}
+		archivePath, err := archiver.CreateArchiveIn(groups[0], filepath.Join(testDir, fmt.Sprint(readBuffer)))
+		if err != nil {
+			t.Fatalf("创建压缩包失败: %v", err)
+		}
+		// 读取400KB，第一秒的100KB不需要等待
+		if slept < 3*time.Second || readBuffer == 0 && slept != 3*time.Second {
+			t.Errorf("预读%d: 读取400KB应等待3秒，实际 %v", readBuffer, slept)
+		}
+		destDir := filepath.Join(testDir, fmt.Sprintf("restore-%d", readBuffer))
+		if _, err := ExtractArchive(archivePath, destDir, nil); err != nil {
+			t.Fatalf("解压失败: %v", err)
+		}
+		for name, content := range files {
+			if data, err := os.ReadFile(filepath.Join(destDir, name)); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("预读%d: 解压的%s与源文件不一致: %v", readBuffer, name, err)
+			}
+		}
+	}
+}
+
+// TestCalculateChecksumBufferSize 测试不同的读取缓冲区大小得到相同的校验和
+func TestCalculateChecksumBufferSize(t *testing.T) {
+	data := make([]byte, 3<<20+7)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	expected := hex.EncodeToString(sum[:])
+
+	for _, size := range []int{0, 7, 32 << 10, 1 << 20, 8 << 20} {
+		archiver := NewArchiverWithOptions(t.TempDir(), t.TempDir(), Options{HashBufferSize: size})
+		checksum, err := archiver.CalculateChecksum(path)
+		if err != nil {
+			t.Fatalf("缓冲区%d字节时计算校验和失败: %v", size, err)
+		}
+		if checksum != expected {
+			t.Errorf("缓冲区%d字节时校验和为%s，期望%s", size, checksum, expected)
+		}
+	}
+}
+
+// BenchmarkCalculateChecksum 比较io.Copy默认的32KB缓冲区与默认1MB缓冲区计算大压缩包校验和的速度，
+// 运行: go test -run '^$' -bench CalculateChecksum ./internal/archiver
+func BenchmarkCalculateChecksum(b *testing.B) {
+	data := make([]byte, 256<<20)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(b.TempDir(), "archive.tar.gz")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name string
+		size int
+	}{
+		{"32KB", 32 << 10},
+		{"1MB", 1 << 20},
+		{"4MB", 4 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			archiver := NewArchiverWithOptions(b.TempDir(), b.TempDir(), Options{HashBufferSize: bc.size})
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := archiver.CalculateChecksum(path); err != nil {
+					b.Fatalf("计算校验和失败: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCreateArchive 比较标准gzip与并行gzip创建压缩包的速度，
+// 运行: go test -run '^$' -bench CreateArchive ./internal/archiver
+func BenchmarkCreateArchive(b *testing.B) {
+	chunkDir := filepath.Join(b.TempDir(), "chunks")
+	writeCompressibleChunks(b, chunkDir, 8, 4<<20)
+
+	for _, bc := range []struct {
+		name     string
+		parallel bool
+	}{
+		{"gzip", false},
+		{"pgzip", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			archiver := NewArchiverWithOptions(chunkDir, b.TempDir(), Options{ParallelGzip: bc.parallel})
+			b.SetBytes(8 << 22)
+			for i := 0; i < b.N; i++ {
+				groups, err := archiver.GenerateArchiveGroups([]string{"0000", "0001"}, 2)
+				if err != nil {
+					b.Fatalf("生成分组失败: %v", err)
+				}
+				if _, err := archiver.CreateArchive(groups[0]); err != nil {
+					b.Fatalf("创建压缩包失败: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveEmptyDirectories(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	for _, dir := range []string{"0000/sub", "0001"} {
+		if err := os.MkdirAll(filepath.Join(chunkDir, dir), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "file.dat"), []byte("data"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+
+	options := map[string]Options{
+		"默认":    {},
+		"tar索引": {TarIndex: true},
+		"预读":    {ReadBufferBytes: 1024},
+	}
+	for name, opts := range options {
+		a := NewArchiverWithOptions(chunkDir, filepath.Join(testDir, "temp"), opts)
+		archivePath, err := a.CreateArchive(&models.ArchiveGroup{
+			ArchiveName: "0000-00ff.tar.gz",
+			Directories: []string{"0000", "0001"},
+		})
+		if err != nil {
+			t.Fatalf("%s: 创建压缩包失败: %v", name, err)
+		}
+
+		// 空目录应作为目录条目写入压缩包，解压后重新创建
+		destDir := filepath.Join(testDir, "restore-"+name)
+		if _, err := ExtractArchive(archivePath, destDir, nil); err != nil {
+			t.Fatalf("%s: 解压失败: %v", name, err)
+		}
+		for _, dir := range []string{"0000/sub", "0001"} {
+			if info, err := os.Stat(filepath.Join(destDir, dir)); err != nil || !info.IsDir() {
+				t.Errorf("%s: 解压后应存在空目录 %s: %v", name, dir, err)
+			}
+		}
+	}
+}
+
+func TestUncompressedArchive(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	files := map[string]string{
+		"0000/a.chunk":     "content of a",
+		"0001/sub/c.chunk": "nested content",
+	}
+	for name, content := range files {
+		path := filepath.Join(chunkDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+
+	if _, err := ParseCompression("zstd"); err == nil {
+		t.Error("不支持的压缩包格式应返回错误")
+	}
+	if compression, err := ParseCompression(""); err != nil || compression != CompressionGzip {
+		t.Errorf("空值应表示gzip，实际 %q (%v)", compression, err)
+	}
+
+	for _, tarIndex := range []bool{false, true} {
+		a := NewArchiverWithOptions(chunkDir, filepath.Join(testDir, "temp"), Options{TarIndex: tarIndex, Compression: CompressionNone})
+		groups, err := a.GenerateArchiveGroups([]string{"0000", "0001"}, 2)
+		if err != nil {
+			t.Fatalf("生成分组失败: %v", err)
+		}
+		if groups[0].ArchiveName != "0000-00ff.tar" {
+			t.Fatalf("不压缩的压缩包应使用.tar扩展名，实际 %s", groups[0].ArchiveName)
+		}
+
+		archivePath, err := a.CreateArchive(groups[0])
+		if err != nil {
+			t.Fatalf("创建压缩包失败: %v", err)
+		}
+		if groups[0].Compression != CompressionNone {
+			t.Errorf("分组应记录压缩方式none，实际 %q", groups[0].Compression)
+		}
+
+		// 内容是普通的tar流
+		file, err := os.Open(archivePath)
+		if err != nil {
+			t.Fatalf("打开压缩包失败: %v", err)
+		}
+		count := 0
+		tarReader := tar.NewReader(file)
+		for {
+			if _, err := tarReader.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("tar索引=%v: 压缩包不是普通tar: %v", tarIndex, err)
+			}
+			count++
+		}
+		file.Close()
+
+		destDir := filepath.Join(testDir, fmt.Sprintf("restore-%v", tarIndex))
+		extracted, err := ExtractArchive(archivePath, destDir, nil)
+		if err != nil || extracted != count {
+			t.Errorf("tar索引=%v: 解压条目数 %d，预期 %d (%v)", tarIndex, extracted, count, err)
+		}
+		if !tarIndex {
+			continue
+		}
+
+		// 索引记录的是条目在tar流中的偏移，可以直接定位解压
+		index, err := LoadIndex(IndexPath(archivePath))
+		if err != nil {
+			t.Fatalf("读取索引失败: %v", err)
+		}
+		for name, content := range files {
+			entry, found := FindEntry(index, name)
+			if !found {
+				t.Fatalf("索引中缺少条目 %s", name)
+			}
+			entryDir := filepath.Join(testDir, "entry")
+			if err := ExtractEntry(archivePath, entry, entryDir); err != nil {
+				t.Fatalf("按索引解压 %s 失败: %v", name, err)
+			}
+			if data, err := os.ReadFile(filepath.Join(entryDir, filepath.FromSlash(name))); err != nil || string(data) != content {
+				t.Errorf("条目 %s 内容不匹配: %q (%v)", name, data, err)
+			}
+		}
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	oldTime := time.Now().Add(-time.Hour)
+	files := map[string]string{
+		"0000/a.chunk":     "content of a",
+		"0000/b.chunk":     "content of b",
+		"0001/sub/c.chunk": "nested content",
+	}
+	for name, content := range files {
+		path := filepath.Join(chunkDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(chunkDir, "0001", "empty"), 0755); err != nil {
+		t.Fatalf("创建目录失败: %v", err)
+	}
+	setOld := func() {
+		err := filepath.Walk(chunkDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Chtimes(path, oldTime, oldTime)
+		})
+		if err != nil {
+			t.Fatalf("设置修改时间失败: %v", err)
+		}
+	}
+	setOld()
+
+	create := func(options Options, deduped ...models.DedupeEntry) (*Archiver, *models.ArchiveGroup, string, time.Time) {
+		a := NewArchiverWithOptions(chunkDir, filepath.Join(testDir, "temp"), options)
+		group := &models.ArchiveGroup{ArchiveName: "0000-00ff.tar.gz", Directories: []string{"0000", "0001"}, Deduped: deduped}
+		createdAt := time.Now()
+		archivePath, err := a.CreateArchive(group)
+		if err != nil {
+			t.Fatalf("创建压缩包失败: %v", err)
+		}
+		return a, group, archivePath, createdAt
+	}
+
+	// 各种写入方式生成的压缩包都应与源目录一致
+	for _, options := range []Options{{}, {TarIndex: true}, {ReadBufferBytes: 16}, {Compression: CompressionNone}} {
+		a, group, archivePath, createdAt := create(options)
+		if err := a.VerifyArchive(archivePath, group, createdAt); err != nil {
+			t.Errorf("%+v: 正常的压缩包自检失败: %v", options, err)
+		}
+	}
+
+	// 去重的文件不在压缩包中，不应视为缺失
+	a, group, archivePath, createdAt := create(Options{}, models.DedupeEntry{Path: "0000/b.chunk"})
+	if err := a.VerifyArchive(archivePath, group, createdAt); err != nil {
+		t.Errorf("去重文件不应导致自检失败: %v", err)
+	}
+
+	// 压缩包缺少条目（模拟路径处理错误）
+	group.Deduped = nil
+	if err := a.VerifyArchive(archivePath, group, createdAt); !errors.Is(err, ErrArchiveMismatch) {
+		t.Errorf("缺少条目时应返回ErrArchiveMismatch，实际 %v", err)
+	}
+
+	// 压缩包被截断
+	a, group, archivePath, createdAt = create(Options{Compression: CompressionNone})
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		t.Fatalf("读取压缩包信息失败: %v", err)
+	}
+	if err := os.Truncate(archivePath, info.Size()/2); err != nil {
+		t.Fatalf("截断压缩包失败: %v", err)
+	}
+	if err := a.VerifyArchive(archivePath, group, createdAt); !errors.Is(err, ErrArchiveMismatch) {
+		t.Errorf("截断的压缩包应返回ErrArchiveMismatch，实际 %v", err)
+	}
+
+	// 内容与源文件不同（大小相同）
+	a, group, archivePath, createdAt = create(Options{})
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "a.chunk"), []byte("CONTENT OF A"), 0644); err != nil {
+		t.Fatalf("修改文件失败: %v", err)
+	}
+	setOld()
+	if err := a.VerifyArchive(archivePath, group, createdAt); !errors.Is(err, ErrArchiveMismatch) {
+		t.Errorf("内容不同时应返回ErrArchiveMismatch，实际 %v", err)
+	}
+
+	// 创建之后新增或删除的源文件不视为不一致
+	a, group, archivePath, createdAt = create(Options{})
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "new.chunk"), []byte("new"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	if err := os.Remove(filepath.Join(chunkDir, "0001", "sub", "c.chunk")); err != nil {
+		t.Fatalf("删除文件失败: %v", err)
+	}
+	if err := a.VerifyArchive(archivePath, group, createdAt); err != nil {
+		t.Errorf("创建之后的源目录变化不应导致自检失败: %v", err)
+	}
+}
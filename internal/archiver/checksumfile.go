@@ -0,0 +1,164 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// 校验和文件格式，与sha256sum的输出方式对应
+const (
+	ChecksumStyleText   = "text"   // <checksum>  <name>，sha256sum的默认输出
+	ChecksumStyleBinary = "binary" // <checksum> *<name>，sha256sum -b的输出
+	ChecksumStyleTag    = "tag"    // SHA256 (<name>) = <checksum>，sha256sum --tag的输出
+)
+
+var (
+	utf8BOM       = []byte{0xef, 0xbb, 0xbf}
+	sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	tagPattern    = regexp.MustCompile(`^SHA256 \((.*)\) ?= ?([0-9a-fA-F]+)$`)
+)
+
+// ParseChecksumStyle 解析校验和文件格式名称，空字符串表示text
+func ParseChecksumStyle(style string) (string, error) {
+	switch style {
+	case "", ChecksumStyleText:
+		return ChecksumStyleText, nil
+	case ChecksumStyleBinary, ChecksumStyleTag:
+		return style, nil
+	default:
+		return "", fmt.Errorf("invalid checksum style %q (expected %s, %s or %s)", style, ChecksumStyleText, ChecksumStyleBinary, ChecksumStyleTag)
+	}
+}
+
+// FormatChecksumLine 按指定格式生成校验和文件的一行（含换行），结果可以直接交给sha256sum -c校验。
+// 与coreutils相同，文件名包含反斜杠或换行时转义，并在行首加反斜杠
+func FormatChecksumLine(style, checksum, name string) string {
+	prefix := ""
+	if strings.ContainsAny(name, "\\\n\r") {
+		prefix = `\`
+		name = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(name)
+	}
+	switch style {
+	case ChecksumStyleBinary:
+		return fmt.Sprintf("%s%s *%s\n", prefix, checksum, name)
+	case ChecksumStyleTag:
+		return fmt.Sprintf("%sSHA256 (%s) = %s\n", prefix, name, checksum)
+	default:
+		return fmt.Sprintf("%s%s  %s\n", prefix, checksum, name)
+	}
+}
+
+// checksumEntry 校验和文件中的一条记录
+type checksumEntry struct {
+	checksum string
+	name     string
+}
+
+// ParseChecksumFile 解析校验和文件，返回小写的SHA256。接受sha256sum的各种输出：
+// 两个空格或一个空格分隔、文件名前的*（二进制模式）、--tag格式、转义的文件名，以及只有校验和的内容；
+// 容忍UTF-8 BOM、CRLF换行和前后空白。文件只有一条记录时不检查文件名（如复制为.prev的元数据校验和），
+// 有多条记录时（如对整个目录执行sha256sum的输出）返回文件名或其最后一段与name相同的记录
+func ParseChecksumFile(content []byte, name string) (string, error) {
+	entries, err := parseChecksumEntries(content)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(entries) {
+	case 0:
+		return "", fmt.Errorf("invalid checksum file format: empty")
+	case 1:
+		return entries[0].checksum, nil
+	}
+	for _, entry := range entries {
+		if entry.name == name || path.Base(entry.name) == name {
+			return entry.checksum, nil
+		}
+	}
+	return "", fmt.Errorf("invalid checksum file format: no entry for %s among %d entries", name, len(entries))
+}
+
+// ParseChecksumEntries 解析包含多条记录的校验和文件（如对多个文件执行sha256sum的输出），返回文件名到小写SHA256的映射。
+// 接受的格式与ParseChecksumFile相同，空文件返回空映射
+func ParseChecksumEntries(content []byte) (map[string]string, error) {
+	entries, err := parseChecksumEntries(content)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		sums[entry.name] = entry.checksum
+	}
+	return sums, nil
+}
+
+// parseChecksumEntries 逐行解析校验和文件，跳过空行
+func parseChecksumEntries(content []byte) ([]checksumEntry, error) {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	var entries []checksumEntry
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		entry, err := parseChecksumLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseChecksumLine 解析校验和文件的一行，line已去掉前后空白
+func parseChecksumLine(line string) (checksumEntry, error) {
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+
+	var entry checksumEntry
+	if match := tagPattern.FindStringSubmatch(line); match != nil {
+		entry = checksumEntry{checksum: match[2], name: match[1]}
+	} else {
+		// 校验和与文件名之间可能是两个空格、一个空格或制表符，二进制模式在文件名前加*
+		entry.checksum = line
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			entry.checksum = line[:i]
+			entry.name = strings.TrimPrefix(strings.TrimLeft(line[i+1:], " \t"), "*")
+		}
+	}
+
+	if !sha256Pattern.MatchString(entry.checksum) {
+		return entry, fmt.Errorf("invalid checksum file format: %q is not a SHA256 hex digest", entry.checksum)
+	}
+	entry.checksum = strings.ToLower(entry.checksum)
+	if escaped {
+		entry.name = unescapeChecksumName(entry.name)
+	}
+	return entry, nil
+}
+
+// unescapeChecksumName 还原coreutils转义的文件名
+func unescapeChecksumName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+1 < len(name) {
+			i++
+			switch name[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(name[i])
+			}
+			continue
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
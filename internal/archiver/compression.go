@@ -0,0 +1,144 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pbs-backuper/internal/models"
+)
+
+const (
+	// CompressionGzip 使用默认级别的gzip压缩
+	CompressionGzip = "gzip"
+	// CompressionStore 仍使用gzip格式但不压缩（级别0），数据以存储块写入
+	CompressionStore = "store"
+	// CompressionNone 不使用gzip，写入普通的tar包（扩展名.tar）
+	CompressionNone = "none"
+	// CompressionZstd 使用zstd压缩（扩展名.tar.zst），只在CompressionAuto下按分组选择
+	CompressionZstd = "zstd"
+	// CompressionAuto 创建每个压缩包时采样其中的文件，逐个选择gzip、zstd或不压缩。
+	// 压缩包名仍使用.tar.gz，实际格式记录在元数据中，远程对象名使用实际格式的扩展名
+	CompressionAuto = "auto"
+)
+
+// ParseCompression 解析压缩包格式，空字符串表示默认的gzip
+func ParseCompression(compression string) (string, error) {
+	switch compression {
+	case "", CompressionGzip:
+		return CompressionGzip, nil
+	case CompressionNone, CompressionAuto:
+		return compression, nil
+	default:
+		return "", fmt.Errorf("invalid compression %q (expected %s, %s or %s)", compression, CompressionGzip, CompressionNone, CompressionAuto)
+	}
+}
+
+// ArchiveExtension 返回压缩包格式对应的扩展名
+func ArchiveExtension(compression string) string {
+	switch compression {
+	case CompressionNone:
+		return ".tar"
+	case CompressionZstd:
+		return ".tar.zst"
+	default:
+		return ".tar.gz"
+	}
+}
+
+// FormatObjectName 把按.tar.gz命名的压缩包名换成compression实际格式的扩展名，
+// 用于CompressionAuto下选择了zstd或不压缩的压缩包的远程对象名
+func FormatObjectName(archiveName, compression string) string {
+	base, found := strings.CutSuffix(archiveName, ArchiveExtension(CompressionGzip))
+	if !found {
+		return archiveName
+	}
+	return base + ArchiveExtension(compression)
+}
+
+const (
+	sampleFileCount  = 8         // 采样的文件数
+	sampleBytes      = 64 * 1024 // 每个文件采样的字节数
+	storeRatioCutoff = 0.95      // 压缩后大小与原始大小之比高于此值时不压缩
+	zstdRatioCutoff  = 0.5       // CompressionAuto下gzip采样压缩率低于此值（文本等易压缩的内容）时使用zstd
+)
+
+// chooseFormat 为CompressionAuto采样分组中的部分文件，返回该压缩包的格式：压缩效果差（chunk已压缩或加密）时不压缩，
+// 容易压缩时使用更快、压缩率更高的zstd，其余使用gzip。启用tar索引时不选择zstd，索引需要可以随机访问的gzip成员
+func (a *Archiver) chooseFormat(group *models.ArchiveGroup, skip map[string]bool) string {
+	ratio, ok := a.sampleCompressionRatio(group, skip)
+	switch {
+	case !ok:
+		return CompressionGzip
+	case ratio > storeRatioCutoff:
+		return CompressionNone
+	case ratio < zstdRatioCutoff && !a.options.TarIndex:
+		return CompressionZstd
+	default:
+		return CompressionGzip
+	}
+}
+
+// chooseCompression 采样分组中的部分文件判断压缩效果，返回压缩方式。
+// chunk文件通常已经压缩或加密，再次gzip只会消耗CPU，因此压缩率低时选择存储。
+func (a *Archiver) chooseCompression(group *models.ArchiveGroup, skip map[string]bool) string {
+	ratio, ok := a.sampleCompressionRatio(group, skip)
+	if ok && ratio > storeRatioCutoff {
+		return CompressionStore
+	}
+	return CompressionGzip
+}
+
+// sampleCompressionRatio 读取分组中前几个文件的开头部分并压缩，返回压缩后与原始大小之比。
+// 没有可采样的数据时第二个返回值为false
+func (a *Archiver) sampleCompressionRatio(group *models.ArchiveGroup, skip map[string]bool) (float64, bool) {
+	counter := &countingWriter{w: io.Discard}
+	gzipWriter := gzip.NewWriter(counter)
+	var total int64
+	sampled := 0
+
+	errDone := io.EOF
+	for _, dir := range group.Directories {
+		dirPath := filepath.Join(a.chunkPath, dir)
+		err := filepath.Walk(dirPath, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if a.excluded(dirPath, file, info) {
+				return skipExcluded(info)
+			}
+			if info.IsDir() || info.Size() == 0 {
+				return nil
+			}
+			relPath, err := filepath.Rel(a.chunkPath, file)
+			if err != nil || skip[filepath.ToSlash(relPath)] {
+				return nil
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				return nil
+			}
+			n, _ := io.Copy(gzipWriter, io.LimitReader(f, sampleBytes))
+			f.Close()
+
+			total += n
+			sampled++
+			if sampled >= sampleFileCount {
+				return errDone
+			}
+			return nil
+		})
+		if err == errDone {
+			break
+		}
+	}
+
+	if err := gzipWriter.Close(); err != nil || total == 0 {
+		return 0, false
+	}
+	return float64(counter.n) / float64(total), true
+}
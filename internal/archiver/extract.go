@@ -0,0 +1,192 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ExtractArchive 解压压缩包到目标目录，match为nil时解压全部条目，返回解压的条目数
+func ExtractArchive(archivePath, destDir string, match func(name string) bool) (int, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	stream, err := openArchiveStream(file)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	tarReader := tar.NewReader(stream)
+	extracted := 0
+	var dirHeaders []*tar.Header
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		if match != nil && !match(header.Name) {
+			continue
+		}
+
+		if err := extractTarEntry(tarReader, header, destDir); err != nil {
+			return extracted, err
+		}
+		if header.Typeflag == tar.TypeDir {
+			dirHeaders = append(dirHeaders, header)
+		}
+		extracted++
+	}
+
+	// 目录的修改时间在写入子条目后才能恢复，逆序处理保证父目录最后设置
+	for i := len(dirHeaders) - 1; i >= 0; i-- {
+		target, err := safeJoin(destDir, dirHeaders[i].Name)
+		if err != nil {
+			return extracted, err
+		}
+		if err := os.Chtimes(target, dirHeaders[i].ModTime, dirHeaders[i].ModTime); err != nil {
+			return extracted, fmt.Errorf("failed to set mod time for %s: %w", target, err)
+		}
+	}
+
+	return extracted, nil
+}
+
+// openArchiveStream 根据文件头判断压缩包是gzip还是zstd格式，都不是时按未压缩的tar读取
+func openArchiveStream(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	if len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return gzipReader, nil
+	}
+	if bytes.Equal(magic, zstdMagic) {
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// zstdMagic zstd帧的魔数（小端序的0xFD2FB528）
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// extractTarEntry 将单个tar条目写入目标目录（目录的修改时间由调用方在最后恢复）
+func extractTarEntry(tarReader *tar.Reader, header *tar.Header, destDir string) error {
+	target, err := safeJoin(destDir, header.Name)
+	if err != nil {
+		return err
+	}
+
+	// 路径在备份之间由目录变为文件（或相反）时，恢复到已有目录前先删除旧类型的条目
+	if header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeReg {
+		if err := RemoveStaleType(destDir, target, header.Typeflag == tar.TypeDir); err != nil {
+			return err
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", target, err)
+		}
+		return nil
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", target, err)
+		}
+
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", target, err)
+		}
+
+		if _, err := io.Copy(file, tarReader); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write file %s: %w", target, err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to close file %s: %w", target, err)
+		}
+	default:
+		// chunk目录中只有普通文件和目录，其他类型直接跳过
+		return nil
+	}
+
+	// 恢复文件修改时间，保证恢复后的增量比较结果一致
+	if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+		return fmt.Errorf("failed to set mod time for %s: %w", target, err)
+	}
+
+	return nil
+}
+
+// safeJoin 拼接解压路径，拒绝跳出目标目录的条目
+func safeJoin(destDir, name string) (string, error) {
+	target := filepath.Join(destDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	return target, nil
+}
+
+// RemoveStaleType 删除target（位于destDir下）路径上类型不符的已有条目：父路径中的普通文件，
+// 以及isDir为true时同名的文件、为false时同名的目录（连同其内容）。不存在的路径和符号链接保持不变
+func RemoveStaleType(destDir, target string, isDir bool) error {
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	current := destDir
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", current, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+
+		wantDir := isDir || i < len(parts)-1
+		if info.IsDir() == wantDir {
+			continue
+		}
+		if err := os.RemoveAll(current); err != nil {
+			return fmt.Errorf("failed to remove stale %s: %w", current, err)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package archiver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"pbs-backuper/internal/scanner"
+)
+
+// crc32cTable Castagnoli多项式的CRC32表，与GCS等服务端记录的CRC32C相同
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ArchiveHashes 压缩包的哈希，均为十六进制小写。SHA256用于本工具的校验；
+// CRC32C和MD5与云存储服务端记录的哈希比较，不需要下载压缩包
+type ArchiveHashes struct {
+	SHA256 string
+	CRC32C string
+	MD5    string
+}
+
+// CalculateHashes 读取一次压缩包，同时计算SHA256、CRC32C和MD5
+func (a *Archiver) CalculateHashes(filePath string) (ArchiveHashes, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ArchiveHashes{}, fmt.Errorf("failed to open file for checksum: %w", err)
+	}
+	defer file.Close()
+
+	bufferSize := a.options.HashBufferSize
+	if bufferSize <= 0 {
+		bufferSize = scanner.DefaultHashBufferSize
+	}
+	sha, crc, sum := sha256.New(), crc32.New(crc32cTable), md5.New()
+	// 隐藏*os.File的WriteTo，与scanner.HashReader一样使用指定大小的缓冲区
+	if _, err := io.CopyBuffer(io.MultiWriter(sha, crc, sum), struct{ io.Reader }{file}, make([]byte, bufferSize)); err != nil {
+		return ArchiveHashes{}, fmt.Errorf("failed to calculate checksum: %w", err)
+	}
+
+	return ArchiveHashes{
+		SHA256: hex.EncodeToString(sha.Sum(nil)),
+		CRC32C: hex.EncodeToString(crc.Sum(nil)),
+		MD5:    hex.EncodeToString(sum.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,234 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"pbs-backuper/internal/models"
+)
+
+// IndexFileSuffix tar索引文件后缀
+const IndexFileSuffix = ".index.json"
+
+// IndexPath 返回压缩包对应的索引文件路径
+func IndexPath(archivePath string) string {
+	return archivePath + IndexFileSuffix
+}
+
+// countingWriter 记录已写入字节数的写入器
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// gzipMemberWriter 将tar流切分为多个独立的gzip成员。
+// 多个gzip成员拼接后仍是标准gzip流，gzip/tar工具可以照常读取，
+// 同时每个成员都可以从其起始偏移处单独解压，从而实现随机访问。
+type gzipMemberWriter struct {
+	out   *countingWriter
+	gz    *gzip.Writer
+	level int
+}
+
+func (m *gzipMemberWriter) Write(p []byte) (int, error) {
+	if m.gz == nil {
+		gz, err := gzip.NewWriterLevel(m.out, m.level)
+		if err != nil {
+			return 0, err
+		}
+		m.gz = gz
+	}
+	return m.gz.Write(p)
+}
+
+// cut 结束当前gzip成员，返回下一个成员在压缩文件中的起始偏移
+func (m *gzipMemberWriter) cut() (int64, error) {
+	if m.gz != nil {
+		if err := m.gz.Close(); err != nil {
+			return 0, err
+		}
+		m.gz = nil
+	}
+	return m.out.n, nil
+}
+
+// Close 结束最后一个gzip成员
+func (m *gzipMemberWriter) Close() error {
+	_, err := m.cut()
+	return err
+}
+
+// memberWriter 将tar流切分为可以单独读取的段，cut返回下一段的起始偏移
+type memberWriter interface {
+	io.WriteCloser
+	cut() (int64, error)
+}
+
+// plainMemberWriter 不压缩的tar包中每个条目本身就可以从其偏移处直接读取，只需记录偏移
+type plainMemberWriter struct {
+	out *countingWriter
+}
+
+func (m *plainMemberWriter) Write(p []byte) (int, error) {
+	return m.out.Write(p)
+}
+
+func (m *plainMemberWriter) cut() (int64, error) {
+	return m.out.n, nil
+}
+
+func (m *plainMemberWriter) Close() error {
+	return nil
+}
+
+// nopWriteCloser 为不压缩的tar包提供Close，文件由调用方关闭
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// indexBuilder 在创建压缩包时记录每个条目所在的gzip成员（不压缩时为条目的偏移）
+type indexBuilder struct {
+	tarWriter *tar.Writer
+	members   memberWriter
+	index     models.TarIndex
+}
+
+// beginEntry 在写入tar头之前调用，为该条目开启新的gzip成员并记录偏移
+func (b *indexBuilder) beginEntry(header *tar.Header) error {
+	// 先写出上一个条目的填充字节，保证它们落在上一个成员中
+	if err := b.tarWriter.Flush(); err != nil {
+		return err
+	}
+
+	offset, err := b.members.cut()
+	if err != nil {
+		return err
+	}
+	b.closeLastEntry(offset)
+
+	b.index.Entries = append(b.index.Entries, models.TarIndexEntry{
+		Name:   header.Name,
+		IsDir:  header.Typeflag == tar.TypeDir,
+		Size:   header.Size,
+		Offset: offset,
+	})
+	return nil
+}
+
+// closeLastEntry 根据下一个成员的起始偏移计算上一个条目的压缩长度
+func (b *indexBuilder) closeLastEntry(offset int64) {
+	if n := len(b.index.Entries); n > 0 {
+		last := &b.index.Entries[n-1]
+		last.Length = offset - last.Offset
+	}
+}
+
+// writeIndexFile 将tar索引写入JSON文件
+func writeIndexFile(indexPath string, index *models.TarIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tar index: %w", err)
+	}
+
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write tar index: %w", err)
+	}
+
+	return nil
+}
+
+// LoadIndex 读取tar索引文件
+func LoadIndex(indexPath string) (*models.TarIndex, error) {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tar index: %w", err)
+	}
+
+	var index models.TarIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse tar index: %w", err)
+	}
+
+	return &index, nil
+}
+
+// FindEntry 在索引中查找指定路径的条目
+func FindEntry(index *models.TarIndex, name string) (*models.TarIndexEntry, bool) {
+	name = filepath.ToSlash(name)
+	for i := range index.Entries {
+		if index.Entries[i].Name == name {
+			return &index.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// ExtractEntry 根据索引直接定位到条目所在的gzip成员并解压，无需扫描整个压缩包
+func ExtractEntry(archivePath string, entry *models.TarIndexEntry, destDir string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(entry.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to entry %s: %w", entry.Name, err)
+	}
+	return ExtractMember(file, entry, destDir)
+}
+
+// ExtractMember 从条目所在的gzip成员（不压缩时为条目本身）开头读取并解压该条目，
+// r可以只包含索引记录的Offset到Offset+Length这一段，用于从远程按范围读取
+func ExtractMember(r io.Reader, entry *models.TarIndexEntry, destDir string) error {
+	// 不压缩的tar包在偏移处直接是tar头，否则是独立的gzip成员
+	buffered := bufio.NewReader(r)
+	var stream io.Reader = buffered
+	var gzipReader *gzip.Reader
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		var err error
+		gzipReader, err = gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip member for %s: %w", entry.Name, err)
+		}
+		defer gzipReader.Close()
+		gzipReader.Multistream(false)
+		stream = gzipReader
+	}
+
+	tarReader := tar.NewReader(stream)
+	header, err := tarReader.Next()
+	if err != nil {
+		return fmt.Errorf("failed to read tar header for %s: %w", entry.Name, err)
+	}
+
+	if header.Name != entry.Name {
+		return fmt.Errorf("tar index mismatch: expected %s at offset %d, found %s", entry.Name, entry.Offset, header.Name)
+	}
+
+	if err := extractTarEntry(tarReader, header, destDir); err != nil {
+		return err
+	}
+	// 读完成员剩余的填充字节，gzip在成员结尾校验CRC32，内容损坏时在这里返回错误
+	if gzipReader != nil {
+		if _, err := io.Copy(io.Discard, gzipReader); err != nil {
+			return fmt.Errorf("corrupt gzip member for %s: %w", entry.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"pbs-backuper/internal/models"
+)
+
+// tarBlockSize tar格式的块大小，每个条目的头占一个块，内容按块对齐
+const tarBlockSize = 512
+
+// estimateArchiveSize 估算分组打包后的大小：每个条目的tar头、PAX扩展头及其数据块各占一个块，
+// 加上按块对齐的文件内容，再加上结尾的两个空块。
+// PBS的chunk已经压缩过，gzip几乎不能再缩小，因此按未压缩的tar大小估算
+func (a *Archiver) estimateArchiveSize(group *models.ArchiveGroup, skip map[string]bool) int64 {
+	size := int64(2 * tarBlockSize)
+	for _, dir := range group.Directories {
+		dirPath := filepath.Join(a.chunkPath, dir)
+		filepath.Walk(dirPath, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // 估算只用于预留空间，读取失败的条目交给打包时处理
+			}
+			if a.excluded(dirPath, file, info) {
+				return skipExcluded(info)
+			}
+			if relPath, err := filepath.Rel(a.chunkPath, file); err == nil && skip[filepath.ToSlash(relPath)] {
+				return nil
+			}
+			size += 3 * tarBlockSize
+			if info.Mode().IsRegular() {
+				size += (info.Size() + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+			}
+			return nil
+		})
+	}
+	return size
+}
+
+// preallocate 为压缩包文件预留size字节的磁盘空间，不改变文件大小。
+// 空间不足时返回错误，使打包在写入前失败；文件系统或平台不支持预留时忽略
+func preallocate(file *os.File, size int64) error {
+	err := fallocate(file, size)
+	if err == nil || errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return fmt.Errorf("failed to reserve %d bytes for %s: %w", size, filepath.Base(file.Name()), err)
+}
+
+// releasePreallocated 释放写入结束后超出文件大小的预留空间，失败时忽略（临时文件删除后空间同样会释放）
+func releasePreallocated(file *os.File) {
+	if info, err := file.Stat(); err == nil {
+		file.Truncate(info.Size())
+	}
+}
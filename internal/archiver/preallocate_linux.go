@@ -0,0 +1,20 @@
+//go:build linux
+
+package archiver
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocate 使用fallocate(FALLOC_FL_KEEP_SIZE)预留空间。空间或配额不足时返回原始错误，
+// 其他错误（如文件系统不支持）返回errors.ErrUnsupported
+func fallocate(file *os.File, size int64) error {
+	err := unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	if err == nil || errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.EDQUOT) {
+		return err
+	}
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package archiver
+
+import (
+	"errors"
+	"os"
+)
+
+// fallocate 其他平台不支持预留空间
+func fallocate(*os.File, int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,81 @@
+package archiver
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter 令牌桶，限制从源目录读取文件内容的速率。同一个压缩器创建的所有压缩包
+// （包括并行创建的和预读的）共用令牌；读取超过可用令牌时记为欠额，读取方按欠额等待，
+// 并发的读取方依次排在后面，合计速率不超过上限
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒字节数
+	burst  float64 // 空闲后最多积累的令牌，为一秒的额度
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// newRateLimiter 创建每秒读取bytesPerSecond字节的限速器，bytesPerSecond不大于0时返回nil
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &rateLimiter{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// wait 扣除读取的n个字节，令牌不足时等待到补足。nil限速器不等待
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
+
+// reader 返回按限速读取r的Reader，nil限速器时原样返回r
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, limiter: l}
+}
+
+// rateLimitedReader 每次读取后按读到的字节数等待限速器
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,182 @@
+package archiver
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// readAheadWorkers 同时读取文件内容的goroutine数
+const readAheadWorkers = 4
+
+// tarEntry 待写入tar包的条目
+type tarEntry struct {
+	header *tar.Header
+	path   string // 本地文件路径
+}
+
+// byteBudget 限制已读入内存、等待写入tar包的字节数。
+// 读取方在读取前申请额度，tar写入方写完后归还，额度用尽时读取方阻塞。
+type byteBudget struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int64
+	used   int64
+	peak   int64 // 运行期间占用的最大字节数
+	closed bool
+}
+
+// newByteBudget 创建内存预算
+func newByteBudget(limit int64) *byteBudget {
+	b := &byteBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire 申请n字节额度，额度不足时阻塞；预算关闭后返回false
+func (b *byteBudget) acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.used+n > b.limit && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return false
+	}
+
+	b.used += n
+	if b.used > b.peak {
+		b.peak = b.used
+	}
+	return true
+}
+
+// release 归还n字节额度
+func (b *byteBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// close 唤醒所有等待者并拒绝后续申请，写入出错提前退出时调用
+func (b *byteBudget) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// reopen 重新开放预算，供同一压缩包的下一个目录使用
+func (b *byteBudget) reopen() {
+	b.mu.Lock()
+	b.closed = false
+	b.mu.Unlock()
+}
+
+// readResult 预读的文件内容
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// buffered 判断条目是否预读：目录没有内容，超过预算的文件在写入时直接流式读取
+func (e tarEntry) buffered(budget *byteBudget) bool {
+	return e.header.Typeflag == tar.TypeReg && e.header.Size <= budget.limit
+}
+
+// writeEntriesReadAhead 按顺序将条目写入tar包，同时在后台按相同顺序预读后续文件的内容。
+// 额度按条目顺序申请，写入方总是在等待最早申请到额度的条目，因此不会死锁。
+// 预读和流式读取都经过limiter限速
+func writeEntriesReadAhead(tarWriter *tar.Writer, entries []tarEntry, index *indexBuilder, budget *byteBudget, limiter *rateLimiter) error {
+	results := make([]chan readResult, len(entries))
+	for i := range results {
+		results[i] = make(chan readResult, 1)
+	}
+
+	budget.reopen()
+	defer budget.close()
+
+	go func() {
+		workers := make(chan struct{}, readAheadWorkers)
+		for i, entry := range entries {
+			if !entry.buffered(budget) {
+				continue
+			}
+			if !budget.acquire(entry.header.Size) {
+				return
+			}
+
+			workers <- struct{}{}
+			go func(i int, entry tarEntry) {
+				defer func() { <-workers }()
+				data, err := readEntry(entry, limiter)
+				results[i] <- readResult{data: data, err: err}
+			}(i, entry)
+		}
+	}()
+
+	for i, entry := range entries {
+		if index != nil {
+			if err := index.beginEntry(entry.header); err != nil {
+				return err
+			}
+		}
+		if err := tarWriter.WriteHeader(entry.header); err != nil {
+			return err
+		}
+		if entry.header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if !entry.buffered(budget) {
+			if err := copyEntry(tarWriter, entry, limiter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result := <-results[i]
+		if result.err != nil {
+			budget.release(entry.header.Size)
+			return result.err
+		}
+		_, err := tarWriter.Write(result.data)
+		budget.release(entry.header.Size)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readEntry 读取文件内容，大小必须与扫描时记录的一致
+func readEntry(entry tarEntry, limiter *rateLimiter) ([]byte, error) {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, entry.header.Size)
+	if _, err := io.ReadFull(limiter.reader(file), data); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", entry.path, err)
+	}
+	return data, nil
+}
+
+// copyEntry 将文件内容直接流式写入tar包
+func copyEntry(tarWriter *tar.Writer, entry tarEntry, limiter *rateLimiter) error {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(tarWriter, limiter.reader(file))
+	return err
+}
@@ -0,0 +1,184 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"pbs-backuper/internal/models"
+)
+
+// ErrArchiveMismatch 读回的压缩包与源目录不一致
+var ErrArchiveMismatch = errors.New("archive does not match source directories")
+
+// modTimeSlack 文件系统时间戳的精度可能低于系统时钟（如按时钟节拍或2秒取整），
+// 修改时间在创建压缩包前这段时间内的源条目也可能是创建期间写入的
+const modTimeSlack = 2 * time.Second
+
+// sourceEntry 源目录中应写入压缩包的条目
+type sourceEntry struct {
+	path     string // 本地路径
+	isDir    bool
+	required bool // 修改时间早于创建压缩包的时间，压缩包中必须包含
+}
+
+// VerifyArchive 重新读取刚创建的压缩包，确认条目集合与文件内容和分组的源目录完全一致，
+// 在上传前发现路径处理、截断等压缩器错误。createdAt是开始创建压缩包的时间，
+// 之后才出现或修改的源条目可能是创建期间写入的，压缩包中缺少时不视为错误
+func (a *Archiver) VerifyArchive(archivePath string, group *models.ArchiveGroup, createdAt time.Time) error {
+	expected, err := a.sourceEntries(group, createdAt)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	stream, err := openArchiveStream(file)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	tarReader := tar.NewReader(stream)
+	seen := make(map[string]bool, len(expected))
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: failed to read tar entry: %v", ErrArchiveMismatch, err)
+		}
+
+		entry, exists := expected[header.Name]
+		if !exists {
+			// 创建压缩包之后源文件被删除（如PBS垃圾回收）不是压缩器的错误
+			if _, err := os.Lstat(filepath.Join(a.chunkPath, filepath.FromSlash(header.Name))); os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("%w: unexpected entry %s", ErrArchiveMismatch, header.Name)
+		}
+		if seen[header.Name] {
+			return fmt.Errorf("%w: duplicate entry %s", ErrArchiveMismatch, header.Name)
+		}
+		seen[header.Name] = true
+
+		if entry.isDir != (header.Typeflag == tar.TypeDir) {
+			return fmt.Errorf("%w: entry %s has wrong type", ErrArchiveMismatch, header.Name)
+		}
+		// 创建期间修改过的文件压缩包中可能是任一版本，只比较之前就存在的文件
+		if !entry.isDir && entry.required {
+			if err := compareContent(tarReader, header, entry.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	var missing []string
+	for name, entry := range expected {
+		if entry.required && !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%w: %d entries missing from archive (first: %s)", ErrArchiveMismatch, len(missing), missing[0])
+	}
+	return nil
+}
+
+// sourceEntries 按CreateArchive相同的规则列出分组应写入压缩包的条目（跳过去重的文件和不存在的目录）
+func (a *Archiver) sourceEntries(group *models.ArchiveGroup, createdAt time.Time) (map[string]sourceEntry, error) {
+	skip := make(map[string]bool, len(group.Deduped))
+	for _, entry := range group.Deduped {
+		skip[entry.Path] = true
+	}
+
+	entries := make(map[string]sourceEntry)
+	for _, dir := range group.Directories {
+		dirPath := filepath.Join(a.chunkPath, dir)
+		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+			continue
+		}
+
+		err := filepath.Walk(dirPath, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(a.chunkPath, file)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(relPath)
+			if a.excluded(dirPath, file, info) {
+				return skipExcluded(info)
+			}
+			if skip[name] {
+				return nil
+			}
+			entries[name] = sourceEntry{
+				path:     file,
+				isDir:    info.IsDir(),
+				required: info.ModTime().Before(createdAt.Add(-modTimeSlack)),
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list source directory %s: %w", dir, err)
+		}
+	}
+	return entries, nil
+}
+
+// compareContent 比较tar条目与源文件的大小和内容
+func compareContent(tarReader *tar.Reader, header *tar.Header, sourcePath string) error {
+	info, err := os.Stat(sourcePath)
+	if os.IsNotExist(err) {
+		return nil // 读回期间被删除
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat source file %s: %w", sourcePath, err)
+	}
+	if info.Size() != header.Size {
+		return fmt.Errorf("%w: entry %s has %d bytes, source has %d", ErrArchiveMismatch, header.Name, header.Size, info.Size())
+	}
+
+	archived := sha256.New()
+	if _, err := io.Copy(archived, tarReader); err != nil {
+		return fmt.Errorf("%w: failed to read entry %s: %v", ErrArchiveMismatch, header.Name, err)
+	}
+	source, err := hashSourceFile(sourcePath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(archived.Sum(nil), source) {
+		return fmt.Errorf("%w: entry %s content differs from source", ErrArchiveMismatch, header.Name)
+	}
+	return nil
+}
+
+// hashSourceFile 计算源文件内容的SHA256
+func hashSourceFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, fmt.Errorf("failed to read source file %s: %w", path, err)
+	}
+	return hash.Sum(nil), nil
+}
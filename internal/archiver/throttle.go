@@ -0,0 +1,50 @@
+package archiver
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle 每读取一定数量的目录或字节后暂停，把源磁盘的I/O让给正在运行的PBS。
+// 同一个压缩器创建的所有压缩包（包括并行创建的）共用计数；暂停期间持有锁，
+// 其他压缩包读完当前目录后同样在此等待，整个备份一起让出磁盘
+type throttle struct {
+	mu         sync.Mutex
+	everyDirs  int
+	everyBytes int64
+	pause      time.Duration
+	sleep      func(time.Duration)
+
+	dirs  int
+	bytes int64
+}
+
+// newThrottle 根据选项创建节流器，未设置暂停时长或两个条件都未设置时返回nil
+func newThrottle(options Options) *throttle {
+	if options.ThrottleSleep <= 0 || options.ThrottleDirs <= 0 && options.ThrottleBytes <= 0 {
+		return nil
+	}
+	return &throttle{
+		everyDirs:  options.ThrottleDirs,
+		everyBytes: options.ThrottleBytes,
+		pause:      options.ThrottleSleep,
+		sleep:      time.Sleep,
+	}
+}
+
+// dirDone 记录读完一个目录及其读取的字节数，达到任一条件时暂停并重新计数。nil节流器不做任何事
+func (t *throttle) dirDone(bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.dirs++
+	t.bytes += bytes
+	if (t.everyDirs <= 0 || t.dirs < t.everyDirs) && (t.everyBytes <= 0 || t.bytes < t.everyBytes) {
+		return
+	}
+	t.dirs, t.bytes = 0, 0
+	t.sleep(t.pause)
+}
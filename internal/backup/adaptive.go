@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"pbs-backuper/internal/logger"
+)
+
+const (
+	// DefaultMaxAdaptiveConcurrency 自适应并发的默认上限
+	DefaultMaxAdaptiveConcurrency = 16
+
+	// adaptiveGain 一个统计窗口的吞吐量至少比上一个窗口高出该比例，才认为增加并发有效
+	adaptiveGain = 0.1
+)
+
+// workerLimiter 控制同时运行的任务数。acquire在有空位前阻塞，
+// release在任务结束后调用，报告处理的字节数以及是否因传输错误失败
+type workerLimiter interface {
+	acquire()
+	release(bytes int64, failed bool)
+}
+
+// fixedLimiter 固定并发数
+type fixedLimiter chan struct{}
+
+func newFixedLimiter(concurrency int) fixedLimiter {
+	return make(fixedLimiter, concurrency)
+}
+
+func (l fixedLimiter) acquire() { l <- struct{}{} }
+
+func (l fixedLimiter) release(int64, bool) { <-l }
+
+// adaptiveLimiter 根据观测到的吞吐量调整并发数。每完成limit个任务为一个统计窗口，
+// 窗口吞吐量为完成任务的总字节数除以窗口经过的时间：比上一个窗口提高adaptiveGain以上时并发加一，
+// 否则认为已到达瓶颈，撤销上一次增加并保持不变；任务因传输错误失败时并发减半，同样不再增加
+type adaptiveLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	now  func() time.Time // 测试时替换
+
+	min, max int
+	limit    int // 当前并发数
+	active   int // 正在运行的任务数
+	settled  bool
+
+	windowStart time.Time
+	windowBytes int64
+	windowDone  int
+	previous    float64 // 上一个窗口的吞吐量（字节/秒）
+}
+
+func newAdaptiveLimiter(min, max int) *adaptiveLimiter {
+	l := &adaptiveLimiter{min: min, max: max, limit: min, now: time.Now}
+	l.cond = sync.NewCond(&l.mu)
+	l.windowStart = l.now()
+	return l
+}
+
+func (l *adaptiveLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+func (l *adaptiveLimiter) release(bytes int64, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.cond.Broadcast()
+	l.active--
+
+	if failed {
+		if limit := max(l.min, l.limit/2); limit < l.limit {
+			l.setLimit(limit, "传输出错")
+		}
+		l.settled = true
+		l.resetWindow(0)
+		return
+	}
+
+	l.windowBytes += bytes
+	l.windowDone++
+	if l.windowDone < l.limit {
+		return
+	}
+
+	seconds := max(l.now().Sub(l.windowStart).Seconds(), 1e-9)
+	throughput := float64(l.windowBytes) / seconds
+	if !l.settled {
+		if throughput > l.previous*(1+adaptiveGain) {
+			if l.limit < l.max {
+				l.setLimit(l.limit+1, fmt.Sprintf("吞吐量 %.1f MB/s", throughput/1e6))
+			}
+		} else {
+			// 上一次增加没有带来提升，退回并保持
+			if l.limit > l.min {
+				l.setLimit(l.limit-1, fmt.Sprintf("吞吐量 %.1f MB/s 不再提高", throughput/1e6))
+			}
+			l.settled = true
+		}
+	}
+	l.resetWindow(throughput)
+}
+
+// setLimit 调整并发数并记录原因，调用方持有锁
+func (l *adaptiveLimiter) setLimit(limit int, reason string) {
+	logger.Info(fmt.Sprintf("自适应并发: %s，并发数 %d -> %d", reason, l.limit, limit))
+	l.limit = limit
+}
+
+// resetWindow 开始新的统计窗口，调用方持有锁
+func (l *adaptiveLimiter) resetWindow(throughput float64) {
+	l.previous = throughput
+	l.windowStart = l.now()
+	l.windowBytes = 0
+	l.windowDone = 0
+}
+
+// currentLimit 返回当前并发数
+func (l *adaptiveLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// DefaultFullThreshold 自动模式下变化目录比例超过该值时执行全量备份
+const DefaultFullThreshold = 0.5
+
+// RunAutoBackup 根据变化量自动选择全量或增量备份。
+// 远程没有元数据或变化目录占比超过FullThreshold时执行全量备份，否则执行增量备份。
+// 已有备份时沿用元数据中的前缀位数和目录批次大小，保证压缩包名称与远程一致。
+// 选择增量备份时沿用已加载的元数据和比较结果，不重新扫描
+func (bm *BackupManager) RunAutoBackup(ctx context.Context) (*models.BackupResult, error) {
+	bm.startBudget()
+	startTime := time.Now()
+	metadata, err := bm.loadRemoteMetadata(ctx)
+	if errors.Is(err, ErrNoMetadata) {
+		logger.Info("远程不存在备份元数据，执行全量备份")
+		return bm.runAutoFull(ctx, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous backup metadata: %w", err)
+	}
+
+	// 全量备份会覆盖远程元数据，决定之前先确认datastore一致
+	datastoreID, err := bm.datastoreID()
+	if err != nil {
+		return nil, err
+	}
+	if err := bm.checkDatastore(metadata, datastoreID); err != nil {
+		return nil, err
+	}
+
+	comparison, drift, err := bm.measureDrift(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if drift > bm.config.FullThreshold {
+		logger.Info(fmt.Sprintf("变化目录比例 %.1f%% 超过阈值 %.1f%%，执行全量备份", drift*100, bm.config.FullThreshold*100))
+		if metadata.PrefixDigits != bm.config.PrefixDigits || metadata.DirBatchSize != bm.config.DirBatchSize {
+			logger.Info(fmt.Sprintf("沿用上次备份的分组方式: 前缀位数 %d，每个压缩包最多 %d 个目录（0表示不限）", metadata.PrefixDigits, metadata.DirBatchSize))
+			bm.config.PrefixDigits = metadata.PrefixDigits
+			bm.config.DirBatchSize = metadata.DirBatchSize
+		}
+		return bm.runAutoFull(ctx, drift)
+	}
+
+	logger.Info(fmt.Sprintf("变化目录比例 %.1f%% 未超过阈值 %.1f%%，执行增量备份", drift*100, bm.config.FullThreshold*100))
+	result, err := bm.runIncrementalFrom(ctx, &incrementalBase{startTime: startTime, metadata: metadata, comparison: comparison})
+	if result != nil {
+		result.Mode = "incremental"
+		result.Drift = drift
+	}
+	return result, err
+}
+
+// runAutoFull 执行全量备份并记录自动模式的选择结果
+func (bm *BackupManager) runAutoFull(ctx context.Context, drift float64) (*models.BackupResult, error) {
+	result, err := bm.RunFullBackup(ctx)
+	if result != nil {
+		result.Mode = "full"
+		result.Drift = drift
+	}
+	return result, err
+}
+
+// measureDrift 扫描当前文件树并与元数据比较，返回比较结果和变化目录占新旧文件树目录总数的比例
+func (bm *BackupManager) measureDrift(ctx context.Context, metadata *models.BackupMetadata) (*treeComparison, float64, error) {
+	compareMode, err := scanner.ParseCompareMode(bm.config.CompareMode)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	comparison, err := bm.compareWithPrevious(ctx, metadata, compareMode)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(comparison.current)
+	for dir := range comparison.oldSizes {
+		if _, exists := comparison.current[dir]; !exists {
+			total++
+		}
+	}
+	if total == 0 {
+		return comparison, 0, nil
+	}
+	return comparison, float64(len(comparison.changed)) / float64(total), nil
+}
@@ -2,11 +2,15 @@ package backup
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"pbs-backuper/internal/archiver"
@@ -21,6 +25,14 @@ const (
 	MetadataVersion  = 1
 	ChunkDirName     = "chunk"
 	Sha256DirName    = "sha256"
+	IndexDirName     = "index"
+	BlobDirName      = "blob"
+
+	// minUploadTimeout 按吞吐量计算上传截止时间时的下限，覆盖连接建立等固定开销
+	minUploadTimeout = time.Minute
+
+	// uploadingSuffix 替换远程文件时先上传到的临时名称的后缀
+	uploadingSuffix = ".uploading"
 )
 
 // BackupManager 备份管理器
@@ -29,25 +41,133 @@ type BackupManager struct {
 	storage  storage.Storage
 	scanner  *scanner.ChunkScanner
 	archiver *archiver.Archiver
+	runID    string // 本次运行的标识，记录到元数据和备份结果中
+	host     string // 运行备份的主机名
+
+	tempIndex uint64 // 轮流选择压缩包临时目录的计数
+
+	tempFiles *tempFileManager // 本次运行创建的临时文件
+
+	postProcessors []PostProcessor // 压缩包创建后依次执行的后处理器
+
+	status *statusTracker // 启用StatusFile时定期写入的运行状态，未启用时为nil
+
+	runBase string // 启用每次运行独立子目录时的顶层远程路径，RemotePath为其下本次运行的子目录
+
+	metadataFromPrev bool // 主元数据损坏，本次加载的是.prev
+
+	remoteSums *remoteChecksumCache // 增量备份预先读取的远程校验和，见prefetchRemoteChecksums
+
+	budgetStart time.Time // MaxRuntime的计时起点
+	budgetSpent bool      // 已达到MaxRuntime，不再开始新的压缩包组
+
+	now func() time.Time // 生成带时间戳后缀的对象名时使用的时钟，测试时替换
 }
 
 // NewBackupManager 创建备份管理器
 func NewBackupManager(config *models.Config, storage storage.Storage) *BackupManager {
-	return &BackupManager{
+	scannerOptions := scanner.Options{
+		MaxDirSize:      config.MaxDirSize,
+		IncludePrefixes: config.IncludePrefixes,
+		HashFiles:       config.CompareMode == string(scanner.CompareHash) || config.Dedupe || config.SmartFull,
+		HashAlgorithm:   config.TreeHash,
+		NewerThan:       scanCutoff(config),
+		HexDigits:       config.HexDigits,
+		LooseHex:        config.LooseHex,
+		ExcludePatterns: config.ExcludePatterns,
+		HashBufferSize:  config.HashBufferSize,
+		ContinueOnError: config.SkipScanErrors,
+		MaxDepth:        config.MaxScanDepth,
+		TrackInodes:     config.TrackInodes,
+	}
+	archiverOptions := archiver.Options{
+		TarIndex:         config.TarIndex,
+		SmartCompression: config.SmartCompress,
+		ReadBufferBytes:  config.ReadBufferBytes,
+		ParallelGzip:     config.ParallelGzip,
+		PreallocateTemp:  config.PreallocateTemp,
+		HashBufferSize:   config.HashBufferSize,
+		HexDigits:        config.HexDigits,
+		Compression:      config.Compression,
+		ChecksumStyle:    config.ChecksumStyle,
+		ExcludePatterns:  config.ExcludePatterns,
+		ThrottleDirs:     config.ThrottleDirs,
+		ThrottleBytes:    config.ThrottleBytes,
+		ThrottleSleep:    config.ThrottleSleep,
+		ReadRateLimit:    config.ReadRateLimit,
+		Fsync:            config.FsyncMetadata,
+	}
+
+	runID := config.RunID
+	if runID == "" {
+		runID = NewRunID()
+	}
+	host, _ := os.Hostname()
+
+	bm := &BackupManager{
 		config:   config,
 		storage:  storage,
-		scanner:  scanner.NewChunkScanner(config.ChunkPath),
-		archiver: archiver.NewArchiver(config.ChunkPath, config.TempPath),
+		scanner:  scanner.NewChunkScannerWithOptions(config.ChunkPath, scannerOptions),
+		archiver: archiver.NewArchiverWithOptions(config.ChunkPath, config.TempPath, archiverOptions),
+		runID:    runID,
+		host:     host,
+
+		tempFiles: newTempFileManager(),
+		now:       time.Now,
 	}
+	if config.StatusFile {
+		bm.status = newStatusTracker(config, storage, runID)
+	}
+	if config.SignKey != "" {
+		bm.AddPostProcessor(NewGPGSigner(config.GPGBinary, config.SignKey))
+	}
+	return bm
 }
 
-// RunFullBackup 执行全量备份
+// SetScanProgress 设置扫描chunk目录时的进度回调，参数为已扫描的目录数和目录总数
+func (bm *BackupManager) SetScanProgress(progress func(done, total int)) {
+	bm.scanner.SetProgress(progress)
+}
+
+// NewRunID 生成随机的运行标识（UUID v4格式），用于关联日志、元数据和备份结果
+func NewRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// 系统随机数不可用时退回到时间戳，仍能区分不同的运行
+		return fmt.Sprintf("run-%d", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// RunFullBackup 执行全量备份。部分压缩包失败时同时返回结果和PartialFailureError
 func (bm *BackupManager) RunFullBackup(ctx context.Context) (*models.BackupResult, error) {
+	defer bm.tempFiles.guard(ctx)()
+	bm.startBudget()
+
+	result, err := bm.runFullBackup(ctx)
+	bm.status.finish(err)
+	return result, err
+}
+
+// runFullBackup 全量备份的处理过程
+func (bm *BackupManager) runFullBackup(ctx context.Context) (*models.BackupResult, error) {
+	bm.status.setPhase(PhaseScanning)
 	startTime := time.Now()
 	result := &models.BackupResult{
+		RunID:   bm.runID,
 		Details: make(map[string]string),
 	}
 
+	datastoreID, err := bm.datastoreID()
+	if err != nil {
+		return nil, err
+	}
+	if err := bm.requireChunkDirectories(); err != nil {
+		return nil, err
+	}
+
 	// 1. 扫描文件树
 	fileTree, err := bm.scanner.ScanFileTree()
 	if err != nil {
@@ -59,17 +179,80 @@ func (bm *BackupManager) RunFullBackup(ctx context.Context) (*models.BackupResul
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chunk directories: %w", err)
 	}
+	directories = bm.filterScannedDirectories(directories, fileTree, result)
+
+	if bm.config.PBSVerify {
+		if err := bm.verifySourceChunks(fileTree, directories, result); err != nil {
+			return nil, err
+		}
+	}
 
 	// 3. 生成压缩包分组
-	groups, err := bm.archiver.GenerateArchiveGroups(directories, bm.config.PrefixDigits)
+	groups, err := bm.archiver.GenerateBatchedArchiveGroups(directories, bm.config.PrefixDigits, bm.config.DirBatchSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate archive groups: %w", err)
 	}
+	groups, err = bm.fitObjectSize(groups, directories, fileTree)
+	if err != nil {
+		return nil, err
+	}
+	groups, mergedRanges, err := bm.mergeSmallGroups(groups, fileTree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge archive groups: %w", err)
+	}
 
-	// 4. 创建所有压缩包
-	checksums := make(map[string]string)
+	if bm.config.Dedupe {
+		assignDedupedFiles(groups, fileTree)
+	}
+
+	var previous *models.BackupMetadata
+	if bm.config.SmartFull {
+		previous = bm.smartFullBaseline(ctx, datastoreID)
+	}
+
+	// 4. 创建所有压缩包，处理结果记录到新的备份元数据中
+	metadata := &models.BackupMetadata{
+		Version:      MetadataVersion,
+		PrefixDigits: bm.config.PrefixDigits,
+		DirBatchSize: bm.config.DirBatchSize,
+		MergedRanges: mergedRanges,
+		DatastoreID:  datastoreID,
+		BackupTime:   startTime,
+		FileTree:     fileTree,
+		Checksums:    make(map[string]string),
+		SourceSums:   make(map[string]string),
+		Dedupe:       make(map[string][]models.DedupeEntry),
+		Compression:  make(map[string]string),
+		Sidecars:     make(map[string][]models.Sidecar),
+	}
+	metadata.ArchiveFormat = metadataFormat(bm.config.Compression)
+	metadata.TreeHash = metadataTreeHash(bm.config.TreeHash)
+	if previous != nil {
+		// 沿用的压缩包的校验和文件仍按上次的方式保存
+		metadata.ChecksumFiles = previous.ChecksumFiles
+	}
+	if !bm.config.StagedCutoff.IsZero() {
+		bm.trackPending(metadata, nil, result)
+	}
+	bm.status.startGroups(len(groups))
 	for _, group := range groups {
-		err := bm.processArchiveGroup(ctx, group, checksums, result, false)
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("backup cancelled: %w", err)
+		}
+
+		// 智能全量备份：源内容与上次相同的压缩包沿用上次的记录
+		if previous != nil && reuseArchive(group, previous, metadata) {
+			result.SkippedArchives++
+			result.Details[group.ArchiveName] = "source unchanged, skipped"
+			bm.status.groupDone()
+			continue
+		}
+		if bm.outOfTime() {
+			deferGroup(group, metadata, nil, result)
+			continue
+		}
+
+		err := bm.processArchiveGroup(ctx, group, metadata, result, false)
 		if err != nil {
 			logger.Error(fmt.Sprintf("处理压缩包组失败: %s, %s", group.ArchiveName, err))
 			result.ErrorArchives = append(result.ErrorArchives, group.ArchiveName)
@@ -77,17 +260,11 @@ func (bm *BackupManager) RunFullBackup(ctx context.Context) (*models.BackupResul
 		} else {
 			logger.Info(fmt.Sprintf("成功处理压缩包组: %s", group.ArchiveName))
 		}
+		bm.status.groupDone()
 	}
 
-	// 5. 创建并上传备份元数据
-	metadata := &models.BackupMetadata{
-		Version:      MetadataVersion,
-		PrefixDigits: bm.config.PrefixDigits,
-		BackupTime:   startTime,
-		FileTree:     fileTree,
-		Checksums:    checksums,
-	}
-
+	// 5. 上传备份元数据
+	bm.status.setPhase(PhaseMetadata)
 	err = bm.saveAndUploadMetadata(ctx, metadata)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
@@ -96,56 +273,229 @@ func (bm *BackupManager) RunFullBackup(ctx context.Context) (*models.BackupResul
 	result.TotalArchives = len(groups)
 	result.Duration = time.Since(startTime)
 
-	return result, nil
+	return result, backupOutcome(result)
 }
 
-// RunIncrementalBackup 执行增量备份
+// RunIncrementalBackup 执行增量备份。部分压缩包失败时同时返回结果和PartialFailureError
 func (bm *BackupManager) RunIncrementalBackup(ctx context.Context) (*models.BackupResult, error) {
+	return bm.runIncrementalFrom(ctx, nil)
+}
+
+// incrementalBase 自动模式选择增量备份时已经完成的准备：加载的上次元数据和扫描比较的结果，
+// 增量备份直接沿用，不再重新加载和扫描（哈希比较时避免把datastore读取两遍）
+type incrementalBase struct {
+	startTime  time.Time
+	metadata   *models.BackupMetadata
+	comparison *treeComparison
+}
+
+// runIncrementalFrom 执行增量备份，base为nil时自行加载元数据并扫描比较
+func (bm *BackupManager) runIncrementalFrom(ctx context.Context, base *incrementalBase) (*models.BackupResult, error) {
+	defer bm.tempFiles.guard(ctx)()
+	bm.startBudget()
+
+	result, err := bm.runIncrementalBackup(ctx, base)
+	bm.status.finish(err)
+	return result, err
+}
+
+// runIncrementalBackup 增量备份的处理过程
+func (bm *BackupManager) runIncrementalBackup(ctx context.Context, base *incrementalBase) (*models.BackupResult, error) {
+	if bm.config.VerifyRemote {
+		return bm.runVerifyRemoteBackup(ctx)
+	}
+
+	bm.status.setPhase(PhaseScanning)
 	startTime := time.Now()
+	if base != nil {
+		startTime = base.startTime
+	}
 	result := &models.BackupResult{
+		RunID:   bm.runID,
 		Details: make(map[string]string),
 	}
 
-	// 1. 下载并解析上次的备份元数据
-	oldMetadata, err := bm.loadRemoteMetadata(ctx)
+	compareMode, err := scanner.ParseCompareMode(bm.config.CompareMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 下载并解析上次的备份元数据，不存在且启用了AutoFull时改为全量备份
+	var oldMetadata *models.BackupMetadata
+	if base != nil {
+		oldMetadata = base.metadata
+	} else {
+		oldMetadata, err = bm.loadRemoteMetadata(ctx)
+	}
+	if errors.Is(err, ErrNoMetadata) && bm.config.AutoFull {
+		logger.Warn(fmt.Sprintf("远程不存在备份元数据，自动改为全量备份（前缀位数: %d）", bm.config.PrefixDigits))
+		return bm.runFullBackup(ctx)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to load previous backup metadata: %w", err)
 	}
 
-	// 2. 扫描当前文件树
-	currentFileTree, err := bm.scanner.ScanFileTree()
+	// 确认chunk目录与上次备份属于同一个datastore，避免覆盖其他datastore的备份
+	datastoreID, err := bm.datastoreID()
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan current file tree: %w", err)
+		return nil, err
+	}
+	if err := bm.checkDatastore(oldMetadata, datastoreID); err != nil {
+		return nil, err
+	}
+	if err := bm.checkNamespace(oldMetadata); err != nil {
+		return nil, err
+	}
+	// 空的chunk路径会让所有目录看起来都已删除
+	if err := bm.requireChunkDirectories(); err != nil {
+		return nil, err
+	}
+
+	// 压缩包格式决定压缩包名，沿用上次备份的格式
+	if format := oldMetadata.ArchiveFormat; !sameCompression(format, bm.config.Compression) {
+		logger.Warn(fmt.Sprintf("压缩包格式与上次备份不一致，沿用上次的格式（%s）", archiver.ArchiveExtension(format)))
+	}
+	bm.archiver = bm.archiverFor(oldMetadata)
+
+	// 2. 扫描当前文件树并与上次的文件树比较，找出变化的目录
+	var comparison *treeComparison
+	if base != nil {
+		comparison = base.comparison
+	} else if comparison, err = bm.compareWithPrevious(ctx, oldMetadata, compareMode); err != nil {
+		return nil, err
 	}
+	moves := bm.detectMoves(oldMetadata, comparison)
+	currentFileTree, changedDirs := comparison.current, comparison.changed
 
-	// 3. 比较文件树，找出变化的目录
-	changedDirs := scanner.CompareFileTrees(oldMetadata.FileTree, currentFileTree)
+	// 校验基线：元数据中的校验和应与按原前缀位数生成的分组一一对应
+	missing := bm.checkBaseline(oldMetadata, comparison.oldSizes)
+
+	if bm.config.GrowthReport > 0 {
+		result.GrowthReport = scanner.BuildGrowthReportFromSizes(comparison.oldSizes, currentFileTree, bm.config.GrowthReport)
+	}
 
 	// 4. 获取当前chunk目录列表
 	directories, err := bm.scanner.GetChunkDirectories()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chunk directories: %w", err)
 	}
+	directories = bm.filterScannedDirectories(directories, currentFileTree, result)
+
+	// 没有目录变化且未要求修复时直接返回，不生成分组也不重新上传元数据。
+	// 分阶段备份的待备份目录被删除时、识别出新的移动文件时、校验和文件的保存方式改变时仍需更新元数据中的记录
+	pendingChanged := len(oldMetadata.PendingDirs) > 0 && !slices.Equal(bm.pendingDirectories(currentFileTree), oldMetadata.PendingDirs)
+	movesChanged := !slices.Equal(moves, oldMetadata.Moves)
+	sumFilesChanged := oldMetadata.ChecksumFiles != bm.checksumFilesMode()
+	if len(changedDirs) == 0 && !bm.config.Repair && !pendingChanged && !movesChanged && !sumFilesChanged {
+		logger.Info("没有目录发生变化，跳过本次增量备份")
+		for name := range oldMetadata.Checksums {
+			result.Details[name] = "unchanged, skipped"
+		}
+		result.TotalArchives = len(oldMetadata.Checksums)
+		result.SkippedArchives = result.TotalArchives
+		result.Duration = time.Since(startTime)
+		return result, nil
+	}
+
+	// 只校验发生变化的目录，未变化的目录上次已经备份过
+	if bm.config.PBSVerify {
+		var changed []string
+		for _, dir := range directories {
+			if changedDirs[dir] {
+				changed = append(changed, dir)
+			}
+		}
+		if err := bm.verifySourceChunks(currentFileTree, changed, result); err != nil {
+			return nil, err
+		}
+	}
 
 	// 5. 使用原前缀位数生成压缩包分组
-	groups, err := bm.archiver.GenerateArchiveGroups(directories, oldMetadata.PrefixDigits)
+	groups, err := archiveGroups(bm.archiver, directories, oldMetadata)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate archive groups: %w", err)
 	}
 
 	// 6. 标记需要更新的压缩包
 	bm.archiver.MarkGroupsForUpdate(groups, changedDirs)
+	if bm.config.Repair {
+		markMissingGroups(groups, missing)
+	}
+	forceMoveTargets(groups, moves)
+	if bm.config.Dedupe {
+		assignDedupedFiles(groups, currentFileTree)
+	}
 
-	// 7. 处理需要更新的压缩包
-	checksums := make(map[string]string)
-	// 首先复制旧的校验和
+	// 7. 处理需要更新的压缩包，未更新的压缩包沿用上次的记录
+	metadata := &models.BackupMetadata{
+		Version:      MetadataVersion,
+		PrefixDigits: oldMetadata.PrefixDigits,
+		DirBatchSize: oldMetadata.DirBatchSize,
+		MergedRanges: oldMetadata.MergedRanges,
+		DatastoreID:  datastoreID,
+		BackupTime:   startTime,
+		FileTree:     currentFileTree,
+		Checksums:    make(map[string]string),
+		Dedupe:       make(map[string][]models.DedupeEntry),
+		Compression:  make(map[string]string),
+		Sidecars:     make(map[string][]models.Sidecar),
+	}
+	metadata.ArchiveFormat = oldMetadata.ArchiveFormat
+	metadata.TreeHash = metadataTreeHash(bm.config.TreeHash)
+	metadata.ChecksumFiles = oldMetadata.ChecksumFiles
 	for k, v := range oldMetadata.Checksums {
-		checksums[k] = v
+		metadata.Checksums[k] = v
+	}
+	for k, v := range oldMetadata.SourceSums {
+		if metadata.SourceSums == nil {
+			metadata.SourceSums = make(map[string]string)
+		}
+		metadata.SourceSums[k] = v
+	}
+	for k, v := range oldMetadata.Dedupe {
+		metadata.Dedupe[k] = v
+	}
+	for k, v := range oldMetadata.Compression {
+		metadata.Compression[k] = v
+	}
+	for k, v := range oldMetadata.Sidecars {
+		metadata.Sidecars[k] = v
+	}
+	for k, v := range oldMetadata.Objects {
+		setArchiveObject(metadata, k, v)
+	}
+	for k, v := range oldMetadata.Hashes {
+		setArchiveHashes(metadata, k, v)
+	}
+	if len(oldMetadata.PendingDirs) > 0 {
+		bm.trackPending(metadata, oldMetadata.PendingDirs, result)
 	}
 
+	// 并发读取需要更新的分组的远程校验和，与打包和上传的顺序处理分开
+	var previous []string
 	for _, group := range groups {
 		if group.NeedsUpdate {
-			err := bm.processArchiveGroup(ctx, group, checksums, result, true) // 增量备份检查远程校验和
+			previous = append(previous, archiveObject(metadata, group.ArchiveName))
+		}
+	}
+	bm.prefetchRemoteChecksums(ctx, previous, hasChecksumBundle(metadata))
+
+	updates := 0
+	for _, group := range groups {
+		if group.NeedsUpdate {
+			updates++
+		}
+	}
+	bm.status.startGroups(updates)
+	for _, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("backup cancelled: %w", err)
+		}
+
+		if group.NeedsUpdate && bm.outOfTime() {
+			deferGroup(group, metadata, oldMetadata.FileTree, result)
+		} else if group.NeedsUpdate {
+			err := bm.processArchiveGroup(ctx, group, metadata, result, true) // 增量备份检查远程校验和
 			if err != nil {
 				logger.Error(fmt.Sprintf("处理压缩包组失败: %s", group.ArchiveName))
 				result.ErrorArchives = append(result.ErrorArchives, group.ArchiveName)
@@ -153,21 +503,26 @@ func (bm *BackupManager) RunIncrementalBackup(ctx context.Context) (*models.Back
 			} else {
 				logger.Info(fmt.Sprintf("成功处理压缩包组: %s", group.ArchiveName))
 			}
+			bm.status.groupDone()
 		} else {
 			result.SkippedArchives++
 			result.Details[group.ArchiveName] = "unchanged, skipped"
 		}
 	}
 
-	// 8. 创建并上传新的备份元数据
-	metadata := &models.BackupMetadata{
-		Version:      MetadataVersion,
-		PrefixDigits: oldMetadata.PrefixDigits,
-		BackupTime:   startTime,
-		FileTree:     currentFileTree,
-		Checksums:    checksums,
+	metadata.Moves = keptMoves(moves, groups, result)
+	result.MovedFiles = len(metadata.Moves)
+	if err := dropRemovedArchives(bm.archiver, metadata); err != nil {
+		return nil, fmt.Errorf("failed to generate archive groups: %w", err)
 	}
 
+	// 修复模式下把格式不规范但内容正确的校验和文件改写为标准格式。启用时间戳后缀时不改写远程已有的对象
+	if bm.config.Repair && !bm.config.ArchiveSuffix {
+		bm.rewriteChecksumFiles(ctx, metadata, result)
+	}
+
+	// 8. 上传新的备份元数据
+	bm.status.setPhase(PhaseMetadata)
 	err = bm.saveAndUploadMetadata(ctx, metadata)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
@@ -176,34 +531,346 @@ func (bm *BackupManager) RunIncrementalBackup(ctx context.Context) (*models.Back
 	result.TotalArchives = len(groups)
 	result.Duration = time.Since(startTime)
 
-	return result, nil
+	return result, backupOutcome(result)
+}
+
+// checkBaseline 检查上次备份的元数据是否完整，不一致时输出警告，返回缺少校验和的压缩包。
+// 缺少校验和通常说明上次备份有压缩包失败，多余的校验和则对应已不存在的分组。
+// oldSizes为比较时读取的上次文件树中的目录，文件树拆分保存、流式比较时元数据中没有文件树
+func (bm *BackupManager) checkBaseline(metadata *models.BackupMetadata, oldSizes map[string]int64) []string {
+	missing, extra, err := bm.baselineDivergence(metadata, oldSizes)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("无法校验备份元数据: %v", err))
+		return nil
+	}
+
+	if len(missing) > 0 {
+		logger.Warn(fmt.Sprintf("备份元数据缺少%d个压缩包的校验和，上次备份可能不完整: %s", len(missing), strings.Join(missing, ", ")))
+	}
+	if len(extra) > 0 {
+		logger.Warn(fmt.Sprintf("备份元数据包含%d个文件树中没有对应目录的压缩包: %s", len(extra), strings.Join(extra, ", ")))
+	}
+	return missing
+}
+
+// markMissingGroups 将上次备份缺少校验和的压缩包标记为需要更新，用于--repair
+func markMissingGroups(groups []*models.ArchiveGroup, missing []string) {
+	names := make(map[string]bool, len(missing))
+	for _, name := range missing {
+		names[name] = true
+	}
+	for _, group := range groups {
+		if names[group.ArchiveName] && !group.NeedsUpdate {
+			logger.Info(fmt.Sprintf("修复模式: 重新生成缺少校验和的压缩包 %s", group.ArchiveName))
+			group.NeedsUpdate = true
+		}
+	}
+}
+
+// archiverFor 返回按元数据记录的压缩包格式生成压缩包的压缩器，生成的压缩包名与该元数据一致
+func (bm *BackupManager) archiverFor(metadata *models.BackupMetadata) *archiver.Archiver {
+	return bm.archiver.WithCompression(metadata.ArchiveFormat)
+}
+
+// archiveGroups 按元数据记录的分组方式（前缀位数、目录批次和合并范围）生成压缩包分组
+func archiveGroups(a *archiver.Archiver, directories []string, metadata *models.BackupMetadata) ([]*models.ArchiveGroup, error) {
+	groups, err := a.GenerateBatchedArchiveGroups(directories, metadata.PrefixDigits, metadata.DirBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	return a.MergeArchiveGroups(groups, metadata.MergedRanges)
+}
+
+// mergeSmallGroups 配置了MinArchiveSize时按文件树中的目录大小合并相邻的小分组，
+// 返回合并后的分组和需要记录到元数据中的合并范围
+func (bm *BackupManager) mergeSmallGroups(groups []*models.ArchiveGroup, fileTree map[string]*models.FileTreeNode) ([]*models.ArchiveGroup, []string, error) {
+	if bm.config.MinArchiveSize <= 0 {
+		return groups, nil, nil
+	}
+	ranges := archiver.PlanMergedRanges(groups, scanner.TreeSizes(fileTree), bm.config.MinArchiveSize)
+	merged, err := bm.archiver.MergeArchiveGroups(groups, ranges)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ranges) > 0 {
+		logger.Info(fmt.Sprintf("合并小于 %d 字节的相邻分组: %d 个分组合并为 %d 个压缩包", bm.config.MinArchiveSize, len(groups), len(merged)))
+	}
+	return merged, ranges, nil
+}
+
+// sameCompression 判断元数据记录的压缩包格式与配置是否一致（空值都表示gzip）
+func sameCompression(format, compression string) bool {
+	return metadataFormat(format) == metadataFormat(compression)
+}
+
+// metadataTreeHash 返回记录到元数据TreeHash中的文件哈希算法，默认的SHA256记录为空，与旧版本的元数据一致
+func metadataTreeHash(algorithm string) string {
+	if algorithm == scanner.HashXXH3 {
+		return algorithm
+	}
+	return ""
+}
+
+// metadataFormat 返回记录到元数据ArchiveFormat中的压缩包格式，默认的gzip记录为空
+func metadataFormat(compression string) string {
+	if compression == archiver.CompressionGzip {
+		return ""
+	}
+	return compression
+}
+
+// baselineDivergence 比较元数据文件树中的目录（oldSizes的键）按PrefixDigits生成的压缩包与Checksums中的压缩包，
+// 返回缺少校验和的压缩包和多余的校验和（均按名称排序）
+func (bm *BackupManager) baselineDivergence(metadata *models.BackupMetadata, oldSizes map[string]int64) (missing, extra []string, err error) {
+	directories := make([]string, 0, len(oldSizes))
+	for dir := range oldSizes {
+		directories = append(directories, dir)
+	}
+
+	groups, err := archiveGroups(bm.archiverFor(metadata), directories, metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expected := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		expected[group.ArchiveName] = true
+		if _, exists := metadata.Checksums[group.ArchiveName]; !exists {
+			missing = append(missing, group.ArchiveName)
+		}
+	}
+	for name := range metadata.Checksums {
+		if !expected[name] {
+			extra = append(extra, name)
+		}
+	}
+
+	scanner.SortHex(missing)
+	scanner.SortHex(extra)
+	return missing, extra, nil
+}
+
+// liveArchives 返回文件树中仍有目录的分组的压缩包名。增量备份沿用上次的记录，
+// 目录已全部从源中删除的分组不在其中
+func liveArchives(a *archiver.Archiver, fileTree map[string]*models.FileTreeNode, metadata *models.BackupMetadata) (map[string]bool, error) {
+	directories := make([]string, 0, len(fileTree))
+	for dir := range fileTree {
+		directories = append(directories, dir)
+	}
+	groups, err := archiveGroups(a, directories, metadata)
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		live[group.ArchiveName] = true
+	}
+	return live, nil
+}
+
+// dropRemovedArchives 删除目录已全部从源中删除的分组的记录，否则恢复、校验和复制会继续处理这些压缩包，
+// 恢复时重新带回已删除的目录。远程对象不在这里删除，由gc作为孤立对象清理
+func dropRemovedArchives(a *archiver.Archiver, metadata *models.BackupMetadata) error {
+	live, err := liveArchives(a, metadata.FileTree, metadata)
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedArchiveNames(metadata.Checksums) {
+		if live[name] {
+			continue
+		}
+		logger.Info(fmt.Sprintf("压缩包 %s 的目录已全部删除，从备份元数据中移除", name))
+		delete(metadata.Checksums, name)
+		delete(metadata.SourceSums, name)
+		delete(metadata.Dedupe, name)
+		delete(metadata.Compression, name)
+		delete(metadata.Sidecars, name)
+		setArchiveObject(metadata, name, name)
+		setArchiveHashes(metadata, name, nil)
+	}
+	return nil
+}
+
+// sortedArchiveNames 返回按十六进制数值排序的压缩包名，
+// 恢复、校验、复制和恢复清单都按这个顺序处理压缩包，输出可以重现
+func sortedArchiveNames(checksums map[string]string) []string {
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	scanner.SortHex(names)
+	return names
+}
+
+// archiveObject 返回压缩包在远程chunk目录中的对象名，校验和文件、tar索引和附加文件都以对象名命名。
+// 启用ArchiveSuffix上传的压缩包记录在元数据的Objects中，其余压缩包的对象名就是压缩包名
+func archiveObject(metadata *models.BackupMetadata, archiveName string) string {
+	if object, exists := metadata.Objects[archiveName]; exists {
+		return object
+	}
+	return archiveName
+}
+
+// setArchiveObject 记录压缩包的远程对象名，与压缩包名相同时删除记录
+func setArchiveObject(metadata *models.BackupMetadata, archiveName, object string) {
+	if object == archiveName {
+		delete(metadata.Objects, archiveName)
+		return
+	}
+	if metadata.Objects == nil {
+		metadata.Objects = make(map[string]string)
+	}
+	metadata.Objects[archiveName] = object
+}
+
+// setArchiveHashes 记录压缩包对象的服务端可校验哈希，hashes为空时删除记录
+func setArchiveHashes(metadata *models.BackupMetadata, archiveName string, hashes models.ObjectHashes) {
+	if len(hashes) == 0 {
+		delete(metadata.Hashes, archiveName)
+		return
+	}
+	if metadata.Hashes == nil {
+		metadata.Hashes = make(map[string]models.ObjectHashes)
+	}
+	metadata.Hashes[archiveName] = hashes
+}
+
+// newArchiveObject 返回上传压缩包使用的对象名。启用ArchiveSuffix时在扩展名前加上上传时间
+// （如0000-00ff.20240101T020000Z.tar.gz），每次上传都是新对象，不覆盖远程已有的压缩包
+func (bm *BackupManager) newArchiveObject(archiveName string, uploadTime time.Time) string {
+	if !bm.config.ArchiveSuffix {
+		return archiveName
+	}
+	stamp := uploadTime.UTC().Format(historyTimeLayout)
+	if base, ext, found := strings.Cut(archiveName, "."); found {
+		return base + "." + stamp + "." + ext
+	}
+	return archiveName + "." + stamp
+}
+
+// removeReplacedObject 删除被不同对象名替换的旧压缩包（例如--compression auto改变了分组的格式和扩展名），
+// 启用ArchiveSuffix时旧对象由保留策略清理。删除失败只记录警告，不影响本次备份
+func (bm *BackupManager) removeReplacedObject(ctx context.Context, previous, object string, sidecars []models.Sidecar) {
+	if bm.config.ArchiveSuffix || previous == object {
+		return
+	}
+	if err := bm.deleteArchive(ctx, previous, sidecars, false); err != nil {
+		logger.Warn(fmt.Sprintf("删除被替换的压缩包 %s 失败: %v", previous, err))
+	}
+}
+
+// filterScannedDirectories 只保留文件树中存在的目录，并记录因超过大小限制或修改时间被排除的目录。
+// 不匹配--include-prefix的目录在增量备份中沿用上次的记录时同样保留，重新生成的压缩包仍包含这些目录
+func (bm *BackupManager) filterScannedDirectories(directories []string, fileTree map[string]*models.FileTreeNode, result *models.BackupResult) []string {
+	excluded := bm.scanner.ExcludedDirectories()
+	for dir, size := range excluded {
+		if _, kept := fileTree[dir]; kept {
+			logger.Warn(fmt.Sprintf("目录 %s 大小为 %d 字节，超过限制 %d 字节，本次不扫描，沿用上次备份的记录", dir, size, bm.config.MaxDirSize))
+		} else {
+			logger.Warn(fmt.Sprintf("目录 %s 大小为 %d 字节，超过限制 %d 字节，已排除", dir, size, bm.config.MaxDirSize))
+		}
+		result.ExcludedDirs = append(result.ExcludedDirs, dir)
+	}
+	scanner.SortHex(result.ExcludedDirs)
+
+	if unreadable := bm.scanner.UnreadableDirectories(); len(unreadable) > 0 {
+		logger.Warn(fmt.Sprintf("%d个目录多次扫描失败，本次已跳过: %s", len(unreadable), strings.Join(unreadable, ", ")))
+		result.UnreadableDirs = unreadable
+	}
+
+	if stale := bm.scanner.StaleDirectories(); len(stale) > 0 {
+		logger.Info(fmt.Sprintf("%d个目录在%s之后没有修改，已跳过", len(stale), scanCutoff(bm.config).Format(time.RFC3339)))
+	}
+
+	filtered := make([]string, 0, len(directories))
+	for _, dir := range slices.Concat(directories, bm.scanner.FilteredDirectories()) {
+		if _, exists := fileTree[dir]; exists {
+			filtered = append(filtered, dir)
+		}
+	}
+	scanner.SortHex(filtered)
+	return filtered
+}
+
+// nextTempPath 返回下一个压缩包使用的临时目录，配置了多个目录时轮流使用以分散磁盘I/O
+func (bm *BackupManager) nextTempPath() string {
+	paths := bm.config.TempPaths
+	if len(paths) == 0 {
+		return bm.config.TempPath
+	}
+	i := atomic.AddUint64(&bm.tempIndex, 1) - 1
+	return paths[i%uint64(len(paths))]
 }
 
 // processArchiveGroup 处理单个压缩包组
-func (bm *BackupManager) processArchiveGroup(ctx context.Context, group *models.ArchiveGroup, checksums map[string]string, result *models.BackupResult, checkRemoteChecksum bool) error {
-	// 1. 创建压缩包
+func (bm *BackupManager) processArchiveGroup(ctx context.Context, group *models.ArchiveGroup, metadata *models.BackupMetadata, result *models.BackupResult, checkRemoteChecksum bool) error {
+	// 1. 创建压缩包（创建失败时可能留下不完整的文件，因此创建前先登记临时文件）
+	tempDir := bm.nextTempPath()
+	localArchivePath := filepath.Join(tempDir, group.ArchiveName)
+	bm.tempFiles.track(localArchivePath)
+	defer bm.tempFiles.remove(localArchivePath)
+	if bm.config.TarIndex {
+		indexPath := archiver.IndexPath(localArchivePath)
+		bm.tempFiles.track(indexPath)
+		defer bm.tempFiles.remove(indexPath)
+	}
+
 	logger.Debug(fmt.Sprintf("Creating archive: %s", group.ArchiveName))
-	archivePath, err := bm.archiver.CreateArchive(group)
+	createdAt := time.Now()
+	archivePath, err := bm.archiver.CreateArchiveIn(group, tempDir)
 	if err != nil {
 		return fmt.Errorf("failed to create archive: %w", err)
 	}
-	defer os.Remove(archivePath) // 清理临时文件
 
-	// 2. 计算校验和
+	// 上传前读回压缩包与源目录逐一比较，在备份时而不是恢复时发现压缩器的错误
+	if bm.config.Paranoid {
+		logger.Debug(fmt.Sprintf("Self-testing archive: %s", group.ArchiveName))
+		if err := bm.archiver.VerifyArchive(archivePath, group, createdAt); err != nil {
+			return fmt.Errorf("archive self-test failed: %w", err)
+		}
+	}
+
+	// 2. 计算校验和，同时计算与服务端记录比较用的CRC32C和MD5
 	logger.Debug(fmt.Sprintf("Calculating checksum for: %s", group.ArchiveName))
-	checksum, err := bm.archiver.CalculateChecksum(archivePath)
+	hashes, err := bm.archiver.CalculateHashes(archivePath)
 	if err != nil {
 		return fmt.Errorf("failed to calculate checksum: %w", err)
 	}
+	checksum := hashes.SHA256
+
+	// 启用时间戳后缀或按分组选择格式时本地压缩包改用新的对象名，校验和文件、tar索引和附加文件随之使用该名称
+	object := bm.newArchiveObject(archiver.FormatObjectName(group.ArchiveName, group.Compression), bm.now())
+	if object != group.ArchiveName {
+		renamed := filepath.Join(tempDir, object)
+		bm.tempFiles.track(renamed)
+		defer bm.tempFiles.remove(renamed)
+		if err := os.Rename(archivePath, renamed); err != nil {
+			return fmt.Errorf("failed to rename archive: %w", err)
+		}
+		if bm.config.TarIndex {
+			bm.tempFiles.track(archiver.IndexPath(renamed))
+			defer bm.tempFiles.remove(archiver.IndexPath(renamed))
+			if err := os.Rename(archiver.IndexPath(archivePath), archiver.IndexPath(renamed)); err != nil {
+				return fmt.Errorf("failed to rename tar index: %w", err)
+			}
+		}
+		archivePath = renamed
+	}
+
+	archiveInfo, err := os.Stat(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat archive: %w", err)
+	}
 
 	// 3. 生成远程路径
-	remoteArchivePath := filepath.Join(bm.config.RemotePath, ChunkDirName, group.ArchiveName)
-	remoteSha256Path := filepath.Join(bm.config.RemotePath, Sha256DirName, group.ArchiveName+".sha256")
+	remoteArchivePath := filepath.Join(bm.config.RemotePath, ChunkDirName, object)
+	remoteSha256Path := filepath.Join(bm.config.RemotePath, Sha256DirName, object+".sha256")
 	needsUpload := true
 
-	// 4. 检查远程校验和是否已存在且相同（根据参数决定是否检查）
+	// 4. 检查远程校验和是否已存在且相同（根据参数决定是否检查），与上次上传的对象比较
 	if checkRemoteChecksum {
-		if remoteChecksum, err := bm.getRemoteChecksum(ctx, remoteSha256Path); err == nil {
+		previous := archiveObject(metadata, group.ArchiveName)
+		if remoteChecksum, err := bm.previousChecksum(ctx, previous); err == nil {
 			if remoteChecksum == checksum {
 				needsUpload = false
 				result.Details[group.ArchiveName] = "checksum unchanged, skipped upload"
@@ -212,31 +879,63 @@ func (bm *BackupManager) processArchiveGroup(ctx context.Context, group *models.
 	}
 
 	if needsUpload {
-		// 5. 上传压缩包
+		if err := bm.checkObjectSize(group.ArchiveName, archiveInfo.Size()); err != nil {
+			return err
+		}
+
+		// 5. 上传去重文件的blob，必须在压缩包之前上传，避免压缩包引用不存在的blob
+		if err := bm.uploadBlobs(ctx, group.Deduped, result); err != nil {
+			return err
+		}
+
+		// 上传压缩包
 		logger.Debug(fmt.Sprintf("Uploading archive: %s", group.ArchiveName))
-		err = bm.storage.UploadFile(ctx, archivePath, remoteArchivePath)
+		err = bm.uploadAtomic(ctx, archivePath, remoteArchivePath, archiveInfo.Size())
 		if err != nil {
 			return fmt.Errorf("failed to upload archive: %w", err)
 		}
-		result.UploadedFiles = append(result.UploadedFiles, ChunkDirName+"/"+group.ArchiveName)
+		result.UploadedFiles = append(result.UploadedFiles, ChunkDirName+"/"+object)
+		result.UploadedBytes += archiveInfo.Size()
+		bm.status.addUploaded(archiveInfo.Size())
 
-		// 6. 创建校验和文件
-		logger.Debug(fmt.Sprintf("Creating checksum for: %s", group.ArchiveName))
-		checksumPath, err := bm.archiver.CreateChecksumFile(archivePath, checksum)
-		if err != nil {
-			return fmt.Errorf("failed to create checksum file: %w", err)
-		}
-		defer os.Remove(checksumPath) // 清理临时文件
+		// 6. 创建并上传校验和文件。只保存汇总校验和文件时删除旧的单独校验和文件，避免留下与压缩包不一致的记录
+		if bm.writesSidecars() {
+			logger.Debug(fmt.Sprintf("Creating checksum for: %s", group.ArchiveName))
+			checksumPath, err := bm.archiver.CreateChecksumFile(archivePath, checksum)
+			if err != nil {
+				return fmt.Errorf("failed to create checksum file: %w", err)
+			}
+			bm.tempFiles.track(checksumPath)
+			defer bm.tempFiles.remove(checksumPath)
 
-		// 7. 上传校验和文件
-		logger.Debug(fmt.Sprintf("Uploading checksum for: %s", group.ArchiveName))
-		err = bm.storage.UploadFile(ctx, checksumPath, remoteSha256Path)
-		if err != nil {
-			return fmt.Errorf("failed to upload checksum file: %w", err)
+			// 7. 上传校验和文件
+			logger.Debug(fmt.Sprintf("Uploading checksum for: %s", group.ArchiveName))
+			err = bm.uploadAtomic(ctx, checksumPath, remoteSha256Path, 0)
+			if err != nil {
+				return fmt.Errorf("failed to upload checksum file: %w", err)
+			}
+
+			result.UploadedFiles = append(result.UploadedFiles, Sha256DirName+"/"+object+".sha256")
+		} else if hasSidecars(metadata) {
+			if err := bm.storage.DeleteFile(ctx, remoteSha256Path); err != nil {
+				logger.Warn(fmt.Sprintf("删除旧的校验和文件失败: %s, %v", group.ArchiveName, remoteError(err)))
+			}
 		}
 
-		result.UploadedFiles = append(result.UploadedFiles, Sha256DirName+"/"+group.ArchiveName+".sha256")
+		// 8. 上传tar索引文件
+		if bm.config.TarIndex {
+			indexPath := archiver.IndexPath(archivePath)
+			logger.Debug(fmt.Sprintf("Uploading tar index for: %s", group.ArchiveName))
+			indexName := filepath.Base(indexPath)
+			err = bm.uploadAtomic(ctx, indexPath, filepath.Join(bm.config.RemotePath, IndexDirName, indexName), 0)
+			if err != nil {
+				return fmt.Errorf("failed to upload tar index: %w", err)
+			}
+			result.UploadedFiles = append(result.UploadedFiles, IndexDirName+"/"+indexName)
+		}
 
+		bm.removeReplacedObject(ctx, archiveObject(metadata, group.ArchiveName), object, metadata.Sidecars[group.ArchiveName])
+		setArchiveObject(metadata, group.ArchiveName, object)
 		result.UpdatedArchives++
 		result.Details[group.ArchiveName] = "created and uploaded"
 	} else {
@@ -244,79 +943,303 @@ func (bm *BackupManager) processArchiveGroup(ctx context.Context, group *models.
 		result.Details[group.ArchiveName] = "checksum unchanged, skipped"
 	}
 
+	// 9. 执行后处理器并上传附加文件。上传被跳过时远程压缩包与本地相同，附加文件同样有效；
+	// 没有配置后处理器时，重新上传的压缩包不再有有效的附加文件。
+	// 启用时间戳后缀时跳过上传的压缩包沿用上次的附加文件，不覆盖远程已有的对象
+	switch {
+	case !needsUpload && bm.config.ArchiveSuffix:
+	case len(bm.postProcessors) > 0:
+		sidecars, err := bm.postProcessArchive(ctx, archivePath, group.ArchiveName, result)
+		if err != nil {
+			return err
+		}
+		metadata.Sidecars[group.ArchiveName] = sidecars
+	case needsUpload:
+		delete(metadata.Sidecars, group.ArchiveName)
+	}
+
 	// 更新校验和映射
-	checksums[group.ArchiveName] = checksum
+	metadata.Checksums[group.ArchiveName] = checksum
+	setArchiveHashes(metadata, group.ArchiveName, models.ObjectHashes{storage.HashCRC32C: hashes.CRC32C, storage.HashMD5: hashes.MD5})
+	if group.Compression != "" {
+		metadata.Compression[group.ArchiveName] = group.Compression
+	} else {
+		delete(metadata.Compression, group.ArchiveName)
+	}
+
+	// 更新去重清单，压缩包中没有省略文件时删除旧清单
+	if len(group.Deduped) > 0 {
+		metadata.Dedupe[group.ArchiveName] = group.Deduped
+	} else {
+		delete(metadata.Dedupe, group.ArchiveName)
+	}
+	setSourceChecksum(metadata, group)
 
 	return nil
 }
 
+// uploadFile 上传文件，配置了最低吞吐量时按文件大小设置本次上传的截止时间
+func (bm *BackupManager) uploadFile(ctx context.Context, localPath, remotePath string, size int64) error {
+	ctx, cancel := bm.uploadContext(ctx, size)
+	defer cancel()
+	return remoteError(bm.storage.UploadFile(ctx, localPath, remotePath))
+}
+
+// uploadAtomic 与uploadFile相同，但通过uploadReplacing上传，读取方只会看到完整的文件。
+// 用于压缩包及其校验和文件和tar索引，备份期间并发的verify/restore不会读到写了一半的压缩包
+func (bm *BackupManager) uploadAtomic(ctx context.Context, localPath, remotePath string, size int64) error {
+	ctx, cancel := bm.uploadContext(ctx, size)
+	defer cancel()
+	return remoteError(bm.uploadReplacing(ctx, localPath, remotePath))
+}
+
+// uploadParentKey 上下文中记录上传父上下文的键，见WithUploadParent
+type uploadParentKey struct{}
+
+// WithUploadParent 返回记录了上传父上下文的ctx。parent通常是只在收到SIGINT/SIGTERM时取消、
+// 没有全局--timeout的上下文；配置了最低吞吐量时上传从parent派生，不受ctx的截止时间约束
+func WithUploadParent(ctx, parent context.Context) context.Context {
+	return context.WithValue(ctx, uploadParentKey{}, parent)
+}
+
+// uploadContext 配置了最低吞吐量时返回只以按大小计算的截止时间为期限的上传上下文。
+// 上传从WithUploadParent记录的父上下文派生：不继承ctx的截止时间（全局--timeout不会中断进行中的上传），
+// 父上下文被取消（如收到SIGINT/SIGTERM，包括全局超时之后）时随之取消；ctx因其他原因被取消时同样取消。
+// 没有记录父上下文时无法区分超时和信号，上传仍受ctx约束
+func (bm *BackupManager) uploadContext(ctx context.Context, size int64) (context.Context, context.CancelFunc) {
+	if bm.config.MinThroughput <= 0 {
+		return ctx, func() {}
+	}
+	parent, ok := ctx.Value(uploadParentKey{}).(context.Context)
+	if !ok {
+		return context.WithTimeout(ctx, uploadTimeout(size, bm.config.MinThroughput))
+	}
+	uploadCtx, cancel := context.WithTimeout(parent, uploadTimeout(size, bm.config.MinThroughput))
+	stop := context.AfterFunc(ctx, func() {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			cancel()
+		}
+	})
+	return uploadCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// uploadTimeout 根据文件大小和最低吞吐量（字节/秒）计算上传超时，
+// 大文件获得成比例的时间，小文件则在下限时间后快速失败
+func uploadTimeout(size, minThroughput int64) time.Duration {
+	timeout := time.Duration(float64(size) / float64(minThroughput) * float64(time.Second))
+	if timeout < minUploadTimeout {
+		return minUploadTimeout
+	}
+	return timeout
+}
+
+// MetadataExists 检查远程是否已有备份元数据，只确认文件存在，不下载也不校验
+func (bm *BackupManager) MetadataExists(ctx context.Context) (bool, error) {
+	exists, err := bm.storage.FileExists(ctx, filepath.Join(bm.metadataDir(), MetadataFileName))
+	if err != nil {
+		return false, fmt.Errorf("failed to check metadata file existence: %w", remoteError(err))
+	}
+	return exists, nil
+}
+
+// metadataDir 返回备份元数据所在的远程目录：配置了MetadataRemote时为该目录，否则与压缩包位于同一目录
+func (bm *BackupManager) metadataDir() string {
+	if bm.config.MetadataRemote != "" {
+		return bm.config.MetadataRemote
+	}
+	return bm.config.RemotePath
+}
+
 // loadRemoteMetadata 从远程加载备份元数据
 func (bm *BackupManager) loadRemoteMetadata(ctx context.Context) (*models.BackupMetadata, error) {
-	remotePath := filepath.Join(bm.config.RemotePath, MetadataFileName)
+	remotePath := filepath.Join(bm.metadataDir(), MetadataFileName)
 
 	// 检查文件是否存在
 	exists, err := bm.storage.FileExists(ctx, remotePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check metadata file existence: %w", err)
+		return nil, fmt.Errorf("failed to check metadata file existence: %w", remoteError(err))
 	}
 
 	if !exists {
-		return nil, fmt.Errorf("no previous backup metadata found, use full backup mode")
+		return nil, ErrNoMetadata
 	}
 
-	// 下载元数据内容
-	content, err := bm.storage.GetFileContent(ctx, remotePath)
+	metadata, err := bm.loadMetadataFile(ctx, remotePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download metadata: %w", err)
+		return bm.loadPrevMetadata(ctx, remotePath, err)
 	}
+	return metadata, nil
+}
 
-	var metadata models.BackupMetadata
-	err = json.Unmarshal(content, &metadata)
+// loadMetadataFile 下载并解析指定路径的元数据文件。
+// 元数据旁存在校验和文件时校验下载的内容，旧版本上传的元数据没有校验和文件，不做校验
+func (bm *BackupManager) loadMetadataFile(ctx context.Context, remotePath string) (*models.BackupMetadata, error) {
+	expected, err := bm.metadataChecksum(ctx, remotePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+		return nil, err
+	}
+
+	var metadata models.BackupMetadata
+	if err := bm.loadJSONFile(ctx, remotePath, expected, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
 	}
 
 	return &metadata, nil
 }
 
+// metadataChecksum 返回元数据文件旁的校验和文件记录的SHA256，没有校验和文件时返回空字符串
+func (bm *BackupManager) metadataChecksum(ctx context.Context, remotePath string) (string, error) {
+	checksumPath := remotePath + MetadataChecksumSuffix
+	exists, err := bm.storage.FileExists(ctx, checksumPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to check metadata checksum existence: %w", remoteError(err))
+	}
+	if !exists {
+		return "", nil
+	}
+	expected, err := bm.getRemoteChecksum(ctx, checksumPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get metadata checksum: %w", remoteError(err))
+	}
+	return expected, nil
+}
+
 // saveAndUploadMetadata 保存并上传备份元数据
 func (bm *BackupManager) saveAndUploadMetadata(ctx context.Context, metadata *models.BackupMetadata) error {
-	// 1. 序列化元数据
-	data, err := json.MarshalIndent(metadata, "", "  ")
+	// 1. 记录生成元数据的运行，序列化元数据；启用SplitFileTree时先单独上传文件树，主元数据只记录文件树对象
+	metadata.RunID = bm.runID
+	metadata.Host = bm.host
+	// 本次运行指定了自定义字段或命名空间时替换原有记录；重新分组等沿用旧元数据的操作未指定时保留
+	if len(bm.config.Annotations) > 0 {
+		metadata.Annotations = bm.config.Annotations
+	}
+	if bm.config.Namespace != "" {
+		metadata.Namespace = bm.config.Namespace
+	}
+	// 汇总校验和文件在元数据之前上传，元数据记录的保存方式总是对应远程已有的文件
+	if err := bm.syncChecksumFiles(ctx, metadata); err != nil {
+		return err
+	}
+	stored := metadata
+	if bm.config.SplitFileTree {
+		split, err := bm.uploadFileTree(ctx, metadata)
+		if err != nil {
+			return err
+		}
+		stored = split
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
 	// 2. 保存到本地临时文件
 	localPath := filepath.Join(bm.config.TempPath, MetadataFileName)
-	err = os.WriteFile(localPath, data, 0644)
+	err = writeLocalFile(localPath, data, bm.config.FsyncMetadata)
 	if err != nil {
 		return fmt.Errorf("failed to save local metadata: %w", err)
 	}
 
-	// 3. 上传到远程
-	remotePath := filepath.Join(bm.config.RemotePath, MetadataFileName)
-	err = bm.storage.UploadFile(ctx, localPath, remotePath)
+	// 3. 上传到远程。先把现有的元数据另存为.prev，再删除旧的校验和文件，上传元数据和新的校验和，
+	// 中途失败时元数据只是缺少校验和（加载时不校验），不会与过期的校验和不一致
+	remotePath := filepath.Join(bm.metadataDir(), MetadataFileName)
+	remoteChecksumPath := remotePath + MetadataChecksumSuffix
+	bm.backupPreviousMetadata(ctx, remotePath)
+	if err := bm.storage.DeleteFile(ctx, remoteChecksumPath); err != nil {
+		return fmt.Errorf("failed to delete old metadata checksum: %w", remoteError(err))
+	}
+	if err := bm.uploadReplacing(ctx, localPath, remotePath); err != nil {
+		return fmt.Errorf("failed to upload metadata: %w", remoteError(err))
+	}
+	if err := bm.uploadMetadataChecksum(ctx, localPath, remoteChecksumPath); err != nil {
+		return err
+	}
+
+	// 4. 主元数据已指向新的文件树，删除不再引用的旧文件树
+	if bm.config.SplitFileTree {
+		bm.cleanupFileTrees(ctx, stored.FileTreeFile)
+	}
+
+	// 5. 启用历史时另存一份快照，供prune按保留策略清理
+	if bm.config.KeepHistory {
+		bm.uploadHistory(ctx, localPath, metadata.BackupTime)
+	}
+
+	// 6. 生成不依赖本工具的恢复清单
+	if bm.config.RestoreManifest {
+		if err := bm.uploadRestoreManifest(ctx, metadata); err != nil {
+			return err
+		}
+	}
+
+	// 7. 保留本地副本（不删除临时文件）
+	return nil
+}
+
+// writeLocalFile 写入本地文件，fsync为true时关闭前调用fsync，保证崩溃后保留的本地副本完整
+func writeLocalFile(path string, data []byte, fsync bool) error {
+	if !fsync {
+		return os.WriteFile(path, data, 0644)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// uploadReplacing 上传文件并替换远程已有的同名文件。后端上传不是原子的且支持移动时先上传到临时名称再移动到目标，
+// 上传中断时目标仍是完整的旧文件，并发的校验或恢复也不会读到写了一半的文件；否则直接上传
+func (bm *BackupManager) uploadReplacing(ctx context.Context, localPath, remotePath string) error {
+	if caps := bm.storage.Capabilities(); caps.AtomicUpload || !caps.Move {
+		return bm.storage.UploadFile(ctx, localPath, remotePath)
+	}
+
+	uploadPath := remotePath + uploadingSuffix
+	if err := bm.storage.UploadFile(ctx, localPath, uploadPath); err != nil {
+		return err
+	}
+	if err := bm.storage.MoveRemote(ctx, uploadPath, remotePath); err != nil {
+		bm.storage.DeleteFile(ctx, uploadPath)
+		return err
+	}
+	return nil
+}
+
+// uploadMetadataChecksum 计算本地元数据文件的校验和并上传，加载元数据时用于校验下载的内容
+func (bm *BackupManager) uploadMetadataChecksum(ctx context.Context, localPath, remotePath string) error {
+	checksum, err := bm.archiver.CalculateChecksum(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate metadata checksum: %w", err)
+	}
+	checksumPath, err := bm.archiver.CreateChecksumFile(localPath, checksum)
 	if err != nil {
-		return fmt.Errorf("failed to upload metadata: %w", err)
+		return fmt.Errorf("failed to create metadata checksum file: %w", err)
 	}
+	defer os.Remove(checksumPath)
 
-	// 4. 保留本地副本（不删除临时文件）
+	if err := bm.storage.UploadFile(ctx, checksumPath, remotePath); err != nil {
+		return fmt.Errorf("failed to upload metadata checksum: %w", remoteError(err))
+	}
 	return nil
 }
 
-// getRemoteChecksum 获取远程校验和文件内容
+// getRemoteChecksum 获取远程校验和文件记录的SHA256
 func (bm *BackupManager) getRemoteChecksum(ctx context.Context, remotePath string) (string, error) {
 	content, err := bm.storage.GetFileContent(ctx, remotePath)
 	if err != nil {
 		return "", err
 	}
-
-	// 解析校验和文件格式：<checksum>  <filename>
-	parts := strings.Fields(string(content))
-	if len(parts) >= 1 {
-		return parts[0], nil
-	}
-
-	return "", fmt.Errorf("invalid checksum file format")
+	return parseChecksum(content, strings.TrimSuffix(filepath.Base(remotePath), ".sha256"))
 }
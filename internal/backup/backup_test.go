@@ -1,14 +1,33 @@
 package backup
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"pbs-backuper/internal/archiver"
 	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
 	"pbs-backuper/internal/storage"
 )
 
@@ -91,6 +110,87 @@ func TestBackupIntegration(t *testing.T) {
 	verifyFinalMetadata(t, remoteDir)
 }
 
+// TestBackupIntegrationRclone 使用指向临时目录的rclone local远程，通过RcloneStorage执行与TestBackupIntegration相同的流程，
+// 覆盖rclone特有的路径和输出处理。没有安装rclone时跳过
+func TestBackupIntegrationRclone(t *testing.T) {
+	if os.Getenv("SKIP_RCLONE_TESTS") == "true" {
+		t.Skip("跳过rclone测试（SKIP_RCLONE_TESTS=true）")
+	}
+	rcloneBinary, err := exec.LookPath("rclone")
+	if err != nil {
+		t.Skipf("rclone命令不可用，跳过测试: %v", err)
+	}
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "local", ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	tempDir := filepath.Join(testDir, "temp")
+	for _, dir := range []string{chunkDir, remoteDir, tempDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("创建目录失败 %s: %v", dir, err)
+		}
+	}
+
+	// 单独的配置文件，不读取也不修改用户的rclone配置
+	rcloneConfig := filepath.Join(testDir, "rclone.conf")
+	if err := os.WriteFile(rcloneConfig, []byte("[testlocal]\ntype = local\n"), 0600); err != nil {
+		t.Fatalf("写入rclone配置失败: %v", err)
+	}
+	rcloneStorage := storage.NewRcloneStorage(rcloneBinary, rcloneConfig, nil, false, false)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "testlocal:" + filepath.ToSlash(remoteDir),
+		TempPath:     tempDir,
+		PrefixDigits: 2,
+		Mode:         "full",
+		Verbose:      true,
+	}
+
+	createInitialChunkData(t, chunkDir)
+	manager := NewBackupManager(config, rcloneStorage)
+	ctx := context.Background()
+
+	result1, err := manager.RunFullBackup(ctx)
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	verifyFullBackupResult(t, result1, remoteDir)
+	verifyRemoteStorage(t, remoteDir, 2)
+
+	modifyChunkData(t, chunkDir)
+	config.Mode = "incremental"
+	result2, err := manager.RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	verifyIncrementalBackupResult(t, result2)
+
+	result3, err := manager.RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("第二次增量备份失败: %v", err)
+	}
+	verifyNoChangeBackupResult(t, result3)
+	verifyFinalMetadata(t, remoteDir)
+
+	// 通过rclone下载压缩包后恢复，确认DownloadFile写入的是指定的本地路径
+	restoreDir := filepath.Join(testDir, "restore")
+	restoreConfig := *config
+	restoreConfig.ChunkPath = restoreDir
+	restoreConfig.Mode = "restore"
+	if _, err := NewBackupManager(&restoreConfig, rcloneStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	restored, err := os.ReadFile(filepath.Join(restoreDir, "0000", "file0.dat"))
+	if err != nil {
+		t.Fatalf("读取恢复的文件失败: %v", err)
+	}
+	original, _ := os.ReadFile(filepath.Join(chunkDir, "0000", "file0.dat"))
+	if !bytes.Equal(restored, original) {
+		t.Errorf("恢复的文件内容不一致: %q != %q", restored, original)
+	}
+}
+
 // createInitialChunkData 创建初始chunk数据
 func createInitialChunkData(t *testing.T, chunkDir string) {
 	// 创建chunk目录：0000, 0001, 00ff, 0100
@@ -364,3 +464,5016 @@ func TestPrefixGrouping(t *testing.T) {
 		})
 	}
 }
+
+// TestRestore 测试全量恢复以及通过tar索引恢复单个文件
+func TestRestore(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "local", ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	tempDir := filepath.Join(testDir, "temp")
+
+	createInitialChunkData(t, chunkDir)
+	mockStorage := storage.NewMockStorage(remoteDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     tempDir,
+		PrefixDigits: 2,
+		Mode:         "full",
+		TarIndex:     true,
+	}
+
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(remoteDir, IndexDirName, "0000-00ff.tar.gz.index.json")); err != nil {
+		t.Fatalf("远程存储应该包含tar索引: %v", err)
+	}
+
+	// 全量恢复到新目录，恢复后的文件树应与原始文件树一致
+	restoreDir := filepath.Join(testDir, "restore-all")
+	restoreConfig := *config
+	restoreConfig.ChunkPath = restoreDir
+	restoreConfig.Mode = "restore"
+
+	result, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "")
+	if err != nil {
+		t.Fatalf("全量恢复失败: %v", err)
+	}
+	if result.RestoredArchives != 2 {
+		t.Errorf("应该恢复2个压缩包，实际 %d 个", result.RestoredArchives)
+	}
+
+	originalTree, err := NewBackupManager(config, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := NewBackupManager(&restoreConfig, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	if changed := scanner.CompareFileTrees(originalTree, restoredTree); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+
+	// 通过索引恢复单个文件
+	singleDir := filepath.Join(testDir, "restore-single")
+	singleConfig := restoreConfig
+	singleConfig.ChunkPath = singleDir
+
+	result, err = NewBackupManager(&singleConfig, mockStorage).RunRestore(ctx, "0100/subdir/subfile.dat")
+	if err != nil {
+		t.Fatalf("单文件恢复失败: %v", err)
+	}
+	if result.RestoredEntries != 1 {
+		t.Errorf("应该恢复1个条目，实际 %d 个", result.RestoredEntries)
+	}
+
+	data, err := os.ReadFile(filepath.Join(singleDir, "0100", "subdir", "subfile.dat"))
+	if err != nil {
+		t.Fatalf("读取恢复文件失败: %v", err)
+	}
+	if string(data) != "chunk 0100 sub content" {
+		t.Errorf("恢复文件内容不匹配: %q", string(data))
+	}
+
+	if _, err := NewBackupManager(&singleConfig, mockStorage).RunRestore(ctx, "0100/missing.dat"); err == nil {
+		t.Error("恢复不存在的文件应该返回错误")
+	}
+}
+
+// downloadRecorder 记录下载的远程路径
+type downloadRecorder struct {
+	storage.Storage
+	downloads []string
+}
+
+func (s *downloadRecorder) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	s.downloads = append(s.downloads, filepath.ToSlash(remotePath))
+	return s.Storage.DownloadFile(ctx, remotePath, localPath)
+}
+
+// rangeStorage 在downloadRecorder的基础上支持按范围读取
+type rangeStorage struct {
+	*downloadRecorder
+	reader storage.RangeReader
+}
+
+func (s *rangeStorage) ReadRange(ctx context.Context, remotePath string, offset, length int64) ([]byte, error) {
+	return s.reader.ReadRange(ctx, remotePath, offset, length)
+}
+
+// TestRestoreIndexedRange 测试按tar索引恢复单个文件时只读取条目所在的gzip成员，不下载整个压缩包；
+// 后端不支持按范围读取时下载整个压缩包；成员内容损坏时报错
+func TestRestoreIndexedRange(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+	mockStorage := storage.NewMockStorage(remoteDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		TarIndex:     true,
+	}
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	restore := func(store storage.Storage, name string) error {
+		restoreConfig := *config
+		restoreConfig.ChunkPath = filepath.Join(testDir, name)
+		restoreConfig.Mode = "restore"
+		if _, err := NewBackupManager(&restoreConfig, store).RunRestore(ctx, "0100/subdir/subfile.dat"); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(restoreConfig.ChunkPath, "0100", "subdir", "subfile.dat"))
+		if err != nil || string(data) != "chunk 0100 sub content" {
+			t.Errorf("恢复文件内容不匹配: %q, %v", data, err)
+		}
+		return nil
+	}
+	downloadedArchive := func(downloads []string) bool {
+		for _, path := range downloads {
+			if strings.HasPrefix(path, "/"+ChunkDirName+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ranged := &rangeStorage{downloadRecorder: &downloadRecorder{Storage: mockStorage}, reader: mockStorage}
+	if err := restore(ranged, "ranged"); err != nil {
+		t.Fatalf("按范围恢复失败: %v", err)
+	}
+	if downloadedArchive(ranged.downloads) {
+		t.Errorf("支持按范围读取时不应下载整个压缩包: %v", ranged.downloads)
+	}
+
+	full := &downloadRecorder{Storage: mockStorage}
+	if err := restore(full, "full"); err != nil {
+		t.Fatalf("下载整个压缩包恢复失败: %v", err)
+	}
+	if !downloadedArchive(full.downloads) {
+		t.Errorf("不支持按范围读取时应下载整个压缩包: %v", full.downloads)
+	}
+
+	// 损坏条目所在成员的最后一个字节（gzip的ISIZE），解压时校验失败
+	index, err := archiver.LoadIndex(filepath.Join(remoteDir, IndexDirName, "0100-01ff.tar.gz.index.json"))
+	if err != nil {
+		t.Fatalf("读取tar索引失败: %v", err)
+	}
+	entry, found := archiver.FindEntry(index, "0100/subdir/subfile.dat")
+	if !found {
+		t.Fatal("tar索引中缺少条目")
+	}
+	archivePath := filepath.Join(remoteDir, ChunkDirName, "0100-01ff.tar.gz")
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("读取压缩包失败: %v", err)
+	}
+	data[entry.Offset+entry.Length-1] ^= 0xff
+	if err := os.WriteFile(archivePath, data, 0644); err != nil {
+		t.Fatalf("写入压缩包失败: %v", err)
+	}
+	if err := restore(ranged, "corrupt"); err == nil {
+		t.Error("条目所在的gzip成员损坏时应该返回错误")
+	}
+
+	// 成员开头的gzip魔数损坏时不能当作不压缩的tar读取
+	data[entry.Offset+entry.Length-1] ^= 0xff
+	data[entry.Offset] ^= 0xff
+	if err := os.WriteFile(archivePath, data, 0644); err != nil {
+		t.Fatalf("写入压缩包失败: %v", err)
+	}
+	if err := restore(ranged, "corrupt-header"); err == nil {
+		t.Error("gzip成员头部损坏时应该返回错误")
+	}
+
+	// 不压缩的tar包没有CRC，即使支持按范围读取也下载整个压缩包校验SHA256
+	config.Compression = archiver.CompressionNone
+	plainStorage := storage.NewMockStorage(filepath.Join(testDir, "plain-remote"))
+	if _, err := NewBackupManager(config, plainStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	plain := &rangeStorage{downloadRecorder: &downloadRecorder{Storage: plainStorage}, reader: plainStorage}
+	if err := restore(plain, "plain"); err != nil {
+		t.Fatalf("恢复不压缩的压缩包失败: %v", err)
+	}
+	if !downloadedArchive(plain.downloads) {
+		t.Errorf("不压缩的压缩包应下载整个压缩包校验: %v", plain.downloads)
+	}
+}
+
+// TestRestoreDeletedGroup 测试分组的目录全部删除后，增量备份从元数据中移除该压缩包，恢复不会带回已删除的目录
+func TestRestoreDeletedGroup(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+	mockStorage := storage.NewMockStorage(remoteDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	fullMetadata, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+
+	// 删除0100-01ff分组中唯一的目录
+	if err := os.RemoveAll(filepath.Join(chunkDir, "0100")); err != nil {
+		t.Fatalf("删除目录失败: %v", err)
+	}
+	incrementalConfig := *config
+	incrementalConfig.Mode = "incremental"
+	if _, err := NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	metadata, err := NewBackupManager(&incrementalConfig, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("加载元数据失败: %v", err)
+	}
+	if _, exists := metadata.Checksums["0100-01ff.tar.gz"]; exists {
+		t.Error("目录已全部删除的分组不应保留在元数据中")
+	}
+
+	restore := func(name string) *models.RestoreResult {
+		restoreConfig := *config
+		restoreConfig.ChunkPath = filepath.Join(testDir, name)
+		restoreConfig.Mode = "restore"
+		result, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "")
+		if err != nil {
+			t.Fatalf("全量恢复失败: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, "0100")); !os.IsNotExist(err) {
+			t.Errorf("恢复不应带回已删除的目录0100: %v", err)
+		}
+		return result
+	}
+	if result := restore("restore"); result.RestoredArchives != 1 {
+		t.Errorf("应该恢复1个压缩包，实际 %d 个", result.RestoredArchives)
+	}
+
+	// 旧版本的增量备份保留了已删除分组的校验和，恢复时按文件树跳过
+	var stale models.BackupMetadata
+	if err := json.Unmarshal(fullMetadata, &stale); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	metadata.Checksums["0100-01ff.tar.gz"] = stale.Checksums["0100-01ff.tar.gz"]
+	if err := NewBackupManager(&incrementalConfig, mockStorage).saveAndUploadMetadata(ctx, metadata); err != nil {
+		t.Fatalf("上传元数据失败: %v", err)
+	}
+	if result := restore("restore-stale"); result.RestoredArchives != 1 {
+		t.Errorf("应该跳过已删除的分组，实际恢复 %d 个压缩包", result.RestoredArchives)
+	}
+}
+
+// TestUploadTimeout 测试按大小和最低吞吐量计算上传超时
+func TestUploadTimeout(t *testing.T) {
+	testCases := []struct {
+		size          int64
+		minThroughput int64
+		expected      time.Duration
+	}{
+		{size: 0, minThroughput: 1 << 20, expected: minUploadTimeout},
+		{size: 10 << 20, minThroughput: 1 << 20, expected: minUploadTimeout},
+		{size: 600 << 20, minThroughput: 1 << 20, expected: 600 * time.Second},
+		{size: 10 << 30, minThroughput: 10 << 20, expected: 1024 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		if got := uploadTimeout(tc.size, tc.minThroughput); got != tc.expected {
+			t.Errorf("uploadTimeout(%d, %d) = %v, 期望 %v", tc.size, tc.minThroughput, got, tc.expected)
+		}
+	}
+}
+
+// TestUploadContext 测试配置最低吞吐量时上传不受父上下文的截止时间约束，但随父上下文取消
+func TestUploadContext(t *testing.T) {
+	bm := NewBackupManager(&models.Config{MinThroughput: 1 << 20}, storage.NewMockStorage(t.TempDir()))
+
+	// 模拟收到信号时取消的上下文和在其上设置的全局超时
+	signalCtx, sendSignal := context.WithCancel(context.Background())
+	defer sendSignal()
+	expired, cancelExpired := context.WithTimeout(signalCtx, time.Millisecond)
+	defer cancelExpired()
+	<-expired.Done()
+	ctx, cancel := bm.uploadContext(WithUploadParent(expired, signalCtx), 600<<20)
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatalf("全局超时不应中断上传: %v", ctx.Err())
+	}
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < 500*time.Second {
+		t.Errorf("上传截止时间应按大小计算: %v", deadline)
+	}
+	// 全局超时之后收到信号仍然取消上传
+	sendSignal()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("全局超时之后收到信号时上传应随之取消")
+	}
+
+	// 备份内部取消ctx（不是超时）时上传同样取消
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel = bm.uploadContext(WithUploadParent(parent, context.Background()), 1)
+	defer cancel()
+	cancelParent()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("父上下文取消时上传应随之取消")
+	}
+
+	// 没有记录上传父上下文时仍受ctx的截止时间约束
+	ctx, cancel = bm.uploadContext(expired, 600<<20)
+	defer cancel()
+	if ctx.Err() == nil {
+		t.Error("没有记录上传父上下文时不应脱离ctx的截止时间")
+	}
+
+	// 未配置最低吞吐量时直接使用父上下文
+	plain := NewBackupManager(&models.Config{}, storage.NewMockStorage(t.TempDir()))
+	if ctx, cancel := plain.uploadContext(expired, 1); ctx != expired {
+		t.Error("未配置最低吞吐量时应使用父上下文")
+	} else {
+		cancel()
+	}
+}
+
+// TestMaxDirSizeExclusion 测试超过大小限制的目录不会被打包并在结果中列出
+func TestMaxDirSizeExclusion(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+
+	createInitialChunkData(t, chunkDir)
+	// 让0001成为超大目录
+	if err := os.WriteFile(filepath.Join(chunkDir, "0001", "huge.dat"), make([]byte, 4096), 0644); err != nil {
+		t.Fatalf("创建大文件失败: %v", err)
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		MaxDirSize:   1024,
+	}
+
+	mockStorage := storage.NewMockStorage(remoteDir)
+	manager := NewBackupManager(config, mockStorage)
+	result, err := manager.RunFullBackup(context.Background())
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	if len(result.ExcludedDirs) != 1 || result.ExcludedDirs[0] != "0001" {
+		t.Errorf("结果应该列出被排除的目录0001，实际 %v", result.ExcludedDirs)
+	}
+
+	// 被排除的目录不应该出现在压缩包中
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(context.Background(), ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, "0001")); !os.IsNotExist(err) {
+		t.Error("被排除的目录0001不应该出现在备份中")
+	}
+	if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, "0000")); err != nil {
+		t.Errorf("目录0000应该被备份: %v", err)
+	}
+}
+
+// TestIncrementalKeepsFilteredDirectories 测试增量备份中被--include-prefix或--max-dir-size排除的目录
+// 沿用上次的记录，重新生成的压缩包仍包含这些目录，元数据也不会丢掉它们所在的压缩包
+func TestIncrementalKeepsFilteredDirectories(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		for _, filter := range []string{"include-prefix", "max-dir-size"} {
+			testDir := t.TempDir()
+			chunkDir := filepath.Join(testDir, ".chunk")
+			remoteDir := filepath.Join(testDir, "remote")
+			createInitialChunkData(t, chunkDir)
+
+			config := &models.Config{
+				ChunkPath:     chunkDir,
+				RemotePath:    "/",
+				TempPath:      filepath.Join(testDir, "temp"),
+				PrefixDigits:  2,
+				Mode:          "full",
+				SplitFileTree: split,
+			}
+			mockStorage := storage.NewMockStorage(remoteDir)
+			ctx := context.Background()
+			if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+				t.Fatalf("全量备份失败: %v", err)
+			}
+
+			// 0001发生变化，0000超过大小限制
+			if err := os.WriteFile(filepath.Join(chunkDir, "0001", "new.dat"), []byte("new"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(chunkDir, "0000", "huge.dat"), make([]byte, 4096), 0644); err != nil {
+				t.Fatal(err)
+			}
+			incrConfig := *config
+			incrConfig.Mode = "incremental"
+			if filter == "include-prefix" {
+				incrConfig.IncludePrefixes = []string{"0001"}
+			} else {
+				incrConfig.MaxDirSize = 1024
+			}
+			if _, err := NewBackupManager(&incrConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+				t.Fatalf("拆分保存=%v %s: 增量备份失败: %v", split, filter, err)
+			}
+
+			restoreConfig := *config
+			restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+			if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+				t.Fatalf("恢复失败: %v", err)
+			}
+			for _, path := range []string{"0000/file0.dat", "0001/new.dat", "00ff/file0.dat", "0100/file0.dat"} {
+				if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, path)); err != nil {
+					t.Errorf("拆分保存=%v %s: 恢复结果缺少 %s: %v", split, filter, path, err)
+				}
+			}
+		}
+	}
+}
+
+// cancellingStorage 在第一次上传压缩包时取消上下文，模拟处理压缩包组途中被中断
+type cancellingStorage struct {
+	*storage.MockStorage
+	cancel          context.CancelFunc
+	cancelled       bool
+	removedOnCancel bool
+}
+
+func (s *cancellingStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	if !s.cancelled && strings.HasSuffix(localPath, ".tar.gz") {
+		s.cancelled = true
+		s.cancel()
+
+		// 取消后临时文件应立即被清理，而不是等到压缩包组处理结束
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(localPath); os.IsNotExist(err) {
+				s.removedOnCancel = true
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return ctx.Err()
+	}
+	return s.MockStorage.UploadFile(ctx, localPath, remotePath)
+}
+
+func TestTempFileCleanupOnCancel(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	tempDir := filepath.Join(testDir, "temp")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     tempDir,
+		PrefixDigits: 2,
+		Mode:         "full",
+		TarIndex:     true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancellingStore := &cancellingStorage{
+		MockStorage: storage.NewMockStorage(filepath.Join(testDir, "remote")),
+		cancel:      cancel,
+	}
+
+	manager := NewBackupManager(config, cancellingStore)
+	if _, err := manager.RunFullBackup(ctx); err == nil {
+		t.Fatal("取消后全量备份应该返回错误")
+	}
+
+	if !cancellingStore.removedOnCancel {
+		t.Error("上下文取消后压缩包临时文件应该被立即删除")
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("读取临时目录失败: %v", err)
+	}
+	for _, entry := range entries {
+		t.Errorf("临时文件未被清理: %s", entry.Name())
+	}
+}
+
+func TestIncrementalAutoFull(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "incremental",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+
+	// 未启用AutoFull时缺少元数据应该报错
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background()); !errors.Is(err, ErrNoMetadata) {
+		t.Fatalf("缺少元数据时应返回ErrNoMetadata，实际 %v", err)
+	}
+
+	// 启用AutoFull后自动执行全量备份
+	config.AutoFull = true
+	result, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("自动全量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 2 {
+		t.Errorf("预期上传2个压缩包，实际 %d", result.UpdatedArchives)
+	}
+	data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("自动全量备份后应该存在元数据: %v", err)
+	}
+
+	// 元数据记录生成它的运行标识，与备份结果一致
+	var metadata models.BackupMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	if result.RunID == "" || metadata.RunID != result.RunID {
+		t.Errorf("元数据运行标识 %q 与备份结果 %q 不一致", metadata.RunID, result.RunID)
+	}
+	if id := NewRunID(); len(id) != 36 || id == NewRunID() {
+		t.Errorf("运行标识格式不正确或重复: %s", id)
+	}
+}
+
+func TestBaselineDivergence(t *testing.T) {
+	manager := NewBackupManager(&models.Config{TempPath: t.TempDir()}, nil)
+
+	metadata := &models.BackupMetadata{
+		PrefixDigits: 2,
+		FileTree: map[string]*models.FileTreeNode{
+			"0000": {Name: "0000", IsDir: true},
+			"0001": {Name: "0001", IsDir: true},
+			"0100": {Name: "0100", IsDir: true},
+		},
+		Checksums: map[string]string{
+			"0000-00ff.tar.gz": "aaa",
+			"0100-01ff.tar.gz": "bbb",
+		},
+	}
+
+	missing, extra, err := manager.baselineDivergence(metadata, scanner.TreeSizes(metadata.FileTree))
+	if err != nil {
+		t.Fatalf("校验基线失败: %v", err)
+	}
+	if len(missing) != 0 || len(extra) != 0 {
+		t.Errorf("完整的元数据不应有差异，missing=%v extra=%v", missing, extra)
+	}
+
+	// 上次备份部分失败：缺少一个压缩包的校验和，并残留一个已不存在分组的校验和
+	delete(metadata.Checksums, "0100-01ff.tar.gz")
+	metadata.Checksums["ff00-ffff.tar.gz"] = "ccc"
+
+	missing, extra, err = manager.baselineDivergence(metadata, scanner.TreeSizes(metadata.FileTree))
+	if err != nil {
+		t.Fatalf("校验基线失败: %v", err)
+	}
+	if len(missing) != 1 || missing[0] != "0100-01ff.tar.gz" {
+		t.Errorf("预期缺少0100-01ff.tar.gz，实际 %v", missing)
+	}
+	if len(extra) != 1 || extra[0] != "ff00-ffff.tar.gz" {
+		t.Errorf("预期多余ff00-ffff.tar.gz，实际 %v", extra)
+	}
+}
+
+// TestRepairSplitFileTree 测试文件树拆分保存、流式比较时仍按上次的目录校验基线，--repair重新生成缺少校验和的压缩包
+func TestRepairSplitFileTree(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "full",
+		SplitFileTree: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 模拟上次备份有压缩包失败：元数据缺少一个压缩包的校验和
+	metadataPath := filepath.Join(remoteDir, MetadataFileName)
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metadata models.BackupMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	delete(metadata.Checksums, "0100-01ff.tar.gz")
+	if data, err = json.Marshal(&metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(metadataPath + MetadataChecksumSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Mode = "incremental"
+	config.Repair = true
+	manager := NewBackupManager(config, mockStorage)
+	changes, err := manager.ListChanged(ctx)
+	if err != nil {
+		t.Fatalf("列出变化失败: %v", err)
+	}
+	if !slices.Equal(changes.Archives, []string{"0100-01ff.tar.gz"}) {
+		t.Errorf("修复模式应列出缺少校验和的压缩包，实际 %v", changes.Archives)
+	}
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	repaired, err := NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repaired.Checksums["0100-01ff.tar.gz"]; !ok {
+		t.Error("修复后元数据应包含0100-01ff.tar.gz的校验和")
+	}
+}
+
+// TestVerifyInjectedFailure 使用内存存储注入下载错误，失败的压缩包记录错误，其余压缩包正常校验
+func TestVerifyInjectedFailure(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 4,
+		Mode:         "full",
+	}
+	store := storage.NewMemStorage()
+	manager := NewBackupManager(config, store)
+	if _, err := manager.RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	failing := filepath.Join("/", ChunkDirName, "0001-0001.tar.gz")
+	store.SetLatency(10 * time.Millisecond)
+	store.SetFailure(func(op, remotePath string) error {
+		if op == "DownloadFile" && remotePath == failing {
+			return errors.New("connection reset")
+		}
+		return nil
+	})
+
+	for _, adaptive := range []bool{false, true} {
+		var result *models.VerifyResult
+		var err error
+		if adaptive {
+			result, err = manager.RunVerifyAdaptive(context.Background(), 2, 4, MismatchReport)
+		} else {
+			result, err = manager.RunVerify(context.Background(), 2, MismatchReport)
+		}
+		if err != nil {
+			t.Fatalf("校验失败: %v", err)
+		}
+		if result.VerifiedArchives != 4 || len(result.Mismatches) != 1 {
+			t.Fatalf("预期4个压缩包中1个失败，实际 %d 个，失败 %v", result.VerifiedArchives, result.Mismatches)
+		}
+		if m := result.Mismatches[0]; m.Archive != "0001-0001.tar.gz" || !strings.Contains(m.Error, "connection reset") {
+			t.Errorf("失败项不正确: %+v", m)
+		}
+	}
+	if store.MaxConcurrent() > 4 {
+		t.Errorf("同时进行的操作数不应超过并发上限4，实际 %d", store.MaxConcurrent())
+	}
+}
+
+// TestProviderHashVerify 测试比较服务端哈希的校验：支持的后端不下载压缩包，不支持的后端退回下载校验
+func TestProviderHashVerify(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 4,
+		Mode:         "full",
+		ProviderHash: true,
+	}
+	store := storage.NewMemStorage()
+	manager := NewBackupManager(config, store)
+	if _, err := manager.RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 下载压缩包会失败，服务端哈希校验不应下载
+	store.SetFailure(func(op, remotePath string) error {
+		if op == "DownloadFile" && strings.HasPrefix(remotePath, "/"+ChunkDirName+"/") {
+			return errors.New("archive downloaded")
+		}
+		return nil
+	})
+	result, err := manager.RunVerify(context.Background(), 2, MismatchReport)
+	if err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if result.VerifiedArchives != 4 || result.HashVerified != 4 || len(result.Mismatches) != 0 {
+		t.Errorf("预期4个压缩包全部通过服务端哈希校验，实际 %+v", result)
+	}
+
+	store.Put(filepath.Join("/", ChunkDirName, "00ff-00ff.tar.gz"), []byte("corrupted"))
+	result, err = manager.RunVerify(context.Background(), 2, MismatchReport)
+	if err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if len(result.Mismatches) != 1 || result.Mismatches[0].Archive != "00ff-00ff.tar.gz" || !strings.HasPrefix(result.Mismatches[0].Expected, storage.HashCRC32C+":") {
+		t.Errorf("应发现00ff-00ff.tar.gz的CRC32C不匹配，实际 %+v", result.Mismatches)
+	}
+
+	// MockStorage不能读取服务端哈希，退回下载校验SHA256
+	remoteDir := filepath.Join(testDir, "remote")
+	mockConfig := *config
+	mockConfig.TempPath = filepath.Join(testDir, "temp-mock")
+	mockManager := NewBackupManager(&mockConfig, storage.NewMockStorage(remoteDir))
+	if _, err := mockManager.RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	result, err = mockManager.RunVerify(context.Background(), 2, MismatchReport)
+	if err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if result.VerifiedArchives != 4 || result.HashVerified != 0 || len(result.Mismatches) != 0 {
+		t.Errorf("不支持服务端哈希时应下载校验全部压缩包，实际 %+v", result)
+	}
+}
+
+// concurrencyStorage 记录同时进行的下载数
+type concurrencyStorage struct {
+	*storage.MockStorage
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (s *concurrencyStorage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.maxSeen {
+		s.maxSeen = s.active
+	}
+	s.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+	err := s.MockStorage.DownloadFile(ctx, remotePath, localPath)
+
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	return err
+}
+
+func TestVerify(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 4,
+		Mode:         "full",
+	}
+	store := &concurrencyStorage{MockStorage: storage.NewMockStorage(remoteDir)}
+	manager := NewBackupManager(config, store)
+	if _, err := manager.RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	result, err := manager.RunVerify(context.Background(), 2, MismatchReport)
+	if err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if result.VerifiedArchives != 4 || len(result.Mismatches) != 0 {
+		t.Errorf("预期4个压缩包全部通过，实际 %d 个，失败 %v", result.VerifiedArchives, result.Mismatches)
+	}
+	if store.maxSeen > 2 {
+		t.Errorf("同时下载数不应超过2，实际 %d", store.maxSeen)
+	}
+
+	result, err = manager.RunVerifyAdaptive(context.Background(), 1, 3, MismatchReport)
+	if err != nil {
+		t.Fatalf("自适应并发校验失败: %v", err)
+	}
+	if result.VerifiedArchives != 4 || len(result.Mismatches) != 0 {
+		t.Errorf("自适应并发时预期4个压缩包全部通过，实际 %d 个，失败 %v", result.VerifiedArchives, result.Mismatches)
+	}
+	if store.maxSeen > 3 {
+		t.Errorf("同时下载数不应超过上限3，实际 %d", store.maxSeen)
+	}
+
+	// 损坏一个压缩包并删除另一个
+	if err := os.WriteFile(filepath.Join(remoteDir, ChunkDirName, "00ff-00ff.tar.gz"), []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("损坏压缩包失败: %v", err)
+	}
+	if err := os.Remove(filepath.Join(remoteDir, ChunkDirName, "0000-0000.tar.gz")); err != nil {
+		t.Fatalf("删除压缩包失败: %v", err)
+	}
+
+	result, err = manager.RunVerify(context.Background(), 4, MismatchReport)
+	if err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if len(result.Mismatches) != 2 {
+		t.Fatalf("预期2个压缩包校验失败，实际 %v", result.Mismatches)
+	}
+	if result.Mismatches[0].Archive != "0000-0000.tar.gz" || result.Mismatches[0].Error == "" {
+		t.Errorf("第一个失败项应为缺失的0000-0000.tar.gz，实际 %+v", result.Mismatches[0])
+	}
+	if result.Mismatches[1].Archive != "00ff-00ff.tar.gz" || result.Mismatches[1].Actual == "" {
+		t.Errorf("第二个失败项应为损坏的00ff-00ff.tar.gz，实际 %+v", result.Mismatches[1])
+	}
+
+	// fail策略在第一个失败处停止并返回错误
+	result, err = manager.RunVerify(context.Background(), 1, MismatchFail)
+	if !errors.Is(err, ErrChecksumMismatch) || result == nil || len(result.Mismatches) != 1 {
+		t.Fatalf("fail策略应在第一个失败处停止，实际 %v, %+v", err, result)
+	}
+	if result.Mismatches[0].Archive != "0000-0000.tar.gz" || result.VerifiedArchives != 1 {
+		t.Errorf("fail策略应只校验到0000-0000.tar.gz，实际校验 %d 个，失败 %+v", result.VerifiedArchives, result.Mismatches)
+	}
+
+	// repair策略用当前chunk目录重新生成失败的压缩包
+	result, err = manager.RunVerify(context.Background(), 4, MismatchRepair)
+	if err != nil {
+		t.Fatalf("修复失败: %v", err)
+	}
+	if len(result.Mismatches) != 2 || !result.Mismatches[0].Repaired || !result.Mismatches[1].Repaired {
+		t.Fatalf("两个失败项都应修复，实际 %+v", result.Mismatches)
+	}
+	result, err = manager.RunVerify(context.Background(), 4, MismatchReport)
+	if err != nil || len(result.Mismatches) != 0 {
+		t.Errorf("修复后应全部校验通过，实际 %v, %+v", err, result.Mismatches)
+	}
+
+	if _, err := ParseMismatchPolicy("ignore"); err == nil {
+		t.Error("无效的处理方式应返回错误")
+	}
+}
+
+func TestIncrementalNewerThan(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	// 把所有文件和目录的修改时间设置为两天前
+	oldTime := time.Now().Add(-48 * time.Hour)
+	err := filepath.Walk(chunkDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, oldTime, oldTime)
+	})
+	if err != nil {
+		t.Fatalf("设置修改时间失败: %v", err)
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 只修改0100
+	if err := os.WriteFile(filepath.Join(chunkDir, "0100", "file0.dat"), []byte("changed today"), 0644); err != nil {
+		t.Fatalf("修改文件失败: %v", err)
+	}
+
+	config.Mode = "incremental"
+	config.NewerThan = time.Now().Add(-time.Hour)
+	result, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+
+	if result.UpdatedArchives != 1 || result.SkippedArchives != 1 {
+		t.Errorf("预期更新1个、跳过1个压缩包，实际更新%d个、跳过%d个", result.UpdatedArchives, result.SkippedArchives)
+	}
+
+	// 被跳过的目录不应从元数据中删除
+	content, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	var metadata models.BackupMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	for _, dir := range []string{"0000", "0001", "00ff", "0100"} {
+		if _, exists := metadata.FileTree[dir]; !exists {
+			t.Errorf("元数据中应保留目录 %s", dir)
+		}
+	}
+}
+
+// TestStagedFullBackup 测试分阶段全量备份：只打包最近修改的目录，其余目录记录为待备份，由之后的增量备份补齐
+func TestStagedFullBackup(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	// 除0100外所有目录的修改时间设置为两天前
+	oldTime := time.Now().Add(-48 * time.Hour)
+	err := filepath.Walk(chunkDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || strings.HasPrefix(path, filepath.Join(chunkDir, "0100")) {
+			return err
+		}
+		return os.Chtimes(path, oldTime, oldTime)
+	})
+	if err != nil {
+		t.Fatalf("设置修改时间失败: %v", err)
+	}
+
+	readMetadata := func() *models.BackupMetadata {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+		if err != nil {
+			t.Fatalf("读取元数据失败: %v", err)
+		}
+		var metadata models.BackupMetadata
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			t.Fatalf("解析元数据失败: %v", err)
+		}
+		return &metadata
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		StagedCutoff: time.Now().Add(-time.Hour),
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	result, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background())
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	metadata := readMetadata()
+	if strings.Join(metadata.PendingDirs, ",") != "0000,0001,00ff" || result.PendingDirs != 3 {
+		t.Errorf("待备份目录不正确: %v（结果中%d个）", metadata.PendingDirs, result.PendingDirs)
+	}
+	if _, exists := metadata.FileTree["0100"]; !exists || len(metadata.FileTree) != 1 {
+		t.Errorf("文件树应只包含最近修改的目录: %v", metadata.FileTree)
+	}
+
+	// 增量备份的截止时间仍早于待备份目录的修改时间，待备份目录保持不变
+	config.Mode = "incremental"
+	config.StagedCutoff = time.Time{}
+	config.NewerThan = time.Now().Add(-24 * time.Hour)
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background()); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if metadata := readMetadata(); len(metadata.PendingDirs) != 3 {
+		t.Errorf("待备份目录不应变化: %v", metadata.PendingDirs)
+	}
+
+	// 不限制修改时间的增量备份补齐所有待备份目录
+	config.NewerThan = time.Time{}
+	result, err = NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	metadata = readMetadata()
+	if len(metadata.PendingDirs) != 0 || result.PendingDirs != 0 {
+		t.Errorf("待备份目录应已全部完成: %v", metadata.PendingDirs)
+	}
+	for _, dir := range []string{"0000", "0001", "00ff", "0100"} {
+		if _, exists := metadata.FileTree[dir]; !exists {
+			t.Errorf("元数据中缺少目录 %s", dir)
+		}
+	}
+	if _, exists := metadata.Checksums["0000-00ff.tar.gz"]; !exists {
+		t.Errorf("待备份目录所在的压缩包应已上传: %v", metadata.Checksums)
+	}
+}
+
+// TestTreeHashXXH3 测试用XXH3做hash比较：算法记录在元数据中，算法改变时退回到比较大小和修改时间
+func TestTreeHashXXH3(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		CompareMode:  string(scanner.CompareHash),
+		TreeHash:     scanner.HashXXH3,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	var metadata models.BackupMetadata
+	data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	if metadata.TreeHash != scanner.HashXXH3 {
+		t.Errorf("元数据应记录哈希算法xxh3，实际 %q", metadata.TreeHash)
+	}
+	if hash := metadata.FileTree["0100"].Children["file0.dat"].Hash; len(hash) != 16 {
+		t.Errorf("XXH3哈希应为16位十六进制，实际 %q", hash)
+	}
+
+	// 内容改变但大小和修改时间不变，XXH3能发现
+	path := filepath.Join(chunkDir, "0100", "file0.dat")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[0] ^= 0xff
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Mode = "incremental"
+	result, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 1 || result.Details["0100-01ff.tar.gz"] == "unchanged, skipped" {
+		t.Errorf("内容变化的压缩包应重新上传: %+v", result.Details)
+	}
+
+	// 换回SHA256时两种哈希无法比较，按大小和修改时间比较，不应把所有文件都当作变化
+	config.TreeHash = scanner.HashSHA256
+	result, err = NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 0 {
+		t.Errorf("哈希算法改变时不应重新上传未变化的压缩包: %+v", result.Details)
+	}
+}
+
+// TestTrackInodes 测试按inode号识别移动的文件：只移动文件时不重新打包，恢复时移动到当前路径；
+// 原路径所在的分组重新打包后，当前路径所在的分组一并更新，移动记录随之清除
+func TestTrackInodes(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		TrackInodes:  true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	readMetadata := func() models.BackupMetadata {
+		var metadata models.BackupMetadata
+		data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+		if err != nil {
+			t.Fatalf("读取元数据失败: %v", err)
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("解析元数据失败: %v", err)
+		}
+		return metadata
+	}
+	if readMetadata().FileTree["0000"].Children["file1.dat"].Inode == 0 {
+		t.Skip("当前平台不提供inode号")
+	}
+
+	// 把文件移动到另一个分组的已有目录中，修改时间不变
+	from := filepath.Join(chunkDir, "0000", "file1.dat")
+	to := filepath.Join(chunkDir, "0100", "subdir", "moved.dat")
+	content, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		t.Fatalf("移动文件失败: %v", err)
+	}
+
+	config.Mode = "incremental"
+	result, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 0 || result.MovedFiles != 1 {
+		t.Errorf("只移动文件时不应重新打包: 更新 %d, 移动 %d, %+v", result.UpdatedArchives, result.MovedFiles, result.Details)
+	}
+	expected := models.MovedFile{From: "0000/file1.dat", To: "0100/subdir/moved.dat"}
+	if moves := readMetadata().Moves; len(moves) != 1 || moves[0] != expected {
+		t.Fatalf("元数据应记录移动 %v，实际 %v", expected, moves)
+	}
+
+	// 全量恢复和单个文件恢复都应把文件放到当前路径
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	restoreResult, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "")
+	if err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if restoreResult.MovedFiles != 1 {
+		t.Errorf("应移动1个文件，实际 %d", restoreResult.MovedFiles)
+	}
+	if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, "0000", "file1.dat")); !os.IsNotExist(err) {
+		t.Errorf("原路径的文件不应保留: %v", err)
+	}
+	singleConfig := *config
+	singleConfig.ChunkPath = filepath.Join(testDir, "restore-single")
+	if _, err := NewBackupManager(&singleConfig, mockStorage).RunRestore(ctx, "0100/subdir/moved.dat"); err != nil {
+		t.Fatalf("恢复移动的文件失败: %v", err)
+	}
+	for _, dir := range []string{restoreConfig.ChunkPath, singleConfig.ChunkPath} {
+		data, err := os.ReadFile(filepath.Join(dir, "0100", "subdir", "moved.dat"))
+		if err != nil || string(data) != string(content) {
+			t.Errorf("%s 中移动的文件内容不匹配: %q, %v", dir, data, err)
+		}
+	}
+
+	// 原路径所在的分组有其他修改而重新打包后不再包含该文件，当前路径所在的分组也需要更新
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "file0.dat"), []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 2 {
+		t.Errorf("两个分组都应重新打包: %+v", result.Details)
+	}
+	if moves := readMetadata().Moves; len(moves) != 0 {
+		t.Errorf("重新打包后不应保留移动记录: %v", moves)
+	}
+}
+
+func TestDedupeAcrossGroups(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	// 在两个不同分组中放入内容相同的文件
+	duplicate := []byte("identical chunk content shared by two groups")
+	for _, name := range []string{"0000/dup.dat", "0100/subdir/dup.dat"} {
+		if err := os.WriteFile(filepath.Join(chunkDir, filepath.FromSlash(name)), duplicate, 0644); err != nil {
+			t.Fatalf("创建重复文件失败: %v", err)
+		}
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		Dedupe:       true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 重复内容只保存一份
+	blobs, err := os.ReadDir(filepath.Join(remoteDir, BlobDirName))
+	if err != nil {
+		t.Fatalf("读取blob目录失败: %v", err)
+	}
+	if len(blobs) != 1 {
+		t.Errorf("预期1个blob，实际 %d 个", len(blobs))
+	}
+
+	var metadata models.BackupMetadata
+	content, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	if len(metadata.Dedupe["0000-00ff.tar.gz"]) != 1 || len(metadata.Dedupe["0100-01ff.tar.gz"]) != 1 {
+		t.Errorf("每个压缩包应记录1个去重文件，实际 %v", metadata.Dedupe)
+	}
+
+	// 全量恢复后的文件树与原始一致
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+
+	originalTree, err := scanner.NewChunkScanner(chunkDir).ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := scanner.NewChunkScanner(restoreConfig.ChunkPath).ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	if changed := scanner.CompareFileTrees(originalTree, restoredTree); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+
+	// 单独恢复去重的文件
+	singleConfig := *config
+	singleConfig.ChunkPath = filepath.Join(testDir, "restore-single")
+	if _, err := NewBackupManager(&singleConfig, mockStorage).RunRestore(ctx, "0100/subdir/dup.dat"); err != nil {
+		t.Fatalf("恢复去重文件失败: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(singleConfig.ChunkPath, "0100", "subdir", "dup.dat"))
+	if err != nil || string(data) != string(duplicate) {
+		t.Errorf("恢复的去重文件内容不匹配: %q, %v", data, err)
+	}
+}
+
+// TestEmptyDatastore 测试chunk路径下没有chunk目录时备份报错且不上传元数据，--allow-empty时继续
+func TestEmptyDatastore(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	// 只有不符合命名规则的目录和文件，类似把--chunk-path指向了datastore根目录
+	if err := os.MkdirAll(filepath.Join(chunkDir, "lost+found"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(chunkDir, ".lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	store := storage.NewMockStorage(remoteDir)
+	_, err := NewBackupManager(config, store).RunFullBackup(context.Background())
+	if !errors.Is(err, ErrNoChunkDirectories) {
+		t.Fatalf("没有chunk目录时应返回ErrNoChunkDirectories，实际: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, MetadataFileName)); !os.IsNotExist(err) {
+		t.Error("没有chunk目录时不应上传元数据")
+	}
+
+	config.AllowEmpty = true
+	if err := os.MkdirAll(config.TempPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewBackupManager(config, store).RunFullBackup(context.Background())
+	if err != nil || result.TotalArchives != 0 {
+		t.Fatalf("--allow-empty时应完成空备份: %+v, %v", result, err)
+	}
+
+	// 已有备份的datastore变空时，增量备份不应把所有目录当作已删除
+	createInitialChunkData(t, chunkDir)
+	config.AllowEmpty = false
+	if _, err := NewBackupManager(config, store).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	for _, dir := range []string{"0000", "0001", "00ff", "0100"} {
+		if err := os.RemoveAll(filepath.Join(chunkDir, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Mode = "incremental"
+	if _, err := NewBackupManager(config, store).RunIncrementalBackup(context.Background()); !errors.Is(err, ErrNoChunkDirectories) {
+		t.Errorf("增量备份时chunk目录为空应返回ErrNoChunkDirectories，实际: %v", err)
+	}
+}
+
+func TestDatastoreMismatch(t *testing.T) {
+	testDir := t.TempDir()
+	firstDir := filepath.Join(testDir, "first", ".chunk")
+	secondDir := filepath.Join(testDir, "second", ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, firstDir)
+	createInitialChunkData(t, secondDir)
+
+	config := &models.Config{
+		ChunkPath:    firstDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 同一目录的增量备份正常执行
+	config.Mode = "incremental"
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+
+	// 指向其他chunk目录时拒绝执行
+	otherConfig := *config
+	otherConfig.ChunkPath = secondDir
+	if _, err := NewBackupManager(&otherConfig, mockStorage).RunIncrementalBackup(ctx); !errors.Is(err, ErrDatastoreMismatch) {
+		t.Fatalf("chunk目录不同时应返回ErrDatastoreMismatch，实际 %v", err)
+	}
+
+	// 使用Force继续执行
+	otherConfig.Force = true
+	if _, err := NewBackupManager(&otherConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("使用Force的增量备份失败: %v", err)
+	}
+
+	// 上次的前缀位数超过本次的目录名位数时无法按原方式分组，--force也不能跳过
+	hexConfig := otherConfig
+	hexConfig.HexDigits = 1
+	if _, err := NewBackupManager(&hexConfig, mockStorage).RunIncrementalBackup(ctx); err == nil || !strings.Contains(err.Error(), "hex digits") {
+		t.Fatalf("前缀位数超过hex-digits时应报错，实际 %v", err)
+	}
+
+	// 移动datastore后首次指定标识时接受上次的路径指纹，不需要Force
+	movedConfig := *config
+	movedConfig.DatastoreID = "pbs-main"
+	if _, err := NewBackupManager(&movedConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("上次使用路径指纹时首次指定datastore标识应成功: %v", err)
+	}
+	metadata, err := NewBackupManager(&movedConfig, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("加载元数据失败: %v", err)
+	}
+	if metadata.DatastoreID != "pbs-main" {
+		t.Errorf("元数据应改为记录指定的标识，实际 %s", metadata.DatastoreID)
+	}
+
+	// 用户指定的标识在chunk目录变化后仍然匹配
+	movedConfig.ChunkPath = secondDir
+	if _, err := NewBackupManager(&movedConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("datastore标识相同时增量备份应成功: %v", err)
+	}
+
+	// 已记录指定的标识后，换成其他标识或回到路径指纹都拒绝执行
+	renamedConfig := movedConfig
+	renamedConfig.DatastoreID = "pbs-other"
+	if _, err := NewBackupManager(&renamedConfig, mockStorage).RunIncrementalBackup(ctx); !errors.Is(err, ErrDatastoreMismatch) {
+		t.Fatalf("datastore标识不同时应返回ErrDatastoreMismatch，实际 %v", err)
+	}
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); !errors.Is(err, ErrDatastoreMismatch) {
+		t.Fatalf("未指定标识时应返回ErrDatastoreMismatch，实际 %v", err)
+	}
+}
+
+// TestNamespace 测试命名空间记录在元数据中并用作远程子目录，增量备份和恢复检查命名空间
+func TestNamespace(t *testing.T) {
+	for _, invalid := range []string{"a//b", "-a", "a b", "a/b/c/d/e/f/g/h"} {
+		if _, err := ParseNamespace(invalid); err == nil {
+			t.Errorf("命名空间 %q 应无效", invalid)
+		}
+	}
+	if ns, err := ParseNamespace("/prod/db/"); err != nil || ns != "prod/db" {
+		t.Errorf("应忽略首尾的/，实际 %q, %v", ns, err)
+	}
+	if path := NamespaceRemotePath("/", "prod/db"); path != filepath.Join("/", "ns", "prod", "ns", "db") {
+		t.Errorf("命名空间子目录应与PBS布局相同，实际 %s", path)
+	}
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   NamespaceRemotePath("/", "prod"),
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		Namespace:    "prod",
+		NamespaceDir: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(remoteDir, "ns", "prod", MetadataFileName))
+	if err != nil {
+		t.Fatalf("元数据应保存在命名空间的子目录中: %v", err)
+	}
+	var metadata models.BackupMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	if metadata.Namespace != "prod" {
+		t.Errorf("元数据应记录命名空间，实际 %q", metadata.Namespace)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, ChunkDirName)); !os.IsNotExist(err) {
+		t.Errorf("远程根目录下不应有压缩包: %v", err)
+	}
+
+	// 同一路径下的元数据属于其他命名空间时，增量备份和恢复都拒绝执行
+	config.Mode = "incremental"
+	otherConfig := *config
+	otherConfig.Namespace = "dev"
+	if _, err := NewBackupManager(&otherConfig, mockStorage).RunIncrementalBackup(ctx); !errors.Is(err, ErrNamespaceMismatch) {
+		t.Fatalf("命名空间不同时应返回ErrNamespaceMismatch，实际 %v", err)
+	}
+	otherConfig.ChunkPath = filepath.Join(testDir, "restore-dev")
+	if _, err := NewBackupManager(&otherConfig, mockStorage).RunRestore(ctx, ""); !errors.Is(err, ErrNamespaceMismatch) {
+		t.Fatalf("恢复其他命名空间的备份应返回ErrNamespaceMismatch，实际 %v", err)
+	}
+
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, "0100", "file0.dat")); err != nil {
+		t.Errorf("恢复的文件不存在: %v", err)
+	}
+}
+
+// TestAutoBackup 测试自动模式根据变化比例选择全量或增量备份
+func TestAutoBackup(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "auto",
+		FullThreshold: 0.5,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+
+	// 远程没有元数据时执行全量备份
+	result, err := NewBackupManager(config, mockStorage).RunAutoBackup(context.Background())
+	if err != nil {
+		t.Fatalf("首次自动备份失败: %v", err)
+	}
+	if result.Mode != "full" {
+		t.Errorf("首次自动备份应为全量备份，实际 %s", result.Mode)
+	}
+
+	// 修改4个目录中的1个，比例25%，执行增量备份
+	writeChunkFile := func(dir string) {
+		path := filepath.Join(chunkDir, dir, "new.dat")
+		if err := os.WriteFile(path, []byte("new content "+dir), 0644); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
+		}
+	}
+	writeChunkFile("0000")
+
+	// 增量备份沿用选择备份类型时的扫描结果，只扫描一遍
+	manager := NewBackupManager(config, mockStorage)
+	scans := 0
+	manager.SetScanProgress(func(done, total int) {
+		if done == total {
+			scans++
+		}
+	})
+	result, err = manager.RunAutoBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量自动备份失败: %v", err)
+	}
+	if result.Mode != "incremental" || result.Drift != 0.25 {
+		t.Errorf("预期增量备份且变化比例0.25，实际 %s %.2f", result.Mode, result.Drift)
+	}
+	if scans != 1 {
+		t.Errorf("自动备份应只扫描一次chunk目录，实际 %d 次", scans)
+	}
+	if result.UpdatedArchives != 1 || result.SkippedArchives != 1 {
+		t.Errorf("预期更新1个、跳过1个压缩包，实际更新 %d 跳过 %d", result.UpdatedArchives, result.SkippedArchives)
+	}
+
+	// 修改3个目录，比例75%，执行全量备份并沿用元数据中的前缀位数
+	writeChunkFile("0001")
+	writeChunkFile("00ff")
+	writeChunkFile("0100")
+	config.PrefixDigits = 3
+
+	result, err = NewBackupManager(config, mockStorage).RunAutoBackup(context.Background())
+	if err != nil {
+		t.Fatalf("全量自动备份失败: %v", err)
+	}
+	if result.Mode != "full" || result.Drift != 0.75 {
+		t.Errorf("预期全量备份且变化比例0.75，实际 %s %.2f", result.Mode, result.Drift)
+	}
+	if result.TotalArchives != 2 {
+		t.Errorf("全量备份应沿用2位前缀生成2个压缩包，实际 %d", result.TotalArchives)
+	}
+}
+
+// TestRetentionPolicy 测试祖父-父-子保留策略的快照选择
+func TestRetentionPolicy(t *testing.T) {
+	day := func(month time.Month, d, hour int) time.Time {
+		return time.Date(2024, month, d, hour, 0, 0, 0, time.Local)
+	}
+	times := []time.Time{
+		day(3, 14, 2), // 0 周四
+		day(3, 14, 1), // 1 同一天较早的快照
+		day(3, 13, 2), // 2
+		day(3, 12, 2), // 3
+		day(3, 8, 2),  // 4 上一周
+		day(3, 1, 2),  // 5 再上一周
+		day(2, 20, 2), // 6 上个月
+		day(1, 10, 2), // 7 两个月前
+	}
+
+	testCases := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []int
+	}{
+		{"每天", RetentionPolicy{Daily: 3}, []int{0, 2, 3}},
+		{"每周", RetentionPolicy{Weekly: 3}, []int{0, 4, 5}},
+		{"每月", RetentionPolicy{Monthly: 3}, []int{0, 6, 7}},
+		{"组合", RetentionPolicy{Daily: 2, Weekly: 2, Monthly: 2}, []int{0, 2, 4, 6}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keep := tc.policy.retain(times)
+			if len(keep) != len(tc.want) {
+				t.Fatalf("保留 %v，期望 %v", keep, tc.want)
+			}
+			for _, i := range tc.want {
+				if !keep[i] {
+					t.Errorf("快照 %d 应该保留，实际保留 %v", i, keep)
+				}
+			}
+		})
+	}
+}
+
+// TestPrune 测试清理历史快照时只删除没有被保留快照引用的压缩包和blob
+func TestPrune(t *testing.T) {
+	testDir := t.TempDir()
+	remoteDir := filepath.Join(testDir, "remote")
+
+	writeRemote := func(path string, data []byte) {
+		fullPath := filepath.Join(remoteDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(fullPath, data, 0644); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
+		}
+	}
+	writeMetadata := func(path string, backupTime time.Time, archives []string, blobs []string) {
+		metadata := models.BackupMetadata{
+			Version:      MetadataVersion,
+			PrefixDigits: 2,
+			BackupTime:   backupTime,
+			Checksums:    make(map[string]string),
+			Dedupe:       make(map[string][]models.DedupeEntry),
+		}
+		for _, archive := range archives {
+			metadata.Checksums[archive] = "checksum"
+		}
+		for _, blob := range blobs {
+			metadata.Dedupe[archives[0]] = append(metadata.Dedupe[archives[0]], models.DedupeEntry{Path: "0000/" + blob, Hash: blob})
+		}
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			t.Fatalf("序列化元数据失败: %v", err)
+		}
+		writeRemote(path, data)
+	}
+
+	now := time.Date(2024, 3, 14, 2, 0, 0, 0, time.Local)
+	writeMetadata(MetadataFileName, now, []string{"00-00ff.tar.gz"}, nil)
+	writeMetadata("history/"+historyFileName(now), now, []string{"00-00ff.tar.gz"}, nil)
+	writeMetadata("history/"+historyFileName(now.AddDate(0, 0, -1)), now.AddDate(0, 0, -1), []string{"00-00ff.tar.gz", "01-01ff.tar.gz"}, []string{"shared"})
+	oldSnapshot := "history/" + historyFileName(now.AddDate(0, 0, -2))
+	writeMetadata(oldSnapshot, now.AddDate(0, 0, -2), []string{"00-00ff.tar.gz", "0-0fff.tar.gz"}, []string{"shared", "orphan"})
+
+	for _, archive := range []string{"00-00ff.tar.gz", "01-01ff.tar.gz", "0-0fff.tar.gz"} {
+		writeRemote(ChunkDirName+"/"+archive, []byte("archive"))
+		writeRemote(Sha256DirName+"/"+archive+".sha256", []byte("checksum  "+archive))
+	}
+	writeRemote(BlobDirName+"/shared", []byte("shared"))
+	writeRemote(BlobDirName+"/orphan", []byte("orphan"))
+
+	config := &models.Config{RemotePath: "/", TempPath: filepath.Join(testDir, "temp")}
+	manager := NewBackupManager(config, storage.NewMockStorage(remoteDir))
+	policy := RetentionPolicy{Daily: 2}
+
+	// 预览模式不删除任何文件
+	result, err := manager.RunPrune(context.Background(), policy, true)
+	if err != nil {
+		t.Fatalf("预览清理失败: %v", err)
+	}
+	if len(result.PrunedSnapshots) != 1 || len(result.DeletedArchives) != 1 || len(result.DeletedBlobs) != 1 {
+		t.Errorf("预览结果不正确: %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, filepath.FromSlash(oldSnapshot))); err != nil {
+		t.Errorf("预览模式不应删除快照: %v", err)
+	}
+
+	// 刚写入的快照和对象处于保护期内，本次不清理
+	protectedPolicy := policy
+	protectedPolicy.MinAge = time.Hour
+	result, err = manager.RunPrune(context.Background(), protectedPolicy, false)
+	if err != nil {
+		t.Fatalf("清理失败: %v", err)
+	}
+	if len(result.ProtectedSnapshots) != 1 || len(result.PrunedSnapshots) != 0 || len(result.DeletedArchives) != 0 || len(result.DeletedBlobs) != 0 {
+		t.Errorf("保护期内不应删除任何文件: %+v", result)
+	}
+
+	// 快照本身超过保护期，但将删除的压缩包仍在保护期内
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(remoteDir, filepath.FromSlash(oldSnapshot)), old, old); err != nil {
+		t.Fatalf("修改时间失败: %v", err)
+	}
+	result, err = manager.RunPrune(context.Background(), protectedPolicy, false)
+	if err != nil {
+		t.Fatalf("清理失败: %v", err)
+	}
+	if len(result.ProtectedSnapshots) != 1 || len(result.PrunedSnapshots) != 0 {
+		t.Errorf("压缩包在保护期内时不应清理快照: %+v", result)
+	}
+	for _, path := range []string{ChunkDirName + "/0-0fff.tar.gz", BlobDirName + "/orphan"} {
+		if err := os.Chtimes(filepath.Join(remoteDir, filepath.FromSlash(path)), old, old); err != nil {
+			t.Fatalf("修改时间失败: %v", err)
+		}
+	}
+
+	result, err = manager.RunPrune(context.Background(), protectedPolicy, false)
+	if err != nil {
+		t.Fatalf("清理失败: %v", err)
+	}
+	if len(result.RetainedSnapshots) != 2 {
+		t.Errorf("应保留2个快照，实际 %v", result.RetainedSnapshots)
+	}
+	if len(result.DeletedArchives) != 1 || result.DeletedArchives[0] != "0-0fff.tar.gz" {
+		t.Errorf("只应删除0-0fff.tar.gz，实际 %v", result.DeletedArchives)
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(filepath.Join(remoteDir, filepath.FromSlash(path)))
+		return err == nil
+	}
+	for path, want := range map[string]bool{
+		oldSnapshot:                             false,
+		ChunkDirName + "/0-0fff.tar.gz":         false,
+		Sha256DirName + "/0-0fff.tar.gz.sha256": false,
+		BlobDirName + "/orphan":                 false,
+		ChunkDirName + "/00-00ff.tar.gz":        true,
+		ChunkDirName + "/01-01ff.tar.gz":        true,
+		BlobDirName + "/shared":                 true,
+		"history/" + historyFileName(now):       true,
+		MetadataFileName:                        true,
+	} {
+		if exists(path) != want {
+			t.Errorf("%s 存在=%v，期望 %v", path, exists(path), want)
+		}
+	}
+}
+
+func TestGC(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	remoteFile := func(path string) string {
+		return filepath.Join(remoteDir, filepath.FromSlash(path))
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(remoteFile(path))
+		return err == nil
+	}
+
+	// 孤立对象：已不在元数据中的压缩包及其校验和文件、中断的上传留下的临时文件
+	orphans := []string{
+		ChunkDirName + "/0000-00ff.tar.gz" + uploadingSuffix,
+		ChunkDirName + "/0200-02ff.tar.gz",
+		Sha256DirName + "/0200-02ff.tar.gz.sha256",
+	}
+	for _, path := range orphans {
+		if err := os.WriteFile(remoteFile(path), []byte("orphan"), 0644); err != nil {
+			t.Fatalf("写入孤立对象失败: %v", err)
+		}
+	}
+	// 缺失的压缩包和格式不规范的校验和文件
+	if err := os.Remove(remoteFile(ChunkDirName + "/0100-01ff.tar.gz")); err != nil {
+		t.Fatalf("删除压缩包失败: %v", err)
+	}
+	checksumPath := remoteFile(Sha256DirName + "/0000-00ff.tar.gz.sha256")
+	standard, err := os.ReadFile(checksumPath)
+	if err != nil {
+		t.Fatalf("读取校验和文件失败: %v", err)
+	}
+	drifted := strings.ReplaceAll(string(standard), "\n", "\r\n")
+	if err := os.WriteFile(checksumPath, []byte(drifted), 0644); err != nil {
+		t.Fatalf("写入校验和文件失败: %v", err)
+	}
+
+	// 预览模式只报告
+	manager := NewBackupManager(config, mockStorage)
+	result, err := manager.RunGC(context.Background(), GCOptions{Repair: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("预览整理失败: %v", err)
+	}
+	if !slices.Equal(result.DeletedOrphans, orphans) {
+		t.Errorf("孤立对象不正确: %v", result.DeletedOrphans)
+	}
+	if !slices.Equal(result.MissingArchives, []string{"0100-01ff.tar.gz"}) || len(result.RepairedArchives) != 0 {
+		t.Errorf("预览时应报告缺失的压缩包但不修复: %+v", result)
+	}
+	if !slices.Equal(result.RewrittenChecksums, []string{"0000-00ff.tar.gz"}) {
+		t.Errorf("应报告格式不规范的校验和文件，实际 %v", result.RewrittenChecksums)
+	}
+	for _, path := range orphans {
+		if !exists(path) {
+			t.Errorf("预览模式不应删除 %s", path)
+		}
+	}
+	if data, _ := os.ReadFile(checksumPath); string(data) != drifted {
+		t.Errorf("预览模式不应改写校验和文件")
+	}
+
+	// 刚写入的孤立对象处于保护期内
+	result, err = manager.RunGC(context.Background(), GCOptions{MinAge: time.Hour})
+	if err != nil {
+		t.Fatalf("整理失败: %v", err)
+	}
+	if len(result.DeletedOrphans) != 0 || len(result.ProtectedOrphans) != len(orphans) {
+		t.Errorf("保护期内的孤立对象不应删除: %+v", result)
+	}
+
+	result, err = NewBackupManager(config, mockStorage).RunGC(context.Background(), GCOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("整理失败: %v", err)
+	}
+	if !slices.Equal(result.RepairedArchives, []string{"0100-01ff.tar.gz"}) {
+		t.Errorf("应修复缺失的压缩包，实际 %v", result.RepairedArchives)
+	}
+	for _, path := range orphans {
+		if exists(path) {
+			t.Errorf("孤立对象 %s 应被删除", path)
+		}
+	}
+	if data, _ := os.ReadFile(checksumPath); string(data) != string(standard) {
+		t.Errorf("校验和文件应改写为标准格式，实际 %q", data)
+	}
+
+	verifyResult, err := NewBackupManager(config, mockStorage).RunVerify(context.Background(), 1, MismatchReport)
+	if err != nil || len(verifyResult.Mismatches) != 0 {
+		t.Errorf("整理后校验应通过: %+v, %v", verifyResult, err)
+	}
+
+	// 再次整理没有需要处理的对象
+	result, err = NewBackupManager(config, mockStorage).RunGC(context.Background(), GCOptions{})
+	if err != nil {
+		t.Fatalf("整理失败: %v", err)
+	}
+	if len(result.DeletedOrphans)+len(result.MissingArchives)+len(result.RewrittenChecksums) != 0 {
+		t.Errorf("整理后不应再有需要处理的对象: %+v", result)
+	}
+}
+
+// TestGCKeepsPrevReferences 测试gc保留只被.prev引用的带时间戳后缀的压缩包和文件树，回退到.prev后仍能恢复
+func TestGCKeepsPrevReferences(t *testing.T) {
+	defer func(delay time.Duration) { jsonRetryDelay = delay }(jsonRetryDelay)
+	jsonRetryDelay = 0
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		ArchiveSuffix: true,
+		SplitFileTree: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	manager := func(mode string, clock time.Time) *BackupManager {
+		cfg := *config
+		cfg.Mode = mode
+		bm := NewBackupManager(&cfg, mockStorage)
+		bm.now = func() time.Time { return clock }
+		return bm
+	}
+
+	if _, err := manager("full", time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(chunkDir, "0001", "new.dat"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager("incremental", time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC)).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+
+	result, err := manager("incremental", time.Now()).RunGC(ctx, GCOptions{})
+	if err != nil {
+		t.Fatalf("整理失败: %v", err)
+	}
+	if len(result.DeletedOrphans) != 0 {
+		t.Errorf(".prev引用的对象不应删除: %v", result.DeletedOrphans)
+	}
+
+	// 主元数据损坏后回退到.prev恢复第一次备份的内容
+	if err := os.WriteFile(filepath.Join(remoteDir, MetadataFileName), []byte(`{"version": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.UsePrevMetadata = true
+	config.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := manager("restore", time.Now()).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("回退到.prev恢复失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.ChunkPath, "0001", "file0.dat")); err != nil {
+		t.Errorf("恢复结果缺少第一次备份的文件: %v", err)
+	}
+}
+
+// TestRestoreManifest 测试启用后上传的恢复清单与备份元数据一致
+func TestRestoreManifest(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:       chunkDir,
+		RemotePath:      "/",
+		TempPath:        filepath.Join(testDir, "temp"),
+		PrefixDigits:    2,
+		Mode:            "full",
+		RestoreManifest: true,
+	}
+	if _, err := NewBackupManager(config, storage.NewMockStorage(remoteDir)).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	var metadata models.BackupMetadata
+	var manifest models.RestoreManifest
+	for name, target := range map[string]interface{}{MetadataFileName: &metadata, RestoreManifestFileName: &manifest} {
+		data, err := os.ReadFile(filepath.Join(remoteDir, name))
+		if err != nil {
+			t.Fatalf("读取 %s 失败: %v", name, err)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			t.Fatalf("解析 %s 失败: %v", name, err)
+		}
+	}
+
+	if len(manifest.Archives) != len(metadata.Checksums) {
+		t.Fatalf("清单包含 %d 个压缩包，元数据有 %d 个", len(manifest.Archives), len(metadata.Checksums))
+	}
+	for i, archive := range manifest.Archives {
+		if i > 0 && manifest.Archives[i-1].Name >= archive.Name {
+			t.Errorf("压缩包未按名称排序: %s 在 %s 之后", archive.Name, manifest.Archives[i-1].Name)
+		}
+		if archive.SHA256 != metadata.Checksums[archive.Name] {
+			t.Errorf("压缩包 %s 的校验和与元数据不一致", archive.Name)
+		}
+		if len(archive.Commands) != 3 || !strings.Contains(archive.Commands[2], "tar -xzf '"+archive.Name+"'") {
+			t.Errorf("压缩包 %s 的命令不正确: %v", archive.Name, archive.Commands)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(config.TempPath, RestoreManifestFileName)); !os.IsNotExist(err) {
+		t.Errorf("上传后应删除本地恢复清单: %v", err)
+	}
+
+	if quoted := shellQuote("it's"); quoted != `'it'\''s'` {
+		t.Errorf("shellQuote结果不正确: %s", quoted)
+	}
+}
+
+// failingStorage 上传指定压缩包或所有文件时失败，模拟远程存储不可用
+type failingStorage struct {
+	*storage.MockStorage
+	failArchive string // 为空时所有上传都失败
+}
+
+func (s *failingStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	if s.failArchive == "" || filepath.Base(localPath) == s.failArchive {
+		return errors.New("connection refused")
+	}
+	return s.MockStorage.UploadFile(ctx, localPath, remotePath)
+}
+
+// TestStructuredErrors 测试备份包返回的错误可以用errors.Is/errors.As区分类别
+func TestStructuredErrors(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	ctx := context.Background()
+
+	// 远程存储完全不可用
+	unavailable := &failingStorage{MockStorage: storage.NewMockStorage(remoteDir)}
+	if _, err := NewBackupManager(config, unavailable).RunFullBackup(ctx); !errors.Is(err, ErrRemoteUnavailable) {
+		t.Errorf("上传元数据失败应返回ErrRemoteUnavailable，实际 %v", err)
+	}
+
+	// 单个压缩包失败时返回结果和PartialFailureError
+	partial := &failingStorage{MockStorage: storage.NewMockStorage(remoteDir), failArchive: "0100-01ff.tar.gz"}
+	result, err := NewBackupManager(config, partial).RunFullBackup(ctx)
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("部分压缩包失败应返回ErrPartialFailure，实际 %v", err)
+	}
+	var partialErr *PartialFailureError
+	if !errors.As(err, &partialErr) || len(partialErr.Failed) != 1 || partialErr.Failed[0] != "0100-01ff.tar.gz" {
+		t.Errorf("PartialFailureError应列出失败的压缩包，实际 %v", err)
+	}
+	if result == nil || result.UpdatedArchives != 1 {
+		t.Errorf("部分失败时仍应返回备份结果: %+v", result)
+	}
+	if !strings.Contains(result.Details["0100-01ff.tar.gz"], ErrRemoteUnavailable.Error()) {
+		t.Errorf("失败详情应包含远程错误类别: %s", result.Details["0100-01ff.tar.gz"])
+	}
+
+	// 完整备份后损坏远程压缩包，恢复时返回ChecksumError
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(remoteDir, ChunkDirName, "0000-00ff.tar.gz"), []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("损坏压缩包失败: %v", err)
+	}
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	_, err = NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "")
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("压缩包损坏时应返回ErrChecksumMismatch，实际 %v", err)
+	}
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) || checksumErr.Name != "0000-00ff.tar.gz" {
+		t.Errorf("ChecksumError应包含压缩包名，实际 %v", err)
+	}
+}
+
+// TestDirBatchSize 测试按目录批次切分的压缩包可以增量备份和恢复
+func TestDirBatchSize(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		DirBatchSize: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	// 0000、0001、00ff、0100 切分为 0000-0001、00fe-00ff、0100-0101
+	result, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx)
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if result.TotalArchives != 3 {
+		t.Fatalf("预期3个压缩包，实际 %d", result.TotalArchives)
+	}
+	for _, name := range []string{"0000-0001.tar.gz", "00fe-00ff.tar.gz", "0100-0101.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(remoteDir, ChunkDirName, name)); err != nil {
+			t.Errorf("远程缺少压缩包 %s: %v", name, err)
+		}
+	}
+
+	// 增量备份沿用元数据中的批次大小，不受当前配置影响
+	if err := os.WriteFile(filepath.Join(chunkDir, "00ff", "new.dat"), []byte("new"), 0644); err != nil {
+		t.Fatalf("写入文件失败: %v", err)
+	}
+	incrementalConfig := *config
+	incrementalConfig.DirBatchSize = 0
+	incrementalConfig.Mode = "incremental"
+	result, err = NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.TotalArchives != 3 || result.UpdatedArchives != 1 || result.Details["00fe-00ff.tar.gz"] != "created and uploaded" {
+		t.Errorf("增量备份应只更新00fe-00ff.tar.gz: %+v", result)
+	}
+
+	// 全量恢复合并所有子压缩包
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	originalTree, err := NewBackupManager(config, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := NewBackupManager(&restoreConfig, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	if changed := scanner.CompareFileTrees(originalTree, restoredTree); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+
+	// 单文件恢复定位到对应的子压缩包
+	singleConfig := *config
+	singleConfig.ChunkPath = filepath.Join(testDir, "single")
+	if _, err := NewBackupManager(&singleConfig, mockStorage).RunRestore(ctx, "0100/file1.dat"); err != nil {
+		t.Fatalf("单文件恢复失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(singleConfig.ChunkPath, "0100", "file1.dat")); err != nil {
+		t.Errorf("单文件恢复后文件不存在: %v", err)
+	}
+}
+
+// TestMinArchiveSize 测试合并相邻小分组后的增量备份、恢复和校验
+func TestMinArchiveSize(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:      chunkDir,
+		RemotePath:     "/",
+		TempPath:       filepath.Join(testDir, "temp"),
+		PrefixDigits:   2,
+		MinArchiveSize: 1 << 30,
+		Mode:           "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	// 0000-00ff与0100-01ff都很小，合并为一个按实际范围命名的压缩包
+	result, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx)
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if result.TotalArchives != 1 {
+		t.Fatalf("预期1个压缩包，实际 %d", result.TotalArchives)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, ChunkDirName, "0000-01ff.tar.gz")); err != nil {
+		t.Fatalf("远程缺少合并后的压缩包: %v", err)
+	}
+
+	// 增量备份沿用元数据中的合并范围，落入范围的新目录写入合并后的压缩包
+	if err := os.MkdirAll(filepath.Join(chunkDir, "0150"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(chunkDir, "0150", "new.dat"), []byte("new"), 0644); err != nil {
+		t.Fatalf("写入文件失败: %v", err)
+	}
+	incrementalConfig := *config
+	incrementalConfig.MinArchiveSize = 0
+	incrementalConfig.Mode = "incremental"
+	result, err = NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.TotalArchives != 1 || result.Details["0000-01ff.tar.gz"] != "created and uploaded" {
+		t.Errorf("增量备份应更新0000-01ff.tar.gz: %+v", result)
+	}
+
+	verifyResult, err := NewBackupManager(&incrementalConfig, mockStorage).RunVerify(ctx, 2, MismatchReport)
+	if err != nil || verifyResult.VerifiedArchives != 1 || len(verifyResult.Mismatches) != 0 {
+		t.Errorf("校验合并后的压缩包失败: %+v, %v", verifyResult, err)
+	}
+
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	originalTree, err := NewBackupManager(config, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := NewBackupManager(&restoreConfig, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	if changed := scanner.CompareFileTrees(originalTree, restoredTree); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+
+	// 单文件恢复按合并范围定位压缩包
+	singleConfig := *config
+	singleConfig.ChunkPath = filepath.Join(testDir, "single")
+	if _, err := NewBackupManager(&singleConfig, mockStorage).RunRestore(ctx, "0150/new.dat"); err != nil {
+		t.Fatalf("单文件恢复失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(singleConfig.ChunkPath, "0150", "new.dat")); err != nil {
+		t.Errorf("单文件恢复后文件不存在: %v", err)
+	}
+}
+
+// TestSmartFull 测试智能全量备份跳过源内容未变化的压缩包
+func TestSmartFull(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		SmartFull:    true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	// 第一次没有上次的元数据，全部生成并记录源内容校验和
+	result, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx)
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 2 {
+		t.Fatalf("首次全量备份应生成2个压缩包: %+v", result)
+	}
+	metadata, err := NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("加载元数据失败: %v", err)
+	}
+	if len(metadata.SourceSums) != 2 {
+		t.Fatalf("元数据应记录2个源内容校验和: %v", metadata.SourceSums)
+	}
+
+	// 只修改0100的内容（大小不变、修改时间还原），0000-00ff沿用上次的压缩包
+	path := filepath.Join(chunkDir, "0100", "file1.dat")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[0] ^= 0xff
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+
+	result, err = NewBackupManager(config, mockStorage).RunFullBackup(ctx)
+	if err != nil {
+		t.Fatalf("智能全量备份失败: %v", err)
+	}
+	if result.Details["0000-00ff.tar.gz"] != "source unchanged, skipped" || result.Details["0100-01ff.tar.gz"] != "created and uploaded" {
+		t.Errorf("只应重新生成0100-01ff.tar.gz: %v", result.Details)
+	}
+	for _, uploaded := range result.UploadedFiles {
+		if strings.Contains(uploaded, "0000-00ff") {
+			t.Errorf("不应上传未变化的压缩包: %s", uploaded)
+		}
+	}
+
+	// 沿用的记录与远程的压缩包一致
+	verifyResult, err := NewBackupManager(config, mockStorage).RunVerify(ctx, 2, MismatchReport)
+	if err != nil || verifyResult.VerifiedArchives != 2 || len(verifyResult.Mismatches) != 0 {
+		t.Errorf("校验失败: %+v, %v", verifyResult, err)
+	}
+
+	// 未启用时照常重新生成所有压缩包
+	config.SmartFull = false
+	result, err = NewBackupManager(config, mockStorage).RunFullBackup(ctx)
+	if err != nil || result.UpdatedArchives != 2 {
+		t.Errorf("普通全量备份应重新生成所有压缩包: %+v, %v", result, err)
+	}
+}
+
+// TestAnnotations 测试自定义字段写入元数据并在恢复结果中返回
+func TestAnnotations(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		Annotations:  map[string]string{"ticket": "OPS-1234", "env": "prod"},
+	}
+	mockStorage := storage.NewMockStorage(filepath.Join(testDir, "remote"))
+	ctx := context.Background()
+
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	metadata, err := NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("加载元数据失败: %v", err)
+	}
+	if metadata.Annotations["ticket"] != "OPS-1234" || metadata.Annotations["env"] != "prod" {
+		t.Errorf("元数据中的自定义字段不正确: %v", metadata.Annotations)
+	}
+
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	restoreConfig.Annotations = nil
+	result, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "")
+	if err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if result.Annotations["ticket"] != "OPS-1234" {
+		t.Errorf("恢复结果应包含备份的自定义字段: %v", result.Annotations)
+	}
+
+	// 字段属于生成元数据的运行，未指定字段的增量备份不沿用
+	incrementalConfig := *config
+	incrementalConfig.Mode = "incremental"
+	incrementalConfig.Annotations = nil
+	incrementalConfig.Repair = true
+	if _, err := NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	metadata, err = NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil || metadata.Annotations != nil {
+		t.Errorf("增量备份未指定字段时不应记录: %v, %v", metadata.Annotations, err)
+	}
+}
+
+// TestDescribeBackup 测试流式读取元数据得到的摘要与完整加载的元数据一致，文件树拆分保存时也能读取
+func TestDescribeBackup(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		Namespace:    "prod",
+		Dedupe:       true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	if _, err := NewBackupManager(config, mockStorage).DescribeBackup(ctx, ""); !errors.Is(err, ErrNoMetadata) {
+		t.Fatalf("没有元数据时应返回ErrNoMetadata，实际 %v", err)
+	}
+
+	for _, split := range []bool{false, true} {
+		config.SplitFileTree = split
+		if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+			t.Fatalf("全量备份失败: %v", err)
+		}
+		manager := NewBackupManager(config, mockStorage)
+		metadata, err := manager.loadRemoteMetadata(ctx)
+		if err != nil {
+			t.Fatalf("加载元数据失败: %v", err)
+		}
+		fileTree, err := manager.loadFileTree(ctx, metadata)
+		if err != nil {
+			t.Fatalf("加载文件树失败: %v", err)
+		}
+		var totalSize int64
+		for _, node := range fileTree {
+			totalSize += node.Size
+		}
+
+		desc, err := manager.DescribeBackup(ctx, "0100/subdir/subfile.dat")
+		if err != nil {
+			t.Fatalf("读取备份摘要失败: %v", err)
+		}
+		if desc.Archives != len(metadata.Checksums) || desc.Directories != len(fileTree) || desc.Files != 16 || desc.TotalSize != totalSize {
+			t.Errorf("拆分保存=%v 时摘要不一致: %+v（压缩包 %d，目录 %d，总大小 %d）", split, desc, len(metadata.Checksums), len(fileTree), totalSize)
+		}
+		if !desc.BackupTime.Equal(metadata.BackupTime) || desc.Namespace != "prod" || desc.DatastoreID != metadata.DatastoreID {
+			t.Errorf("摘要的备份时间、命名空间或datastore标识不一致: %+v", desc)
+		}
+		if !desc.PathFound || desc.Path != "0100/subdir/subfile.dat" {
+			t.Errorf("存在的文件应找到: %+v", desc)
+		}
+
+		for path, want := range map[string]bool{"/0001/subdir/": true, "0001/missing.dat": false, "0200": false} {
+			desc, err := manager.DescribeBackup(ctx, path)
+			if err != nil {
+				t.Fatalf("读取备份摘要失败: %v", err)
+			}
+			if desc.PathFound != want {
+				t.Errorf("路径 %s 应为 %v，实际 %+v", path, want, desc)
+			}
+		}
+	}
+}
+
+func TestSplitFileTree(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "full",
+		SplitFileTree: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	readMetadata := func() models.BackupMetadata {
+		var metadata models.BackupMetadata
+		data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+		if err != nil {
+			t.Fatalf("读取元数据失败: %v", err)
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("解析元数据失败: %v", err)
+		}
+		return metadata
+	}
+	first := readMetadata()
+	if first.FileTree != nil || first.FileTreeFile == "" {
+		t.Fatalf("拆分保存时元数据不应包含文件树: tree_file=%q", first.FileTreeFile)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, first.FileTreeFile)); err != nil {
+		t.Fatalf("文件树对象不存在: %v", err)
+	}
+
+	// 修改一个目录后增量备份，应下载文件树比较并只更新对应压缩包
+	if err := os.WriteFile(filepath.Join(chunkDir, "0001", "new.dat"), []byte("new data"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	config.Mode = "incremental"
+	result, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 1 || result.SkippedArchives != 1 {
+		t.Errorf("预期更新1个、跳过1个压缩包，实际更新 %d、跳过 %d", result.UpdatedArchives, result.SkippedArchives)
+	}
+
+	second := readMetadata()
+	if second.FileTreeFile == first.FileTreeFile {
+		t.Fatalf("每次备份应使用新的文件树对象: %s", second.FileTreeFile)
+	}
+	entries, err := os.ReadDir(filepath.Join(remoteDir, FileTreeDirName))
+	if err != nil {
+		t.Fatalf("读取文件树目录失败: %v", err)
+	}
+	// .prev仍引用第一次的文件树
+	kept := map[string]bool{}
+	for _, entry := range entries {
+		kept[path.Join(FileTreeDirName, entry.Name())] = true
+	}
+	if len(kept) != 2 || !kept[first.FileTreeFile] || !kept[second.FileTreeFile] {
+		t.Errorf("应只保留当前元数据和.prev引用的文件树，实际 %v", kept)
+	}
+
+	// 未启用拆分时仍能读取拆分保存的文件树，并重新内联保存
+	if err := os.WriteFile(filepath.Join(chunkDir, "0100", "new.dat"), []byte("new data"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	config.SplitFileTree = false
+	result, err = NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 1 {
+		t.Errorf("预期更新1个压缩包，实际 %d", result.UpdatedArchives)
+	}
+	if third := readMetadata(); third.FileTreeFile != "" || len(third.FileTree) != 4 {
+		t.Errorf("关闭拆分后应内联保存文件树: tree_file=%q, %d 个目录", third.FileTreeFile, len(third.FileTree))
+	}
+}
+
+func TestIncrementalNoOp(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 模拟上次备份有压缩包失败：元数据缺少一个校验和
+	metadataPath := filepath.Join(remoteDir, MetadataFileName)
+	var metadata models.BackupMetadata
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	delete(metadata.Checksums, "0100-01ff.tar.gz")
+	if data, err = json.Marshal(&metadata); err != nil {
+		t.Fatalf("序列化元数据失败: %v", err)
+	}
+	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
+		t.Fatalf("写入元数据失败: %v", err)
+	}
+	os.Remove(metadataPath + MetadataChecksumSuffix)
+
+	// 没有变化时直接返回，不重新上传元数据
+	config.Mode = "incremental"
+	result, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.TotalArchives != 1 || result.SkippedArchives != 1 || result.UpdatedArchives != 0 {
+		t.Errorf("无变化时应全部跳过，实际总数 %d、跳过 %d、更新 %d", result.TotalArchives, result.SkippedArchives, result.UpdatedArchives)
+	}
+	if after, err := os.ReadFile(metadataPath); err != nil || string(after) != string(data) {
+		t.Errorf("无变化时不应重新上传元数据: %v", err)
+	}
+
+	// 修复模式重新生成缺少校验和的压缩包并上传元数据
+	config.Repair = true
+	result, err = NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("修复模式增量备份失败: %v", err)
+	}
+	if result.TotalArchives != 2 || result.Details["0100-01ff.tar.gz"] == "unchanged, skipped" {
+		t.Errorf("修复模式应重新处理缺少校验和的压缩包: %+v", result.Details)
+	}
+	data, err = os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	metadata = models.BackupMetadata{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	if _, exists := metadata.Checksums["0100-01ff.tar.gz"]; !exists {
+		t.Errorf("修复后元数据应包含 0100-01ff.tar.gz 的校验和")
+	}
+}
+
+func TestListChanged(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+
+	config.Mode = "incremental"
+	if _, err := NewBackupManager(config, mockStorage).ListChanged(context.Background()); !errors.Is(err, ErrNoMetadata) {
+		t.Fatalf("缺少元数据时应返回ErrNoMetadata，实际 %v", err)
+	}
+
+	config.Mode = "full"
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	metadataBefore, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(chunkDir, "0100", "new.dat"), []byte("new data"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(chunkDir, "0200"), 0755); err != nil {
+		t.Fatalf("创建目录失败: %v", err)
+	}
+
+	config.Mode = "incremental"
+	changes, err := NewBackupManager(config, mockStorage).ListChanged(context.Background())
+	if err != nil {
+		t.Fatalf("ListChanged失败: %v", err)
+	}
+	if strings.Join(changes.ChangedDirs, ",") != "0100,0200" {
+		t.Errorf("变化目录不正确: %v", changes.ChangedDirs)
+	}
+	if strings.Join(changes.Archives, ",") != "0100-01ff.tar.gz,0200-02ff.tar.gz" {
+		t.Errorf("需要更新的压缩包不正确: %v", changes.Archives)
+	}
+
+	// 只列出变化，不应上传任何内容
+	metadataAfter, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil || string(metadataAfter) != string(metadataBefore) {
+		t.Errorf("ListChanged不应修改远程元数据: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, ChunkDirName, "0200-02ff.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("ListChanged不应上传压缩包: %v", err)
+	}
+
+	// 检查远程时，未变化的0000-00ff.tar.gz缺失或校验和不一致会列为漂移
+	config.VerifyRemote = true
+	archivePath := filepath.Join(remoteDir, ChunkDirName, "0000-00ff.tar.gz")
+	checkDrift := func(want string) {
+		t.Helper()
+		changes, err := NewBackupManager(config, mockStorage).ListChanged(context.Background())
+		if err != nil {
+			t.Fatalf("ListChanged失败: %v", err)
+		}
+		var got []string
+		for _, drift := range changes.RemoteDrift {
+			got = append(got, drift.Archive+":"+drift.Problem)
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("远程漂移不正确: 预期 %q，实际 %q", want, got)
+		}
+	}
+	checkDrift("")
+
+	if err := os.Rename(archivePath, archivePath+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	checkDrift("0000-00ff.tar.gz:" + DriftArchiveMissing)
+	if err := os.Rename(archivePath+".moved", archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	checksumPath := filepath.Join(remoteDir, Sha256DirName, "0000-00ff.tar.gz.sha256")
+	if err := os.WriteFile(checksumPath, []byte(strings.Repeat("0", 64)+"  0000-00ff.tar.gz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkDrift("0000-00ff.tar.gz:" + DriftChecksumMismatch)
+}
+
+// TestStreamingIncremental 测试文件树拆分保存时边读取边比较的增量备份
+func TestStreamingIncremental(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	// 0001很久没有修改，增量备份时因--newer-than被跳过
+	oldTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := filepath.Walk(filepath.Join(chunkDir, "0001"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, oldTime, oldTime)
+	})
+	if err != nil {
+		t.Fatalf("设置修改时间失败: %v", err)
+	}
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "full",
+		SplitFileTree: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(chunkDir, "0100", "new.dat"), []byte("new data"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(chunkDir, "00ff")); err != nil {
+		t.Fatalf("删除目录失败: %v", err)
+	}
+
+	config.Mode = "incremental"
+	config.GrowthReport = 10
+	config.NewerThan = oldTime.Add(time.Hour)
+	manager := NewBackupManager(config, mockStorage)
+	changes, err := manager.ListChanged(context.Background())
+	if err != nil {
+		t.Fatalf("ListChanged失败: %v", err)
+	}
+	if strings.Join(changes.ChangedDirs, ",") != "00ff,0100" {
+		t.Errorf("变化目录不正确（跳过的0001不应视为删除）: %v", changes.ChangedDirs)
+	}
+
+	result, err := manager.RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.Details["0100-01ff.tar.gz"] == "unchanged, skipped" {
+		t.Errorf("变化的目录所在压缩包应重新上传: %+v", result.Details)
+	}
+	growth := make(map[string]int64)
+	for _, change := range result.GrowthReport {
+		growth[change.Directory] = change.Delta
+	}
+	if len(growth) != 2 || growth["0100"] != int64(len("new data")) || growth["00ff"] >= 0 {
+		t.Errorf("大小变化报告不正确: %+v", result.GrowthReport)
+	}
+
+	// 新的文件树沿用跳过目录的记录，不包含删除的目录
+	metadata, err := manager.loadRemoteMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	fileTree, err := manager.loadFileTree(context.Background(), metadata)
+	if err != nil {
+		t.Fatalf("读取文件树失败: %v", err)
+	}
+	if _, exists := fileTree["0001"]; !exists {
+		t.Error("文件树应保留被跳过的目录0001")
+	}
+	if _, exists := fileTree["00ff"]; exists {
+		t.Error("文件树不应包含已删除的目录00ff")
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(config.TempPath, "download-*")); len(leftovers) != 0 {
+		t.Errorf("比较结束后不应残留临时文件: %v", leftovers)
+	}
+}
+
+// interruptedStorage 第一次下载元数据时只写入一半内容后失败，模拟不稳定的连接
+type interruptedStorage struct {
+	*storage.MockStorage
+	remoteDir   string
+	interrupted bool
+	resumed     int
+}
+
+func (s *interruptedStorage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	if filepath.Base(remotePath) != MetadataFileName || s.interrupted {
+		return s.MockStorage.DownloadFile(ctx, remotePath, localPath)
+	}
+	s.interrupted = true
+	data, err := os.ReadFile(filepath.Join(s.remoteDir, remotePath))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(localPath, data[:len(data)/2], 0644); err != nil {
+		return err
+	}
+	return errors.New("connection reset by peer")
+}
+
+func (s *interruptedStorage) DownloadFileFrom(ctx context.Context, remotePath, localPath string, offset int64) error {
+	s.resumed++
+	return s.MockStorage.DownloadFileFrom(ctx, remotePath, localPath, offset)
+}
+
+// TestLoadMetadataResume 测试元数据下载中断后续传，并按校验和文件校验内容
+func TestLoadMetadataResume(t *testing.T) {
+	defer func(delay time.Duration) { jsonRetryDelay = delay }(jsonRetryDelay)
+	jsonRetryDelay = 0
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "full",
+		SplitFileTree: true,
+	}
+	if _, err := NewBackupManager(config, storage.NewMockStorage(remoteDir)).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, MetadataFileName+MetadataChecksumSuffix)); err != nil {
+		t.Fatalf("应上传元数据校验和文件: %v", err)
+	}
+
+	store := &interruptedStorage{MockStorage: storage.NewMockStorage(remoteDir), remoteDir: remoteDir}
+	manager := NewBackupManager(config, store)
+	metadata, err := manager.loadRemoteMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("加载元数据失败: %v", err)
+	}
+	if store.resumed != 1 || len(metadata.Checksums) != 2 || metadata.FileTreeSHA256 == "" {
+		t.Errorf("应续传一次并得到完整元数据，续传 %d 次，元数据 %+v", store.resumed, metadata)
+	}
+	if fileTree, err := manager.loadFileTree(context.Background(), metadata); err != nil || len(fileTree) != 4 {
+		t.Errorf("加载文件树失败: %d 个目录, %v", len(fileTree), err)
+	}
+
+	// 内容与校验和不一致时重试后返回校验错误
+	metadataPath := filepath.Join(remoteDir, MetadataFileName)
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if err := os.WriteFile(metadataPath, append(data, ' '), 0644); err != nil {
+		t.Fatalf("写入元数据失败: %v", err)
+	}
+	if _, err := manager.loadRemoteMetadata(context.Background()); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("元数据损坏时应返回校验错误，实际 %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(config.TempPath, "download-*")); len(leftovers) != 0 {
+		t.Errorf("加载后不应残留临时文件: %v", leftovers)
+	}
+}
+
+// writePBSChunk 按PBS未压缩数据块格式写入chunk，文件名为内容摘要；corrupt为true时写入后篡改内容
+func writePBSChunk(t *testing.T, dir string, content []byte, corrupt bool) string {
+	sum := sha256.Sum256(content)
+	name := hex.EncodeToString(sum[:])
+	blob := []byte{66, 171, 56, 7, 190, 131, 112, 161} // 未压缩数据块魔数
+	blob = binary.LittleEndian.AppendUint32(blob, crc32.ChecksumIEEE(content))
+	blob = append(blob, content...)
+	if corrupt {
+		blob[len(blob)-1] ^= 0xff
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), blob, 0644); err != nil {
+		t.Fatalf("写入chunk失败: %v", err)
+	}
+	return name
+}
+
+// TestPBSVerify 测试备份前抽样校验源chunk，发现损坏时报告或中止
+func TestPBSVerify(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+	writePBSChunk(t, filepath.Join(chunkDir, "0000"), []byte("good chunk"), false)
+	bad := writePBSChunk(t, filepath.Join(chunkDir, "0100"), []byte("bad chunk"), true)
+
+	config := &models.Config{
+		ChunkPath:       chunkDir,
+		RemotePath:      "/",
+		TempPath:        filepath.Join(testDir, "temp"),
+		PrefixDigits:    2,
+		Mode:            "full",
+		PBSVerify:       true,
+		PBSVerifySample: 1,
+		PBSVerifyAbort:  true,
+	}
+	_, err := NewBackupManager(config, storage.NewMockStorage(remoteDir)).RunFullBackup(context.Background())
+	var corruptErr *CorruptSourceError
+	if !errors.Is(err, ErrCorruptSource) || !errors.As(err, &corruptErr) || len(corruptErr.Chunks) != 1 || corruptErr.Chunks[0] != "0100/"+bad {
+		t.Fatalf("应因损坏的源chunk中止备份，实际 %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, MetadataFileName)); !os.IsNotExist(err) {
+		t.Errorf("中止时不应上传元数据: %v", err)
+	}
+
+	// 不中止时照常备份并报告损坏的chunk
+	config.PBSVerifyAbort = false
+	result, err := NewBackupManager(config, storage.NewMockStorage(remoteDir)).RunFullBackup(context.Background())
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if len(result.CorruptChunks) != 1 || result.UpdatedArchives != 2 {
+		t.Errorf("应报告1个损坏的chunk并备份全部压缩包: %+v", result)
+	}
+
+	// 增量备份只校验变化的目录
+	writePBSChunk(t, filepath.Join(chunkDir, "0000"), []byte("new chunk"), false)
+	config.Mode = "incremental"
+	result, err = NewBackupManager(config, storage.NewMockStorage(remoteDir)).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if len(result.CorruptChunks) != 0 {
+		t.Errorf("未变化的目录不应校验: %v", result.CorruptChunks)
+	}
+}
+
+// recordingStorage 记录上传的压缩包来自哪个本地目录
+type recordingStorage struct {
+	*storage.MockStorage
+	mu   sync.Mutex
+	dirs map[string]int
+}
+
+func (s *recordingStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	if strings.HasSuffix(localPath, ".tar.gz") {
+		s.mu.Lock()
+		s.dirs[filepath.Dir(localPath)]++
+		s.mu.Unlock()
+	}
+	return s.MockStorage.UploadFile(ctx, localPath, remotePath)
+}
+
+// atomicStorage 记录上传的远程路径，可以声明上传是原子的
+type atomicStorage struct {
+	*storage.MockStorage
+	atomic  bool
+	uploads []string
+}
+
+func (s *atomicStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	s.uploads = append(s.uploads, remotePath)
+	return s.MockStorage.UploadFile(ctx, localPath, remotePath)
+}
+
+func (s *atomicStorage) Capabilities() storage.Capabilities {
+	caps := s.MockStorage.Capabilities()
+	caps.AtomicUpload = s.atomic
+	return caps
+}
+
+// TestAtomicArchiveUpload 测试上传不是原子的后端先把压缩包上传到临时名称再移动，声明原子上传的后端直接上传
+func TestAtomicArchiveUpload(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		TarIndex:     true,
+	}
+	store := &atomicStorage{MockStorage: storage.NewMockStorage(remoteDir)}
+	if _, err := NewBackupManager(config, store).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	for _, name := range []string{
+		ChunkDirName + "/0000-00ff.tar.gz",
+		Sha256DirName + "/0000-00ff.tar.gz.sha256",
+		IndexDirName + "/" + archiver.IndexPath("0000-00ff.tar.gz"),
+	} {
+		if !slices.Contains(store.uploads, "/"+name+uploadingSuffix) {
+			t.Errorf("%s 应先上传到临时名称: %v", name, store.uploads)
+		}
+		if _, err := os.Stat(filepath.Join(remoteDir, name+uploadingSuffix)); !os.IsNotExist(err) {
+			t.Errorf("移动后不应留下临时文件: %s", name)
+		}
+		if _, err := os.Stat(filepath.Join(remoteDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s 应移动到最终位置: %v", name, err)
+		}
+	}
+
+	// 声明原子上传的后端不需要临时名称
+	store = &atomicStorage{MockStorage: storage.NewMockStorage(filepath.Join(testDir, "atomic")), atomic: true}
+	if _, err := NewBackupManager(config, store).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	for _, upload := range store.uploads {
+		if strings.HasSuffix(upload, uploadingSuffix) {
+			t.Errorf("原子上传的后端应直接上传: %s", upload)
+		}
+	}
+}
+
+// TestTempPaths 测试配置多个临时目录时压缩包轮流存放在各目录中
+func TestTempPaths(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	createInitialChunkData(t, chunkDir)
+
+	tempA, tempB := filepath.Join(testDir, "temp-a"), filepath.Join(testDir, "temp-b")
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     tempA,
+		TempPaths:    []string{tempA, tempB},
+		PrefixDigits: 3,
+		Mode:         "full",
+	}
+	store := &recordingStorage{MockStorage: storage.NewMockStorage(filepath.Join(testDir, "remote")), dirs: make(map[string]int)}
+	result, err := NewBackupManager(config, store).RunFullBackup(context.Background())
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 前缀位数3时4个目录分为0000-000f、00f0-00ff、0100-010f三组
+	if result.UpdatedArchives != 3 || store.dirs[tempA] != 2 || store.dirs[tempB] != 1 {
+		t.Errorf("压缩包应轮流存放在两个临时目录中: %v", store.dirs)
+	}
+	for _, dir := range []string{tempA, tempB} {
+		if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tar.gz")); len(leftovers) != 0 {
+			t.Errorf("上传后应删除临时压缩包: %v", leftovers)
+		}
+	}
+}
+
+// TestClone 测试复制备份到另一个远程路径并校验
+func TestClone(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/primary",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		KeepHistory:  true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	manager := NewBackupManager(config, mockStorage)
+	result, err := manager.RunClone(context.Background(), "/copy", false)
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	if result.VerifiedArchives != 2 || len(result.Mismatches) != 0 {
+		t.Errorf("复制后应校验通过2个压缩包: %+v", result)
+	}
+	last := result.CopiedObjects[len(result.CopiedObjects)-1]
+	if last != MetadataFileName+MetadataChecksumSuffix || result.CopiedObjects[len(result.CopiedObjects)-2] != MetadataFileName {
+		t.Errorf("元数据应最后复制: %v", result.CopiedObjects)
+	}
+	for _, path := range []string{"copy/" + ChunkDirName + "/0000-00ff.tar.gz", "copy/" + HistoryDirName, "copy/" + MetadataFileName} {
+		if _, err := os.Stat(filepath.Join(remoteDir, filepath.FromSlash(path))); err != nil {
+			t.Errorf("目标缺少 %s: %v", path, err)
+		}
+	}
+
+	// 目标已有备份时默认拒绝
+	if _, err := manager.RunClone(context.Background(), "/copy", false); err == nil {
+		t.Error("目标已有备份时应返回错误")
+	}
+	if _, err := manager.RunClone(context.Background(), "/primary", true); err == nil {
+		t.Error("目标与源相同时应返回错误")
+	}
+
+	// 校验和文件与元数据不一致时报告
+	checksumFile := filepath.Join(remoteDir, "primary", Sha256DirName, "0100-01ff.tar.gz.sha256")
+	badChecksum := strings.Repeat("0", 64)
+	if err := os.WriteFile(checksumFile, []byte(badChecksum+"  0100-01ff.tar.gz\n"), 0644); err != nil {
+		t.Fatalf("写入校验和文件失败: %v", err)
+	}
+	result, err = manager.RunClone(context.Background(), "/copy", true)
+	if err != nil {
+		t.Fatalf("覆盖复制失败: %v", err)
+	}
+	if len(result.Mismatches) != 1 || result.Mismatches[0].Archive != "0100-01ff.tar.gz" || result.Mismatches[0].Actual != badChecksum {
+		t.Errorf("应报告校验和不一致的压缩包: %+v", result.Mismatches)
+	}
+}
+
+// limitedStorage 不支持远程复制和移动的存储，用于测试能力缺失时的退化路径
+type limitedStorage struct {
+	*storage.MockStorage
+	uploads []string
+}
+
+func (l *limitedStorage) Capabilities() storage.Capabilities {
+	return storage.Capabilities{}
+}
+
+func (l *limitedStorage) CopyRemote(ctx context.Context, srcPath, dstPath string) error {
+	return storage.ErrNotSupported
+}
+
+func (l *limitedStorage) MoveRemote(ctx context.Context, srcPath, dstPath string) error {
+	return storage.ErrNotSupported
+}
+
+func (l *limitedStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	l.uploads = append(l.uploads, filepath.ToSlash(remotePath))
+	return l.MockStorage.UploadFile(ctx, localPath, remotePath)
+}
+
+// TestLocalBackend 测试使用本地文件系统后端备份和恢复，元数据直接上传而不经过临时名称
+func TestLocalBackend(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   remoteDir,
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	store := storage.NewLocalStorage()
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, store).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	for _, name := range []string{MetadataFileName, MetadataFileName + ".sha256", filepath.Join(ChunkDirName, "0000-00ff.tar.gz")} {
+		if _, err := os.Stat(filepath.Join(remoteDir, name)); err != nil {
+			t.Errorf("远程应该包含 %s: %v", name, err)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(remoteDir, "*.uploading")); len(matches) > 0 {
+		t.Errorf("原子上传的后端不应留下临时对象: %v", matches)
+	}
+
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	restoreConfig.Mode = "restore"
+	if _, err := NewBackupManager(&restoreConfig, store).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	originalTree, _ := NewBackupManager(config, store).scanner.ScanFileTree()
+	restoredTree, _ := NewBackupManager(&restoreConfig, store).scanner.ScanFileTree()
+	if changed := scanner.CompareFileTrees(originalTree, restoredTree); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+}
+
+// TestStorageCapabilities 测试后端缺少复制和移动能力时，元数据直接上传、复制经本地中转
+func TestStorageCapabilities(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/primary",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+
+	// 支持移动时元数据经临时名称移动到位，不留下临时文件
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "primary", MetadataFileName+uploadingSuffix)); !os.IsNotExist(err) {
+		t.Errorf("移动后不应留下临时元数据: %v", err)
+	}
+
+	limited := &limitedStorage{MockStorage: mockStorage}
+	manager := NewBackupManager(config, limited)
+	if _, err := manager.RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("不支持移动时全量备份失败: %v", err)
+	}
+	for _, upload := range limited.uploads {
+		if strings.HasSuffix(upload, uploadingSuffix) {
+			t.Errorf("不支持移动时应直接上传元数据: %s", upload)
+		}
+	}
+
+	result, err := manager.RunClone(context.Background(), "/copy", false)
+	if err != nil {
+		t.Fatalf("不支持远程复制时复制失败: %v", err)
+	}
+	if result.VerifiedArchives != 2 || len(result.Mismatches) != 0 {
+		t.Errorf("经本地中转复制后应校验通过2个压缩包: %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "copy", ChunkDirName, "0000-00ff.tar.gz")); err != nil {
+		t.Errorf("目录应递归复制: %v", err)
+	}
+}
+
+func TestChecksumFileDrift(t *testing.T) {
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	cases := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"标准格式", digest + "  0000-00ff.tar.gz\n", false},
+		{"BOM和CRLF", "\ufeff" + digest + "  0000-00ff.tar.gz\r\n", false},
+		{"大写和前导空白", "  \n" + strings.ToUpper(digest) + " *0000-00ff.tar.gz", false},
+		{"只有校验和", digest, false},
+		{"空文件", " \r\n", true},
+		{"长度错误", digest[:63] + "  0000-00ff.tar.gz\n", true},
+		{"非十六进制", "g" + digest[1:] + "  0000-00ff.tar.gz\n", true},
+	}
+	for _, tc := range cases {
+		got, err := parseChecksum([]byte(tc.content), "0000-00ff.tar.gz")
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: 应返回错误，实际 %q", tc.name, got)
+			}
+			continue
+		}
+		if err != nil || got != digest {
+			t.Errorf("%s: 期望 %s，实际 %q (%v)", tc.name, digest, got, err)
+		}
+	}
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	var metadata models.BackupMetadata
+	data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	checksum := metadata.Checksums["0000-00ff.tar.gz"]
+
+	// 模拟后端或编辑器改变了校验和文件的格式
+	drifted := filepath.Join(remoteDir, Sha256DirName, "0000-00ff.tar.gz.sha256")
+	driftedContent := "\ufeff" + strings.ToUpper(checksum) + "  0000-00ff.tar.gz\r\n"
+	if err := os.WriteFile(drifted, []byte(driftedContent), 0644); err != nil {
+		t.Fatalf("写入校验和文件失败: %v", err)
+	}
+
+	// 格式变化不影响校验和比较，压缩包照常跳过
+	config.Mode = "incremental"
+	config.Repair = true
+	result, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background())
+	if err != nil {
+		t.Fatalf("修复模式增量备份失败: %v", err)
+	}
+	if result.Details["0000-00ff.tar.gz"] != "unchanged, skipped" {
+		t.Errorf("格式不规范的校验和文件不应导致重新上传: %+v", result.Details)
+	}
+	if len(result.RewrittenChecksums) != 1 || result.RewrittenChecksums[0] != "0000-00ff.tar.gz" {
+		t.Errorf("应只改写格式不规范的校验和文件，实际 %v", result.RewrittenChecksums)
+	}
+	if data, _ := os.ReadFile(drifted); string(data) != checksum+"  0000-00ff.tar.gz\n" {
+		t.Errorf("校验和文件应改写为标准格式，实际 %q", data)
+	}
+}
+
+// TestChecksumBundle 测试所有压缩包的校验和写入一个checksums.txt，跳过判断、远程一致性检查和gc都读取汇总文件，
+// 改回逐个保存时补齐单独的校验和文件
+func TestChecksumBundle(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "full",
+		ChecksumFiles: ChecksumFilesBundle,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	loadMetadata := func() *models.BackupMetadata {
+		metadata, err := NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+		if err != nil {
+			t.Fatalf("加载元数据失败: %v", err)
+		}
+		return metadata
+	}
+	metadata := loadMetadata()
+	if metadata.ChecksumFiles != ChecksumFilesBundle {
+		t.Errorf("元数据应记录校验和文件的保存方式，实际 %q", metadata.ChecksumFiles)
+	}
+
+	bundlePath := filepath.Join(remoteDir, ChecksumBundleName)
+	bundle, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatalf("读取汇总校验和文件失败: %v", err)
+	}
+	expected := metadata.Checksums["0000-00ff.tar.gz"] + "  chunk/0000-00ff.tar.gz\n" +
+		metadata.Checksums["0100-01ff.tar.gz"] + "  chunk/0100-01ff.tar.gz\n"
+	if string(bundle) != expected {
+		t.Errorf("汇总校验和文件内容不正确: %q", bundle)
+	}
+	if sidecars, _ := os.ReadDir(filepath.Join(remoteDir, Sha256DirName)); len(sidecars) != 0 {
+		t.Errorf("只保存汇总文件时不应上传单独的校验和文件: %v", sidecars)
+	}
+	if sha256sum, err := exec.LookPath("sha256sum"); err == nil {
+		cmd := exec.Command(sha256sum, "-c", ChecksumBundleName)
+		cmd.Dir = remoteDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("sha256sum -c不接受汇总校验和文件: %v: %s", err, output)
+		}
+	}
+
+	// 没有单独的校验和文件，--verify-remote仍能从汇总文件判断所有压缩包无需上传
+	incConfig := *config
+	incConfig.Mode = "incremental"
+	incConfig.VerifyRemote = true
+	result, err := NewBackupManager(&incConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("--verify-remote增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 0 || result.SkippedArchives != 2 {
+		t.Errorf("所有压缩包都应按汇总文件跳过上传: %+v", result.Details)
+	}
+
+	// 汇总文件中的记录与元数据不一致时报告，gc按元数据改写
+	tampered := strings.Replace(expected, metadata.Checksums["0100-01ff.tar.gz"], strings.Repeat("0", 64), 1)
+	if err := os.WriteFile(bundlePath, []byte(tampered), 0644); err != nil {
+		t.Fatalf("改写汇总校验和文件失败: %v", err)
+	}
+	changes, err := NewBackupManager(&incConfig, mockStorage).ListChanged(ctx)
+	if err != nil {
+		t.Fatalf("列出变化失败: %v", err)
+	}
+	if len(changes.RemoteDrift) != 1 || changes.RemoteDrift[0].Archive != "0100-01ff.tar.gz" || changes.RemoteDrift[0].Problem != DriftChecksumMismatch {
+		t.Errorf("应报告汇总文件记录不一致: %+v", changes.RemoteDrift)
+	}
+	gcResult, err := NewBackupManager(config, mockStorage).RunGC(ctx, GCOptions{})
+	if err != nil {
+		t.Fatalf("gc失败: %v", err)
+	}
+	if !slices.Equal(gcResult.RewrittenChecksums, []string{ChecksumBundleName}) {
+		t.Errorf("gc应只改写汇总校验和文件: %v", gcResult.RewrittenChecksums)
+	}
+	if data, _ := os.ReadFile(bundlePath); string(data) != expected {
+		t.Errorf("汇总校验和文件应按元数据改写，实际 %q", data)
+	}
+
+	// 改回逐个保存：补齐所有压缩包的校验和文件，删除不再维护的汇总文件
+	sidecarConfig := *config
+	sidecarConfig.Mode = "incremental"
+	sidecarConfig.ChecksumFiles = ChecksumFilesSidecar
+	if _, err := NewBackupManager(&sidecarConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	for _, name := range []string{"0000-00ff.tar.gz", "0100-01ff.tar.gz"} {
+		data, err := os.ReadFile(filepath.Join(remoteDir, Sha256DirName, name+".sha256"))
+		if err != nil || string(data) != metadata.Checksums[name]+"  "+name+"\n" {
+			t.Errorf("应补齐 %s 的校验和文件: %q (%v)", name, data, err)
+		}
+	}
+	if _, err := os.Stat(bundlePath); !os.IsNotExist(err) {
+		t.Errorf("改回逐个保存后应删除汇总校验和文件: %v", err)
+	}
+	if metadata := loadMetadata(); metadata.ChecksumFiles != "" {
+		t.Errorf("逐个保存时元数据不应记录汇总文件，实际 %q", metadata.ChecksumFiles)
+	}
+}
+
+func TestKeepEmptyDirs(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	// 空的chunk目录和空的子目录，使用固定的旧修改时间
+	oldTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, dir := range []string{"0000/empty", "0002", "0100/empty"} {
+		path := filepath.Join(chunkDir, filepath.FromSlash(dir))
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatalf("创建空目录失败: %v", err)
+		}
+		if err := os.Chtimes(path, oldTime, oldTime); err != nil {
+			t.Fatalf("设置修改时间失败: %v", err)
+		}
+	}
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "full",
+		TarIndex:      true,
+		SplitFileTree: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 模拟其他工具生成的、不含目录条目的压缩包
+	archivePath := filepath.Join(remoteDir, ChunkDirName, "0100-01ff.tar.gz")
+	checksum := rewriteWithoutDirEntries(t, archivePath)
+	metadataPath := filepath.Join(remoteDir, MetadataFileName)
+	var metadata models.BackupMetadata
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	metadata.Checksums["0100-01ff.tar.gz"] = checksum
+	if data, err = json.Marshal(&metadata); err != nil {
+		t.Fatalf("序列化元数据失败: %v", err)
+	}
+	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
+		t.Fatalf("写入元数据失败: %v", err)
+	}
+	os.Remove(metadataPath + MetadataChecksumSuffix)
+
+	// 全量恢复后按文件树补齐缺少的空目录，目录修改时间与原始一致
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	restoreConfig.KeepEmptyDirs = true
+	result, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "")
+	if err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if result.CreatedDirs != 1 {
+		t.Errorf("应创建压缩包中缺少的1个空目录，实际 %d", result.CreatedDirs)
+	}
+
+	originalTree, err := scanner.NewChunkScanner(chunkDir).ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := scanner.NewChunkScanner(restoreConfig.ChunkPath).ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	for _, mode := range []scanner.CompareMode{scanner.CompareMtimeSize, scanner.CompareSizeOnly, scanner.CompareHash} {
+		if changed := scanner.CompareFileTreesWithMode(originalTree, restoredTree, mode); len(changed) != 0 {
+			t.Errorf("%s: 恢复后的文件树与原始文件树不一致: %v", mode, changed)
+		}
+	}
+
+	// 通过tar索引单独恢复空的chunk目录时同样还原修改时间
+	singleConfig := restoreConfig
+	singleConfig.ChunkPath = filepath.Join(testDir, "restore-single")
+	if _, err := NewBackupManager(&singleConfig, mockStorage).RunRestore(ctx, "0002"); err != nil {
+		t.Fatalf("恢复空目录失败: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(singleConfig.ChunkPath, "0002"))
+	if err != nil || !info.IsDir() || !info.ModTime().Equal(oldTime) {
+		t.Errorf("应恢复空目录0002及其修改时间: %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(singleConfig.ChunkPath, "0000")); !os.IsNotExist(err) {
+		t.Errorf("单独恢复时不应创建其他目录: %v", err)
+	}
+}
+
+// rewriteWithoutDirEntries 去掉压缩包中的目录条目，返回新压缩包的SHA256
+func rewriteWithoutDirEntries(t *testing.T, archivePath string) string {
+	t.Helper()
+	in, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("打开压缩包失败: %v", err)
+	}
+	defer in.Close()
+	gzipReader, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatalf("读取gzip失败: %v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("读取tar条目失败: %v", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatalf("写入tar头失败: %v", err)
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			t.Fatalf("写入tar内容失败: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("关闭tar失败: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("关闭gzip失败: %v", err)
+	}
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("写入压缩包失败: %v", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUncompressedBackup(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:       chunkDir,
+		RemotePath:      "/",
+		TempPath:        filepath.Join(testDir, "temp"),
+		PrefixDigits:    2,
+		Mode:            "full",
+		Compression:     archiver.CompressionNone,
+		RestoreManifest: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	var metadata models.BackupMetadata
+	var manifest models.RestoreManifest
+	for name, target := range map[string]interface{}{MetadataFileName: &metadata, RestoreManifestFileName: &manifest} {
+		data, err := os.ReadFile(filepath.Join(remoteDir, name))
+		if err != nil {
+			t.Fatalf("读取 %s 失败: %v", name, err)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			t.Fatalf("解析 %s 失败: %v", name, err)
+		}
+	}
+	if metadata.ArchiveFormat != archiver.CompressionNone || metadata.Compression["0000-00ff.tar"] != archiver.CompressionNone {
+		t.Errorf("元数据应记录不压缩的格式: %q %v", metadata.ArchiveFormat, metadata.Compression)
+	}
+	if _, exists := metadata.Checksums["0100-01ff.tar"]; !exists {
+		t.Errorf("压缩包应使用.tar扩展名: %v", metadata.Checksums)
+	}
+	if command := manifest.Archives[0].Commands[2]; !strings.Contains(command, "tar -xf ") {
+		t.Errorf("恢复清单不应使用gzip解压: %s", command)
+	}
+
+	// 增量备份沿用全量备份的格式，即使本次未指定
+	if err := os.WriteFile(filepath.Join(chunkDir, "0100", "new.dat"), []byte("new data"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	incrementalConfig := *config
+	incrementalConfig.Mode = "incremental"
+	incrementalConfig.Compression = ""
+	result, err := NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 1 || result.Details["0100-01ff.tar"] == "" {
+		t.Errorf("增量备份应更新0100-01ff.tar: %+v", result.Details)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, ChunkDirName, "0100-01ff.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("增量备份不应生成gzip压缩包: %v", err)
+	}
+
+	// 校验和恢复都能处理不压缩的tar包
+	verifyResult, err := NewBackupManager(&incrementalConfig, mockStorage).RunVerify(ctx, 2, MismatchReport)
+	if err != nil || verifyResult.VerifiedArchives != 2 || len(verifyResult.Mismatches) != 0 {
+		t.Errorf("校验失败: %+v (%v)", verifyResult, err)
+	}
+	restoreConfig := incrementalConfig
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "0100/new.dat"); err != nil {
+		t.Fatalf("单独恢复失败: %v", err)
+	}
+	originalTree, err := scanner.NewChunkScanner(chunkDir).ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := scanner.NewChunkScanner(restoreConfig.ChunkPath).ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	if changed := scanner.CompareFileTreesWithMode(originalTree, restoredTree, scanner.CompareSizeOnly); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+}
+
+func TestAutoCompression(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+
+	// 0000目录是容易压缩的文本，0100目录是随机数据（相当于已压缩或加密的chunk）
+	text := []byte(strings.Repeat("proxmox backup server chunk store\n", 4096))
+	random := make([]byte, 128*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("生成随机数据失败: %v", err)
+	}
+	for dir, content := range map[string][]byte{"0000": text, "0100": random} {
+		if err := os.MkdirAll(filepath.Join(chunkDir, dir), 0755); err != nil {
+			t.Fatalf("创建目录失败: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(chunkDir, dir, "chunk.dat"), content, 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+
+	config := &models.Config{
+		ChunkPath:       chunkDir,
+		RemotePath:      "/",
+		TempPath:        filepath.Join(testDir, "temp"),
+		PrefixDigits:    2,
+		Mode:            "full",
+		Compression:     archiver.CompressionAuto,
+		RestoreManifest: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	readMetadata := func() models.BackupMetadata {
+		var metadata models.BackupMetadata
+		data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+		if err != nil {
+			t.Fatalf("读取元数据失败: %v", err)
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("解析元数据失败: %v", err)
+		}
+		return metadata
+	}
+	metadata := readMetadata()
+	if metadata.ArchiveFormat != archiver.CompressionAuto {
+		t.Errorf("元数据应记录auto格式: %q", metadata.ArchiveFormat)
+	}
+	if metadata.Compression["0000-00ff.tar.gz"] != archiver.CompressionZstd || metadata.Compression["0100-01ff.tar.gz"] != archiver.CompressionNone {
+		t.Errorf("文本应使用zstd，随机数据应不压缩: %v", metadata.Compression)
+	}
+	for _, object := range []string{"0000-00ff.tar.zst", "0100-01ff.tar"} {
+		if _, err := os.Stat(filepath.Join(remoteDir, ChunkDirName, object)); err != nil {
+			t.Errorf("远程对象应使用实际格式的扩展名 %s: %v", object, err)
+		}
+	}
+
+	// 内容变为随机数据后重新选择格式，旧的.tar.zst对象被删除
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "chunk.dat"), random, 0644); err != nil {
+		t.Fatalf("修改文件失败: %v", err)
+	}
+	incrementalConfig := *config
+	incrementalConfig.Mode = "incremental"
+	incrementalConfig.Compression = ""
+	if _, err := NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	metadata = readMetadata()
+	if metadata.Compression["0000-00ff.tar.gz"] != archiver.CompressionNone {
+		t.Errorf("增量备份应沿用auto并重新选择格式: %v", metadata.Compression)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, ChunkDirName, "0000-00ff.tar.zst")); !os.IsNotExist(err) {
+		t.Errorf("被替换的对象应删除: %v", err)
+	}
+
+	verifyResult, err := NewBackupManager(&incrementalConfig, mockStorage).RunVerify(ctx, 2, MismatchReport)
+	if err != nil || verifyResult.VerifiedArchives != 2 || len(verifyResult.Mismatches) != 0 {
+		t.Errorf("校验失败: %+v (%v)", verifyResult, err)
+	}
+	restoreConfig := incrementalConfig
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	restored, err := os.ReadFile(filepath.Join(restoreConfig.ChunkPath, "0100", "chunk.dat"))
+	if err != nil || !bytes.Equal(restored, random) {
+		t.Errorf("恢复的文件内容不一致: %v", err)
+	}
+}
+
+func TestParanoid(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	// 去重的文件不在压缩包中，自检时不应视为缺失
+	duplicate := []byte("identical chunk content shared by two groups")
+	for _, name := range []string{"0000/dup.dat", "0100/subdir/dup.dat"} {
+		if err := os.WriteFile(filepath.Join(chunkDir, filepath.FromSlash(name)), duplicate, 0644); err != nil {
+			t.Fatalf("创建重复文件失败: %v", err)
+		}
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		Dedupe:       true,
+		TarIndex:     true,
+		Paranoid:     true,
+	}
+	result, err := NewBackupManager(config, storage.NewMockStorage(remoteDir)).RunFullBackup(context.Background())
+	if err != nil {
+		t.Fatalf("自检模式全量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 2 || len(result.ErrorArchives) != 0 {
+		t.Errorf("所有压缩包都应通过自检并上传: %+v", result.Details)
+	}
+}
+
+// TestSignArchives 测试GPG签名后处理：签名与校验和文件一起上传并记录在元数据中，增量备份沿用，verify校验签名
+func TestSignArchives(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("模拟gpg脚本需要sh")
+	}
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	// 模拟gpg：签名内容为压缩包的SHA256，校验时重新计算并比较
+	fakeGPG := filepath.Join(testDir, "gpg")
+	script := `#!/bin/sh
+case "$3" in
+  --local-user) [ "$4" = "backup@example.com" ] || exit 2; sha256sum < "$8" > "$6" ;;
+  --verify) sha256sum < "$5" | cmp -s - "$4" || { echo "BAD signature" >&2; exit 1; } ;;
+  *) exit 2 ;;
+esac
+`
+	if err := os.WriteFile(fakeGPG, []byte(script), 0755); err != nil {
+		t.Fatalf("创建模拟gpg失败: %v", err)
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		SignKey:      "backup@example.com",
+		GPGBinary:    fakeGPG,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("签名全量备份失败: %v", err)
+	}
+	for _, archive := range []string{"0000-00ff.tar.gz", "0100-01ff.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(remoteDir, Sha256DirName, archive+".sig")); err != nil {
+			t.Errorf("缺少签名文件 %s: %v", archive, err)
+		}
+	}
+
+	// 增量备份只重新签名变化的压缩包，其余压缩包的签名记录沿用
+	if err := os.WriteFile(filepath.Join(chunkDir, "0100", "file0.dat"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("修改文件失败: %v", err)
+	}
+	config.Mode = "incremental"
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(context.Background()); err != nil {
+		t.Fatalf("签名增量备份失败: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+	if err != nil {
+		t.Fatalf("读取元数据失败: %v", err)
+	}
+	var metadata models.BackupMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("解析元数据失败: %v", err)
+	}
+	for _, archive := range []string{"0000-00ff.tar.gz", "0100-01ff.tar.gz"} {
+		sidecars := metadata.Sidecars[archive]
+		if len(sidecars) != 1 || sidecars[0].Processor != GPGProcessorName || sidecars[0].Name != archive+".sig" {
+			t.Errorf("元数据中 %s 的签名记录不正确: %+v", archive, sidecars)
+		}
+	}
+
+	// 校验不需要签名密钥
+	verifyConfig := *config
+	verifyConfig.SignKey = ""
+	result, err := NewBackupManager(&verifyConfig, mockStorage).RunVerify(context.Background(), 1, MismatchReport)
+	if err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if len(result.Mismatches) != 0 {
+		t.Errorf("签名应校验通过: %+v", result.Mismatches)
+	}
+
+	if err := os.WriteFile(filepath.Join(remoteDir, Sha256DirName, "0000-00ff.tar.gz.sig"), []byte("forged\n"), 0644); err != nil {
+		t.Fatalf("篡改签名失败: %v", err)
+	}
+	result, err = NewBackupManager(&verifyConfig, mockStorage).RunVerify(context.Background(), 1, MismatchReport)
+	if err != nil {
+		t.Fatalf("校验失败: %v", err)
+	}
+	if len(result.Mismatches) != 1 || result.Mismatches[0].Archive != "0000-00ff.tar.gz" || !strings.Contains(result.Mismatches[0].Error, "BAD signature") {
+		t.Errorf("应报告签名不匹配的压缩包: %+v", result.Mismatches)
+	}
+}
+
+// TestRegroup 测试按新的前缀位数拆分、合并压缩包，重新分组后备份可以校验和恢复
+func TestRegroup(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		TarIndex:     true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	if _, err := NewBackupManager(config, mockStorage).RunRegroup(ctx, 2); err == nil {
+		t.Error("前缀位数不变时应该返回错误")
+	}
+
+	// 2位拆分为3位：0000-00ff拆分后重新打包，只含0100的压缩包远程复制改名
+	result, err := NewBackupManager(config, mockStorage).RunRegroup(ctx, 3)
+	if err != nil {
+		t.Fatalf("拆分失败: %v", err)
+	}
+	if len(result.Rebuilt) != 2 || len(result.Copied) != 1 || len(result.Deleted) != 2 {
+		t.Errorf("拆分结果不符合预期: %+v", result)
+	}
+
+	// 3位合并为2位：0000-000f和00f0-00ff合并重新打包，0100-010f复制改名
+	result, err = NewBackupManager(config, mockStorage).RunRegroup(ctx, 2)
+	if err != nil {
+		t.Fatalf("合并失败: %v", err)
+	}
+	if len(result.Rebuilt) != 1 || len(result.Copied) != 1 || len(result.Deleted) != 3 {
+		t.Errorf("合并结果不符合预期: %+v", result)
+	}
+
+	metadata, err := NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("加载元数据失败: %v", err)
+	}
+	if metadata.PrefixDigits != 2 || len(metadata.Checksums) != 2 {
+		t.Errorf("元数据应记录2位前缀和2个压缩包: %d %v", metadata.PrefixDigits, metadata.Checksums)
+	}
+	files, err := os.ReadDir(filepath.Join(remoteDir, ChunkDirName))
+	if err != nil {
+		t.Fatalf("列出远程压缩包失败: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("旧压缩包应已删除，实际剩余 %d 个文件", len(files))
+	}
+
+	verifyResult, err := NewBackupManager(config, mockStorage).RunVerify(ctx, DefaultVerifyConcurrency, MismatchReport)
+	if err != nil || len(verifyResult.Mismatches) != 0 {
+		t.Fatalf("重新分组后校验失败: %v %+v", err, verifyResult)
+	}
+
+	// 复制改名的压缩包的索引应指向新名称，可以恢复单个文件
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	restoreConfig.Mode = "restore"
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, "0100/subdir/subfile.dat"); err != nil {
+		t.Fatalf("单文件恢复失败: %v", err)
+	}
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("全量恢复失败: %v", err)
+	}
+	originalTree, err := NewBackupManager(config, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := NewBackupManager(&restoreConfig, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	if changed := scanner.CompareFileTrees(originalTree, restoredTree); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+}
+
+// TestStatusFile 测试备份期间写入本地和远程状态文件，结束时记录最终状态
+func TestStatusFile(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	tempDir := filepath.Join(testDir, "temp")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     tempDir,
+		PrefixDigits: 2,
+		Mode:         "full",
+		StatusFile:   true,
+		StatusRemote: true,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	result, err := NewBackupManager(config, mockStorage).RunFullBackup(context.Background())
+	if err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	for _, path := range []string{filepath.Join(tempDir, StatusFileName), filepath.Join(remoteDir, StatusFileName)} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("读取状态文件失败: %v", err)
+		}
+		var status models.RunStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatalf("解析状态文件失败: %v", err)
+		}
+		if status.Phase != PhaseDone || status.RunID != result.RunID {
+			t.Errorf("%s 应记录完成状态和运行标识: %+v", path, status)
+		}
+		if status.GroupsCompleted != 2 || status.GroupsTotal != 2 || status.BytesUploaded <= 0 || status.ETASeconds != 0 {
+			t.Errorf("%s 进度不符合预期: %+v", path, status)
+		}
+	}
+
+	// 失败时记录错误，未启用时不写状态文件
+	failConfig := *config
+	failConfig.Mode = "incremental"
+	failConfig.RemotePath = "/missing"
+	if _, err := NewBackupManager(&failConfig, mockStorage).RunIncrementalBackup(context.Background()); err == nil {
+		t.Fatal("远程没有元数据时增量备份应该失败")
+	}
+	data, err := os.ReadFile(filepath.Join(tempDir, StatusFileName))
+	if err != nil {
+		t.Fatalf("读取状态文件失败: %v", err)
+	}
+	var status models.RunStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatalf("解析状态文件失败: %v", err)
+	}
+	if status.Phase != PhaseFailed || status.Error == "" {
+		t.Errorf("失败时应记录失败状态和错误: %+v", status)
+	}
+
+	disabledConfig := *config
+	disabledConfig.StatusFile = false
+	disabledConfig.TempPath = filepath.Join(testDir, "temp-disabled")
+	if _, err := NewBackupManager(&disabledConfig, mockStorage).RunFullBackup(context.Background()); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(disabledConfig.TempPath, StatusFileName)); !os.IsNotExist(err) {
+		t.Errorf("未启用时不应写入状态文件: %v", err)
+	}
+}
+
+// TestTypeChange 测试路径在两次备份之间由目录变为文件、由文件变为目录后，
+// 增量备份能发现变化，恢复到已有旧版本的目录时删除旧类型的条目
+func TestTypeChange(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	toDir := filepath.Join(chunkDir, "0000", "to-dir")
+	toFile := filepath.Join(chunkDir, "0000", "to-file")
+	if err := os.WriteFile(toDir, []byte("file before"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(toFile, "nested"), 0755); err != nil {
+		t.Fatalf("创建目录失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(toFile, "nested", "child.dat"), []byte("child"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	restoreConfig.Mode = "restore"
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复第一次备份失败: %v", err)
+	}
+
+	// 交换两个路径的类型
+	if err := os.Remove(toDir); err != nil {
+		t.Fatalf("删除文件失败: %v", err)
+	}
+	if err := os.MkdirAll(toDir, 0755); err != nil {
+		t.Fatalf("创建目录失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(toDir, "inner.dat"), []byte("dir after"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	if err := os.RemoveAll(toFile); err != nil {
+		t.Fatalf("删除目录失败: %v", err)
+	}
+	if err := os.WriteFile(toFile, []byte("file after"), 0644); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+
+	incConfig := *config
+	incConfig.Mode = "incremental"
+	result, err := NewBackupManager(&incConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.Details["0000-00ff.tar.gz"] != "created and uploaded" {
+		t.Errorf("类型变化的压缩包应重新上传: %v", result.Details)
+	}
+
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复到已有目录失败: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(restoreConfig.ChunkPath, "0000", "to-file"))
+	if err != nil || string(data) != "file after" {
+		t.Errorf("目录应被替换为文件: %q %v", data, err)
+	}
+	data, err = os.ReadFile(filepath.Join(restoreConfig.ChunkPath, "0000", "to-dir", "inner.dat"))
+	if err != nil || string(data) != "dir after" {
+		t.Errorf("文件应被替换为目录: %q %v", data, err)
+	}
+
+	originalTree, err := NewBackupManager(config, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描原始目录失败: %v", err)
+	}
+	restoredTree, err := NewBackupManager(&restoreConfig, mockStorage).scanner.ScanFileTree()
+	if err != nil {
+		t.Fatalf("扫描恢复目录失败: %v", err)
+	}
+	if changed := scanner.CompareFileTrees(originalTree, restoredTree); len(changed) != 0 {
+		t.Errorf("恢复后的文件树与原始文件树不一致: %v", changed)
+	}
+}
+
+// TestAdaptiveLimiter 测试吞吐量提高时增加并发、不再提高时回退并保持、传输出错时减半
+func TestAdaptiveLimiter(t *testing.T) {
+	clock := time.Unix(0, 0)
+	limiter := newAdaptiveLimiter(1, 4)
+	limiter.now = func() time.Time { return clock }
+	limiter.resetWindow(0)
+
+	// runWindow 以当前并发完成一个窗口，每个任务1MB，窗口耗时elapsed
+	runWindow := func(elapsed time.Duration) {
+		n := limiter.currentLimit()
+		for i := 0; i < n; i++ {
+			limiter.acquire()
+		}
+		clock = clock.Add(elapsed)
+		for i := 0; i < n; i++ {
+			limiter.release(1<<20, false)
+		}
+	}
+
+	runWindow(time.Second) // 1MB/s
+	if got := limiter.currentLimit(); got != 2 {
+		t.Fatalf("吞吐量提高后并发应为2，实际 %d", got)
+	}
+	runWindow(time.Second) // 2MB/s
+	if got := limiter.currentLimit(); got != 3 {
+		t.Fatalf("吞吐量提高后并发应为3，实际 %d", got)
+	}
+	runWindow(1500 * time.Millisecond) // 2MB/s，不再提高
+	if got := limiter.currentLimit(); got != 2 {
+		t.Fatalf("吞吐量不再提高时应回退到2，实际 %d", got)
+	}
+	runWindow(100 * time.Millisecond)
+	if got := limiter.currentLimit(); got != 2 {
+		t.Fatalf("稳定后不应再增加并发，实际 %d", got)
+	}
+
+	limiter.acquire()
+	limiter.release(0, true)
+	if got := limiter.currentLimit(); got != 1 {
+		t.Errorf("传输出错时并发应减半为1，实际 %d", got)
+	}
+}
+
+// TestVerifyRemote 测试--verify-remote按远程校验和文件而不是文件树决定上传，元数据丢失后仍可运行
+func TestVerifyRemote(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 改写内容但保持大小和修改时间，文件树比较发现不了
+	path := filepath.Join(chunkDir, "0100", "subdir", "subfile.dat")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("读取文件信息失败: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("CHUNK 0100 SUB CONTENT"), 0644); err != nil {
+		t.Fatalf("改写文件失败: %v", err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("恢复修改时间失败: %v", err)
+	}
+
+	incConfig := *config
+	incConfig.Mode = "incremental"
+	result, err := NewBackupManager(&incConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 0 {
+		t.Fatalf("文件树比较不应发现变化，实际更新 %d 个", result.UpdatedArchives)
+	}
+
+	verifyConfig := incConfig
+	verifyConfig.VerifyRemote = true
+	result, err = NewBackupManager(&verifyConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("--verify-remote增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 1 || result.Details["0100-01ff.tar.gz"] != "created and uploaded" {
+		t.Errorf("只有内容变化的压缩包应上传: %+v", result.Details)
+	}
+	if result.Details["0000-00ff.tar.gz"] != "checksum unchanged, skipped" {
+		t.Errorf("未变化的压缩包应跳过上传: %+v", result.Details)
+	}
+
+	// 元数据丢失后按命令行的前缀位数分组，全部压缩包与远程一致，重新生成元数据
+	if err := os.Remove(filepath.Join(remoteDir, MetadataFileName)); err != nil {
+		t.Fatalf("删除元数据失败: %v", err)
+	}
+	result, err = NewBackupManager(&verifyConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("元数据丢失后--verify-remote失败: %v", err)
+	}
+	if result.UpdatedArchives != 0 || result.SkippedArchives != 2 {
+		t.Errorf("元数据丢失后所有压缩包应跳过上传: %+v", result)
+	}
+	verifyResult, err := NewBackupManager(config, mockStorage).RunVerify(ctx, 2, MismatchReport)
+	if err != nil || verifyResult.VerifiedArchives != 2 || len(verifyResult.Mismatches) != 0 {
+		t.Errorf("重新生成的元数据应能校验通过: %v %+v", err, verifyResult)
+	}
+}
+
+// slowChecksumStorage 读取压缩包的校验和文件时延迟返回，并记录同时进行的最大读取数
+type slowChecksumStorage struct {
+	*storage.MockStorage
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (s *slowChecksumStorage) GetFileContent(ctx context.Context, remotePath string) ([]byte, error) {
+	if filepath.Base(filepath.Dir(remotePath)) != Sha256DirName {
+		return s.MockStorage.GetFileContent(ctx, remotePath)
+	}
+	s.mu.Lock()
+	s.active++
+	s.maxSeen = max(s.maxSeen, s.active)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	return s.MockStorage.GetFileContent(ctx, remotePath)
+}
+
+// TestDecisionConcurrency 测试判断压缩包是否需要上传时并发读取远程校验和文件，且不超过设定的并发数
+func TestDecisionConcurrency(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 4,
+		Mode:         "full",
+	}
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, storage.NewMockStorage(remoteDir)).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	for _, workers := range []int{1, 3} {
+		slow := &slowChecksumStorage{MockStorage: storage.NewMockStorage(remoteDir)}
+		verifyConfig := *config
+		verifyConfig.Mode = "incremental"
+		verifyConfig.VerifyRemote = true
+		verifyConfig.DecisionWorkers = workers
+		result, err := NewBackupManager(&verifyConfig, slow).RunIncrementalBackup(ctx)
+		if err != nil {
+			t.Fatalf("--verify-remote增量备份失败: %v", err)
+		}
+		if result.UpdatedArchives != 0 || result.SkippedArchives != 4 {
+			t.Errorf("所有压缩包都应跳过上传: %+v", result.Details)
+		}
+		if workers == 1 && slow.maxSeen != 1 || workers > 1 && (slow.maxSeen < 2 || slow.maxSeen > workers) {
+			t.Errorf("并发数为%d时同时读取的校验和文件数应在范围内，实际 %d", workers, slow.maxSeen)
+		}
+
+		// --list-changed --verify-remote检查远程对象时同样并发
+		slow.maxSeen = 0
+		verifyConfig.VerifyRemote = false
+		if _, err := NewBackupManager(&verifyConfig, slow).RunIncrementalBackup(ctx); err != nil {
+			t.Fatalf("增量备份失败: %v", err)
+		}
+		verifyConfig.VerifyRemote = true
+		changes, err := NewBackupManager(&verifyConfig, slow).ListChanged(ctx)
+		if err != nil {
+			t.Fatalf("列出变化失败: %v", err)
+		}
+		if len(changes.RemoteDrift) != 0 || slow.maxSeen > workers {
+			t.Errorf("远程对象应与元数据一致且并发不超过%d: %+v, %d", workers, changes.RemoteDrift, slow.maxSeen)
+		}
+	}
+}
+
+// TestExcludeFilePattern 测试匹配排除模式的文件不写入压缩包，内容变化也不触发增量备份
+func TestExcludeFilePattern(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	tmpFile := filepath.Join(chunkDir, "0000", "0000abcd.tmp")
+	badFile := filepath.Join(chunkDir, "0100", "subdir", "0100abcd.0.bad")
+	cacheFile := filepath.Join(chunkDir, "0001", "cache", "state.dat")
+	os.MkdirAll(filepath.Dir(cacheFile), 0755)
+	for _, path := range []string{tmpFile, badFile, cacheFile} {
+		if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatalf("创建临时文件失败: %v", err)
+		}
+	}
+
+	// 默认模式之外，从排除文件读取目录模式
+	excludeFile := filepath.Join(testDir, "exclude.txt")
+	if err := os.WriteFile(excludeFile, []byte("# 缓存目录\n\ncache/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filePatterns, err := scanner.ReadExcludeFile(excludeFile)
+	if err != nil {
+		t.Fatalf("读取排除文件失败: %v", err)
+	}
+
+	config := &models.Config{
+		ChunkPath:       chunkDir,
+		RemotePath:      "/",
+		TempPath:        filepath.Join(testDir, "temp"),
+		PrefixDigits:    2,
+		Mode:            "full",
+		Paranoid:        true,
+		ExcludePatterns: append(append([]string{}, scanner.DefaultExcludePatterns...), filePatterns...),
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	// 临时文件和排除目录中的文件继续写入（目录本身不变），增量备份不应重建压缩包
+	for _, path := range []string{tmpFile, cacheFile} {
+		if err := os.WriteFile(path, []byte("partial, still writing"), 0644); err != nil {
+			t.Fatalf("改写临时文件失败: %v", err)
+		}
+	}
+	incConfig := *config
+	incConfig.Mode = "incremental"
+	result, err := NewBackupManager(&incConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 0 {
+		t.Errorf("排除的文件变化不应触发更新: %+v", result.Details)
+	}
+
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	restoreConfig.Mode = "restore"
+	if _, err := NewBackupManager(&restoreConfig, mockStorage).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	for _, name := range []string{"0000/0000abcd.tmp", "0100/subdir/0100abcd.0.bad", "0001/cache"} {
+		if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s 不应写入压缩包: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(restoreConfig.ChunkPath, "0100", "subdir", "subfile.dat")); err != nil {
+		t.Errorf("其他文件应正常恢复: %v", err)
+	}
+}
+
+// unreachableStorage 查询远程文件时失败，模拟远程暂时不可用
+type unreachableStorage struct {
+	*storage.MockStorage
+}
+
+func (s *unreachableStorage) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+// TestRemoteExcludes 测试从远程读取排除模式文件，远程不可用时使用本地副本
+func TestRemoteExcludes(t *testing.T) {
+	testDir := t.TempDir()
+	remoteDir := filepath.Join(testDir, "remote")
+	config := &models.Config{RemotePath: "/", TempPath: filepath.Join(testDir, "temp")}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	// 远程没有排除模式文件时不影响备份
+	patterns, err := FetchRemoteExcludes(ctx, mockStorage, config)
+	if err != nil || len(patterns) != 0 {
+		t.Fatalf("远程不存在排除模式文件时应返回空: %v %v", patterns, err)
+	}
+
+	if err := os.MkdirAll(remoteDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	remoteFile := filepath.Join(remoteDir, RemoteExcludeFileName)
+	if err := os.WriteFile(remoteFile, []byte("# 集中管理的排除模式\n*.partial\nlost+found/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err = FetchRemoteExcludes(ctx, mockStorage, config)
+	if err != nil || strings.Join(patterns, ",") != "*.partial,lost+found/" {
+		t.Fatalf("读取远程排除模式不正确: %v %v", patterns, err)
+	}
+
+	// 远程不可用时使用上次保存的副本
+	patterns, err = FetchRemoteExcludes(ctx, &unreachableStorage{mockStorage}, config)
+	if err != nil || strings.Join(patterns, ",") != "*.partial,lost+found/" {
+		t.Errorf("远程不可用时应使用本地副本: %v %v", patterns, err)
+	}
+
+	// 语法错误的模式使备份失败
+	if err := os.WriteFile(remoteFile, []byte("[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchRemoteExcludes(ctx, mockStorage, config); err == nil {
+		t.Error("排除模式语法错误时应返回错误")
+	}
+
+	// 远程删除文件后本地副本也删除，之后远程不可用时不再使用旧的策略
+	if err := os.Remove(remoteFile); err != nil {
+		t.Fatal(err)
+	}
+	if patterns, err := FetchRemoteExcludes(ctx, mockStorage, config); err != nil || len(patterns) != 0 {
+		t.Errorf("远程删除排除模式文件后应返回空: %v %v", patterns, err)
+	}
+	if patterns, err := FetchRemoteExcludes(ctx, &unreachableStorage{mockStorage}, config); err != nil || len(patterns) != 0 {
+		t.Errorf("没有本地副本时远程不可用应返回空: %v %v", patterns, err)
+	}
+}
+
+// TestMetadataRemote 测试元数据保存到单独的远程目录，增量备份、校验和复制从该目录读取元数据
+func TestMetadataRemote(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:      chunkDir,
+		RemotePath:     "/archives",
+		MetadataRemote: "/meta",
+		TempPath:       filepath.Join(testDir, "temp"),
+		PrefixDigits:   2,
+		Mode:           "full",
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+	if err := mockStorage.MkdirRemote(ctx, "/meta"); err != nil {
+		t.Fatalf("创建元数据目录失败: %v", err)
+	}
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+
+	for _, name := range []string{MetadataFileName, MetadataFileName + MetadataChecksumSuffix} {
+		if _, err := os.Stat(filepath.Join(remoteDir, "meta", name)); err != nil {
+			t.Errorf("%s 应保存在元数据目录: %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(remoteDir, "archives", name)); !os.IsNotExist(err) {
+			t.Errorf("%s 不应保存在压缩包目录: %v", name, err)
+		}
+	}
+
+	incConfig := *config
+	incConfig.Mode = "incremental"
+	incConfig.Repair = true
+	if _, err := NewBackupManager(&incConfig, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份应从元数据目录读取元数据: %v", err)
+	}
+	verifyResult, err := NewBackupManager(config, mockStorage).RunVerify(ctx, 2, MismatchReport)
+	if err != nil || verifyResult.VerifiedArchives != 2 {
+		t.Fatalf("校验失败: %v %+v", err, verifyResult)
+	}
+
+	// 复制到目标后元数据与压缩包位于同一目录
+	if _, err := NewBackupManager(config, mockStorage).RunClone(ctx, "/copy", false); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "copy", MetadataFileName)); err != nil {
+		t.Errorf("复制的元数据应与压缩包位于同一目录: %v", err)
+	}
+}
+
+// TestArchiveTimestampSuffix 测试带时间戳后缀的压缩包：每次上传新的对象，从不覆盖已有的压缩包；
+// 增量备份沿用未变化分组的对象，校验、恢复和prune按元数据记录的对象名处理
+func TestArchiveTimestampSuffix(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/backup",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		TarIndex:      true,
+		KeepHistory:   true,
+		ArchiveSuffix: true,
+	}
+	store := storage.NewMemStorage()
+	// 模拟不可变存储：压缩包、校验和文件和索引一旦写入就不能覆盖
+	store.SetFailure(func(op, remotePath string) error {
+		for _, dir := range []string{ChunkDirName, Sha256DirName, IndexDirName} {
+			if op == "UploadFile" && strings.HasPrefix(remotePath, filepath.Join("/backup", dir)+"/") {
+				if _, exists := store.Get(remotePath); exists {
+					return fmt.Errorf("%s is immutable", remotePath)
+				}
+			}
+		}
+		return nil
+	})
+	ctx := context.Background()
+	manager := func(mode string, clock time.Time) *BackupManager {
+		cfg := *config
+		cfg.Mode = mode
+		bm := NewBackupManager(&cfg, store)
+		bm.now = func() time.Time { return clock }
+		return bm
+	}
+	loadMetadata := func() *models.BackupMetadata {
+		data, ok := store.Get("/backup/" + MetadataFileName)
+		if !ok {
+			t.Fatal("远程没有备份元数据")
+		}
+		var metadata models.BackupMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("解析元数据失败: %v", err)
+		}
+		return &metadata
+	}
+
+	first := time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+	if _, err := manager("full", first).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	metadata := loadMetadata()
+	oldObject := "0000-00ff.20240101T020000Z.tar.gz"
+	sharedObject := "0100-01ff.20240101T020000Z.tar.gz"
+	if metadata.Objects["0000-00ff.tar.gz"] != oldObject || metadata.Objects["0100-01ff.tar.gz"] != sharedObject {
+		t.Fatalf("元数据记录的对象名不正确: %v", metadata.Objects)
+	}
+	if _, exists := store.Get("/backup/chunk/0000-00ff.tar.gz"); exists {
+		t.Error("不应上传不带后缀的压缩包")
+	}
+	if content, _ := store.Get("/backup/sha256/" + oldObject + ".sha256"); !strings.HasSuffix(string(content), "  "+oldObject+"\n") {
+		t.Errorf("校验和文件应记录对象名: %q", content)
+	}
+	if _, exists := store.Get("/backup/index/" + filepath.Base(archiver.IndexPath(oldObject))); !exists {
+		t.Error("tar索引应以对象名命名")
+	}
+
+	// 把全量备份的历史快照改为更早的时间，prune时作为过期快照清理
+	files, _ := store.ListFiles(ctx, "/backup/"+HistoryDirName)
+	if len(files) != 1 {
+		t.Fatalf("应有1个历史快照: %+v", files)
+	}
+	if err := store.MoveRemote(ctx, "/backup/history/"+files[0].Name, "/backup/history/"+historyFileName(first)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改0000目录后增量备份，只有变化的分组上传新对象
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "file0.dat"), []byte("modified chunk 0000 file 0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := manager("incremental", first.Add(time.Hour)).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份不应覆盖已有对象: %v", err)
+	}
+	if result.UpdatedArchives != 1 {
+		t.Errorf("应上传1个压缩包，实际 %d: %v", result.UpdatedArchives, result.Details)
+	}
+	metadata = loadMetadata()
+	newObject := "0000-00ff.20240101T030000Z.tar.gz"
+	if metadata.Objects["0000-00ff.tar.gz"] != newObject || metadata.Objects["0100-01ff.tar.gz"] != sharedObject {
+		t.Fatalf("增量备份后的对象名不正确: %v", metadata.Objects)
+	}
+	if _, exists := store.Get("/backup/chunk/" + oldObject); !exists {
+		t.Error("旧对象应保留到prune清理")
+	}
+
+	verifyResult, err := manager("", first).RunVerify(ctx, 2, MismatchReport)
+	if err != nil || verifyResult.VerifiedArchives != 2 || len(verifyResult.Mismatches) != 0 {
+		t.Fatalf("校验失败: %v %+v", err, verifyResult)
+	}
+
+	restoreConfig := *config
+	restoreConfig.ChunkPath = filepath.Join(testDir, "restore")
+	if _, err := NewBackupManager(&restoreConfig, store).RunRestore(ctx, ""); err != nil {
+		t.Fatalf("恢复失败: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(restoreConfig.ChunkPath, "0000", "file0.dat")); string(data) != "modified chunk 0000 file 0" {
+		t.Errorf("恢复的内容不正确: %q", data)
+	}
+
+	// 再备份一次，.prev改为引用上一次的对象
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "file0.dat"), []byte("modified again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager("incremental", first.Add(2*time.Hour)).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+
+	// 过期快照单独引用的旧对象被删除，两次备份共用的对象和.prev引用的对象保留
+	pruneResult, err := manager("", first).RunPrune(ctx, RetentionPolicy{Daily: 1}, false)
+	if err != nil {
+		t.Fatalf("清理失败: %v", err)
+	}
+	if len(pruneResult.DeletedArchives) != 1 || pruneResult.DeletedArchives[0] != oldObject {
+		t.Errorf("应只删除旧对象: %v", pruneResult.DeletedArchives)
+	}
+	for _, path := range []string{"/backup/chunk/" + oldObject, "/backup/sha256/" + oldObject + ".sha256"} {
+		if _, exists := store.Get(path); exists {
+			t.Errorf("%s 应被删除", path)
+		}
+	}
+	for _, object := range []string{sharedObject, newObject} {
+		if _, exists := store.Get("/backup/chunk/" + object); !exists {
+			t.Errorf("仍被引用的对象 %s 不应删除", object)
+		}
+	}
+}
+
+// TestRemoteSubdirPerRun 测试每次运行写入独立的时间戳子目录：增量备份从latest复制上次运行后只上传变化的压缩包，
+// 每个子目录都是完整的备份，latest指向最新一次运行
+func TestRemoteSubdirPerRun(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	remoteDir := filepath.Join(testDir, "remote")
+	tempDir := filepath.Join(testDir, "temp")
+	createInitialChunkData(t, chunkDir)
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	run := func(mode string, start time.Time) (*BackupManager, *models.BackupResult) {
+		t.Helper()
+		config := &models.Config{
+			ChunkPath:    chunkDir,
+			RemotePath:   "/",
+			TempPath:     tempDir,
+			PrefixDigits: 2,
+			Mode:         mode,
+			RunSubdir:    true,
+		}
+		bm := NewBackupManager(config, mockStorage)
+		bm.now = func() time.Time { return start }
+		if err := bm.PrepareRunSubdir(ctx); err != nil {
+			t.Fatalf("准备运行子目录失败: %v", err)
+		}
+		var result *models.BackupResult
+		var err error
+		if mode == "full" {
+			result, err = bm.RunFullBackup(ctx)
+		} else {
+			result, err = bm.RunIncrementalBackup(ctx)
+		}
+		if err != nil {
+			t.Fatalf("%s备份失败: %v", mode, err)
+		}
+		if err := bm.UpdateLatest(ctx); err != nil {
+			t.Fatalf("更新latest失败: %v", err)
+		}
+		return bm, result
+	}
+	latest := func() string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(remoteDir, LatestFileName))
+		if err != nil {
+			t.Fatalf("读取latest失败: %v", err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	first := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	run("full", first)
+	verifyRemoteStorage(t, filepath.Join(remoteDir, "2024-01-01T12-00"), 2)
+	if got := latest(); got != "2024-01-01T12-00" {
+		t.Errorf("latest应该指向第一次运行，实际为%q", got)
+	}
+
+	// 同一分钟内再次运行时子目录已存在
+	config := &models.Config{ChunkPath: chunkDir, RemotePath: "/", TempPath: tempDir, Mode: "incremental", RunSubdir: true}
+	bm := NewBackupManager(config, mockStorage)
+	bm.now = func() time.Time { return first.Add(10 * time.Second) }
+	if err := bm.PrepareRunSubdir(ctx); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("子目录已存在时应该报错: %v", err)
+	}
+
+	modifyChunkData(t, chunkDir)
+	second := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	_, result := run("incremental", second)
+	if result.UpdatedArchives == 0 || result.UpdatedArchives >= result.TotalArchives {
+		t.Errorf("增量备份应该只更新部分压缩包: 总计=%d, 更新=%d", result.TotalArchives, result.UpdatedArchives)
+	}
+	if got := latest(); got != "2024-01-02T12-00" {
+		t.Errorf("latest应该指向第二次运行，实际为%q", got)
+	}
+
+	// 新子目录包含全部压缩包，上一次运行保持不变
+	verifyRemoteStorage(t, filepath.Join(remoteDir, "2024-01-02T12-00"), 3)
+	verifyRemoteStorage(t, filepath.Join(remoteDir, "2024-01-01T12-00"), 2)
+
+	// 只读操作通过latest找到最新一次运行
+	readConfig := &models.Config{ChunkPath: chunkDir, RemotePath: "/", TempPath: tempDir, RunSubdir: true}
+	reader := NewBackupManager(readConfig, mockStorage)
+	if err := reader.UseLatestRun(ctx); err != nil {
+		t.Fatalf("读取latest失败: %v", err)
+	}
+	if readConfig.RemotePath != filepath.Join("/", "2024-01-02T12-00") {
+		t.Errorf("远程路径应该改为最新一次运行: %s", readConfig.RemotePath)
+	}
+	metadata, err := reader.loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("加载最新一次运行的元数据失败: %v", err)
+	}
+	if _, ok := metadata.FileTree["0200"]; !ok {
+		t.Error("最新一次运行的元数据应该包含新增的目录0200")
+	}
+}
+
+// TestPrevMetadata 测试覆盖元数据前保存.prev，主元数据损坏时按选项回退到.prev
+func TestPrevMetadata(t *testing.T) {
+	defer func(delay time.Duration) { jsonRetryDelay = delay }(jsonRetryDelay)
+	jsonRetryDelay = 0
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	metadataPath := filepath.Join(remoteDir, MetadataFileName)
+	prevPath := metadataPath + PrevMetadataSuffix
+	if _, err := os.Stat(prevPath); !os.IsNotExist(err) {
+		t.Fatalf("第一次备份前没有元数据，不应该生成.prev: %v", err)
+	}
+	first, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二次备份覆盖元数据前把第一次的元数据和校验和保存为.prev
+	modifyChunkData(t, chunkDir)
+	config.Mode = "incremental"
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	prev, err := os.ReadFile(prevPath)
+	if err != nil {
+		t.Fatalf("应该保存.prev: %v", err)
+	}
+	if !bytes.Equal(prev, first) {
+		t.Error(".prev应该与覆盖前的元数据相同")
+	}
+	if _, err := os.Stat(prevPath + MetadataChecksumSuffix); err != nil {
+		t.Errorf("应该同时保存.prev的校验和文件: %v", err)
+	}
+
+	// 主元数据损坏：未启用回退时报错并提示选项
+	if err := os.WriteFile(metadataPath, []byte(`{"version": 1, "file_tree": {`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+	if err == nil || !strings.Contains(err.Error(), "--use-prev-metadata") {
+		t.Fatalf("元数据损坏时应该提示--use-prev-metadata: %v", err)
+	}
+
+	// 启用回退后加载.prev，本次备份不用损坏的元数据覆盖.prev
+	config.UsePrevMetadata = true
+	bm := NewBackupManager(config, mockStorage)
+	metadata, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("回退到.prev失败: %v", err)
+	}
+	if _, ok := metadata.FileTree["0200"]; ok {
+		t.Error("回退后应该是第一次备份的元数据")
+	}
+	if _, err := bm.RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("使用.prev的增量备份失败: %v", err)
+	}
+	if prev, _ := os.ReadFile(prevPath); !bytes.Equal(prev, first) {
+		t.Error("主元数据损坏时不应该覆盖.prev")
+	}
+	config.UsePrevMetadata = false
+	metadata, err = NewBackupManager(config, mockStorage).loadRemoteMetadata(ctx)
+	if err != nil {
+		t.Fatalf("回退后的备份应该上传有效的元数据: %v", err)
+	}
+	if _, ok := metadata.FileTree["0200"]; !ok {
+		t.Error("新的元数据应该包含目录0200")
+	}
+}
+
+// TestPrevMetadataSplitFileTree 测试拆分保存文件树时.prev和历史快照引用的文件树不会被清理，回退到.prev后仍能比较
+func TestPrevMetadataSplitFileTree(t *testing.T) {
+	defer func(delay time.Duration) { jsonRetryDelay = delay }(jsonRetryDelay)
+	jsonRetryDelay = 0
+
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	config := &models.Config{
+		ChunkPath:     chunkDir,
+		RemotePath:    "/",
+		TempPath:      filepath.Join(testDir, "temp"),
+		PrefixDigits:  2,
+		Mode:          "full",
+		SplitFileTree: true,
+		KeepHistory:   true,
+	}
+	if _, err := NewBackupManager(config, mockStorage).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	// 只有第一次备份保存历史快照
+	config.KeepHistory = false
+	modifyChunkData(t, chunkDir)
+	config.Mode = "incremental"
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(chunkDir, "0000", "third.dat"), []byte("third"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+
+	// 当前元数据、.prev和历史快照分别引用三个文件树
+	trees, err := os.ReadDir(filepath.Join(remoteDir, FileTreeDirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trees) != 3 {
+		t.Errorf("应该保留3个仍被引用的文件树，实际 %d", len(trees))
+	}
+
+	metadataPath := filepath.Join(remoteDir, MetadataFileName)
+	if err := os.WriteFile(metadataPath, []byte(`{"version": 1, "file_tree_file": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.UsePrevMetadata = true
+	if _, err := NewBackupManager(config, mockStorage).RunIncrementalBackup(ctx); err != nil {
+		t.Fatalf("回退到.prev的增量备份失败: %v", err)
+	}
+}
+
+func TestMaxRuntime(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+	createInitialChunkData(t, chunkDir)
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+		MaxRuntime:   90 * time.Second,
+	}
+	mockStorage := storage.NewMockStorage(remoteDir)
+	ctx := context.Background()
+
+	// 每次读取时钟前进一分钟：第一个分组开始前未超时，第二个分组开始前已超过90秒
+	newManager := func(cfg *models.Config) *BackupManager {
+		bm := NewBackupManager(cfg, mockStorage)
+		clock := time.Date(2024, 3, 14, 2, 0, 0, 0, time.UTC)
+		bm.now = func() time.Time {
+			clock = clock.Add(time.Minute)
+			return clock
+		}
+		return bm
+	}
+	loadMetadata := func() models.BackupMetadata {
+		var metadata models.BackupMetadata
+		data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+		if err != nil {
+			t.Fatalf("读取元数据失败: %v", err)
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("解析元数据失败: %v", err)
+		}
+		return metadata
+	}
+
+	result, err := newManager(config).RunFullBackup(ctx)
+	if !errors.Is(err, ErrTimeBudget) || !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("达到最长运行时间应返回ErrTimeBudget: %v", err)
+	}
+	if len(result.DeferredArchives) != 1 || result.DeferredArchives[0] != "0100-01ff.tar.gz" || result.UpdatedArchives != 1 {
+		t.Fatalf("应完成第一个分组并推迟第二个: %+v", result)
+	}
+	metadata := loadMetadata()
+	if _, exists := metadata.Checksums["0100-01ff.tar.gz"]; exists {
+		t.Error("推迟的分组不应记录校验和")
+	}
+	if _, exists := metadata.FileTree["0100"]; exists {
+		t.Error("推迟的分组的目录不应记录在文件树中")
+	}
+
+	// 下次增量备份继续处理推迟的分组
+	incrementalConfig := *config
+	incrementalConfig.Mode = "incremental"
+	incrementalConfig.MaxRuntime = 0
+	result, err = NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 1 || result.Details["0100-01ff.tar.gz"] == "unchanged, skipped" {
+		t.Errorf("增量备份应处理推迟的分组: %+v", result.Details)
+	}
+	if metadata := loadMetadata(); len(metadata.Checksums) != 2 || metadata.FileTree["0100"] == nil {
+		t.Errorf("推迟的分组应补齐: %v", metadata.Checksums)
+	}
+
+	// 增量备份推迟的分组沿用上次的文件树记录，再下一次运行时仍视为变化
+	for _, dir := range []string{"0000", "0100"} {
+		if err := os.WriteFile(filepath.Join(chunkDir, dir, "file0.dat"), []byte("changed content of "+dir), 0644); err != nil {
+			t.Fatalf("修改文件失败: %v", err)
+		}
+	}
+	incrementalConfig.MaxRuntime = config.MaxRuntime
+	result, err = newManager(&incrementalConfig).RunIncrementalBackup(ctx)
+	if !errors.Is(err, ErrTimeBudget) || len(result.DeferredArchives) != 1 {
+		t.Fatalf("增量备份应推迟一个分组: %+v (%v)", result, err)
+	}
+	oldChecksum := loadMetadata().Checksums["0100-01ff.tar.gz"]
+
+	incrementalConfig.MaxRuntime = 0
+	result, err = NewBackupManager(&incrementalConfig, mockStorage).RunIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatalf("增量备份失败: %v", err)
+	}
+	if result.UpdatedArchives != 1 || result.Details["0000-00ff.tar.gz"] != "unchanged, skipped" {
+		t.Errorf("只应处理上次推迟的分组: %+v", result.Details)
+	}
+	if loadMetadata().Checksums["0100-01ff.tar.gz"] == oldChecksum {
+		t.Error("推迟的分组应重新生成压缩包")
+	}
+}
+
+// objectLimitStorage 报告单个对象大小上限的存储
+type objectLimitStorage struct {
+	*storage.MockStorage
+	limit int64
+}
+
+func (s *objectLimitStorage) Capabilities() storage.Capabilities {
+	caps := s.MockStorage.Capabilities()
+	caps.MaxObjectSize = s.limit
+	return caps
+}
+
+// TestMaxObjectSize 测试预计压缩包超过远程对象大小上限时自动切分，--max-object-size可以覆盖后端的上限，
+// 增量备份上传前拒绝超过上限的压缩包
+func TestMaxObjectSize(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, ".chunk")
+	remoteDir := filepath.Join(testDir, "remote")
+
+	// 4个目录各1000字节，内容可压缩，压缩包远小于目录大小
+	for _, dir := range []string{"0000", "0001", "0002", "0003"} {
+		if err := os.MkdirAll(filepath.Join(chunkDir, dir), 0755); err != nil {
+			t.Fatalf("创建chunk目录失败: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(chunkDir, dir, "chunk"), make([]byte, 1000), 0644); err != nil {
+			t.Fatalf("创建文件失败: %v", err)
+		}
+	}
+
+	config := &models.Config{
+		ChunkPath:    chunkDir,
+		RemotePath:   "/",
+		TempPath:     filepath.Join(testDir, "temp"),
+		PrefixDigits: 2,
+		Mode:         "full",
+	}
+	store := &objectLimitStorage{MockStorage: storage.NewMockStorage(remoteDir), limit: 2200}
+	ctx := context.Background()
+	loadMetadata := func() models.BackupMetadata {
+		var metadata models.BackupMetadata
+		data, err := os.ReadFile(filepath.Join(remoteDir, MetadataFileName))
+		if err != nil {
+			t.Fatalf("读取元数据失败: %v", err)
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("解析元数据失败: %v", err)
+		}
+		return metadata
+	}
+
+	// 上限2200字节预留5%余量后每个压缩包最多2个目录
+	fullConfig := *config
+	if _, err := NewBackupManager(&fullConfig, store).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	metadata := loadMetadata()
+	if metadata.DirBatchSize != 2 {
+		t.Errorf("元数据应记录自动设置的目录数2，得到%d", metadata.DirBatchSize)
+	}
+	for _, name := range []string{"0000-0001.tar.gz", "0002-0003.tar.gz"} {
+		if _, exists := metadata.Checksums[name]; !exists {
+			t.Errorf("应按上限切分出压缩包 %s: %v", name, metadata.Checksums)
+		}
+	}
+
+	// 覆盖为不检查时不切分
+	overrideConfig := *config
+	overrideConfig.MaxObjectSize = -1
+	if _, err := NewBackupManager(&overrideConfig, store).RunFullBackup(ctx); err != nil {
+		t.Fatalf("全量备份失败: %v", err)
+	}
+	metadata = loadMetadata()
+	if _, exists := metadata.Checksums["0000-00ff.tar.gz"]; !exists || metadata.DirBatchSize != 0 {
+		t.Errorf("覆盖上限后不应切分: 目录数 %d, %v", metadata.DirBatchSize, metadata.Checksums)
+	}
+
+	// 单个目录超过上限时无法切分
+	tinyConfig := *config
+	tinyConfig.MaxObjectSize = 500
+	if _, err := NewBackupManager(&tinyConfig, store).RunFullBackup(ctx); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("单个目录超过上限应返回ErrObjectTooLarge: %v", err)
+	}
+
+	// 增量备份沿用上次的分组，压缩包增长超过上限时在上传前失败
+	random := make([]byte, 3000)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("生成随机数据失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(chunkDir, "0001", "chunk"), random, 0644); err != nil {
+		t.Fatalf("修改文件失败: %v", err)
+	}
+	incrementalConfig := *config
+	incrementalConfig.Mode = "incremental"
+	result, err := NewBackupManager(&incrementalConfig, store).RunIncrementalBackup(ctx)
+	if !errors.Is(err, ErrPartialFailure) {
+		t.Fatalf("压缩包超过上限应部分失败: %v", err)
+	}
+	if len(result.ErrorArchives) != 1 || !strings.Contains(result.Details["0000-00ff.tar.gz"], ErrObjectTooLarge.Error()) {
+		t.Errorf("应报告压缩包超过对象大小上限: %v %v", result.ErrorArchives, result.Details)
+	}
+	if len(result.UploadedFiles) != 0 {
+		t.Errorf("超过上限的压缩包不应上传: %v", result.UploadedFiles)
+	}
+}
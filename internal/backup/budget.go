@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// startBudget 记录MaxRuntime的计时起点。自动模式内部调用全量或增量备份时沿用最先记录的起点
+func (bm *BackupManager) startBudget() {
+	if bm.budgetStart.IsZero() {
+		bm.budgetStart = bm.now()
+	}
+}
+
+// outOfTime 设置了MaxRuntime且运行时间已经用完时返回true，调用方不再开始新的压缩包组，
+// 正在处理的压缩包组照常完成
+func (bm *BackupManager) outOfTime() bool {
+	if bm.config.MaxRuntime <= 0 || bm.budgetStart.IsZero() {
+		return false
+	}
+	if bm.now().Sub(bm.budgetStart) < bm.config.MaxRuntime {
+		return false
+	}
+	if !bm.budgetSpent {
+		bm.budgetSpent = true
+		logger.Warn(fmt.Sprintf("已达到最长运行时间%v，不再开始新的压缩包组，剩余分组推迟到下次运行", bm.config.MaxRuntime))
+	}
+	return true
+}
+
+// deferGroup 记录因运行时间用完而推迟的压缩包组。组内目录在新元数据的文件树中恢复为上次的记录，
+// 上次没有记录（全量备份或文件树拆分保存）时删除，下次增量备份会把这些目录视为变化并重新处理该分组
+func deferGroup(group *models.ArchiveGroup, metadata *models.BackupMetadata, oldFileTree map[string]*models.FileTreeNode, result *models.BackupResult) {
+	for _, dir := range group.Directories {
+		if oldNode, exists := oldFileTree[dir]; exists {
+			metadata.FileTree[dir] = oldNode
+		} else {
+			delete(metadata.FileTree, dir)
+		}
+	}
+	result.DeferredArchives = append(result.DeferredArchives, group.ArchiveName)
+	result.Details[group.ArchiveName] = "deferred: max runtime reached"
+}
+
+// backupOutcome 根据备份结果返回错误：有推迟的分组时返回TimeBudgetError，否则按失败的压缩包返回PartialFailureError或nil
+func backupOutcome(result *models.BackupResult) error {
+	if len(result.DeferredArchives) > 0 {
+		return &TimeBudgetError{Deferred: result.DeferredArchives, Failed: result.ErrorArchives}
+	}
+	return partialFailure(result.ErrorArchives)
+}
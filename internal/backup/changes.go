@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// 远程漂移的类型，见models.RemoteDrift
+const (
+	DriftArchiveMissing   = "archive_missing"
+	DriftChecksumMissing  = "checksum_missing"
+	DriftChecksumInvalid  = "checksum_invalid"
+	DriftChecksumMismatch = "checksum_mismatch"
+)
+
+// ListChanged 只计算增量备份将要更新的目录和压缩包，不创建压缩包也不上传任何文件。
+// 判断方式与RunIncrementalBackup相同，远程没有元数据时返回ErrNoMetadata。
+// 启用VerifyRemote时还检查不需要更新的压缩包在远程是否存在、校验和文件是否与元数据一致
+func (bm *BackupManager) ListChanged(ctx context.Context) (*models.ChangeList, error) {
+	compareMode, err := scanner.ParseCompareMode(bm.config.CompareMode)
+	if err != nil {
+		return nil, err
+	}
+
+	oldMetadata, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous backup metadata: %w", err)
+	}
+
+	datastoreID, err := bm.datastoreID()
+	if err != nil {
+		return nil, err
+	}
+	if err := bm.checkDatastore(oldMetadata, datastoreID); err != nil {
+		return nil, err
+	}
+	if err := bm.requireChunkDirectories(); err != nil {
+		return nil, err
+	}
+
+	comparison, err := bm.compareWithPrevious(ctx, oldMetadata, compareMode)
+	if err != nil {
+		return nil, err
+	}
+	moves := bm.detectMoves(oldMetadata, comparison)
+	currentFileTree, changedDirs := comparison.current, comparison.changed
+	missing := bm.checkBaseline(oldMetadata, comparison.oldSizes)
+
+	changes := &models.ChangeList{
+		ChangedDirs: make([]string, 0, len(changedDirs)),
+		Archives:    []string{},
+	}
+	for dir := range changedDirs {
+		changes.ChangedDirs = append(changes.ChangedDirs, dir)
+	}
+	scanner.SortHex(changes.ChangedDirs)
+
+	if len(changedDirs) == 0 && !bm.config.Repair && !bm.config.VerifyRemote {
+		return changes, nil
+	}
+
+	directories, err := bm.scanner.GetChunkDirectories()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chunk directories: %w", err)
+	}
+	directories = bm.filterScannedDirectories(directories, currentFileTree, &models.BackupResult{})
+
+	groups, err := archiveGroups(bm.archiverFor(oldMetadata), directories, oldMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate archive groups: %w", err)
+	}
+	bm.archiver.MarkGroupsForUpdate(groups, changedDirs)
+	if bm.config.Repair {
+		markMissingGroups(groups, missing)
+	}
+	forceMoveTargets(groups, moves)
+
+	for _, group := range groups {
+		if group.NeedsUpdate {
+			changes.Archives = append(changes.Archives, group.ArchiveName)
+		}
+	}
+	scanner.SortHex(changes.Archives)
+
+	if bm.config.VerifyRemote {
+		changes.RemoteDrift, err = bm.checkRemoteDrift(ctx, oldMetadata, groups)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+// checkRemoteDrift 检查不需要更新、元数据中有校验和的压缩包：远程对象和校验和文件是否存在，
+// 校验和文件记录的值是否与元数据一致。只读取校验和文件，不下载压缩包；最多同时检查decisionConcurrency个压缩包。
+// 远程有汇总校验和文件时只读取一次checksums.txt，与其中的记录比较
+func (bm *BackupManager) checkRemoteDrift(ctx context.Context, metadata *models.BackupMetadata, groups []*models.ArchiveGroup) ([]models.RemoteDrift, error) {
+	var checked []*models.ArchiveGroup
+	for _, group := range groups {
+		if _, recorded := metadata.Checksums[group.ArchiveName]; recorded && !group.NeedsUpdate {
+			checked = append(checked, group)
+		}
+	}
+
+	var bundle map[string]string
+	if hasChecksumBundle(metadata) {
+		exists, err := bm.storage.FileExists(ctx, filepath.Join(bm.config.RemotePath, ChecksumBundleName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", ChecksumBundleName, remoteError(err))
+		}
+		bundle = map[string]string{}
+		if exists {
+			if bundle, err = bm.loadChecksumBundle(ctx, bm.config.RemotePath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 每个压缩包的结果写入各自的位置，汇总时保持分组的顺序
+	found := make([]*models.RemoteDrift, len(checked))
+	errs := make([]error, len(checked))
+	limiter := newFixedLimiter(bm.decisionConcurrency())
+	var wg sync.WaitGroup
+	for i, group := range checked {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		wg.Add(1)
+		limiter.acquire()
+		go func(i int, name string) {
+			defer wg.Done()
+			defer limiter.release(0, false)
+			found[i], errs[i] = bm.archiveDrift(ctx, name, archiveObject(metadata, name), metadata.Checksums[name], bundle)
+		}(i, group.ArchiveName)
+	}
+	wg.Wait()
+
+	var drift []models.RemoteDrift
+	for i := range checked {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if found[i] != nil {
+			drift = append(drift, *found[i])
+		}
+	}
+	if len(drift) > 0 {
+		logger.Warn(fmt.Sprintf("远程有%d个压缩包与元数据不一致", len(drift)))
+	}
+	return drift, nil
+}
+
+// archiveDrift 检查一个压缩包的远程对象和校验和文件，一致时返回nil。bundle不为nil时与汇总校验和文件的记录比较
+func (bm *BackupManager) archiveDrift(ctx context.Context, name, object, expected string, bundle map[string]string) (*models.RemoteDrift, error) {
+	exists, err := bm.storage.FileExists(ctx, filepath.Join(bm.config.RemotePath, ChunkDirName, object))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check remote archive %s: %w", object, remoteError(err))
+	}
+	if !exists {
+		return &models.RemoteDrift{Archive: name, Problem: DriftArchiveMissing}, nil
+	}
+
+	if bundle != nil {
+		actual, exists := bundle[object]
+		switch {
+		case !exists:
+			return &models.RemoteDrift{Archive: name, Problem: DriftChecksumMissing}, nil
+		case actual != expected:
+			return &models.RemoteDrift{Archive: name, Problem: DriftChecksumMismatch, Detail: actual}, nil
+		}
+		return nil, nil
+	}
+
+	checksumPath := filepath.Join(bm.config.RemotePath, Sha256DirName, object+".sha256")
+	exists, err = bm.storage.FileExists(ctx, checksumPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check remote checksum %s: %w", object, remoteError(err))
+	}
+	if !exists {
+		return &models.RemoteDrift{Archive: name, Problem: DriftChecksumMissing}, nil
+	}
+	content, err := bm.storage.GetFileContent(ctx, checksumPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote checksum %s: %w", object, remoteError(err))
+	}
+	actual, err := parseChecksum(content, object)
+	if err != nil {
+		return &models.RemoteDrift{Archive: name, Problem: DriftChecksumInvalid, Detail: err.Error()}, nil
+	}
+	if actual != expected {
+		return &models.RemoteDrift{Archive: name, Problem: DriftChecksumMismatch, Detail: actual}, nil
+	}
+	return nil, nil
+}
+
+// keptDirectories 返回本次没有扫描、沿用上次文件树记录的目录：因修改时间早于--newer-than被跳过的目录，
+// 启用--continue-on-scan-error后多次扫描失败的目录，以及被--include-prefix或--max-dir-size排除的目录
+// （不能当作已删除，否则会从压缩包中去掉）
+func (bm *BackupManager) keptDirectories() []string {
+	kept := append(append([]string{}, bm.scanner.StaleDirectories()...), bm.scanner.UnreadableDirectories()...)
+	kept = append(kept, bm.scanner.FilteredDirectories()...)
+	for dir := range bm.scanner.ExcludedDirectories() {
+		kept = append(kept, dir)
+	}
+	return kept
+}
+
+// treeComparison 当前文件树与上次备份文件树的比较结果
+type treeComparison struct {
+	current  map[string]*models.FileTreeNode // 当前文件树，被--newer-than跳过或扫描失败的目录沿用上次的记录
+	changed  map[string]bool                 // 新增、修改或删除的目录
+	oldSizes map[string]int64                // 上次文件树中每个目录的大小
+	mode     scanner.CompareMode             // 实际使用的比较模式
+}
+
+// compareWithPrevious 扫描当前文件树并与元数据中的文件树比较，因修改时间早于--newer-than
+// 被跳过或扫描失败的目录沿用上次的记录，不视为删除。文件树拆分保存且尚未加载时边读取边扫描边比较，
+// 内存中不保留完整的旧文件树
+func (bm *BackupManager) compareWithPrevious(ctx context.Context, metadata *models.BackupMetadata, mode scanner.CompareMode) (*treeComparison, error) {
+	// 不同算法的哈希无法比较，本次退回到比较大小和修改时间，新元数据记录本次的算法
+	if mode == scanner.CompareHash && metadata.TreeHash != metadataTreeHash(bm.config.TreeHash) {
+		logger.Warn("文件哈希算法与上次备份不同，本次按大小和修改时间比较")
+		mode = scanner.CompareMtimeSize
+	}
+	if metadata.FileTree == nil && metadata.FileTreeFile != "" {
+		if !bm.config.TrackInodes {
+			return bm.streamCompare(ctx, metadata, mode)
+		}
+		// 识别移动的文件需要完整的旧文件树
+		if _, err := bm.loadFileTree(ctx, metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	currentFileTree, err := bm.scanner.ScanFileTree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan current file tree: %w", err)
+	}
+	oldFileTree := metadata.FileTree
+
+	for _, dir := range bm.keptDirectories() {
+		if oldNode, exists := oldFileTree[dir]; exists {
+			currentFileTree[dir] = oldNode
+		}
+	}
+
+	return &treeComparison{
+		current:  currentFileTree,
+		changed:  scanner.CompareFileTreesWithMode(oldFileTree, currentFileTree, mode),
+		oldSizes: scanner.TreeSizes(oldFileTree),
+		mode:     mode,
+	}, nil
+}
+
+// streamCompare 从拆分保存的文件树对象中逐个读取上次的目录，与逐个扫描的当前目录合并比较
+func (bm *BackupManager) streamCompare(ctx context.Context, metadata *models.BackupMetadata, mode scanner.CompareMode) (*treeComparison, error) {
+	remotePath := filepath.Join(bm.config.RemotePath, filepath.FromSlash(metadata.FileTreeFile))
+	comparison := &treeComparison{current: make(map[string]*models.FileTreeNode), mode: mode}
+
+	err := bm.readJSONFile(ctx, remotePath, metadata.FileTreeSHA256, func(r io.Reader) error {
+		decoder, err := scanner.NewFileTreeDecoder(r)
+		if err != nil {
+			return err
+		}
+		stream, err := bm.scanner.CompareStream(decoder, mode, func(name string, node *models.FileTreeNode) {
+			comparison.current[name] = node
+		})
+		if err != nil {
+			return err
+		}
+
+		comparison.changed = stream.Changed
+		comparison.oldSizes = stream.OldSizes
+		for _, dir := range bm.keptDirectories() {
+			if oldNode, exists := stream.Removed[dir]; exists {
+				comparison.current[dir] = oldNode
+				delete(comparison.changed, dir)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare with previous file tree %s: %w", metadata.FileTreeFile, err)
+	}
+	return comparison, nil
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// 校验和文件的保存方式
+const (
+	ChecksumFilesSidecar = "sidecar" // 每个压缩包一个sha256/<对象名>.sha256（默认）
+	ChecksumFilesBundle  = "bundle"  // 所有压缩包的校验和写入RemotePath下的checksums.txt，不再上传单独的校验和文件
+	ChecksumFilesBoth    = "both"    // 两种都上传，兼容只读取单独校验和文件的旧版本和外部脚本
+
+	// ChecksumBundleName 汇总校验和文件名，记录的路径相对RemotePath（chunk/<对象名>），可在RemotePath下直接执行sha256sum -c
+	ChecksumBundleName = "checksums.txt"
+)
+
+// ParseChecksumFiles 解析校验和文件的保存方式，空字符串表示sidecar
+func ParseChecksumFiles(mode string) (string, error) {
+	switch mode {
+	case "", ChecksumFilesSidecar:
+		return ChecksumFilesSidecar, nil
+	case ChecksumFilesBundle, ChecksumFilesBoth:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid checksum files mode %q (expected %s, %s or %s)", mode, ChecksumFilesSidecar, ChecksumFilesBundle, ChecksumFilesBoth)
+	}
+}
+
+// writesSidecars 本次运行是否为每个压缩包上传单独的校验和文件
+func (bm *BackupManager) writesSidecars() bool {
+	return bm.config.ChecksumFiles != ChecksumFilesBundle
+}
+
+// writesBundle 本次运行是否上传汇总校验和文件
+func (bm *BackupManager) writesBundle() bool {
+	return bm.config.ChecksumFiles == ChecksumFilesBundle || bm.config.ChecksumFiles == ChecksumFilesBoth
+}
+
+// checksumFilesMode 返回本次运行记录在元数据中的保存方式，只有单独的校验和文件时为空，与旧版本的元数据相同
+func (bm *BackupManager) checksumFilesMode() string {
+	if bm.writesBundle() {
+		return bm.config.ChecksumFiles
+	}
+	return ""
+}
+
+// hasSidecars 元数据对应的远程是否有单独的校验和文件，读取校验和时按元数据记录的方式，与本次的配置无关
+func hasSidecars(metadata *models.BackupMetadata) bool {
+	return metadata.ChecksumFiles != ChecksumFilesBundle
+}
+
+// hasChecksumBundle 元数据对应的远程是否有汇总校验和文件
+func hasChecksumBundle(metadata *models.BackupMetadata) bool {
+	return metadata.ChecksumFiles == ChecksumFilesBundle || metadata.ChecksumFiles == ChecksumFilesBoth
+}
+
+// parseChecksum 解析name的校验和文件内容，接受sha256sum的各种输出格式（见archiver.ParseChecksumFile）。
+// 容忍编辑器或后端引入的UTF-8 BOM、CRLF换行和前后空白，校验和必须是64位十六进制，返回小写形式
+func parseChecksum(content []byte, name string) (string, error) {
+	return archiver.ParseChecksumFile(content, name)
+}
+
+// checksumFileContent 返回按配置的格式生成的校验和文件内容，与archiver.CreateChecksumFile生成的一致
+func (bm *BackupManager) checksumFileContent(checksum, archiveName string) string {
+	return archiver.FormatChecksumLine(bm.config.ChecksumStyle, checksum, archiveName)
+}
+
+// rewriteChecksumFiles 检查压缩包的校验和文件，记录的值与元数据一致但格式不规范
+// （BOM、CRLF、大写、多余空白或不是配置的格式）时按配置的格式重新上传。只在修复模式下执行，失败只记录警告。
+// 远程只有汇总校验和文件时不检查，汇总文件在保存元数据时重新生成
+func (bm *BackupManager) rewriteChecksumFiles(ctx context.Context, metadata *models.BackupMetadata, result *models.BackupResult) {
+	if !hasSidecars(metadata) {
+		return
+	}
+	for _, name := range sortedArchiveNames(metadata.Checksums) {
+		if ctx.Err() != nil {
+			return
+		}
+		object := archiveObject(metadata, name)
+		remotePath := filepath.Join(bm.config.RemotePath, Sha256DirName, object+".sha256")
+		content, err := bm.storage.GetFileContent(ctx, remotePath)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("读取校验和文件 %s 失败: %v", name, err))
+			continue
+		}
+
+		expected := metadata.Checksums[name]
+		if string(content) == bm.checksumFileContent(expected, object) {
+			continue
+		}
+		if actual, err := parseChecksum(content, object); err != nil || actual != expected {
+			// 内容与元数据不一致时无法判断哪一方正确，交给verify处理
+			logger.Warn(fmt.Sprintf("校验和文件 %s 与元数据不一致，未改写", name))
+			continue
+		}
+
+		if err := bm.uploadChecksumFile(ctx, object, expected); err != nil {
+			logger.Warn(fmt.Sprintf("改写校验和文件 %s 失败: %v", name, err))
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("已将校验和文件 %s 改写为标准格式", name))
+		result.RewrittenChecksums = append(result.RewrittenChecksums, name)
+	}
+}
+
+// uploadChecksumFile 按配置的格式生成远程对象名为object的压缩包的校验和文件并上传，覆盖远程已有的文件
+func (bm *BackupManager) uploadChecksumFile(ctx context.Context, object, checksum string) error {
+	localPath, err := bm.archiver.CreateChecksumFile(filepath.Join(bm.config.TempPath, object), checksum)
+	if err != nil {
+		return fmt.Errorf("failed to create checksum file: %w", err)
+	}
+	bm.tempFiles.track(localPath)
+	defer bm.tempFiles.remove(localPath)
+
+	remotePath := filepath.Join(bm.config.RemotePath, Sha256DirName, object+".sha256")
+	if err := bm.storage.UploadFile(ctx, localPath, remotePath); err != nil {
+		return fmt.Errorf("failed to upload checksum file: %w", remoteError(err))
+	}
+	return nil
+}
+
+// checksumBundleContent 按配置的格式生成汇总校验和文件的内容，每个压缩包一行，按压缩包名排序
+func (bm *BackupManager) checksumBundleContent(metadata *models.BackupMetadata) string {
+	var b strings.Builder
+	for _, name := range sortedArchiveNames(metadata.Checksums) {
+		b.WriteString(bm.checksumFileContent(metadata.Checksums[name], path.Join(ChunkDirName, archiveObject(metadata, name))))
+	}
+	return b.String()
+}
+
+// uploadChecksumBundle 按元数据生成汇总校验和文件并上传，覆盖远程已有的文件
+func (bm *BackupManager) uploadChecksumBundle(ctx context.Context, metadata *models.BackupMetadata) error {
+	localPath := filepath.Join(bm.config.TempPath, ChecksumBundleName)
+	return bm.uploadContent(ctx, localPath, ChecksumBundleName, []byte(bm.checksumBundleContent(metadata)))
+}
+
+// loadChecksumBundle 读取root下的汇总校验和文件，返回远程对象名到SHA256的映射
+func (bm *BackupManager) loadChecksumBundle(ctx context.Context, root string) (map[string]string, error) {
+	content, err := bm.storage.GetFileContent(ctx, filepath.Join(root, ChecksumBundleName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ChecksumBundleName, remoteError(err))
+	}
+	entries, err := archiver.ParseChecksumEntries(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ChecksumBundleName, err)
+	}
+	sums := make(map[string]string, len(entries))
+	for name, checksum := range entries {
+		if object, ok := strings.CutPrefix(name, ChunkDirName+"/"); ok {
+			sums[object] = checksum
+		}
+	}
+	return sums, nil
+}
+
+// syncChecksumFiles 保存元数据前按本次配置的保存方式维护校验和文件：上次只有汇总文件而本次需要单独的校验和文件时
+// 为所有压缩包补齐，需要汇总文件时按元数据重新生成，不再需要时删除远程的旧汇总文件。最后在元数据中记录本次的方式
+func (bm *BackupManager) syncChecksumFiles(ctx context.Context, metadata *models.BackupMetadata) error {
+	if bm.writesSidecars() && !hasSidecars(metadata) {
+		logger.Info("校验和文件改为逐个保存，为沿用的压缩包补齐校验和文件")
+		for _, name := range sortedArchiveNames(metadata.Checksums) {
+			if err := bm.uploadChecksumFile(ctx, archiveObject(metadata, name), metadata.Checksums[name]); err != nil {
+				return fmt.Errorf("failed to upload checksum file for %s: %w", name, err)
+			}
+		}
+	}
+
+	if bm.writesBundle() {
+		if err := bm.uploadChecksumBundle(ctx, metadata); err != nil {
+			return err
+		}
+	} else if hasChecksumBundle(metadata) {
+		if err := bm.storage.DeleteFile(ctx, filepath.Join(bm.config.RemotePath, ChecksumBundleName)); err != nil {
+			logger.Warn(fmt.Sprintf("删除不再维护的%s失败: %v", ChecksumBundleName, remoteError(err)))
+		}
+	}
+	metadata.ChecksumFiles = bm.checksumFilesMode()
+	return nil
+}
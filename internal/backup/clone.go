@@ -0,0 +1,228 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// RunClone 将RemotePath下的整个备份（压缩包、校验和、索引、blob、历史快照和元数据）复制到dstPath，
+// 不需要chunk目录也不重新打包。元数据最后复制，中途失败时目标不会出现引用缺失压缩包的元数据。
+// 复制完成后加载目标的元数据（按校验和文件校验），并比对每个压缩包的大小和校验和文件。
+// 目标已有备份元数据时需要overwrite为true
+func (bm *BackupManager) RunClone(ctx context.Context, dstPath string, overwrite bool) (*models.CloneResult, error) {
+	startTime := time.Now()
+	srcPath := bm.config.RemotePath
+	if filepath.Clean(srcPath) == filepath.Clean(dstPath) {
+		return nil, fmt.Errorf("clone destination must differ from source")
+	}
+
+	metadata, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup metadata: %w", err)
+	}
+
+	dstMetadataPath := filepath.Join(dstPath, MetadataFileName)
+	exists, err := bm.storage.FileExists(ctx, dstMetadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check destination metadata: %w", remoteError(err))
+	}
+	if exists && !overwrite {
+		return nil, fmt.Errorf("destination %s already contains a backup", dstPath)
+	}
+
+	entries, err := bm.storage.ListFiles(ctx, srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", remoteError(err))
+	}
+	if err := bm.storage.MkdirRemote(ctx, dstPath); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", remoteError(err))
+	}
+
+	result := &models.CloneResult{Source: srcPath, Destination: dstPath}
+	caps := bm.storage.Capabilities()
+	switch {
+	case !caps.Copy:
+		logger.Warn("存储后端不支持远程复制，逐个文件下载后重新上传")
+	case !caps.ServerSideCopy:
+		logger.Info("存储后端不支持服务端复制，数据经本机中转")
+	}
+
+	// 先复制目录和其他文件，再按与备份相同的顺序复制元数据：
+	// 删除目标旧的校验和文件、复制元数据、复制新的校验和文件
+	metadataFiles := map[string]bool{MetadataFileName: true, MetadataFileName + MetadataChecksumSuffix: true}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	for _, entry := range entries {
+		if metadataFiles[entry.Name] {
+			continue
+		}
+		if err := bm.copyRemote(ctx, srcPath, dstPath, entry.Name, entry.IsDir); err != nil {
+			return nil, err
+		}
+		result.CopiedObjects = append(result.CopiedObjects, entry.Name)
+	}
+
+	if err := bm.storage.DeleteFile(ctx, dstMetadataPath+MetadataChecksumSuffix); err != nil {
+		return nil, fmt.Errorf("failed to delete destination metadata checksum: %w", remoteError(err))
+	}
+	// 元数据可能保存在单独的远程（MetadataRemote），复制到目标后与压缩包位于同一目录。
+	// 先复制元数据再复制校验和文件；旧版本备份没有元数据校验和文件
+	metadataDir := bm.metadataDir()
+	for _, name := range []string{MetadataFileName, MetadataFileName + MetadataChecksumSuffix} {
+		exists, err := bm.storage.FileExists(ctx, filepath.Join(metadataDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", name, remoteError(err))
+		}
+		if !exists {
+			continue
+		}
+		if err := bm.copyRemote(ctx, metadataDir, dstPath, name, false); err != nil {
+			return nil, err
+		}
+		result.CopiedObjects = append(result.CopiedObjects, name)
+	}
+
+	if err := bm.verifyClone(ctx, metadata, dstPath, result); err != nil {
+		return nil, err
+	}
+
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// copyRemote 复制源路径下的一个文件或目录到目标的同名位置，后端不支持远程复制时经本地临时目录中转
+func (bm *BackupManager) copyRemote(ctx context.Context, srcPath, dstPath, name string, isDir bool) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("clone cancelled: %w", err)
+	}
+	logger.Info(fmt.Sprintf("复制 %s", name))
+	src, dst := filepath.Join(srcPath, name), filepath.Join(dstPath, name)
+	var err error
+	if bm.storage.Capabilities().Copy {
+		err = bm.storage.CopyRemote(ctx, src, dst)
+	} else {
+		err = bm.copyThroughLocal(ctx, src, dst, isDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", name, remoteError(err))
+	}
+	return nil
+}
+
+// copyThroughLocal 后端不支持远程复制时，将文件下载到临时目录再上传到目标；目录逐层递归复制
+func (bm *BackupManager) copyThroughLocal(ctx context.Context, srcPath, dstPath string, isDir bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !isDir {
+		localPath := filepath.Join(bm.config.TempPath, "clone-"+filepath.Base(srcPath))
+		defer os.Remove(localPath)
+		if err := bm.storage.DownloadFile(ctx, srcPath, localPath); err != nil {
+			return err
+		}
+		return bm.storage.UploadFile(ctx, localPath, dstPath)
+	}
+
+	files, err := bm.storage.ListFiles(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	if err := bm.storage.MkdirRemote(ctx, dstPath); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := bm.copyThroughLocal(ctx, filepath.Join(srcPath, file.Name), filepath.Join(dstPath, file.Name), file.IsDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyClone 加载目标的元数据并比对每个压缩包：大小与源一致，校验和文件记录的SHA256与元数据一致。
+// 不下载压缩包本身，需要完整校验时对目标执行verify
+func (bm *BackupManager) verifyClone(ctx context.Context, metadata *models.BackupMetadata, dstPath string, result *models.CloneResult) error {
+	cloned, err := bm.loadMetadataFile(ctx, filepath.Join(dstPath, MetadataFileName))
+	if err != nil {
+		return fmt.Errorf("failed to load cloned metadata: %w", err)
+	}
+	if len(cloned.Checksums) != len(metadata.Checksums) {
+		return fmt.Errorf("cloned metadata lists %d archives, source has %d", len(cloned.Checksums), len(metadata.Checksums))
+	}
+
+	sizes := func(root string) (map[string]int64, error) {
+		files, err := bm.storage.ListFiles(ctx, filepath.Join(root, ChunkDirName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list archives in %s: %w", root, remoteError(err))
+		}
+		sizes := make(map[string]int64, len(files))
+		for _, file := range files {
+			sizes[file.Name] = file.Size
+		}
+		return sizes, nil
+	}
+	srcSizes, err := sizes(bm.config.RemotePath)
+	if err != nil {
+		return err
+	}
+	dstSizes, err := sizes(dstPath)
+	if err != nil {
+		return err
+	}
+
+	// 只有汇总校验和文件时读取一次目标的checksums.txt
+	var bundle map[string]string
+	if !hasSidecars(cloned) {
+		if bundle, err = bm.loadChecksumBundle(ctx, dstPath); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedArchiveNames(metadata.Checksums) {
+		expected := metadata.Checksums[name]
+		object := archiveObject(metadata, name)
+		mismatch := models.VerifyMismatch{Archive: name, Expected: expected}
+		dstSize, ok := dstSizes[object]
+		switch {
+		case !ok:
+			mismatch.Error = "archive missing at destination"
+		case dstSize != srcSizes[object]:
+			mismatch.Error = fmt.Sprintf("size %d differs from source size %d", dstSize, srcSizes[object])
+		default:
+			actual, err := bm.clonedChecksum(ctx, dstPath, object, bundle)
+			if err != nil {
+				mismatch.Error = fmt.Sprintf("failed to read checksum file: %v", remoteError(err))
+			} else if !strings.EqualFold(actual, expected) {
+				mismatch.Actual = actual
+			} else {
+				result.VerifiedArchives++
+				continue
+			}
+		}
+		logger.Error(fmt.Sprintf("复制的压缩包校验失败: %s", name))
+		result.Mismatches = append(result.Mismatches, mismatch)
+	}
+	return nil
+}
+
+// clonedChecksum 返回目标记录的压缩包校验和，bundle不为nil时从汇总校验和文件中查找
+func (bm *BackupManager) clonedChecksum(ctx context.Context, dstPath, object string, bundle map[string]string) (string, error) {
+	if bundle == nil {
+		return bm.getRemoteChecksum(ctx, filepath.Join(dstPath, Sha256DirName, object+".sha256"))
+	}
+	if checksum, exists := bundle[object]; exists {
+		return checksum, nil
+	}
+	return "", fmt.Errorf("no entry for %s in %s", object, ChecksumBundleName)
+}
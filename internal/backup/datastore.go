@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// pathDatastorePrefix 按chunk路径计算的datastore标识的前缀
+const pathDatastorePrefix = "path-"
+
+// datastoreID 返回当前备份的datastore标识。
+// 用户指定了DatastoreID时直接使用，否则使用chunk目录绝对路径的指纹。
+func (bm *BackupManager) datastoreID() (string, error) {
+	if bm.config.DatastoreID != "" {
+		return bm.config.DatastoreID, nil
+	}
+	return PathDatastoreID(bm.config.ChunkPath)
+}
+
+// PathDatastoreID 返回chunk目录绝对路径的指纹，作为未指定DatastoreID时的datastore标识
+func PathDatastoreID(chunkPath string) (string, error) {
+	absPath, err := filepath.Abs(chunkPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve chunk path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		absPath = resolved
+	}
+
+	sum := sha256.Sum256([]byte(absPath))
+	return pathDatastorePrefix + hex.EncodeToString(sum[:8]), nil
+}
+
+// checkDatastore 确认增量备份的datastore与上次备份一致。
+// 上次的前缀位数超过本次的HexDigits时无法按原方式分组，在扫描前报错；
+// 旧元数据没有记录标识时视为一致；上次使用路径指纹而本次首次指定了--datastore-id时（如移动datastore后）
+// 接受并改为记录指定的标识；其他不一致且未设置Force时返回ErrDatastoreMismatch。
+func (bm *BackupManager) checkDatastore(metadata *models.BackupMetadata, currentID string) error {
+	if err := archiver.ValidatePrefixDigits(metadata.PrefixDigits, bm.config.HexDigits); err != nil {
+		return fmt.Errorf("previous backup cannot be grouped with the configured --hex-digits: %w", err)
+	}
+	if metadata.DatastoreID == "" {
+		logger.Info(fmt.Sprintf("上次备份未记录datastore标识，本次记录为 %s", currentID))
+		return nil
+	}
+	if metadata.DatastoreID == currentID {
+		return nil
+	}
+	if strings.HasPrefix(metadata.DatastoreID, pathDatastorePrefix) && !strings.HasPrefix(currentID, pathDatastorePrefix) {
+		logger.Info(fmt.Sprintf("上次备份使用chunk路径指纹 %s 作为datastore标识，本次起改为指定的 %s", metadata.DatastoreID, currentID))
+		return nil
+	}
+
+	if bm.config.Force {
+		logger.Warn(fmt.Sprintf("datastore标识不一致（上次 %s，本次 %s），已使用--force继续", metadata.DatastoreID, currentID))
+		return nil
+	}
+	return fmt.Errorf("%w: previous %s, current %s (use --datastore-id to keep the identifier after moving the datastore, or --force to override)",
+		ErrDatastoreMismatch, metadata.DatastoreID, currentID)
+}
+
+// requireChunkDirectories 确认chunk路径下至少有一个chunk目录。路径存在但没有符合命名规则的目录几乎总是配置错误
+// （如指向了datastore根目录而不是.chunks），继续备份会上传空的文件树，增量备份还会把所有目录当作已删除。
+// 设置AllowEmpty时只记录警告
+func (bm *BackupManager) requireChunkDirectories() error {
+	directories, err := bm.scanner.GetChunkDirectories()
+	if err != nil {
+		return fmt.Errorf("failed to get chunk directories: %w", err)
+	}
+	if len(directories) > 0 {
+		return nil
+	}
+	if bm.config.AllowEmpty {
+		logger.Warn(fmt.Sprintf("chunk路径 %s 下没有chunk目录，已使用--allow-empty继续", bm.config.ChunkPath))
+		return nil
+	}
+	return fmt.Errorf("%w in %s (check --chunk-path, --hex-digits and --include-prefix, or use --allow-empty)", ErrNoChunkDirectories, bm.config.ChunkPath)
+}
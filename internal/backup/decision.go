@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"pbs-backuper/internal/logger"
+)
+
+// DefaultDecisionConcurrency 默认同时读取的远程校验和文件数
+const DefaultDecisionConcurrency = 8
+
+// remoteChecksum 预先读取的远程校验和文件内容，读取失败时err不为nil
+type remoteChecksum struct {
+	checksum string
+	err      error
+}
+
+// remoteChecksumCache 按对象名缓存预先读取的远程校验和，多个goroutine同时写入
+type remoteChecksumCache struct {
+	mu   sync.Mutex
+	sums map[string]remoteChecksum
+}
+
+func (c *remoteChecksumCache) get(object string) (remoteChecksum, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sum, exists := c.sums[object]
+	return sum, exists
+}
+
+func (c *remoteChecksumCache) set(object string, sum remoteChecksum) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sums[object] = sum
+}
+
+// decisionConcurrency 返回判断压缩包是否需要上传时同时读取的远程校验和文件数
+func (bm *BackupManager) decisionConcurrency() int {
+	if bm.config.DecisionWorkers > 0 {
+		return bm.config.DecisionWorkers
+	}
+	return DefaultDecisionConcurrency
+}
+
+// prefetchRemoteChecksums 并发读取objects（需要处理的分组上次上传的对象）的远程校验和文件，结果缓存在bm.remoteSums中。
+// 之后逐个处理分组时与本地压缩包比较不再等待远程读取，分组很多而实际变化很少时判断阶段不再受往返延迟限制。
+// 读取失败只记录在缓存中，处理该分组时按没有远程校验和处理（重新上传）。
+// bundle为true（上次备份上传了汇总校验和文件）时只读取一次checksums.txt，读取失败时退回逐个读取
+func (bm *BackupManager) prefetchRemoteChecksums(ctx context.Context, objects []string, bundle bool) {
+	cache := &remoteChecksumCache{sums: make(map[string]remoteChecksum, len(objects))}
+	bm.remoteSums = cache
+	if len(objects) == 0 {
+		return
+	}
+
+	if bundle {
+		sums, err := bm.loadChecksumBundle(ctx, bm.config.RemotePath)
+		if err == nil {
+			for _, object := range objects {
+				if checksum, exists := sums[object]; exists {
+					cache.set(object, remoteChecksum{checksum: checksum})
+				} else {
+					cache.set(object, remoteChecksum{err: fmt.Errorf("no entry for %s in %s", object, ChecksumBundleName)})
+				}
+			}
+			return
+		}
+		logger.Warn(fmt.Sprintf("读取汇总校验和文件失败，逐个读取校验和文件: %v", err))
+	}
+
+	logger.Debug(fmt.Sprintf("Prefetching %d remote checksums with concurrency %d", len(objects), bm.decisionConcurrency()))
+	limiter := newFixedLimiter(bm.decisionConcurrency())
+	var wg sync.WaitGroup
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		limiter.acquire()
+		go func(object string) {
+			defer wg.Done()
+			defer limiter.release(0, false)
+
+			checksum, err := bm.getRemoteChecksum(ctx, filepath.Join(bm.config.RemotePath, Sha256DirName, object+".sha256"))
+			cache.set(object, remoteChecksum{checksum: checksum, err: err})
+		}(object)
+	}
+	wg.Wait()
+}
+
+// previousChecksum 返回对象在远程的校验和，优先使用预先读取的结果
+func (bm *BackupManager) previousChecksum(ctx context.Context, object string) (string, error) {
+	if bm.remoteSums != nil {
+		if sum, exists := bm.remoteSums.get(object); exists {
+			return sum.checksum, sum.err
+		}
+	}
+	return bm.getRemoteChecksum(ctx, filepath.Join(bm.config.RemotePath, Sha256DirName, object+".sha256"))
+}
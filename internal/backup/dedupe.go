@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// assignDedupedFiles 找出文件树中内容重复的文件，按所属分组设置group.Deduped
+func assignDedupedFiles(groups []*models.ArchiveGroup, fileTree map[string]*models.FileTreeNode) {
+	groupByDir := make(map[string]*models.ArchiveGroup)
+	for _, group := range groups {
+		group.Deduped = nil
+		for _, dir := range group.Directories {
+			groupByDir[dir] = group
+		}
+	}
+
+	for filePath, hash := range scanner.DuplicateFiles(fileTree) {
+		group, exists := groupByDir[strings.SplitN(filePath, "/", 2)[0]]
+		if !exists {
+			continue
+		}
+		node := lookupNode(fileTree, filePath)
+		group.Deduped = append(group.Deduped, models.DedupeEntry{
+			Path:    filePath,
+			Hash:    hash,
+			Size:    node.Size,
+			ModTime: node.ModTime,
+		})
+	}
+
+	for _, group := range groups {
+		sort.Slice(group.Deduped, func(i, j int) bool {
+			return group.Deduped[i].Path < group.Deduped[j].Path
+		})
+	}
+}
+
+// lookupNode 按相对路径（正斜杠分隔）在文件树中查找节点，不存在时返回nil
+func lookupNode(fileTree map[string]*models.FileTreeNode, relPath string) *models.FileTreeNode {
+	parts := strings.Split(relPath, "/")
+	node, exists := fileTree[parts[0]]
+	for _, part := range parts[1:] {
+		if !exists {
+			return nil
+		}
+		node, exists = node.Children[part]
+	}
+	if !exists {
+		return nil
+	}
+	return node
+}
+
+// uploadBlobs 上传分组中去重文件的内容，远程已存在的blob不再上传
+func (bm *BackupManager) uploadBlobs(ctx context.Context, entries []models.DedupeEntry, result *models.BackupResult) error {
+	uploaded := make(map[string]bool)
+	for _, entry := range entries {
+		if uploaded[entry.Hash] {
+			continue
+		}
+
+		remotePath := filepath.Join(bm.config.RemotePath, BlobDirName, entry.Hash)
+		exists, err := bm.storage.FileExists(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to check blob existence: %w", remoteError(err))
+		}
+		if !exists {
+			// 扫描后文件可能被修改，上传前确认内容仍与记录的哈希一致
+			localPath := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(entry.Path))
+			actual, err := bm.archiver.CalculateChecksum(localPath)
+			if err != nil {
+				return err
+			}
+			if actual != entry.Hash {
+				return fmt.Errorf("file %s changed during backup", entry.Path)
+			}
+
+			logger.Debug(fmt.Sprintf("Uploading blob %s for %s", entry.Hash, entry.Path))
+			if err := bm.uploadFile(ctx, localPath, remotePath, entry.Size); err != nil {
+				return fmt.Errorf("failed to upload blob for %s: %w", entry.Path, err)
+			}
+			result.UploadedFiles = append(result.UploadedFiles, BlobDirName+"/"+entry.Hash)
+		}
+		uploaded[entry.Hash] = true
+	}
+	return nil
+}
+
+// restoreDedupedFiles 从blob目录恢复压缩包中省略的文件，match为nil时恢复全部。
+// 写入文件会改变父目录的修改时间，因此完成后按文件树重新设置父目录的修改时间。
+func (bm *BackupManager) restoreDedupedFiles(ctx context.Context, metadata *models.BackupMetadata, entries []models.DedupeEntry, match func(name string) bool) (int, error) {
+	count := 0
+	parents := make(map[string]bool)
+
+	for _, entry := range entries {
+		if match != nil && !match(entry.Path) {
+			continue
+		}
+
+		localPath := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(entry.Path))
+		remotePath := filepath.Join(bm.config.RemotePath, BlobDirName, entry.Hash)
+		if err := archiver.RemoveStaleType(bm.config.ChunkPath, localPath, false); err != nil {
+			return count, err
+		}
+		if err := bm.storage.DownloadFile(ctx, remotePath, localPath); err != nil {
+			return count, fmt.Errorf("failed to download blob for %s: %w", entry.Path, remoteError(err))
+		}
+
+		actual, err := bm.archiver.CalculateChecksum(localPath)
+		if err != nil {
+			return count, err
+		}
+		if actual != entry.Hash {
+			return count, &ChecksumError{Name: entry.Path, Expected: entry.Hash, Actual: actual}
+		}
+
+		if err := os.Chtimes(localPath, entry.ModTime, entry.ModTime); err != nil {
+			return count, fmt.Errorf("failed to set modification time for %s: %w", entry.Path, err)
+		}
+
+		parents[filepath.ToSlash(filepath.Dir(filepath.FromSlash(entry.Path)))] = true
+		count++
+	}
+
+	if len(parents) == 0 {
+		return count, nil
+	}
+	fileTree, err := bm.loadFileTree(ctx, metadata)
+	if err != nil {
+		return count, err
+	}
+	for parent := range parents {
+		node := lookupNode(fileTree, parent)
+		if node == nil {
+			continue
+		}
+		localPath := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(parent))
+		if err := os.Chtimes(localPath, node.ModTime, node.ModTime); err != nil {
+			return count, fmt.Errorf("failed to set modification time for %s: %w", parent, err)
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,210 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// DescribeBackup 流式读取远程备份元数据，返回压缩包数、目录和文件数、总大小等摘要。
+// entry不为空时同时检查该路径（相对chunk目录，文件或目录）是否在文件树中。
+// 元数据和拆分保存的文件树都边读边统计，同一时刻只在内存中保留一个chunk目录的节点，
+// 不构建完整的文件树，适合在很大的datastore上执行只读查询
+func (bm *BackupManager) DescribeBackup(ctx context.Context, entry string) (*models.BackupDescription, error) {
+	startTime := time.Now()
+	remotePath := filepath.Join(bm.metadataDir(), MetadataFileName)
+	exists, err := bm.storage.FileExists(ctx, remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check metadata file existence: %w", remoteError(err))
+	}
+	if !exists {
+		return nil, ErrNoMetadata
+	}
+	expected, err := bm.metadataChecksum(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := newTreeSummary(entry)
+	var treeFile, treeSHA256 string
+	err = bm.readJSONFile(ctx, remotePath, expected, func(r io.Reader) error {
+		return describeMetadata(json.NewDecoder(r), summary, &treeFile, &treeSHA256)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	// 文件树拆分保存时再流式读取文件树对象
+	if treeFile != "" {
+		treePath := filepath.Join(bm.config.RemotePath, filepath.FromSlash(treeFile))
+		err := bm.readJSONFile(ctx, treePath, treeSHA256, func(r io.Reader) error {
+			return summary.addTree(json.NewDecoder(r))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file tree %s: %w", treeFile, err)
+		}
+	}
+
+	summary.desc.Duration = time.Since(startTime)
+	return summary.desc, nil
+}
+
+// describeMetadata 逐个字段读取元数据对象：摘要需要的字段解码，文件树逐个目录统计，
+// 压缩包只计数，其余字段跳过而不解码
+func describeMetadata(decoder *json.Decoder, summary *treeSummary, treeFile, treeSHA256 *string) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	desc := summary.desc
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var field error
+		switch token {
+		case "backup_time":
+			field = decoder.Decode(&desc.BackupTime)
+		case "datastore_id":
+			field = decoder.Decode(&desc.DatastoreID)
+		case "namespace":
+			field = decoder.Decode(&desc.Namespace)
+		case "tree_file":
+			field = decoder.Decode(treeFile)
+		case "tree_sha256":
+			field = decoder.Decode(treeSHA256)
+		case "checksums":
+			desc.Archives, field = countObjectEntries(decoder)
+		case "file_tree":
+			field = summary.addTree(decoder)
+		default:
+			field = skipJSONValue(decoder)
+		}
+		if field != nil {
+			return fmt.Errorf("failed to read field %v: %w", token, field)
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// treeSummary 逐个chunk目录累计文件树的统计
+type treeSummary struct {
+	desc   *models.BackupDescription
+	target []string // 要查找的路径按/拆分后的各级名称，为空时不查找
+}
+
+func newTreeSummary(entry string) *treeSummary {
+	s := &treeSummary{desc: &models.BackupDescription{}}
+	if entry != "" {
+		s.desc.Path = entryPath(entry)
+		s.target = strings.Split(s.desc.Path, "/")
+	}
+	return s
+}
+
+// addTree 从decoder的当前位置逐个读取文件树的chunk目录并累计
+func (s *treeSummary) addTree(decoder *json.Decoder) error {
+	tree, err := scanner.FileTreeDecoderFrom(decoder)
+	if err != nil {
+		return err
+	}
+	for {
+		name, node, err := tree.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.add(name, node)
+	}
+}
+
+// add 累计一个chunk目录，读完即可丢弃节点
+func (s *treeSummary) add(name string, node *models.FileTreeNode) {
+	s.desc.Directories++
+	var walk func(node *models.FileTreeNode)
+	walk = func(node *models.FileTreeNode) {
+		if !node.IsDir {
+			s.desc.Files++
+			s.desc.TotalSize += node.Size
+			return
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	if len(s.target) == 0 || s.target[0] != name {
+		return
+	}
+	for _, part := range s.target[1:] {
+		if node = node.Children[part]; node == nil {
+			return
+		}
+	}
+	s.desc.PathFound = true
+}
+
+// countObjectEntries 读取一个JSON对象（或null）并返回其中的条目数，值不解码
+func countObjectEntries(decoder *json.Decoder) (int, error) {
+	token, err := decoder.Token()
+	if err != nil || token == nil {
+		return 0, err
+	}
+	if token != json.Delim('{') {
+		return 0, fmt.Errorf("unexpected token %v", token)
+	}
+	count := 0
+	for decoder.More() {
+		if _, err := decoder.Token(); err != nil {
+			return 0, err
+		}
+		if err := skipJSONValue(decoder); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	_, err = decoder.Token()
+	return count, err
+}
+
+// skipJSONValue 跳过decoder当前位置的一个JSON值（包括嵌套的对象和数组）
+func skipJSONValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// expectDelim 读取下一个token并确认是指定的分隔符
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("unexpected token %v, expected %v", token, delim)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/storage"
+)
+
+const (
+	// MetadataChecksumSuffix 元数据校验和文件的后缀，与元数据保存在同一目录
+	MetadataChecksumSuffix = ".sha256"
+
+	// jsonDownloadAttempts 下载元数据和文件树的最大尝试次数
+	jsonDownloadAttempts = 3
+)
+
+// jsonRetryDelay 下载元数据失败后第一次重试前的等待时间，之后每次递增
+var jsonRetryDelay = 2 * time.Second
+
+// loadJSONFile 将远程JSON文件下载到临时文件后流式解码到v，不在内存中保留原始内容。
+// 下载失败时重试，存储支持断点续传时从已下载的位置继续；expected不为空时校验SHA256，
+// 不一致的内容丢弃后重新完整下载
+func (bm *BackupManager) loadJSONFile(ctx context.Context, remotePath, expected string, v interface{}) error {
+	return bm.readJSONFile(ctx, remotePath, expected, func(r io.Reader) error {
+		if err := json.NewDecoder(r).Decode(v); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", remotePath, err)
+		}
+		return nil
+	})
+}
+
+// readJSONFile 与loadJSONFile相同地下载并校验远程文件，然后把本地临时文件的内容交给read处理，
+// 供需要边读边处理的调用方使用。read返回后临时文件即被删除
+func (bm *BackupManager) readJSONFile(ctx context.Context, remotePath, expected string, read func(r io.Reader) error) error {
+	if err := os.MkdirAll(bm.config.TempPath, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	file, err := os.CreateTemp(bm.config.TempPath, "download-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	localPath := file.Name()
+	file.Close()
+	bm.tempFiles.track(localPath)
+	defer bm.tempFiles.remove(localPath)
+
+	if err := bm.downloadVerified(ctx, remotePath, localPath, expected); err != nil {
+		return err
+	}
+
+	file, err = os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded file: %w", err)
+	}
+	defer file.Close()
+
+	return read(bufio.NewReader(file))
+}
+
+// downloadVerified 带重试和续传地下载远程文件并校验SHA256
+func (bm *BackupManager) downloadVerified(ctx context.Context, remotePath, localPath, expected string) error {
+	resumer, resumable := bm.storage.(storage.ResumableDownloader)
+
+	var lastErr error
+	for attempt := 1; attempt <= jsonDownloadAttempts; attempt++ {
+		if attempt > 1 {
+			logger.Warn(fmt.Sprintf("下载 %s 失败，第%d次重试: %v", remotePath, attempt-1, lastErr))
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("failed to download %s: %w", remotePath, ctx.Err())
+			case <-time.After(time.Duration(attempt-1) * jsonRetryDelay):
+			}
+		}
+
+		var offset int64
+		if info, err := os.Stat(localPath); err == nil {
+			offset = info.Size()
+		}
+
+		var err error
+		if resumable && offset > 0 {
+			logger.Debug(fmt.Sprintf("Resuming download of %s at offset %d", remotePath, offset))
+			err = resumer.DownloadFileFrom(ctx, remotePath, localPath, offset)
+		} else {
+			err = bm.storage.DownloadFile(ctx, remotePath, localPath)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("failed to download %s: %w", remotePath, ctx.Err())
+			}
+			lastErr = remoteError(err)
+			continue
+		}
+
+		if expected == "" {
+			return nil
+		}
+		actual, err := bm.archiver.CalculateChecksum(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to calculate checksum: %w", err)
+		}
+		if actual == expected {
+			return nil
+		}
+
+		// 内容与校验和不一致（续传期间远程文件被替换或传输损坏），丢弃后重新完整下载
+		lastErr = &ChecksumError{Name: remotePath, Expected: expected, Actual: actual}
+		if err := os.Truncate(localPath, 0); err != nil {
+			return fmt.Errorf("failed to reset downloaded file: %w", err)
+		}
+	}
+	return fmt.Errorf("failed to download %s after %d attempts: %w", remotePath, jsonDownloadAttempts, lastErr)
+}
@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 备份包导出的错误类别，调用方可以用errors.Is区分失败原因，决定退出码或是否重试
+var (
+	// ErrNoMetadata 远程不存在备份元数据（尚未执行过全量备份）
+	ErrNoMetadata = errors.New("no previous backup metadata found, use full backup mode")
+
+	// ErrDatastoreMismatch 增量备份的chunk目录与上次备份的datastore不一致
+	ErrDatastoreMismatch = errors.New("datastore does not match previous backup")
+
+	// ErrNamespaceMismatch 远程备份元数据记录的命名空间与配置的命名空间不一致
+	ErrNamespaceMismatch = errors.New("namespace does not match previous backup")
+
+	// ErrRemoteUnavailable 远程存储操作失败（网络、认证、远程文件缺失等），通常可以重试
+	ErrRemoteUnavailable = errors.New("remote storage unavailable")
+
+	// ErrChecksumMismatch 下载的文件与记录的SHA256不一致
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrPartialFailure 备份完成但部分压缩包处理失败
+	ErrPartialFailure = errors.New("some archives failed")
+
+	// ErrCorruptSource 备份前抽样校验发现源chunk已损坏
+	ErrCorruptSource = errors.New("corrupt source chunks")
+
+	// ErrTimeBudget 达到最长运行时间，部分压缩包组推迟到下次运行
+	ErrTimeBudget = errors.New("time budget exhausted")
+
+	// ErrNoChunkDirectories chunk路径存在但没有任何符合命名规则的chunk目录，通常是路径配置错误
+	ErrNoChunkDirectories = errors.New("no chunk directories found")
+
+	// ErrObjectTooLarge 压缩包超过远程单个对象的大小上限，上传必然失败
+	ErrObjectTooLarge = errors.New("archive exceeds remote object size limit")
+)
+
+// ChecksumError 文件校验和不一致，errors.Is(err, ErrChecksumMismatch)为true
+type ChecksumError struct {
+	Name     string // 压缩包名或文件路径
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.Name, e.Expected, e.Actual)
+}
+
+// Is 使ChecksumError匹配ErrChecksumMismatch
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// CorruptSourceError 源chunk损坏而中止备份，errors.Is(err, ErrCorruptSource)为true
+type CorruptSourceError struct {
+	Chunks []string // 损坏的chunk，相对chunk目录的路径
+}
+
+func (e *CorruptSourceError) Error() string {
+	return fmt.Sprintf("%d corrupt source chunks found, backup aborted: %s", len(e.Chunks), strings.Join(e.Chunks, ", "))
+}
+
+// Is 使CorruptSourceError匹配ErrCorruptSource
+func (e *CorruptSourceError) Is(target error) bool {
+	return target == ErrCorruptSource
+}
+
+// PartialFailureError 部分压缩包处理失败，errors.Is(err, ErrPartialFailure)为true。
+// 返回该错误时备份结果仍然有效，元数据已经上传，失败的压缩包会在下次增量备份时重新处理
+type PartialFailureError struct {
+	Failed []string // 处理失败的压缩包
+}
+
+func (e *PartialFailureError) Error() string {
+	return fmt.Sprintf("%d archives failed: %s", len(e.Failed), strings.Join(e.Failed, ", "))
+}
+
+// Is 使PartialFailureError匹配ErrPartialFailure
+func (e *PartialFailureError) Is(target error) bool {
+	return target == ErrPartialFailure
+}
+
+// TimeBudgetError 达到最长运行时间后停止开始新的压缩包组，errors.Is(err, ErrTimeBudget)为true，
+// 同时也匹配ErrPartialFailure：已完成的压缩包和元数据都已上传，推迟的分组在下次增量备份时处理
+type TimeBudgetError struct {
+	Deferred []string // 未开始处理的压缩包
+	Failed   []string // 处理失败的压缩包
+}
+
+func (e *TimeBudgetError) Error() string {
+	msg := fmt.Sprintf("time budget exhausted, %d archives deferred to the next run: %s", len(e.Deferred), strings.Join(e.Deferred, ", "))
+	if len(e.Failed) > 0 {
+		msg += fmt.Sprintf("; %d archives failed: %s", len(e.Failed), strings.Join(e.Failed, ", "))
+	}
+	return msg
+}
+
+// Is 使TimeBudgetError匹配ErrTimeBudget和ErrPartialFailure
+func (e *TimeBudgetError) Is(target error) bool {
+	return target == ErrTimeBudget || target == ErrPartialFailure
+}
+
+// remoteError 将存储后端返回的错误归类为ErrRemoteUnavailable，nil、上下文取消或超时保持原样
+func remoteError(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrRemoteUnavailable, err)
+}
+
+// partialFailure 根据备份结果返回PartialFailureError，没有失败的压缩包时返回nil
+func partialFailure(failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &PartialFailureError{Failed: failed}
+}
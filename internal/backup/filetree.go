@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+const (
+	// FileTreeDirName 拆分保存的文件树所在目录，启用SplitFileTree时使用
+	FileTreeDirName = "filetree"
+
+	fileTreeFilePrefix = "filetree-"
+	fileTreeTimeLayout = "20060102T150405.000000000Z"
+)
+
+// fileTreeFileName 返回备份时间对应的文件树对象路径（相对RemotePath）。
+// 每次备份使用新名称，主元数据上传失败时旧元数据引用的文件树不会被覆盖
+func fileTreeFileName(backupTime time.Time) string {
+	return path.Join(FileTreeDirName, fileTreeFilePrefix+backupTime.UTC().Format(fileTreeTimeLayout)+".json")
+}
+
+// loadFileTree 返回元数据的文件树。文件树拆分保存时在第一次需要时才下载，
+// 只需要校验和的操作（verify、prune、恢复压缩包）不会下载文件树
+func (bm *BackupManager) loadFileTree(ctx context.Context, metadata *models.BackupMetadata) (map[string]*models.FileTreeNode, error) {
+	if metadata.FileTree != nil || metadata.FileTreeFile == "" {
+		return metadata.FileTree, nil
+	}
+
+	remotePath := filepath.Join(bm.config.RemotePath, filepath.FromSlash(metadata.FileTreeFile))
+	fileTree := make(map[string]*models.FileTreeNode)
+	if err := bm.loadJSONFile(ctx, remotePath, metadata.FileTreeSHA256, &fileTree); err != nil {
+		return nil, fmt.Errorf("failed to load file tree %s: %w", metadata.FileTreeFile, err)
+	}
+
+	metadata.FileTree = fileTree
+	return fileTree, nil
+}
+
+// uploadFileTree 单独上传文件树，返回不含文件树、改为引用文件树对象的元数据副本
+func (bm *BackupManager) uploadFileTree(ctx context.Context, metadata *models.BackupMetadata) (*models.BackupMetadata, error) {
+	data, err := json.Marshal(metadata.FileTree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal file tree: %w", err)
+	}
+
+	name := fileTreeFileName(metadata.BackupTime)
+	localPath := filepath.Join(bm.config.TempPath, path.Base(name))
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save local file tree: %w", err)
+	}
+	defer os.Remove(localPath)
+
+	if err := bm.storage.UploadFile(ctx, localPath, filepath.Join(bm.config.RemotePath, filepath.FromSlash(name))); err != nil {
+		return nil, fmt.Errorf("failed to upload file tree: %w", remoteError(err))
+	}
+
+	sum := sha256.Sum256(data)
+	split := *metadata
+	split.FileTree = nil
+	split.FileTreeFile = name
+	split.FileTreeSHA256 = hex.EncodeToString(sum[:])
+	return &split, nil
+}
+
+// cleanupFileTrees 删除不再被引用的文件树对象：当前元数据、.prev和历史快照引用的文件树都保留，
+// 回退到.prev或按快照恢复时仍能读取文件树。
+// 主元数据已经上传成功，清理失败只记录警告，残留的对象在下次备份时再次清理
+func (bm *BackupManager) cleanupFileTrees(ctx context.Context, current string) {
+	referenced, err := bm.referencedFileTrees(ctx, current)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("无法确定仍被引用的文件树，本次不清理: %v", err))
+		return
+	}
+
+	dir := filepath.Join(bm.config.RemotePath, FileTreeDirName)
+	files, err := bm.storage.ListFiles(ctx, dir)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("列出文件树目录失败: %v", err))
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir || !strings.HasPrefix(file.Name, fileTreeFilePrefix) || referenced[path.Join(FileTreeDirName, file.Name)] {
+			continue
+		}
+		if err := bm.storage.DeleteFile(ctx, filepath.Join(dir, file.Name)); err != nil {
+			logger.Warn(fmt.Sprintf("删除旧文件树 %s 失败: %v", file.Name, err))
+		}
+	}
+}
+
+// referencedFileTrees 返回仍被引用的文件树对象（相对RemotePath）：current、.prev和历史快照引用的文件树
+func (bm *BackupManager) referencedFileTrees(ctx context.Context, current string) (map[string]bool, error) {
+	referenced := map[string]bool{current: true}
+
+	prev, err := bm.prevMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && prev.FileTreeFile != "" {
+		referenced[prev.FileTreeFile] = true
+	}
+
+	historyDir := filepath.Join(bm.config.RemotePath, HistoryDirName)
+	files, err := bm.storage.ListFiles(ctx, historyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata history: %w", remoteError(err))
+	}
+	for _, file := range files {
+		if _, ok := parseHistoryFileName(file.Name); file.IsDir || !ok {
+			continue
+		}
+		metadata, err := bm.loadMetadataFile(ctx, filepath.Join(historyDir, file.Name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", file.Name, err)
+		}
+		if metadata.FileTreeFile != "" {
+			referenced[metadata.FileTreeFile] = true
+		}
+	}
+	return referenced, nil
+}
@@ -0,0 +1,272 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// gcDirs gc列出并核对的远程目录，元数据、历史快照和状态文件不在其中
+var gcDirs = []string{ChunkDirName, Sha256DirName, IndexDirName, BlobDirName, FileTreeDirName}
+
+// GCOptions gc的执行选项
+type GCOptions struct {
+	Retention *RetentionPolicy // 非nil时先按保留策略清理历史快照，再核对剩余的引用
+	MinAge    time.Duration    // 删除保护期，修改时间在保护期内的孤立对象本次不删除
+	Repair    bool             // 用chunk目录重新生成当前元数据引用但远程缺失的压缩包
+	DryRun    bool             // 只报告，不删除、上传或改写任何远程文件
+}
+
+// RunGC 全面核对远程存储：列出压缩包、校验和、索引、blob和文件树目录中的全部对象，
+// 与当前元数据、.prev和保留的历史快照的引用比对。没有被引用的对象（包括中断的上传留下的临时文件）删除；
+// 当前元数据引用但远程不存在的压缩包在Repair时用chunk目录重新生成；
+// 校验和文件缺失或内容与元数据不一致（格式不规范或值不同）时按元数据和配置的格式重新上传，汇总校验和文件同样核对。
+// 只被历史快照引用的缺失压缩包无法从当前数据恢复，只报告
+func (bm *BackupManager) RunGC(ctx context.Context, opts GCOptions) (*models.GCResult, error) {
+	defer bm.tempFiles.guard(ctx)()
+
+	startTime := time.Now()
+	if opts.MinAge < 0 {
+		return nil, fmt.Errorf("minimum age must not be negative")
+	}
+	result := &models.GCResult{DryRun: opts.DryRun}
+
+	// 保留策略清理的快照不再计入引用；预览时它们和将删除的对象仍在远程，不重复报告为孤立对象
+	pruned := make(map[string]bool)
+	handled := make(map[string]bool)
+	if opts.Retention != nil {
+		prune, err := bm.RunPrune(ctx, *opts.Retention, opts.DryRun)
+		if err != nil {
+			return nil, err
+		}
+		result.Prune = prune
+		for _, name := range prune.PrunedSnapshots {
+			pruned[name] = true
+		}
+		for _, object := range prune.DeletedArchives {
+			for _, file := range archiveFiles(object, nil) {
+				handled[file] = true
+			}
+		}
+		for _, hash := range prune.DeletedBlobs {
+			handled[path.Join(BlobDirName, hash)] = true
+		}
+	}
+
+	// 元数据无法加载时无从判断哪些对象被引用，不删除任何文件
+	current, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup metadata: %w", err)
+	}
+
+	refs := make(map[string]bool)
+	historyObjects := make(map[string]bool)
+	addRefs := func(metadata *models.BackupMetadata) {
+		for name := range metadata.Checksums {
+			for _, file := range archiveFiles(archiveObject(metadata, name), metadata.Sidecars[name]) {
+				refs[file] = true
+			}
+		}
+		for _, entries := range metadata.Dedupe {
+			for _, entry := range entries {
+				refs[path.Join(BlobDirName, entry.Hash)] = true
+			}
+		}
+		if metadata.FileTreeFile != "" {
+			refs[metadata.FileTreeFile] = true
+		}
+	}
+	addRefs(current)
+
+	// .prev引用的对象同样保留，回退到.prev后仍能恢复
+	prev, err := bm.prevMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		addRefs(prev)
+	}
+
+	historyDir := filepath.Join(bm.config.RemotePath, HistoryDirName)
+	files, err := bm.storage.ListFiles(ctx, historyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata history: %w", remoteError(err))
+	}
+	for _, file := range files {
+		if file.IsDir || pruned[file.Name] {
+			continue
+		}
+		if _, ok := parseHistoryFileName(file.Name); !ok {
+			continue
+		}
+		metadata, err := bm.loadMetadataFile(ctx, filepath.Join(historyDir, file.Name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", file.Name, err)
+		}
+		addRefs(metadata)
+		for name := range metadata.Checksums {
+			historyObjects[archiveObject(metadata, name)] = true
+		}
+		result.Snapshots++
+	}
+
+	// 删除没有被引用的对象
+	present := make(map[string]bool)
+	cutoff := startTime.Add(-opts.MinAge)
+	for _, dir := range gcDirs {
+		files, err := bm.storage.ListFiles(ctx, filepath.Join(bm.config.RemotePath, dir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, remoteError(err))
+		}
+		for _, file := range files {
+			if file.IsDir {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("gc cancelled: %w", err)
+			}
+			name := path.Join(dir, file.Name)
+			present[name] = true
+			result.ScannedObjects++
+			if refs[name] || handled[name] {
+				continue
+			}
+			if opts.MinAge > 0 && file.ModTime.After(cutoff) {
+				logger.Info(fmt.Sprintf("孤立对象 %s 在保护期内，本次不删除", name))
+				result.ProtectedOrphans = append(result.ProtectedOrphans, name)
+				continue
+			}
+			if err := bm.deleteRemote(ctx, filepath.Join(bm.config.RemotePath, filepath.FromSlash(name)), opts.DryRun); err != nil {
+				return nil, err
+			}
+			result.DeletedOrphans = append(result.DeletedOrphans, name)
+		}
+	}
+
+	// 检查被引用的压缩包是否存在
+	currentObjects := make(map[string]bool, len(current.Checksums))
+	for _, name := range sortedArchiveNames(current.Checksums) {
+		object := archiveObject(current, name)
+		currentObjects[object] = true
+		if !present[path.Join(ChunkDirName, object)] {
+			logger.Warn(fmt.Sprintf("当前元数据引用的压缩包 %s 在远程不存在", name))
+			result.MissingArchives = append(result.MissingArchives, name)
+		}
+	}
+	for object := range historyObjects {
+		if !currentObjects[object] && !present[path.Join(ChunkDirName, object)] {
+			logger.Warn(fmt.Sprintf("历史快照引用的压缩包 %s 在远程不存在，无法修复", object))
+			result.MissingHistory = append(result.MissingHistory, object)
+		}
+	}
+
+	repaired := make(map[string]bool)
+	if opts.Repair && len(result.MissingArchives) > 0 {
+		if opts.DryRun {
+			logger.Info(fmt.Sprintf("[dry-run] 将用chunk目录重新生成%d个缺失的压缩包", len(result.MissingArchives)))
+		} else {
+			mismatches := make([]models.VerifyMismatch, len(result.MissingArchives))
+			for i, name := range result.MissingArchives {
+				mismatches[i] = models.VerifyMismatch{Archive: name, Expected: current.Checksums[name], Error: "missing from remote"}
+			}
+			if err := bm.repairArchives(ctx, current, mismatches); err != nil {
+				return nil, err
+			}
+			for _, mismatch := range mismatches {
+				if mismatch.Repaired {
+					repaired[mismatch.Archive] = true
+					result.RepairedArchives = append(result.RepairedArchives, mismatch.Archive)
+				}
+			}
+		}
+	}
+
+	// 按元数据改写缺失或内容不一致的校验和文件，重新生成的压缩包已上传新的校验和文件；
+	// 只有汇总校验和文件时不补齐单独的校验和文件
+	for _, name := range sortedArchiveNames(current.Checksums) {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("gc cancelled: %w", err)
+		}
+		object := archiveObject(current, name)
+		if !hasSidecars(current) || repaired[name] || !present[path.Join(ChunkDirName, object)] {
+			continue
+		}
+		expected := current.Checksums[name]
+		checksumFile := path.Join(Sha256DirName, object+".sha256")
+		if present[checksumFile] {
+			content, err := bm.storage.GetFileContent(ctx, filepath.Join(bm.config.RemotePath, filepath.FromSlash(checksumFile)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", checksumFile, remoteError(err))
+			}
+			if string(content) == bm.checksumFileContent(expected, object) {
+				continue
+			}
+			if actual, err := parseChecksum(content, object); err != nil || actual != expected {
+				logger.Warn(fmt.Sprintf("校验和文件 %s 与元数据记录不一致，按元数据改写", checksumFile))
+			}
+		}
+
+		if opts.DryRun {
+			logger.Info(fmt.Sprintf("[dry-run] 将改写校验和文件: %s", checksumFile))
+		} else if err := bm.uploadChecksumFile(ctx, object, expected); err != nil {
+			return nil, fmt.Errorf("failed to rewrite %s: %w", checksumFile, err)
+		}
+		result.RewrittenChecksums = append(result.RewrittenChecksums, name)
+	}
+
+	if err := bm.reconcileChecksumBundle(ctx, current, opts.DryRun, result); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result.DeletedOrphans)
+	sort.Strings(result.ProtectedOrphans)
+	scanner.SortHex(result.MissingHistory)
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// reconcileChecksumBundle 核对RemotePath下的汇总校验和文件：元数据记录了汇总文件时，缺失或内容与元数据不一致则重新生成；
+// 没有记录时（已改回逐个保存校验和文件）远程残留的汇总文件作为孤立对象删除
+func (bm *BackupManager) reconcileChecksumBundle(ctx context.Context, metadata *models.BackupMetadata, dryRun bool, result *models.GCResult) error {
+	remotePath := filepath.Join(bm.config.RemotePath, ChecksumBundleName)
+	exists, err := bm.storage.FileExists(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", ChecksumBundleName, remoteError(err))
+	}
+
+	if !hasChecksumBundle(metadata) {
+		if !exists {
+			return nil
+		}
+		if err := bm.deleteRemote(ctx, remotePath, dryRun); err != nil {
+			return err
+		}
+		result.DeletedOrphans = append(result.DeletedOrphans, ChecksumBundleName)
+		return nil
+	}
+
+	if exists {
+		content, err := bm.storage.GetFileContent(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", ChecksumBundleName, remoteError(err))
+		}
+		if string(content) == bm.checksumBundleContent(metadata) {
+			return nil
+		}
+		logger.Warn(fmt.Sprintf("%s 与元数据记录不一致，按元数据改写", ChecksumBundleName))
+	}
+	if dryRun {
+		logger.Info(fmt.Sprintf("[dry-run] 将改写校验和文件: %s", ChecksumBundleName))
+	} else if err := bm.uploadChecksumBundle(ctx, metadata); err != nil {
+		return fmt.Errorf("failed to rewrite %s: %w", ChecksumBundleName, err)
+	}
+	result.RewrittenChecksums = append(result.RewrittenChecksums, ChecksumBundleName)
+	return nil
+}
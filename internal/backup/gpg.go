@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// GPGProcessorName 内置GPG签名处理器的名称
+	GPGProcessorName = "gpg"
+
+	// gpgSignatureSuffix GPG分离签名文件的后缀
+	gpgSignatureSuffix = ".sig"
+)
+
+// GPGSigner 使用gpg --detach-sign为压缩包生成分离签名的后处理器。
+// 签名需要私钥在本机的gpg密钥环中；校验只需要公钥，因此校验时不需要指定密钥
+type GPGSigner struct {
+	binary string // gpg二进制路径
+	key    string // 签名使用的密钥（--local-user），为空时只能校验
+}
+
+// NewGPGSigner 创建GPG签名处理器，binary为空时使用PATH中的gpg
+func NewGPGSigner(binary, key string) *GPGSigner {
+	if binary == "" {
+		binary = "gpg"
+	}
+	return &GPGSigner{binary: binary, key: key}
+}
+
+// Name 实现PostProcessor接口
+func (g *GPGSigner) Name() string {
+	return GPGProcessorName
+}
+
+// Process 实现PostProcessor接口 - 生成压缩包的二进制分离签名archivePath.sig
+func (g *GPGSigner) Process(ctx context.Context, archivePath string) (string, error) {
+	if g.key == "" {
+		return "", fmt.Errorf("gpg signing key is not configured")
+	}
+	signaturePath := archivePath + gpgSignatureSuffix
+	if err := g.run(ctx, "sign", "--local-user", g.key, "--output", signaturePath, "--detach-sign", archivePath); err != nil {
+		os.Remove(signaturePath)
+		return "", err
+	}
+	return signaturePath, nil
+}
+
+// Verify 实现PostProcessor接口 - 用gpg --verify校验分离签名
+func (g *GPGSigner) Verify(ctx context.Context, archivePath, sidecarPath string) error {
+	return g.run(ctx, "verify", "--verify", sidecarPath, archivePath)
+}
+
+// run 以批处理模式执行gpg，失败时在错误中附带操作名称和gpg的错误输出
+func (g *GPGSigner) run(ctx context.Context, action string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, g.binary, append([]string{"--batch", "--yes"}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gpg %s failed: %w: %s", action, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
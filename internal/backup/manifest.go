@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/models"
+)
+
+// RestoreManifestFileName 恢复清单文件名，与备份元数据放在同一目录
+const RestoreManifestFileName = "restore-manifest.json"
+
+// buildRestoreManifest 根据备份元数据生成恢复清单，列出按顺序恢复所需的压缩包、
+// 校验和以及可直接执行的命令，不依赖本工具解析元数据即可手动恢复
+func (bm *BackupManager) buildRestoreManifest(metadata *models.BackupMetadata) *models.RestoreManifest {
+	chunkPath := shellQuote(bm.config.ChunkPath)
+	manifest := &models.RestoreManifest{
+		Version:      MetadataVersion,
+		BackupTime:   metadata.BackupTime,
+		RemotePath:   bm.config.RemotePath,
+		ChunkPath:    bm.config.ChunkPath,
+		PrefixDigits: metadata.PrefixDigits,
+		Steps: []string{
+			"按archives顺序下载每个压缩包，用sha256sum校验后解压到chunk目录",
+			"所有压缩包解压完成后，按blobs下载去重文件到对应路径并校验",
+			"命令使用rclone下载；使用其他后端时请用相应工具下载remote_path下的同名文件",
+			"设置了--smart-compression的压缩包仍是gzip格式，解压命令相同；--compression none生成的.tar压缩包不使用gzip解压，--compression auto选择zstd的压缩包使用tar --zstd解压",
+		},
+	}
+
+	for _, name := range sortedArchiveNames(metadata.Checksums) {
+		remotePath := filepath.Join(bm.config.RemotePath, ChunkDirName, archiveObject(metadata, name))
+		checksum := metadata.Checksums[name]
+		manifest.Archives = append(manifest.Archives, models.RestoreManifestArchive{
+			Name:        name,
+			RemotePath:  remotePath,
+			SHA256:      checksum,
+			Compression: metadata.Compression[name],
+			Commands: []string{
+				fmt.Sprintf("rclone copyto %s %s", shellQuote(remotePath), shellQuote(name)),
+				fmt.Sprintf("echo %s | sha256sum -c -", shellQuote(checksum+"  "+name)),
+				fmt.Sprintf("mkdir -p %s && tar %s %s -C %s", chunkPath, tarExtractFlags(metadata.Compression[name]), shellQuote(name), chunkPath),
+			},
+		})
+
+		for _, entry := range metadata.Dedupe[name] {
+			remoteBlob := filepath.Join(bm.config.RemotePath, BlobDirName, entry.Hash)
+			localPath := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(entry.Path))
+			manifest.Blobs = append(manifest.Blobs, models.RestoreManifestBlob{
+				Path:       entry.Path,
+				RemotePath: remoteBlob,
+				SHA256:     entry.Hash,
+				Commands: []string{
+					fmt.Sprintf("rclone copyto %s %s", shellQuote(remoteBlob), shellQuote(localPath)),
+					fmt.Sprintf("echo %s | sha256sum -c -", shellQuote(entry.Hash+"  "+localPath)),
+				},
+			})
+		}
+	}
+
+	for _, move := range metadata.Moves {
+		from := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(move.From))
+		to := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(move.To))
+		manifest.Moves = append(manifest.Moves, models.RestoreManifestMove{
+			From:    move.From,
+			To:      move.To,
+			Command: fmt.Sprintf("mkdir -p %s && mv %s %s", shellQuote(filepath.Dir(to)), shellQuote(from), shellQuote(to)),
+		})
+	}
+	if len(manifest.Moves) > 0 {
+		manifest.Steps = append(manifest.Steps, "最后按moves把移动过的文件从压缩包中的原路径移动到当前路径")
+	}
+
+	return manifest
+}
+
+// uploadRestoreManifest 生成恢复清单并上传到远程路径根目录
+func (bm *BackupManager) uploadRestoreManifest(ctx context.Context, metadata *models.BackupMetadata) error {
+	data, err := json.MarshalIndent(bm.buildRestoreManifest(metadata), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal restore manifest: %w", err)
+	}
+
+	localPath := filepath.Join(bm.config.TempPath, RestoreManifestFileName)
+	bm.tempFiles.track(localPath)
+	defer bm.tempFiles.remove(localPath)
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save restore manifest: %w", err)
+	}
+
+	remotePath := filepath.Join(bm.config.RemotePath, RestoreManifestFileName)
+	if err := bm.storage.UploadFile(ctx, localPath, remotePath); err != nil {
+		return fmt.Errorf("failed to upload restore manifest: %w", remoteError(err))
+	}
+	return nil
+}
+
+// shellQuote 用单引号包裹字符串，使其可以安全地作为shell参数
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// tarExtractFlags 返回解压压缩包的tar参数，不压缩的.tar不能使用-z，zstd压缩包需要--zstd
+func tarExtractFlags(compression string) string {
+	switch compression {
+	case archiver.CompressionNone:
+		return "-xf"
+	case archiver.CompressionZstd:
+		return "--zstd -xf"
+	default:
+		return "-xzf"
+	}
+}
@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// PrevMetadataSuffix 覆盖元数据前保存的上一版元数据的后缀（backup-metadata.json.prev），
+// 与主元数据位于同一目录，其校验和文件为backup-metadata.json.prev.sha256
+const PrevMetadataSuffix = ".prev"
+
+// backupPreviousMetadata 覆盖远程元数据之前把现有的元数据及其校验和文件复制为.prev（后端支持时在服务端复制），
+// 本次运行产生错误的元数据时可以一步回退。本次加载的是.prev（主元数据已损坏）时保留.prev不覆盖。
+// 复制失败只记录警告，不影响本次备份
+func (bm *BackupManager) backupPreviousMetadata(ctx context.Context, remotePath string) {
+	if bm.metadataFromPrev {
+		logger.Warn(fmt.Sprintf("本次使用%s%s加载元数据，保留它而不用损坏的主元数据覆盖", MetadataFileName, PrevMetadataSuffix))
+		return
+	}
+	exists, err := bm.storage.FileExists(ctx, remotePath)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("检查现有元数据失败，未保存%s: %v", PrevMetadataSuffix, err))
+		return
+	}
+	if !exists {
+		return
+	}
+
+	// 与上传元数据相同的顺序：先删除旧的校验和文件，再复制元数据和校验和文件
+	prevPath := remotePath + PrevMetadataSuffix
+	if err := bm.storage.DeleteFile(ctx, prevPath+MetadataChecksumSuffix); err != nil {
+		logger.Warn(fmt.Sprintf("删除旧的%s校验和文件失败，未保存%s: %v", PrevMetadataSuffix, PrevMetadataSuffix, err))
+		return
+	}
+	if err := bm.copyRemoteFile(ctx, remotePath, prevPath); err != nil {
+		logger.Warn(fmt.Sprintf("保存%s失败: %v", filepath.Base(prevPath), err))
+		return
+	}
+	checksumPath := remotePath + MetadataChecksumSuffix
+	if exists, err := bm.storage.FileExists(ctx, checksumPath); err != nil || !exists {
+		return
+	}
+	if err := bm.copyRemoteFile(ctx, checksumPath, prevPath+MetadataChecksumSuffix); err != nil {
+		logger.Warn(fmt.Sprintf("保存%s的校验和文件失败: %v", filepath.Base(prevPath), err))
+	}
+}
+
+// prevMetadata 加载远程的.prev元数据，不存在时返回nil
+func (bm *BackupManager) prevMetadata(ctx context.Context) (*models.BackupMetadata, error) {
+	prevPath := filepath.Join(bm.metadataDir(), MetadataFileName) + PrevMetadataSuffix
+	exists, err := bm.storage.FileExists(ctx, prevPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %w", filepath.Base(prevPath), remoteError(err))
+	}
+	if !exists {
+		return nil, nil
+	}
+	metadata, err := bm.loadMetadataFile(ctx, prevPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filepath.Base(prevPath), err)
+	}
+	return metadata, nil
+}
+
+// copyRemoteFile 复制远程文件，后端不支持远程复制时经本地临时目录中转
+func (bm *BackupManager) copyRemoteFile(ctx context.Context, srcPath, dstPath string) error {
+	if bm.storage.Capabilities().Copy {
+		return remoteError(bm.storage.CopyRemote(ctx, srcPath, dstPath))
+	}
+	return remoteError(bm.copyThroughLocal(ctx, srcPath, dstPath, false))
+}
+
+// loadPrevMetadata 主元数据损坏（校验和不一致或无法解析）时的处理：启用UsePrevMetadata时改为加载.prev，
+// 否则在错误中提示可以回退。其他错误（如远程不可用）原样返回
+func (bm *BackupManager) loadPrevMetadata(ctx context.Context, remotePath string, loadErr error) (*models.BackupMetadata, error) {
+	if !corruptMetadata(loadErr) {
+		return nil, loadErr
+	}
+	prevPath := remotePath + PrevMetadataSuffix
+	exists, err := bm.storage.FileExists(ctx, prevPath)
+	if err != nil || !exists {
+		return nil, loadErr
+	}
+	if !bm.config.UsePrevMetadata {
+		return nil, fmt.Errorf("%w (run with --use-prev-metadata to fall back to %s)", loadErr, filepath.Base(prevPath))
+	}
+
+	logger.Warn(fmt.Sprintf("元数据已损坏（%v），回退到%s", loadErr, filepath.Base(prevPath)))
+	metadata, err := bm.loadMetadataFile(ctx, prevPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w; fallback to %s also failed: %v", loadErr, filepath.Base(prevPath), err)
+	}
+	bm.metadataFromPrev = true
+	return metadata, nil
+}
+
+// corruptMetadata 判断加载元数据的错误是否表示内容损坏，而不是远程暂时不可用
+func corruptMetadata(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.Is(err, ErrChecksumMismatch) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
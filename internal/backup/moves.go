@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// detectMoves 启用TrackInodes时按inode号找出上次备份后移动过的文件，从comparison.changed中去掉只因移动而变化的目录，
+// 返回与上次元数据中尚未解决的移动记录合并后的列表。未启用时只返回上次的记录，其中的文件仍只能从原路径恢复
+func (bm *BackupManager) detectMoves(oldMetadata *models.BackupMetadata, comparison *treeComparison) []models.MovedFile {
+	if !bm.config.TrackInodes || oldMetadata.FileTree == nil {
+		return oldMetadata.Moves
+	}
+
+	detected := scanner.FindMovedFiles(oldMetadata.FileTree, comparison.current)
+	if len(detected) > 0 {
+		before := len(comparison.changed)
+		comparison.changed = scanner.DiscountMoves(oldMetadata.FileTree, comparison.current, comparison.changed, detected, comparison.mode)
+		logger.Info(fmt.Sprintf("识别出%d个移动的文件，%d个目录不再需要重新打包", len(detected), before-len(comparison.changed)))
+	}
+	return composeMoves(oldMetadata.Moves, detected)
+}
+
+// composeMoves 合并上次的移动记录和本次识别出的移动：上次移动到某路径的文件本次再次移动时，
+// 合并为从压缩包中的原路径到最新路径的一条记录。结果按当前路径排序
+func composeMoves(previous, detected []models.MovedFile) []models.MovedFile {
+	if len(detected) == 0 {
+		return previous
+	}
+	byFrom := make(map[string]models.MovedFile, len(detected))
+	for _, move := range detected {
+		byFrom[move.From] = move
+	}
+
+	moves := make([]models.MovedFile, 0, len(previous)+len(detected))
+	for _, move := range previous {
+		if next, exists := byFrom[move.To]; exists {
+			delete(byFrom, move.To)
+			move.To = next.To
+		}
+		moves = append(moves, move)
+	}
+	for _, move := range detected {
+		if _, exists := byFrom[move.From]; exists {
+			moves = append(moves, move)
+		}
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i].To < moves[j].To
+	})
+	return moves
+}
+
+// forceMoveTargets 移动的文件只保存在原路径所在分组的压缩包中，该分组重新打包（或已不存在）后不再包含该文件，
+// 此时把当前路径所在的分组也标记为需要更新
+func forceMoveTargets(groups []*models.ArchiveGroup, moves []models.MovedFile) {
+	if len(moves) == 0 {
+		return
+	}
+	byDir := make(map[string]*models.ArchiveGroup)
+	for _, group := range groups {
+		for _, dir := range group.Directories {
+			byDir[dir] = group
+		}
+	}
+
+	// 被标记的分组可能是其他移动记录的原路径所在分组，重复直到没有新的标记
+	for marked := true; marked; {
+		marked = false
+		for _, move := range moves {
+			source, target := byDir[moveDir(move.From)], byDir[moveDir(move.To)]
+			if target == nil || target.NeedsUpdate || source != nil && !source.NeedsUpdate {
+				continue
+			}
+			logger.Info(fmt.Sprintf("移动的文件 %s 的原压缩包将重新打包，一并更新压缩包 %s", move.To, target.ArchiveName))
+			target.NeedsUpdate = true
+			marked = true
+		}
+	}
+}
+
+// keptMoves 返回处理分组后仍需保留的移动记录：原路径或当前路径所在的分组成功重新打包后，
+// 文件已按当前路径打包（或已随原分组重新打包而不再需要从原路径恢复），记录不再需要
+func keptMoves(moves []models.MovedFile, groups []*models.ArchiveGroup, result *models.BackupResult) []models.MovedFile {
+	unfinished := make(map[string]bool)
+	for _, name := range result.ErrorArchives {
+		unfinished[name] = true
+	}
+	for _, name := range result.DeferredArchives {
+		unfinished[name] = true
+	}
+	rebuilt := make(map[string]bool)
+	for _, group := range groups {
+		if group.NeedsUpdate && !unfinished[group.ArchiveName] {
+			for _, dir := range group.Directories {
+				rebuilt[dir] = true
+			}
+		}
+	}
+
+	var kept []models.MovedFile
+	for _, move := range moves {
+		if !rebuilt[moveDir(move.From)] && !rebuilt[moveDir(move.To)] {
+			kept = append(kept, move)
+		}
+	}
+	return kept
+}
+
+// moveDir 返回移动记录中路径所在的顶层chunk目录
+func moveDir(relPath string) string {
+	return strings.SplitN(relPath, "/", 2)[0]
+}
+
+// applyMoves 恢复后把移动过的文件从压缩包中的原路径移动到当前路径。match不为nil时只处理当前路径匹配的文件，
+// 原路径不在本次恢复范围内的先单独从原压缩包中恢复；原路径匹配而当前路径不匹配的文件删除，
+// 避免在已不存在该文件的位置留下副本。移动的文件数记录到result.MovedFiles
+func (bm *BackupManager) applyMoves(ctx context.Context, metadata *models.BackupMetadata, match func(name string) bool, result *models.RestoreResult) error {
+	for _, move := range metadata.Moves {
+		from := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(move.From))
+		to := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(move.To))
+		extracted := match == nil || match(move.From)
+
+		if match != nil && !match(move.To) {
+			if extracted {
+				if err := os.Remove(from); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove moved file %s: %w", move.From, err)
+				}
+			}
+			continue
+		}
+		if !extracted {
+			if err := bm.restoreSingleEntry(ctx, metadata, move.From, &models.RestoreResult{}); err != nil {
+				return fmt.Errorf("failed to restore moved file %s: %w", move.To, err)
+			}
+			result.RestoredEntries++
+		}
+
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", move.To, err)
+		}
+		if err := os.Rename(from, to); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", move.From, move.To, err)
+		}
+		logger.Debug(fmt.Sprintf("Moved restored file %s to %s", move.From, move.To))
+		result.MovedFiles++
+	}
+	return nil
+}
+
+// findMove 返回当前路径为entryName的移动记录
+func findMove(moves []models.MovedFile, entryName string) (models.MovedFile, bool) {
+	for _, move := range moves {
+		if move.To == entryName {
+			return move, true
+		}
+	}
+	return models.MovedFile{}, false
+}
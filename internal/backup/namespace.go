@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+const (
+	// NamespaceDirName 按命名空间划分远程路径时每一级命名空间的目录名，与PBS datastore中的布局相同
+	NamespaceDirName = "ns"
+
+	// maxNamespaceDepth PBS允许的命名空间最大层数
+	maxNamespaceDepth = 7
+)
+
+// namespacePattern PBS命名空间每一级的名称规则
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._\-]*$`)
+
+// ParseNamespace 校验PBS命名空间，各级以/分隔，忽略首尾的/。空字符串表示根命名空间
+func ParseNamespace(namespace string) (string, error) {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return "", nil
+	}
+	parts := strings.Split(namespace, "/")
+	if len(parts) > maxNamespaceDepth {
+		return "", fmt.Errorf("namespace %q is deeper than %d levels", namespace, maxNamespaceDepth)
+	}
+	for _, part := range parts {
+		if !namespacePattern.MatchString(part) {
+			return "", fmt.Errorf("invalid namespace component %q in %q", part, namespace)
+		}
+	}
+	return namespace, nil
+}
+
+// NamespaceRemotePath 返回base下命名空间对应的远程子目录，与PBS相同每一级加上ns/前缀，
+// 如a/b对应base/ns/a/ns/b。命名空间为空时返回base
+func NamespaceRemotePath(base, namespace string) string {
+	if namespace == "" {
+		return base
+	}
+	parts := []string{base}
+	for _, part := range strings.Split(namespace, "/") {
+		parts = append(parts, NamespaceDirName, part)
+	}
+	return filepath.Join(parts...)
+}
+
+// checkNamespace 确认上次备份的元数据属于配置的命名空间。旧元数据没有记录命名空间时视为一致，本次的命名空间记录到新元数据中；
+// 不一致（包括上次有命名空间而本次未指定）且未设置Force时返回ErrNamespaceMismatch
+func (bm *BackupManager) checkNamespace(metadata *models.BackupMetadata) error {
+	if metadata.Namespace == bm.config.Namespace {
+		return nil
+	}
+	if metadata.Namespace == "" {
+		logger.Info(fmt.Sprintf("上次备份未记录命名空间，本次记录为 %s", bm.config.Namespace))
+		return nil
+	}
+
+	if bm.config.Force {
+		logger.Warn(fmt.Sprintf("命名空间不一致（上次 %q，本次 %q），已使用--force继续", metadata.Namespace, bm.config.Namespace))
+		return nil
+	}
+	return fmt.Errorf("%w: previous %q, current %q (use --namespace to select the namespace, or --force to override)",
+		ErrNamespaceMismatch, metadata.Namespace, bm.config.Namespace)
+}
@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"fmt"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// objectSizeMargin 按目录大小预测压缩包大小时预留的余量（百分比）。chunk本身已压缩，
+// 压缩包大小接近目录大小，但tar头和不可压缩的内容可能使压缩包略大于目录
+const objectSizeMargin = 5
+
+// maxObjectSize 返回单个远程对象的大小上限，0表示不检查。
+// 配置了MaxObjectSize时优先于后端报告的上限，负数表示不检查
+func (bm *BackupManager) maxObjectSize() int64 {
+	if bm.config.MaxObjectSize != 0 {
+		return max(bm.config.MaxObjectSize, 0)
+	}
+	return bm.storage.Capabilities().MaxObjectSize
+}
+
+// fitObjectSize 按目录大小预测每个分组的压缩包大小，有分组可能超过远程对象大小上限时
+// 自动设置每个压缩包最多包含的目录数并重新分组，新的目录数记录到元数据中，之后的增量备份沿用。
+// 单个目录已超过上限时无法切分，返回ErrObjectTooLarge
+func (bm *BackupManager) fitObjectSize(groups []*models.ArchiveGroup, directories []string, fileTree map[string]*models.FileTreeNode) ([]*models.ArchiveGroup, error) {
+	limit := bm.maxObjectSize()
+	if limit <= 0 {
+		return groups, nil
+	}
+	target := limit - limit/100*objectSizeMargin
+
+	sizes := scanner.TreeSizes(fileTree)
+	var largest *models.ArchiveGroup
+	var largestSize int64
+	for _, group := range groups {
+		var size int64
+		for _, dir := range group.Directories {
+			size += sizes[dir]
+		}
+		if size > largestSize {
+			largest, largestSize = group, size
+		}
+	}
+	if largestSize <= target {
+		return groups, nil
+	}
+
+	var maxDir string
+	for _, dir := range directories {
+		if maxDir == "" || sizes[dir] > sizes[maxDir] {
+			maxDir = dir
+		}
+	}
+	if sizes[maxDir] > target {
+		return nil, fmt.Errorf("%w: directory %s is %d bytes, the remote accepts at most %d bytes per object (exclude it with --max-dir-size, or override the limit with --max-object-size)",
+			ErrObjectTooLarge, maxDir, sizes[maxDir], limit)
+	}
+
+	// 按目录编号对齐切分后每个压缩包最多batch个目录，每个目录不超过最大的目录，压缩包不会超过target
+	batch := int(target / max(sizes[maxDir], 1))
+	logger.Warn(fmt.Sprintf("压缩包 %s 预计 %d 字节，超过远程单个对象的大小上限 %d 字节，已自动切分为每个压缩包最多 %d 个目录（后端实际支持更大的对象时可用--max-object-size覆盖）",
+		largest.ArchiveName, largestSize, limit, batch))
+	bm.config.DirBatchSize = batch
+	return bm.archiver.GenerateBatchedArchiveGroups(directories, bm.config.PrefixDigits, batch)
+}
+
+// checkObjectSize 上传前确认压缩包不超过远程对象大小上限，避免上传到最后才失败。
+// 增量备份沿用上次的分组，分组增长超过上限时需要重新执行全量备份切分
+func (bm *BackupManager) checkObjectSize(archiveName string, size int64) error {
+	limit := bm.maxObjectSize()
+	if limit <= 0 || size <= limit {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is %d bytes, the remote accepts at most %d bytes per object (run a full backup to split it automatically, or override the limit with --max-object-size)",
+		ErrObjectTooLarge, archiveName, size, limit)
+}
@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// PostProcessor 压缩包后处理器。压缩包创建并计算校验和后调用Process生成附加文件（如分离签名），
+// 附加文件与校验和文件一起上传到sha256目录，并以处理器名称记录在元数据中，verify时调用Verify校验
+type PostProcessor interface {
+	// Name 处理器名称，记录在元数据中，校验时按名称选择处理器
+	Name() string
+
+	// Process 处理本地压缩包，返回生成的附加文件路径。附加文件名在同一压缩包的附加文件中必须唯一，
+	// 通常是压缩包名加上后缀（如0000-00ff.tar.gz.sig）
+	Process(ctx context.Context, archivePath string) (string, error)
+
+	// Verify 校验附加文件与本地压缩包是否匹配，不匹配时返回错误
+	Verify(ctx context.Context, archivePath, sidecarPath string) error
+}
+
+// AddPostProcessor 添加压缩包后处理器，之后创建的每个压缩包都会按添加顺序调用
+func (bm *BackupManager) AddPostProcessor(processor PostProcessor) {
+	bm.postProcessors = append(bm.postProcessors, processor)
+}
+
+// verifierFor 返回校验指定处理器生成的附加文件的处理器。
+// 优先使用已添加的处理器；内置的GPG签名不需要签名密钥即可校验
+func (bm *BackupManager) verifierFor(name string) PostProcessor {
+	for _, processor := range bm.postProcessors {
+		if processor.Name() == name {
+			return processor
+		}
+	}
+	if name == GPGProcessorName {
+		return NewGPGSigner(bm.config.GPGBinary, "")
+	}
+	return nil
+}
+
+// postProcessArchive 对本地压缩包依次执行后处理器并上传生成的附加文件，返回要记录在元数据中的附加文件
+func (bm *BackupManager) postProcessArchive(ctx context.Context, archivePath, archiveName string, result *models.BackupResult) ([]models.Sidecar, error) {
+	var sidecars []models.Sidecar
+	for _, processor := range bm.postProcessors {
+		logger.Debug(fmt.Sprintf("Post-processing archive %s with %s", archiveName, processor.Name()))
+		sidecarPath, err := processor.Process(ctx, archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("%s post-processing failed: %w", processor.Name(), err)
+		}
+		bm.tempFiles.track(sidecarPath)
+		defer bm.tempFiles.remove(sidecarPath)
+
+		name := filepath.Base(sidecarPath)
+		if err := bm.uploadFile(ctx, sidecarPath, filepath.Join(bm.config.RemotePath, Sha256DirName, name), 0); err != nil {
+			return nil, fmt.Errorf("failed to upload %s sidecar: %w", processor.Name(), err)
+		}
+		result.UploadedFiles = append(result.UploadedFiles, Sha256DirName+"/"+name)
+		sidecars = append(sidecars, models.Sidecar{Processor: processor.Name(), Name: name})
+	}
+	return sidecars, nil
+}
+
+// verifySidecars 下载压缩包的附加文件并用对应的处理器校验，返回第一个失败的原因
+func (bm *BackupManager) verifySidecars(ctx context.Context, archivePath string, sidecars []models.Sidecar) error {
+	for _, sidecar := range sidecars {
+		verifier := bm.verifierFor(sidecar.Processor)
+		if verifier == nil {
+			return fmt.Errorf("no verifier for %s sidecar %s", sidecar.Processor, sidecar.Name)
+		}
+
+		localPath := filepath.Join(bm.config.TempPath, sidecar.Name)
+		bm.tempFiles.track(localPath)
+		err := bm.storage.DownloadFile(ctx, filepath.Join(bm.config.RemotePath, Sha256DirName, sidecar.Name), localPath)
+		if err == nil {
+			err = verifier.Verify(ctx, archivePath, localPath)
+		}
+		bm.tempFiles.remove(localPath)
+		if err != nil {
+			return fmt.Errorf("%s sidecar %s: %w", sidecar.Processor, sidecar.Name, remoteError(err))
+		}
+	}
+	return nil
+}
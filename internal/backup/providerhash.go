@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/storage"
+)
+
+// providerHashOrder 比较服务端哈希时优先使用的哈希类型
+var providerHashOrder = []string{storage.HashCRC32C, storage.HashMD5}
+
+// verifyProviderHashes 比较元数据记录的CRC32C或MD5与服务端记录的哈希，不下载压缩包。
+// 返回仍需下载校验SHA256的压缩包（元数据没有记录哈希、服务端没有可比较的哈希，或后端不支持读取哈希）、
+// 校验失败项和通过校验的压缩包数
+func (bm *BackupManager) verifyProviderHashes(ctx context.Context, metadata *models.BackupMetadata, names []string) ([]string, []models.VerifyMismatch, int) {
+	hasher, ok := bm.storage.(storage.HashLister)
+	if !ok {
+		logger.Info("存储后端不能读取服务端哈希，下载校验全部压缩包")
+		return names, nil, 0
+	}
+	files, err := hasher.ListFilesWithHashes(ctx, filepath.Join(bm.config.RemotePath, ChunkDirName))
+	if err != nil {
+		logger.Warn(fmt.Sprintf("读取服务端哈希失败，下载校验全部压缩包: %v", err))
+		return names, nil, 0
+	}
+	remote := make(map[string]storage.FileInfo, len(files))
+	for _, file := range files {
+		remote[file.Name] = file
+	}
+
+	var (
+		remaining  []string
+		mismatches []models.VerifyMismatch
+		verified   int
+	)
+	for _, name := range names {
+		stored := metadata.Hashes[name]
+		if len(stored) == 0 {
+			remaining = append(remaining, name)
+			continue
+		}
+		file, exists := remote[archiveObject(metadata, name)]
+		if !exists {
+			logger.Error(fmt.Sprintf("远程不存在压缩包: %s", name))
+			mismatches = append(mismatches, models.VerifyMismatch{Archive: name, Expected: metadata.Checksums[name], Error: "archive not found on remote"})
+			continue
+		}
+		hashType := commonHashType(stored, file.Hashes)
+		if hashType == "" {
+			remaining = append(remaining, name)
+			continue
+		}
+		if stored[hashType] != file.Hashes[hashType] {
+			logger.Error(fmt.Sprintf("服务端哈希不匹配: %s（%s）", name, hashType))
+			mismatches = append(mismatches, models.VerifyMismatch{
+				Archive:  name,
+				Expected: hashType + ":" + stored[hashType],
+				Actual:   hashType + ":" + file.Hashes[hashType],
+			})
+			continue
+		}
+		logger.Info(fmt.Sprintf("服务端哈希校验通过: %s（%s）", name, hashType))
+		verified++
+	}
+
+	if len(remaining) > 0 {
+		logger.Info(fmt.Sprintf("%d个压缩包没有可比较的服务端哈希，下载校验SHA256", len(remaining)))
+	}
+	return remaining, mismatches, verified
+}
+
+// commonHashType 返回记录的哈希和服务端哈希中都有的哈希类型，按providerHashOrder选择，没有时返回空字符串
+func commonHashType(stored, remote models.ObjectHashes) string {
+	for _, hashType := range providerHashOrder {
+		if stored[hashType] != "" && remote[hashType] != "" {
+			return hashType
+		}
+	}
+	return ""
+}
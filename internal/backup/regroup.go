@@ -0,0 +1,282 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+// regroupStagingDir 重新分组时解压旧压缩包的临时目录（位于TempPath下）
+const regroupStagingDir = "regroup-staging"
+
+// RunRegroup 按新的前缀位数重新分组已有的备份，不读取chunk目录。
+// 目录集合不变的压缩包保持不动；目录集合与某个旧压缩包相同、只是名称改变的压缩包在远程复制改名；
+// 其余压缩包下载对应的旧压缩包（校验SHA256）解压到临时目录后重新打包上传。
+// 新的元数据上传后再删除旧压缩包，中途失败时原备份保持可用。启用KeepHistory时保留旧压缩包，
+// 由prune在引用它们的历史快照清理后删除
+func (bm *BackupManager) RunRegroup(ctx context.Context, prefixDigits int) (*models.RegroupResult, error) {
+	defer bm.tempFiles.guard(ctx)()
+
+	startTime := time.Now()
+	metadata, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup metadata: %w", err)
+	}
+	if prefixDigits == metadata.PrefixDigits {
+		return nil, fmt.Errorf("backup already uses %d prefix digits", prefixDigits)
+	}
+	fileTree, err := bm.loadFileTree(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// 缺少的压缩包无法从远程重新分组
+	missing, _, err := bm.baselineDivergence(metadata, scanner.TreeSizes(fileTree))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check backup metadata: %w", err)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("backup is missing %d archives (%s), run an incremental backup with --repair first", len(missing), strings.Join(missing, ", "))
+	}
+
+	directories := make([]string, 0, len(fileTree))
+	for dir := range fileTree {
+		directories = append(directories, dir)
+	}
+	groupArchiver := bm.archiverFor(metadata)
+	oldGroups, err := archiveGroups(groupArchiver, directories, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate current archive groups: %w", err)
+	}
+	newGroups, err := groupArchiver.GenerateBatchedArchiveGroups(directories, prefixDigits, metadata.DirBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new archive groups: %w", err)
+	}
+
+	oldByDir := make(map[string]*models.ArchiveGroup)
+	oldByDirs := make(map[string]*models.ArchiveGroup, len(oldGroups))
+	for _, group := range oldGroups {
+		for _, dir := range group.Directories {
+			oldByDir[dir] = group
+		}
+		oldByDirs[strings.Join(group.Directories, "/")] = group
+	}
+
+	regrouped := *metadata
+	regrouped.PrefixDigits = prefixDigits
+	regrouped.MergedRanges = nil // 合并范围按原前缀位数规划，重新分组后不再适用
+	regrouped.BackupTime = startTime
+	regrouped.FileTreeFile = ""
+	regrouped.FileTreeSHA256 = ""
+	regrouped.Checksums = make(map[string]string)
+	regrouped.SourceSums = make(map[string]string)
+	regrouped.Compression = make(map[string]string)
+	regrouped.Dedupe = make(map[string][]models.DedupeEntry)
+	regrouped.Sidecars = make(map[string][]models.Sidecar)
+	regrouped.Objects = make(map[string]string)
+	regrouped.Hashes = make(map[string]models.ObjectHashes)
+
+	staging := filepath.Join(bm.config.TempPath, regroupStagingDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, fmt.Errorf("failed to clean staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+	bm.archiver = groupArchiver.WithChunkPath(staging)
+
+	result := &models.RegroupResult{OldPrefixDigits: metadata.PrefixDigits, NewPrefixDigits: prefixDigits}
+	rebuildResult := &models.BackupResult{Details: make(map[string]string)}
+	extracted := make(map[string]bool)
+	canCopy := bm.storage.Capabilities().Copy
+
+	for _, group := range newGroups {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("regroup cancelled: %w", err)
+		}
+
+		old := oldByDirs[strings.Join(group.Directories, "/")]
+		switch {
+		case old != nil && old.ArchiveName == group.ArchiveName:
+			keepArchive(metadata, &regrouped, old.ArchiveName, group.ArchiveName, archiveObject(metadata, old.ArchiveName))
+			result.Unchanged = append(result.Unchanged, group.ArchiveName)
+		case old != nil && canCopy:
+			logger.Info(fmt.Sprintf("复制压缩包 %s 为 %s", old.ArchiveName, group.ArchiveName))
+			object := bm.newArchiveObject(group.ArchiveName, bm.now())
+			if err := bm.copyArchive(ctx, metadata, old.ArchiveName, group.ArchiveName, object); err != nil {
+				return nil, fmt.Errorf("failed to copy archive %s to %s: %w", old.ArchiveName, group.ArchiveName, err)
+			}
+			keepArchive(metadata, &regrouped, old.ArchiveName, group.ArchiveName, object)
+			result.Copied = append(result.Copied, group.ArchiveName)
+		default:
+			logger.Info(fmt.Sprintf("重新打包压缩包 %s", group.ArchiveName))
+			if err := bm.stageDirectories(ctx, metadata, group, oldByDir, staging, extracted); err != nil {
+				return nil, err
+			}
+			if err := bm.processArchiveGroup(ctx, group, &regrouped, rebuildResult, false); err != nil {
+				return nil, fmt.Errorf("failed to rebuild archive %s: %w", group.ArchiveName, err)
+			}
+			for _, dir := range group.Directories {
+				os.RemoveAll(filepath.Join(staging, dir))
+			}
+			result.Rebuilt = append(result.Rebuilt, group.ArchiveName)
+		}
+	}
+	remapDedupe(metadata, &regrouped, newGroups)
+
+	if err := bm.saveAndUploadMetadata(ctx, &regrouped); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	// 新元数据已生效，删除失败只记录警告，残留的旧压缩包不影响备份
+	if bm.config.KeepHistory {
+		logger.Info("启用了历史快照，旧压缩包保留到引用它们的快照被prune清理")
+	} else {
+		kept := make(map[string]bool, len(regrouped.Checksums))
+		for name := range regrouped.Checksums {
+			kept[archiveObject(&regrouped, name)] = true
+		}
+		for _, name := range sortedArchiveNames(metadata.Checksums) {
+			object := archiveObject(metadata, name)
+			if kept[object] {
+				continue
+			}
+			if err := bm.deleteArchive(ctx, object, metadata.Sidecars[name], false); err != nil {
+				logger.Warn(fmt.Sprintf("删除旧压缩包 %s 失败: %v", name, err))
+				continue
+			}
+			result.Deleted = append(result.Deleted, name)
+		}
+	}
+
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// keepArchive 将旧压缩包的校验和、源内容校验和、压缩方式、哈希和附加文件记录到新元数据的newName下，远程对象名为object
+func keepArchive(metadata, regrouped *models.BackupMetadata, oldName, newName, object string) {
+	oldObject := archiveObject(metadata, oldName)
+	regrouped.Checksums[newName] = metadata.Checksums[oldName]
+	setArchiveObject(regrouped, newName, object)
+	if sum, exists := metadata.SourceSums[oldName]; exists {
+		regrouped.SourceSums[newName] = sum
+	}
+	if compression, exists := metadata.Compression[oldName]; exists {
+		regrouped.Compression[newName] = compression
+	}
+	// 复制得到的对象内容不变，服务端哈希相同
+	setArchiveHashes(regrouped, newName, metadata.Hashes[oldName])
+	for _, sidecar := range metadata.Sidecars[oldName] {
+		sidecar.Name = object + strings.TrimPrefix(sidecar.Name, oldObject)
+		regrouped.Sidecars[newName] = append(regrouped.Sidecars[newName], sidecar)
+	}
+}
+
+// copyArchive 在远程把压缩包及其附加文件复制为新的对象object，重新生成记录新名称的校验和文件（只保存汇总校验和文件时除外）和tar索引
+func (bm *BackupManager) copyArchive(ctx context.Context, metadata *models.BackupMetadata, oldName, newName, object string) error {
+	oldObject := archiveObject(metadata, oldName)
+	copies := [][2]string{{filepath.Join(ChunkDirName, oldObject), filepath.Join(ChunkDirName, object)}}
+	for _, sidecar := range metadata.Sidecars[oldName] {
+		copies = append(copies, [2]string{
+			filepath.Join(Sha256DirName, sidecar.Name),
+			filepath.Join(Sha256DirName, object+strings.TrimPrefix(sidecar.Name, oldObject)),
+		})
+	}
+	for _, c := range copies {
+		if err := bm.storage.CopyRemote(ctx, filepath.Join(bm.config.RemotePath, c[0]), filepath.Join(bm.config.RemotePath, c[1])); err != nil {
+			return remoteError(err)
+		}
+	}
+
+	if bm.writesSidecars() {
+		checksumPath := filepath.Join(bm.config.TempPath, object+".sha256")
+		if err := bm.uploadContent(ctx, checksumPath, filepath.Join(Sha256DirName, object+".sha256"), []byte(bm.checksumFileContent(metadata.Checksums[oldName], object))); err != nil {
+			return err
+		}
+	}
+
+	index, err := bm.downloadIndex(ctx, oldObject)
+	if err != nil || index == nil {
+		return err
+	}
+	index.Archive = newName
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tar index: %w", err)
+	}
+	indexName := filepath.Base(archiver.IndexPath(object))
+	return bm.uploadContent(ctx, filepath.Join(bm.config.TempPath, indexName), filepath.Join(IndexDirName, indexName), data)
+}
+
+// uploadContent 将内容写入本地临时文件后上传到RemotePath下的相对路径
+func (bm *BackupManager) uploadContent(ctx context.Context, localPath, remotePath string, data []byte) error {
+	bm.tempFiles.track(localPath)
+	defer bm.tempFiles.remove(localPath)
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(localPath), err)
+	}
+	if err := bm.uploadFile(ctx, localPath, filepath.Join(bm.config.RemotePath, remotePath), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// stageDirectories 下载并解压包含分组目录的旧压缩包到临时目录，每个旧压缩包只解压一次。
+// 一个旧压缩包拆分到多个新分组时，其余目录留在临时目录中，处理到对应分组时使用
+func (bm *BackupManager) stageDirectories(ctx context.Context, metadata *models.BackupMetadata, group *models.ArchiveGroup, oldByDir map[string]*models.ArchiveGroup, staging string, extracted map[string]bool) error {
+	for _, dir := range group.Directories {
+		old := oldByDir[dir]
+		if extracted[old.ArchiveName] {
+			continue
+		}
+
+		archivePath, err := bm.downloadArchive(ctx, archiveObject(metadata, old.ArchiveName), metadata.Checksums[old.ArchiveName])
+		if err != nil {
+			return fmt.Errorf("failed to download archive %s: %w", old.ArchiveName, err)
+		}
+		_, err = archiver.ExtractArchive(archivePath, staging, nil)
+		os.Remove(archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to extract archive %s: %w", old.ArchiveName, err)
+		}
+		extracted[old.ArchiveName] = true
+	}
+	return nil
+}
+
+// remapDedupe 按文件所在的顶层目录将去重清单重新归入新的压缩包
+func remapDedupe(metadata, regrouped *models.BackupMetadata, groups []*models.ArchiveGroup) {
+	archiveByDir := make(map[string]string)
+	for _, group := range groups {
+		for _, dir := range group.Directories {
+			archiveByDir[dir] = group.ArchiveName
+		}
+	}
+	for _, name := range sortedDedupeArchives(metadata.Dedupe) {
+		for _, entry := range metadata.Dedupe[name] {
+			dir, _, _ := strings.Cut(entry.Path, "/")
+			archive, exists := archiveByDir[dir]
+			if !exists {
+				logger.Warn(fmt.Sprintf("去重文件 %s 没有对应的压缩包，已从清单中移除", entry.Path))
+				continue
+			}
+			regrouped.Dedupe[archive] = append(regrouped.Dedupe[archive], entry)
+		}
+	}
+}
+
+// sortedDedupeArchives 返回去重清单中按十六进制数值排序的压缩包名
+func sortedDedupeArchives(dedupe map[string][]models.DedupeEntry) []string {
+	names := make(map[string]string, len(dedupe))
+	for name := range dedupe {
+		names[name] = ""
+	}
+	return sortedArchiveNames(names)
+}
@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+	"pbs-backuper/internal/storage"
+)
+
+// RemoteExcludeFileName 远程集中管理的排除模式文件名，位于RemotePath顶层，格式与--exclude-from相同。
+// 读取成功时在TempPath中保存同名副本，远程暂时不可用时使用该副本
+const RemoteExcludeFileName = "ignore-patterns.txt"
+
+// FetchRemoteExcludes 读取远程的排除模式文件，返回需要与本地排除模式合并的模式。
+// 远程不存在该文件时返回空并删除本地副本；远程读取失败时使用上次的本地副本，没有副本时只记录警告。
+// 文件内容有语法错误时返回错误，避免按不完整的策略备份
+func FetchRemoteExcludes(ctx context.Context, store storage.Storage, config *models.Config) ([]string, error) {
+	remotePath := filepath.Join(config.RemotePath, RemoteExcludeFileName)
+	cachePath := filepath.Join(config.TempPath, RemoteExcludeFileName)
+
+	content, err := fetchRemoteExcludeFile(ctx, store, remotePath)
+	if err != nil {
+		cached, cacheErr := os.ReadFile(cachePath)
+		if cacheErr != nil {
+			logger.Warn(fmt.Sprintf("读取远程排除模式文件失败，本次只使用本地排除模式: %v", err))
+			return nil, nil
+		}
+		logger.Warn(fmt.Sprintf("读取远程排除模式文件失败，使用上次保存的副本 %s: %v", cachePath, err))
+		return scanner.ParseExcludePatterns(bytes.NewReader(cached), cachePath)
+	}
+	if content == nil {
+		logger.Info(fmt.Sprintf("远程不存在排除模式文件 %s，只使用本地排除模式", remotePath))
+		if err := os.Remove(cachePath); err != nil && !os.IsNotExist(err) {
+			logger.Warn(fmt.Sprintf("删除排除模式文件副本失败: %v", err))
+		}
+		return nil, nil
+	}
+
+	patterns, err := scanner.ParseExcludePatterns(bytes.NewReader(content), remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.TempPath, 0755); err == nil {
+		err = os.WriteFile(cachePath, content, 0644)
+	}
+	if err != nil {
+		logger.Warn(fmt.Sprintf("保存排除模式文件副本失败: %v", err))
+	}
+	logger.Info(fmt.Sprintf("从远程读取了%d个排除模式", len(patterns)))
+	return patterns, nil
+}
+
+// fetchRemoteExcludeFile 读取远程排除模式文件的内容，文件不存在时返回nil
+func fetchRemoteExcludeFile(ctx context.Context, store storage.Storage, remotePath string) ([]byte, error) {
+	exists, err := store.FileExists(ctx, remotePath)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	content, err := store.GetFileContent(ctx, remotePath)
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	if content == nil {
+		content = []byte{}
+	}
+	return content, nil
+}
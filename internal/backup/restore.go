@@ -0,0 +1,363 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/storage"
+)
+
+// RunRestore 从远程恢复chunk数据到ChunkPath。
+// filePath为空时恢复全部压缩包；否则只恢复该路径（如"0012/abcd..."），
+// 若远程存在tar索引则直接定位到对应条目，不扫描整个压缩包。
+func (bm *BackupManager) RunRestore(ctx context.Context, filePath string) (*models.RestoreResult, error) {
+	startTime := time.Now()
+	result := &models.RestoreResult{}
+
+	metadata, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup metadata: %w", err)
+	}
+	// 指定了命名空间时只恢复该命名空间的备份，避免从错误的远程路径恢复
+	if bm.config.Namespace != "" && metadata.Namespace != bm.config.Namespace {
+		return nil, fmt.Errorf("%w: backup belongs to %q, requested %q", ErrNamespaceMismatch, metadata.Namespace, bm.config.Namespace)
+	}
+
+	if err := os.MkdirAll(bm.config.ChunkPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+
+	var match func(name string) bool
+	if filePath != "" {
+		// 移动过的文件不在当前路径所在的压缩包中，由applyMoves从原路径恢复
+		if _, moved := findMove(metadata.Moves, entryPath(filePath)); !moved {
+			if err := bm.restoreSingleEntry(ctx, metadata, filePath, result); err != nil {
+				return nil, err
+			}
+		}
+		match = entryMatcher(filePath)
+	} else {
+		names, err := bm.restoreArchiveNames(ctx, metadata)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			count, err := bm.restoreArchive(ctx, archiveObject(metadata, name), metadata.Checksums[name], nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore archive %s: %w", name, err)
+			}
+
+			deduped, err := bm.restoreDedupedFiles(ctx, metadata, metadata.Dedupe[name], nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore deduplicated files of %s: %w", name, err)
+			}
+			count += deduped
+			result.RestoredArchives++
+			result.RestoredEntries += count
+			logger.Info(fmt.Sprintf("成功恢复压缩包: %s (%d个条目)", name, count))
+		}
+	}
+
+	if err := bm.applyMoves(ctx, metadata, match, result); err != nil {
+		return nil, err
+	}
+
+	if bm.config.KeepEmptyDirs {
+		created, err := bm.restoreDirectories(ctx, metadata, match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore directories: %w", err)
+		}
+		result.CreatedDirs = created
+	}
+
+	result.Annotations = metadata.Annotations
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// restoreArchiveNames 返回全量恢复的压缩包，只包含文件树中仍有目录的分组。
+// 旧版本的增量备份会保留目录已全部删除的分组的校验和，这些压缩包不恢复，避免带回已删除的目录
+func (bm *BackupManager) restoreArchiveNames(ctx context.Context, metadata *models.BackupMetadata) ([]string, error) {
+	fileTree, err := bm.loadFileTree(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if fileTree == nil {
+		// 没有文件树的元数据无法判断分组，恢复所有压缩包
+		return sortedArchiveNames(metadata.Checksums), nil
+	}
+	live, err := liveArchives(bm.archiverFor(metadata), fileTree, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate archive groups: %w", err)
+	}
+
+	var names []string
+	for _, name := range sortedArchiveNames(metadata.Checksums) {
+		if !live[name] {
+			logger.Warn(fmt.Sprintf("压缩包 %s 的目录已不在文件树中，跳过", name))
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// restoreSingleEntry 恢复单个文件或目录
+func (bm *BackupManager) restoreSingleEntry(ctx context.Context, metadata *models.BackupMetadata, filePath string, result *models.RestoreResult) error {
+	entryName := entryPath(filePath)
+	topDir := strings.SplitN(entryName, "/", 2)[0]
+
+	groups, err := archiveGroups(bm.archiverFor(metadata), []string{topDir}, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to locate archive for %s: %w", filePath, err)
+	}
+	if len(groups) == 0 {
+		return fmt.Errorf("invalid chunk path: %s", filePath)
+	}
+
+	archiveName := groups[0].ArchiveName
+	checksum, exists := metadata.Checksums[archiveName]
+	if !exists {
+		return fmt.Errorf("archive %s not found in backup metadata", archiveName)
+	}
+
+	// 去重的文件不在压缩包中，直接从blob目录恢复
+	for _, entry := range metadata.Dedupe[archiveName] {
+		if entry.Path == entryName {
+			if _, err := bm.restoreDedupedFiles(ctx, metadata, []models.DedupeEntry{entry}, nil); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", filePath, err)
+			}
+			result.RestoredEntries = 1
+			return nil
+		}
+	}
+
+	// 优先使用tar索引定位条目
+	object := archiveObject(metadata, archiveName)
+	index, err := bm.downloadIndex(ctx, object)
+	if err != nil {
+		return err
+	}
+
+	if index != nil {
+		entry, found := archiver.FindEntry(index, entryName)
+		if !found {
+			return fmt.Errorf("%s not found in archive %s", filePath, archiveName)
+		}
+
+		if err := bm.extractIndexedEntry(ctx, object, checksum, entry); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", filePath, err)
+		}
+
+		result.RestoredArchives = 1
+		result.RestoredEntries = 1
+		return nil
+	}
+
+	// 没有索引时顺序扫描压缩包
+	logger.Debug(fmt.Sprintf("No tar index for %s, scanning archive", archiveName))
+	match := entryMatcher(filePath)
+	count, err := bm.restoreArchive(ctx, object, checksum, match)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", filePath, err)
+	}
+
+	// 恢复目录时一并恢复其中去重的文件
+	deduped, err := bm.restoreDedupedFiles(ctx, metadata, metadata.Dedupe[archiveName], match)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", filePath, err)
+	}
+	count += deduped
+	if count == 0 {
+		return fmt.Errorf("%s not found in archive %s", filePath, archiveName)
+	}
+
+	result.RestoredArchives = 1
+	result.RestoredEntries = count
+	return nil
+}
+
+// gzipMagic gzip成员开头的魔数
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// extractIndexedEntry 按tar索引恢复一个条目。gzip压缩包且后端支持按范围读取时只读取条目所在的gzip成员，
+// 成员的完整性由gzip的CRC32保证；不压缩的tar包没有CRC，与其他情况一样下载整个压缩包、校验SHA256后在本地定位
+func (bm *BackupManager) extractIndexedEntry(ctx context.Context, object, checksum string, entry *models.TarIndexEntry) error {
+	gzipped := strings.HasSuffix(object, archiver.ArchiveExtension(archiver.CompressionGzip))
+	if reader, ok := bm.storage.(storage.RangeReader); ok && gzipped && entry.Length > 0 {
+		remotePath := filepath.Join(bm.config.RemotePath, ChunkDirName, object)
+		logger.Debug(fmt.Sprintf("Reading %s from %s: %d bytes at offset %d", entry.Name, object, entry.Length, entry.Offset))
+		member, err := reader.ReadRange(ctx, remotePath, entry.Offset, entry.Length)
+		if err != nil {
+			return remoteError(err)
+		}
+		// 成员头部损坏时ExtractMember会当作不压缩的tar读取，不再校验CRC
+		if !bytes.HasPrefix(member, gzipMagic) {
+			return fmt.Errorf("corrupt gzip member for %s in %s", entry.Name, object)
+		}
+		return archiver.ExtractMember(bytes.NewReader(member), entry, bm.config.ChunkPath)
+	}
+
+	archivePath, err := bm.downloadArchive(ctx, object, checksum)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archivePath)
+
+	logger.Debug(fmt.Sprintf("Extracting %s from %s at offset %d", entry.Name, object, entry.Offset))
+	return archiver.ExtractEntry(archivePath, entry, bm.config.ChunkPath)
+}
+
+// entryPath 将用户指定的恢复路径规范化为压缩包内的条目名
+func entryPath(filePath string) string {
+	return strings.Trim(filepath.ToSlash(filepath.Clean(filePath)), "/")
+}
+
+// entryMatcher 返回匹配恢复路径本身及其下所有条目的函数
+func entryMatcher(filePath string) func(name string) bool {
+	entryName := entryPath(filePath)
+	return func(name string) bool {
+		return name == entryName || strings.HasPrefix(name, entryName+"/")
+	}
+}
+
+// restoreDirectories 按文件树重新创建已恢复压缩包中的所有目录，并恢复目录的修改时间。
+// 空目录不依赖压缩包中是否有对应的目录条目；恢复去重文件等操作改变的目录修改时间也在这里还原，
+// 恢复后的数据重新扫描时与元数据一致。只处理元数据中有校验和的压缩包，返回新创建的目录数
+func (bm *BackupManager) restoreDirectories(ctx context.Context, metadata *models.BackupMetadata, match func(name string) bool) (int, error) {
+	fileTree, err := bm.loadFileTree(ctx, metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	topDirs := make([]string, 0, len(fileTree))
+	for name := range fileTree {
+		topDirs = append(topDirs, name)
+	}
+	groups, err := archiveGroups(bm.archiverFor(metadata), topDirs, metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	dirs := make(map[string]time.Time)
+	for _, group := range groups {
+		if _, exists := metadata.Checksums[group.ArchiveName]; !exists {
+			continue
+		}
+		for _, name := range group.Directories {
+			collectDirectories(name, fileTree[name], dirs)
+		}
+	}
+
+	paths := make([]string, 0, len(dirs))
+	for name := range dirs {
+		if match == nil || match(name) {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+
+	created := 0
+	for _, name := range paths {
+		target := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(name))
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			continue
+		}
+		if err := archiver.RemoveStaleType(bm.config.ChunkPath, target, true); err != nil {
+			return created, err
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return created, fmt.Errorf("failed to create directory %s: %w", target, err)
+		}
+		created++
+	}
+
+	// 子目录排在父目录之后，逆序设置使父目录的修改时间不再被改变
+	for i := len(paths) - 1; i >= 0; i-- {
+		target := filepath.Join(bm.config.ChunkPath, filepath.FromSlash(paths[i]))
+		if err := os.Chtimes(target, dirs[paths[i]], dirs[paths[i]]); err != nil {
+			return created, fmt.Errorf("failed to set mod time for %s: %w", target, err)
+		}
+	}
+
+	if created > 0 {
+		logger.Info(fmt.Sprintf("按文件树创建了 %d 个压缩包中缺少的目录", created))
+	}
+	return created, nil
+}
+
+// collectDirectories 收集节点及其下所有目录的路径（以/分隔）和修改时间
+func collectDirectories(name string, node *models.FileTreeNode, dirs map[string]time.Time) {
+	if node == nil || !node.IsDir {
+		return
+	}
+	dirs[name] = node.ModTime
+	for childName, child := range node.Children {
+		collectDirectories(name+"/"+childName, child, dirs)
+	}
+}
+
+// restoreArchive 下载并解压单个压缩包
+func (bm *BackupManager) restoreArchive(ctx context.Context, object, checksum string, match func(name string) bool) (int, error) {
+	archivePath, err := bm.downloadArchive(ctx, object, checksum)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(archivePath)
+
+	return archiver.ExtractArchive(archivePath, bm.config.ChunkPath, match)
+}
+
+// downloadArchive 下载远程对象名为object的压缩包到临时目录并校验SHA256
+func (bm *BackupManager) downloadArchive(ctx context.Context, object, checksum string) (string, error) {
+	localPath := filepath.Join(bm.config.TempPath, object)
+	remotePath := filepath.Join(bm.config.RemotePath, ChunkDirName, object)
+
+	logger.Debug(fmt.Sprintf("Downloading archive: %s", object))
+	if err := bm.storage.DownloadFile(ctx, remotePath, localPath); err != nil {
+		return "", fmt.Errorf("failed to download archive: %w", remoteError(err))
+	}
+
+	actual, err := bm.archiver.CalculateChecksum(localPath)
+	if err != nil {
+		os.Remove(localPath)
+		return "", fmt.Errorf("failed to calculate checksum: %w", err)
+	}
+	if actual != checksum {
+		os.Remove(localPath)
+		return "", &ChecksumError{Name: object, Expected: checksum, Actual: actual}
+	}
+
+	return localPath, nil
+}
+
+// downloadIndex 下载远程对象名为object的压缩包的tar索引，不存在时返回nil
+func (bm *BackupManager) downloadIndex(ctx context.Context, object string) (*models.TarIndex, error) {
+	indexName := filepath.Base(archiver.IndexPath(object))
+	remotePath := filepath.Join(bm.config.RemotePath, IndexDirName, indexName)
+
+	exists, err := bm.storage.FileExists(ctx, remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check tar index existence: %w", remoteError(err))
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	localPath := filepath.Join(bm.config.TempPath, indexName)
+	if err := bm.storage.DownloadFile(ctx, remotePath, localPath); err != nil {
+		return nil, fmt.Errorf("failed to download tar index: %w", remoteError(err))
+	}
+	defer os.Remove(localPath)
+
+	return archiver.LoadIndex(localPath)
+}
@@ -0,0 +1,362 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pbs-backuper/internal/archiver"
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/scanner"
+)
+
+const (
+	// HistoryDirName 元数据历史快照目录，启用KeepHistory时每次备份保存一份
+	HistoryDirName = "history"
+
+	historyFilePrefix = "backup-metadata-"
+	historyFileSuffix = ".json"
+	historyTimeLayout = "20060102T150405Z"
+)
+
+// RetentionPolicy 元数据历史快照的保留策略（祖父-父-子），
+// 分别保留最近N天、N周、N月中每个周期内最新的一份快照。
+// MinAge为删除保护期：快照或其将删除的压缩包、blob在保护期内修改过时，该快照本次不清理
+type RetentionPolicy struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+	MinAge  time.Duration
+}
+
+// historyFileName 返回备份时间对应的历史快照文件名
+func historyFileName(backupTime time.Time) string {
+	return historyFilePrefix + backupTime.UTC().Format(historyTimeLayout) + historyFileSuffix
+}
+
+// parseHistoryFileName 从历史快照文件名中解析备份时间
+func parseHistoryFileName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, historyFilePrefix) || !strings.HasSuffix(name, historyFileSuffix) {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, historyFilePrefix), historyFileSuffix)
+	backupTime, err := time.Parse(historyTimeLayout, stamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return backupTime, true
+}
+
+// uploadHistory 将已保存的元数据文件另存一份到历史目录。
+// 主元数据已经上传成功，历史快照失败只记录警告，不影响本次备份结果
+func (bm *BackupManager) uploadHistory(ctx context.Context, localPath string, backupTime time.Time) {
+	remotePath := filepath.Join(bm.config.RemotePath, HistoryDirName, historyFileName(backupTime))
+	if err := bm.storage.UploadFile(ctx, localPath, remotePath); err != nil {
+		logger.Warn(fmt.Sprintf("上传元数据历史快照失败: %v", err))
+	}
+}
+
+// retain 返回应保留的快照下标，times为各快照的备份时间（顺序任意）。
+// 周期按本地时间划分，每个周期保留其中最新的快照，三种周期的结果取并集
+func (p RetentionPolicy) retain(times []time.Time) map[int]bool {
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return times[order[i]].After(times[order[j]])
+	})
+
+	keep := make(map[int]bool)
+	keepPeriods := func(count int, period func(t time.Time) string) {
+		seen := make(map[string]bool)
+		for _, i := range order {
+			key := period(times[i].Local())
+			if seen[key] {
+				continue
+			}
+			if len(seen) >= count {
+				break
+			}
+			seen[key] = true
+			keep[i] = true
+		}
+	}
+
+	keepPeriods(p.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(p.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPeriods(p.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	return keep
+}
+
+// RunPrune 按保留策略删除元数据历史快照，以及只被删除的快照引用的压缩包和blob。
+// 当前元数据、.prev和保留的快照引用的压缩包（按远程对象名）与blob不会被删除，处于保护期内的快照推迟清理。
+// dryRun为true时只计算不删除
+func (bm *BackupManager) RunPrune(ctx context.Context, policy RetentionPolicy, dryRun bool) (*models.PruneResult, error) {
+	startTime := time.Now()
+	if policy.Daily < 0 || policy.Weekly < 0 || policy.Monthly < 0 {
+		return nil, fmt.Errorf("retention counts must not be negative")
+	}
+	if policy.MinAge < 0 {
+		return nil, fmt.Errorf("minimum age must not be negative")
+	}
+	if policy.Daily+policy.Weekly+policy.Monthly == 0 {
+		return nil, fmt.Errorf("retention policy keeps no snapshots, set at least one of daily, weekly or monthly")
+	}
+
+	current, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup metadata: %w", err)
+	}
+
+	historyDir := filepath.Join(bm.config.RemotePath, HistoryDirName)
+	files, err := bm.storage.ListFiles(ctx, historyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata history: %w", remoteError(err))
+	}
+
+	var names []string
+	var times []time.Time
+	snapshotModTimes := make(map[string]time.Time)
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		backupTime, ok := parseHistoryFileName(file.Name)
+		if !ok {
+			continue
+		}
+		names = append(names, file.Name)
+		times = append(times, backupTime)
+		snapshotModTimes[file.Name] = file.ModTime
+	}
+
+	keep := policy.retain(times)
+	result := &models.PruneResult{DryRun: dryRun}
+
+	// 统计当前元数据和保留的快照引用的压缩包与blob
+	archiveRefs := make(map[string]bool)
+	blobRefs := make(map[string]bool)
+	addRefs := func(metadata *models.BackupMetadata) {
+		for name := range metadata.Checksums {
+			archiveRefs[archiveObject(metadata, name)] = true
+		}
+		for _, entries := range metadata.Dedupe {
+			for _, entry := range entries {
+				blobRefs[entry.Hash] = true
+			}
+		}
+	}
+	addRefs(current)
+	prev, err := bm.prevMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		addRefs(prev)
+	}
+
+	var pruned []string
+	for i, name := range names {
+		if !keep[i] {
+			pruned = append(pruned, name)
+			continue
+		}
+		result.RetainedSnapshots = append(result.RetainedSnapshots, name)
+		metadata, err := bm.loadMetadataFile(ctx, filepath.Join(historyDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", name, err)
+		}
+		addRefs(metadata)
+	}
+	sort.Strings(result.RetainedSnapshots)
+	sort.Strings(pruned)
+
+	snapshots := make(map[string]*models.BackupMetadata, len(pruned))
+	for _, name := range pruned {
+		metadata, err := bm.loadMetadataFile(ctx, filepath.Join(historyDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", name, err)
+		}
+		snapshots[name] = metadata
+	}
+
+	// 保护期内的快照整体推迟清理，并将其引用计入保留的引用，
+	// 避免删除快照后留下以后再也不会被清理的压缩包，也避免其他快照删除它依赖的对象
+	if policy.MinAge > 0 {
+		cutoff := startTime.Add(-policy.MinAge)
+		protected, err := bm.protectedSnapshots(ctx, pruned, snapshots, snapshotModTimes, archiveRefs, blobRefs, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		var eligible []string
+		for _, name := range pruned {
+			if protected[name] {
+				result.ProtectedSnapshots = append(result.ProtectedSnapshots, name)
+				addRefs(snapshots[name])
+				continue
+			}
+			eligible = append(eligible, name)
+		}
+		pruned = eligible
+	}
+
+	for _, name := range pruned {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("prune cancelled: %w", err)
+		}
+
+		snapshotPath := filepath.Join(historyDir, name)
+		metadata := snapshots[name]
+
+		// 先删除快照引用的对象，最后删除快照本身，中途失败时可以重新执行
+		for archive := range metadata.Checksums {
+			object := archiveObject(metadata, archive)
+			if archiveRefs[object] {
+				continue
+			}
+			if err := bm.deleteArchive(ctx, object, metadata.Sidecars[archive], dryRun); err != nil {
+				return nil, err
+			}
+			archiveRefs[object] = true // 多个删除的快照引用同一压缩包时只删除一次
+			result.DeletedArchives = append(result.DeletedArchives, object)
+		}
+		for _, entries := range metadata.Dedupe {
+			for _, entry := range entries {
+				if blobRefs[entry.Hash] {
+					continue
+				}
+				if err := bm.deleteRemote(ctx, filepath.Join(bm.config.RemotePath, BlobDirName, entry.Hash), dryRun); err != nil {
+					return nil, err
+				}
+				blobRefs[entry.Hash] = true
+				result.DeletedBlobs = append(result.DeletedBlobs, entry.Hash)
+			}
+		}
+
+		if err := bm.deleteRemote(ctx, snapshotPath, dryRun); err != nil {
+			return nil, err
+		}
+		result.PrunedSnapshots = append(result.PrunedSnapshots, name)
+	}
+
+	scanner.SortHex(result.DeletedArchives)
+	sort.Strings(result.DeletedBlobs)
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// protectedSnapshots 返回处于删除保护期内的待清理快照：快照文件本身，或它将删除的
+// （没有被保留的快照引用的）压缩包、blob的修改时间晚于cutoff。远程不存在的对象不阻止清理
+func (bm *BackupManager) protectedSnapshots(ctx context.Context, pruned []string, snapshots map[string]*models.BackupMetadata,
+	snapshotModTimes map[string]time.Time, archiveRefs, blobRefs map[string]bool, cutoff time.Time) (map[string]bool, error) {
+	archiveModTimes, err := bm.remoteModTimes(ctx, ChunkDirName)
+	if err != nil {
+		return nil, err
+	}
+	blobModTimes, err := bm.remoteModTimes(ctx, BlobDirName)
+	if err != nil {
+		return nil, err
+	}
+
+	recent := func(modTimes map[string]time.Time, name string) bool {
+		modTime, ok := modTimes[name]
+		return ok && modTime.After(cutoff)
+	}
+
+	protected := make(map[string]bool)
+	for _, name := range pruned {
+		if recent(snapshotModTimes, name) {
+			logger.Info(fmt.Sprintf("快照 %s 在保护期内，本次不清理", name))
+			protected[name] = true
+			continue
+		}
+
+		metadata := snapshots[name]
+		for archive := range metadata.Checksums {
+			object := archiveObject(metadata, archive)
+			if !archiveRefs[object] && recent(archiveModTimes, object) {
+				logger.Info(fmt.Sprintf("快照 %s 引用的压缩包 %s 在保护期内，本次不清理", name, object))
+				protected[name] = true
+				break
+			}
+		}
+		if protected[name] {
+			continue
+		}
+		for _, entries := range metadata.Dedupe {
+			for _, entry := range entries {
+				if !blobRefs[entry.Hash] && recent(blobModTimes, entry.Hash) {
+					logger.Info(fmt.Sprintf("快照 %s 引用的blob %s 在保护期内，本次不清理", name, entry.Hash))
+					protected[name] = true
+					break
+				}
+			}
+			if protected[name] {
+				break
+			}
+		}
+	}
+	return protected, nil
+}
+
+// remoteModTimes 列出远程目录中各文件的修改时间
+func (bm *BackupManager) remoteModTimes(ctx context.Context, dir string) (map[string]time.Time, error) {
+	files, err := bm.storage.ListFiles(ctx, filepath.Join(bm.config.RemotePath, dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, remoteError(err))
+	}
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if !file.IsDir {
+			modTimes[file.Name] = file.ModTime
+		}
+	}
+	return modTimes, nil
+}
+
+// archiveFiles 返回远程对象名为object的压缩包的全部远程文件（相对RemotePath，以/分隔）：
+// 压缩包、校验和文件、tar索引和附加文件
+func archiveFiles(object string, sidecars []models.Sidecar) []string {
+	files := []string{
+		path.Join(ChunkDirName, object),
+		path.Join(Sha256DirName, object+".sha256"),
+		path.Join(IndexDirName, archiver.IndexPath(object)),
+	}
+	for _, sidecar := range sidecars {
+		files = append(files, path.Join(Sha256DirName, sidecar.Name))
+	}
+	return files
+}
+
+// deleteArchive 删除远程对象名为object的压缩包及其校验和文件、附加文件和tar索引（不存在的文件忽略）
+func (bm *BackupManager) deleteArchive(ctx context.Context, object string, sidecars []models.Sidecar, dryRun bool) error {
+	for _, file := range archiveFiles(object, sidecars) {
+		if err := bm.deleteRemote(ctx, filepath.Join(bm.config.RemotePath, filepath.FromSlash(file)), dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRemote 删除远程文件，dryRun时只记录日志
+func (bm *BackupManager) deleteRemote(ctx context.Context, remotePath string, dryRun bool) error {
+	if dryRun {
+		logger.Info(fmt.Sprintf("[dry-run] 将删除: %s", remotePath))
+		return nil
+	}
+	logger.Debug(fmt.Sprintf("Deleting remote file: %s", remotePath))
+	if err := bm.storage.DeleteFile(ctx, remotePath); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", remotePath, remoteError(err))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pbs-backuper/internal/logger"
+)
+
+const (
+	// LatestFileName 启用每次运行独立子目录时，记录最新一次运行子目录名的指针文件，位于顶层远程路径下
+	LatestFileName = "latest"
+
+	// runSubdirLayout 每次运行子目录名的时间格式（UTC），如2024-01-01T12-00
+	runSubdirLayout = "2006-01-02T15-04"
+)
+
+// LatestRun 读取base下的latest指针，返回最新一次运行的子目录名；还没有指针时返回空字符串
+func (bm *BackupManager) LatestRun(ctx context.Context, base string) (string, error) {
+	latestPath := filepath.Join(base, LatestFileName)
+	exists, err := bm.storage.FileExists(ctx, latestPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to check %s: %w", LatestFileName, remoteError(err))
+	}
+	if !exists {
+		return "", nil
+	}
+	content, err := bm.storage.GetFileContent(ctx, latestPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", LatestFileName, remoteError(err))
+	}
+	name := strings.TrimSpace(string(content))
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid %s pointer %q", LatestFileName, name)
+	}
+	return name, nil
+}
+
+// UseLatestRun 把RemotePath改为latest指向的最新一次运行的子目录，供只读取备份的操作使用
+func (bm *BackupManager) UseLatestRun(ctx context.Context) error {
+	latest, err := bm.LatestRun(ctx, bm.config.RemotePath)
+	if err != nil {
+		return err
+	}
+	if latest == "" {
+		return fmt.Errorf("no %s pointer under %s", LatestFileName, bm.config.RemotePath)
+	}
+	bm.config.RemotePath = filepath.Join(bm.config.RemotePath, latest)
+	return nil
+}
+
+// PrepareRunSubdir 在RemotePath下为本次运行创建以开始时间命名的子目录，并把RemotePath改为该子目录。
+// 增量和自动模式先把latest指向的上次运行完整复制到新子目录（后端支持时在服务端完成），
+// 之后按上次的元数据只上传变化的压缩包，因此每个子目录都是可以单独恢复的完整备份。
+// 备份成功后调用UpdateLatest更新指针
+func (bm *BackupManager) PrepareRunSubdir(ctx context.Context) error {
+	base := bm.config.RemotePath
+	latest, err := bm.LatestRun(ctx, base)
+	if err != nil {
+		return err
+	}
+
+	name := bm.now().UTC().Format(runSubdirLayout)
+	runPath := filepath.Join(base, name)
+	exists, err := bm.storage.FileExists(ctx, filepath.Join(runPath, MetadataFileName))
+	if err != nil {
+		return fmt.Errorf("failed to check run directory: %w", remoteError(err))
+	}
+	if exists || name == latest {
+		return fmt.Errorf("remote run directory %s already exists", runPath)
+	}
+
+	if latest != "" && bm.config.Mode != "full" {
+		logger.Info(fmt.Sprintf("复制上次运行 %s 到 %s", latest, name))
+		previous := *bm.config
+		previous.RemotePath = filepath.Join(base, latest)
+		if _, err := NewBackupManager(&previous, bm.storage).RunClone(ctx, runPath, false); err != nil {
+			return fmt.Errorf("failed to copy previous run %s: %w", latest, err)
+		}
+	} else if err := bm.storage.MkdirRemote(ctx, runPath); err != nil {
+		return fmt.Errorf("failed to create run directory: %w", remoteError(err))
+	}
+
+	bm.runBase = base
+	bm.config.RemotePath = runPath
+	return nil
+}
+
+// UpdateLatest 把顶层的latest指针指向本次运行的子目录，没有调用PrepareRunSubdir时不做任何事
+func (bm *BackupManager) UpdateLatest(ctx context.Context) error {
+	if bm.runBase == "" {
+		return nil
+	}
+	name := filepath.Base(bm.config.RemotePath)
+	localPath := filepath.Join(bm.config.TempPath, LatestFileName)
+	bm.tempFiles.track(localPath)
+	defer bm.tempFiles.remove(localPath)
+	if err := os.WriteFile(localPath, []byte(name+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", LatestFileName, err)
+	}
+	if err := bm.uploadFile(ctx, localPath, filepath.Join(bm.runBase, LatestFileName), int64(len(name)+1)); err != nil {
+		return fmt.Errorf("failed to update %s: %w", LatestFileName, err)
+	}
+	logger.Info(fmt.Sprintf("%s 已指向 %s", LatestFileName, name))
+	return nil
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// sourceChecksum 计算分组源内容的校验和：按路径排序的每个文件的路径、大小和内容SHA256，
+// 以及空目录和去重时省略的文件路径。与压缩包的校验和不同，不受压缩方式和修改时间影响。
+// 文件树中有文件没有记录内容哈希时（扫描时未计算SHA256）返回空字符串
+func sourceChecksum(group *models.ArchiveGroup, fileTree map[string]*models.FileTreeNode) string {
+	hash := sha256.New()
+	var walk func(path string, node *models.FileTreeNode) bool
+	walk = func(path string, node *models.FileTreeNode) bool {
+		if !node.IsDir {
+			if node.Hash == "" {
+				return false
+			}
+			fmt.Fprintf(hash, "f %s %d %s\n", path, node.Size, node.Hash)
+			return true
+		}
+		fmt.Fprintf(hash, "d %s\n", path)
+		names := make([]string, 0, len(node.Children))
+		for name := range node.Children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !walk(path+"/"+name, node.Children[name]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, dir := range group.Directories {
+		node, exists := fileTree[dir]
+		if !exists || !walk(dir, node) {
+			return ""
+		}
+	}
+	for _, entry := range group.Deduped {
+		fmt.Fprintf(hash, "x %s\n", entry.Path)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// setSourceChecksum 按元数据中的文件树记录分组的源内容校验和，无法计算时删除旧记录
+func setSourceChecksum(metadata *models.BackupMetadata, group *models.ArchiveGroup) {
+	sum := sourceChecksum(group, metadata.FileTree)
+	if sum == "" {
+		delete(metadata.SourceSums, group.ArchiveName)
+		return
+	}
+	if metadata.SourceSums == nil {
+		metadata.SourceSums = make(map[string]string)
+	}
+	metadata.SourceSums[group.ArchiveName] = sum
+}
+
+// smartFullBaseline 加载--smart-full比较用的上次备份元数据。没有元数据、无法读取、datastore不同
+// 或压缩包格式不同时返回nil，全量备份照常重新生成所有压缩包
+func (bm *BackupManager) smartFullBaseline(ctx context.Context, datastoreID string) *models.BackupMetadata {
+	previous, err := bm.loadRemoteMetadata(ctx)
+	if errors.Is(err, ErrNoMetadata) {
+		logger.Info("智能全量备份: 远程没有上次的备份元数据，重新生成所有压缩包")
+		return nil
+	}
+	if err != nil {
+		logger.Warn(fmt.Sprintf("智能全量备份: 无法读取上次的备份元数据（%v），重新生成所有压缩包", err))
+		return nil
+	}
+	if previous.DatastoreID != "" && previous.DatastoreID != datastoreID {
+		logger.Warn(fmt.Sprintf("智能全量备份: datastore标识不一致（上次 %s，本次 %s），重新生成所有压缩包", previous.DatastoreID, datastoreID))
+		return nil
+	}
+	if !sameCompression(previous.ArchiveFormat, bm.config.Compression) {
+		logger.Info("智能全量备份: 压缩包格式与上次不同，重新生成所有压缩包")
+		return nil
+	}
+	if previous.TreeHash != metadataTreeHash(bm.config.TreeHash) {
+		logger.Info("智能全量备份: 文件哈希算法与上次不同，源内容校验和无法比较，重新生成所有压缩包")
+		return nil
+	}
+	return previous
+}
+
+// reuseArchive 分组的源内容校验和与上次备份记录的相同时，沿用上次的压缩包及其校验和、
+// 压缩方式、去重清单、附加文件、对象名和哈希，不重新打包和上传
+func reuseArchive(group *models.ArchiveGroup, previous, metadata *models.BackupMetadata) bool {
+	name := group.ArchiveName
+	checksum, exists := previous.Checksums[name]
+	sum := sourceChecksum(group, metadata.FileTree)
+	if !exists || sum == "" || previous.SourceSums[name] != sum {
+		return false
+	}
+
+	metadata.Checksums[name] = checksum
+	metadata.SourceSums[name] = sum
+	if compression, ok := previous.Compression[name]; ok {
+		metadata.Compression[name] = compression
+	}
+	if entries, ok := previous.Dedupe[name]; ok {
+		metadata.Dedupe[name] = entries
+	}
+	if sidecars, ok := previous.Sidecars[name]; ok {
+		metadata.Sidecars[name] = sidecars
+	}
+	if object, ok := previous.Objects[name]; ok {
+		setArchiveObject(metadata, name, object)
+	}
+	setArchiveHashes(metadata, name, previous.Hashes[name])
+	return true
+}
@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/pbs"
+)
+
+// DefaultPBSVerifySample 抽样校验源chunk的默认比例
+const DefaultPBSVerifySample = 0.01
+
+// verifySourceChunks 按PBSVerifySample比例抽样校验目录中的PBS chunk文件，
+// 在打包前发现源数据损坏，损坏的chunk记录到result.CorruptChunks（相对ChunkPath的路径）。
+// 文件名不是chunk摘要的文件跳过；读取失败（例如被PBS垃圾回收删除）只记录警告。
+// 启用PBSVerifyAbort且发现损坏时返回CorruptSourceError
+func (bm *BackupManager) verifySourceChunks(fileTree map[string]*models.FileTreeNode, directories []string, result *models.BackupResult) error {
+	sample := bm.config.PBSVerifySample
+	if sample <= 0 {
+		sample = DefaultPBSVerifySample
+	}
+
+	var checked int
+	var walk func(relPath string, node *models.FileTreeNode)
+	walk = func(relPath string, node *models.FileTreeNode) {
+		if node.IsDir {
+			for name, child := range node.Children {
+				walk(filepath.Join(relPath, name), child)
+			}
+			return
+		}
+		if !pbs.IsChunkName(node.Name) || rand.Float64() >= sample {
+			return
+		}
+
+		checked++
+		err := pbs.VerifyChunk(filepath.Join(bm.config.ChunkPath, relPath))
+		switch {
+		case errors.Is(err, pbs.ErrCorruptChunk):
+			logger.Error(fmt.Sprintf("源chunk已损坏: %s, %v", relPath, err))
+			result.CorruptChunks = append(result.CorruptChunks, filepath.ToSlash(relPath))
+		case err != nil:
+			logger.Warn(fmt.Sprintf("无法读取源chunk %s: %v", relPath, err))
+		}
+	}
+	for _, dir := range directories {
+		if node, ok := fileTree[dir]; ok {
+			walk(dir, node)
+		}
+	}
+
+	sort.Strings(result.CorruptChunks)
+	logger.Info(fmt.Sprintf("抽样校验源chunk %d 个，损坏 %d 个", checked, len(result.CorruptChunks)))
+	if len(result.CorruptChunks) > 0 && bm.config.PBSVerifyAbort {
+		return &CorruptSourceError{Chunks: result.CorruptChunks}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// scanCutoff 扫描时使用的修改时间截止点：NewerThan与分阶段全量备份的StagedCutoff中较晚的一个
+func scanCutoff(config *models.Config) time.Time {
+	if config.StagedCutoff.After(config.NewerThan) {
+		return config.StagedCutoff
+	}
+	return config.NewerThan
+}
+
+// pendingDirectories 返回因修改时间早于截止点被跳过、且不在文件树中的目录，即从未备份过的目录。
+// 增量备份中已备份过的目录沿用上次的记录，不计入
+func (bm *BackupManager) pendingDirectories(fileTree map[string]*models.FileTreeNode) []string {
+	var pending []string
+	for _, dir := range bm.scanner.StaleDirectories() {
+		if _, exists := fileTree[dir]; !exists {
+			pending = append(pending, dir)
+		}
+	}
+	return pending
+}
+
+// trackPending 记录分阶段备份中仍未备份的目录。previous为上次元数据中的待备份目录，
+// 上次的待备份目录本次已扫描时作为新增目录打包，日志中报告完成的数量
+func (bm *BackupManager) trackPending(metadata *models.BackupMetadata, previous []string, result *models.BackupResult) {
+	metadata.PendingDirs = bm.pendingDirectories(metadata.FileTree)
+	result.PendingDirs = len(metadata.PendingDirs)
+
+	completed := 0
+	for _, dir := range previous {
+		if _, exists := metadata.FileTree[dir]; exists {
+			completed++
+		}
+	}
+	if completed > 0 {
+		logger.Info(fmt.Sprintf("本次备份了%d个上次待备份的目录", completed))
+	}
+	if len(metadata.PendingDirs) > 0 {
+		logger.Info(fmt.Sprintf("还有%d个目录尚未备份，之后的增量备份会继续处理", len(metadata.PendingDirs)))
+	} else if len(previous) > 0 {
+		logger.Info("分阶段备份已完成，所有目录都已备份")
+	}
+}
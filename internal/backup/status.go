@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+	"pbs-backuper/internal/storage"
+)
+
+const (
+	// StatusFileName 运行状态文件名，写入TempPath，启用StatusRemote时同时上传到RemotePath
+	StatusFileName = "status.json"
+
+	// 状态文件中的运行阶段
+	PhaseScanning  = "scanning"
+	PhaseArchiving = "archiving"
+	PhaseMetadata  = "metadata"
+	PhaseDone      = "done"
+	PhaseFailed    = "failed"
+	PhasePartial   = "partial" // 达到最长运行时间，部分压缩包组推迟到下次运行
+
+	// statusUploadInterval 上传远程状态文件的最小间隔，避免每个压缩包都多一次远程写入
+	statusUploadInterval = time.Minute
+	// statusUploadTimeout 单次上传远程状态文件的超时，状态文件不应拖慢备份
+	statusUploadTimeout = 30 * time.Second
+)
+
+// statusTracker 在处理循环中更新运行状态并写入状态文件。
+// 方法可以在nil上调用（未启用状态文件），写入失败只记录警告，不影响备份
+type statusTracker struct {
+	config  *models.Config
+	storage storage.Storage
+	status  models.RunStatus
+
+	groupsStart time.Time // 开始处理压缩包组的时间，用于估算剩余时间
+	lastUpload  time.Time // 上次上传远程状态文件的时间
+}
+
+// newStatusTracker 创建运行状态跟踪器
+func newStatusTracker(config *models.Config, storage storage.Storage, runID string) *statusTracker {
+	return &statusTracker{
+		config:  config,
+		storage: storage,
+		status: models.RunStatus{
+			RunID:     runID,
+			Mode:      config.Mode,
+			StartTime: time.Now(),
+		},
+	}
+}
+
+// setPhase 进入新的运行阶段
+func (t *statusTracker) setPhase(phase string) {
+	if t == nil {
+		return
+	}
+	t.status.Phase = phase
+	t.write(false)
+}
+
+// startGroups 开始处理total个压缩包组
+func (t *statusTracker) startGroups(total int) {
+	if t == nil {
+		return
+	}
+	t.groupsStart = time.Now()
+	t.status.Phase = PhaseArchiving
+	t.status.GroupsTotal = total
+	t.status.GroupsCompleted = 0
+	t.status.ETASeconds = 0
+	t.write(false)
+}
+
+// groupDone 记录一个压缩包组处理完成（包括失败），按已处理分组的平均耗时估算剩余时间
+func (t *statusTracker) groupDone() {
+	if t == nil {
+		return
+	}
+	t.status.GroupsCompleted++
+	remaining := t.status.GroupsTotal - t.status.GroupsCompleted
+	if remaining > 0 {
+		perGroup := time.Since(t.groupsStart) / time.Duration(t.status.GroupsCompleted)
+		t.status.ETASeconds = int64((perGroup * time.Duration(remaining)).Seconds())
+	} else {
+		t.status.ETASeconds = 0
+	}
+	t.write(false)
+}
+
+// addUploaded 累计已上传的压缩包字节数，在下一次写入状态文件时体现
+func (t *statusTracker) addUploaded(bytes int64) {
+	if t == nil {
+		return
+	}
+	t.status.BytesUploaded += bytes
+}
+
+// finish 记录运行结束，立即写入并上传最终状态
+func (t *statusTracker) finish(err error) {
+	if t == nil {
+		return
+	}
+	t.status.Phase = PhaseDone
+	t.status.Error = ""
+	if err != nil {
+		t.status.Phase = PhaseFailed
+		t.status.Error = err.Error()
+	}
+	if errors.Is(err, ErrTimeBudget) {
+		t.status.Phase = PhasePartial
+	}
+	t.status.ETASeconds = 0
+	t.write(true)
+}
+
+// write 写入本地状态文件，先写临时文件再改名，读取方不会看到写了一半的内容。
+// 启用StatusRemote时按间隔上传，force为true时总是上传
+func (t *statusTracker) write(force bool) {
+	t.status.UpdateTime = time.Now()
+	data, err := json.MarshalIndent(t.status, "", "  ")
+	if err != nil {
+		logger.Warn(fmt.Sprintf("序列化运行状态失败: %v", err))
+		return
+	}
+
+	localPath := filepath.Join(t.config.TempPath, StatusFileName)
+	tempPath := localPath + ".tmp"
+	if err := os.MkdirAll(t.config.TempPath, 0755); err != nil {
+		logger.Warn(fmt.Sprintf("写入状态文件失败: %v", err))
+		return
+	}
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		logger.Warn(fmt.Sprintf("写入状态文件失败: %v", err))
+		return
+	}
+	if err := os.Rename(tempPath, localPath); err != nil {
+		os.Remove(tempPath)
+		logger.Warn(fmt.Sprintf("写入状态文件失败: %v", err))
+		return
+	}
+
+	if !t.config.StatusRemote || !force && time.Since(t.lastUpload) < statusUploadInterval {
+		return
+	}
+	t.lastUpload = time.Now()
+
+	// 最终状态在备份被取消后也要上传，因此不使用备份的上下文
+	ctx, cancel := context.WithTimeout(context.Background(), statusUploadTimeout)
+	defer cancel()
+	if err := t.storage.UploadFile(ctx, localPath, filepath.Join(t.config.RemotePath, StatusFileName)); err != nil {
+		logger.Warn(fmt.Sprintf("上传状态文件失败: %v", remoteError(err)))
+	}
+}
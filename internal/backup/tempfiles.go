@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"pbs-backuper/internal/logger"
+)
+
+// tempFileManager 统一管理备份过程中创建的临时文件。
+// 文件创建后登记，正常处理完毕时单独删除；上下文取消或一次运行结束时
+// 删除所有仍然登记的文件，避免中途退出时临时文件残留。
+type tempFileManager struct {
+	mu    sync.Mutex
+	files map[string]struct{}
+}
+
+// newTempFileManager 创建临时文件管理器
+func newTempFileManager() *tempFileManager {
+	return &tempFileManager{
+		files: make(map[string]struct{}),
+	}
+}
+
+// track 登记临时文件
+func (m *tempFileManager) track(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path] = struct{}{}
+}
+
+// remove 删除临时文件并取消登记
+func (m *tempFileManager) remove(path string) {
+	m.mu.Lock()
+	delete(m.files, path)
+	m.mu.Unlock()
+
+	removeTempFile(path)
+}
+
+// flush 删除所有仍然登记的临时文件
+func (m *tempFileManager) flush() {
+	m.mu.Lock()
+	files := m.files
+	m.files = make(map[string]struct{})
+	m.mu.Unlock()
+
+	for path := range files {
+		removeTempFile(path)
+	}
+}
+
+// guard 在上下文取消时立即清理临时文件，返回的函数在运行结束时调用，
+// 停止监听并清理剩余文件。通常的用法是 defer bm.tempFiles.guard(ctx)()
+func (m *tempFileManager) guard(ctx context.Context) func() {
+	stop := context.AfterFunc(ctx, m.flush)
+	return func() {
+		stop()
+		m.flush()
+	}
+}
+
+// removeTempFile 删除文件，文件已不存在时忽略
+func removeTempFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logger.Warn(fmt.Sprintf("清理临时文件失败: %s, %v", path, err))
+	}
+}
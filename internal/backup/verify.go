@@ -0,0 +1,268 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// DefaultVerifyConcurrency 默认同时校验的压缩包数
+const DefaultVerifyConcurrency = 4
+
+// MismatchPolicy 校验发现压缩包损坏或缺失时的处理方式
+type MismatchPolicy string
+
+const (
+	MismatchReport MismatchPolicy = "report" // 只报告（默认）
+	MismatchRepair MismatchPolicy = "repair" // 用当前chunk目录重新生成并上传失败的压缩包
+	MismatchFail   MismatchPolicy = "fail"   // 发现第一个失败立即停止
+)
+
+// ParseMismatchPolicy 解析校验失败处理方式，空字符串表示默认的report
+func ParseMismatchPolicy(policy string) (MismatchPolicy, error) {
+	switch MismatchPolicy(policy) {
+	case "":
+		return MismatchReport, nil
+	case MismatchReport, MismatchRepair, MismatchFail:
+		return MismatchPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid mismatch policy %q (expected %s, %s or %s)", policy, MismatchReport, MismatchRepair, MismatchFail)
+	}
+}
+
+// RunVerify 下载远程压缩包并与元数据中的SHA256比对。
+// 最多同时校验concurrency个压缩包，所有失败项汇总后按压缩包名称排序返回。
+// policy为MismatchFail时发现第一个失败即停止，同时返回已有的结果和ChecksumError；
+// 为MismatchRepair时校验结束后重新生成失败的压缩包，成功的失败项标记为Repaired。
+// 启用ProviderHash时先比较服务端记录的CRC32C或MD5，只下载没有可比较哈希的压缩包。
+func (bm *BackupManager) RunVerify(ctx context.Context, concurrency int, policy MismatchPolicy) (*models.VerifyResult, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1, got %d", concurrency)
+	}
+	return bm.runVerify(ctx, newFixedLimiter(concurrency), policy)
+}
+
+// RunVerifyAdaptive 与RunVerify相同，但并发数在[minConcurrency, maxConcurrency]内自动调整：
+// 从下限开始，每个压缩包完成后按下载字节数统计吞吐量，吞吐量提高时增加并发，不再提高时回退并保持，
+// 下载出错时减半
+func (bm *BackupManager) RunVerifyAdaptive(ctx context.Context, minConcurrency, maxConcurrency int, policy MismatchPolicy) (*models.VerifyResult, error) {
+	if minConcurrency < 1 || maxConcurrency < minConcurrency {
+		return nil, fmt.Errorf("invalid concurrency bounds %d-%d", minConcurrency, maxConcurrency)
+	}
+	return bm.runVerify(ctx, newAdaptiveLimiter(minConcurrency, maxConcurrency), policy)
+}
+
+// runVerify 校验的处理过程，limiter控制同时校验的压缩包数
+func (bm *BackupManager) runVerify(ctx context.Context, limiter workerLimiter, policy MismatchPolicy) (*models.VerifyResult, error) {
+	defer bm.tempFiles.guard(ctx)()
+
+	startTime := time.Now()
+	metadata, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup metadata: %w", err)
+	}
+
+	archiveNames := sortedArchiveNames(metadata.Checksums)
+
+	// MismatchFail时通过stop取消其余校验，与外部取消区分开
+	verifyCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var (
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+		mismatches   []models.VerifyMismatch
+		checked      int
+		hashVerified int
+		failed       bool
+	)
+
+	// 先用服务端哈希校验，剩余的压缩包再下载校验
+	if bm.config.ProviderHash {
+		archiveNames, mismatches, hashVerified = bm.verifyProviderHashes(ctx, metadata, archiveNames)
+		checked = hashVerified + len(mismatches)
+		if policy == MismatchFail && len(mismatches) > 0 {
+			failed = true
+			archiveNames = nil
+		}
+	}
+
+	for _, name := range archiveNames {
+		if err := verifyCtx.Err(); err != nil {
+			break
+		}
+
+		wg.Add(1)
+		limiter.acquire()
+		go func(name string) {
+			defer wg.Done()
+
+			mismatch, size := bm.verifyArchive(verifyCtx, name, archiveObject(metadata, name), metadata.Checksums[name], metadata.Sidecars[name])
+			limiter.release(size, mismatch != nil && size == 0)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if failed {
+				// 已因第一个失败停止，之后的结果可能只是被取消
+				return
+			}
+			checked++
+			if mismatch != nil {
+				mismatches = append(mismatches, *mismatch)
+				if policy == MismatchFail {
+					failed = true
+					stop()
+				}
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("verify cancelled: %w", err)
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Archive < mismatches[j].Archive
+	})
+
+	result := &models.VerifyResult{
+		VerifiedArchives: checked,
+		HashVerified:     hashVerified,
+		Mismatches:       mismatches,
+	}
+
+	if failed {
+		result.Duration = time.Since(startTime)
+		first := mismatches[0]
+		if first.Error != "" {
+			return result, fmt.Errorf("verify stopped at %s: %s: %w", first.Archive, first.Error, ErrChecksumMismatch)
+		}
+		return result, &ChecksumError{Name: first.Archive, Expected: first.Expected, Actual: first.Actual}
+	}
+
+	if policy == MismatchRepair && len(mismatches) > 0 {
+		if err := bm.repairArchives(ctx, metadata, result.Mismatches); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// repairArchives 用当前chunk目录重新生成校验失败的压缩包并上传，成功的失败项标记为Repaired。
+// 只有chunk目录仍完好时修复才有意义；修复后的压缩包反映当前数据，下次增量备份会照常比较文件树。
+func (bm *BackupManager) repairArchives(ctx context.Context, metadata *models.BackupMetadata, mismatches []models.VerifyMismatch) error {
+	fileTree, err := bm.scanner.ScanFileTree()
+	if err != nil {
+		return fmt.Errorf("failed to scan current file tree: %w", err)
+	}
+	directories, err := bm.scanner.GetChunkDirectories()
+	if err != nil {
+		return fmt.Errorf("failed to get chunk directories: %w", err)
+	}
+	result := &models.BackupResult{Details: make(map[string]string)}
+	directories = bm.filterScannedDirectories(directories, fileTree, result)
+
+	// 重新生成的压缩包沿用元数据记录的格式
+	bm.archiver = bm.archiverFor(metadata)
+	groups, err := archiveGroups(bm.archiver, directories, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to generate archive groups: %w", err)
+	}
+	if bm.config.Dedupe || len(metadata.Dedupe) > 0 {
+		assignDedupedFiles(groups, fileTree)
+	}
+	byName := make(map[string]*models.ArchiveGroup, len(groups))
+	for _, group := range groups {
+		byName[group.ArchiveName] = group
+	}
+
+	if metadata.Dedupe == nil {
+		metadata.Dedupe = make(map[string][]models.DedupeEntry)
+	}
+	if metadata.Compression == nil {
+		metadata.Compression = make(map[string]string)
+	}
+	if metadata.Sidecars == nil {
+		metadata.Sidecars = make(map[string][]models.Sidecar)
+	}
+
+	repaired := 0
+	for i := range mismatches {
+		name := mismatches[i].Archive
+		group, exists := byName[name]
+		if !exists {
+			logger.Error(fmt.Sprintf("无法修复压缩包 %s: 当前chunk目录中没有对应的目录", name))
+			continue
+		}
+		if err := bm.processArchiveGroup(ctx, group, metadata, result, false); err != nil {
+			logger.Error(fmt.Sprintf("修复压缩包失败: %s, %v", name, err))
+			continue
+		}
+		logger.Info(fmt.Sprintf("已修复压缩包: %s", name))
+		mismatches[i].Repaired = true
+		repaired++
+	}
+
+	if repaired == 0 {
+		return nil
+	}
+
+	// 重新上传元数据前取回拆分保存的文件树，按当前配置重新保存
+	if _, err := bm.loadFileTree(ctx, metadata); err != nil {
+		return err
+	}
+	metadata.FileTreeFile = ""
+	metadata.FileTreeSHA256 = ""
+	if err := bm.saveAndUploadMetadata(ctx, metadata); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	return nil
+}
+
+// verifyArchive 下载单个压缩包（远程对象名为object）并计算SHA256，再校验元数据记录的附加文件（如签名），
+// 校验通过时返回nil。同时返回下载的字节数，下载失败时为0
+func (bm *BackupManager) verifyArchive(ctx context.Context, archiveName, object, expected string, sidecars []models.Sidecar) (*models.VerifyMismatch, int64) {
+	localPath := filepath.Join(bm.config.TempPath, object)
+	remotePath := filepath.Join(bm.config.RemotePath, ChunkDirName, object)
+	bm.tempFiles.track(localPath)
+	defer bm.tempFiles.remove(localPath)
+
+	logger.Debug(fmt.Sprintf("Verifying archive: %s", archiveName))
+	if err := bm.storage.DownloadFile(ctx, remotePath, localPath); err != nil {
+		logger.Error(fmt.Sprintf("下载压缩包失败: %s, %v", archiveName, err))
+		return &models.VerifyMismatch{Archive: archiveName, Expected: expected, Error: err.Error()}, 0
+	}
+	var size int64
+	if info, err := os.Stat(localPath); err == nil {
+		size = info.Size()
+	}
+
+	actual, err := bm.archiver.CalculateChecksum(localPath)
+	if err != nil {
+		logger.Error(fmt.Sprintf("计算校验和失败: %s, %v", archiveName, err))
+		return &models.VerifyMismatch{Archive: archiveName, Expected: expected, Error: err.Error()}, size
+	}
+
+	if actual != expected {
+		logger.Error(fmt.Sprintf("校验和不匹配: %s", archiveName))
+		return &models.VerifyMismatch{Archive: archiveName, Expected: expected, Actual: actual}, size
+	}
+
+	if err := bm.verifySidecars(ctx, localPath, sidecars); err != nil {
+		logger.Error(fmt.Sprintf("附加文件校验失败: %s, %v", archiveName, err))
+		return &models.VerifyMismatch{Archive: archiveName, Expected: expected, Error: err.Error()}, size
+	}
+
+	logger.Info(fmt.Sprintf("校验通过: %s", archiveName))
+	return nil, size
+}
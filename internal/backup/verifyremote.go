@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"pbs-backuper/internal/logger"
+	"pbs-backuper/internal/models"
+)
+
+// runVerifyRemoteBackup 不依赖上次文件树的增量备份（VerifyRemote）：为所有分组创建压缩包并计算SHA256，
+// 只上传与远程.sha256文件不一致的压缩包。需要读取并打包全部数据，但在元数据丢失或被篡改后仍然可靠。
+// 远程元数据可以读取时只沿用其中的分组方式（前缀位数、批次大小、压缩包格式）和datastore检查，
+// 否则按命令行的前缀位数分组
+func (bm *BackupManager) runVerifyRemoteBackup(ctx context.Context) (*models.BackupResult, error) {
+	startTime := time.Now()
+	result := &models.BackupResult{
+		RunID:   bm.runID,
+		Details: make(map[string]string),
+	}
+
+	datastoreID, err := bm.datastoreID()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixDigits, dirBatchSize := bm.config.PrefixDigits, bm.config.DirBatchSize
+	var mergedRanges []string
+	format := metadataFormat(bm.config.Compression)
+	sidecars := make(map[string][]models.Sidecar)
+	objects := make(map[string]string)
+	oldMetadata, err := bm.loadRemoteMetadata(ctx)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("无法读取远程备份元数据（%v），按前缀位数 %d 分组", err, prefixDigits))
+	} else {
+		if err := bm.checkDatastore(oldMetadata, datastoreID); err != nil {
+			return nil, err
+		}
+		if err := bm.checkNamespace(oldMetadata); err != nil {
+			return nil, err
+		}
+		prefixDigits, dirBatchSize, format = oldMetadata.PrefixDigits, oldMetadata.DirBatchSize, oldMetadata.ArchiveFormat
+		mergedRanges = oldMetadata.MergedRanges
+		bm.archiver = bm.archiverFor(oldMetadata)
+		// 跳过上传的压缩包在远程的对象和附加文件仍然有效，verify会重新校验
+		for name, list := range oldMetadata.Sidecars {
+			sidecars[name] = list
+		}
+		for name := range oldMetadata.Checksums {
+			objects[name] = archiveObject(oldMetadata, name)
+		}
+	}
+
+	if err := bm.requireChunkDirectories(); err != nil {
+		return nil, err
+	}
+
+	// 1. 扫描文件树并获取chunk目录列表
+	bm.status.setPhase(PhaseScanning)
+	fileTree, err := bm.scanner.ScanFileTree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan file tree: %w", err)
+	}
+	directories, err := bm.scanner.GetChunkDirectories()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chunk directories: %w", err)
+	}
+	directories = bm.filterScannedDirectories(directories, fileTree, result)
+
+	if bm.config.PBSVerify {
+		if err := bm.verifySourceChunks(fileTree, directories, result); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. 生成分组，全部需要处理
+	groups, err := bm.archiver.GenerateBatchedArchiveGroups(directories, prefixDigits, dirBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate archive groups: %w", err)
+	}
+	if oldMetadata != nil {
+		groups, err = bm.archiver.MergeArchiveGroups(groups, mergedRanges)
+	} else {
+		groups, mergedRanges, err = bm.mergeSmallGroups(groups, fileTree)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge archive groups: %w", err)
+	}
+	if bm.config.Dedupe {
+		assignDedupedFiles(groups, fileTree)
+	}
+
+	// 3. 创建所有压缩包，与远程校验和文件相同的跳过上传
+	metadata := &models.BackupMetadata{
+		Version:       MetadataVersion,
+		PrefixDigits:  prefixDigits,
+		DirBatchSize:  dirBatchSize,
+		MergedRanges:  mergedRanges,
+		DatastoreID:   datastoreID,
+		BackupTime:    startTime,
+		FileTree:      fileTree,
+		Checksums:     make(map[string]string),
+		Dedupe:        make(map[string][]models.DedupeEntry),
+		Compression:   make(map[string]string),
+		Sidecars:      make(map[string][]models.Sidecar),
+		ArchiveFormat: format,
+		TreeHash:      metadataTreeHash(bm.config.TreeHash),
+	}
+	if oldMetadata != nil {
+		metadata.ChecksumFiles = oldMetadata.ChecksumFiles
+	}
+	if oldMetadata != nil && len(oldMetadata.PendingDirs) > 0 {
+		bm.trackPending(metadata, oldMetadata.PendingDirs, result)
+	}
+	// 先并发读取所有分组的远程校验和，对象名沿用上次的元数据
+	previous := make([]string, len(groups))
+	for i, group := range groups {
+		previous[i] = group.ArchiveName
+		if object, exists := objects[group.ArchiveName]; exists {
+			previous[i] = object
+		}
+	}
+	// 元数据丢失时按本次的配置判断远程是否有汇总校验和文件，读取失败时退回逐个读取
+	bm.prefetchRemoteChecksums(ctx, previous, hasChecksumBundle(metadata) || oldMetadata == nil && bm.writesBundle())
+
+	bm.status.startGroups(len(groups))
+	for _, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("backup cancelled: %w", err)
+		}
+		if bm.outOfTime() {
+			deferGroup(group, metadata, nil, result)
+			continue
+		}
+
+		metadata.Sidecars[group.ArchiveName] = sidecars[group.ArchiveName]
+		if len(metadata.Sidecars[group.ArchiveName]) == 0 {
+			delete(metadata.Sidecars, group.ArchiveName)
+		}
+		if object, exists := objects[group.ArchiveName]; exists {
+			setArchiveObject(metadata, group.ArchiveName, object)
+		}
+		if err := bm.processArchiveGroup(ctx, group, metadata, result, true); err != nil {
+			logger.Error(fmt.Sprintf("处理压缩包组失败: %s, %s", group.ArchiveName, err))
+			result.ErrorArchives = append(result.ErrorArchives, group.ArchiveName)
+			result.Details[group.ArchiveName] = err.Error()
+		} else {
+			logger.Info(fmt.Sprintf("成功处理压缩包组: %s (%s)", group.ArchiveName, result.Details[group.ArchiveName]))
+		}
+		bm.status.groupDone()
+	}
+
+	// 4. 上传按当前数据重建的元数据
+	bm.status.setPhase(PhaseMetadata)
+	if err := bm.saveAndUploadMetadata(ctx, metadata); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	result.TotalArchives = len(groups)
+	result.Duration = time.Since(startTime)
+	return result, backupOutcome(result)
+}
@@ -0,0 +1,51 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrLocked 锁已被其他进程持有
+var ErrLocked = errors.New("another backuper process is already running")
+
+// Lock 本地文件锁，进程退出时由操作系统自动释放
+type Lock struct {
+	file *os.File
+}
+
+// Acquire 以非阻塞方式获取文件锁，锁已被持有时返回ErrLocked。
+// 获取成功后在锁文件中写入当前进程PID，便于排查。
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := tryLock(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// 锁文件不删除，删除后其他进程可能对新文件加锁而绕过当前锁
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Release 释放文件锁
+func (l *Lock) Release() error {
+	if err := unlock(l.file); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return l.file.Close()
+}
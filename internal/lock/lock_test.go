@@ -0,0 +1,32 @@
+package lock
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backuper.lock")
+
+	first, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// 锁被持有时再次获取应立即失败
+	if _, err := Acquire(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v", err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// 释放后可以重新获取
+	second, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire after release failed: %v", err)
+	}
+	second.Release()
+}
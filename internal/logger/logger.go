@@ -1,22 +1,29 @@
 package logger
 
 import (
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// Logger 控制台日志实例，FileLogger 文件日志实例（未指定日志路径时为nil）。
+// 并发备份时多个goroutine同时记录日志，应通过GetLogger/GetFileLogger访问，不要直接读写这两个变量
 var Logger *logrus.Logger
 var FileLogger *logrus.Logger
 
-// InitLogger 初始化日志系统
-func InitLogger(verbose bool, logPath string) error {
-	Logger = logrus.New()
+// mu 保护Logger和FileLogger的初始化与替换
+var mu sync.RWMutex
+
+// newConsoleLogger 创建控制台日志实例
+func newConsoleLogger(verbose bool) *logrus.Logger {
+	l := logrus.New()
 
 	// 设置日志格式
-	Logger.SetFormatter(&logrus.TextFormatter{
+	l.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp:   true,
 		TimestampFormat: "2006-01-02 15:04:05",
 		DisableColors:   false,
@@ -24,12 +31,44 @@ func InitLogger(verbose bool, logPath string) error {
 
 	// 设置日志级别
 	if verbose {
-		Logger.SetLevel(logrus.DebugLevel)
+		l.SetLevel(logrus.DebugLevel)
 	} else {
-		Logger.SetLevel(logrus.InfoLevel)
+		l.SetLevel(logrus.InfoLevel)
 	}
+	l.SetOutput(os.Stdout)
+	return l
+}
+
+// fieldsHook 为每条日志附加固定字段，如主机名和运行ID
+type fieldsHook struct {
+	fields logrus.Fields
+}
+
+func (h *fieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *fieldsHook) Fire(entry *logrus.Entry) error {
+	for key, value := range h.fields {
+		if _, exists := entry.Data[key]; !exists {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+// InitLogger 初始化日志系统
+func InitLogger(verbose bool, logPath string) error {
+	return InitLoggerWithFields(verbose, logPath, nil)
+}
+
+// InitLoggerWithFields 初始化日志系统，控制台和文件日志的每一行都附加fields，
+// 用于在多台主机或多个定时任务的日志中区分各次运行
+func InitLoggerWithFields(verbose bool, logPath string, fields logrus.Fields) error {
+	console := newConsoleLogger(verbose)
 
 	// 如果指定了日志路径，同时输出到文件和控制台
+	var file *logrus.Logger
 	if logPath != "" {
 		// 确保日志目录存在
 		logDir := filepath.Dir(logPath)
@@ -43,35 +82,56 @@ func InitLogger(verbose bool, logPath string) error {
 			return err
 		}
 
-		// 控制台日志实例
-		Logger.SetOutput(os.Stdout)
-
 		// 文件日志实例
-		FileLogger = logrus.New()
-		FileLogger.SetFormatter(&logrus.TextFormatter{
+		file = logrus.New()
+		file.SetFormatter(&logrus.TextFormatter{
 			FullTimestamp:   true,
 			TimestampFormat: "2006-01-02 15:04:05",
 			DisableColors:   true, // 文件日志禁用颜色
 		})
 		if verbose {
-			FileLogger.SetLevel(logrus.DebugLevel)
+			file.SetLevel(logrus.DebugLevel)
 		} else {
-			FileLogger.SetLevel(logrus.InfoLevel)
+			file.SetLevel(logrus.InfoLevel)
+		}
+		file.SetOutput(logFile)
+	}
+
+	if len(fields) > 0 {
+		hook := &fieldsHook{fields: fields}
+		console.AddHook(hook)
+		if file != nil {
+			file.AddHook(hook)
 		}
-		FileLogger.SetOutput(logFile)
-	} else {
-		// 只输出到控制台
-		Logger.SetOutput(os.Stdout)
-		FileLogger = nil
 	}
 
+	// 两个实例都创建完成后再一起替换，其他goroutine不会看到只初始化了一半的状态
+	mu.Lock()
+	Logger = console
+	FileLogger = file
+	mu.Unlock()
+
 	return nil
 }
 
+// SetConsoleOutput 修改控制台日志的输出目标，例如输出结果到标准输出时把日志改到标准错误
+func SetConsoleOutput(w io.Writer) {
+	GetLogger().SetOutput(w)
+}
+
 // GetLogger 获取日志实例
 func GetLogger() *logrus.Logger {
+	mu.RLock()
+	l := Logger
+	mu.RUnlock()
+	if l != nil {
+		return l
+	}
+
+	// 如果未初始化，使用默认配置；加写锁后再检查一次，避免并发调用时重复创建
+	mu.Lock()
+	defer mu.Unlock()
 	if Logger == nil {
-		// 如果未初始化，使用默认配置
 		Logger = logrus.New()
 		Logger.SetLevel(logrus.InfoLevel)
 		Logger.SetFormatter(&logrus.TextFormatter{
@@ -84,6 +144,8 @@ func GetLogger() *logrus.Logger {
 
 // GetFileLogger 获取文件日志实例
 func GetFileLogger() *logrus.Logger {
+	mu.RLock()
+	defer mu.RUnlock()
 	return FileLogger
 }
 
@@ -104,8 +166,8 @@ func Info(args ...interface{}) {
 
 // Infof 记录格式化信息级别日志（文件）
 func Infof(format string, args ...interface{}) {
-	if FileLogger != nil {
-		FileLogger.Infof(format, args...)
+	if fileLogger := GetFileLogger(); fileLogger != nil {
+		fileLogger.Infof(format, args...)
 	}
 }
 
@@ -116,24 +178,24 @@ func Debug(args ...interface{}) {
 
 // Debugf 记录格式化调试级别日志（文件）
 func Debugf(format string, args ...interface{}) {
-	if FileLogger != nil {
-		FileLogger.Debugf(format, args...)
+	if fileLogger := GetFileLogger(); fileLogger != nil {
+		fileLogger.Debugf(format, args...)
 	}
 }
 
 // Warn 记录警告级别日志（控制台和文件）
 func Warn(args ...interface{}) {
 	GetLogger().Warn(args...)
-	if FileLogger != nil {
-		FileLogger.Warn(args...)
+	if fileLogger := GetFileLogger(); fileLogger != nil {
+		fileLogger.Warn(args...)
 	}
 }
 
 // Error 记录错误级别日志（控制台和文件）
 func Error(args ...interface{}) {
 	GetLogger().Error(args...)
-	if FileLogger != nil {
-		FileLogger.Error(args...)
+	if fileLogger := GetFileLogger(); fileLogger != nil {
+		fileLogger.Error(args...)
 	}
 }
 
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentLogging 多个goroutine同时初始化和记录日志，使用go test -race检查数据竞争
+func TestConcurrentLogging(t *testing.T) {
+	mu.Lock()
+	Logger, FileLogger = nil, nil
+	mu.Unlock()
+
+	logPath := filepath.Join(t.TempDir(), "backup.log")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%4 == 0 {
+				if err := InitLogger(false, logPath); err != nil {
+					t.Errorf("InitLogger失败: %v", err)
+				}
+				SetConsoleOutput(io.Discard)
+			}
+			for j := 0; j < 20; j++ {
+				Debug("debug")
+				Infof("worker %d message %d", i, j)
+				Debugf("worker %d debug %d", i, j)
+				WithField("worker", i).Debug("field")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if GetLogger() == nil || GetFileLogger() == nil {
+		t.Fatal("初始化后日志实例不应为nil")
+	}
+
+	Infof("done")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("读取日志文件失败: %v", err)
+	}
+	if !strings.Contains(string(data), "done") {
+		t.Errorf("文件日志缺少最后一条记录")
+	}
+}
+
+// TestInitLoggerWithFields 测试固定字段写入每一行控制台和文件日志
+func TestInitLoggerWithFields(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "backup.log")
+	if err := InitLoggerWithFields(false, logPath, map[string]interface{}{"host": "pve1", "run_id": "abc"}); err != nil {
+		t.Fatalf("InitLoggerWithFields失败: %v", err)
+	}
+	var console strings.Builder
+	SetConsoleOutput(&console)
+
+	Warn("first")
+	WithField("archive", "0000-00ff.tar.gz").Info("second")
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("读取日志文件失败: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(console.String()), "\n")
+	lines = append(lines, strings.TrimSpace(string(data)))
+	if len(lines) != 3 {
+		t.Fatalf("预期2行控制台日志和1行文件日志，实际: %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "host=pve1") || !strings.Contains(line, "run_id=abc") {
+			t.Errorf("日志缺少固定字段: %s", line)
+		}
+	}
+}
@@ -10,29 +10,160 @@ type FileTreeNode struct {
 	Size     int64                    `json:"size"`
 	ModTime  time.Time                `json:"mod_time"`
 	IsDir    bool                     `json:"is_dir"`
+	Hash     string                   `json:"hash,omitempty"`  // 文件内容哈希（算法见BackupMetadata.TreeHash），扫描时计算了哈希才记录
+	Inode    uint64                   `json:"inode,omitempty"` // 文件的inode号，启用--track-inodes时记录，用于识别移动的文件
 	Children map[string]*FileTreeNode `json:"children,omitempty"`
 }
 
 // BackupMetadata 备份元数据，记录整体备份信息
 type BackupMetadata struct {
-	Version      int                      `json:"version"`       // 元数据版本
-	PrefixDigits int                      `json:"prefix_digits"` // 前缀位数
-	BackupTime   time.Time                `json:"backup_time"`   // 备份时间
-	FileTree     map[string]*FileTreeNode `json:"file_tree"`     // 文件树，key为顶层目录名
-	Checksums    map[string]string        `json:"checksums"`     // 压缩包SHA256值，key为压缩包名
+	Version        int                      `json:"version"`                // 元数据版本
+	PrefixDigits   int                      `json:"prefix_digits"`          // 前缀位数
+	DirBatchSize   int                      `json:"dir_batch,omitempty"`    // 每个压缩包最多包含的目录数，0表示只按前缀分组
+	MergedRanges   []string                 `json:"merged,omitempty"`       // 相邻小分组合并后的范围（如"0000-02ff"），范围内的分组合并为一个压缩包
+	DatastoreID    string                   `json:"datastore_id,omitempty"` // datastore标识，防止增量备份混用不同的chunk目录
+	Namespace      string                   `json:"namespace,omitempty"`    // PBS命名空间标签，chunk在datastore内共享，只用于区分备份
+	BackupTime     time.Time                `json:"backup_time"`            // 备份时间
+	RunID          string                   `json:"run_id,omitempty"`       // 生成该元数据的运行标识，与日志中的run_id对应
+	Host           string                   `json:"host,omitempty"`         // 生成该元数据的主机名
+	Annotations    map[string]string        `json:"annotations,omitempty"`  // 运行时通过--meta附加的自定义字段（如工单号、环境、操作人），不影响备份逻辑
+	FileTree       map[string]*FileTreeNode `json:"file_tree,omitempty"`    // 文件树，key为顶层目录名
+	FileTreeFile   string                   `json:"tree_file,omitempty"`    // 文件树拆分保存时的远程对象路径（相对RemotePath），此时FileTree为空
+	FileTreeSHA256 string                   `json:"tree_sha256,omitempty"`  // 拆分保存的文件树对象的SHA256，加载时校验
+	TreeHash       string                   `json:"tree_hash,omitempty"`    // 文件树中文件内容哈希的算法，为空表示SHA256，xxh3只用于变化检测
+	Checksums      map[string]string        `json:"checksums"`              // 压缩包SHA256值，key为压缩包名
+	ChecksumFiles  string                   `json:"sum_files,omitempty"`    // 远程校验和文件的保存方式：bundle只有checksums.txt，both两种都有，为空表示只有sha256目录下单独的校验和文件
+	SourceSums     map[string]string        `json:"source_sums,omitempty"`  // 压缩包源内容（文件路径、大小和SHA256）的校验和，key为压缩包名；扫描时计算了文件哈希才记录
+	Hashes         map[string]ObjectHashes  `json:"hashes,omitempty"`       // 压缩包对象的CRC32C和MD5（哈希类型到十六进制值），key为压缩包名；用于与服务端记录的哈希比较
+	Objects        map[string]string        `json:"objects,omitempty"`      // 压缩包在远程的对象名（带时间戳后缀或实际格式的扩展名），key为压缩包名；没有记录时对象名与压缩包名相同
+	Dedupe         map[string][]DedupeEntry `json:"dedupe,omitempty"`       // 去重后从压缩包中省略的文件，key为压缩包名
+	Compression    map[string]string        `json:"compression,omitempty"`  // 每个压缩包的压缩方式（gzip/store/none/zstd），key为压缩包名
+	ArchiveFormat  string                   `json:"format,omitempty"`       // 压缩包格式，none表示不压缩的.tar，auto表示按压缩包选择，为空表示.tar.gz；增量备份沿用
+	Sidecars       map[string][]Sidecar     `json:"sidecars,omitempty"`     // 后处理器生成的附加文件（如签名），key为压缩包名
+	PendingDirs    []string                 `json:"pending,omitempty"`      // 分阶段全量备份中因修改时间较早尚未备份的目录，之后的增量备份作为新增目录处理
+	Moves          []MovedFile              `json:"moves,omitempty"`        // 增量备份识别出的移动过的文件，内容仍在原路径所在的压缩包中，恢复时移动到当前路径
+}
+
+// MovedFile 按inode识别出的移动过的文件，路径相对chunk目录并使用正斜杠
+type MovedFile struct {
+	From string `json:"from"` // 压缩包中的路径
+	To   string `json:"to"`   // 当前路径
+}
+
+// ObjectHashes 远程对象的哈希，key为哈希类型（crc32c、md5），值为十六进制小写
+type ObjectHashes map[string]string
+
+// Sidecar 压缩包的附加文件，与校验和文件一起存放在sha256目录中
+type Sidecar struct {
+	Processor string `json:"processor"` // 生成该文件的后处理器名称，如gpg
+	Name      string `json:"name"`      // 文件名，如0000-00ff.tar.gz.sig
+}
+
+// DedupeEntry 去重清单条目，文件内容以SHA256为名存放在远程blob目录中
+type DedupeEntry struct {
+	Path    string    `json:"path"`     // 文件相对于chunk目录的路径
+	Hash    string    `json:"hash"`     // 文件内容SHA256，即blob名称
+	Size    int64     `json:"size"`     // 文件大小
+	ModTime time.Time `json:"mod_time"` // 文件修改时间，恢复时还原
 }
 
 // Config 备份配置
 type Config struct {
-	ChunkPath    string   `json:"chunk_path"`    // .chunk目录路径
-	RemotePath   string   `json:"remote_path"`   // 远程存储路径
-	TempPath     string   `json:"temp_path"`     // 临时文件路径
-	RcloneBinary string   `json:"rclone_binary"` // rclone二进制路径
-	RcloneConfig string   `json:"rclone_config"` // rclone配置文件路径
-	RcloneArgs   []string `json:"rclone_args"`   // rclone额外参数
-	PrefixDigits int      `json:"prefix_digits"` // 前缀位数（全量备份使用）
-	Mode         string   `json:"mode"`          // 备份模式：full/incremental
-	Verbose      bool     `json:"verbose"`       // 详细日志
+	ChunkPath       string    `json:"chunk_path"`        // .chunk目录路径
+	RemotePath      string    `json:"remote_path"`       // 远程存储路径
+	MetadataRemote  string    `json:"metadata_remote"`   // 备份元数据的远程目录，为空时与压缩包位于RemotePath
+	TempPath        string    `json:"temp_path"`         // 临时文件路径
+	TempPaths       []string  `json:"temp_paths"`        // 压缩包临时目录，多个目录时轮流使用；为空时使用TempPath
+	Backend         string    `json:"backend"`           // 存储后端名称
+	RcloneBinary    string    `json:"rclone_binary"`     // rclone二进制路径
+	RcloneConfig    string    `json:"rclone_config"`     // rclone配置文件路径
+	RcloneArgs      []string  `json:"rclone_args"`       // rclone额外参数
+	CatMaxSize      int64     `json:"cat_max_size"`      // 读取远程小文件（校验和文件）的大小上限，0表示不限制
+	Transfers       int       `json:"transfers"`         // rclone传输类命令的并发传输数，0表示使用rclone默认值
+	Checkers        int       `json:"checkers"`          // rclone传输类命令的并发检查数，0表示使用rclone默认值
+	SignKey         string    `json:"sign_key"`          // 用GPG为每个压缩包生成分离签名时使用的密钥，为空时不签名
+	GPGBinary       string    `json:"gpg_binary"`        // gpg二进制路径
+	PrefixDigits    int       `json:"prefix_digits"`     // 前缀位数（全量备份使用）
+	DirBatchSize    int       `json:"dir_batch_size"`    // 每个压缩包最多包含的目录数（全量备份使用），0表示不限
+	MinArchiveSize  int64     `json:"min_archive_size"`  // 合并相邻小分组直到达到该大小（全量备份使用，字节），0表示不合并
+	HexDigits       int       `json:"hex_digits"`        // chunk目录名的十六进制位数，0表示默认的4位
+	LooseHex        bool      `json:"loose_hex"`         // 目录名只要求以HexDigits位十六进制开头，允许带后缀
+	Mode            string    `json:"mode"`              // 备份模式：full/incremental/auto
+	FullThreshold   float64   `json:"full_threshold"`    // 自动模式下触发全量备份的变化目录比例
+	AutoFull        bool      `json:"auto_full"`         // 增量备份时远程没有元数据则自动执行全量备份
+	DatastoreID     string    `json:"datastore_id"`      // 用户指定的datastore标识，为空时使用chunk路径指纹
+	Namespace       string    `json:"namespace"`         // PBS命名空间标签，记录在元数据中，增量备份和恢复时检查
+	NamespaceDir    bool      `json:"namespace_dir"`     // RemotePath（和MetadataRemote）加上命名空间对应的ns/<名称>子目录
+	Force           bool      `json:"force"`             // datastore标识不匹配时仍然执行增量备份
+	AllowEmpty      bool      `json:"allow_empty"`       // chunk路径下没有chunk目录时只警告并继续备份
+	Repair          bool      `json:"repair"`            // 增量备份重新生成上次缺少校验和的压缩包，并且不跳过无变化的备份
+	VerifyRemote    bool      `json:"verify_remote"`     // 增量备份不比较文件树，打包全部分组后与远程.sha256文件比较决定是否上传
+	ProviderHash    bool      `json:"provider_hash"`     // 校验时比较服务端记录的CRC32C或MD5，不下载压缩包；不支持时退回下载校验SHA256
+	RunID           string    `json:"run_id"`            // 本次运行标识，为空时由备份管理器生成
+	LogRunContext   bool      `json:"log_run_context"`   // 每行日志附加主机名和运行标识
+	ReportFile      string    `json:"report_file"`       // 备份结束后写入JSON运行报告的路径，.jsonl结尾时追加
+	StatusFile      bool      `json:"status_file"`       // 运行期间在TempPath中定期更新status.json
+	StatusRemote    bool      `json:"status_remote"`     // 同时把status.json上传到RemotePath
+	Verbose         bool      `json:"verbose"`           // 详细日志
+	VerboseRclone   bool      `json:"verbose_rclone"`    // rclone详细输出
+	TarIndex        bool      `json:"tar_index"`         // 生成tar索引以支持单文件恢复
+	SmartCompress   bool      `json:"smart_compress"`    // 采样判断压缩率，压缩效果差的压缩包不压缩
+	ParallelGzip    bool      `json:"parallel_gzip"`     // 使用多核并行gzip压缩，输出仍是标准gzip流
+	PreallocateTemp bool      `json:"preallocate_temp"`  // 写入压缩包前按估算大小预留临时目录的磁盘空间
+	FsyncMetadata   bool      `json:"fsync_metadata"`    // 本地元数据副本和临时压缩包写完后调用fsync落盘
+	SmartFull       bool      `json:"smart_full"`        // 全量备份时源内容校验和与上次相同的压缩包不重新打包和上传
+	UsePrevMetadata bool      `json:"use_prev_metadata"` // 主元数据损坏时回退到上次覆盖前保存的backup-metadata.json.prev
+	RunSubdir       bool      `json:"run_subdir"`        // 每次运行写入RemotePath下以开始时间命名的子目录，并更新顶层的latest指针
+	Compression     string    `json:"compression"`       // 压缩包格式：gzip（默认）或none（不压缩的.tar，仅全量备份生效）
+	ChecksumStyle   string    `json:"checksum_style"`    // 校验和文件格式：text（默认）、binary或tag，对应sha256sum默认、-b和--tag的输出
+	ChecksumFiles   string    `json:"checksum_files"`    // 校验和文件的保存方式：sidecar（默认，每个压缩包一个）、bundle（所有压缩包写入一个checksums.txt）或both
+	Paranoid        bool      `json:"paranoid"`          // 上传前读回压缩包，确认内容与源目录完全一致
+	KeepHistory     bool      `json:"keep_history"`      // 每次备份在history目录保存一份元数据快照
+	ArchiveSuffix   bool      `json:"archive_suffix"`    // 压缩包以带时间戳后缀的新对象名上传，不覆盖远程已有的压缩包
+	RestoreManifest bool      `json:"restore_manifest"`  // 每次备份上传不依赖本工具的恢复清单
+	SplitFileTree   bool      `json:"split_file_tree"`   // 文件树与校验和分开保存，只需要校验和的操作不下载文件树
+	KeepEmptyDirs   bool      `json:"keep_empty_dirs"`   // 恢复后按文件树重新创建压缩包中缺少的目录（包括空目录）并还原目录修改时间
+	SnapshotHook    string    `json:"snapshot_hook"`     // 备份前创建快照的命令，输出的挂载路径代替ChunkPath
+	SnapshotCleanup string    `json:"snapshot_cleanup"`  // 备份结束后清理快照的命令
+	ReadBufferBytes int64     `json:"read_buffer"`       // 创建压缩包时预读文件内容的内存上限，0表示顺序读取
+	HashBufferSize  int       `json:"hash_buffer_size"`  // 计算SHA256时的读取缓冲区大小（字节），0表示默认的1MB
+	MinThroughput   int64     `json:"min_throughput"`    // 最低上传吞吐量（字节/秒），用于按大小计算上传截止时间
+	MaxDirSize      int64     `json:"max_dir_size"`      // 超过该大小的chunk目录被排除，0表示不限制
+	MaxObjectSize   int64     `json:"max_object_size"`   // 单个远程对象的大小上限，0表示使用后端报告的上限，负数表示不检查
+	IncludePrefixes []string  `json:"include_prefixes"`  // 只备份以这些前缀开头的chunk目录
+	ExcludePatterns []string  `json:"exclude_patterns"`  // 文件名匹配这些模式的文件不纳入文件树和压缩包
+	RemoteExcludes  bool      `json:"remote_excludes"`   // 启动时读取远程的ignore-patterns.txt，与本地排除模式合并
+	SkipScanErrors  bool      `json:"skip_scan_errors"`  // 扫描chunk目录遇到暂时性错误时重试，多次失败后跳过该目录
+	MaxScanDepth    int       `json:"max_scan_depth"`    // chunk目录下允许的最大嵌套深度，0表示默认值
+	NewerThan       time.Time `json:"newer_than"`        // 只备份树内修改时间晚于该时间的chunk目录，零值表示不限制
+	StagedCutoff    time.Time `json:"staged_cutoff"`     // 分阶段全量备份：只打包树内修改时间晚于该时间的目录，其余目录记录为待备份
+	CompareMode     string    `json:"compare_mode"`      // 增量备份的变化检测模式：mtime-size/size-only/hash
+	TrackInodes     bool      `json:"track_inodes"`      // 文件树记录inode号，增量备份把只是移动过的文件视为未变化
+	GrowthReport    int       `json:"growth_report"`     // 增量备份后报告大小变化最大的前N个目录，0表示不报告
+	DecisionWorkers int       `json:"decision_workers"`  // 增量备份判断压缩包是否需要上传时同时读取的远程校验和文件数，0表示默认值
+	Dedupe          bool      `json:"dedupe"`            // 内容相同的文件只在远程blob目录中保存一份
+	TreeHash        string    `json:"tree_hash"`         // 扫描时文件内容哈希的算法：sha256（默认）或xxh3
+	PBSVerify       bool      `json:"pbs_verify"`        // 打包前抽样校验源chunk的内容与文件名摘要
+	PBSVerifySample float64   `json:"pbs_verify_sample"` // 抽样比例（0-1]，0表示默认比例
+	PBSVerifyAbort  bool      `json:"pbs_verify_abort"`  // 发现损坏的源chunk时中止备份
+
+	Annotations map[string]string `json:"annotations,omitempty"` // 记录到备份元数据中的自定义字段
+
+	MaxRuntime time.Duration `json:"max_runtime"` // 备份的最长运行时间，用完后不再开始新的压缩包组，0表示不限制
+
+	ThrottleDirs  int           `json:"throttle_dirs"`  // 每打包该数量的目录后暂停，0表示不按目录数暂停
+	ThrottleBytes int64         `json:"throttle_bytes"` // 每读取该字节数后暂停，0表示不按数据量暂停
+	ThrottleSleep time.Duration `json:"throttle_sleep"` // 每次暂停的时长
+
+	ReadRateLimit int64 `json:"read_rate_limit"` // 从源目录读取文件内容的速率上限（字节/秒），0表示不限制
+
+	SFTPHost                  string `json:"sftp_host"`                     // SFTP服务器地址
+	SFTPPort                  int    `json:"sftp_port"`                     // SFTP端口
+	SFTPUser                  string `json:"sftp_user"`                     // SFTP用户名
+	SFTPKeyFile               string `json:"sftp_key_file"`                 // SSH私钥文件
+	SFTPPassword              string `json:"-"`                             // SFTP密码，不序列化
+	SFTPKnownHosts            string `json:"sftp_known_hosts"`              // known_hosts文件
+	SFTPInsecureIgnoreHostKey bool   `json:"sftp_insecure_ignore_host_key"` // 跳过主机密钥校验
 }
 
 // ArchiveGroup 压缩包分组信息
@@ -43,15 +174,270 @@ type ArchiveGroup struct {
 	ArchiveName string   `json:"archive_name"` // 压缩包名称，如"0000-00ff.tar.gz"
 	Directories []string `json:"directories"`  // 包含的目录列表
 	NeedsUpdate bool     `json:"needs_update"` // 是否需要更新
+
+	Deduped     []DedupeEntry `json:"-"`                     // 创建压缩包时省略的文件，内容存放在blob目录中
+	Compression string        `json:"compression,omitempty"` // 启用智能压缩时选择的压缩方式（gzip/store）
 }
 
 // BackupResult 备份结果
 type BackupResult struct {
-	TotalArchives   int               `json:"total_archives"`
-	UpdatedArchives int               `json:"updated_archives"`
-	SkippedArchives int               `json:"skipped_archives"`
-	ErrorArchives   []string          `json:"error_archives"`
-	UploadedFiles   []string          `json:"uploaded_files"`
-	Duration        time.Duration     `json:"duration"`
-	Details         map[string]string `json:"details"` // 详细结果信息
+	RunID              string            `json:"run_id"` // 本次运行标识，与日志和元数据中的run_id对应
+	TotalArchives      int               `json:"total_archives"`
+	UpdatedArchives    int               `json:"updated_archives"`
+	SkippedArchives    int               `json:"skipped_archives"`
+	ErrorArchives      []string          `json:"error_archives"`
+	UploadedFiles      []string          `json:"uploaded_files"`
+	UploadedBytes      int64             `json:"uploaded_bytes"`                // 上传的压缩包字节数
+	ExcludedDirs       []string          `json:"excluded_dirs"`                 // 因超过大小限制被排除的目录
+	UnreadableDirs     []string          `json:"unreadable_dirs,omitempty"`     // 多次扫描失败而跳过的目录（增量备份沿用上次的记录）
+	CorruptChunks      []string          `json:"corrupt_chunks,omitempty"`      // 抽样校验发现损坏的源chunk（相对chunk目录）
+	RewrittenChecksums []string          `json:"rewritten_checksums,omitempty"` // 修复模式下改写为标准格式的校验和文件（压缩包名）
+	GrowthReport       []DirSizeChange   `json:"growth_report"`                 // 大小变化最大的目录（增量备份）
+	DeferredArchives   []string          `json:"deferred_archives,omitempty"`   // 达到最长运行时间后推迟到下次运行的压缩包
+	PendingDirs        int               `json:"pending_dirs,omitempty"`        // 分阶段备份中仍未备份的目录数
+	MovedFiles         int               `json:"moved_files,omitempty"`         // 元数据中记录的移动过、未重新打包的文件数
+	Mode               string            `json:"mode"`                          // 自动模式实际执行的备份类型：full/incremental
+	Drift              float64           `json:"drift"`                         // 自动模式测得的变化目录比例
+	Duration           time.Duration     `json:"duration"`
+	Details            map[string]string `json:"details"` // 详细结果信息
+}
+
+// BackupReport 写入--report-file的运行报告，供监控读取
+type BackupReport struct {
+	RunID     string        `json:"run_id"`           // 本次运行标识
+	Host      string        `json:"host"`             // 运行备份的主机名
+	Mode      string        `json:"mode"`             // 请求的备份模式
+	Success   bool          `json:"success"`          // 备份完成且没有失败的压缩包
+	Error     string        `json:"error,omitempty"`  // 失败或部分失败时的错误信息
+	StartTime time.Time     `json:"start_time"`       // 开始时间
+	EndTime   time.Time     `json:"end_time"`         // 结束时间
+	Config    *Config       `json:"config"`           // 本次运行的配置（不含密码）
+	Result    *BackupResult `json:"result,omitempty"` // 备份结果，备份未完成时为空
+}
+
+// RestoreManifest 恢复清单，记录手动恢复所需的压缩包、校验和与命令
+type RestoreManifest struct {
+	Version      int                      `json:"version"`         // 生成清单的元数据版本
+	BackupTime   time.Time                `json:"backup_time"`     // 备份时间
+	RemotePath   string                   `json:"remote_path"`     // 备份所在的远程路径
+	ChunkPath    string                   `json:"chunk_path"`      // 备份时的chunk目录，命令中作为恢复目标
+	PrefixDigits int                      `json:"prefix_digits"`   // 分组前缀位数
+	Steps        []string                 `json:"steps"`           // 恢复步骤说明
+	Archives     []RestoreManifestArchive `json:"archives"`        // 按恢复顺序排列的压缩包
+	Blobs        []RestoreManifestBlob    `json:"blobs,omitempty"` // 压缩包中省略的去重文件
+	Moves        []RestoreManifestMove    `json:"moves,omitempty"` // 压缩包和去重文件恢复后需要移动到当前路径的文件
+}
+
+// RestoreManifestArchive 恢复清单中的压缩包
+type RestoreManifestArchive struct {
+	Name        string   `json:"name"`
+	RemotePath  string   `json:"remote_path"`
+	SHA256      string   `json:"sha256"`
+	Compression string   `json:"compression,omitempty"`
+	Commands    []string `json:"commands"` // 下载、校验和解压命令
+}
+
+// RestoreManifestBlob 恢复清单中的去重文件
+type RestoreManifestBlob struct {
+	Path       string   `json:"path"` // 相对于chunk目录的路径
+	RemotePath string   `json:"remote_path"`
+	SHA256     string   `json:"sha256"`
+	Commands   []string `json:"commands"` // 下载和校验命令
+}
+
+// RestoreManifestMove 恢复清单中移动过的文件
+type RestoreManifestMove struct {
+	From    string `json:"from"`    // 压缩包中的路径（相对于chunk目录）
+	To      string `json:"to"`      // 当前路径（相对于chunk目录）
+	Command string `json:"command"` // 移动命令
+}
+
+// PruneResult 按保留策略清理历史快照的结果
+type PruneResult struct {
+	RetainedSnapshots  []string      `json:"retained_snapshots"`            // 保留的历史快照
+	PrunedSnapshots    []string      `json:"pruned_snapshots"`              // 删除的历史快照
+	ProtectedSnapshots []string      `json:"protected_snapshots,omitempty"` // 按策略应删除但处于保护期内、本次未清理的快照
+	DeletedArchives    []string      `json:"deleted_archives"`              // 只被删除的快照引用的压缩包
+	DeletedBlobs       []string      `json:"deleted_blobs"`                 // 只被删除的快照引用的blob
+	DryRun             bool          `json:"dry_run"`                       // 只计算不删除
+	Duration           time.Duration `json:"duration"`
+}
+
+// GCResult 全面核对远程存储（gc）的结果，对象路径相对RemotePath
+type GCResult struct {
+	Prune              *PruneResult  `json:"prune,omitempty"`               // 同时按保留策略清理历史快照时的结果
+	Snapshots          int           `json:"snapshots"`                     // 计入引用的历史快照数
+	ScannedObjects     int           `json:"scanned_objects"`               // 列出的远程对象数
+	DeletedOrphans     []string      `json:"deleted_orphans"`               // 删除的孤立对象
+	ProtectedOrphans   []string      `json:"protected_orphans,omitempty"`   // 处于保护期内、本次未删除的孤立对象
+	MissingArchives    []string      `json:"missing_archives"`              // 当前元数据引用但远程不存在的压缩包
+	MissingHistory     []string      `json:"missing_history,omitempty"`     // 只被历史快照引用且远程不存在的压缩包对象，无法修复
+	RepairedArchives   []string      `json:"repaired_archives,omitempty"`   // 用chunk目录重新生成并上传的压缩包
+	RewrittenChecksums []string      `json:"rewritten_checksums,omitempty"` // 按元数据改写或补传的校验和文件（压缩包名）
+	DryRun             bool          `json:"dry_run"`                       // 只报告不修改远程
+	Duration           time.Duration `json:"duration"`
+}
+
+// TarIndexEntry tar索引条目，记录条目所在gzip成员在压缩包中的位置
+type TarIndexEntry struct {
+	Name   string `json:"name"`   // 条目在tar包中的路径
+	IsDir  bool   `json:"is_dir"` // 是否为目录
+	Size   int64  `json:"size"`   // 未压缩大小
+	Offset int64  `json:"offset"` // gzip成员在压缩包中的起始偏移
+	Length int64  `json:"length"` // gzip成员的压缩长度
+}
+
+// TarIndex 压缩包的tar索引，作为压缩包的附属文件保存
+type TarIndex struct {
+	Archive string          `json:"archive"` // 压缩包名称
+	Entries []TarIndexEntry `json:"entries"` // 条目列表，按写入顺序排列
+}
+
+// RestoreResult 恢复结果
+type RestoreResult struct {
+	RestoredArchives int               `json:"restored_archives"`
+	RestoredEntries  int               `json:"restored_entries"`
+	CreatedDirs      int               `json:"created_dirs,omitempty"` // 启用KeepEmptyDirs时按文件树新创建的目录数
+	MovedFiles       int               `json:"moved_files,omitempty"`  // 从压缩包中的原路径移动到当前路径的文件数
+	Annotations      map[string]string `json:"annotations,omitempty"`  // 恢复的备份记录的自定义字段
+	Duration         time.Duration     `json:"duration"`
+}
+
+// ChangeList 增量备份将要处理的内容，由incremental --list-changed输出
+type ChangeList struct {
+	ChangedDirs []string      `json:"changed_dirs"`           // 新增、修改或删除的目录，按名称排序
+	Archives    []string      `json:"archives"`               // 需要更新的压缩包，按名称排序
+	RemoteDrift []RemoteDrift `json:"remote_drift,omitempty"` // 元数据认为完好、远程却缺失或不一致的压缩包，按名称排序
+}
+
+// RemoteDrift 远程压缩包与元数据不一致的情况
+type RemoteDrift struct {
+	Archive string `json:"archive"`
+	Problem string `json:"problem"`          // archive_missing/checksum_missing/checksum_invalid/checksum_mismatch
+	Detail  string `json:"detail,omitempty"` // 校验和文件记录的值或解析错误
+}
+
+// VerifyResult 校验结果
+type VerifyResult struct {
+	VerifiedArchives int              `json:"verified_archives"`
+	HashVerified     int              `json:"hash_verified"` // 通过服务端哈希校验、没有下载的压缩包数
+	Mismatches       []VerifyMismatch `json:"mismatches"`    // 校验失败的压缩包，按名称排序
+	Duration         time.Duration    `json:"duration"`
+}
+
+// BackupDescription 流式读取备份元数据得到的摘要，不包含文件树本身
+type BackupDescription struct {
+	BackupTime  time.Time     `json:"backup_time"`
+	DatastoreID string        `json:"datastore_id,omitempty"`
+	Namespace   string        `json:"namespace,omitempty"`
+	Archives    int           `json:"archives"`       // 压缩包数
+	Directories int           `json:"directories"`    // 文件树中的chunk目录数
+	Files       int           `json:"files"`          // 文件树中的文件数
+	TotalSize   int64         `json:"total_size"`     // 文件树中所有文件的总大小（字节）
+	Path        string        `json:"path,omitempty"` // 查找的路径（相对chunk目录）
+	PathFound   bool          `json:"path_found"`     // Path是否在文件树中
+	Duration    time.Duration `json:"duration"`
+}
+
+// VerifyMismatch 单个压缩包的校验失败信息
+type VerifyMismatch struct {
+	Archive  string `json:"archive"`
+	Expected string `json:"expected"`           // 元数据中记录的SHA256（比较服务端哈希时带哈希类型前缀，如crc32c:）
+	Actual   string `json:"actual,omitempty"`   // 实际计算的SHA256或服务端记录的哈希
+	Error    string `json:"error,omitempty"`    // 下载或计算失败时的错误
+	Repaired bool   `json:"repaired,omitempty"` // 已用当前chunk目录重新生成并上传
+}
+
+// CloneResult 复制备份到另一个远程路径的结果
+type CloneResult struct {
+	Source           string           `json:"source"`            // 源远程路径
+	Destination      string           `json:"destination"`       // 目标远程路径
+	CopiedObjects    []string         `json:"copied_objects"`    // 复制的顶层文件和目录
+	VerifiedArchives int              `json:"verified_archives"` // 大小和校验和一致的压缩包数
+	Mismatches       []VerifyMismatch `json:"mismatches"`        // 复制后校验失败的压缩包
+	Duration         time.Duration    `json:"duration"`
+}
+
+// RunStatus 长时间运行的备份写入状态文件的进度，供外部监控读取
+type RunStatus struct {
+	RunID           string    `json:"run_id"`                // 本次运行标识
+	Mode            string    `json:"mode"`                  // 备份模式
+	Phase           string    `json:"phase"`                 // 当前阶段：scanning/archiving/metadata/done/failed
+	GroupsCompleted int       `json:"groups_completed"`      // 已处理的压缩包组数（包括失败的）
+	GroupsTotal     int       `json:"groups_total"`          // 本次需要处理的压缩包组数
+	BytesUploaded   int64     `json:"bytes_uploaded"`        // 已上传的压缩包字节数
+	StartTime       time.Time `json:"start_time"`            // 运行开始时间
+	UpdateTime      time.Time `json:"update_time"`           // 状态文件更新时间
+	ETASeconds      int64     `json:"eta_seconds,omitempty"` // 按已处理分组的平均耗时估算的剩余秒数
+	Error           string    `json:"error,omitempty"`       // 运行失败时的错误
+}
+
+// RegroupResult 按新的前缀位数重新分组的结果
+type RegroupResult struct {
+	OldPrefixDigits int           `json:"old_prefix_digits"` // 原前缀位数
+	NewPrefixDigits int           `json:"new_prefix_digits"` // 新前缀位数
+	Unchanged       []string      `json:"unchanged"`         // 名称和内容都不变的压缩包
+	Copied          []string      `json:"copied"`            // 目录与某个旧压缩包相同，在远程复制并改名的压缩包
+	Rebuilt         []string      `json:"rebuilt"`           // 下载旧压缩包后重新打包的压缩包
+	Deleted         []string      `json:"deleted"`           // 删除的旧压缩包
+	Duration        time.Duration `json:"duration"`
+}
+
+// ScanGroupStats 单个前缀分组的扫描统计
+type ScanGroupStats struct {
+	Prefix      string `json:"prefix"`      // 分组前缀
+	Directories int    `json:"directories"` // chunk目录数
+	Files       int    `json:"files"`       // 文件数
+	Size        int64  `json:"size"`        // 总大小（字节）
+}
+
+// ScanReport chunk目录扫描报告
+type ScanReport struct {
+	ChunkPath      string           `json:"chunk_path"`
+	PrefixDigits   int              `json:"prefix_digits"`
+	DirectoryCount int              `json:"directory_count"`
+	FileCount      int              `json:"file_count"`
+	TotalSize      int64            `json:"total_size"`
+	Groups         []ScanGroupStats `json:"groups"` // 按前缀分组的统计，按前缀排序
+}
+
+// ReportSummary summary命令汇总多个运行报告得到的统计
+type ReportSummary struct {
+	Runs            int             `json:"runs"`
+	Succeeded       int             `json:"succeeded"`
+	SuccessRate     float64         `json:"success_rate"` // 成功运行的比例（0到1）
+	UploadedBytes   int64           `json:"uploaded_bytes"`
+	AverageDuration time.Duration   `json:"average_duration"`
+	Hosts           []HostSummary   `json:"hosts"`         // 按主机名排序
+	Failures        []ReportFailure `json:"failures"`      // 按开始时间排序
+	InvalidLines    int             `json:"invalid_lines"` // 无法解析而跳过的报告行
+}
+
+// HostSummary 单个主机的运行统计
+type HostSummary struct {
+	Host            string        `json:"host"`
+	Runs            int           `json:"runs"`
+	Succeeded       int           `json:"succeeded"`
+	SuccessRate     float64       `json:"success_rate"`
+	UploadedBytes   int64         `json:"uploaded_bytes"`
+	AverageDuration time.Duration `json:"average_duration"`
+	LastRun         time.Time     `json:"last_run"`
+	LastSuccess     time.Time     `json:"last_success"` // 没有成功运行时为零值
+}
+
+// ReportFailure 失败或部分失败的一次运行
+type ReportFailure struct {
+	Host           string    `json:"host"`
+	RunID          string    `json:"run_id"`
+	StartTime      time.Time `json:"start_time"`
+	Error          string    `json:"error"`
+	FailedArchives []string  `json:"failed_archives,omitempty"`
+}
+
+// DirSizeChange 两次备份之间单个chunk目录的大小变化
+type DirSizeChange struct {
+	Directory string `json:"directory"`
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+	Delta     int64  `json:"delta"` // 新大小减旧大小，负数表示缩小
 }
@@ -0,0 +1,96 @@
+// Package pbs 解析Proxmox Backup Server的chunk文件格式，用于在备份前检查源数据是否已经损坏
+package pbs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrCorruptChunk chunk文件内容与格式或文件名记录的摘要不一致
+var ErrCorruptChunk = errors.New("corrupt chunk")
+
+// PBS数据块（DataBlob）头部的魔数，取自sha256("Proxmox Backup ... blob v1.0")的前8字节
+var (
+	uncompressedMagic = []byte{66, 171, 56, 7, 190, 131, 112, 161}
+	compressedMagic   = []byte{49, 185, 88, 66, 111, 182, 163, 127}
+	encryptedMagic    = []byte{123, 103, 133, 190, 34, 45, 76, 240}
+	encrComprMagic    = []byte{230, 89, 27, 191, 11, 191, 216, 11}
+)
+
+const (
+	// blobHeaderSize 魔数和CRC32
+	blobHeaderSize = 8 + 4
+	// encryptedHeaderSize 加密块在普通头部之后还有16字节IV和16字节认证标签
+	encryptedHeaderSize = blobHeaderSize + 16 + 16
+)
+
+var (
+	chunkNamePattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	zstdDecoder, _   = zstd.NewReader(nil)
+)
+
+// IsChunkName 判断文件名是否为chunk摘要（64位小写十六进制）
+func IsChunkName(name string) bool {
+	return chunkNamePattern.MatchString(name)
+}
+
+// VerifyChunk 校验chunk文件：检查魔数和CRC32，未加密的chunk再比较内容SHA256与文件名。
+// 加密chunk的文件名是带密钥的摘要，只能检查CRC32。
+// 内容损坏时返回的错误满足errors.Is(err, ErrCorruptChunk)，读取失败时返回原始错误
+func VerifyChunk(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) < blobHeaderSize {
+		return fmt.Errorf("%w: file too short (%d bytes)", ErrCorruptChunk, len(data))
+	}
+
+	magic := data[:8]
+	headerSize := blobHeaderSize
+	encrypted := bytes.Equal(magic, encryptedMagic) || bytes.Equal(magic, encrComprMagic)
+	switch {
+	case encrypted:
+		headerSize = encryptedHeaderSize
+		if len(data) < headerSize {
+			return fmt.Errorf("%w: encrypted header truncated", ErrCorruptChunk)
+		}
+	case bytes.Equal(magic, uncompressedMagic), bytes.Equal(magic, compressedMagic):
+	default:
+		return fmt.Errorf("%w: unknown blob magic %x", ErrCorruptChunk, magic)
+	}
+
+	expectedCRC := binary.LittleEndian.Uint32(data[8:12])
+	if actual := crc32.ChecksumIEEE(data[headerSize:]); actual != expectedCRC {
+		return fmt.Errorf("%w: crc mismatch: expected %08x, got %08x", ErrCorruptChunk, expectedCRC, actual)
+	}
+	if encrypted {
+		return nil
+	}
+
+	payload := data[headerSize:]
+	if bytes.Equal(magic, compressedMagic) {
+		payload, err = zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return fmt.Errorf("%w: failed to decompress: %v", ErrCorruptChunk, err)
+		}
+	}
+
+	// 文件名不是摘要时（例如被改名的文件）无法比较，只做格式检查
+	sum := sha256.Sum256(payload)
+	name := filepath.Base(path)
+	if digest := hex.EncodeToString(sum[:]); IsChunkName(name) && digest != name {
+		return fmt.Errorf("%w: digest mismatch: got %s", ErrCorruptChunk, digest)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package pbs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// encodeBlob 按PBS数据块格式编码：魔数、payload的CRC32、payload
+func encodeBlob(magic, payload []byte) []byte {
+	blob := append([]byte{}, magic...)
+	blob = binary.LittleEndian.AppendUint32(blob, crc32.ChecksumIEEE(payload))
+	return append(blob, payload...)
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// TestVerifyChunk 测试各种格式的chunk校验
+func TestVerifyChunk(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("proxmox backup chunk content")
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("创建zstd编码器失败: %v", err)
+	}
+	compressed := encoder.EncodeAll(content, nil)
+
+	encryptedPayload := make([]byte, 32+len(content)) // IV、认证标签和密文
+	copy(encryptedPayload[32:], content)
+	encrypted := append(append([]byte{}, encryptedMagic...), 0, 0, 0, 0)
+	encrypted = append(encrypted, encryptedPayload...)
+	binary.LittleEndian.PutUint32(encrypted[8:12], crc32.ChecksumIEEE(encryptedPayload[32:]))
+
+	badCRC := encodeBlob(uncompressedMagic, content)
+	badCRC[len(badCRC)-1] ^= 0xff
+
+	testCases := []struct {
+		name    string
+		file    string
+		data    []byte
+		corrupt bool
+	}{
+		{"未压缩", digest(content), encodeBlob(uncompressedMagic, content), false},
+		{"zstd压缩", digest(content), encodeBlob(compressedMagic, compressed), false},
+		{"加密只校验CRC", digest([]byte("keyed")), encrypted, false},
+		{"内容与文件名不一致", digest([]byte("other")), encodeBlob(uncompressedMagic, content), true},
+		{"CRC不一致", digest(content), badCRC, true},
+		{"未知魔数", digest(content), append(make([]byte, 12), content...), true},
+		{"文件过短", digest(content), []byte{1, 2, 3}, true},
+		{"压缩数据损坏", digest(content), encodeBlob(compressedMagic, content), true},
+	}
+	for _, tc := range testCases {
+		path := filepath.Join(dir, tc.file)
+		if err := os.WriteFile(path, tc.data, 0644); err != nil {
+			t.Fatalf("写入chunk失败: %v", err)
+		}
+		err := VerifyChunk(path)
+		if tc.corrupt != errors.Is(err, ErrCorruptChunk) {
+			t.Errorf("%s: 期望损坏=%v，实际 %v", tc.name, tc.corrupt, err)
+		}
+		if !tc.corrupt && err != nil {
+			t.Errorf("%s: 不应返回错误: %v", tc.name, err)
+		}
+	}
+
+	if err := VerifyChunk(filepath.Join(dir, "missing")); err == nil || errors.Is(err, ErrCorruptChunk) {
+		t.Errorf("文件不存在时应返回读取错误，实际 %v", err)
+	}
+	if IsChunkName("0000") || !IsChunkName(digest(content)) {
+		t.Error("IsChunkName结果不正确")
+	}
+}
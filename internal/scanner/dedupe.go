@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"path"
+	"sort"
+
+	"pbs-backuper/internal/models"
+)
+
+// DuplicateFiles 根据文件树中记录的哈希找出内容重复的文件，返回路径到哈希的映射。
+// 路径相对于chunk目录并使用正斜杠（与tar包中的条目名称一致）。
+// 文件树需要在HashFiles模式下扫描；没有哈希的文件和空文件不参与去重。
+func DuplicateFiles(fileTree map[string]*models.FileTreeNode) map[string]string {
+	paths := make(map[string][]string)
+
+	dirNames := make([]string, 0, len(fileTree))
+	for dirName := range fileTree {
+		dirNames = append(dirNames, dirName)
+	}
+	sort.Strings(dirNames)
+
+	for _, dirName := range dirNames {
+		collectHashes(fileTree[dirName], dirName, paths)
+	}
+
+	duplicates := make(map[string]string)
+	for hash, files := range paths {
+		if len(files) < 2 {
+			continue
+		}
+		for _, file := range files {
+			duplicates[file] = hash
+		}
+	}
+	return duplicates
+}
+
+// collectHashes 递归收集节点下每个文件的哈希
+func collectHashes(node *models.FileTreeNode, nodePath string, paths map[string][]string) {
+	if !node.IsDir {
+		if node.Hash != "" && node.Size > 0 {
+			paths[node.Hash] = append(paths[node.Hash], nodePath)
+		}
+		return
+	}
+
+	for name, child := range node.Children {
+		collectHashes(child, path.Join(nodePath, name), paths)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package scanner
+
+import "os"
+
+// statDirID 其他平台的FileInfo不提供inode号，不检测目录循环，只依靠深度限制，也不记录文件的inode号
+func statDirID(os.FileInfo) (dirID, bool) {
+	return dirID{}, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package scanner
+
+import (
+	"os"
+	"syscall"
+)
+
+// statDirID 返回目录（或文件）的设备号和inode号
+func statDirID(info os.FileInfo) (dirID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return dirID{}, false
+	}
+	return dirID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
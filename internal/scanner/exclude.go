@@ -0,0 +1,83 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultExcludePatterns 默认排除的文件：PBS写入chunk时的临时文件（*.tmp）、原子替换文件时的临时文件（*.tmp_*），
+// 以及校验或垃圾回收发现损坏后改名的chunk（*.bad）
+var DefaultExcludePatterns = []string{"*.tmp", "*.tmp_*", "*.bad"}
+
+// ValidateExcludePatterns 检查排除模式的语法（filepath.Match）。以/结尾的模式匹配目录名
+func ValidateExcludePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ReadExcludeFile 读取排除模式文件，每行一个模式，忽略空行和以#开头的注释行，
+// 以/结尾的模式匹配目录名。模式语法错误时返回所在的行号
+func ReadExcludeFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseExcludePatterns(file, path)
+}
+
+// ParseExcludePatterns 按ReadExcludeFile的格式解析排除模式，source用于错误信息中标明来源
+func ParseExcludePatterns(r io.Reader, source string) ([]string, error) {
+	var patterns []string
+	lines := bufio.NewScanner(r)
+	for lineNumber := 1; lines.Scan(); lineNumber++ {
+		pattern := strings.TrimSpace(lines.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		if err := ValidateExcludePatterns([]string{pattern}); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", source, lineNumber, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	return patterns, nil
+}
+
+// MatchExclude 判断文件名（不含目录）是否匹配任一文件排除模式（不以/结尾的模式）
+func MatchExclude(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/") {
+			continue
+		}
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchExcludeDir 判断目录名是否匹配任一目录排除模式（以/结尾的模式）。
+// 只用于chunk目录下的子目录，chunk目录本身由前缀等选项选择
+func MatchExcludeDir(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		dirPattern, isDir := strings.CutSuffix(pattern, "/")
+		if !isDir {
+			continue
+		}
+		if matched, _ := filepath.Match(dirPattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
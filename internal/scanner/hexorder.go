@@ -0,0 +1,47 @@
+package scanner
+
+import (
+	"sort"
+	"strings"
+)
+
+// HexLess 按名称开头的十六进制前缀比较两个名称，用于chunk目录名（0000、00FF）和压缩包名（0000-00ff.tar.gz）。
+// 前缀不区分大小写逐位比较：位数相同时就是数值顺序，位数不同时（宽松匹配的目录名）同一前缀的名称保持相邻。
+// 前缀相同或没有十六进制前缀时按完整名称的字节序比较，因此任意输入都有唯一确定的顺序
+func HexLess(a, b string) bool {
+	if c := compareHexPrefix(hexPrefix(a), hexPrefix(b)); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+// SortHex 按HexLess排序名称
+func SortHex(names []string) {
+	sort.SliceStable(names, func(i, j int) bool {
+		return HexLess(names[i], names[j])
+	})
+}
+
+// hexPrefix 返回名称开头连续的十六进制字符
+func hexPrefix(name string) string {
+	end := 0
+	for end < len(name) && isHexDigit(name[end]) {
+		end++
+	}
+	return name[:end]
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// compareHexPrefix 不区分大小写比较两个十六进制前缀，没有前缀的名称排在所有有前缀的名称之后
+func compareHexPrefix(a, b string) int {
+	if (a == "") != (b == "") {
+		if a == "" {
+			return 1
+		}
+		return -1
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
@@ -0,0 +1,182 @@
+package scanner
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"pbs-backuper/internal/models"
+)
+
+// inodeFile 文件树中记录了inode号的文件
+type inodeFile struct {
+	path string
+	node *models.FileTreeNode
+}
+
+// FindMovedFiles 比较两个文件树中记录的inode号，找出移动过的文件：inode号相同、大小和修改时间不变
+// （两边都有哈希时哈希也相同），旧路径在新文件树中不存在，新路径在旧文件树中不存在。
+// 同一inode号出现多次（硬链接）的文件无法确定对应关系，不视为移动。结果按新路径排序
+func FindMovedFiles(oldTree, newTree map[string]*models.FileTreeNode) []models.MovedFile {
+	oldFiles := inodeIndex(oldTree)
+	if len(oldFiles) == 0 {
+		return nil
+	}
+
+	var moves []models.MovedFile
+	for inode, newFiles := range inodeIndex(newTree) {
+		oldFiles := oldFiles[inode]
+		if len(newFiles) != 1 || len(oldFiles) != 1 {
+			continue
+		}
+		from, to := oldFiles[0], newFiles[0]
+		if from.path == to.path || from.node.Size != to.node.Size || !from.node.ModTime.Equal(to.node.ModTime) {
+			continue
+		}
+		if from.node.Hash != "" && to.node.Hash != "" && from.node.Hash != to.node.Hash {
+			continue
+		}
+		if findNode(newTree, from.path) != nil || findNode(oldTree, to.path) != nil {
+			continue
+		}
+		moves = append(moves, models.MovedFile{From: from.path, To: to.path})
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i].To < moves[j].To
+	})
+	return moves
+}
+
+// DiscountMoves 返回changed中去掉只因moves中的文件移动而变化的目录后的集合：
+// 比较时忽略移动的文件本身，以及移动引起的上级目录修改时间和大小的变化。
+// 除移动外还有其他变化的目录，以及新增或删除的目录，仍视为变化
+func DiscountMoves(oldTree, newTree map[string]*models.FileTreeNode, changed map[string]bool, moves []models.MovedFile, mode CompareMode) map[string]bool {
+	removed := make(map[string][]string) // 旧文件树中按顶层目录忽略的路径
+	added := make(map[string][]string)   // 新文件树中按顶层目录忽略的路径
+	for _, move := range moves {
+		removed[topDir(move.From)] = append(removed[topDir(move.From)], move.From)
+		added[topDir(move.To)] = append(added[topDir(move.To)], move.To)
+	}
+
+	result := make(map[string]bool, len(changed))
+	for dir := range changed {
+		result[dir] = true
+		oldNode, newNode := oldTree[dir], newTree[dir]
+		if oldNode == nil || newNode == nil || (len(removed[dir]) == 0 && len(added[dir]) == 0) {
+			continue
+		}
+
+		oldCopy, newCopy := cloneNode(oldNode), cloneNode(newNode)
+		for _, p := range removed[dir] {
+			removeNode(oldCopy, p)
+		}
+		for _, p := range added[dir] {
+			removeNode(newCopy, p)
+		}
+		// 移动会改变新旧路径上级目录的修改时间
+		for _, paths := range [][]string{removed[dir], added[dir]} {
+			for _, p := range paths {
+				keepParentTimes(oldCopy, newCopy, p)
+			}
+		}
+		recomputeSize(oldCopy)
+		recomputeSize(newCopy)
+
+		if !hasTreeChanged(oldCopy, newCopy, mode) {
+			delete(result, dir)
+		}
+	}
+	return result
+}
+
+// keepParentTimes 把新节点中relPath（含顶层目录名）各级上级目录的修改时间设为旧节点中对应目录的修改时间
+func keepParentTimes(oldRoot, newRoot *models.FileTreeNode, relPath string) {
+	oldDir, newDir := oldRoot, newRoot
+	for _, part := range strings.Split(relPath, "/")[1:] {
+		if oldDir == nil || newDir == nil || !oldDir.IsDir || !newDir.IsDir {
+			return
+		}
+		newDir.ModTime = oldDir.ModTime
+		oldDir, newDir = oldDir.Children[part], newDir.Children[part]
+	}
+}
+
+// inodeIndex 按inode号索引文件树中的文件，没有记录inode号的文件不计入
+func inodeIndex(tree map[string]*models.FileTreeNode) map[uint64][]inodeFile {
+	index := make(map[uint64][]inodeFile)
+	var walk func(node *models.FileTreeNode, nodePath string)
+	walk = func(node *models.FileTreeNode, nodePath string) {
+		if !node.IsDir {
+			if node.Inode != 0 {
+				index[node.Inode] = append(index[node.Inode], inodeFile{path: nodePath, node: node})
+			}
+			return
+		}
+		for name, child := range node.Children {
+			walk(child, path.Join(nodePath, name))
+		}
+	}
+	for name, node := range tree {
+		walk(node, name)
+	}
+	return index
+}
+
+// findNode 按相对路径（正斜杠分隔）在文件树中查找节点，不存在时返回nil
+func findNode(tree map[string]*models.FileTreeNode, relPath string) *models.FileTreeNode {
+	parts := strings.Split(relPath, "/")
+	node := tree[parts[0]]
+	for _, part := range parts[1:] {
+		if node == nil {
+			return nil
+		}
+		node = node.Children[part]
+	}
+	return node
+}
+
+// topDir 返回相对路径的顶层目录名
+func topDir(relPath string) string {
+	return strings.SplitN(relPath, "/", 2)[0]
+}
+
+// cloneNode 深拷贝文件树节点
+func cloneNode(node *models.FileTreeNode) *models.FileTreeNode {
+	clone := *node
+	if node.Children != nil {
+		clone.Children = make(map[string]*models.FileTreeNode, len(node.Children))
+		for name, child := range node.Children {
+			clone.Children[name] = cloneNode(child)
+		}
+	}
+	return &clone
+}
+
+// removeNode 从以root为顶层目录的节点中删除相对路径为relPath（含顶层目录名）的节点
+func removeNode(root *models.FileTreeNode, relPath string) {
+	parts := strings.Split(relPath, "/")[1:]
+	if len(parts) == 0 {
+		return
+	}
+	node := root
+	for _, part := range parts[:len(parts)-1] {
+		node = node.Children[part]
+		if node == nil {
+			return
+		}
+	}
+	delete(node.Children, parts[len(parts)-1])
+}
+
+// recomputeSize 重新计算目录节点的大小（所有子文件的总和）
+func recomputeSize(node *models.FileTreeNode) int64 {
+	if !node.IsDir {
+		return node.Size
+	}
+	node.Size = 0
+	for _, child := range node.Children {
+		node.Size += recomputeSize(child)
+	}
+	return node.Size
+}
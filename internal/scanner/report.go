@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+
+	"pbs-backuper/internal/models"
+)
+
+// BuildScanReport 根据文件树统计目录数、文件数、总大小，并按前缀分组汇总
+func BuildScanReport(fileTree map[string]*models.FileTreeNode, prefixDigits int) (*models.ScanReport, error) {
+	if prefixDigits < 1 || prefixDigits > MaxHexDigits {
+		return nil, fmt.Errorf("prefix digits must be between 1 and %d, got %d", MaxHexDigits, prefixDigits)
+	}
+
+	report := &models.ScanReport{
+		PrefixDigits:   prefixDigits,
+		DirectoryCount: len(fileTree),
+	}
+
+	groupMap := make(map[string]*models.ScanGroupStats)
+	for dirName, node := range fileTree {
+		files := countFiles(node)
+		report.FileCount += files
+		report.TotalSize += node.Size
+
+		if len(dirName) < prefixDigits {
+			return nil, fmt.Errorf("prefix digits %d exceed directory name %s", prefixDigits, dirName)
+		}
+		prefix := dirName[:prefixDigits]
+		stats, exists := groupMap[prefix]
+		if !exists {
+			stats = &models.ScanGroupStats{Prefix: prefix}
+			groupMap[prefix] = stats
+		}
+		stats.Directories++
+		stats.Files += files
+		stats.Size += node.Size
+	}
+
+	for _, stats := range groupMap {
+		report.Groups = append(report.Groups, *stats)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return HexLess(report.Groups[i].Prefix, report.Groups[j].Prefix)
+	})
+
+	return report, nil
+}
+
+// countFiles 递归统计节点下的文件数
+func countFiles(node *models.FileTreeNode) int {
+	if !node.IsDir {
+		return 1
+	}
+
+	count := 0
+	for _, child := range node.Children {
+		count += countFiles(child)
+	}
+	return count
+}
+
+// BuildGrowthReport 比较新旧文件树中每个chunk目录的大小，
+// 返回按大小变化绝对值排序的前limit个目录（limit<=0时返回全部有变化的目录）
+func BuildGrowthReport(oldTree, newTree map[string]*models.FileTreeNode, limit int) []models.DirSizeChange {
+	return BuildGrowthReportFromSizes(TreeSizes(oldTree), newTree, limit)
+}
+
+// TreeSizes 返回文件树中每个chunk目录的大小
+func TreeSizes(fileTree map[string]*models.FileTreeNode) map[string]int64 {
+	sizes := make(map[string]int64, len(fileTree))
+	for dirName, node := range fileTree {
+		sizes[dirName] = node.Size
+	}
+	return sizes
+}
+
+// BuildGrowthReportFromSizes 与BuildGrowthReport相同，但旧文件树只需要每个目录的大小，
+// 供流式比较时使用，不需要保留完整的旧文件树
+func BuildGrowthReportFromSizes(oldSizes map[string]int64, newTree map[string]*models.FileTreeNode, limit int) []models.DirSizeChange {
+	var changes []models.DirSizeChange
+
+	for dirName, newNode := range newTree {
+		oldSize := oldSizes[dirName]
+		if newNode.Size != oldSize {
+			changes = append(changes, models.DirSizeChange{
+				Directory: dirName,
+				OldSize:   oldSize,
+				NewSize:   newNode.Size,
+				Delta:     newNode.Size - oldSize,
+			})
+		}
+	}
+
+	// 已删除的目录
+	for dirName, oldSize := range oldSizes {
+		if _, exists := newTree[dirName]; !exists && oldSize != 0 {
+			changes = append(changes, models.DirSizeChange{
+				Directory: dirName,
+				OldSize:   oldSize,
+				Delta:     -oldSize,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		di, dj := abs(changes[i].Delta), abs(changes[j].Delta)
+		if di != dj {
+			return di > dj
+		}
+		return HexLess(changes[i].Directory, changes[j].Directory)
+	})
+
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes
+}
+
+func abs(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -1,74 +1,306 @@
 package scanner
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zeebo/xxh3"
 
 	"pbs-backuper/internal/models"
 )
 
+// Options 扫描器可选配置
+type Options struct {
+	MaxDirSize      int64     // 超过该大小（字节）的chunk目录不纳入文件树，0表示不限制
+	IncludePrefixes []string  // 只包含以这些前缀开头的chunk目录，为空表示全部包含
+	HashFiles       bool      // 扫描时计算每个文件的内容哈希，供hash比较模式使用
+	HashAlgorithm   string    // HashFiles使用的哈希算法：空或sha256，xxh3只能用于变化检测
+	NewerThan       time.Time // 只包含树内最新修改时间晚于该时间的chunk目录，零值表示不限制
+	HexDigits       int       // chunk目录名的十六进制位数，0表示默认的4位
+	LooseHex        bool      // 目录名只要求以HexDigits位十六进制开头，允许带后缀
+	ExcludePatterns []string  // 文件名匹配这些模式（filepath.Match）的文件不纳入文件树，以/结尾的模式排除匹配的子目录
+	HashBufferSize  int       // 计算SHA256时的读取缓冲区大小（字节），0表示DefaultHashBufferSize
+	ContinueOnError bool      // 扫描chunk目录遇到暂时性错误时重试，多次失败后跳过该目录而不是中止扫描
+	MaxDepth        int       // chunk目录下允许的最大嵌套深度，0表示DefaultMaxDepth
+	TrackInodes     bool      // 记录每个文件的inode号，供FindMovedFiles识别移动的文件；不提供inode号的平台上不记录
+}
+
+const (
+	// DefaultHexDigits PBS datastore中chunk目录名的十六进制位数
+	DefaultHexDigits = 4
+	// MaxHexDigits 支持的最大十六进制位数，目录编号需要能放入32位整数
+	MaxHexDigits = 8
+	// DefaultHashBufferSize 计算SHA256时默认的读取缓冲区大小。io.Copy默认的32KB缓冲区
+	// 每次读取的系统调用开销在高速磁盘上成为瓶颈，1MB足以让哈希计算本身成为限制
+	DefaultHashBufferSize = 1 << 20
+	// DefaultMaxDepth chunk目录下默认允许的最大嵌套深度。PBS的chunk目录只有一层文件，
+	// 超过该深度通常说明目录被误挂载或复制了别的目录树
+	DefaultMaxDepth = 32
+)
+
+var (
+	// ErrMaxDepth 目录嵌套超过了Options.MaxDepth
+	ErrMaxDepth = errors.New("directory nesting exceeds max depth")
+
+	// ErrDirectoryLoop 目录包含自己的上级目录（如绑定挂载到自身的子目录），继续扫描不会结束
+	ErrDirectoryLoop = errors.New("directory loop detected")
+)
+
+// dirID 目录的设备号和inode号，同一目录经不同路径出现时相同
+type dirID struct {
+	dev uint64
+	ino uint64
+}
+
+// ChunkDirPattern 返回匹配chunk目录名的正则表达式。
+// digits为0时使用DefaultHexDigits；loose为true时只要求以digits位十六进制开头
+func ChunkDirPattern(digits int, loose bool) *regexp.Regexp {
+	if digits == 0 {
+		digits = DefaultHexDigits
+	}
+	pattern := fmt.Sprintf(`^[0-9a-fA-F]{%d}`, digits)
+	if !loose {
+		pattern += "$"
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// CompareMode 文件树变化检测策略
+type CompareMode string
+
+const (
+	CompareMtimeSize CompareMode = "mtime-size" // 比较大小和修改时间（默认）
+	CompareSizeOnly  CompareMode = "size-only"  // 只比较大小，适用于rsync等不保留修改时间的副本
+	CompareHash      CompareMode = "hash"       // 比较大小和文件内容哈希
+)
+
+// 文件树中文件内容哈希的算法
+const (
+	HashSHA256 = "sha256" // 默认，跨分组去重和源内容校验和依赖该算法
+	HashXXH3   = "xxh3"   // 非加密哈希，比SHA256快得多，只用于判断文件是否变化
+)
+
+// ParseHashAlgorithm 解析文件内容哈希算法，空字符串表示默认的sha256
+func ParseHashAlgorithm(algorithm string) (string, error) {
+	switch algorithm {
+	case "", HashSHA256:
+		return HashSHA256, nil
+	case HashXXH3:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("invalid hash algorithm %q (expected %s or %s)", algorithm, HashSHA256, HashXXH3)
+	}
+}
+
+// ParseCompareMode 解析比较模式，空字符串表示默认的mtime-size
+func ParseCompareMode(mode string) (CompareMode, error) {
+	switch CompareMode(mode) {
+	case "":
+		return CompareMtimeSize, nil
+	case CompareMtimeSize, CompareSizeOnly, CompareHash:
+		return CompareMode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid compare mode %q (expected %s, %s or %s)", mode, CompareMtimeSize, CompareSizeOnly, CompareHash)
+	}
+}
+
 // ChunkScanner 负责扫描.chunk目录
 type ChunkScanner struct {
 	chunkPath string
+	options   Options
+	excluded  map[string]int64 // 上次扫描中因超过大小限制被排除的目录及其大小
+	stale     []string         // 上次扫描中因修改时间早于NewerThan被跳过的目录
+	failed    []string         // 上次扫描中多次遇到暂时性错误而跳过的目录
+	filtered  []string         // 上次扫描中不匹配IncludePrefixes而跳过的目录
+
+	readDir func(name string) ([]os.DirEntry, error) // 读取目录内容，测试时替换以模拟挂载错误
+
+	progress func(done, total int) // 每扫描完一个chunk目录后调用，为nil时不报告进度
 }
 
 // NewChunkScanner 创建新的扫描器
 func NewChunkScanner(chunkPath string) *ChunkScanner {
+	return NewChunkScannerWithOptions(chunkPath, Options{})
+}
+
+// NewChunkScannerWithOptions 使用可选配置创建扫描器
+func NewChunkScannerWithOptions(chunkPath string, options Options) *ChunkScanner {
 	return &ChunkScanner{
 		chunkPath: chunkPath,
+		options:   options,
+		excluded:  make(map[string]int64),
+		readDir:   os.ReadDir,
 	}
 }
 
+// ExcludedDirectories 返回上次ScanFileTree中因超过MaxDirSize被排除的目录及其大小
+func (s *ChunkScanner) ExcludedDirectories() map[string]int64 {
+	return s.excluded
+}
+
+// SetProgress 设置扫描进度回调，每扫描完一个chunk目录后以已扫描数和待扫描的目录总数调用
+func (s *ChunkScanner) SetProgress(progress func(done, total int)) {
+	s.progress = progress
+}
+
+// StaleDirectories 返回上次ScanFileTree中因修改时间早于NewerThan被跳过的目录（按字典序排序）
+func (s *ChunkScanner) StaleDirectories() []string {
+	return s.stale
+}
+
+// UnreadableDirectories 返回上次ScanFileTree中启用ContinueOnError后多次遇到暂时性错误而跳过的目录（按字典序排序）
+func (s *ChunkScanner) UnreadableDirectories() []string {
+	return s.failed
+}
+
+// FilteredDirectories 返回上次ScanFileTree中不匹配IncludePrefixes而跳过的目录（按字典序排序）
+func (s *ChunkScanner) FilteredDirectories() []string {
+	return s.filtered
+}
+
+// isIncluded 检查目录名是否匹配包含前缀
+func (s *ChunkScanner) isIncluded(name string) bool {
+	if len(s.options.IncludePrefixes) == 0 {
+		return true
+	}
+
+	lower := strings.ToLower(name)
+	for _, prefix := range s.options.IncludePrefixes {
+		if strings.HasPrefix(lower, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
 // ScanFileTree 扫描chunk目录，构建文件树
 func (s *ChunkScanner) ScanFileTree() (map[string]*models.FileTreeNode, error) {
 	fileTree := make(map[string]*models.FileTreeNode)
+	err := s.WalkFileTree(func(name string, node *models.FileTreeNode) error {
+		fileTree[name] = node
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileTree, nil
+}
 
+// WalkFileTree 按目录名的字典序逐个扫描chunk目录，每扫描完一个目录就交给fn处理，
+// 调用方不需要保留整棵文件树。过滤规则与ScanFileTree相同，fn返回错误时停止扫描
+func (s *ChunkScanner) WalkFileTree(fn func(name string, node *models.FileTreeNode) error) error {
 	// 检查chunk目录是否存在
 	if _, err := os.Stat(s.chunkPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("chunk directory does not exist: %s", s.chunkPath)
+		return fmt.Errorf("chunk directory does not exist: %s", s.chunkPath)
 	}
 
-	// 遍历chunk目录下的所有条目
-	entries, err := os.ReadDir(s.chunkPath)
+	// 遍历chunk目录下的所有条目（os.ReadDir按名称排序）
+	var entries []os.DirEntry
+	err := s.retryTransient(func() (err error) {
+		entries, err = s.readDir(s.chunkPath)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read chunk directory: %w", err)
+		return fmt.Errorf("failed to read chunk directory: %w", err)
 	}
 
 	// 只处理符合16进制命名规则的目录
-	hexPattern := regexp.MustCompile(`^[0-9a-fA-F]{4}$`)
-
+	hexPattern := ChunkDirPattern(s.options.HexDigits, s.options.LooseHex)
+	s.excluded = make(map[string]int64)
+	s.stale = nil
+	s.failed = nil
+	s.filtered = nil
+
+	// 先筛选出要扫描的目录，得到进度的总数
+	var dirs []os.DirEntry
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue // 跳过非目录文件
 		}
 
-		// 检查目录名是否符合4位16进制格式
+		// 检查目录名是否符合16进制格式
 		if !hexPattern.MatchString(entry.Name()) {
 			continue // 跳过不符合命名规则的目录
 		}
+		if !s.isIncluded(entry.Name()) {
+			s.filtered = append(s.filtered, entry.Name())
+			continue // 跳过不在包含前缀中的目录
+		}
+		dirs = append(dirs, entry)
+	}
 
+	for i, entry := range dirs {
 		// 扫描子目录
 		dirPath := filepath.Join(s.chunkPath, entry.Name())
-		node, err := s.scanDirectory(dirPath)
+		var node *models.FileTreeNode
+		err := s.retryTransient(func() (err error) {
+			node, err = s.scanDirectory(dirPath, 0, nil)
+			return err
+		})
+		if s.progress != nil {
+			s.progress(i+1, len(dirs))
+		}
+		// 挂载多次出错时跳过该目录并记录，由调用方决定如何处理
+		if err != nil && s.options.ContinueOnError && IsTransientError(err) {
+			s.failed = append(s.failed, entry.Name())
+			continue
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan directory %s: %w", dirPath, err)
+			return fmt.Errorf("failed to scan directory %s: %w", dirPath, err)
+		}
+
+		// 超过大小限制的目录不纳入常规分组，记录下来以便单独处理
+		if s.options.MaxDirSize > 0 && node.Size > s.options.MaxDirSize {
+			s.excluded[entry.Name()] = node.Size
+			continue
 		}
 
-		fileTree[entry.Name()] = node
+		// 整棵树都没有晚于截止时间的修改，跳过
+		if !s.options.NewerThan.IsZero() && !treeModTime(node).After(s.options.NewerThan) {
+			s.stale = append(s.stale, entry.Name())
+			continue
+		}
+
+		if err := fn(entry.Name(), node); err != nil {
+			return err
+		}
 	}
 
-	return fileTree, nil
+	return nil
 }
 
-// scanDirectory 递归扫描目录，构建文件树节点
-func (s *ChunkScanner) scanDirectory(dirPath string) (*models.FileTreeNode, error) {
+// scanDirectory 递归扫描目录，构建文件树节点。depth为dirPath在chunk目录下的嵌套深度，
+// ancestors为从chunk目录到dirPath的上级目录，目录再次出现在自己的上级中时返回ErrDirectoryLoop。
+// 符号链接作为普通条目记录，不跟随
+func (s *ChunkScanner) scanDirectory(dirPath string, depth int, ancestors []dirID) (*models.FileTreeNode, error) {
+	maxDepth := s.options.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w %d: %s", ErrMaxDepth, maxDepth, dirPath)
+	}
+
 	info, err := os.Stat(dirPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat directory %s: %w", dirPath, err)
 	}
+	if id, ok := statDirID(info); ok {
+		if slices.Contains(ancestors, id) {
+			return nil, fmt.Errorf("%w: %s is its own ancestor", ErrDirectoryLoop, dirPath)
+		}
+		ancestors = append(ancestors, id)
+	}
 
 	node := &models.FileTreeNode{
 		Name:     filepath.Base(dirPath),
@@ -79,7 +311,7 @@ func (s *ChunkScanner) scanDirectory(dirPath string) (*models.FileTreeNode, erro
 	}
 
 	// 读取目录内容
-	entries, err := os.ReadDir(dirPath)
+	entries, err := s.readDir(dirPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read directory %s: %w", dirPath, err)
 	}
@@ -89,14 +321,24 @@ func (s *ChunkScanner) scanDirectory(dirPath string) (*models.FileTreeNode, erro
 		entryPath := filepath.Join(dirPath, entry.Name())
 
 		if entry.IsDir() {
+			// 排除的子目录整体不记录到文件树
+			if MatchExcludeDir(s.options.ExcludePatterns, entry.Name()) {
+				continue
+			}
+
 			// 递归处理子目录
-			childNode, err := s.scanDirectory(entryPath)
+			childNode, err := s.scanDirectory(entryPath, depth+1, ancestors)
 			if err != nil {
 				return nil, err
 			}
 			node.Children[entry.Name()] = childNode
 			node.Size += childNode.Size // 累加子目录大小
 		} else {
+			// 排除的文件不记录到文件树，出现或消失都不会使目录被判断为变化
+			if MatchExclude(s.options.ExcludePatterns, entry.Name()) {
+				continue
+			}
+
 			// 处理文件
 			fileInfo, err := entry.Info()
 			if err != nil {
@@ -110,6 +352,19 @@ func (s *ChunkScanner) scanDirectory(dirPath string) (*models.FileTreeNode, erro
 				IsDir:   false,
 			}
 
+			if s.options.TrackInodes {
+				if id, ok := statDirID(fileInfo); ok {
+					fileNode.Inode = id.ino
+				}
+			}
+
+			if s.options.HashFiles {
+				fileNode.Hash, err = hashFile(entryPath, s.options.HashBufferSize, s.options.HashAlgorithm)
+				if err != nil {
+					return nil, err
+				}
+			}
+
 			node.Children[entry.Name()] = fileNode
 			node.Size += fileInfo.Size() // 累加文件大小
 		}
@@ -118,30 +373,85 @@ func (s *ChunkScanner) scanDirectory(dirPath string) (*models.FileTreeNode, erro
 	return node, nil
 }
 
-// GetChunkDirectories 获取所有有效的chunk目录名列表（按字典序排序）
+// treeModTime 返回节点及其所有子节点中最新的修改时间
+func treeModTime(node *models.FileTreeNode) time.Time {
+	latest := node.ModTime
+	for _, child := range node.Children {
+		if childTime := treeModTime(child); childTime.After(latest) {
+			latest = childTime
+		}
+	}
+	return latest
+}
+
+// hashFile 按指定算法计算文件内容的哈希，algorithm为空时使用SHA256
+func hashFile(filePath string, bufferSize int, algorithm string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	var h hash.Hash = sha256.New()
+	if algorithm == HashXXH3 {
+		h = xxh3.New()
+	}
+	sum, err := hashReader(file, bufferSize, h)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", filePath, err)
+	}
+	return sum, nil
+}
+
+// HashReader 使用bufferSize字节的缓冲区读取r并返回内容的十六进制SHA256，bufferSize不大于0时使用DefaultHashBufferSize
+func HashReader(r io.Reader, bufferSize int) (string, error) {
+	return hashReader(r, bufferSize, sha256.New())
+}
+
+// hashReader 使用bufferSize字节的缓冲区把r的内容写入h，返回十六进制的哈希值
+func hashReader(r io.Reader, bufferSize int, h hash.Hash) (string, error) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultHashBufferSize
+	}
+	// 隐藏*os.File的WriteTo，否则io.CopyBuffer会交给它处理并退回32KB的默认缓冲区
+	if _, err := io.CopyBuffer(h, struct{ io.Reader }{r}, make([]byte, bufferSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GetChunkDirectories 获取所有有效的chunk目录名列表（按十六进制数值排序）
 func (s *ChunkScanner) GetChunkDirectories() ([]string, error) {
-	entries, err := os.ReadDir(s.chunkPath)
+	var entries []os.DirEntry
+	err := s.retryTransient(func() (err error) {
+		entries, err = s.readDir(s.chunkPath)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to read chunk directory: %w", err)
 	}
 
-	hexPattern := regexp.MustCompile(`^[0-9a-fA-F]{4}$`)
+	hexPattern := ChunkDirPattern(s.options.HexDigits, s.options.LooseHex)
 	var directories []string
 
 	for _, entry := range entries {
-		if entry.IsDir() && hexPattern.MatchString(entry.Name()) {
+		if entry.IsDir() && hexPattern.MatchString(entry.Name()) && s.isIncluded(entry.Name()) {
 			directories = append(directories, entry.Name())
 		}
 	}
 
-	// 按字典序排序
-	sort.Strings(directories)
+	SortHex(directories)
 
 	return directories, nil
 }
 
-// CompareFileTrees 比较两个文件树，找出差异
+// CompareFileTrees 比较两个文件树，找出差异（使用mtime-size模式）
 func CompareFileTrees(oldTree, newTree map[string]*models.FileTreeNode) map[string]bool {
+	return CompareFileTreesWithMode(oldTree, newTree, CompareMtimeSize)
+}
+
+// CompareFileTreesWithMode 按指定的比较模式比较两个文件树，找出差异
+func CompareFileTreesWithMode(oldTree, newTree map[string]*models.FileTreeNode, mode CompareMode) map[string]bool {
 	changedDirs := make(map[string]bool)
 
 	// 检查新树中的目录
@@ -154,7 +464,7 @@ func CompareFileTrees(oldTree, newTree map[string]*models.FileTreeNode) map[stri
 		}
 
 		// 比较目录树
-		if hasTreeChanged(oldNode, newNode) {
+		if hasTreeChanged(oldNode, newNode, mode) {
 			changedDirs[dirName] = true
 		}
 	}
@@ -170,17 +480,20 @@ func CompareFileTrees(oldTree, newTree map[string]*models.FileTreeNode) map[stri
 }
 
 // hasTreeChanged 递归比较两个文件树节点是否有变化
-func hasTreeChanged(oldNode, newNode *models.FileTreeNode) bool {
+func hasTreeChanged(oldNode, newNode *models.FileTreeNode, mode CompareMode) bool {
 	// 比较基本属性
-	if oldNode.Size != newNode.Size ||
-		!oldNode.ModTime.Equal(newNode.ModTime) ||
-		oldNode.IsDir != newNode.IsDir {
+	if oldNode.Size != newNode.Size || oldNode.IsDir != newNode.IsDir {
 		return true
 	}
 
-	// 如果是文件，直接返回结果
+	// 如果是文件，按比较模式判断
 	if !oldNode.IsDir {
-		return false
+		return hasFileChanged(oldNode, newNode, mode)
+	}
+
+	// 目录的修改时间只在mtime-size模式下比较，其余模式依靠子节点判断
+	if mode == CompareMtimeSize && !oldNode.ModTime.Equal(newNode.ModTime) {
+		return true
 	}
 
 	// 比较子节点数量
@@ -195,7 +508,7 @@ func hasTreeChanged(oldNode, newNode *models.FileTreeNode) bool {
 			return true // 子节点被删除
 		}
 
-		if hasTreeChanged(oldChild, newChild) {
+		if hasTreeChanged(oldChild, newChild, mode) {
 			return true
 		}
 	}
@@ -209,3 +522,17 @@ func hasTreeChanged(oldNode, newNode *models.FileTreeNode) bool {
 
 	return false
 }
+
+// hasFileChanged 比较两个大小相同的文件节点。
+// hash模式下任一方缺少哈希（如旧元数据未记录）时退回到比较修改时间。
+func hasFileChanged(oldNode, newNode *models.FileTreeNode, mode CompareMode) bool {
+	switch mode {
+	case CompareSizeOnly:
+		return false
+	case CompareHash:
+		if oldNode.Hash != "" && newNode.Hash != "" {
+			return oldNode.Hash != newNode.Hash
+		}
+	}
+	return !oldNode.ModTime.Equal(newNode.ModTime)
+}
@@ -1,11 +1,19 @@
 package scanner
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/zeebo/xxh3"
+
 	"pbs-backuper/internal/models"
 )
 
@@ -159,3 +167,734 @@ func TestCompareFileTrees(t *testing.T) {
 		t.Errorf("Expected %d changed directories, got %d", expectedChanges, len(changedDirs))
 	}
 }
+
+func TestBuildScanReport(t *testing.T) {
+	tempDir := t.TempDir()
+
+	files := map[string]int{
+		"0000/a":     10,
+		"0000/sub/b": 20,
+		"0001/c":     30,
+		"0100/d":     40,
+		"ab00/e":     50,
+	}
+	for name, size := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	fileTree, err := NewChunkScanner(tempDir).ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+
+	report, err := BuildScanReport(fileTree, 2)
+	if err != nil {
+		t.Fatalf("BuildScanReport failed: %v", err)
+	}
+
+	if report.DirectoryCount != 4 || report.FileCount != 5 || report.TotalSize != 150 {
+		t.Errorf("Unexpected totals: dirs=%d files=%d size=%d", report.DirectoryCount, report.FileCount, report.TotalSize)
+	}
+
+	expected := []models.ScanGroupStats{
+		{Prefix: "00", Directories: 2, Files: 3, Size: 60},
+		{Prefix: "01", Directories: 1, Files: 1, Size: 40},
+		{Prefix: "ab", Directories: 1, Files: 1, Size: 50},
+	}
+	if len(report.Groups) != len(expected) {
+		t.Fatalf("Expected %d groups, got %d", len(expected), len(report.Groups))
+	}
+	for i, want := range expected {
+		if report.Groups[i] != want {
+			t.Errorf("Group %d: expected %+v, got %+v", i, want, report.Groups[i])
+		}
+	}
+
+	if _, err := BuildScanReport(fileTree, 5); err == nil {
+		t.Error("Expected error for invalid prefix digits")
+	}
+}
+
+func TestScannerOptions(t *testing.T) {
+	tempDir := t.TempDir()
+
+	sizes := map[string]int{"0000": 10, "0001": 500, "00ff": 20, "ab00": 30}
+	for dir, size := range sizes {
+		dirPath := filepath.Join(tempDir, dir)
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dirPath, "chunk"), make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	// 超过大小限制的目录被排除并记录
+	s := NewChunkScannerWithOptions(tempDir, Options{MaxDirSize: 100})
+	fileTree, err := s.ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+	if _, exists := fileTree["0001"]; exists {
+		t.Error("Oversized directory 0001 should be excluded from file tree")
+	}
+	if len(fileTree) != 3 {
+		t.Errorf("Expected 3 directories in file tree, got %d", len(fileTree))
+	}
+	if size, exists := s.ExcludedDirectories()["0001"]; !exists || size != 500 {
+		t.Errorf("Expected 0001 to be recorded as excluded with size 500, got %v", s.ExcludedDirectories())
+	}
+
+	// 包含前缀只保留匹配的目录
+	s = NewChunkScannerWithOptions(tempDir, Options{IncludePrefixes: []string{"00F", "ab"}})
+	dirs, err := s.GetChunkDirectories()
+	if err != nil {
+		t.Fatalf("GetChunkDirectories failed: %v", err)
+	}
+	if len(dirs) != 2 || dirs[0] != "00ff" || dirs[1] != "ab00" {
+		t.Errorf("Expected [00ff ab00], got %v", dirs)
+	}
+	fileTree, err = s.ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+	if len(fileTree) != 2 {
+		t.Errorf("Expected 2 directories in file tree, got %d", len(fileTree))
+	}
+	if filtered := s.FilteredDirectories(); !slices.Equal(filtered, []string{"0000", "0001"}) {
+		t.Errorf("Expected [0000 0001] to be recorded as filtered, got %v", filtered)
+	}
+}
+
+// TestContinueOnScanError 测试暂时性错误重试后恢复、多次失败后跳过目录，路径不存在的错误仍然中止扫描
+func TestContinueOnScanError(t *testing.T) {
+	defer func(delay time.Duration) { scanRetryDelay = delay }(scanRetryDelay)
+	scanRetryDelay = 0
+
+	tempDir := t.TempDir()
+	for _, dir := range []string{"0000", "0001", "00ff"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, dir, "chunk"), []byte(dir), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 0001第一次读取失败后恢复，00ff一直失败
+	failures := map[string]int{"0001": 1, "00ff": scanAttempts}
+	flaky := func(name string) ([]os.DirEntry, error) {
+		if failures[filepath.Base(name)] > 0 {
+			failures[filepath.Base(name)]--
+			return nil, &os.PathError{Op: "readdirent", Path: name, Err: syscall.EIO}
+		}
+		return os.ReadDir(name)
+	}
+
+	s := NewChunkScannerWithOptions(tempDir, Options{ContinueOnError: true})
+	s.readDir = flaky
+	tree, err := s.ScanFileTree()
+	if err != nil {
+		t.Fatalf("暂时性错误不应中止扫描: %v", err)
+	}
+	if len(tree) != 2 || tree["0001"] == nil {
+		t.Errorf("重试成功的目录应在文件树中: %v", tree)
+	}
+	if unreadable := s.UnreadableDirectories(); len(unreadable) != 1 || unreadable[0] != "00ff" {
+		t.Errorf("多次失败的目录应被跳过并记录: %v", unreadable)
+	}
+
+	// 未启用时暂时性错误中止扫描
+	failures["0001"] = 1
+	s = NewChunkScanner(tempDir)
+	s.readDir = flaky
+	if _, err := s.ScanFileTree(); !errors.Is(err, syscall.EIO) {
+		t.Errorf("未启用时应中止扫描: %v", err)
+	}
+
+	// 路径不存在不是暂时性错误，不跳过
+	s = NewChunkScannerWithOptions(tempDir, Options{ContinueOnError: true})
+	s.readDir = func(name string) ([]os.DirEntry, error) {
+		if filepath.Base(name) == "0000" {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+		}
+		return os.ReadDir(name)
+	}
+	if _, err := s.ScanFileTree(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("路径不存在时应中止扫描: %v", err)
+	}
+}
+
+// TestScanDepthAndLoops 测试超过最大嵌套深度和目录循环时中止扫描，符号链接不跟随
+func TestScanDepthAndLoops(t *testing.T) {
+	chunkDir := t.TempDir()
+	deep := filepath.Join(chunkDir, "0000")
+	for i := 0; i < 5; i++ {
+		deep = filepath.Join(deep, "d")
+	}
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(deep, "chunk"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewChunkScannerWithOptions(chunkDir, Options{MaxDepth: 5}).ScanFileTree(); err != nil {
+		t.Fatalf("深度未超过限制时不应出错: %v", err)
+	}
+	_, err := NewChunkScannerWithOptions(chunkDir, Options{MaxDepth: 4}).ScanFileTree()
+	if !errors.Is(err, ErrMaxDepth) || !strings.Contains(err.Error(), "0000") {
+		t.Fatalf("超过最大深度时应返回ErrMaxDepth并指出目录，实际: %v", err)
+	}
+
+	// 指向上级目录的符号链接作为普通条目记录，不会造成循环
+	if err := os.Symlink(chunkDir, filepath.Join(chunkDir, "0000", "up")); err != nil {
+		t.Skipf("无法创建符号链接: %v", err)
+	}
+	tree, err := NewChunkScannerWithOptions(chunkDir, Options{MaxDepth: 5}).ScanFileTree()
+	if err != nil {
+		t.Fatalf("符号链接不应被跟随: %v", err)
+	}
+	if link := tree["0000"].Children["up"]; link == nil || link.IsDir {
+		t.Errorf("符号链接应记录为普通条目: %+v", link)
+	}
+
+	// 绑定挂载造成的循环需要root权限，直接以目录自身作为上级目录模拟：子目录d/d的inode出现在上级中
+	info, err := os.Stat(filepath.Join(chunkDir, "0000", "d", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := statDirID(info)
+	if !ok {
+		t.Skip("当前平台不提供inode号")
+	}
+	s := NewChunkScannerWithOptions(chunkDir, Options{})
+	_, err = s.scanDirectory(filepath.Join(chunkDir, "0000"), 0, []dirID{id})
+	if !errors.Is(err, ErrDirectoryLoop) || !strings.Contains(err.Error(), filepath.Join("d", "d")) {
+		t.Fatalf("目录出现在自己的上级中时应返回ErrDirectoryLoop，实际: %v", err)
+	}
+}
+
+// TestScanProgress 测试扫描进度回调：总数只包含要扫描的目录，被大小限制排除的目录也计入进度
+func TestScanProgress(t *testing.T) {
+	tempDir := t.TempDir()
+	for dir, size := range map[string]int{"0000": 10, "0001": 500, "00ff": 20, "notes": 5} {
+		dirPath := filepath.Join(tempDir, dir)
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dirPath, "chunk"), make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	s := NewChunkScannerWithOptions(tempDir, Options{MaxDirSize: 100})
+	var calls [][2]int
+	s.SetProgress(func(done, total int) { calls = append(calls, [2]int{done, total}) })
+	if _, err := s.ScanFileTree(); err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+
+	expected := [][2]int{{1, 3}, {2, 3}, {3, 3}}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected progress calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Expected progress calls %v, got %v", expected, calls)
+			break
+		}
+	}
+}
+
+func TestHexDigits(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"00", "0a", "ff", "0a.tmp", "abc", "0000", "0000abcd", "ffffffff", "0000abcd-old"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		digits   int
+		loose    bool
+		expected string
+	}{
+		{0, false, "0000"},
+		{2, false, "00,0a,ff"},
+		{2, true, "00,0000,0000abcd,0000abcd-old,0a,0a.tmp,abc,ff,ffffffff"},
+		{8, false, "0000abcd,ffffffff"},
+		{8, true, "0000abcd,0000abcd-old,ffffffff"},
+	}
+	for _, tc := range testCases {
+		s := NewChunkScannerWithOptions(tempDir, Options{HexDigits: tc.digits, LooseHex: tc.loose})
+		dirs, err := s.GetChunkDirectories()
+		if err != nil {
+			t.Fatalf("GetChunkDirectories failed: %v", err)
+		}
+		if got := strings.Join(dirs, ","); got != tc.expected {
+			t.Errorf("digits=%d loose=%v: expected directories %s, got %s", tc.digits, tc.loose, tc.expected, got)
+		}
+
+		fileTree, err := s.ScanFileTree()
+		if err != nil {
+			t.Fatalf("ScanFileTree failed: %v", err)
+		}
+		if len(fileTree) != len(dirs) {
+			t.Errorf("digits=%d loose=%v: file tree has %d directories, expected %d", tc.digits, tc.loose, len(fileTree), len(dirs))
+		}
+	}
+}
+
+func TestCompareModes(t *testing.T) {
+	baseTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// 每种修改方式：只改修改时间、只改大小、只改内容（大小和修改时间不变）
+	modifications := map[string]func(path string) error{
+		"mtime": func(path string) error {
+			return os.Chtimes(path, baseTime, baseTime.Add(time.Minute))
+		},
+		"size": func(path string) error {
+			if err := os.WriteFile(path, []byte("original content!"), 0644); err != nil {
+				return err
+			}
+			return os.Chtimes(path, baseTime, baseTime)
+		},
+		"content": func(path string) error {
+			if err := os.WriteFile(path, []byte("modified content"), 0644); err != nil {
+				return err
+			}
+			return os.Chtimes(path, baseTime, baseTime)
+		},
+	}
+
+	expected := map[CompareMode]map[string]bool{
+		CompareMtimeSize: {"mtime": true, "size": true, "content": false},
+		CompareSizeOnly:  {"mtime": false, "size": true, "content": false},
+		CompareHash:      {"mtime": false, "size": true, "content": true},
+	}
+
+	for mode, cases := range expected {
+		for modification, wantChanged := range cases {
+			t.Run(string(mode)+"/"+modification, func(t *testing.T) {
+				tempDir := t.TempDir()
+				dirPath := filepath.Join(tempDir, "0000")
+				filePath := filepath.Join(dirPath, "chunk")
+				if err := os.MkdirAll(dirPath, 0755); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(filePath, []byte("original content"), 0644); err != nil {
+					t.Fatalf("Failed to create file: %v", err)
+				}
+				if err := os.Chtimes(filePath, baseTime, baseTime); err != nil {
+					t.Fatalf("Failed to set file time: %v", err)
+				}
+
+				s := NewChunkScannerWithOptions(tempDir, Options{HashFiles: mode == CompareHash})
+				oldTree, err := s.ScanFileTree()
+				if err != nil {
+					t.Fatalf("ScanFileTree failed: %v", err)
+				}
+
+				if err := modifications[modification](filePath); err != nil {
+					t.Fatalf("Failed to modify file: %v", err)
+				}
+
+				newTree, err := s.ScanFileTree()
+				if err != nil {
+					t.Fatalf("ScanFileTree failed: %v", err)
+				}
+
+				changedDirs := CompareFileTreesWithMode(oldTree, newTree, mode)
+				if changedDirs["0000"] != wantChanged {
+					t.Errorf("Expected changed=%v, got %v", wantChanged, changedDirs["0000"])
+				}
+			})
+		}
+	}
+}
+
+func TestParseCompareMode(t *testing.T) {
+	mode, err := ParseCompareMode("")
+	if err != nil || mode != CompareMtimeSize {
+		t.Errorf("Expected default mode %s, got %s (%v)", CompareMtimeSize, mode, err)
+	}
+
+	if _, err := ParseCompareMode("checksum"); err == nil {
+		t.Error("Expected error for unknown compare mode")
+	}
+}
+
+func TestFindMovedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"0000/a.dat", "0000/b.dat", "0001/subdir/c.dat", "0002/d.dat", "0003/e.dat"} {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	s := NewChunkScannerWithOptions(tempDir, Options{TrackInodes: true})
+	oldTree, err := s.ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+	if oldTree["0000"].Children["a.dat"].Inode == 0 {
+		t.Skip("Inode numbers are not available on this platform")
+	}
+
+	// 0000/a.dat moves into an existing directory of another group; 0002/d.dat moves within its
+	// directory and 0002 also gets a new file; 0003/e.dat is hard linked, so its move is ambiguous
+	renames := [][2]string{{"0000/a.dat", "0001/subdir/a.dat"}, {"0002/d.dat", "0002/d2.dat"}}
+	for _, rename := range renames {
+		if err := os.Rename(filepath.Join(tempDir, filepath.FromSlash(rename[0])), filepath.Join(tempDir, filepath.FromSlash(rename[1]))); err != nil {
+			t.Fatalf("Failed to move file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "0002", "new.dat"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Link(filepath.Join(tempDir, "0003", "e.dat"), filepath.Join(tempDir, "0003", "e2.dat")); err != nil {
+		t.Fatalf("Failed to create hard link: %v", err)
+	}
+	if err := os.Remove(filepath.Join(tempDir, "0003", "e.dat")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.Link(filepath.Join(tempDir, "0003", "e2.dat"), filepath.Join(tempDir, "0003", "e3.dat")); err != nil {
+		t.Fatalf("Failed to create hard link: %v", err)
+	}
+
+	newTree, err := s.ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+
+	moves := FindMovedFiles(oldTree, newTree)
+	expected := []models.MovedFile{{From: "0002/d.dat", To: "0002/d2.dat"}, {From: "0000/a.dat", To: "0001/subdir/a.dat"}}
+	if len(moves) != len(expected) {
+		t.Fatalf("Expected moves %v, got %v", expected, moves)
+	}
+	for i := range expected {
+		// Sorted by the current path
+		want := expected[len(expected)-1-i]
+		if moves[i] != want {
+			t.Errorf("Expected move %v at %d, got %v", want, i, moves[i])
+		}
+	}
+
+	changed := CompareFileTreesWithMode(oldTree, newTree, CompareMtimeSize)
+	for _, dir := range []string{"0000", "0001", "0002", "0003"} {
+		if !changed[dir] {
+			t.Fatalf("Expected %s to be changed before discounting moves, got %v", dir, changed)
+		}
+	}
+	remaining := DiscountMoves(oldTree, newTree, changed, moves, CompareMtimeSize)
+	for dir, want := range map[string]bool{"0000": false, "0001": false, "0002": true, "0003": true} {
+		if remaining[dir] != want {
+			t.Errorf("Expected %s changed=%v after discounting moves, got %v", dir, want, remaining[dir])
+		}
+	}
+	if !changed["0000"] || !changed["0001"] {
+		t.Error("DiscountMoves must not modify its input")
+	}
+	if oldTree["0000"].Children["a.dat"] == nil || newTree["0001"].Children["subdir"].Children["a.dat"] == nil {
+		t.Error("DiscountMoves must not modify the file trees")
+	}
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "0000", "chunk")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filePath, []byte("original content"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	expected := map[string]string{
+		HashSHA256: "bf573149b23303cac63c2a359b53760d919770c5d070047e76de42e2184f1046",
+		HashXXH3:   fmt.Sprintf("%016x", xxh3.HashString("original content")),
+	}
+	for algorithm, want := range expected {
+		s := NewChunkScannerWithOptions(tempDir, Options{HashFiles: true, HashAlgorithm: algorithm})
+		tree, err := s.ScanFileTree()
+		if err != nil {
+			t.Fatalf("ScanFileTree failed: %v", err)
+		}
+		if got := tree["0000"].Children["chunk"].Hash; got != want {
+			t.Errorf("%s: expected hash %s, got %s", algorithm, want, got)
+		}
+	}
+
+	if algorithm, err := ParseHashAlgorithm(""); err != nil || algorithm != HashSHA256 {
+		t.Errorf("Expected default algorithm %s, got %s (%v)", HashSHA256, algorithm, err)
+	}
+	if _, err := ParseHashAlgorithm("md5"); err == nil {
+		t.Error("Expected error for unknown hash algorithm")
+	}
+}
+
+func TestBuildGrowthReport(t *testing.T) {
+	oldTree := map[string]*models.FileTreeNode{
+		"0000": {Name: "0000", Size: 100, IsDir: true},
+		"0001": {Name: "0001", Size: 500, IsDir: true},
+		"0002": {Name: "0002", Size: 50, IsDir: true},
+		"0003": {Name: "0003", Size: 300, IsDir: true},
+	}
+	newTree := map[string]*models.FileTreeNode{
+		"0000": {Name: "0000", Size: 150, IsDir: true}, // +50
+		"0001": {Name: "0001", Size: 100, IsDir: true}, // -400
+		"0002": {Name: "0002", Size: 50, IsDir: true},  // 无变化
+		"0004": {Name: "0004", Size: 200, IsDir: true}, // 新增 +200
+		// 0003被删除 -300
+	}
+
+	report := BuildGrowthReport(oldTree, newTree, 3)
+
+	expected := []models.DirSizeChange{
+		{Directory: "0001", OldSize: 500, NewSize: 100, Delta: -400},
+		{Directory: "0003", OldSize: 300, NewSize: 0, Delta: -300},
+		{Directory: "0004", OldSize: 0, NewSize: 200, Delta: 200},
+	}
+	if len(report) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d: %+v", len(expected), len(report), report)
+	}
+	for i, want := range expected {
+		if report[i] != want {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, want, report[i])
+		}
+	}
+
+	if all := BuildGrowthReport(oldTree, newTree, 0); len(all) != 4 {
+		t.Errorf("Expected 4 changed directories without limit, got %d", len(all))
+	}
+}
+
+func TestEmptyDirectories(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"0000/sub", "0001", "0002"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "0002", "file.dat"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tree, err := NewChunkScanner(tempDir).ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+
+	// 空的chunk目录和空的子目录都应作为目录节点记录
+	if node := tree["0001"]; node == nil || !node.IsDir || node.Size != 0 || len(node.Children) != 0 {
+		t.Errorf("Empty chunk directory should be recorded, got %+v", node)
+	}
+	if node := tree["0000"]; node == nil || node.Children["sub"] == nil || !node.Children["sub"].IsDir {
+		t.Errorf("Empty subdirectory should be recorded, got %+v", node)
+	}
+
+	// 经过元数据序列化后空目录的Children为nil，不应被视为变化
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatalf("Failed to marshal tree: %v", err)
+	}
+	var stored map[string]*models.FileTreeNode
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("Failed to unmarshal tree: %v", err)
+	}
+	for _, mode := range []CompareMode{CompareMtimeSize, CompareSizeOnly, CompareHash} {
+		if changed := CompareFileTreesWithMode(stored, tree, mode); len(changed) != 0 {
+			t.Errorf("%s: unchanged empty directories reported as changed: %v", mode, changed)
+		}
+	}
+
+	// 删除空子目录是变化
+	if err := os.Remove(filepath.Join(tempDir, "0000", "sub")); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	rescanned, err := NewChunkScanner(tempDir).ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+	if changed := CompareFileTreesWithMode(stored, rescanned, CompareSizeOnly); !changed["0000"] || len(changed) != 1 {
+		t.Errorf("Removed empty subdirectory should mark only 0000 as changed, got %v", changed)
+	}
+}
+
+func TestCompareStream(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"0000", "0001", "0002", "0004"} {
+		dirPath := filepath.Join(tempDir, dir)
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dirPath, "chunk"), []byte("content "+dir), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	s := NewChunkScannerWithOptions(tempDir, Options{HashFiles: true})
+	oldTree, err := s.ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+	data, err := json.Marshal(oldTree)
+	if err != nil {
+		t.Fatalf("Failed to marshal tree: %v", err)
+	}
+
+	// 修改0001，删除0002和0004，新增0003和0005
+	if err := os.WriteFile(filepath.Join(tempDir, "0001", "chunk"), []byte("modified content"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	for _, dir := range []string{"0002", "0004"} {
+		if err := os.RemoveAll(filepath.Join(tempDir, dir)); err != nil {
+			t.Fatalf("Failed to remove directory: %v", err)
+		}
+	}
+	for _, dir := range []string{"0003", "0005"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	newTree, err := s.ScanFileTree()
+	if err != nil {
+		t.Fatalf("ScanFileTree failed: %v", err)
+	}
+	for _, mode := range []CompareMode{CompareMtimeSize, CompareSizeOnly, CompareHash} {
+		decoder, err := NewFileTreeDecoder(strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatalf("NewFileTreeDecoder failed: %v", err)
+		}
+		visited := make(map[string]*models.FileTreeNode)
+		result, err := s.CompareStream(decoder, mode, func(name string, node *models.FileTreeNode) {
+			visited[name] = node
+		})
+		if err != nil {
+			t.Fatalf("%s: CompareStream failed: %v", mode, err)
+		}
+
+		// 结果应与在内存中比较完全一致
+		expected := CompareFileTreesWithMode(oldTree, newTree, mode)
+		if len(result.Changed) != len(expected) {
+			t.Errorf("%s: expected changed %v, got %v", mode, expected, result.Changed)
+		}
+		for dir := range expected {
+			if !result.Changed[dir] {
+				t.Errorf("%s: %s should be reported as changed", mode, dir)
+			}
+		}
+		if len(visited) != len(newTree) {
+			t.Errorf("%s: expected %d visited directories, got %d", mode, len(newTree), len(visited))
+		}
+		if len(result.Removed) != 2 || result.Removed["0002"] == nil || result.Removed["0004"] == nil {
+			t.Errorf("%s: expected removed 0002 and 0004, got %v", mode, result.Removed)
+		}
+		if len(result.OldSizes) != len(oldTree) {
+			t.Errorf("%s: expected %d old sizes, got %d", mode, len(oldTree), len(result.OldSizes))
+		}
+	}
+
+	// null表示空文件树，所有目录都是新增的
+	decoder, err := NewFileTreeDecoder(strings.NewReader("null"))
+	if err != nil {
+		t.Fatalf("NewFileTreeDecoder failed: %v", err)
+	}
+	result, err := s.CompareStream(decoder, CompareMtimeSize, nil)
+	if err != nil || len(result.Changed) != len(newTree) {
+		t.Errorf("Empty old tree should mark all directories as changed, got %v (%v)", result, err)
+	}
+
+	// 目录名未排序时无法合并比较
+	decoder, err = NewFileTreeDecoder(strings.NewReader(`{"0001":{"name":"0001","is_dir":true},"0000":{"name":"0000","is_dir":true}}`))
+	if err != nil {
+		t.Fatalf("NewFileTreeDecoder failed: %v", err)
+	}
+	if _, err := s.CompareStream(decoder, CompareMtimeSize, nil); !errors.Is(err, ErrUnsortedFileTree) {
+		t.Errorf("Expected ErrUnsortedFileTree, got %v", err)
+	}
+}
+
+// TestHexLess 测试按十六进制前缀排序：不区分大小写，同一前缀保持相邻，结果与输入顺序无关
+func TestHexLess(t *testing.T) {
+	names := []string{"00FF", "zz", "0010", "0000-00ff.tar.gz", "00a0", "0000abcd", "0000", "00", "00A0", "0100-01ff.tar"}
+	SortHex(names)
+	expected := "00,0000,0000-00ff.tar.gz,0000abcd,0010,00A0,00a0,00FF,0100-01ff.tar,zz"
+	if got := strings.Join(names, ","); got != expected {
+		t.Errorf("排序不正确:\n期望: %s\n实际: %s", expected, got)
+	}
+
+	for i := range names {
+		for j := range names {
+			if i != j && HexLess(names[i], names[j]) == HexLess(names[j], names[i]) {
+				t.Errorf("%s与%s的顺序不确定", names[i], names[j])
+			}
+		}
+	}
+}
+
+// TestExcludePatterns 测试默认排除模式只匹配PBS的临时文件和损坏的chunk
+func TestExcludePatterns(t *testing.T) {
+	cases := map[string]bool{
+		"0000abcd.tmp":        true,
+		"0000abcd.tmp_Xy12Ab": true,
+		"0000abcd.0.bad":      true,
+		"0000abcd":            false,
+		"tmp":                 false,
+		"0000abcd.bad.keep":   false,
+	}
+	for name, want := range cases {
+		if got := MatchExclude(DefaultExcludePatterns, name); got != want {
+			t.Errorf("MatchExclude(%q) = %v, 期望 %v", name, got, want)
+		}
+	}
+
+	if err := ValidateExcludePatterns([]string{"*.tmp", "[a-"}); err == nil {
+		t.Error("语法错误的模式应该返回错误")
+	}
+
+	// 以/结尾的模式只匹配目录名
+	patterns := []string{"*.tmp", "cache*/"}
+	if MatchExclude(patterns, "cache1") || !MatchExcludeDir(patterns, "cache1") || MatchExcludeDir(patterns, "a.tmp") {
+		t.Error("目录模式与文件模式应分别匹配")
+	}
+}
+
+// TestReadExcludeFile 测试从文件读取排除模式：忽略注释和空行，语法错误时报告行号
+func TestReadExcludeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "exclude.txt")
+	content := "# PBS临时文件\n*.tmp\n\n  *.partial  \r\nlost+found/\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := ReadExcludeFile(path)
+	if err != nil {
+		t.Fatalf("读取排除文件失败: %v", err)
+	}
+	if strings.Join(patterns, ",") != "*.tmp,*.partial,lost+found/" {
+		t.Errorf("排除模式不正确: %q", patterns)
+	}
+
+	if err := os.WriteFile(path, []byte("*.tmp\n[a-\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadExcludeFile(path); err == nil || !strings.Contains(err.Error(), "exclude.txt:2") {
+		t.Errorf("语法错误应报告行号: %v", err)
+	}
+	if _, err := ReadExcludeFile(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("文件不存在时应该返回错误")
+	}
+}
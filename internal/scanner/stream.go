@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"pbs-backuper/internal/models"
+)
+
+// ErrUnsortedFileTree 序列化的文件树中目录名不是按字典序排列，无法与扫描结果逐个合并比较。
+// encoding/json序列化map时总是按键排序，本工具生成的文件树不会出现这种情况
+var ErrUnsortedFileTree = errors.New("file tree entries are not sorted")
+
+// FileTreeDecoder 从JSON流中逐个读取文件树的chunk目录，同一时刻只在内存中保留一个目录的节点
+type FileTreeDecoder struct {
+	decoder *json.Decoder
+	last    string
+	started bool
+	done    bool
+}
+
+// NewFileTreeDecoder 创建文件树解码器，r的内容是以chunk目录名为键的JSON对象（或null）
+func NewFileTreeDecoder(r io.Reader) (*FileTreeDecoder, error) {
+	return FileTreeDecoderFrom(json.NewDecoder(r))
+}
+
+// FileTreeDecoderFrom 从decoder的当前位置读取文件树，用于文件树嵌在更大的JSON对象中（如元数据的file_tree字段）。
+// 读完文件树（Next返回io.EOF）后decoder停在文件树之后，调用方可以继续读取其余字段
+func FileTreeDecoderFrom(decoder *json.Decoder) (*FileTreeDecoder, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file tree: %w", err)
+	}
+
+	d := &FileTreeDecoder{decoder: decoder}
+	switch token {
+	case json.Delim('{'):
+	case nil:
+		d.done = true
+	default:
+		return nil, fmt.Errorf("failed to read file tree: unexpected token %v", token)
+	}
+	return d, nil
+}
+
+// Next 返回下一个chunk目录的名称和节点，读完时返回io.EOF。
+// 目录名必须严格递增，否则返回ErrUnsortedFileTree
+func (d *FileTreeDecoder) Next() (string, *models.FileTreeNode, error) {
+	if d.done {
+		return "", nil, io.EOF
+	}
+	if !d.decoder.More() {
+		if _, err := d.decoder.Token(); err != nil {
+			return "", nil, fmt.Errorf("failed to read file tree: %w", err)
+		}
+		d.done = true
+		return "", nil, io.EOF
+	}
+
+	token, err := d.decoder.Token()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read file tree: %w", err)
+	}
+	name, ok := token.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("failed to read file tree: unexpected token %v", token)
+	}
+	if d.started && name <= d.last {
+		return "", nil, fmt.Errorf("%w: %s after %s", ErrUnsortedFileTree, name, d.last)
+	}
+	d.started = true
+	d.last = name
+
+	var node models.FileTreeNode
+	if err := d.decoder.Decode(&node); err != nil {
+		return "", nil, fmt.Errorf("failed to read file tree entry %s: %w", name, err)
+	}
+	return name, &node, nil
+}
+
+// StreamComparison 流式比较的结果
+type StreamComparison struct {
+	Changed  map[string]bool                 // 新增、修改或删除的目录
+	Removed  map[string]*models.FileTreeNode // 旧文件树中有、本次扫描没有的目录节点（包括被过滤的目录）
+	OldSizes map[string]int64                // 旧文件树中每个目录的大小，用于统计和大小变化报告
+}
+
+// CompareStream 边扫描边与旧文件树比较。旧文件树从old逐个读取，当前文件树按目录逐个扫描，
+// 两边都按目录名排序后合并比较，结果与CompareFileTreesWithMode一致，但不需要同时在内存中保留两棵完整的树。
+// 每个扫描到的目录都会交给visit（可为nil），调用方决定是否保留当前文件树
+func (s *ChunkScanner) CompareStream(old *FileTreeDecoder, mode CompareMode, visit func(name string, node *models.FileTreeNode)) (*StreamComparison, error) {
+	result := &StreamComparison{
+		Changed:  make(map[string]bool),
+		Removed:  make(map[string]*models.FileTreeNode),
+		OldSizes: make(map[string]int64),
+	}
+
+	oldName, oldNode, err := old.Next()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	oldDone := err == io.EOF
+
+	// advance 记录当前的旧目录并读取下一个
+	advance := func() error {
+		result.OldSizes[oldName] = oldNode.Size
+		oldName, oldNode, err = old.Next()
+		if err == io.EOF {
+			oldDone = true
+			return nil
+		}
+		return err
+	}
+
+	err = s.WalkFileTree(func(name string, node *models.FileTreeNode) error {
+		if visit != nil {
+			visit(name, node)
+		}
+
+		// 排在当前目录之前的旧目录在本次扫描中不存在
+		for !oldDone && oldName < name {
+			result.Changed[oldName] = true
+			result.Removed[oldName] = oldNode
+			if err := advance(); err != nil {
+				return err
+			}
+		}
+
+		if oldDone || oldName != name {
+			result.Changed[name] = true // 新增目录
+			return nil
+		}
+		if hasTreeChanged(oldNode, node, mode) {
+			result.Changed[name] = true
+		}
+		return advance()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for !oldDone {
+		result.Changed[oldName] = true
+		result.Removed[oldName] = oldNode
+		if err := advance(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
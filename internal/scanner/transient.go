@@ -0,0 +1,51 @@
+package scanner
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// 启用ContinueOnError时扫描单个chunk目录的重试次数和间隔（第n次重试前等待n倍间隔），测试时可以修改
+var (
+	scanAttempts   = 3
+	scanRetryDelay = 2 * time.Second
+)
+
+// transientErrors 网络挂载（NFS、CIFS、FUSE等）短暂中断时常见的错误，重试可能成功
+var transientErrors = []error{
+	syscall.EIO,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+	syscall.EAGAIN,
+	syscall.EINTR,
+	syscall.ENOTCONN,
+	syscall.ECONNRESET,
+	syscall.EHOSTDOWN,
+	syscall.EHOSTUNREACH,
+}
+
+// IsTransientError 判断扫描错误是否可能是挂载暂时不可用造成的。
+// 路径不存在（目录被删除或chunk目录配置错误）不是暂时性错误，重试没有意义
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	for _, transient := range transientErrors {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTransient 启用ContinueOnError时对暂时性错误最多尝试scanAttempts次，其他错误立即返回
+func (s *ChunkScanner) retryTransient(fn func() error) error {
+	err := fn()
+	for attempt := 1; s.options.ContinueOnError && attempt < scanAttempts && IsTransientError(err); attempt++ {
+		time.Sleep(time.Duration(attempt) * scanRetryDelay)
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// localPartialSuffix 上传过程中使用的临时文件后缀，写完后重命名为目标文件
+const localPartialSuffix = ".partial"
+
+// LocalStorage 本地文件系统存储实现，远程路径就是本地路径（如挂载的NAS或移动硬盘），不依赖rclone
+type LocalStorage struct {
+	catMaxSize int64 // GetFileContent读取的文件大小上限，0表示不限制
+}
+
+func init() {
+	Register("local", func(opts Options) (Storage, error) {
+		store := NewLocalStorage()
+		store.catMaxSize = opts.CatMaxSize
+		return store, nil
+	})
+}
+
+// NewLocalStorage 创建本地文件系统存储实例
+func NewLocalStorage() *LocalStorage {
+	return &LocalStorage{}
+}
+
+// ListFiles 实现Storage接口 - 列出目录中的文件，目录不存在时返回空列表
+func (l *LocalStorage) ListFiles(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	entries, err := os.ReadDir(remotePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []FileInfo{}, nil
+		}
+		return nil, fmt.Errorf("failed to list files in %s: %w", remotePath, err)
+	}
+
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // 列出后被删除的文件
+		}
+		files = append(files, FileInfo{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   entry.IsDir(),
+		})
+	}
+	return files, nil
+}
+
+// DownloadFile 实现Storage接口 - 复制文件到本地路径
+func (l *LocalStorage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	if err := copyLocalFile(ctx, remotePath, localPath); err != nil {
+		return fmt.Errorf("failed to download file %s to %s: %w", remotePath, localPath, err)
+	}
+	return nil
+}
+
+// DownloadFileFrom 实现ResumableDownloader接口 - 从offset处续传
+func (l *LocalStorage) DownloadFileFrom(ctx context.Context, remotePath, localPath string, offset int64) error {
+	src, err := os.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
+	}
+	defer src.Close()
+
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek remote file %s: %w", remotePath, err)
+	}
+	if err := appendToFile(localPath, &contextReader{ctx: ctx, r: src}); err != nil {
+		return fmt.Errorf("failed to resume download of %s to %s: %w", remotePath, localPath, err)
+	}
+	return nil
+}
+
+// ReadRange 实现RangeReader接口 - 读取文件的一段内容
+func (l *LocalStorage) ReadRange(ctx context.Context, remotePath string, offset, length int64) ([]byte, error) {
+	src, err := os.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
+	}
+	defer src.Close()
+
+	data := make([]byte, length)
+	if _, err := src.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("failed to read %d bytes at offset %d of %s: %w", length, offset, remotePath, err)
+	}
+	return data, nil
+}
+
+// UploadFile 实现Storage接口 - 上传文件。
+// 先写入临时文件再重命名，读取方不会看到写了一半的文件
+func (l *LocalStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	partialPath := remotePath + localPartialSuffix
+	if err := copyLocalFile(ctx, localPath, partialPath); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to upload file %s to %s: %w", localPath, remotePath, err)
+	}
+	if err := os.Rename(partialPath, remotePath); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to upload file %s to %s: %w", localPath, remotePath, err)
+	}
+	return nil
+}
+
+// FileExists 实现Storage接口 - 检查文件是否存在
+func (l *LocalStorage) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	if _, err := os.Stat(remotePath); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check file existence: %w", err)
+	}
+	return true, nil
+}
+
+// GetFileContent 实现Storage接口 - 获取文件内容
+func (l *LocalStorage) GetFileContent(ctx context.Context, remotePath string) ([]byte, error) {
+	file, err := os.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file content: %w", err)
+	}
+	defer file.Close()
+
+	content, err := readLimited(&contextReader{ctx: ctx, r: file}, l.catMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file content of %s: %w", remotePath, err)
+	}
+	return content, nil
+}
+
+// MkdirRemote 实现Storage接口 - 创建目录
+func (l *LocalStorage) MkdirRemote(ctx context.Context, remotePath string) error {
+	if err := os.MkdirAll(remotePath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// DeleteFile 实现Storage接口 - 删除文件
+func (l *LocalStorage) DeleteFile(ctx context.Context, remotePath string) error {
+	if err := os.Remove(remotePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// CopyRemote 实现Storage接口 - 复制文件或目录（目录递归复制）
+func (l *LocalStorage) CopyRemote(ctx context.Context, srcPath, dstPath string) error {
+	return filepath.Walk(srcPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+		if err := copyLocalFile(ctx, path, filepath.Join(dstPath, rel)); err != nil {
+			return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
+		}
+		return nil
+	})
+}
+
+// MoveRemote 实现Storage接口 - 重命名文件，覆盖已存在的目标
+func (l *LocalStorage) MoveRemote(ctx context.Context, srcPath, dstPath string) error {
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dstPath, err)
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", srcPath, dstPath, err)
+	}
+	return nil
+}
+
+// Capabilities 实现Storage接口。复制在同一文件系统内完成，不经过临时目录；
+// 上传先写入.partial再重命名，是原子的；本地文件系统没有对象大小上限
+func (l *LocalStorage) Capabilities() Capabilities {
+	return Capabilities{Copy: true, ServerSideCopy: true, Move: true, AtomicUpload: true, ResumableDownload: true}
+}
+
+// copyLocalFile 复制文件，按需创建目标目录
+func copyLocalFile(ctx context.Context, src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dstFile, &contextReader{ctx: ctx, r: srcFile}); err != nil {
+		dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLocalStorage 测试本地文件系统后端的基本操作和能力
+func TestLocalStorage(t *testing.T) {
+	store, err := New("local", Options{})
+	if err != nil {
+		t.Fatalf("创建local后端失败: %v", err)
+	}
+	local, ok := store.(*LocalStorage)
+	if !ok {
+		t.Fatalf("local后端类型不正确: %T", store)
+	}
+	caps := local.Capabilities()
+	if !caps.Copy || !caps.ServerSideCopy || !caps.Move || !caps.AtomicUpload || !caps.ResumableDownload || caps.MaxObjectSize != 0 {
+		t.Errorf("local后端能力不正确: %+v", caps)
+	}
+
+	ctx := context.Background()
+	localDir := t.TempDir()
+	remoteDir := filepath.Join(t.TempDir(), "backup")
+	localFile := filepath.Join(localDir, "0000-00ff.tar.gz")
+	if err := os.WriteFile(localFile, []byte("archive content"), 0644); err != nil {
+		t.Fatalf("创建本地文件失败: %v", err)
+	}
+
+	remoteFile := filepath.Join(remoteDir, "chunk", "0000-00ff.tar.gz")
+	for i := 0; i < 2; i++ {
+		// 第二次上传覆盖已存在的文件
+		if err := local.UploadFile(ctx, localFile, remoteFile); err != nil {
+			t.Fatalf("第%d次UploadFile失败: %v", i+1, err)
+		}
+	}
+	if exists, err := local.FileExists(ctx, remoteFile); err != nil || !exists {
+		t.Fatalf("上传后文件应该存在: %v", err)
+	}
+	if exists, _ := local.FileExists(ctx, remoteFile+localPartialSuffix); exists {
+		t.Error("上传完成后不应该残留临时文件")
+	}
+
+	files, err := local.ListFiles(ctx, filepath.Join(remoteDir, "chunk"))
+	if err != nil || len(files) != 1 || files[0].Name != "0000-00ff.tar.gz" || files[0].Size != int64(len("archive content")) {
+		t.Errorf("ListFiles结果不正确: %+v, %v", files, err)
+	}
+	if files, err := local.ListFiles(ctx, filepath.Join(remoteDir, "missing")); err != nil || len(files) != 0 {
+		t.Errorf("不存在的目录应返回空列表: %+v, %v", files, err)
+	}
+
+	downloaded := filepath.Join(localDir, "download", "archive.tar.gz")
+	if err := local.DownloadFile(ctx, remoteFile, downloaded); err != nil {
+		t.Fatalf("DownloadFile失败: %v", err)
+	}
+	if err := os.Truncate(downloaded, 7); err != nil {
+		t.Fatalf("截断文件失败: %v", err)
+	}
+	if err := local.DownloadFileFrom(ctx, remoteFile, downloaded, 7); err != nil {
+		t.Fatalf("DownloadFileFrom失败: %v", err)
+	}
+	if data, _ := os.ReadFile(downloaded); string(data) != "archive content" {
+		t.Errorf("续传内容不正确: %q", data)
+	}
+	if data, err := local.ReadRange(ctx, remoteFile, 8, 7); err != nil || string(data) != "content" {
+		t.Errorf("ReadRange结果不正确: %q, %v", data, err)
+	}
+
+	local.catMaxSize = 4
+	if _, err := local.GetFileContent(ctx, remoteFile); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("超过读取上限时应返回ErrFileTooLarge: %v", err)
+	}
+	local.catMaxSize = 0
+
+	copyDir := filepath.Join(filepath.Dir(remoteDir), "copy")
+	if err := local.CopyRemote(ctx, remoteDir, copyDir); err != nil {
+		t.Fatalf("CopyRemote失败: %v", err)
+	}
+	moved := filepath.Join(copyDir, "moved.tar.gz")
+	if err := local.MoveRemote(ctx, filepath.Join(copyDir, "chunk", "0000-00ff.tar.gz"), moved); err != nil {
+		t.Fatalf("MoveRemote失败: %v", err)
+	}
+	if content, err := local.GetFileContent(ctx, moved); err != nil || string(content) != "archive content" {
+		t.Errorf("复制并移动后的内容不正确: %q, %v", content, err)
+	}
+
+	if err := local.DeleteFile(ctx, remoteFile); err != nil {
+		t.Fatalf("DeleteFile失败: %v", err)
+	}
+	if err := local.DeleteFile(ctx, remoteFile); err != nil {
+		t.Errorf("删除不存在的文件不应该报错: %v", err)
+	}
+}
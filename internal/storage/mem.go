@@ -0,0 +1,433 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemStorage 纯内存的存储实现，用于测试。与MockStorage不同，文件内容保存在map中，
+// 不写入远程目录；可以注入延迟和错误，用于确定性地测试重试和并发代码
+type MemStorage struct {
+	mu      sync.Mutex
+	files   map[string]memFile
+	dirs    map[string]bool
+	latency time.Duration
+	failure func(op, remotePath string) error
+
+	calls         map[string]int
+	active        int
+	maxConcurrent int
+}
+
+// memFile 内存中的文件
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemStorage 创建内存存储实例
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		files: make(map[string]memFile),
+		dirs:  map[string]bool{"/": true},
+		calls: make(map[string]int),
+	}
+}
+
+// SetLatency 设置每次操作的延迟，延迟期间上下文取消时操作返回ctx.Err()
+func (m *MemStorage) SetLatency(latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency = latency
+}
+
+// SetFailure 设置错误注入函数。每次操作前以操作名（Storage接口的方法名，如"UploadFile"）
+// 和远程路径调用，返回非nil时操作不执行并返回该错误。传入nil取消注入
+func (m *MemStorage) SetFailure(failure func(op, remotePath string) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failure = failure
+}
+
+// Calls 返回指定操作被调用的次数（包括注入错误的调用）
+func (m *MemStorage) Calls(op string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[op]
+}
+
+// MaxConcurrent 返回同时进行的操作数的最大值，配合SetLatency检查并发控制
+func (m *MemStorage) MaxConcurrent() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.maxConcurrent
+}
+
+// Put 直接写入远程文件，不经过延迟和错误注入
+func (m *MemStorage) Put(remotePath string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(memPath(remotePath), data)
+}
+
+// Get 直接读取远程文件，不经过延迟和错误注入
+func (m *MemStorage) Get(remotePath string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, ok := m.files[memPath(remotePath)]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), file.data...), true
+}
+
+// Paths 返回所有远程文件的路径（以/开头），按名称排序
+func (m *MemStorage) Paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.files))
+	for p := range m.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ListFiles 实现Storage接口 - 列出文件，目录不存在时返回空列表
+func (m *MemStorage) ListFiles(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	done, err := m.begin(ctx, "ListFiles", remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	dir := memPath(remotePath)
+	entries := make(map[string]FileInfo)
+	for p, file := range m.files {
+		if name, ok := memChild(dir, p); ok {
+			if strings.Contains(name, "/") {
+				name = name[:strings.Index(name, "/")]
+				entries[name] = FileInfo{Name: name, IsDir: true}
+			} else {
+				entries[name] = FileInfo{Name: name, Size: int64(len(file.data)), ModTime: file.modTime}
+			}
+		}
+	}
+	for p := range m.dirs {
+		if name, ok := memChild(dir, p); ok && !strings.Contains(name, "/") {
+			entries[name] = FileInfo{Name: name, IsDir: true}
+		}
+	}
+
+	files := make([]FileInfo, 0, len(entries))
+	for _, info := range entries {
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// ListFilesWithHashes 实现HashLister接口 - 按文件内容计算CRC32C和MD5，模拟对象存储记录的哈希
+func (m *MemStorage) ListFilesWithHashes(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	files, err := m.ListFiles(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range files {
+		if files[i].IsDir {
+			continue
+		}
+		file, err := m.fileLocked(path.Join(memPath(remotePath), files[i].Name))
+		if err != nil {
+			continue
+		}
+		crc := crc32.Checksum(file.data, crc32.MakeTable(crc32.Castagnoli))
+		sum := md5.Sum(file.data)
+		files[i].Hashes = map[string]string{
+			HashCRC32C: fmt.Sprintf("%08x", crc),
+			HashMD5:    hex.EncodeToString(sum[:]),
+		}
+	}
+	return files, nil
+}
+
+// DownloadFile 实现Storage接口 - 下载文件
+func (m *MemStorage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	return m.DownloadFileFrom(ctx, remotePath, localPath, -1)
+}
+
+// DownloadFileFrom 实现ResumableDownloader接口 - 从offset处续传。
+// offset为-1时由DownloadFile调用，覆盖本地文件
+func (m *MemStorage) DownloadFileFrom(ctx context.Context, remotePath, localPath string, offset int64) error {
+	op := "DownloadFileFrom"
+	if offset < 0 {
+		op = "DownloadFile"
+	}
+	done, err := m.begin(ctx, op, remotePath)
+	if err != nil {
+		return err
+	}
+	file, err := m.file(remotePath)
+	done()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	if offset < 0 {
+		return os.WriteFile(localPath, file.data, 0644)
+	}
+	if offset > int64(len(file.data)) {
+		return fmt.Errorf("offset %d beyond size %d of %s", offset, len(file.data), remotePath)
+	}
+	return appendToFile(localPath, strings.NewReader(string(file.data[offset:])))
+}
+
+// ReadRange 实现RangeReader接口 - 读取文件的一段内容
+func (m *MemStorage) ReadRange(ctx context.Context, remotePath string, offset, length int64) ([]byte, error) {
+	done, err := m.begin(ctx, "ReadRange", remotePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := m.file(remotePath)
+	done()
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || length < 0 || offset+length > int64(len(file.data)) {
+		return nil, fmt.Errorf("range %d+%d beyond size %d of %s", offset, length, len(file.data), remotePath)
+	}
+	return append([]byte(nil), file.data[offset:offset+length]...), nil
+}
+
+// UploadFile 实现Storage接口 - 上传文件
+func (m *MemStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	done, err := m.begin(ctx, "UploadFile", remotePath)
+	if err != nil {
+		return err
+	}
+	defer done()
+	m.put(memPath(remotePath), data)
+	return nil
+}
+
+// FileExists 实现Storage接口 - 检查文件或目录是否存在
+func (m *MemStorage) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	done, err := m.begin(ctx, "FileExists", remotePath)
+	if err != nil {
+		return false, err
+	}
+	defer done()
+
+	p := memPath(remotePath)
+	if _, ok := m.files[p]; ok || m.dirs[p] {
+		return true, nil
+	}
+	for other := range m.files {
+		if _, ok := memChild(p, other); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetFileContent 实现Storage接口 - 获取文件内容
+func (m *MemStorage) GetFileContent(ctx context.Context, remotePath string) ([]byte, error) {
+	done, err := m.begin(ctx, "GetFileContent", remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	file, err := m.fileLocked(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+// MkdirRemote 实现Storage接口 - 创建远程目录
+func (m *MemStorage) MkdirRemote(ctx context.Context, remotePath string) error {
+	done, err := m.begin(ctx, "MkdirRemote", remotePath)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	for p := memPath(remotePath); !m.dirs[p]; p = path.Dir(p) {
+		m.dirs[p] = true
+	}
+	return nil
+}
+
+// DeleteFile 实现Storage接口 - 删除文件
+func (m *MemStorage) DeleteFile(ctx context.Context, remotePath string) error {
+	done, err := m.begin(ctx, "DeleteFile", remotePath)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	delete(m.files, memPath(remotePath))
+	return nil
+}
+
+// CopyRemote 实现Storage接口 - 复制文件或目录
+func (m *MemStorage) CopyRemote(ctx context.Context, srcPath, dstPath string) error {
+	done, err := m.begin(ctx, "CopyRemote", srcPath)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	src, dst := memPath(srcPath), memPath(dstPath)
+	if file, ok := m.files[src]; ok {
+		m.put(dst, file.data)
+		return nil
+	}
+	copied := false
+	for p, file := range m.files {
+		if rel, ok := memChild(src, p); ok {
+			m.put(path.Join(dst, rel), file.data)
+			copied = true
+		}
+	}
+	if !copied && !m.dirs[src] {
+		return memNotExist("copy", srcPath)
+	}
+	return nil
+}
+
+// MoveRemote 实现Storage接口 - 移动文件
+func (m *MemStorage) MoveRemote(ctx context.Context, srcPath, dstPath string) error {
+	done, err := m.begin(ctx, "MoveRemote", srcPath)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	file, err := m.fileLocked(srcPath)
+	if err != nil {
+		return err
+	}
+	delete(m.files, memPath(srcPath))
+	m.put(memPath(dstPath), file.data)
+	return nil
+}
+
+// Capabilities 实现Storage接口 - 支持所有能力，上传在锁内一次写入，是原子的
+func (m *MemStorage) Capabilities() Capabilities {
+	return Capabilities{Copy: true, ServerSideCopy: true, Move: true, ResumableDownload: true, AtomicUpload: true}
+}
+
+// begin 开始一次操作：记录调用次数和并发数，等待注入的延迟，再检查注入的错误。
+// 成功时返回持有锁的done，调用方在访问文件后调用done释放锁并结束操作
+func (m *MemStorage) begin(ctx context.Context, op, remotePath string) (func(), error) {
+	m.mu.Lock()
+	m.calls[op]++
+	m.active++
+	m.maxConcurrent = max(m.maxConcurrent, m.active)
+	latency, failure := m.latency, m.failure
+	m.mu.Unlock()
+
+	end := func() {
+		m.active--
+		m.mu.Unlock()
+	}
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			m.mu.Lock()
+			end()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		m.mu.Lock()
+		end()
+		return nil, err
+	}
+	if failure != nil {
+		if err := failure(op, remotePath); err != nil {
+			m.mu.Lock()
+			end()
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	return end, nil
+}
+
+// file 返回文件内容的副本，释放锁后仍可使用（例如写入本地文件）。调用方持有锁
+func (m *MemStorage) file(remotePath string) (memFile, error) {
+	file, err := m.fileLocked(remotePath)
+	if err != nil {
+		return memFile{}, err
+	}
+	return memFile{data: append([]byte(nil), file.data...), modTime: file.modTime}, nil
+}
+
+// fileLocked 查找文件，调用方持有锁
+func (m *MemStorage) fileLocked(remotePath string) (memFile, error) {
+	file, ok := m.files[memPath(remotePath)]
+	if !ok {
+		return memFile{}, memNotExist("open", remotePath)
+	}
+	return file, nil
+}
+
+// put 写入文件并创建上级目录，调用方持有锁
+func (m *MemStorage) put(p string, data []byte) {
+	m.files[p] = memFile{data: append([]byte(nil), data...), modTime: time.Now()}
+	for dir := path.Dir(p); !m.dirs[dir]; dir = path.Dir(dir) {
+		m.dirs[dir] = true
+	}
+}
+
+// memPath 把远程路径规范化为以/开头的斜杠路径，"a/b"与"/a/b"指向同一个文件
+func memPath(remotePath string) string {
+	return path.Clean("/" + filepath.ToSlash(remotePath))
+}
+
+// memChild 返回p相对于目录dir的路径，p不在dir下时返回false
+func memChild(dir, p string) (string, bool) {
+	prefix := dir
+	if prefix != "/" {
+		prefix += "/"
+	}
+	if p == dir || !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	return p[len(prefix):], true
+}
+
+// memNotExist 返回与os.ErrNotExist匹配的错误
+func memNotExist(op, remotePath string) error {
+	return &fs.PathError{Op: op, Path: remotePath, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMemStorage 测试内存存储的基本操作、延迟和错误注入
+func TestMemStorage(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	m := NewMemStorage()
+
+	localFile := filepath.Join(localDir, "a.txt")
+	os.WriteFile(localFile, []byte("hello"), 0644)
+	if err := m.UploadFile(ctx, localFile, "/backup/chunk/a.txt"); err != nil {
+		t.Fatalf("上传失败: %v", err)
+	}
+	if err := m.MkdirRemote(ctx, "/backup/empty"); err != nil {
+		t.Fatalf("创建目录失败: %v", err)
+	}
+
+	files, err := m.ListFiles(ctx, "/backup")
+	if err != nil || len(files) != 2 || files[0].Name != "chunk" || !files[0].IsDir || files[1].Name != "empty" {
+		t.Fatalf("列出目录不正确: %v %+v", err, files)
+	}
+	if files, _ := m.ListFiles(ctx, "backup/chunk"); len(files) != 1 || files[0].Size != 5 || files[0].IsDir {
+		t.Errorf("相对路径应指向同一目录: %+v", files)
+	}
+	if files, err := m.ListFiles(ctx, "/missing"); err != nil || len(files) != 0 {
+		t.Errorf("不存在的目录应返回空列表: %v %+v", err, files)
+	}
+	for path, want := range map[string]bool{"/backup/chunk/a.txt": true, "/backup/chunk": true, "/backup/empty": true, "/backup/b.txt": false} {
+		if exists, _ := m.FileExists(ctx, path); exists != want {
+			t.Errorf("FileExists(%s) = %v，期望 %v", path, exists, want)
+		}
+	}
+
+	// 下载与续传
+	downloaded := filepath.Join(localDir, "out", "a.txt")
+	if err := m.DownloadFile(ctx, "/backup/chunk/a.txt", downloaded); err != nil {
+		t.Fatalf("下载失败: %v", err)
+	}
+	os.WriteFile(downloaded, []byte("he"), 0644)
+	if err := m.DownloadFileFrom(ctx, "/backup/chunk/a.txt", downloaded, 2); err != nil {
+		t.Fatalf("续传失败: %v", err)
+	}
+	if data, _ := os.ReadFile(downloaded); string(data) != "hello" {
+		t.Errorf("续传后的内容不正确: %q", data)
+	}
+	if data, err := m.ReadRange(ctx, "/backup/chunk/a.txt", 1, 3); err != nil || string(data) != "ell" {
+		t.Errorf("ReadRange结果不正确: %q, %v", data, err)
+	}
+	if _, err := m.ReadRange(ctx, "/backup/chunk/a.txt", 3, 5); err == nil {
+		t.Error("读取范围超过文件末尾时应该返回错误")
+	}
+	if _, err := m.GetFileContent(ctx, "/backup/b.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("读取不存在的文件应返回ErrNotExist: %v", err)
+	}
+
+	// 复制目录、移动和删除
+	if err := m.CopyRemote(ctx, "/backup", "/copy"); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	if err := m.MoveRemote(ctx, "/copy/chunk/a.txt", "/copy/b.txt"); err != nil {
+		t.Fatalf("移动失败: %v", err)
+	}
+	if err := m.DeleteFile(ctx, "/backup/chunk/a.txt"); err != nil {
+		t.Fatalf("删除失败: %v", err)
+	}
+	if err := m.DeleteFile(ctx, "/backup/chunk/a.txt"); err != nil {
+		t.Errorf("删除不存在的文件不应报错: %v", err)
+	}
+	if paths := m.Paths(); len(paths) != 1 || paths[0] != "/copy/b.txt" {
+		t.Errorf("剩余文件不正确: %v", paths)
+	}
+
+	// 错误注入
+	injected := errors.New("injected")
+	m.SetFailure(func(op, remotePath string) error {
+		if op == "GetFileContent" && remotePath == "/copy/b.txt" {
+			return injected
+		}
+		return nil
+	})
+	if _, err := m.GetFileContent(ctx, "/copy/b.txt"); !errors.Is(err, injected) {
+		t.Errorf("应返回注入的错误: %v", err)
+	}
+	if exists, err := m.FileExists(ctx, "/copy/b.txt"); err != nil || !exists {
+		t.Errorf("其他操作不受影响: %v %v", exists, err)
+	}
+	m.SetFailure(nil)
+	if data, err := m.GetFileContent(ctx, "/copy/b.txt"); err != nil || string(data) != "hello" {
+		t.Errorf("取消注入后读取失败: %v %q", err, data)
+	}
+	if calls := m.Calls("GetFileContent"); calls != 3 {
+		t.Errorf("GetFileContent调用次数应为3，实际 %d", calls)
+	}
+
+	// 延迟期间的并发数与取消
+	m.SetLatency(20 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.FileExists(ctx, "/copy/b.txt")
+		}()
+	}
+	wg.Wait()
+	if got := m.MaxConcurrent(); got != 3 {
+		t.Errorf("最大并发数应为3，实际 %d", got)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := m.GetFileContent(cancelled, "/copy/b.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("上下文取消后应返回context.Canceled: %v", err)
+	}
+}
@@ -64,6 +64,35 @@ func (m *MockStorage) DownloadFile(ctx context.Context, remotePath, localPath st
 	return m.copyFile(srcPath, localPath)
 }
 
+// DownloadFileFrom 实现ResumableDownloader接口 - 从offset处续传
+func (m *MockStorage) DownloadFileFrom(ctx context.Context, remotePath, localPath string, offset int64) error {
+	src, err := os.Open(filepath.Join(m.remoteDir, remotePath))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	return appendToFile(localPath, src)
+}
+
+// ReadRange 实现RangeReader接口 - 读取文件的一段内容
+func (m *MockStorage) ReadRange(ctx context.Context, remotePath string, offset, length int64) ([]byte, error) {
+	src, err := os.Open(filepath.Join(m.remoteDir, remotePath))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	data := make([]byte, length)
+	if _, err := src.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // UploadFile 实现Storage接口 - 上传文件
 func (m *MockStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
 	dstPath := filepath.Join(m.remoteDir, remotePath)
@@ -96,6 +125,55 @@ func (m *MockStorage) GetFileContent(ctx context.Context, remotePath string) ([]
 	return os.ReadFile(fullPath)
 }
 
+// MkdirRemote 实现Storage接口 - 创建远程目录
+func (m *MockStorage) MkdirRemote(ctx context.Context, remotePath string) error {
+	fullPath := filepath.Join(m.remoteDir, remotePath)
+	return os.MkdirAll(fullPath, 0755)
+}
+
+// DeleteFile 实现Storage接口 - 删除文件
+func (m *MockStorage) DeleteFile(ctx context.Context, remotePath string) error {
+	fullPath := filepath.Join(m.remoteDir, remotePath)
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// CopyRemote 实现Storage接口 - 复制文件或目录
+func (m *MockStorage) CopyRemote(ctx context.Context, srcPath, dstPath string) error {
+	src := filepath.Join(m.remoteDir, srcPath)
+	dst := filepath.Join(m.remoteDir, dstPath)
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return m.copyFile(path, target)
+	})
+}
+
+// MoveRemote 实现Storage接口 - 移动文件
+func (m *MockStorage) MoveRemote(ctx context.Context, srcPath, dstPath string) error {
+	dst := filepath.Join(m.remoteDir, dstPath)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(m.remoteDir, srcPath), dst)
+}
+
+// Capabilities 实现Storage接口 - 支持复制、移动和续传；上传直接写入目标文件，不是原子的
+func (m *MockStorage) Capabilities() Capabilities {
+	return Capabilities{Copy: true, ServerSideCopy: true, Move: true, ResumableDownload: true}
+}
+
 // copyFile 复制文件的辅助函数
 func (m *MockStorage) copyFile(src, dst string) error {
 	srcFile, err := os.Open(src)
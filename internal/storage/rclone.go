@@ -4,35 +4,87 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
+
+	"pbs-backuper/internal/logger"
 )
 
 // RcloneStorage rclone存储实现
 type RcloneStorage struct {
-	binary     string   // rclone二进制路径
-	configFile string   // rclone配置文件路径
-	extraArgs  []string // 额外参数
-	verbose    bool     // 详细输出模式
+	binary        string   // rclone二进制路径
+	configFile    string   // rclone配置文件路径
+	extraArgs     []string // 额外参数
+	verbose       bool     // 详细输出模式（记录执行的rclone命令）
+	verboseRclone bool     // rclone自身的详细输出（-v及实时输出）
+	catMaxSize    int64    // GetFileContent读取的文件大小上限，0表示不限制
+	transfers     int      // 传输类命令的--transfers，0表示使用rclone默认值
+	checkers      int      // 传输类命令的--checkers，0表示使用rclone默认值
+	remotePath    string   // 备份的远程路径，用于识别远程类型
+	maxObject     int64    // DetectLimits识别出的单个对象大小上限，0表示未识别或不限制
+}
+
+func init() {
+	Register("rclone", func(opts Options) (Storage, error) {
+		store := NewRcloneStorage(opts.RcloneBinary, opts.RcloneConfig, opts.RcloneArgs, opts.Verbose, opts.VerboseRclone)
+		store.catMaxSize = opts.CatMaxSize
+		store.transfers = opts.Transfers
+		store.checkers = opts.Checkers
+		store.remotePath = opts.RemotePath
+		return store, nil
+	})
 }
 
 // NewRcloneStorage 创建rclone存储实例
-func NewRcloneStorage(binary, configFile string, extraArgs []string, verbose bool) *RcloneStorage {
+func NewRcloneStorage(binary, configFile string, extraArgs []string, verbose, verboseRclone bool) *RcloneStorage {
 	return &RcloneStorage{
-		binary:     binary,
-		configFile: configFile,
-		extraArgs:  extraArgs,
-		verbose:    verbose,
+		binary:        binary,
+		configFile:    configFile,
+		extraArgs:     extraArgs,
+		verbose:       verbose,
+		verboseRclone: verboseRclone,
 	}
 }
 
+// transferCommands 传输类rclone命令，这些命令附加并发参数和用户的全部额外参数
+var transferCommands = map[string]bool{
+	"copy":   true,
+	"copyto": true,
+	"move":   true,
+	"moveto": true,
+	"sync":   true,
+}
+
+// transferOnlyFlags 只对传输类命令有意义的rclone参数，值表示参数是否可以带单独的值（如--transfers 4）。
+// 这些参数传给cat/lsjson等命令时可能改变输出格式或直接报错，不在其中的额外参数（后端认证、配置等）传给所有命令
+var transferOnlyFlags = map[string]bool{
+	"--transfers":            true,
+	"--checkers":             true,
+	"--multi-thread-streams": true,
+	"--multi-thread-cutoff":  true,
+	"--stats":                true,
+	"--progress":             false,
+	"-P":                     false,
+	"--stats-one-line":       false,
+}
+
 // rcloneCommand 执行rclone命令的通用方法，分离标准输出和错误输出
 func (r *RcloneStorage) rcloneCommand(ctx context.Context, command string, args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	err := r.runRclone(ctx, &stdout, command, args...)
+	return stdout.Bytes(), err
+}
+
+// runRclone 执行rclone命令，标准输出写入stdout
+func (r *RcloneStorage) runRclone(ctx context.Context, stdout io.Writer, command string, args ...string) error {
 	// 构建基础命令参数
 	cmdArgs := []string{}
 
@@ -43,34 +95,42 @@ func (r *RcloneStorage) rcloneCommand(ctx context.Context, command string, args
 		cmdArgs = append(cmdArgs, "--config", r.configFile)
 	}
 
-	// 添加自定义参数
-	cmdArgs = append(cmdArgs, r.extraArgs...)
+	// 添加自定义参数，并发参数和传输调优参数只用于传输类命令
+	if transferCommands[command] {
+		cmdArgs = append(cmdArgs, r.parallelismArgs()...)
+		cmdArgs = append(cmdArgs, r.extraArgs...)
+	} else {
+		cmdArgs = append(cmdArgs, withoutTransferFlags(r.extraArgs)...)
+	}
 
 	// 添加命令特定参数
 	cmdArgs = append(cmdArgs, args...)
 
-	// 根据 verbose 模式和命令类型添加参数
-	if command == "cat" {
-		// cat 命令总是添加这些参数
-		cmdArgs = append(cmdArgs, "--quiet")
-		cmdArgs = append(cmdArgs, "--progress=false")
-	} else if !r.verbose {
-		// 非 verbose 模式下，其他命令添加这些参数
+	// 根据 verbose-rclone 模式和命令类型添加参数
+	liveOutput := r.verboseRclone && command != "cat"
+	if liveOutput {
+		cmdArgs = append(cmdArgs, "-v")
+	} else {
+		// cat 命令以及非 verbose-rclone 模式下保持 rclone 安静
 		cmdArgs = append(cmdArgs, "--quiet")
 		cmdArgs = append(cmdArgs, "--progress=false")
 	}
 
+	if r.verbose {
+		logger.Debug(fmt.Sprintf("Running: %s %s", r.binary, strings.Join(cmdArgs, " ")))
+	}
+
 	cmd := exec.CommandContext(ctx, r.binary, cmdArgs...)
 
-	var stdout, stderr bytes.Buffer
+	var stderr bytes.Buffer
 
-	if r.verbose && command != "cat" {
-		// verbose 模式下且非 cat 命令，实时输出到控制台
-		cmd.Stdout = io.MultiWriter(&stdout, os.Stdout)
+	if liveOutput {
+		// verbose-rclone 模式下且非 cat 命令，实时输出到控制台
+		cmd.Stdout = io.MultiWriter(stdout, os.Stdout)
 		cmd.Stderr = io.MultiWriter(&stderr, os.Stderr)
 	} else {
-		// 非 verbose 模式或 cat 命令，只捕获输出
-		cmd.Stdout = &stdout
+		// 非 verbose-rclone 模式或 cat 命令，只捕获输出
+		cmd.Stdout = stdout
 		cmd.Stderr = &stderr
 	}
 
@@ -78,10 +138,66 @@ func (r *RcloneStorage) rcloneCommand(ctx context.Context, command string, args
 
 	if err != nil {
 		// 使用我们捕获的stderr
-		return stdout.Bytes(), fmt.Errorf("rclone command failed: %w, stderr: %s", err, stderr.String())
+		if permissionPattern.Match(stderr.Bytes()) {
+			return fmt.Errorf("rclone command failed: %w: %w, stderr: %s", ErrPermissionDenied, err, stderr.String())
+		}
+		return fmt.Errorf("rclone command failed: %w, stderr: %s", err, stderr.String())
 	}
 
-	return stdout.Bytes(), nil
+	return nil
+}
+
+// parallelismArgs 返回传输类命令的--transfers/--checkers参数。
+// 用户已在额外参数中指定同名参数时以用户的为准，不再重复添加
+func (r *RcloneStorage) parallelismArgs() []string {
+	var args []string
+	if r.transfers > 0 && !hasFlag(r.extraArgs, "--transfers") {
+		args = append(args, "--transfers", strconv.Itoa(r.transfers))
+	}
+	if r.checkers > 0 && !hasFlag(r.extraArgs, "--checkers") {
+		args = append(args, "--checkers", strconv.Itoa(r.checkers))
+	}
+	return args
+}
+
+// withoutTransferFlags 去掉参数列表中的传输调优参数（包括以单独参数给出的值）
+func withoutTransferFlags(args []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		name, _, inline := strings.Cut(args[i], "=")
+		takesValue, transferOnly := transferOnlyFlags[name]
+		if !transferOnly {
+			kept = append(kept, args[i])
+			continue
+		}
+		if takesValue && !inline {
+			i++
+		}
+	}
+	return kept
+}
+
+// hasFlag 参数列表中是否包含指定的参数（--name或--name=value形式）
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionPattern 匹配rclone错误输出中权限不足的信号：本地后端和SFTP的permission denied、
+// S3的AccessDenied、GCS/Drive的Error 403与insufficient permissions、Azure的AuthorizationPermissionMismatch等。
+// 403只在状态码上下文中匹配，避免误匹配压缩包名中的数字
+var permissionPattern = regexp.MustCompile(`(?i)permission denied|access ?denied|forbidden|insufficient ?permissions?|authorizationpermissionmismatch|(status code|error|http)[: ]+403\b`)
+
+// permissionError 操作因权限不足失败时返回说明原因和处理方式的错误，否则返回nil
+func permissionError(operation, remotePath string, err error) error {
+	if !errors.Is(err, ErrPermissionDenied) {
+		return nil
+	}
+	return fmt.Errorf("remote rejected %s of %s: permission denied — check credentials/bucket policy: %w", operation, remotePath, err)
 }
 
 // ListFiles 实现Storage接口 - 列出文件
@@ -92,16 +208,63 @@ func (r *RcloneStorage) ListFiles(ctx context.Context, remotePath string) ([]Fil
 		return nil, fmt.Errorf("failed to list files: %w", err)
 	}
 
+	return parseLsjson(output)
+}
+
+// ListFilesWithHashes 实现HashLister接口 - 使用lsjson --hash读取服务端记录的CRC32C和MD5。
+// 对象存储直接返回上传时记录的值；不支持的后端（如crypt）不返回哈希
+func (r *RcloneStorage) ListFilesWithHashes(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	output, err := r.rcloneCommand(ctx, "lsjson", "--hash", "--hash-type", HashCRC32C, "--hash-type", HashMD5, "--files-only", remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("rclone lsjson --hash failed: %w", err)
+	}
+	return parseLsjson(output)
+}
+
+// parseLsjson 解析rclone lsjson的输出。rclone（或包装脚本）可能在JSON前后输出警告等非JSON行，
+// 出错时也可能输出错误对象而不是数组：从第一个能完整解析的JSON值开始读取并忽略其后的内容，
+// 遇到错误对象时返回其中的错误信息
+func parseLsjson(output []byte) ([]FileInfo, error) {
+	lines := strings.Split(string(output), "\n")
+	var data json.RawMessage
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "[") && !strings.HasPrefix(line, "{") {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(strings.Join(lines[i:], "\n")))
+		if err := decoder.Decode(&data); err == nil {
+			break
+		}
+		data = nil
+	}
+	if data == nil {
+		return nil, fmt.Errorf("rclone lsjson produced no JSON file list: %q", truncateOutput(output))
+	}
+
+	if data[0] == '{' {
+		var errorObject struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(data, &errorObject); err == nil && (errorObject.Error != "" || errorObject.Message != "") {
+			return nil, fmt.Errorf("rclone lsjson returned an error: %s", strings.TrimSpace(errorObject.Error+" "+errorObject.Message))
+		}
+		return nil, fmt.Errorf("rclone lsjson returned an object instead of a file list: %q", truncateOutput(data))
+	}
+
 	var jsonFiles []struct {
 		Path    string    `json:"Path"`
 		Name    string    `json:"Name"`
 		Size    int64     `json:"Size"`
 		ModTime time.Time `json:"ModTime"`
 		IsDir   bool      `json:"IsDir"`
+
+		Hashes map[string]string `json:"Hashes"`
 	}
 
-	if err := json.Unmarshal(output, &jsonFiles); err != nil {
-		return nil, fmt.Errorf("failed to parse rclone output: %w", err)
+	if err := json.Unmarshal(data, &jsonFiles); err != nil {
+		return nil, fmt.Errorf("failed to parse rclone output: %w (output: %q)", err, truncateOutput(data))
 	}
 
 	files := make([]FileInfo, len(jsonFiles))
@@ -112,30 +275,178 @@ func (r *RcloneStorage) ListFiles(ctx context.Context, remotePath string) ([]Fil
 			ModTime: f.ModTime,
 			IsDir:   f.IsDir,
 		}
+		for hashType, value := range f.Hashes {
+			if value == "" {
+				continue
+			}
+			if files[i].Hashes == nil {
+				files[i].Hashes = make(map[string]string)
+			}
+			files[i].Hashes[strings.ToLower(hashType)] = strings.ToLower(value)
+		}
 	}
 
 	return files, nil
 }
 
+// truncateOutput 截断过长的命令输出，用于错误信息
+func truncateOutput(output []byte) string {
+	const limit = 200
+	output = bytes.TrimSpace(output)
+	if len(output) > limit {
+		return string(output[:limit]) + "..."
+	}
+	return string(output)
+}
+
 // DownloadFile 实现Storage接口 - 下载文件
 func (r *RcloneStorage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
-	_, err := r.rcloneCommand(ctx, "copyto", remotePath, filepath.Dir(localPath))
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory for %s: %w", localPath, err)
+	}
+
+	_, err := r.rcloneCommand(ctx, "copyto", remotePath, localPath)
 	if err != nil {
 		return fmt.Errorf("failed to download file %s to %s: %w", remotePath, localPath, err)
 	}
 	return nil
 }
 
+// DownloadFileFrom 实现ResumableDownloader接口 - 使用cat --offset从offset处续传
+func (r *RcloneStorage) DownloadFileFrom(ctx context.Context, remotePath, localPath string, offset int64) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory for %s: %w", localPath, err)
+	}
+	dst, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open local file %s: %w", localPath, err)
+	}
+
+	if err := r.runRclone(ctx, dst, "cat", "--offset", strconv.FormatInt(offset, 10), remotePath); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to resume download of %s to %s: %w", remotePath, localPath, err)
+	}
+	return dst.Close()
+}
+
+// ReadRange 实现RangeReader接口 - 使用cat --offset --count只读取一段内容，远程支持时按范围请求
+func (r *RcloneStorage) ReadRange(ctx context.Context, remotePath string, offset, length int64) ([]byte, error) {
+	output, err := r.rcloneCommand(ctx, "cat", "--offset", strconv.FormatInt(offset, 10), "--count", strconv.FormatInt(length, 10), remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %d bytes at offset %d of %s: %w", length, offset, remotePath, err)
+	}
+	if int64(len(output)) != length {
+		return nil, fmt.Errorf("short read of %s: expected %d bytes at offset %d, got %d", remotePath, length, offset, len(output))
+	}
+	return output, nil
+}
+
 // UploadFile 实现Storage接口 - 上传文件
 func (r *RcloneStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
 	_, err := r.rcloneCommand(ctx, "copyto", localPath, remotePath)
 	// fmt.Println("UploadFile", localPath, remotePath, err)
 	if err != nil {
+		if permErr := permissionError("upload", remotePath, err); permErr != nil {
+			return permErr
+		}
 		return fmt.Errorf("failed to upload file %s to %s: %w", localPath, remotePath, err)
 	}
 	return nil
 }
 
+// CopyRemote 实现Storage接口 - 使用copyto复制文件或目录。
+// 源和目标位于同一个支持服务端复制的远程时由服务端完成，否则rclone经本机中转
+func (r *RcloneStorage) CopyRemote(ctx context.Context, srcPath, dstPath string) error {
+	if _, err := r.rcloneCommand(ctx, "copyto", srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
+	}
+	return nil
+}
+
+// MoveRemote 实现Storage接口 - 使用moveto移动文件。
+// 远程支持时由服务端重命名，否则rclone先复制再删除源文件
+func (r *RcloneStorage) MoveRemote(ctx context.Context, srcPath, dstPath string) error {
+	if _, err := r.rcloneCommand(ctx, "moveto", srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", srcPath, dstPath, err)
+	}
+	return nil
+}
+
+// Capabilities 实现Storage接口。copyto/moveto对所有远程都可用，
+// 不支持服务端操作的远程由rclone直接转发数据，不经过本地磁盘。
+// 上传是否原子取决于远程类型（对象存储是原子的，部分文件系统类远程不是），按不是原子的处理。
+// 对象大小上限在调用DetectLimits之后才有值
+func (r *RcloneStorage) Capabilities() Capabilities {
+	return Capabilities{Copy: true, ServerSideCopy: true, Move: true, ResumableDownload: true, MaxObjectSize: r.maxObject}
+}
+
+// remoteObjectLimits 各远程类型的单个对象大小上限。rclone对S3、GCS等自动分片上传，
+// 上限是服务端允许的最大对象而不是单次PUT的上限。未列出的类型（包括local、sftp以及alias、crypt等包装类型）不检查
+var remoteObjectLimits = map[string]int64{
+	"s3":                   5 << 40,
+	"google cloud storage": 5 << 40,
+	"drive":                5 << 40,
+	"b2":                   10_000_000_000_000,
+	"dropbox":              350 << 30,
+	"onedrive":             250 << 30,
+}
+
+// DetectLimits 实现LimitDetector接口 - 识别备份远程路径的远程类型，之后Capabilities报告该类型的对象大小上限。
+// 配置文件中的远程需要执行rclone listremotes --long，失败时返回错误，上限保持为0（不检查）
+func (r *RcloneStorage) DetectLimits(ctx context.Context) error {
+	remoteType, name := parseRemoteName(r.remotePath)
+	if remoteType == "" && name != "" {
+		types, err := r.RemoteTypes(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to detect remote type of %s: %w", r.remotePath, err)
+		}
+		remoteType = types[name]
+	}
+	r.maxObject = remoteObjectLimits[remoteType]
+	return nil
+}
+
+// parseRemoteName 解析rclone路径开头的远程。连接字符串（如":s3,provider=Minio:bucket"）直接返回类型，
+// 配置文件中的远程（如"s3:bucket"）返回名称。本地路径（没有冒号或Windows盘符）两者都为空
+func parseRemoteName(remotePath string) (remoteType, name string) {
+	prefix, _, found := strings.Cut(remotePath, ":")
+	if !found {
+		return "", ""
+	}
+	prefix, _, _ = strings.Cut(prefix, ",")
+	if strings.HasPrefix(remotePath, ":") {
+		// 连接字符串":type,参数:路径"的类型在第二个冒号或第一个逗号之前
+		rest := remotePath[1:]
+		end := strings.IndexAny(rest, ",:")
+		if end < 0 {
+			return rest, ""
+		}
+		return rest[:end], ""
+	}
+	if len(prefix) == 1 || strings.ContainsAny(prefix, `/\`) {
+		return "", ""
+	}
+	return "", prefix
+}
+
+// RemoteTypes 执行rclone listremotes --long，返回配置文件中远程名称（不带冒号）到类型的映射
+func (r *RcloneStorage) RemoteTypes(ctx context.Context) (map[string]string, error) {
+	output, err := r.rcloneCommand(ctx, "listremotes", "--long")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remotes: %w", err)
+	}
+	types := make(map[string]string)
+	for _, line := range nonEmptyLines(output) {
+		// 名称可能包含空格，类型在最后一个冒号之后
+		idx := strings.LastIndex(line, ":")
+		if idx < 0 {
+			continue
+		}
+		types[strings.TrimSpace(line[:idx])] = strings.TrimSpace(line[idx+1:])
+	}
+	return types, nil
+}
+
 // FileExists 实现Storage接口 - 检查文件是否存在
 func (r *RcloneStorage) FileExists(ctx context.Context, remotePath string) (bool, error) {
 	// 使用rclone lsf命令检查文件是否存在
@@ -152,13 +463,102 @@ func (r *RcloneStorage) FileExists(ctx context.Context, remotePath string) (bool
 	return len(strings.TrimSpace(string(output))) > 0, nil
 }
 
-// GetFileContent 实现Storage接口 - 获取文件内容
+// GetFileContent 实现Storage接口 - 获取文件内容。
+// 设置了读取上限时通过cat --count最多读取上限+1字节，路径误指向大文件时不会整个读入内存
 func (r *RcloneStorage) GetFileContent(ctx context.Context, remotePath string) ([]byte, error) {
 	// 使用rclone cat命令获取文件内容，现在rcloneCommand已经分离了标准输出和错误输出
-	output, err := r.rcloneCommand(ctx, "cat", remotePath)
+	args := []string{remotePath}
+	if r.catMaxSize > 0 {
+		args = []string{"--count", strconv.FormatInt(r.catMaxSize+1, 10), remotePath}
+	}
+	output, err := r.rcloneCommand(ctx, "cat", args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file content: %w", err)
 	}
+	if r.catMaxSize > 0 && int64(len(output)) > r.catMaxSize {
+		return nil, fmt.Errorf("failed to get file content of %s: %w (limit %d bytes)", remotePath, ErrFileTooLarge, r.catMaxSize)
+	}
 
 	return output, nil
 }
+
+// MkdirRemote 实现Storage接口 - 创建远程目录
+func (r *RcloneStorage) MkdirRemote(ctx context.Context, remotePath string) error {
+	// rclone mkdir 对已存在的目录不会报错
+	_, err := r.rcloneCommand(ctx, "mkdir", remotePath)
+	if err != nil {
+		if permErr := permissionError("mkdir", remotePath, err); permErr != nil {
+			return permErr
+		}
+		return fmt.Errorf("failed to create remote directory %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// DeleteFile 实现Storage接口 - 删除远程文件
+func (r *RcloneStorage) DeleteFile(ctx context.Context, remotePath string) error {
+	exists, err := r.FileExists(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	_, err = r.rcloneCommand(ctx, "deletefile", remotePath)
+	if err != nil {
+		if permErr := permissionError("delete", remotePath, err); permErr != nil {
+			return permErr
+		}
+		return fmt.Errorf("failed to delete file %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// Version 执行rclone version，返回输出的第一行（如"rclone v1.66.0"）
+func (r *RcloneStorage) Version(ctx context.Context) (string, error) {
+	output, err := r.rcloneCommand(ctx, "version")
+	if err != nil {
+		return "", fmt.Errorf("failed to get rclone version: %w", err)
+	}
+
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if firstLine == "" {
+		return "", fmt.Errorf("rclone version returned no output")
+	}
+	return strings.TrimSpace(firstLine), nil
+}
+
+// ListRemotes 执行rclone listremotes，返回配置文件中的远程名称（带结尾的冒号，如"s3:"）
+func (r *RcloneStorage) ListRemotes(ctx context.Context) ([]string, error) {
+	output, err := r.rcloneCommand(ctx, "listremotes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remotes: %w", err)
+	}
+	return nonEmptyLines(output), nil
+}
+
+// ListDirs 列出远程路径下的目录名称，与rclone lsd的结果相同，但输出格式便于解析
+func (r *RcloneStorage) ListDirs(ctx context.Context, remotePath string) ([]string, error) {
+	output, err := r.rcloneCommand(ctx, "lsf", "--dirs-only", remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directories in %s: %w", remotePath, err)
+	}
+
+	dirs := nonEmptyLines(output)
+	for i, dir := range dirs {
+		dirs[i] = strings.TrimSuffix(dir, "/")
+	}
+	return dirs, nil
+}
+
+// nonEmptyLines 按行拆分输出，去掉空行和行尾的空白
+func nonEmptyLines(output []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimRight(line, " \r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
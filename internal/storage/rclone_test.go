@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -57,7 +59,7 @@ func TestRcloneCommand(t *testing.T) {
 	}
 
 	// 创建RcloneStorage实例
-	rclone := NewRcloneStorage("rclone", "", []string{}, false)
+	rclone := NewRcloneStorage("rclone", "", []string{}, false, false)
 
 	// 测试一个简单的rclone命令，如果rclone不可用则跳过
 	ctx := context.Background()
@@ -68,3 +70,477 @@ func TestRcloneCommand(t *testing.T) {
 
 	t.Log("rcloneCommand方法已成功分离标准输出和错误输出")
 }
+
+// TestMockMkdirRemote 测试MkdirRemote创建目录且可重复调用
+func TestMockMkdirRemote(t *testing.T) {
+	tempDir := t.TempDir()
+	mockStorage := NewMockStorage(tempDir)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := mockStorage.MkdirRemote(ctx, "backup/pve"); err != nil {
+			t.Fatalf("第%d次MkdirRemote失败: %v", i+1, err)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(tempDir, "backup", "pve"))
+	if err != nil || !info.IsDir() {
+		t.Errorf("远程目录未创建: %v", err)
+	}
+}
+
+// writeFakeRclone 创建一个模拟rclone的shell脚本，用于检查传入的参数和输出处理
+func writeFakeRclone(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("模拟rclone脚本需要sh")
+	}
+
+	path := filepath.Join(t.TempDir(), "rclone")
+	content := "#!/bin/sh\n" + script + "\n"
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatalf("创建模拟rclone失败: %v", err)
+	}
+	return path
+}
+
+// TestRcloneVerboseRclone 测试--verbose-rclone独立控制rclone的输出参数
+func TestRcloneVerboseRclone(t *testing.T) {
+	fake := writeFakeRclone(t, `echo "$@"`)
+	ctx := context.Background()
+
+	testCases := []struct {
+		name          string
+		verbose       bool
+		verboseRclone bool
+		command       string
+		wantVerbose   bool
+	}{
+		{"应用详细日志不影响rclone", true, false, "lsf", false},
+		{"rclone详细输出", false, true, "lsf", true},
+		{"cat始终保持安静", false, true, "cat", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rclone := NewRcloneStorage(fake, "", nil, tc.verbose, tc.verboseRclone)
+			output, err := rclone.rcloneCommand(ctx, tc.command, "remote:path")
+			if err != nil {
+				t.Fatalf("rcloneCommand失败: %v", err)
+			}
+
+			args := strings.Fields(string(output))
+			hasVerbose := containsArg(args, "-v")
+			hasQuiet := containsArg(args, "--quiet")
+			if hasVerbose != tc.wantVerbose || hasQuiet == tc.wantVerbose {
+				t.Errorf("参数不符合预期: %v", args)
+			}
+		})
+	}
+}
+
+// containsArg 检查参数列表中是否包含指定参数
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// TestRcloneTransferArgsOnlyForTransfers 测试传输调优参数不会污染lsjson/cat的输出，其余额外参数传给所有命令
+func TestRcloneTransferArgsOnlyForTransfers(t *testing.T) {
+	// 收到传输调优参数时输出干扰内容，模拟rclone输出被改变的情况；每个命令的参数记录到<命令>-args
+	fake := writeFakeRclone(t, `
+dir="$(dirname "$0")"
+for arg in "$@"; do
+  case "$arg" in
+    --transfers=*|--checkers|--progress) echo "Transferred: 0 B / 0 B"; echo "$@" > "$dir/polluted" ;;
+  esac
+done
+echo "$@" > "$dir/$1-args"
+case "$1" in
+  lsjson) echo '[{"Path":"a.tar.gz","Name":"a.tar.gz","Size":3,"ModTime":"2024-01-01T00:00:00Z","IsDir":false}]' ;;
+  lsf) echo 'a.tar.gz' ;;
+  cat) printf 'abc123  a.tar.gz\n' ;;
+esac`)
+	dir := filepath.Dir(fake)
+	rclone := NewRcloneStorage(fake, "", []string{"--transfers=4", "--checkers", "2", "--progress", "--s3-profile=backup"}, false, false)
+	ctx := context.Background()
+
+	files, err := rclone.ListFiles(ctx, "remote:backup")
+	if err != nil {
+		t.Fatalf("ListFiles失败: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "a.tar.gz" {
+		t.Errorf("ListFiles结果不正确: %+v", files)
+	}
+
+	content, err := rclone.GetFileContent(ctx, "remote:backup/a.tar.gz.sha256")
+	if err != nil {
+		t.Fatalf("GetFileContent失败: %v", err)
+	}
+	if string(content) != "abc123  a.tar.gz\n" {
+		t.Errorf("GetFileContent内容被污染: %q", string(content))
+	}
+
+	if err := rclone.MkdirRemote(ctx, "remote:backup"); err != nil {
+		t.Fatalf("MkdirRemote失败: %v", err)
+	}
+	if err := rclone.DeleteFile(ctx, "remote:backup/a.tar.gz"); err != nil {
+		t.Fatalf("DeleteFile失败: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "polluted")); err == nil {
+		t.Error("非传输命令不应该收到传输调优参数")
+	}
+
+	// 后端认证等其余额外参数传给所有命令，传输命令收到全部额外参数
+	if err := rclone.UploadFile(ctx, "/tmp/a.tar.gz", "remote:backup/a.tar.gz"); err != nil {
+		t.Fatalf("UploadFile失败: %v", err)
+	}
+	for _, command := range []string{"lsjson", "cat", "mkdir", "lsf", "deletefile", "copyto"} {
+		args, err := os.ReadFile(filepath.Join(dir, command+"-args"))
+		if err != nil {
+			t.Fatalf("读取%s参数失败: %v", command, err)
+		}
+		if !strings.Contains(string(args), "--s3-profile=backup") {
+			t.Errorf("%s应该收到后端参数: %s", command, args)
+		}
+		if command == "copyto" && !strings.Contains(string(args), "--transfers=4 --checkers 2 --progress") {
+			t.Errorf("copyto应该收到全部额外参数: %s", args)
+		}
+	}
+}
+
+// TestRcloneParallelism 测试--transfers/--checkers只传给传输类命令，用户额外参数中的同名参数优先
+func TestRcloneParallelism(t *testing.T) {
+	fake := writeFakeRclone(t, `
+echo "$@" > "$(dirname "$0")/$1-args"
+case "$1" in
+  lsjson) echo '[]' ;;
+esac`)
+	dir := filepath.Dir(fake)
+	ctx := context.Background()
+	readArgs := func(command string) string {
+		t.Helper()
+		args, err := os.ReadFile(filepath.Join(dir, command+"-args"))
+		if err != nil {
+			t.Fatalf("读取%s参数失败: %v", command, err)
+		}
+		return string(args)
+	}
+
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+	rclone.transfers = 8
+	rclone.checkers = 16
+	if err := rclone.UploadFile(ctx, "/tmp/a.tar.gz", "remote:backup/a.tar.gz"); err != nil {
+		t.Fatalf("UploadFile失败: %v", err)
+	}
+	if args := readArgs("copyto"); !strings.Contains(args, "--transfers 8 --checkers 16") {
+		t.Errorf("copyto应该收到并发参数: %s", args)
+	}
+	if _, err := rclone.ListFiles(ctx, "remote:backup"); err != nil {
+		t.Fatalf("ListFiles失败: %v", err)
+	}
+	if args := readArgs("lsjson"); strings.Contains(args, "--transfers") || strings.Contains(args, "--checkers") {
+		t.Errorf("lsjson不应该收到并发参数: %s", args)
+	}
+
+	// 额外参数中已指定--transfers时不再添加默认值
+	rclone = NewRcloneStorage(fake, "", []string{"--transfers=2"}, false, false)
+	rclone.transfers = 8
+	rclone.checkers = 16
+	if err := rclone.UploadFile(ctx, "/tmp/a.tar.gz", "remote:backup/a.tar.gz"); err != nil {
+		t.Fatalf("UploadFile失败: %v", err)
+	}
+	args := readArgs("copyto")
+	if strings.Contains(args, "--transfers 8") || !strings.Contains(args, "--transfers=2") || !strings.Contains(args, "--checkers 16") {
+		t.Errorf("用户指定的--transfers应该优先: %s", args)
+	}
+}
+
+// TestRcloneVersion 测试从rclone version输出中取出版本行
+func TestRcloneVersion(t *testing.T) {
+	fake := writeFakeRclone(t, `
+case "$1" in
+  version) printf 'rclone v1.66.0\n- os/version: debian 12\n- go/version: go1.22.1\n' ;;
+esac`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+
+	version, err := rclone.Version(context.Background())
+	if err != nil {
+		t.Fatalf("Version失败: %v", err)
+	}
+	if version != "rclone v1.66.0" {
+		t.Errorf("版本不正确: %q", version)
+	}
+}
+
+// TestRcloneDownloadFileFrom 测试续传使用cat --offset并追加到本地文件
+func TestRcloneDownloadFileFrom(t *testing.T) {
+	fake := writeFakeRclone(t, `
+case "$1" in
+  cat) [ "$2" = "--offset" ] && [ "$3" = "7" ] && printf ' content' ;;
+esac`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+
+	localPath := filepath.Join(t.TempDir(), "metadata.json")
+	if err := os.WriteFile(localPath, []byte("archive"), 0644); err != nil {
+		t.Fatalf("创建本地文件失败: %v", err)
+	}
+	if err := rclone.DownloadFileFrom(context.Background(), "remote:backup/metadata.json", localPath, 7); err != nil {
+		t.Fatalf("DownloadFileFrom失败: %v", err)
+	}
+	if data, _ := os.ReadFile(localPath); string(data) != "archive content" {
+		t.Errorf("续传内容不正确: %q", data)
+	}
+}
+
+// TestRcloneListRemotes 测试列出远程和远程下的目录
+func TestRcloneListRemotes(t *testing.T) {
+	fake := writeFakeRclone(t, `
+case "$1" in
+  listremotes) printf 's3:\nb2 offsite:\n\n' ;;
+  lsf) [ "$2" = "--dirs-only" ] && [ "$3" = "s3:" ] && printf 'pve-backups/\nold data/\n' ;;
+esac`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+	ctx := context.Background()
+
+	remotes, err := rclone.ListRemotes(ctx)
+	if err != nil {
+		t.Fatalf("ListRemotes失败: %v", err)
+	}
+	if strings.Join(remotes, "|") != "s3:|b2 offsite:" {
+		t.Errorf("远程列表不正确: %q", remotes)
+	}
+
+	dirs, err := rclone.ListDirs(ctx, "s3:")
+	if err != nil {
+		t.Fatalf("ListDirs失败: %v", err)
+	}
+	if strings.Join(dirs, "|") != "pve-backups|old data" {
+		t.Errorf("目录列表不正确: %q", dirs)
+	}
+}
+
+// TestRcloneCatMaxSize 测试读取上限：cat只读取上限+1字节，超过上限时报错
+func TestRcloneCatMaxSize(t *testing.T) {
+	remoteDir := t.TempDir()
+	checksum := strings.Repeat("a", 64) + "  0000-00ff.tar.gz\n"
+	if err := os.WriteFile(filepath.Join(remoteDir, "checksum"), []byte(checksum), 0644); err != nil {
+		t.Fatalf("创建校验和文件失败: %v", err)
+	}
+	oversized := make([]byte, 1<<20)
+	if err := os.WriteFile(filepath.Join(remoteDir, "oversized"), oversized, 0644); err != nil {
+		t.Fatalf("创建大文件失败: %v", err)
+	}
+
+	// 按--count截断输出，并记录收到的参数
+	fake := writeFakeRclone(t, `
+echo "$@" > "$(dirname "$0")/cat-args"
+if [ "$2" = "--count" ]; then head -c "$3" "`+remoteDir+`/$4"; else cat "`+remoteDir+`/$2"; fi`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+	rclone.catMaxSize = 1024
+	ctx := context.Background()
+
+	content, err := rclone.GetFileContent(ctx, "checksum")
+	if err != nil || string(content) != checksum {
+		t.Fatalf("未超过上限的文件应完整读取: %q, %v", content, err)
+	}
+	args, _ := os.ReadFile(filepath.Join(filepath.Dir(fake), "cat-args"))
+	if !strings.Contains(string(args), "--count 1025") {
+		t.Errorf("cat应只读取上限+1字节: %s", args)
+	}
+
+	if _, err := rclone.GetFileContent(ctx, "oversized"); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("超过上限时应返回ErrFileTooLarge: %v", err)
+	}
+
+	rclone.catMaxSize = 0
+	if content, err := rclone.GetFileContent(ctx, "oversized"); err != nil || len(content) != len(oversized) {
+		t.Errorf("不限制时应完整读取: %d字节, %v", len(content), err)
+	}
+}
+
+// TestParseLsjson 测试解析带有警告行、错误对象等非标准输出的lsjson结果
+func TestParseLsjson(t *testing.T) {
+	listing := `[
+{"Path":"0000-00ff.tar.gz","Name":"0000-00ff.tar.gz","Size":1024,"MimeType":"application/gzip","ModTime":"2024-03-14T08:00:00.000000000Z","IsDir":false},
+{"Path":"sha256","Name":"sha256","Size":-1,"MimeType":"inode/directory","ModTime":"2024-03-14T08:00:00.000000000Z","IsDir":true}
+]`
+
+	testCases := []struct {
+		name    string
+		output  string
+		want    int    // 期望的文件数
+		wantErr string // 期望错误信息包含的内容，为空表示不应出错
+	}{
+		{"标准输出", listing + "\n", 2, ""},
+		{"空目录", "[\n]\n", 0, ""},
+		{"前面有警告行", "2024/03/14 08:00:00 NOTICE: Config file \"/root/.config/rclone/rclone.conf\" not found - using defaults\n" + listing, 2, ""},
+		{"前后都有非JSON行", "[WARN] deprecated flag\n" + listing + "\nTransferred: 0 B / 0 B\n", 2, ""},
+		{"Windows换行", strings.ReplaceAll(listing, "\n", "\r\n") + "\r\n", 2, ""},
+		{"错误对象", `{"error":"directory not found","input":{"fs":"remote:backup"},"status":404}`, 0, "directory not found"},
+		{"其他对象", `{"Path":"x"}`, 0, "instead of a file list"},
+		{"没有JSON", "2024/03/14 08:00:00 ERROR : : error listing: directory not found\n", 0, "no JSON file list"},
+		{"空输出", "", 0, "no JSON file list"},
+		{"JSON不完整", "[\n{\"Name\":\"a\"", 0, "no JSON file list"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := parseLsjson([]byte(tc.output))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("错误信息应包含%q，实际: %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if len(files) != tc.want {
+				t.Fatalf("应解析出%d个文件，实际 %d: %+v", tc.want, len(files), files)
+			}
+			if tc.want > 0 && (files[0].Name != "0000-00ff.tar.gz" || files[0].Size != 1024 || files[0].IsDir || !files[1].IsDir) {
+				t.Errorf("文件信息不正确: %+v", files)
+			}
+		})
+	}
+}
+
+// TestRcloneListFilesWithHashes 测试lsjson --hash的哈希解析，哈希类型和值统一为小写，空值忽略
+func TestRcloneListFilesWithHashes(t *testing.T) {
+	fake := writeFakeRclone(t, `case "$*" in
+*--hash*) echo '[{"Path":"a","Name":"a","Size":3,"ModTime":"2024-03-14T08:00:00Z","IsDir":false,"Hashes":{"CRC32C":"0A1B2C3D","md5":""}}]' ;;
+*) echo "missing --hash: $*" >&2; exit 1 ;;
+esac`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+	files, err := rclone.ListFilesWithHashes(context.Background(), "remote:backup/chunk")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("ListFilesWithHashes失败: %+v, %v", files, err)
+	}
+	if len(files[0].Hashes) != 1 || files[0].Hashes[HashCRC32C] != "0a1b2c3d" {
+		t.Errorf("哈希解析不正确: %v", files[0].Hashes)
+	}
+}
+
+// TestRcloneListFilesNoisyOutput 测试rclone在JSON前输出警告时ListFiles仍能正常解析
+func TestRcloneListFilesNoisyOutput(t *testing.T) {
+	fake := writeFakeRclone(t, `echo "2024/03/14 08:00:00 NOTICE: something happened"
+echo '[{"Path":"a","Name":"a","Size":3,"ModTime":"2024-03-14T08:00:00Z","IsDir":false}]'`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+	files, err := rclone.ListFiles(context.Background(), "remote:backup")
+	if err != nil || len(files) != 1 || files[0].Name != "a" || files[0].Size != 3 {
+		t.Fatalf("ListFiles结果不正确: %+v, %v", files, err)
+	}
+}
+
+// TestRclonePermissionDenied 测试远程拒绝写入/删除时返回可识别的权限错误
+func TestRclonePermissionDenied(t *testing.T) {
+	fake := writeFakeRclone(t, `
+case "$1" in
+  lsf) echo "a.tar.gz" ;;
+  *) printf 'ERROR : a.tar.gz: AccessDenied: Access Denied\n\tstatus code: 403, request id: X\n' >&2; exit 1 ;;
+esac`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+	ctx := context.Background()
+
+	errs := map[string]error{
+		"upload": rclone.UploadFile(ctx, "/tmp/a.tar.gz", "remote:backup/a.tar.gz"),
+		"mkdir":  rclone.MkdirRemote(ctx, "remote:backup/chunk"),
+		"delete": rclone.DeleteFile(ctx, "remote:backup/a.tar.gz"),
+	}
+	for operation, err := range errs {
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s应该返回ErrPermissionDenied: %v", operation, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "permission denied — check credentials/bucket policy") {
+			t.Errorf("%s的错误信息缺少处理提示: %v", operation, err)
+		}
+	}
+
+	// 其他失败不应该被当成权限错误，压缩包名中的403也不应该匹配
+	other := writeFakeRclone(t, `printf 'ERROR : 0403-04ff.tar.gz: connection reset by peer\n' >&2; exit 1`)
+	err := NewRcloneStorage(other, "", nil, false, false).UploadFile(ctx, "/tmp/0403-04ff.tar.gz", "remote:backup/0403-04ff.tar.gz")
+	if err == nil || errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("普通失败不应该被识别为权限错误: %v", err)
+	}
+}
+
+// TestRcloneMaxObjectSize 测试按远程类型识别对象大小上限
+func TestRcloneMaxObjectSize(t *testing.T) {
+	fake := writeFakeRclone(t, `
+case "$1 $2" in
+  "listremotes --long") printf 'box:          onedrive\nb2 offsite:   b2\nnas:          sftp\n' ;;
+  *) exit 1 ;;
+esac`)
+
+	testCases := []struct {
+		remotePath string
+		expected   int64
+	}{
+		{"box:pbs/backups", 250 << 30},
+		{"b2 offsite:bucket", 10_000_000_000_000},
+		{"nas:/srv/backup", 0},
+		{"unknown:bucket", 0},
+		{":s3,provider=Minio:bucket", 5 << 40},
+		{":dropbox:backup", 350 << 30},
+		{"/mnt/backup", 0},
+		{`C:\backup`, 0},
+	}
+	ctx := context.Background()
+	for _, tc := range testCases {
+		rclone := NewRcloneStorage(fake, "", nil, false, false)
+		rclone.remotePath = tc.remotePath
+		if limit := rclone.Capabilities().MaxObjectSize; limit != 0 {
+			t.Errorf("识别之前Capabilities不应报告上限，得到%d", limit)
+		}
+		if err := rclone.DetectLimits(ctx); err != nil {
+			t.Errorf("识别%s失败: %v", tc.remotePath, err)
+		}
+		if limit := rclone.Capabilities().MaxObjectSize; limit != tc.expected {
+			t.Errorf("%s的对象大小上限应为%d，得到%d", tc.remotePath, tc.expected, limit)
+		}
+	}
+
+	// 无法执行listremotes时返回错误，不检查
+	failing := NewRcloneStorage(writeFakeRclone(t, `exit 1`), "", nil, false, false)
+	failing.remotePath = "box:pbs"
+	if err := failing.DetectLimits(ctx); err == nil {
+		t.Error("无法执行listremotes时应返回错误")
+	}
+	if limit := failing.Capabilities().MaxObjectSize; limit != 0 {
+		t.Errorf("识别失败时对象大小上限应为0，得到%d", limit)
+	}
+
+	// 取消的上下文不执行rclone
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	box := NewRcloneStorage(fake, "", nil, false, false)
+	box.remotePath = "box:pbs"
+	if err := box.DetectLimits(cancelled); err == nil {
+		t.Error("上下文取消时应返回错误")
+	}
+}
+
+// TestRcloneReadRange 测试按范围读取使用cat --offset --count，读取不足时返回错误
+func TestRcloneReadRange(t *testing.T) {
+	fake := writeFakeRclone(t, `
+[ "$1 $2 $3 $4 $5 $6" = "cat --offset 8 --count 7 remote:chunk/a.tar.gz" ] && printf 'content' && exit 0
+[ "$1" = "cat" ] && printf 'short' && exit 0
+exit 1`)
+	rclone := NewRcloneStorage(fake, "", nil, false, false)
+	ctx := context.Background()
+
+	data, err := rclone.ReadRange(ctx, "remote:chunk/a.tar.gz", 8, 7)
+	if err != nil || string(data) != "content" {
+		t.Errorf("ReadRange结果不正确: %q, %v", data, err)
+	}
+	if _, err := rclone.ReadRange(ctx, "remote:chunk/a.tar.gz", 8, 20); err == nil {
+		t.Error("读取的字节数不足时应该返回错误")
+	}
+}
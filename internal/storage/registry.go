@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options 创建存储后端的参数，各后端只读取自己需要的字段
+type Options struct {
+	RcloneBinary  string   // rclone二进制路径
+	RcloneConfig  string   // rclone配置文件路径
+	RcloneArgs    []string // rclone额外参数
+	Verbose       bool     // 详细输出模式
+	VerboseRclone bool     // rclone自身的详细输出
+	CatMaxSize    int64    // GetFileContent读取的文件大小上限，0表示不限制
+	Transfers     int      // rclone传输类命令的--transfers，0表示使用rclone默认值
+	Checkers      int      // rclone传输类命令的--checkers，0表示使用rclone默认值
+	RemotePath    string   // 备份的远程路径，rclone据此识别远程类型和对象大小上限
+
+	SFTPHost                  string // SFTP服务器地址
+	SFTPPort                  int    // SFTP端口，0表示22
+	SFTPUser                  string // SFTP用户名
+	SFTPKeyFile               string // SSH私钥文件
+	SFTPPassword              string // 密码（私钥加密时作为私钥口令）
+	SFTPKnownHosts            string // known_hosts文件，默认~/.ssh/known_hosts
+	SFTPInsecureIgnoreHostKey bool   // 跳过主机密钥校验
+}
+
+// Constructor 存储后端构造函数
+type Constructor func(opts Options) (Storage, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Constructor)
+)
+
+// Register 注册存储后端，通常在后端实现文件的init中调用。
+// 名称重复或构造函数为nil时panic，与database/sql的驱动注册方式一致。
+func Register(name string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if constructor == nil {
+		panic("storage: Register constructor is nil for " + name)
+	}
+	if _, exists := registry[name]; exists {
+		panic("storage: Register called twice for " + name)
+	}
+	registry[name] = constructor
+}
+
+// New 根据名称创建已注册的存储后端
+func New(name string, opts Options) (Storage, error) {
+	registryMu.RLock()
+	constructor, exists := registry[name]
+	registryMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown storage backend %q (available: %s)", name, strings.Join(Backends(), ", "))
+	}
+
+	return constructor(opts)
+}
+
+// Backends 返回已注册的后端名称（按字母排序）
+func Backends() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+)
+
+// TestRegistry 测试存储后端注册与查找
+func TestRegistry(t *testing.T) {
+	// rclone后端在init中自动注册
+	store, err := New("rclone", Options{RcloneBinary: "rclone"})
+	if err != nil {
+		t.Fatalf("创建rclone后端失败: %v", err)
+	}
+	if _, ok := store.(*RcloneStorage); !ok {
+		t.Errorf("rclone后端类型不正确: %T", store)
+	}
+
+	// 注册并查找自定义后端
+	remoteDir := t.TempDir()
+	Register("test-mock", func(opts Options) (Storage, error) {
+		return NewMockStorage(remoteDir), nil
+	})
+	store, err = New("test-mock", Options{})
+	if err != nil {
+		t.Fatalf("创建自定义后端失败: %v", err)
+	}
+	if mock, ok := store.(*MockStorage); !ok || mock.GetRemotePath() != remoteDir {
+		t.Errorf("自定义后端不正确: %T", store)
+	}
+
+	found := false
+	for _, name := range Backends() {
+		if name == "test-mock" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Backends应该包含test-mock: %v", Backends())
+	}
+
+	// 未知后端返回错误
+	if _, err := New("does-not-exist", Options{}); err == nil {
+		t.Error("未知后端应该返回错误")
+	}
+
+	// 重复注册panic
+	defer func() {
+		if recover() == nil {
+			t.Error("重复注册应该panic")
+		}
+	}()
+	Register("test-mock", func(opts Options) (Storage, error) { return nil, nil })
+}
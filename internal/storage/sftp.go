@@ -0,0 +1,526 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"pbs-backuper/internal/logger"
+)
+
+// sftpPartialSuffix 上传过程中使用的临时文件后缀，上传完成后重命名为目标文件
+const sftpPartialSuffix = ".partial"
+
+// sftpReplacedSuffix 服务器不支持posix-rename时，覆盖前暂存旧文件使用的后缀
+const sftpReplacedSuffix = ".replaced"
+
+// sftpPosixRename 原子覆盖目标文件的重命名扩展（OpenSSH）
+const sftpPosixRename = "posix-rename@openssh.com"
+
+// SFTPStorage SFTP存储实现，直接通过SSH连接远程服务器，无需rclone
+type SFTPStorage struct {
+	address      string            // 服务器地址（host:port）
+	clientConfig *ssh.ClientConfig // SSH连接配置
+	catMaxSize   int64             // GetFileContent读取的文件大小上限，0表示不限制
+
+	mu        sync.Mutex
+	sshClient *ssh.Client
+	client    *sftp.Client
+}
+
+func init() {
+	Register("sftp", func(opts Options) (Storage, error) {
+		return NewSFTPStorage(opts)
+	})
+}
+
+// NewSFTPStorage 创建SFTP存储实例，连接在第一次操作时建立
+func NewSFTPStorage(opts Options) (*SFTPStorage, error) {
+	if opts.SFTPHost == "" {
+		return nil, fmt.Errorf("sftp host is required")
+	}
+	if opts.SFTPUser == "" {
+		return nil, fmt.Errorf("sftp user is required")
+	}
+
+	auth, err := sftpAuthMethods(opts.SFTPKeyFile, opts.SFTPPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := sftpHostKeyCallback(opts.SFTPKnownHosts, opts.SFTPInsecureIgnoreHostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	port := opts.SFTPPort
+	if port == 0 {
+		port = 22
+	}
+
+	return &SFTPStorage{
+		address: net.JoinHostPort(opts.SFTPHost, strconv.Itoa(port)),
+		clientConfig: &ssh.ClientConfig{
+			User:            opts.SFTPUser,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+		},
+		catMaxSize: opts.CatMaxSize,
+	}, nil
+}
+
+// newSFTPStorageWithClient 使用已建立的SFTP客户端创建存储实例（用于测试）
+func newSFTPStorageWithClient(client *sftp.Client) *SFTPStorage {
+	return &SFTPStorage{client: client}
+}
+
+// sftpAuthMethods 根据私钥文件和密码构建认证方式。
+// 私钥加密时使用密码作为私钥口令，否则密码作为额外的密码认证方式。
+func sftpAuthMethods(keyFile, password string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if keyFile != "" {
+		keyData, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftp key file: %w", err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(keyData)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && password != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(password))
+			password = ""
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftp key file: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("sftp requires a key file or password")
+	}
+
+	return methods, nil
+}
+
+// sftpHostKeyCallback 构建主机密钥校验，默认使用~/.ssh/known_hosts
+func sftpHostKeyCallback(knownHostsFile string, insecure bool) (ssh.HostKeyCallback, error) {
+	if insecure {
+		logger.Warn("SFTP主机密钥校验已禁用，连接可能受到中间人攻击")
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts %s: %w", knownHostsFile, err)
+	}
+	return callback, nil
+}
+
+// getClient 获取SFTP客户端，首次调用时建立连接
+func (s *SFTPStorage) getClient(ctx context.Context) (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.address, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.address, s.clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", s.address, err)
+	}
+	s.sshClient = ssh.NewClient(sshConn, chans, reqs)
+
+	s.client, err = sftp.NewClient(s.sshClient)
+	if err != nil {
+		s.sshClient.Close()
+		s.sshClient = nil
+		return nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+
+	return s.client, nil
+}
+
+// Close 关闭SFTP和SSH连接
+func (s *SFTPStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.client != nil {
+		err = s.client.Close()
+		s.client = nil
+	}
+	if s.sshClient != nil {
+		if closeErr := s.sshClient.Close(); err == nil {
+			err = closeErr
+		}
+		s.sshClient = nil
+	}
+	return err
+}
+
+// ListFiles 实现Storage接口 - 列出文件
+func (s *SFTPStorage) ListFiles(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := client.ReadDir(remotePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []FileInfo{}, nil
+		}
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	files := make([]FileInfo, len(entries))
+	for i, entry := range entries {
+		files[i] = FileInfo{
+			Name:    entry.Name(),
+			Size:    entry.Size(),
+			ModTime: entry.ModTime(),
+			IsDir:   entry.IsDir(),
+		}
+	}
+
+	return files, nil
+}
+
+// DownloadFile 实现Storage接口 - 下载文件
+func (s *SFTPStorage) DownloadFile(ctx context.Context, remotePath, localPath string) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	src, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory for %s: %w", localPath, err)
+	}
+
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local file %s: %w", localPath, err)
+	}
+
+	if _, err := io.Copy(dst, &contextReader{ctx: ctx, r: src}); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to download file %s to %s: %w", remotePath, localPath, err)
+	}
+	return dst.Close()
+}
+
+// DownloadFileFrom 实现ResumableDownloader接口 - 从offset处续传
+func (s *SFTPStorage) DownloadFileFrom(ctx context.Context, remotePath, localPath string, offset int64) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	src, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
+	}
+	defer src.Close()
+
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek remote file %s: %w", remotePath, err)
+	}
+	if err := appendToFile(localPath, &contextReader{ctx: ctx, r: src}); err != nil {
+		return fmt.Errorf("failed to resume download of %s to %s: %w", remotePath, localPath, err)
+	}
+	return nil
+}
+
+// ReadRange 实现RangeReader接口 - 定位到offset后只读取length字节
+func (s *SFTPStorage) ReadRange(ctx context.Context, remotePath string, offset, length int64) ([]byte, error) {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := client.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
+	}
+	defer src.Close()
+
+	data := make([]byte, length)
+	if _, err := src.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("failed to read %d bytes at offset %d of %s: %w", length, offset, remotePath, err)
+	}
+	return data, nil
+}
+
+// UploadFile 实现Storage接口 - 上传文件。
+// 先写入临时文件再重命名，读取方不会看到上传到一半的文件。
+func (s *SFTPStorage) UploadFile(ctx context.Context, localPath, remotePath string) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file %s: %w", localPath, err)
+	}
+	defer src.Close()
+
+	if err := writeRemoteFile(ctx, client, src, remotePath); err != nil {
+		return fmt.Errorf("failed to upload file %s to %s: %w", localPath, remotePath, err)
+	}
+	return nil
+}
+
+// CopyRemote 实现Storage接口 - 复制文件或目录。
+// SFTP协议没有服务端复制，数据经本机中转，但不写入本地磁盘
+func (s *SFTPStorage) CopyRemote(ctx context.Context, srcPath, dstPath string) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	walker := client.Walk(srcPath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return fmt.Errorf("failed to walk %s: %w", srcPath, err)
+		}
+		if walker.Stat().IsDir() {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), srcPath), "/")
+		target := path.Join(dstPath, rel)
+		if err := copySFTPFile(ctx, client, walker.Path(), target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveRemote 实现Storage接口 - 重命名远程文件，目标已存在时覆盖
+func (s *SFTPStorage) MoveRemote(ctx context.Context, srcPath, dstPath string) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 没有posix-rename时覆盖目标要分两步，不满足Move要求的原子性
+	if _, ok := client.HasExtension(sftpPosixRename); !ok {
+		return ErrNotSupported
+	}
+	if err := client.MkdirAll(path.Dir(dstPath)); err != nil {
+		return fmt.Errorf("failed to create remote directory for %s: %w", dstPath, err)
+	}
+	if err := renameRemoteFile(client, srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", srcPath, dstPath, err)
+	}
+	return nil
+}
+
+// Capabilities 实现Storage接口。SFTP协议没有服务端复制；上传先写入.partial再重命名，
+// 服务器支持posix-rename扩展时重命名原子地覆盖目标，上传和移动都是原子的；否则覆盖目标要分两步，
+// 中间目标不存在，上传按不是原子的处理，也不提供移动。扩展在连接后才能确定，尚未连接时按不支持处理
+func (s *SFTPStorage) Capabilities() Capabilities {
+	atomic := s.hasPosixRename()
+	return Capabilities{Copy: true, Move: atomic, ResumableDownload: true, AtomicUpload: atomic}
+}
+
+// hasPosixRename 判断已建立的连接是否支持posix-rename扩展
+func (s *SFTPStorage) hasPosixRename() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return false
+	}
+	_, ok := s.client.HasExtension(sftpPosixRename)
+	return ok
+}
+
+// copySFTPFile 复制单个远程文件
+func copySFTPFile(ctx context.Context, client *sftp.Client, srcPath, dstPath string) error {
+	src, err := client.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file %s: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	if err := writeRemoteFile(ctx, client, src, dstPath); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
+	}
+	return nil
+}
+
+// writeRemoteFile 先写入临时文件再重命名，读取方不会看到写到一半的文件
+func writeRemoteFile(ctx context.Context, client *sftp.Client, src io.Reader, remotePath string) error {
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return fmt.Errorf("failed to create remote directory for %s: %w", remotePath, err)
+	}
+
+	partialPath := remotePath + sftpPartialSuffix
+	dst, err := client.Create(partialPath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file %s: %w", partialPath, err)
+	}
+
+	if _, err := io.Copy(dst, &contextReader{ctx: ctx, r: src}); err != nil {
+		dst.Close()
+		client.Remove(partialPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		client.Remove(partialPath)
+		return fmt.Errorf("failed to finish upload of %s: %w", remotePath, err)
+	}
+
+	if err := renameRemoteFile(client, partialPath, remotePath); err != nil {
+		client.Remove(partialPath)
+		return err
+	}
+	return nil
+}
+
+// renameRemoteFile 重命名远程文件并覆盖目标。
+// 服务器支持posix-rename扩展时原子覆盖目标文件；不支持时先把目标改名为.replaced，新文件就位后再删除
+func renameRemoteFile(client *sftp.Client, srcPath, dstPath string) error {
+	if _, ok := client.HasExtension(sftpPosixRename); ok {
+		if err := client.PosixRename(srcPath, dstPath); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", srcPath, dstPath, err)
+		}
+		return nil
+	}
+	// 先把旧文件改名保留，新文件就位后再删除，重命名失败时恢复旧文件
+	replacedPath := dstPath + sftpReplacedSuffix
+	if err := client.Remove(replacedPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale %s: %w", replacedPath, err)
+	}
+	err := client.Rename(dstPath, replacedPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace remote file %s: %w", dstPath, err)
+	}
+	replaced := err == nil
+	if err := client.Rename(srcPath, dstPath); err != nil {
+		if replaced {
+			client.Rename(replacedPath, dstPath)
+		}
+		return fmt.Errorf("failed to rename %s to %s: %w", srcPath, dstPath, err)
+	}
+	if replaced {
+		client.Remove(replacedPath)
+	}
+	return nil
+}
+
+// FileExists 实现Storage接口 - 检查文件是否存在
+func (s *SFTPStorage) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := client.Stat(remotePath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check file existence: %w", err)
+	}
+	return true, nil
+}
+
+// GetFileContent 实现Storage接口 - 获取文件内容
+func (s *SFTPStorage) GetFileContent(ctx context.Context, remotePath string) ([]byte, error) {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := client.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file content: %w", err)
+	}
+	defer file.Close()
+
+	content, err := readLimited(&contextReader{ctx: ctx, r: file}, s.catMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file content of %s: %w", remotePath, err)
+	}
+	return content, nil
+}
+
+// MkdirRemote 实现Storage接口 - 创建远程目录
+func (s *SFTPStorage) MkdirRemote(ctx context.Context, remotePath string) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := client.MkdirAll(remotePath); err != nil {
+		return fmt.Errorf("failed to create remote directory %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// DeleteFile 实现Storage接口 - 删除远程文件
+func (s *SFTPStorage) DeleteFile(ctx context.Context, remotePath string) error {
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Remove(remotePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// contextReader 在每次读取前检查上下文，使长时间的传输可以被取消或超时中断
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// newInMemorySFTP 创建连接到内存SFTP服务器的存储实例
+func newInMemorySFTP(t *testing.T) *SFTPStorage {
+	t.Helper()
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter}, sftp.InMemHandler())
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatalf("创建SFTP客户端失败: %v", err)
+	}
+
+	store := newSFTPStorageWithClient(client)
+	// 先关闭服务端，客户端的接收协程才能读到EOF并退出
+	t.Cleanup(func() {
+		server.Close()
+		store.Close()
+	})
+	return store
+}
+
+// TestSFTPStorage 测试SFTP后端的上传、下载、列出、读取和删除
+func TestSFTPStorage(t *testing.T) {
+	store := newInMemorySFTP(t)
+	ctx := context.Background()
+
+	localDir := t.TempDir()
+	localFile := filepath.Join(localDir, "0000-00ff.tar.gz")
+	if err := os.WriteFile(localFile, []byte("archive content"), 0644); err != nil {
+		t.Fatalf("创建本地文件失败: %v", err)
+	}
+
+	if err := store.MkdirRemote(ctx, "/backup"); err != nil {
+		t.Fatalf("MkdirRemote失败: %v", err)
+	}
+
+	remoteFile := "/backup/chunk/0000-00ff.tar.gz"
+	for i := 0; i < 2; i++ {
+		// 第二次上传覆盖已存在的文件
+		if err := store.UploadFile(ctx, localFile, remoteFile); err != nil {
+			t.Fatalf("第%d次UploadFile失败: %v", i+1, err)
+		}
+	}
+
+	exists, err := store.FileExists(ctx, remoteFile)
+	if err != nil || !exists {
+		t.Fatalf("上传后文件应该存在: %v", err)
+	}
+	if exists, _ := store.FileExists(ctx, remoteFile+sftpPartialSuffix); exists {
+		t.Error("上传完成后不应该残留临时文件")
+	}
+
+	files, err := store.ListFiles(ctx, "/backup/chunk")
+	if err != nil {
+		t.Fatalf("ListFiles失败: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "0000-00ff.tar.gz" || files[0].Size != int64(len("archive content")) {
+		t.Errorf("ListFiles结果不正确: %+v", files)
+	}
+
+	content, err := store.GetFileContent(ctx, remoteFile)
+	if err != nil || string(content) != "archive content" {
+		t.Errorf("GetFileContent结果不正确: %q, %v", content, err)
+	}
+
+	downloaded := filepath.Join(localDir, "download", "archive.tar.gz")
+	if err := store.DownloadFile(ctx, remoteFile, downloaded); err != nil {
+		t.Fatalf("DownloadFile失败: %v", err)
+	}
+	if data, _ := os.ReadFile(downloaded); string(data) != "archive content" {
+		t.Errorf("下载内容不正确: %q", data)
+	}
+
+	// 模拟下载中断后从已有的位置续传
+	if err := os.Truncate(downloaded, 7); err != nil {
+		t.Fatalf("截断文件失败: %v", err)
+	}
+	if err := store.DownloadFileFrom(ctx, remoteFile, downloaded, 7); err != nil {
+		t.Fatalf("DownloadFileFrom失败: %v", err)
+	}
+	if data, _ := os.ReadFile(downloaded); string(data) != "archive content" {
+		t.Errorf("续传内容不正确: %q", data)
+	}
+
+	// 只读取一段内容
+	if data, err := store.ReadRange(ctx, remoteFile, 8, 7); err != nil || string(data) != "content" {
+		t.Errorf("ReadRange结果不正确: %q, %v", data, err)
+	}
+	if _, err := store.ReadRange(ctx, remoteFile, 8, 20); err == nil {
+		t.Error("读取范围超过文件末尾时应该返回错误")
+	}
+
+	// 超过读取上限的文件不读入内存
+	store.catMaxSize = 4
+	if _, err := store.GetFileContent(ctx, "/backup/chunk/0000-00ff.tar.gz"); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("超过读取上限时应返回ErrFileTooLarge: %v", err)
+	}
+	store.catMaxSize = 0
+
+	// 在服务器上复制整个目录
+	if err := store.CopyRemote(ctx, "/backup", "/copy"); err != nil {
+		t.Fatalf("CopyRemote失败: %v", err)
+	}
+	if content, err := store.GetFileContent(ctx, "/copy/chunk/0000-00ff.tar.gz"); err != nil || string(content) != "archive content" {
+		t.Errorf("复制的内容不正确: %q, %v", content, err)
+	}
+
+	if err := store.DeleteFile(ctx, remoteFile); err != nil {
+		t.Fatalf("DeleteFile失败: %v", err)
+	}
+	if err := store.DeleteFile(ctx, remoteFile); err != nil {
+		t.Errorf("删除不存在的文件不应该报错: %v", err)
+	}
+	if exists, _ := store.FileExists(ctx, remoteFile); exists {
+		t.Error("删除后文件不应该存在")
+	}
+}
+
+// TestNewSFTPStorageValidation 测试SFTP参数校验
+func TestNewSFTPStorageValidation(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"缺少主机", Options{SFTPUser: "backup", SFTPPassword: "secret"}},
+		{"缺少用户", Options{SFTPHost: "example.com", SFTPPassword: "secret"}},
+		{"缺少认证方式", Options{SFTPHost: "example.com", SFTPUser: "backup"}},
+		{"私钥文件不存在", Options{SFTPHost: "example.com", SFTPUser: "backup", SFTPKeyFile: "/nonexistent/key"}},
+		{"known_hosts不存在", Options{SFTPHost: "example.com", SFTPUser: "backup", SFTPPassword: "secret", SFTPKnownHosts: "/nonexistent/known_hosts"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewSFTPStorage(tc.opts); err == nil {
+				t.Error("应该返回错误")
+			}
+		})
+	}
+
+	store, err := NewSFTPStorage(Options{SFTPHost: "example.com", SFTPUser: "backup", SFTPPassword: "secret", SFTPInsecureIgnoreHostKey: true})
+	if err != nil {
+		t.Fatalf("有效参数不应该返回错误: %v", err)
+	}
+	if store.address != "example.com:22" {
+		t.Errorf("默认端口应该是22: %s", store.address)
+	}
+}
+
+// TestSFTPAtomicUpload 测试只有服务器支持posix-rename扩展时才报告原子上传和移动
+func TestSFTPAtomicUpload(t *testing.T) {
+	if caps := newInMemorySFTP(t).Capabilities(); !caps.AtomicUpload || !caps.Move {
+		t.Errorf("服务器支持posix-rename时应报告原子上传和移动: %+v", caps)
+	}
+	if caps := (&SFTPStorage{}).Capabilities(); caps.AtomicUpload || caps.Move {
+		t.Errorf("尚未连接时不应报告原子上传和移动: %+v", caps)
+	}
+
+	if err := sftp.SetSFTPExtensions("hardlink@openssh.com", "statvfs@openssh.com"); err != nil {
+		t.Fatalf("设置SFTP扩展失败: %v", err)
+	}
+	t.Cleanup(func() {
+		sftp.SetSFTPExtensions("hardlink@openssh.com", sftpPosixRename, "statvfs@openssh.com")
+	})
+	store := newInMemorySFTP(t)
+	if caps := store.Capabilities(); caps.AtomicUpload || caps.Move {
+		t.Errorf("服务器不支持posix-rename时不应报告原子上传和移动: %+v", caps)
+	}
+
+	// 不支持posix-rename时仍能覆盖已有文件，暂存的旧文件在新文件就位后删除
+	ctx := context.Background()
+	local := filepath.Join(t.TempDir(), "data")
+	for _, content := range []string{"old", "new"} {
+		if err := os.WriteFile(local, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UploadFile(ctx, local, "/data"); err != nil {
+			t.Fatalf("上传失败: %v", err)
+		}
+	}
+	data, err := store.GetFileContent(ctx, "/data")
+	if err != nil || string(data) != "new" {
+		t.Errorf("覆盖后内容错误: %q, %v", data, err)
+	}
+	if exists, _ := store.FileExists(ctx, "/data"+sftpReplacedSuffix); exists {
+		t.Error("覆盖完成后不应残留暂存的旧文件")
+	}
+	if err := store.MoveRemote(ctx, "/data", "/moved"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("不支持posix-rename时移动应返回ErrNotSupported，实际 %v", err)
+	}
+}
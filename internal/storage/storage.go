@@ -2,6 +2,11 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -11,6 +16,8 @@ type FileInfo struct {
 	Size    int64     // 文件大小
 	ModTime time.Time // 修改时间
 	IsDir   bool      // 是否为目录
+
+	Hashes map[string]string // 服务端记录的哈希（哈希类型到十六进制小写值），只有HashLister返回
 }
 
 // Storage 存储接口，抽象化云端存储操作
@@ -27,6 +34,109 @@ type Storage interface {
 	// FileExists 检查远程文件是否存在
 	FileExists(ctx context.Context, remotePath string) (bool, error)
 
-	// GetFileContent 获取远程文件内容（小文件）
+	// GetFileContent 获取远程文件内容（小文件）。设置了读取上限的后端在文件超过上限时返回ErrFileTooLarge
 	GetFileContent(ctx context.Context, remotePath string) ([]byte, error)
+
+	// MkdirRemote 创建远程目录（已存在时不报错）
+	MkdirRemote(ctx context.Context, remotePath string) error
+
+	// DeleteFile 删除远程文件（不存在时不报错）
+	DeleteFile(ctx context.Context, remotePath string) error
+
+	// CopyRemote 在远程存储内复制文件或目录（目录递归复制），后端支持时在服务端完成，不经过本地
+	CopyRemote(ctx context.Context, srcPath, dstPath string) error
+
+	// MoveRemote 在远程存储内移动文件，覆盖已存在的目标。Capabilities().Move为false时返回ErrNotSupported
+	MoveRemote(ctx context.Context, srcPath, dstPath string) error
+
+	// Capabilities 返回后端支持的能力，调用方据此选择操作方式
+	Capabilities() Capabilities
+}
+
+var (
+	// ErrNotSupported 后端不支持请求的操作
+	ErrNotSupported = errors.New("operation not supported by storage backend")
+
+	// ErrFileTooLarge GetFileContent读取的文件超过了后端的读取上限
+	ErrFileTooLarge = errors.New("remote file exceeds content size limit")
+
+	// ErrPermissionDenied 远程因权限不足拒绝了操作（如凭据无写入或删除权限、存储桶策略禁止）
+	ErrPermissionDenied = errors.New("remote permission denied")
+)
+
+// Capabilities 存储后端的能力。能力缺失时调用方退回到通用的实现（例如用下载+上传代替复制），
+// 而不是报错
+type Capabilities struct {
+	Copy              bool // CopyRemote可用
+	ServerSideCopy    bool // CopyRemote在服务端完成（或由后端直接转发），不写入本地磁盘
+	Move              bool // MoveRemote可用，目标要么是旧内容要么是完整的新内容
+	AtomicUpload      bool // UploadFile完成前读取方看不到写了一半的目标文件，调用方不需要先上传到临时名称再移动
+	ResumableDownload bool // 实现ResumableDownloader，下载中断后可以续传
+
+	MaxObjectSize int64 // 单个对象的大小上限（字节），0表示没有已知的上限
+}
+
+// ResumableDownloader 支持断点续传的存储实现的可选接口
+type ResumableDownloader interface {
+	// DownloadFileFrom 从远程文件的offset处开始下载，追加到本地文件末尾。
+	// 调用方保证本地文件已有offset字节
+	DownloadFileFrom(ctx context.Context, remotePath, localPath string, offset int64) error
+}
+
+// LimitDetector 需要访问远程才能确定能力（如对象大小上限）的存储实现的可选接口。
+// Capabilities本身不访问远程，调用方在开始备份前用带取消和超时的上下文调用一次DetectLimits
+type LimitDetector interface {
+	// DetectLimits 识别远程的限制，之后的Capabilities反映识别结果
+	DetectLimits(ctx context.Context) error
+}
+
+// RangeReader 能够只读取远程文件中一段内容的存储实现的可选接口，
+// 用于按tar索引恢复单个文件时只读取条目所在的gzip成员，不下载整个压缩包
+type RangeReader interface {
+	// ReadRange 读取远程文件从offset开始的length字节，文件在此之前结束时返回错误
+	ReadRange(ctx context.Context, remotePath string, offset, length int64) ([]byte, error)
+}
+
+// 服务端哈希类型，与rclone的哈希名称相同
+const (
+	HashCRC32C = "crc32c"
+	HashMD5    = "md5"
+)
+
+// HashLister 能够不下载文件就取得服务端记录的哈希的存储实现的可选接口
+type HashLister interface {
+	// ListFilesWithHashes 与ListFiles相同，同时在FileInfo.Hashes中返回服务端记录的哈希。
+	// 后端没有记录的哈希类型（如分段上传的对象没有MD5）不出现在结果中
+	ListFilesWithHashes(ctx context.Context, remotePath string) ([]FileInfo, error)
+}
+
+// readLimited 读取r的全部内容，limit大于0且内容超过limit字节时返回ErrFileTooLarge，最多读入limit+1字节
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("%w (limit %d bytes)", ErrFileTooLarge, limit)
+	}
+	return content, nil
+}
+
+// appendToFile 将r的内容追加到本地文件末尾，文件不存在时创建
+func appendToFile(localPath string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
 }